@@ -0,0 +1,241 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package alert sends the failovers and failbacks to people, by email or to
+// a Slack or Microsoft Teams channel, as messages rendered from templates,
+// for sites without an alerting pipeline of their own. Alerts below a
+// severity are dropped, and a failover can be held back until it lasted a
+// while, so that short outages are not alerted at all.
+package alert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/severity"
+)
+
+// Events of the alerts.
+const (
+	// Failover is the traffic leaving the link it was on after a failure.
+	Failover = "failover"
+	// Failback is the traffic going back to the primary link, ending the
+	// failover alerted before.
+	Failback = "failback"
+	// Refused is a failover not made, the backup link being unfit.
+	Refused = "refused"
+	// Stopped is the monitor giving up, the backup link having failed too.
+	Stopped = "stopped"
+)
+
+// queueSize is the number of alerts waiting for delivery beyond which new
+// alerts are dropped.
+const queueSize = 64
+
+// maxBackoff caps the delay between two delivery attempts.
+const maxBackoff = time.Minute
+
+// Alert is an event worth telling someone about, the data of the templates.
+type Alert struct {
+	Site  string
+	Group string
+	// Event is one of Failover, Failback, Refused and Stopped.
+	Event    string
+	Severity severity.Level
+	Time     time.Time
+	// From and To are the links the traffic left and went to.
+	From   string
+	To     string
+	Reason string
+	// Outage is the ID of the outage the alert belongs to, if any.
+	Outage string
+	// Duration is how long the traffic has been off the primary link: since
+	// the failover for a failover held back by the minimum duration, the
+	// whole failover for a failback.
+	Duration time.Duration
+}
+
+// Sink delivers rendered alerts to one destination.
+type Sink interface {
+	// Name names the sink in the logs.
+	Name() string
+	// Send delivers one message.
+	Send(subject, body string) error
+}
+
+// Dispatcher filters the alerts, renders them and delivers them in order to
+// its sinks, each retried with an exponential backoff.
+type Dispatcher struct {
+	sinks     []Sink
+	templates *Templates
+	// minSeverity is the severity below which alerts are dropped, and
+	// minDuration the time a failover must last to be alerted.
+	minSeverity severity.Level
+	minDuration time.Duration
+	attempts    int
+	backoff     time.Duration
+	queue       chan Alert
+	// pending counts the alerts queued and not delivered yet.
+	pending sync.WaitGroup
+
+	// failover is the failover under way, held back by timer until
+	// minDuration elapsed, and sent whether it was alerted, so that its
+	// failback is alerted too.
+	mu       sync.Mutex
+	failover *Alert
+	timer    *time.Timer
+	sent     bool
+}
+
+// New returns a dispatcher delivering the alerts of at least minSeverity to
+// sinks, failovers once they lasted minDuration, making up to attempts
+// delivery attempts per alert and sink, the first retry after backoff.
+func New(sinks []Sink, templates *Templates, minSeverity severity.Level, minDuration time.Duration, attempts int, backoff time.Duration) (*Dispatcher, error) {
+	if attempts < 1 {
+		return nil, fmt.Errorf("invalid attempt count %d", attempts)
+	}
+	d := &Dispatcher{
+		sinks:       sinks,
+		templates:   templates,
+		minSeverity: minSeverity,
+		minDuration: minDuration,
+		attempts:    attempts,
+		backoff:     backoff,
+		queue:       make(chan Alert, queueSize),
+	}
+	go d.deliverLoop()
+	return d, nil
+}
+
+// Notify filters a and queues it for delivery. A failover is held back
+// until it lasted the minimum duration, and dropped with its failback if the
+// failback comes first. A failback is sent if its failover was, whatever its
+// severity.
+func (d *Dispatcher) Notify(a Alert) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch a.Event {
+	case Failover:
+		d.cancel()
+		if a.Severity < d.minSeverity {
+			return
+		}
+		d.failover, d.sent = &a, false
+		if d.minDuration <= 0 {
+			d.send(a)
+			return
+		}
+		d.timer = time.AfterFunc(d.minDuration, d.holdElapsed)
+	case Failback:
+		held, sent := d.failover, d.sent
+		d.cancel()
+		if held == nil {
+			if a.Severity >= d.minSeverity {
+				d.send(a)
+			}
+			return
+		}
+		a.Duration = a.Time.Sub(held.Time)
+		if a.Outage == "" {
+			a.Outage = held.Outage
+		}
+		if !sent {
+			log.Info().Msgf("Failover to %s ended after %s, within %s, not alerted", held.To, a.Duration.Round(time.Second), d.minDuration)
+			return
+		}
+		d.send(a)
+	default:
+		if a.Severity >= d.minSeverity {
+			d.send(a)
+		}
+	}
+}
+
+// holdElapsed sends the failover held back once it lasted the minimum
+// duration.
+func (d *Dispatcher) holdElapsed() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failover == nil || d.sent {
+		return
+	}
+	a := *d.failover
+	a.Duration = time.Since(a.Time)
+	d.send(a)
+}
+
+// cancel forgets the failover under way. The caller holds the lock.
+func (d *Dispatcher) cancel() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.failover, d.sent = nil, false
+}
+
+// send queues a for delivery, without blocking: it is dropped if the queue
+// is full. The caller holds the lock.
+func (d *Dispatcher) send(a Alert) {
+	if d.failover != nil && a.Event == Failover {
+		d.sent = true
+	}
+	d.pending.Add(1)
+	select {
+	case d.queue <- a:
+	default:
+		d.pending.Done()
+		log.Error().Msgf("Alert queue full, %s alert dropped", a.Event)
+	}
+}
+
+// deliverLoop renders the queued alerts and delivers them in order.
+func (d *Dispatcher) deliverLoop() {
+	for a := range d.queue {
+		subject, body, err := d.templates.Render(a)
+		if err != nil {
+			log.Error().Msgf("Error rendering the %s alert: %s", a.Event, err)
+		} else {
+			for _, sink := range d.sinks {
+				d.deliver(sink, a, subject, body)
+			}
+		}
+		d.pending.Done()
+	}
+}
+
+// Flush waits up to timeout for the queued alerts to be delivered, before
+// exiting, and reports whether they were.
+func (d *Dispatcher) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// deliver sends one alert to sink, retrying a failed delivery.
+func (d *Dispatcher) deliver(sink Sink, a Alert, subject string, body string) {
+	delay := d.backoff
+	for attempt := 1; ; attempt++ {
+		err := sink.Send(subject, body)
+		if err == nil {
+			log.Debug().Msgf("%s alert sent to %s", a.Event, sink.Name())
+			return
+		}
+		if attempt >= d.attempts {
+			log.Error().Msgf("%s alert to %s dropped after %d attempts: %s", a.Event, sink.Name(), attempt, err)
+			return
+		}
+		log.Warn().Msgf("Alert delivery to %s failed, attempt %d out of %d, retrying in %s: %s", sink.Name(), attempt, d.attempts, delay, err)
+		time.Sleep(delay)
+		delay = min(2*delay, maxBackoff)
+	}
+}
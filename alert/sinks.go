@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email sends the alerts by email through an SMTP relay, upgrading the
+// connection with STARTTLS when the relay offers it.
+type Email struct {
+	// Server is the relay, as host:port.
+	Server string
+	From   string
+	To     []string
+	// Username and Password authenticate to the relay if Username is set,
+	// which requires TLS unless the relay is on the loopback.
+	Username string
+	Password string
+}
+
+// Name implements Sink.
+func (e *Email) Name() string {
+	return "email"
+}
+
+// Send implements Sink.
+func (e *Email) Send(subject string, body string) error {
+	host, _, err := net.SplitHostPort(e.Server)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP server %q: %s", e.Server, err)
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(e.Server, auth, e.From, e.To, msg.Bytes())
+}
+
+// Slack posts the alerts to a Slack incoming webhook.
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Sink.
+func (s *Slack) Name() string {
+	return "Slack"
+}
+
+// Send implements Sink.
+func (s *Slack) Send(subject string, body string) error {
+	return postJSON(s.Client, s.URL, map[string]string{"text": "*" + subject + "*\n" + body})
+}
+
+// Teams posts the alerts to a Microsoft Teams incoming webhook, as a
+// message card.
+type Teams struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Sink.
+func (t *Teams) Name() string {
+	return "Teams"
+}
+
+// Send implements Sink.
+func (t *Teams) Send(subject string, body string) error {
+	card := map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  subject,
+		"title":    subject,
+		// Teams renders the text as Markdown, where a line break needs two
+		// trailing spaces.
+		"text": strings.ReplaceAll(body, "\n", "  \n"),
+	}
+	return postJSON(t.Client, t.URL, card)
+}
+
+// postJSON POSTs v as JSON to url.
+func postJSON(client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default templates of the subject and body of the messages.
+const (
+	DefaultSubject = `[{{.Severity}}] {{.Site}}{{with .Group}}/{{.}}{{end}}: {{if eq .Event "failover"}}failed over to {{.To}}{{else if eq .Event "failback"}}back on {{.To}}{{else if eq .Event "refused"}}not failing over to {{.To}}{{else}}monitoring stopped{{end}}`
+	DefaultBody    = `{{if eq .Event "failover"}}The traffic of {{.Site}} left {{.From}} for {{.To}}{{if .Duration}} {{duration .Duration}} ago{{end}}: {{.Reason}}.
+{{else if eq .Event "failback"}}The traffic of {{.Site}} is back on {{.To}} after {{duration .Duration}} on {{.From}}: {{.Reason}}.
+{{else if eq .Event "refused"}}{{.From}} of {{.Site}} failed but the traffic was not moved to {{.To}}: {{.Reason}}.
+{{else}}{{.Site}} stopped monitoring: {{.Reason}}. The traffic stays on {{.From}}.
+{{end}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}{{with .Outage}}
+Outage: {{.}}{{end}}
+`
+)
+
+// funcs are the functions of the templates besides the built-in ones.
+var funcs = template.FuncMap{
+	"duration": func(d time.Duration) string { return d.Round(time.Second).String() },
+	"upper":    strings.ToUpper,
+}
+
+// Templates render the subject and body of the messages.
+type Templates struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// ParseTemplates parses the text/template of the subject and of the body
+// of the messages, the defaults if empty. Both see the fields of Alert, and
+// the functions duration, rounding a duration to the second, and upper.
+func ParseTemplates(subject string, body string) (*Templates, error) {
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	if body == "" {
+		body = DefaultBody
+	}
+	t := &Templates{}
+	var err error
+	if t.subject, err = template.New("subject").Funcs(funcs).Parse(subject); err != nil {
+		return nil, fmt.Errorf("subject template: %s", err)
+	}
+	if t.body, err = template.New("body").Funcs(funcs).Parse(body); err != nil {
+		return nil, fmt.Errorf("body template: %s", err)
+	}
+	return t, nil
+}
+
+// Render returns the subject, on a single line, and the body of a.
+func (t *Templates) Render(a Alert) (string, string, error) {
+	var subject, body strings.Builder
+	if err := t.subject.Execute(&subject, a); err != nil {
+		return "", "", err
+	}
+	if err := t.body.Execute(&body, a); err != nil {
+		return "", "", err
+	}
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
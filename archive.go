@@ -0,0 +1,225 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/metrics"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/spf13/cobra"
+)
+
+// windowStats are the statistics of the probes of one endpoint over one link
+// in a window of the history.
+type windowStats struct {
+	Interface string  `json:"interface"`
+	Endpoint  string  `json:"endpoint"`
+	Samples   int     `json:"samples"`
+	Failures  int     `json:"failures"`
+	Loss      float64 `json:"loss"`
+	// The RTTs are in milliseconds.
+	MinRTT    float64 `json:"min_rtt_ms"`
+	MedianRTT float64 `json:"median_rtt_ms"`
+	P95RTT    float64 `json:"p95_rtt_ms"`
+	P99RTT    float64 `json:"p99_rtt_ms"`
+	MaxRTT    float64 `json:"max_rtt_ms"`
+	// Histogram counts the RTTs up to each bound of metrics.RTTBuckets,
+	// not cumulated, the last count being the RTTs above them.
+	Histogram []int `json:"histogram"`
+}
+
+// windowReport is the content of a window of the history.
+type windowReport struct {
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Events    []history.Event `json:"events"`
+	Endpoints []windowStats   `json:"endpoints"`
+}
+
+// summarize returns the statistics of the probes in samples, by link and
+// endpoint.
+func summarize(samples []history.Sample) []windowStats {
+	type key struct{ link, endpoint string }
+	rtts := map[key][]time.Duration{}
+	stats := map[key]*windowStats{}
+	var keys []key
+	for _, s := range samples {
+		// Bufferbloat test results are not probes.
+		if s.Grade != "" {
+			continue
+		}
+		k := key{reliability.LinkKey(s.Interface), s.Endpoint}
+		st, ok := stats[k]
+		if !ok {
+			st = &windowStats{Interface: k.link, Endpoint: k.endpoint, Histogram: make([]int, len(metrics.RTTBuckets)+1)}
+			stats[k] = st
+			keys = append(keys, k)
+		}
+		st.Samples++
+		if !s.Success {
+			st.Failures++
+			continue
+		}
+		rtts[k] = append(rtts[k], s.RTT)
+		bucket, _ := slices.BinarySearch(metrics.RTTBuckets, s.RTT.Seconds())
+		st.Histogram[bucket]++
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		if c := strings.Compare(a.link, b.link); c != 0 {
+			return c
+		}
+		return strings.Compare(a.endpoint, b.endpoint)
+	})
+	out := make([]windowStats, 0, len(keys))
+	for _, k := range keys {
+		st := stats[k]
+		st.Loss = float64(st.Failures) / float64(st.Samples)
+		if sorted := rtts[k]; len(sorted) > 0 {
+			slices.Sort(sorted)
+			st.MinRTT, st.MaxRTT = eventlog.Millis(sorted[0]), eventlog.Millis(sorted[len(sorted)-1])
+			st.MedianRTT = eventlog.Millis(nearestRank(sorted, 50))
+			st.P95RTT = eventlog.Millis(nearestRank(sorted, 95))
+			st.P99RTT = eventlog.Millis(nearestRank(sorted, 99))
+		}
+		out = append(out, *st)
+	}
+	return out
+}
+
+// nearestRank returns the nearest-rank percentile p of sorted.
+func nearestRank(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// parseWindowTime parses a bound of a window, RFC 3339 or a Unix time.
+func parseWindowTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// printWindow prints r as tables.
+func printWindow(r windowReport) {
+	fmt.Printf("From %s to %s\n\n", timefmt.Format(r.From), timefmt.Format(r.To))
+	if len(r.Events) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tEVENT\tFROM\tTO\tREASON")
+		for _, e := range r.Events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", timefmt.Format(e.Time), e.Kind, e.From, e.To, e.Reason)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+	if len(r.Endpoints) == 0 {
+		fmt.Println("No samples in the window")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tENDPOINT\tSAMPLES\tLOSS\tMIN\tMEDIAN\tP95\tP99\tMAX")
+	for _, st := range r.Endpoints {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f%%\t%.3fms\t%.3fms\t%.3fms\t%.3fms\t%.3fms\n", st.Interface, st.Endpoint, st.Samples, st.Loss*100,
+			st.MinRTT, st.MedianRTT, st.P95RTT, st.P99RTT, st.MaxRTT)
+	}
+	w.Flush()
+	for _, st := range r.Endpoints {
+		successes := st.Samples - st.Failures
+		if successes == 0 {
+			continue
+		}
+		fmt.Printf("\nRTT of %s over %s\n", st.Endpoint, st.Interface)
+		// Only the buckets from the lowest to the highest RTT.
+		first, last := len(st.Histogram), 0
+		for i, count := range st.Histogram {
+			if count > 0 {
+				first, last = min(first, i), i
+			}
+		}
+		for i := first; i <= last; i++ {
+			count := st.Histogram[i]
+			label := "> " + bucketLabel(metrics.RTTBuckets[len(metrics.RTTBuckets)-1])
+			if i < len(metrics.RTTBuckets) {
+				label = "<= " + bucketLabel(metrics.RTTBuckets[i])
+			}
+			fmt.Printf("  %-9s %6d %s\n", label, count, strings.Repeat("#", (count*40+successes-1)/successes))
+		}
+	}
+}
+
+// bucketLabel formats a bound of metrics.RTTBuckets, in seconds.
+func bucketLabel(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).String()
+}
+
+// init registers the history command.
+func init() {
+	historyCmd.Flags().String("dir", reliability.DefaultHistoryDir, "Directory of the history archive, as --history-dir of the instance")
+	historyCmd.Flags().Duration("since", 24*time.Hour, "Length of the window, ending now or at --to")
+	historyCmd.Flags().String("from", "", "Start of the window, RFC 3339 or Unix time, instead of --since")
+	historyCmd.Flags().String("to", "", "End of the window, RFC 3339 or Unix time (default: now)")
+	historyCmd.Flags().String("interface", "", "Only the probes over this interface, or primary")
+	historyCmd.Flags().String("endpoint", "", "Only the probes toward this endpoint")
+	historyCmd.Flags().Bool("json", false, "Print the window as JSON")
+	rootCmd.AddCommand(historyCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Query a past window of the history archive",
+	Long: "Summarize the probes and list the state transitions and switches of a past window from the history archive kept with --history-dir, " +
+		"e.g. after an incident: loss, RTT percentiles and RTT histogram per link and endpoint. A running instance's last records show once flushed.",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		since, _ := cmd.Flags().GetDuration("since")
+		r := windowReport{To: time.Now()}
+		if to, _ := cmd.Flags().GetString("to"); to != "" {
+			var err error
+			if r.To, err = parseWindowTime(to); err != nil {
+				log.Error().Msgf("Invalid --to %q: expected RFC 3339, e.g. 2024-05-01T10:00:00Z, or a Unix time", to)
+				os.Exit(1)
+			}
+		}
+		r.From = r.To.Add(-since)
+		if from, _ := cmd.Flags().GetString("from"); from != "" {
+			var err error
+			if r.From, err = parseWindowTime(from); err != nil {
+				log.Error().Msgf("Invalid --from %q: expected RFC 3339, e.g. 2024-05-01T10:00:00Z, or a Unix time", from)
+				os.Exit(1)
+			}
+		}
+		if !r.From.Before(r.To) {
+			log.Error().Msgf("Empty window from %s to %s", timefmt.Format(r.From), timefmt.Format(r.To))
+			os.Exit(1)
+		}
+		samples, events, err := history.Query(dir, r.From, r.To)
+		if err != nil {
+			log.Error().Msgf("Error reading the history archive: %s", err)
+			os.Exit(1)
+		}
+		ifname, _ := cmd.Flags().GetString("interface")
+		endpointName, _ := cmd.Flags().GetString("endpoint")
+		samples = slices.DeleteFunc(samples, func(s history.Sample) bool {
+			return (ifname != "" && reliability.LinkKey(s.Interface) != ifname) || (endpointName != "" && s.Endpoint != endpointName)
+		})
+		r.Events = events
+		r.Endpoints = summarize(samples)
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			printJSON(r)
+			return
+		}
+		printWindow(r)
+	},
+}
@@ -0,0 +1,280 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package availability accumulates the availability of the links over fixed
+// periods, e.g. each hour and each day: the uptime, RTT and loss of every
+// link and the windows spent on a backup link, reported as evidence in SLA
+// disputes.
+package availability
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Window is a stay on a backup link.
+type Window struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Start time.Time `json:"start"`
+	// End is nil while the window is open.
+	End *time.Time `json:"end,omitempty"`
+	// Seconds is the whole length of the window, up to the report if open.
+	Seconds float64 `json:"seconds"`
+}
+
+// Link is the availability of one link over a period.
+type Link struct {
+	Interface string `json:"interface"`
+	// Uptime is the percentage of the period, from the first probe of the
+	// link on, during which the last probe of one of its endpoints
+	// succeeded.
+	Uptime   float64 `json:"uptime_percent"`
+	Probes   int     `json:"probes"`
+	Failures int     `json:"failures"`
+	Loss     float64 `json:"loss_percent"`
+	// The RTTs of the successful probes, in milliseconds.
+	RTTMean float64 `json:"rtt_mean_ms"`
+	RTTP50  float64 `json:"rtt_p50_ms"`
+	RTTP95  float64 `json:"rtt_p95_ms"`
+	RTTP99  float64 `json:"rtt_p99_ms"`
+}
+
+// Report is the availability over a period.
+type Report struct {
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Partial is set for the period under way, End being the time of the
+	// report.
+	Partial bool   `json:"partial,omitempty"`
+	Links   []Link `json:"links"`
+	// Failovers counts the windows opened in the period, and
+	// FailoverSeconds the time spent on a backup link in it.
+	Failovers       int      `json:"failovers"`
+	FailoverSeconds float64  `json:"failover_seconds"`
+	Windows         []Window `json:"windows"`
+}
+
+// String summarizes the report, e.g. "eth0 99.95% up, RTT 12.3ms p95
+// 20.1ms, loss 0.1%; 1 failover, 42s on backup".
+func (r Report) String() string {
+	var parts []string
+	for _, l := range r.Links {
+		parts = append(parts, fmt.Sprintf("%s %.2f%% up, RTT %.1fms p95 %.1fms, loss %.1f%%", l.Interface, l.Uptime, l.RTTMean, l.RTTP95, l.Loss))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no probes")
+	}
+	noun := "failovers"
+	if r.Failovers == 1 {
+		noun = "failover"
+	}
+	return fmt.Sprintf("%s; %d %s, %s on backup", strings.Join(parts, "; "), r.Failovers, noun, (time.Duration(r.FailoverSeconds) * time.Second).String())
+}
+
+// link accumulates the probes of one link.
+type link struct {
+	probes   int
+	failures int
+	rtts     []time.Duration
+	// last is the last result of each endpoint, the link being up while one
+	// of them succeeded, since at.
+	last     map[string]bool
+	up       bool
+	at       time.Time
+	upTime   time.Duration
+	downTime time.Duration
+}
+
+// account counts the time from the last change of state to now.
+func (l *link) account(now time.Time) (up, down time.Duration) {
+	up, down = l.upTime, l.downTime
+	if elapsed := now.Sub(l.at); elapsed > 0 {
+		if l.up {
+			up += elapsed
+		} else {
+			down += elapsed
+		}
+	}
+	return up, down
+}
+
+// Tracker accumulates the availability over consecutive periods of a fixed
+// length, aligned on multiples of it since the zero time, i.e. on the hour
+// and on UTC midnight.
+type Tracker struct {
+	mu     sync.Mutex
+	period time.Duration
+	start  time.Time
+	links  map[string]*link
+	// windows are the windows closed in the period, and open the one under
+	// way, if any.
+	windows []Window
+	opened  int
+	open    *Window
+}
+
+// NewTracker returns a tracker of period starting with the period under way
+// at now.
+func NewTracker(period time.Duration, now time.Time) *Tracker {
+	return &Tracker{period: period, start: now.UTC().Truncate(period), links: map[string]*link{}}
+}
+
+// Period returns the length of the periods.
+func (t *Tracker) Period() time.Duration {
+	return t.period
+}
+
+// End returns the end of the period under way.
+func (t *Tracker) End() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.start.Add(t.period)
+}
+
+// Probe records a probe of endpoint over ifname at at.
+func (t *Tracker) Probe(ifname, endpoint string, success bool, rtt time.Duration, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.links[ifname]
+	if !ok {
+		l = &link{last: map[string]bool{}, at: at}
+		t.links[ifname] = l
+	}
+	l.upTime, l.downTime = l.account(at)
+	l.at = at
+	l.probes++
+	if success {
+		l.rtts = append(l.rtts, rtt)
+	} else {
+		l.failures++
+	}
+	l.last[endpoint] = success
+	l.up = false
+	for _, ok := range l.last {
+		l.up = l.up || ok
+	}
+}
+
+// Switch records that the traffic moved from one link to another at at,
+// backup telling whether to is a backup link: moving to one opens a window,
+// moving back to the primary link closes it.
+func (t *Tracker) Switch(from, to string, backup bool, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case backup && t.open == nil:
+		t.open = &Window{From: from, To: to, Start: at}
+		t.opened++
+	case backup:
+		t.open.To = to
+	case t.open != nil:
+		t.open.End = &at
+		t.open.Seconds = at.Sub(t.open.Start).Seconds()
+		t.windows = append(t.windows, *t.open)
+		t.open = nil
+	}
+}
+
+// Report returns the report of the period under way, up to now.
+func (t *Tracker) Report(now time.Time) Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.report(now)
+	r.Partial = true
+	return r
+}
+
+// Rotate returns the report of the period under way if it ended by now, and
+// starts the next one, carrying over the state of the links and the open
+// window. It returns false if the period did not end yet.
+func (t *Tracker) Rotate(now time.Time) (Report, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	end := t.start.Add(t.period)
+	if now.Before(end) {
+		return Report{}, false
+	}
+	r := t.report(end)
+	t.start = now.UTC().Truncate(t.period)
+	for ifname, l := range t.links {
+		t.links[ifname] = &link{last: l.last, up: l.up, at: t.start}
+	}
+	t.windows, t.opened = nil, 0
+	return r, true
+}
+
+// report builds the report of the period under way up to end.
+func (t *Tracker) report(end time.Time) Report {
+	r := Report{Period: t.period.String(), Start: t.start, End: end, Links: []Link{}, Failovers: t.opened, Windows: []Window{}}
+	names := make([]string, 0, len(t.links))
+	for ifname := range t.links {
+		names = append(names, ifname)
+	}
+	sort.Strings(names)
+	for _, ifname := range names {
+		l := t.links[ifname]
+		up, down := l.account(end)
+		stats := Link{Interface: ifname, Probes: l.probes, Failures: l.failures}
+		switch {
+		case up+down > 0:
+			stats.Uptime = 100 * up.Seconds() / (up + down).Seconds()
+		case l.up:
+			stats.Uptime = 100
+		}
+		if l.probes > 0 {
+			stats.Loss = 100 * float64(l.failures) / float64(l.probes)
+		}
+		if len(l.rtts) > 0 {
+			sorted := append([]time.Duration{}, l.rtts...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			var sum time.Duration
+			for _, rtt := range sorted {
+				sum += rtt
+			}
+			stats.RTTMean = millis(sum / time.Duration(len(sorted)))
+			stats.RTTP50 = millis(percentile(sorted, 50))
+			stats.RTTP95 = millis(percentile(sorted, 95))
+			stats.RTTP99 = millis(percentile(sorted, 99))
+		}
+		r.Links = append(r.Links, stats)
+	}
+	windows := append([]Window{}, t.windows...)
+	if t.open != nil {
+		w := *t.open
+		w.Seconds = end.Sub(w.Start).Seconds()
+		windows = append(windows, w)
+	}
+	for _, w := range windows {
+		from, to := w.Start, end
+		if w.End != nil {
+			to = *w.End
+		}
+		if from.Before(t.start) {
+			from = t.start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			r.FailoverSeconds += to.Sub(from).Seconds()
+		}
+	}
+	r.Windows = windows
+	return r
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// millis returns d in milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package bind binds sockets to a network interface so that traffic leaves
+// through it regardless of the routing table.
+package bind
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Address returns the IPv4 or IPv6 address of ifname sockets are bound to
+// when they cannot be bound to the interface itself, a global address if it
+// has one, and the interface.
+func Address(ifname string, ipv6 bool) (net.IP, *net.Interface, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	var linkLocal net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if !ipnet.IP.IsLinkLocalUnicast() {
+			return ipnet.IP, iface, nil
+		}
+		if linkLocal == nil {
+			linkLocal = ipnet.IP
+		}
+	}
+	if linkLocal == nil {
+		family := "IPv4"
+		if ipv6 {
+			family = "IPv6"
+		}
+		return nil, nil, fmt.Errorf("%s has no %s address", ifname, family)
+	}
+	return linkLocal, iface, nil
+}
+
+// bindAddress binds the unbound socket fd to the IPv4 or IPv6 address of
+// ifname.
+func bindAddress(fd int, ifname string, ipv6 bool) error {
+	ip, iface, err := Address(ifname, ipv6)
+	if err != nil {
+		return err
+	}
+	if !ipv6 {
+		sa := &syscall.SockaddrInet4{}
+		copy(sa.Addr[:], ip.To4())
+		return bindSocket(fd, sa)
+	}
+	sa := &syscall.SockaddrInet6{}
+	copy(sa.Addr[:], ip.To16())
+	if ip.IsLinkLocalUnicast() {
+		sa.ZoneId = uint32(iface.Index)
+	}
+	return bindSocket(fd, sa)
+}
+
+// family6 reports whether a control function network, e.g. "tcp6", is an
+// IPv6 one.
+func family6(network string) bool {
+	return len(network) > 0 && network[len(network)-1] == '6'
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package bind
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// Control returns a dialer control function binding the socket to ifname
+// with SO_BINDTODEVICE, or to the address of ifname when the kernel refuses
+// it without CAP_NET_RAW. The fallback needs an unbound socket, as dialers
+// without a local address have.
+func Control(ifname string) func(network, address string, c syscall.RawConn) error {
+	if ifname == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			_, err = Socket(int(fd), ifname, family6(network))
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// Socket binds the unbound socket fd to ifname with SO_BINDTODEVICE or,
+// when the kernel refuses it without CAP_NET_RAW, to the IPv4 or IPv6
+// address of ifname. It reports whether the socket was bound to an address.
+func Socket(fd int, ifname string, ipv6 bool) (bool, error) {
+	err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+	if !errors.Is(err, syscall.EPERM) {
+		return false, err
+	}
+	if err := bindAddress(fd, ifname, ipv6); err != nil {
+		return false, fmt.Errorf("binding to %s needs CAP_NET_RAW, and binding to its address failed: %w", ifname, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package bind
+
+import (
+	"syscall"
+)
+
+// Control returns a dialer control function binding the socket to the
+// address of ifname, since SO_BINDTODEVICE is Linux-only. Whether traffic
+// then leaves through ifname depends on the routing of the system.
+func Control(ifname string) func(network, address string, c syscall.RawConn) error {
+	if ifname == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			_, err = Socket(int(fd), ifname, family6(network))
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// Socket binds the unbound socket fd to the IPv4 or IPv6 address of ifname,
+// since SO_BINDTODEVICE is Linux-only, and reports that it did.
+func Socket(fd int, ifname string, ipv6 bool) (bool, error) {
+	if err := bindAddress(fd, ifname, ipv6); err != nil {
+		return false, err
+	}
+	return true, nil
+}
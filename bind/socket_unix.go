@@ -0,0 +1,15 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !windows
+
+package bind
+
+import (
+	"syscall"
+)
+
+// bindSocket binds the socket fd to sa.
+func bindSocket(fd int, sa syscall.Sockaddr) error {
+	return syscall.Bind(fd, sa)
+}
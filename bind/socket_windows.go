@@ -0,0 +1,13 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package bind
+
+import (
+	"syscall"
+)
+
+// bindSocket binds the socket fd, a Windows socket handle, to sa.
+func bindSocket(fd int, sa syscall.Sockaddr) error {
+	return syscall.Bind(syscall.Handle(fd), sa)
+}
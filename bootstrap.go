@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapOptions are the options of the bootstrap command, bound to its
+// flags.
+var bootstrapOptions reliability.BootstrapOptions
+
+// init registers the bootstrap command.
+func init() {
+	reliability.AddBootstrapFlags(bootstrapCmd.Flags(), &bootstrapOptions)
+	bootstrapCmd.MarkFlagRequired("config-url")
+	rootCmd.AddCommand(bootstrapCmd)
+}
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Bring up any uplink on a fresh device, fetch its configuration and start monitoring",
+	Long: "Provisioning mode for factory-fresh devices: cycle through the given links and candidate WiFi networks until " +
+		"connectivity is achieved over one of them, fetch the device configuration through it, save it, and start " +
+		"monitoring with it.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := reliability.Bootstrap(bootstrapOptions); err != nil {
+			log.Error().Msgf("Cannot bootstrap: %s", err)
+			os.Exit(1)
+		}
+	},
+}
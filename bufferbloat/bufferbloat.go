@@ -0,0 +1,164 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package bufferbloat measures latency under load. An idle link can show a
+// fine RTT and still be unusable as soon as traffic flows, because of
+// oversized queues in the modem or the carrier network. The test compares
+// the idle RTT with the RTT measured during a short saturating download and
+// grades the increase.
+package bufferbloat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+)
+
+// Grades from best to worst.
+var Grades = []string{"A+", "A", "B", "C", "D", "F"}
+
+// thresholds are the upper bounds of the latency increase for each grade.
+var thresholds = []time.Duration{5 * time.Millisecond, 30 * time.Millisecond, 60 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+
+// Pinger measures one RTT. It returns false if the probe failed.
+type Pinger func() (time.Duration, bool)
+
+// Options configures a test.
+type Options struct {
+	// URL is downloaded to load the link. It should be large enough to
+	// last for the whole test.
+	URL string
+	// Interface the download is bound to, or empty to follow the routing
+	// table.
+	Interface string
+	// Duration of the loaded phase.
+	Duration time.Duration
+	// Ping measures the RTT over the tested link.
+	Ping Pinger
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	Idle   time.Duration
+	Loaded time.Duration
+	Grade  string
+}
+
+// Increase returns the latency added by load.
+func (r Result) Increase() time.Duration {
+	if r.Loaded < r.Idle {
+		return 0
+	}
+	return r.Loaded - r.Idle
+}
+
+// Measure runs a test.
+func Measure(opts Options) (Result, error) {
+	idle := sample(opts.Ping, 5, 200*time.Millisecond, nil)
+	if idle < 0 {
+		return Result{}, fmt.Errorf("no idle RTT sample")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Duration)
+	defer cancel()
+	downloaded := make(chan error, 1)
+	go func() { downloaded <- download(ctx, opts.URL, opts.Interface) }()
+	// Let the transfer ramp up before sampling.
+	time.Sleep(opts.Duration / 5)
+	loaded := sample(opts.Ping, 0, 200*time.Millisecond, ctx.Done())
+	cancel()
+	if err := <-downloaded; err != nil {
+		return Result{}, fmt.Errorf("load download failed: %w", err)
+	}
+	if loaded < 0 {
+		return Result{}, fmt.Errorf("no loaded RTT sample")
+	}
+	r := Result{Idle: idle, Loaded: loaded}
+	r.Grade = grade(r.Increase())
+	return r, nil
+}
+
+// Worse reports whether grade a is worse than grade b.
+func Worse(a string, b string) bool {
+	return rank(a) > rank(b)
+}
+
+// Valid reports whether grade is a known grade.
+func Valid(grade string) bool {
+	return rank(grade) < len(Grades)
+}
+
+func rank(grade string) int {
+	for i, g := range Grades {
+		if g == grade {
+			return i
+		}
+	}
+	return len(Grades)
+}
+
+func grade(increase time.Duration) string {
+	for i, t := range thresholds {
+		if increase < t {
+			return Grades[i]
+		}
+	}
+	return Grades[len(Grades)-1]
+}
+
+// sample pings count times, or until done is closed if count is 0, and
+// returns the median RTT, or -1 without any successful ping.
+func sample(ping Pinger, count int, spacing time.Duration, done <-chan struct{}) time.Duration {
+	var rtts []time.Duration
+	for i := 0; count == 0 || i < count; i++ {
+		if rtt, ok := ping(); ok {
+			rtts = append(rtts, rtt)
+		}
+		select {
+		case <-done:
+			return median(rtts)
+		case <-time.After(spacing):
+		}
+	}
+	return median(rtts)
+}
+
+func median(rtts []time.Duration) time.Duration {
+	if len(rtts) == 0 {
+		return -1
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	return rtts[len(rtts)/2]
+}
+
+// download fetches url until ctx is done. Reaching the deadline is not an
+// error.
+func download(ctx context.Context, url string, ifname string) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: bind.Control(ifname)}
+	client := &http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
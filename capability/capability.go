@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package capability reads the Linux capabilities of the process.
+package capability
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Cap is a Linux capability number, see capabilities(7).
+type Cap uint
+
+// Capabilities the monitor needs.
+const (
+	NetAdmin Cap = 12
+	NetRaw   Cap = 13
+)
+
+// names are the names of the capabilities, as in capabilities(7).
+var names = map[Cap]string{NetAdmin: "CAP_NET_ADMIN", NetRaw: "CAP_NET_RAW"}
+
+// String returns the name of c.
+func (c Cap) String() string {
+	if name, ok := names[c]; ok {
+		return name
+	}
+	return "CAP_" + strconv.Itoa(int(c))
+}
+
+// statusFile holds the capability sets of the process.
+const statusFile = "/proc/self/status"
+
+// Set is a set of capabilities.
+type Set uint64
+
+// Has reports whether c is in s.
+func (s Set) Has(c Cap) bool {
+	return s&(1<<c) != 0
+}
+
+// Missing returns the capabilities of want not in s.
+func (s Set) Missing(want ...Cap) []Cap {
+	var missing []Cap
+	for _, c := range want {
+		if !s.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// Effective returns the effective capability set of the process.
+func Effective() (Set, error) {
+	f, err := os.Open(statusFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		set, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CapEff in %s: %w", statusFile, err)
+		}
+		return Set(set), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in %s", statusFile)
+}
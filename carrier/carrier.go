@@ -0,0 +1,26 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package carrier follows the link state of the network interfaces over
+// rtnetlink, so that a link losing its carrier is noticed at once instead of
+// after failed probes, and reads the speed and duplex wired links negotiated.
+// Watches operate in the network namespace of the calling thread.
+package carrier
+
+// Event is a change of the link state of an interface.
+type Event struct {
+	Interface string
+	// Up reports whether the interface is administratively up with a
+	// carrier.
+	Up bool
+	// Reason tells why a link is down: no carrier, administratively down
+	// or removed.
+	Reason string
+}
+
+// Reasons of a link being down.
+const (
+	ReasonNoCarrier = "no carrier"
+	ReasonDown      = "administratively down"
+	ReasonRemoved   = "removed"
+)
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package carrier
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Watch reports the link state changes of the interfaces until done is
+// closed, or until the rtnetlink socket fails, closing the channel. The
+// state at subscription time is the reference and not reported.
+func Watch(done <-chan struct{}) (<-chan Event, error) {
+	updates := make(chan netlink.LinkUpdate, 64)
+	if err := netlink.LinkSubscribeWithOptions(updates, done, netlink.LinkSubscribeOptions{ListExisting: true}); err != nil {
+		return nil, err
+	}
+	events := make(chan Event, 16)
+	go func() {
+		defer close(events)
+		states := map[string]Event{}
+		for update := range updates {
+			attrs := update.Attrs()
+			e := Event{Interface: attrs.Name, Up: true}
+			switch {
+			case update.Header.Type == unix.RTM_DELLINK:
+				e.Up, e.Reason = false, ReasonRemoved
+			case attrs.Flags&net.FlagUp == 0:
+				e.Up, e.Reason = false, ReasonDown
+			case attrs.RawFlags&unix.IFF_LOWER_UP == 0:
+				e.Up, e.Reason = false, ReasonNoCarrier
+			}
+			previous, known := states[e.Interface]
+			if e.Reason == ReasonRemoved {
+				delete(states, e.Interface)
+			} else {
+				states[e.Interface] = e
+			}
+			if !known || previous.Up == e.Up {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
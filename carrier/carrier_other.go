@@ -0,0 +1,13 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package carrier
+
+import "errors"
+
+// Watch fails, rtnetlink is Linux-only.
+func Watch(done <-chan struct{}) (<-chan Event, error) {
+	return nil, errors.New("link state notifications are only supported on Linux")
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package carrier
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysfsNet holds the attributes of the network interfaces.
+const sysfsNet = "/sys/class/net"
+
+// Duplex modes a link negotiated.
+const (
+	DuplexFull    = "full"
+	DuplexHalf    = "half"
+	DuplexUnknown = "unknown"
+)
+
+// Negotiation is the carrier of a wired interface and the speed and duplex
+// its link negotiated with the other end.
+type Negotiation struct {
+	Carrier bool
+	// Speed is in Mbit/s, 0 if unknown.
+	Speed int
+	// Duplex is DuplexFull, DuplexHalf or DuplexUnknown.
+	Duplex string
+}
+
+// ReadNegotiation reads the negotiation of ifname from sysfs, in the network
+// namespace the process was started in. An interface administratively down
+// has no carrier.
+func ReadNegotiation(ifname string) (Negotiation, error) {
+	dir := filepath.Join(sysfsNet, ifname)
+	if _, err := os.Stat(dir); err != nil {
+		return Negotiation{}, fmt.Errorf("no interface %s", ifname)
+	}
+	n := Negotiation{Duplex: DuplexUnknown}
+	// The attributes cannot be read while the interface is down.
+	if value, err := readAttribute(dir, "carrier"); err != nil || value != "1" {
+		return n, nil
+	}
+	n.Carrier = true
+	if value, err := readAttribute(dir, "speed"); err == nil {
+		if speed, err := strconv.Atoi(value); err == nil && speed > 0 {
+			n.Speed = speed
+		}
+	}
+	if value, err := readAttribute(dir, "duplex"); err == nil && (value == DuplexFull || value == DuplexHalf) {
+		n.Duplex = value
+	}
+	return n, nil
+}
+
+// readAttribute reads the attribute name of the interface in dir.
+func readAttribute(dir, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	return strings.TrimSpace(string(data)), err
+}
+
+// Format formats n as ParseNegotiation parses it, e.g. "1 1000 full".
+func (n Negotiation) Format() string {
+	up := 0
+	if n.Carrier {
+		up = 1
+	}
+	return fmt.Sprintf("%d %d %s", up, n.Speed, n.Duplex)
+}
+
+// ParseNegotiation parses a negotiation formatted by Format.
+func ParseNegotiation(text string) (Negotiation, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return Negotiation{}, fmt.Errorf("invalid negotiation %q", text)
+	}
+	speed, err := strconv.Atoi(fields[1])
+	if err != nil || speed < 0 {
+		return Negotiation{}, fmt.Errorf("invalid speed %q", fields[1])
+	}
+	return Negotiation{Carrier: fields[0] == "1", Speed: speed, Duplex: fields[2]}, nil
+}
+
+// String describes n, e.g. "1000 Mbit/s full duplex".
+func (n Negotiation) String() string {
+	if !n.Carrier {
+		return ReasonNoCarrier
+	}
+	speed := "unknown speed"
+	if n.Speed > 0 {
+		speed = fmt.Sprintf("%d Mbit/s", n.Speed)
+	}
+	return fmt.Sprintf("%s %s duplex", speed, n.Duplex)
+}
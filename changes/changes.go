@@ -0,0 +1,103 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package changes records the system changes made during a state transition
+// so that they can be reported as one compact summary.
+package changes
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Kinds of changed objects.
+const (
+	Route      = "route"
+	Address    = "address"
+	DNS        = "dns"
+	Firewall   = "firewall"
+	Connection = "connection"
+	Sysctl     = "sysctl"
+	NTP        = "ntp"
+	Conntrack  = "conntrack"
+	Link       = "link"
+)
+
+// Change is one change made to the system.
+type Change struct {
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	Detail string `json:"detail"`
+}
+
+// String renders the change, e.g. "route replaced 8.8.8.0/24 via 10.0.0.1".
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s %s", c.Kind, c.Action, c.Detail)
+}
+
+// Recorder accumulates changes until they are flushed. The zero value is
+// ready to use.
+type Recorder struct {
+	// OnRecord, if set, is called with every change recorded.
+	OnRecord func(Change)
+
+	mu      sync.Mutex
+	changes []Change
+}
+
+// Record adds a change.
+func (r *Recorder) Record(kind string, action string, format string, args ...interface{}) {
+	c := Change{Kind: kind, Action: action, Detail: fmt.Sprintf(format, args...)}
+	r.mu.Lock()
+	r.changes = append(r.changes, c)
+	r.mu.Unlock()
+	if r.OnRecord != nil {
+		r.OnRecord(c)
+	}
+}
+
+// Flush returns the recorded changes and resets the recorder.
+func (r *Recorder) Flush() []Change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := r.changes
+	r.changes = nil
+	return changes
+}
+
+// Summary renders changes as one line grouped by kind, e.g.
+// "2 changes: connection activated MySSID on wlan0; route replaced ...".
+func Summary(changes []Change) string {
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	order := []string{Connection, Route, Address, DNS, Firewall, Conntrack, Sysctl, NTP}
+	byKind := map[string][]string{}
+	for _, c := range changes {
+		if _, ok := byKind[c.Kind]; !ok && !contains(order, c.Kind) {
+			order = append(order, c.Kind)
+		}
+		byKind[c.Kind] = append(byKind[c.Kind], c.Action+" "+c.Detail)
+	}
+	var parts []string
+	for _, kind := range order {
+		if entries, ok := byKind[kind]; ok {
+			parts = append(parts, kind+" "+strings.Join(entries, ", "))
+		}
+	}
+	noun := "changes"
+	if len(changes) == 1 {
+		noun = "change"
+	}
+	return fmt.Sprintf("%d %s: %s", len(changes), noun, strings.Join(parts, "; "))
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
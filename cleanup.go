@@ -0,0 +1,34 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// cleanupConfig is the configuration of the cleanup command, bound to its
+// flags.
+var cleanupConfig = reliability.DefaultConfig()
+
+// init registers the cleanup command.
+func init() {
+	reliability.AddCleanupFlags(cleanupCmd.Flags(), &cleanupConfig)
+	rootCmd.AddCommand(cleanupCmd)
+}
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove all routes installed by the tool",
+	Long:  "Remove all routes tagged with the tool's routing protocol number, e.g. after a crash, and restore resolv.conf if it was rewritten on failover.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := reliability.Cleanup(cleanupConfig); err != nil {
+			log.Error().Msgf("Cannot clean up: %s", err)
+			os.Exit(1)
+		}
+	},
+}
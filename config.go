@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// envName returns the environment variable holding the flag of cmd, e.g.
+// IF_RELIABILITY_WIFI_IF for the monitor's --wifi-if and
+// IF_RELIABILITY_GEN_DASHBOARDS_JOB for gen dashboards --job. The monitor
+// command shares the names of the root one.
+func envName(cmd *cobra.Command, flag string) string {
+	name := flag
+	for c := cmd; c.HasParent() && c != monitorCmd; c = c.Parent() {
+		name = c.Name() + "_" + name
+	}
+	return reliability.EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags of cmd not given on the command line from the
+// environment.
+func applyEnv(cmd *cobra.Command) error {
+	return reliability.ApplyEnv(cmd.Flags(), func(flag string) string { return envName(cmd, flag) })
+}
+
+// applyEnvTree applies the environment to the command being run, the only
+// one whose flags were parsed, and the configuration file to the monitor.
+func applyEnvTree(cmd *cobra.Command) {
+	switch {
+	case (cmd == rootCmd || cmd == monitorCmd) && cmd.Flags().Parsed():
+		applyConfig()
+	case cmd.Flags().Parsed():
+		if err := applyEnv(cmd); err != nil {
+			log.Error().Msgf("Invalid environment variable %s", err)
+			os.Exit(1)
+		}
+	}
+	for _, c := range cmd.Commands() {
+		applyEnvTree(c)
+	}
+}
+
+// groupNames are the groups of the configuration file when no --group is
+// given, each run by a manager of its own.
+var groupNames []string
+
+// applyConfig applies the environment, then the configuration file, to the
+// flags of the monitor not given on the command line.
+func applyConfig() {
+	if err := applyEnv(rootCmd); err != nil {
+		log.Error().Msgf("Invalid environment variable %s", err)
+		os.Exit(1)
+	}
+	groups, err := reliability.ApplyConfigFile(rootCmd.Flags())
+	if err != nil {
+		log.Error().Msgf("Invalid configuration file %s", err)
+		os.Exit(1)
+	}
+	if len(groups) > 0 {
+		groupNames = groups
+		superviseGroups(rootCmd.Flags())
+	}
+}
+
+// init registers the config commands.
+func init() {
+	cobra.OnInitialize(func() { applyEnvTree(rootCmd) })
+	configCmd.AddCommand(configEffectiveCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configEffectiveCmd = &cobra.Command{
+	Use:   "effective [monitor flags]",
+	Short: "Print the effective configuration as JSON",
+	Long: "Print, as JSON, the configuration the monitor runs with when given the same flags and environment: " +
+		"the value and source of every setting, with secrets redacted.",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := rootCmd.Flags()
+		if err := flags.Parse(args); err != nil {
+			log.Error().Msgf("Error parsing flags: %s", err)
+			os.Exit(1)
+		}
+		applyConfig()
+		config := monitorConfig
+		config.Sources = reliability.SettingSources(flags)
+		data, err := reliability.EffectiveConfig(config)
+		if err != nil {
+			log.Error().Msgf("Error encoding configuration: %s", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	},
+}
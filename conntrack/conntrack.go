@@ -0,0 +1,7 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package conntrack deletes connection tracking entries over netlink, so
+// that the flows of a path that went away are set up again over the new one
+// rather than kept on the dead path by their entries, NAT included.
+package conntrack
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package conntrack
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Flush deletes every entry of the connection tracking table, of every
+// address family.
+func Flush() error {
+	return netlink.ConntrackTableFlush(netlink.ConntrackTable)
+}
+
+// DeleteEgress deletes the entries of the flows leaving through an address
+// among ips: the flows originating from it, and the ones NATed to it, whose
+// replies are sent to it. It returns the number of entries deleted.
+func DeleteEgress(ips []net.IP) (uint, error) {
+	filters := map[netlink.InetFamily][]netlink.CustomConntrackFilter{}
+	for _, ip := range ips {
+		family := netlink.InetFamily(unix.AF_INET6)
+		if ip.To4() != nil {
+			family = unix.AF_INET
+		}
+		for _, tp := range []netlink.ConntrackFilterType{netlink.ConntrackOrigSrcIP, netlink.ConntrackReplyDstIP} {
+			filter := &netlink.ConntrackFilter{}
+			if err := filter.AddIP(tp, ip); err != nil {
+				return 0, err
+			}
+			filters[family] = append(filters[family], filter)
+		}
+	}
+	var deleted uint
+	for family, list := range filters {
+		n, err := netlink.ConntrackDeleteFilters(netlink.ConntrackTable, family, list...)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
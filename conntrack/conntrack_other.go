@@ -0,0 +1,24 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package conntrack
+
+import (
+	"errors"
+	"net"
+)
+
+// errUnsupported is returned on systems without netfilter.
+var errUnsupported = errors.New("connection tracking is only supported on Linux")
+
+// Flush fails, netfilter is Linux-only.
+func Flush() error {
+	return errUnsupported
+}
+
+// DeleteEgress fails, netfilter is Linux-only.
+func DeleteEgress(ips []net.IP) (uint, error) {
+	return 0, errUnsupported
+}
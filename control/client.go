@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/shynuu/if-reliability/availability"
+	"github.com/shynuu/if-reliability/history"
+)
+
+// Client calls the API of a running instance.
+type Client struct {
+	http *http.Client
+	// host is the Host of the requests, and token authenticates them on
+	// a TCP port.
+	host  string
+	token string
+}
+
+// NewClient returns a client of the API served on address if not empty,
+// authenticated with token, on socket otherwise, giving up on a request
+// after timeout.
+func NewClient(socket string, address string, token string, timeout time.Duration) *Client {
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		if address != "" {
+			return d.DialContext(ctx, "tcp", address)
+		}
+		return d.DialContext(ctx, "unix", socket)
+	}
+	c := &Client{http: &http.Client{Timeout: timeout, Transport: &http.Transport{DialContext: dial}}, host: "localhost"}
+	if address != "" {
+		c.host, c.token = address, token
+	}
+	return c
+}
+
+// Status returns the state of the instance.
+func (c *Client) Status() (Status, error) {
+	var s Status
+	err := c.do(http.MethodGet, "/status", &s)
+	return s, err
+}
+
+// Samples returns the last n probe results, oldest first.
+func (c *Client) Samples(n int) ([]history.Sample, error) {
+	var samples []history.Sample
+	err := c.do(http.MethodGet, fmt.Sprintf("/probes?n=%d", n), &samples)
+	return samples, err
+}
+
+// Availability returns the availability reports of the periods under way.
+func (c *Client) Availability() ([]availability.Report, error) {
+	var reports []availability.Report
+	err := c.do(http.MethodGet, "/availability", &reports)
+	return reports, err
+}
+
+// Command runs a command and returns the state it left the instance in. A
+// command that does not apply in the current state fails with ErrRejected.
+func (c *Client) Command(command string) (Status, error) {
+	var s Status
+	err := c.do(http.MethodPost, "/"+command, &s)
+	return s, err
+}
+
+// Inject makes the probes over ifname fail for duration, reporting a loss of
+// carrier too if carrier is set, and returns the state of the instance.
+func (c *Client) Inject(ifname string, duration time.Duration, carrier bool) (Status, error) {
+	var s Status
+	query := url.Values{"interface": {ifname}, "for": {duration.String()}, "carrier": {strconv.FormatBool(carrier)}}
+	err := c.do(http.MethodPost, "/inject?"+query.Encode(), &s)
+	return s, err
+}
+
+// rejection is a command rejected by the instance, reported as the error it
+// replied.
+type rejection string
+
+// Error returns the error the instance replied.
+func (r rejection) Error() string {
+	return string(r)
+}
+
+// Is matches ErrRejected.
+func (r rejection) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// do sends a request to path and decodes the JSON reply into v.
+func (c *Client) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, "http://"+c.host+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var reply struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&reply)
+		if resp.StatusCode == http.StatusConflict {
+			return rejection(reply.Error)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, reply.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.New("invalid reply: " + err.Error())
+	}
+	return nil
+}
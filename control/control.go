@@ -0,0 +1,297 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package control serves the control API of the running instance: JSON over
+// HTTP on a Unix socket and optionally a loopback port, authenticated with a
+// bearer token, exposing the state,
+// the last probe results and the availability reports, and accepting the
+// failover, failback, pause and resume commands and the failures injected
+// to rehearse a failover.
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/availability"
+	"github.com/shynuu/if-reliability/history"
+)
+
+// Commands.
+const (
+	// CommandFailover moves the traffic to the backup link.
+	CommandFailover = "failover"
+	// CommandFailback moves the traffic back to the primary link.
+	CommandFailback = "failback"
+	// CommandPause stops the automatic failovers and failbacks.
+	CommandPause = "pause"
+	// CommandResume resumes the automatic failovers and failbacks.
+	CommandResume = "resume"
+)
+
+// defaultProbes and maxProbes are the default and highest numbers of probe
+// results returned by /probes.
+const (
+	defaultProbes = 20
+	maxProbes     = 10000
+)
+
+// ErrRejected is returned by a Backend for a command that does not apply in
+// the current state.
+var ErrRejected = errors.New("command rejected")
+
+// Status is the state of the instance.
+type Status struct {
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	ActiveLink string    `json:"active_link"`
+	Paused     bool      `json:"paused"`
+	// Maintenance is the maintenance window in progress, and
+	// PreferredLink the link preferred by the schedule, if any.
+	Maintenance   string `json:"maintenance,omitempty"`
+	PreferredLink string `json:"preferred_link,omitempty"`
+	// Usage is the traffic of the links over the current billing period.
+	Usage []Usage `json:"usage,omitempty"`
+	// Pair is the role of the instance in a pair of gateways, if any.
+	Pair *Pair `json:"pair,omitempty"`
+	// Faults are the failures injected and not over yet.
+	Faults []Fault `json:"faults,omitempty"`
+}
+
+// Fault is a failure injected into the probes over an interface.
+type Fault struct {
+	Interface string    `json:"interface"`
+	Until     time.Time `json:"until"`
+	// Carrier reports whether a loss of carrier was reported too.
+	Carrier bool `json:"carrier,omitempty"`
+}
+
+// Pair is the role of an instance in a pair of gateways and what it last
+// heard from the other one.
+type Pair struct {
+	Master bool `json:"master"`
+	// Peer is the name of the other instance, empty if never heard from,
+	// PeerAlive whether it was heard from within the dead interval, and
+	// PeerHealth and PeerMaster what it last advertised.
+	Peer       string `json:"peer,omitempty"`
+	PeerAlive  bool   `json:"peer_alive"`
+	PeerHealth string `json:"peer_health,omitempty"`
+	PeerMaster bool   `json:"peer_master,omitempty"`
+}
+
+// Usage is the traffic of a link since the start of the billing period.
+type Usage struct {
+	Interface string    `json:"interface"`
+	Since     time.Time `json:"since"`
+	RxBytes   uint64    `json:"rx_bytes"`
+	TxBytes   uint64    `json:"tx_bytes"`
+	// CapBytes is the data cap of the link, 0 if it has none, and Capped
+	// reports whether its traffic reached the cap threshold.
+	CapBytes uint64 `json:"cap_bytes,omitempty"`
+	Capped   bool   `json:"capped,omitempty"`
+}
+
+// Backend is what the API exposes.
+type Backend struct {
+	Status func() Status
+	// Samples returns the last n probe results, oldest first.
+	Samples func(n int) ([]history.Sample, error)
+	// Command runs a command, returning once it was accepted.
+	Command func(command string) error
+	// Availability returns the availability reports of the periods under
+	// way.
+	Availability func() []availability.Report
+	// Inject makes the probes over an interface fail for a duration,
+	// reporting a loss of carrier too if carrier is set.
+	Inject func(ifname string, duration time.Duration, carrier bool) error
+}
+
+// Server serves the API until closed.
+type Server struct {
+	listeners []net.Listener
+}
+
+// Listen serves b on socket if not empty and on address if not empty, which
+// must be a loopback one. Any local user can reach the port, and so can web
+// pages through the browser, so requests on address must carry token as a
+// bearer token and name a loopback host in their Host and Origin headers.
+func Listen(socket string, address string, token string, b Backend) (*Server, error) {
+	if address != "" && token == "" {
+		return nil, errors.New("a token is required to serve on a TCP port")
+	}
+	s := &Server{}
+	handler := b.handler()
+	served := map[net.Listener]http.Handler{}
+	if socket != "" {
+		if err := os.MkdirAll(filepath.Dir(socket), 0o755); err != nil {
+			return nil, err
+		}
+		os.Remove(socket)
+		listener, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(socket, 0o660); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		s.listeners = append(s.listeners, listener)
+		served[listener] = handler
+	}
+	if address != "" {
+		if err := checkLoopback(address); err != nil {
+			s.Close()
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.listeners = append(s.listeners, listener)
+		served[listener] = guard(token, handler)
+	}
+	for _, listener := range s.listeners {
+		go http.Serve(listener, served[listener])
+	}
+	return s, nil
+}
+
+// guard serves the requests to next that carry token and come from a
+// loopback origin, so that neither a cross-origin request nor a host name
+// rebound to the loopback address reach it.
+func guard(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackHost(r.Host) {
+			replyError(w, http.StatusForbidden, fmt.Errorf("host %q is not a loopback one", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !loopbackHost(u.Host) {
+				replyError(w, http.StatusForbidden, fmt.Errorf("origin %q is not a loopback one", origin))
+				return
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			replyError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loopbackHost reports whether host, with or without a port, is localhost
+// or a loopback address.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkLoopback fails unless address is a loopback host and port.
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%s is not a loopback address", address)
+	}
+	return nil
+}
+
+// Close stops serving.
+func (s *Server) Close() error {
+	var err error
+	for _, listener := range s.listeners {
+		err = errors.Join(err, listener.Close())
+	}
+	return err
+}
+
+// handler routes the API requests.
+func (b Backend) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, b.Status())
+	})
+	mux.HandleFunc("GET /probes", func(w http.ResponseWriter, r *http.Request) {
+		n := defaultProbes
+		if value := r.URL.Query().Get("n"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxProbes {
+				replyError(w, http.StatusBadRequest, fmt.Errorf("invalid n %q: expected 1 to %d", value, maxProbes))
+				return
+			}
+			n = parsed
+		}
+		samples, err := b.Samples(n)
+		if err != nil {
+			replyError(w, http.StatusInternalServerError, err)
+			return
+		}
+		reply(w, http.StatusOK, samples)
+	})
+	mux.HandleFunc("GET /availability", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, b.Availability())
+	})
+	for _, command := range []string{CommandFailover, CommandFailback, CommandPause, CommandResume} {
+		mux.HandleFunc("POST /"+command, func(w http.ResponseWriter, r *http.Request) {
+			err := b.Command(command)
+			switch {
+			case errors.Is(err, ErrRejected):
+				replyError(w, http.StatusConflict, err)
+			case err != nil:
+				replyError(w, http.StatusServiceUnavailable, err)
+			default:
+				reply(w, http.StatusOK, b.Status())
+			}
+		})
+	}
+	mux.HandleFunc("POST /inject", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		ifname := query.Get("interface")
+		duration, err := time.ParseDuration(query.Get("for"))
+		if ifname == "" || err != nil || duration <= 0 {
+			replyError(w, http.StatusBadRequest, fmt.Errorf("expected an interface and a positive duration, e.g. interface=eth0&for=30s"))
+			return
+		}
+		carrier, _ := strconv.ParseBool(query.Get("carrier"))
+		if err := b.Inject(ifname, duration, carrier); err != nil {
+			replyError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		reply(w, http.StatusOK, b.Status())
+	})
+	return mux
+}
+
+// reply writes v as JSON with code.
+func reply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// replyError writes err as a JSON error with code.
+func replyError(w http.ResponseWriter, code int, err error) {
+	reply(w, code, map[string]string{"error": err.Error()})
+}
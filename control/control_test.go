@@ -0,0 +1,60 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package control
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	const token = "0123456789abcdef"
+	var served bool
+	handler := guard(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+	for _, tt := range []struct {
+		name   string
+		host   string
+		origin string
+		auth   string
+		want   int
+	}{
+		{"authenticated", "127.0.0.1:7070", "", "Bearer " + token, http.StatusOK},
+		{"localhost", "localhost:7070", "http://localhost:7070", "Bearer " + token, http.StatusOK},
+		{"IPv6 loopback", "[::1]:7070", "", "Bearer " + token, http.StatusOK},
+		{"no token", "127.0.0.1:7070", "", "", http.StatusUnauthorized},
+		{"wrong token", "127.0.0.1:7070", "", "Bearer fedcba9876543210", http.StatusUnauthorized},
+		{"rebound host name", "attacker.example:7070", "", "Bearer " + token, http.StatusForbidden},
+		{"cross-origin", "127.0.0.1:7070", "https://attacker.example", "Bearer " + token, http.StatusForbidden},
+		{"opaque origin", "127.0.0.1:7070", "null", "Bearer " + token, http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			served = false
+			r := httptest.NewRequest(http.MethodPost, "/failover", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+			if served != (tt.want == http.StatusOK) {
+				t.Errorf("served %t, want %t", served, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestListenRequiresToken(t *testing.T) {
+	if _, err := Listen("", "127.0.0.1:0", "", Backend{}); err == nil {
+		t.Error("Listen served a TCP port without a token")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package damping suppresses unstable links: every failure of a link holds
+// it down, for twice as long as the previous one while they come in quick
+// succession, so that traffic does not bounce back and forth.
+package damping
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Damper tracks the failures of each link. Its zero value holds nothing
+// down.
+type Damper struct {
+	// HoldDown is how long a link is held down after its first failure.
+	HoldDown time.Duration
+	// MaxHoldDown caps the hold-down of a link failing repeatedly.
+	MaxHoldDown time.Duration
+	// HalfLife is the time after which a failure weighs half as much in
+	// the penalty of a link.
+	HalfLife time.Duration
+
+	mu    sync.Mutex
+	links map[string]*link
+}
+
+// link is the damping state of one link.
+type link struct {
+	// penalty is the decayed number of recent failures as of at.
+	penalty float64
+	at      time.Time
+	until   time.Time
+}
+
+// decayed returns the penalty of l at now.
+func (d *Damper) decayed(l *link, now time.Time) float64 {
+	if d.HalfLife <= 0 {
+		return l.penalty
+	}
+	return l.penalty * math.Exp2(-float64(now.Sub(l.at))/float64(d.HalfLife))
+}
+
+// Fail records a failure of name at now and returns how long the link is
+// held down: HoldDown doubled for every earlier failure still weighing on
+// it, up to MaxHoldDown. A failure counts as earlier for about one
+// half-life.
+func (d *Damper) Fail(name string, now time.Time) time.Duration {
+	if d.HoldDown <= 0 {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.links == nil {
+		d.links = map[string]*link{}
+	}
+	l, ok := d.links[name]
+	if !ok {
+		l = &link{}
+		d.links[name] = l
+	}
+	l.penalty = d.decayed(l, now) + 1
+	l.at = now
+	hold := time.Duration(float64(d.HoldDown) * math.Exp2(math.Round(l.penalty)-1))
+	if d.MaxHoldDown > 0 && hold > d.MaxHoldDown {
+		hold = d.MaxHoldDown
+	}
+	l.until = now.Add(hold)
+	return hold
+}
+
+// Suppressed returns how long name is still held down at now, or 0 if it
+// may be used.
+func (d *Damper) Suppressed(name string, now time.Time) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.links[name]
+	if !ok || !now.Before(l.until) {
+		return 0
+	}
+	return l.until.Sub(now)
+}
+
+// Penalty returns the decayed number of recent failures of name at now.
+func (d *Damper) Penalty(name string, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.links[name]
+	if !ok {
+		return 0
+	}
+	return d.decayed(l, now)
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package dashboards generates a Grafana dashboard and Prometheus alerting
+// rules matching the metrics exported by the tool.
+package dashboards
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/shynuu/if-reliability/metrics"
+)
+
+// Options tunes the generated alerts.
+type Options struct {
+	// Job is the Prometheus job name scraping the tool.
+	Job string
+	// MaxRTT is the RTT threshold in seconds for the high latency alert.
+	MaxRTT float64
+	// MaxFailures is the consecutive failure threshold for the probe alert.
+	MaxFailures int
+	// BackupMaxAge is the age of the last backup verification above which
+	// the unverified backup alert fires.
+	BackupMaxAge time.Duration
+}
+
+type panel struct {
+	Title   string   `json:"title"`
+	Type    string   `json:"type"`
+	GridPos gridPos  `json:"gridPos"`
+	Targets []target `json:"targets"`
+	Unit    string   `json:"-"`
+	Field   *field   `json:"fieldConfig,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+type field struct {
+	Defaults map[string]string `json:"defaults"`
+}
+
+// Grafana returns the dashboard JSON, ready to be imported.
+func Grafana(opts Options) ([]byte, error) {
+	selector := fmt.Sprintf(`{job="%s"}`, opts.Job)
+	panels := []panel{
+		{
+			Title: "Active interface",
+			Type:  "state-timeline",
+			Targets: []target{{
+				Expr:         metrics.ActiveInterface + selector,
+				LegendFormat: "{{" + metrics.LabelInterface + "}}",
+			}},
+		},
+		{
+			Title: "Probe RTT (p50 / p95)",
+			Type:  "timeseries",
+			Unit:  "s",
+			Targets: []target{
+				{
+					Expr:         fmt.Sprintf("histogram_quantile(0.5, sum by (le, %s) (rate(%s_bucket%s[5m])))", metrics.LabelInterface, metrics.ProbeRTT, selector),
+					LegendFormat: "p50 {{" + metrics.LabelInterface + "}}",
+				},
+				{
+					Expr:         fmt.Sprintf("histogram_quantile(0.95, sum by (le, %s) (rate(%s_bucket%s[5m])))", metrics.LabelInterface, metrics.ProbeRTT, selector),
+					LegendFormat: "p95 {{" + metrics.LabelInterface + "}}",
+				},
+			},
+		},
+		{
+			Title: "Consecutive probe failures",
+			Type:  "timeseries",
+			Targets: []target{{
+				Expr:         metrics.ConsecutiveFailures + selector,
+				LegendFormat: "{{" + metrics.LabelInterface + "}} {{" + metrics.LabelEndpoint + "}}",
+			}},
+		},
+		{
+			Title: "Failovers",
+			Type:  "timeseries",
+			Targets: []target{{
+				Expr:         fmt.Sprintf("increase(%s%s[1h])", metrics.Failovers, selector),
+				LegendFormat: "{{" + metrics.LabelFrom + "}} → {{" + metrics.LabelTo + "}}",
+			}},
+		},
+		{
+			Title: "Subprocess mean run time",
+			Type:  "timeseries",
+			Unit:  "s",
+			Targets: []target{{
+				Expr:         fmt.Sprintf("rate(%s_sum%s[5m]) / rate(%s_count%s[5m])", metrics.ExecDuration, selector, metrics.ExecDuration, selector),
+				LegendFormat: "{{" + metrics.LabelProgram + "}}",
+			}},
+		},
+		{
+			Title: "Subprocess failures",
+			Type:  "timeseries",
+			Targets: []target{{
+				Expr:         fmt.Sprintf("increase(%s%s[1h])", metrics.ExecFailures, selector),
+				LegendFormat: "{{" + metrics.LabelProgram + "}}",
+			}},
+		},
+		{
+			Title: "Long-term reliability",
+			Type:  "stat",
+			Unit:  "percentunit",
+			Targets: []target{{
+				Expr:         metrics.Reliability + selector,
+				LegendFormat: "{{" + metrics.LabelInterface + "}}",
+			}},
+		},
+		{
+			Title: "Events by severity",
+			Type:  "timeseries",
+			Targets: []target{{
+				Expr:         fmt.Sprintf("increase(%s%s[1h])", metrics.Events, selector),
+				LegendFormat: "{{" + metrics.LabelSeverity + "}}",
+			}},
+		},
+		{
+			Title: "Time since last failover",
+			Type:  "stat",
+			Unit:  "s",
+			Targets: []target{{
+				Expr:         fmt.Sprintf("time() - %s%s", metrics.LastFailover, selector),
+				LegendFormat: "last failover",
+			}},
+		},
+		{
+			Title: "Time since backup verified",
+			Type:  "stat",
+			Unit:  "s",
+			Targets: []target{{
+				Expr:         fmt.Sprintf("time() - %s%s", metrics.BackupVerified, selector),
+				LegendFormat: "{{" + metrics.LabelInterface + "}}",
+			}},
+		},
+	}
+	for i := range panels {
+		panels[i].GridPos = gridPos{H: 8, W: 12, X: (i % 2) * 12, Y: (i / 2) * 8}
+		for j := range panels[i].Targets {
+			panels[i].Targets[j].RefID = string(rune('A' + j))
+		}
+		if panels[i].Unit != "" {
+			panels[i].Field = &field{Defaults: map[string]string{"unit": panels[i].Unit}}
+		}
+	}
+	dashboard := map[string]interface{}{
+		"title":         "Interface Reliability",
+		"uid":           "if-reliability",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"panels":        panels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+var rulesTemplate = template.Must(template.New("rules").Parse(`groups:
+  - name: if-reliability
+    rules:
+      - alert: IfReliabilityDown
+        expr: up{job="{{.Job}}"} == 0
+        for: 5m
+        labels:
+          severity: critical
+        annotations:
+          summary: "if-reliability on {{"{{ $labels.instance }}"}} is not reporting"
+      - alert: IfReliabilityProbeFailing
+        expr: {{.ConsecutiveFailures}}{job="{{.Job}}"} >= {{.MaxFailures}}
+        for: 1m
+        labels:
+          severity: warning
+        annotations:
+          summary: "Probes toward {{"{{ $labels.endpoint }}"}} over {{"{{ $labels.interface }}"}} are failing"
+      - alert: IfReliabilityHighLatency
+        expr: histogram_quantile(0.95, sum by (le, instance, interface) (rate({{.ProbeRTT}}_bucket{job="{{.Job}}"}[5m]))) > {{.MaxRTT}}
+        for: 10m
+        labels:
+          severity: warning
+        annotations:
+          summary: "p95 RTT over {{"{{ $labels.interface }}"}} is above {{.MaxRTT}}s"
+      - alert: IfReliabilitySlowSubprocess
+        expr: rate({{.ExecDuration}}_sum{job="{{.Job}}"}[5m]) / rate({{.ExecDuration}}_count{job="{{.Job}}"}[5m]) > 2
+        for: 10m
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{"{{ $labels.program }}"}} takes more than 2s on {{"{{ $labels.instance }}"}}, probe results may reflect system load"
+      - alert: IfReliabilityFailover
+        expr: increase({{.Failovers}}{job="{{.Job}}"}[5m]) > 0
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{"{{ $labels.instance }}"}} failed over from {{"{{ $labels.from }}"}} to {{"{{ $labels.to }}"}}"
+      - alert: IfReliabilityBackupUnverified
+        expr: time() - {{.BackupVerified}}{job="{{.Job}}"} > {{.BackupMaxAge}}
+        labels:
+          severity: warning
+        annotations:
+          summary: "Backup path over {{"{{ $labels.interface }}"}} on {{"{{ $labels.instance }}"}} was not verified for more than {{.BackupMaxAgeText}}"
+`))
+
+// AlertRules returns a Prometheus rule file.
+func AlertRules(opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	err := rulesTemplate.Execute(&buf, map[string]interface{}{
+		"Job":                 opts.Job,
+		"MaxRTT":              opts.MaxRTT,
+		"MaxFailures":         opts.MaxFailures,
+		"ProbeRTT":            metrics.ProbeRTT,
+		"ConsecutiveFailures": metrics.ConsecutiveFailures,
+		"Failovers":           metrics.Failovers,
+		"ExecDuration":        metrics.ExecDuration,
+		"BackupVerified":      metrics.BackupVerified,
+		"BackupMaxAge":        opts.BackupMaxAge.Seconds(),
+		"BackupMaxAgeText":    opts.BackupMaxAge.String(),
+	})
+	return buf.Bytes(), err
+}
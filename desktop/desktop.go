@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package desktop pops desktop notifications through the
+// org.freedesktop.Notifications service on the session bus of a user.
+package desktop
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// D-Bus names of the notification service.
+const (
+	busName    = "org.freedesktop.Notifications"
+	objectPath = "/org/freedesktop/Notifications"
+)
+
+// timeout bounds a notification, so that a hung notification daemon does
+// not hold the caller.
+const timeout = 5 * time.Second
+
+// Urgency is the urgency level of a notification.
+type Urgency byte
+
+// Urgency levels.
+const (
+	Low      Urgency = 0
+	Normal   Urgency = 1
+	Critical Urgency = 2
+)
+
+// Notification is a notification to pop.
+type Notification struct {
+	Summary string
+	Body    string
+	// Icon is the name of a themed icon, e.g. network-error, or none if
+	// empty.
+	Icon    string
+	Urgency Urgency
+}
+
+// Notifier pops the notifications on a session bus. Each notification
+// replaces the previous one, so that a flapping link does not pile them up.
+// Its methods may be called from several goroutines.
+type Notifier struct {
+	// App is the name of the application shown with the notifications.
+	App string
+	// Address is the address of the session bus, and UID the user owning
+	// it, whom the bus may only accept.
+	Address string
+	UID     int
+
+	mu   sync.Mutex
+	conn *dbus.Conn
+	// last is the ID of the last notification.
+	last uint32
+}
+
+// Notify pops n, connecting to the bus first if needed, e.g. after the user
+// logged in again.
+func (d *Notifier) Notify(n Notification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		conn, err := d.connect()
+		if err != nil {
+			return fmt.Errorf("connecting to the session bus %s: %w", d.Address, err)
+		}
+		d.conn = conn
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(byte(n.Urgency))}
+	err := d.conn.Object(busName, objectPath).CallWithContext(ctx, busName+".Notify", 0,
+		d.App, d.last, n.Icon, n.Summary, n.Body, []string{}, hints, int32(-1)).Store(&d.last)
+	if err != nil {
+		// Connect again next time, the session may have ended.
+		d.conn.Close()
+		d.conn = nil
+	}
+	return err
+}
+
+// Close closes the connection to the bus.
+func (d *Notifier) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
+
+// connect connects to the bus as UID and says hello.
+func (d *Notifier) connect() (*dbus.Conn, error) {
+	conn, err := dial(d.Address, d.UID)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Auth([]dbus.Auth{dbus.AuthExternal(strconv.Itoa(d.UID))}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package desktop
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+// dial connects to the bus at address as uid. The bus knows its peers by the
+// effective user that connected and may only accept its owner, so a root
+// process connects from a thread running as uid for the time of the
+// connection, leaving the other threads privileged.
+func dial(address string, uid int) (*dbus.Conn, error) {
+	euid := os.Geteuid()
+	if uid == euid {
+		return dbus.Dial(address)
+	}
+	runtime.LockOSThread()
+	if err := setEUID(uid); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("switching to user %d: %w", uid, err)
+	}
+	conn, err := dbus.Dial(address)
+	if restoreErr := setEUID(euid); restoreErr != nil {
+		// Keep the thread locked so that it is never reused by other
+		// goroutines while running as uid.
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, fmt.Errorf("switching back to user %d: %w", euid, restoreErr)
+	}
+	runtime.UnlockOSThread()
+	return conn, err
+}
+
+// setEUID sets the effective user of the calling thread only, unlike
+// unix.Setresuid which sets that of every thread.
+func setEUID(uid int) error {
+	unchanged := ^uintptr(0)
+	if _, _, errno := unix.RawSyscall(sysSetresuid, unchanged, uintptr(uid), unchanged); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package desktop
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// dial connects to the bus at address. Connecting as another user is only
+// supported on Linux.
+func dial(address string, uid int) (*dbus.Conn, error) {
+	if uid != os.Geteuid() {
+		return nil, fmt.Errorf("connecting as user %d is not supported on this platform", uid)
+	}
+	return dbus.Dial(address)
+}
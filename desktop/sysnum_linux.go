@@ -0,0 +1,11 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build linux && !386 && !arm
+
+package desktop
+
+import "golang.org/x/sys/unix"
+
+// sysSetresuid is the setresuid system call taking 32-bit user IDs.
+const sysSetresuid = unix.SYS_SETRESUID
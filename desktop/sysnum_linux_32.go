@@ -0,0 +1,12 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build linux && (386 || arm)
+
+package desktop
+
+import "golang.org/x/sys/unix"
+
+// sysSetresuid is the setresuid system call taking 32-bit user IDs, the
+// original one taking 16-bit IDs on these platforms.
+const sysSetresuid = unix.SYS_SETRESUID32
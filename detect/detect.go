@@ -0,0 +1,217 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package detect decides from its probe rounds when a link is down, with an
+// algorithm trading detection speed against false positives: a number of
+// failed rounds in a row, the share of failed rounds over a sliding window,
+// or exponentially weighted moving averages of the loss and RTT, as BFD and
+// smokeping smooth their measurements.
+package detect
+
+import (
+	"fmt"
+	"time"
+)
+
+// Detection algorithms.
+const (
+	// Consecutive declares the link down after a number of failed rounds in
+	// a row. A single good round starts the count over.
+	Consecutive = "consecutive"
+	// Window declares the link down when the share of failed rounds among
+	// the last ones reaches the maximum loss, catching intermittent loss
+	// that never fails enough rounds in a row.
+	Window = "window"
+	// EWMA declares the link down when the moving average of the loss, or
+	// of the RTT of the answered rounds, goes above its maximum.
+	EWMA = "ewma"
+)
+
+// Round is the outcome of a probe round.
+type Round struct {
+	// Failed is set when the round failed, or found the link unhealthy.
+	Failed bool
+	// RTT is the RTT of the answered probes of the round, 0 if none was.
+	RTT time.Duration
+}
+
+// Detector judges the rounds of a link, one after the other.
+type Detector interface {
+	// Observe records a round and reports whether the link is down.
+	Observe(r Round) bool
+	// Progress describes how close the link is to being declared down,
+	// e.g. "Attempt 2 out of 5".
+	Progress() string
+	// Reason describes why the link was declared down, e.g. "5
+	// consecutive failed rounds".
+	Reason() string
+}
+
+// Options are the settings of the detection algorithms.
+type Options struct {
+	// Algorithm is Consecutive, Window or EWMA.
+	Algorithm string
+	// Retry is the number of failed rounds in a row of Consecutive.
+	Retry int
+	// Window is the number of rounds of Window.
+	Window int
+	// MaxLoss is the share of failed rounds, between 0 and 1, at which
+	// Window and EWMA declare the link down.
+	MaxLoss float64
+	// Alpha is the weight of the last round in the averages of EWMA,
+	// between 0 and 1: the higher, the faster and the noisier.
+	Alpha float64
+	// MaxRTT is the average RTT above which EWMA declares the link down,
+	// disabled if 0.
+	MaxRTT time.Duration
+}
+
+// Validate checks the settings of the selected algorithm.
+func (o Options) Validate() error {
+	switch o.Algorithm {
+	case Consecutive:
+		if o.Retry < 1 {
+			return fmt.Errorf("invalid retry count %d: at least one failed round is needed", o.Retry)
+		}
+	case Window:
+		if o.Window < 1 {
+			return fmt.Errorf("invalid window of %d rounds", o.Window)
+		}
+		if o.MaxLoss <= 0 || o.MaxLoss > 1 {
+			return fmt.Errorf("invalid maximum loss %g%%: it must be above 0 and at most 100", o.MaxLoss*100)
+		}
+	case EWMA:
+		if o.Alpha <= 0 || o.Alpha > 1 {
+			return fmt.Errorf("invalid smoothing factor %g: it must be above 0 and at most 1", o.Alpha)
+		}
+		if o.MaxLoss <= 0 || o.MaxLoss > 1 {
+			return fmt.Errorf("invalid maximum loss %g%%: it must be above 0 and at most 100", o.MaxLoss*100)
+		}
+		if o.MaxRTT < 0 {
+			return fmt.Errorf("invalid maximum RTT %s", o.MaxRTT)
+		}
+	default:
+		return fmt.Errorf("unknown detection algorithm %q (%s, %s or %s)", o.Algorithm, Consecutive, Window, EWMA)
+	}
+	return nil
+}
+
+// New returns a detector of the algorithm of o, which must be valid, with
+// no round observed.
+func New(o Options) Detector {
+	switch o.Algorithm {
+	case Window:
+		return &window{size: o.Window, maxLoss: o.MaxLoss, failed: make([]bool, o.Window)}
+	case EWMA:
+		return &ewma{alpha: o.Alpha, maxLoss: o.MaxLoss, maxRTT: o.MaxRTT}
+	}
+	return &consecutive{retry: o.Retry}
+}
+
+// consecutive counts the failed rounds in a row.
+type consecutive struct {
+	retry    int
+	failures int
+}
+
+// Observe counts r if it failed, or starts over.
+func (c *consecutive) Observe(r Round) bool {
+	if !r.Failed {
+		c.failures = 0
+		return false
+	}
+	c.failures++
+	return c.failures >= c.retry
+}
+
+// Progress counts the failed rounds toward the retry count.
+func (c *consecutive) Progress() string {
+	return fmt.Sprintf("Attempt %d out of %d", c.failures, c.retry)
+}
+
+// Reason counts the failed rounds.
+func (c *consecutive) Reason() string {
+	return fmt.Sprintf("%d consecutive failed rounds", c.failures)
+}
+
+// window keeps whether each of the last rounds failed in a ring, the rounds
+// before the first counting as good ones.
+type window struct {
+	size    int
+	maxLoss float64
+	failed  []bool
+	next    int
+	count   int
+}
+
+// Observe replaces the oldest round of the window with r.
+func (w *window) Observe(r Round) bool {
+	if w.failed[w.next] {
+		w.count--
+	}
+	w.failed[w.next] = r.Failed
+	if r.Failed {
+		w.count++
+	}
+	w.next = (w.next + 1) % w.size
+	return w.loss() >= w.maxLoss
+}
+
+// loss returns the share of failed rounds in the window.
+func (w *window) loss() float64 {
+	return float64(w.count) / float64(w.size)
+}
+
+// Progress gives the failed rounds of the window against the maximum loss.
+func (w *window) Progress() string {
+	return fmt.Sprintf("%d of the last %d rounds failed, failing over at %.0f%%", w.count, w.size, w.maxLoss*100)
+}
+
+// Reason counts the failed rounds of the window.
+func (w *window) Reason() string {
+	return fmt.Sprintf("%d of the last %d rounds failed", w.count, w.size)
+}
+
+// ewma keeps the moving averages of the loss and of the RTT, the latter
+// only over the answered rounds.
+type ewma struct {
+	alpha   float64
+	maxLoss float64
+	maxRTT  time.Duration
+	loss    float64
+	rtt     time.Duration
+}
+
+// Observe folds r into the averages.
+func (e *ewma) Observe(r Round) bool {
+	failed := 0.0
+	if r.Failed {
+		failed = 1
+	}
+	e.loss = e.alpha*failed + (1-e.alpha)*e.loss
+	switch {
+	case r.RTT <= 0:
+	case e.rtt == 0:
+		e.rtt = r.RTT
+	default:
+		e.rtt = time.Duration(e.alpha*float64(r.RTT) + (1-e.alpha)*float64(e.rtt))
+	}
+	return e.loss >= e.maxLoss || e.maxRTT > 0 && e.rtt > e.maxRTT
+}
+
+// Progress gives the averages against their maximums.
+func (e *ewma) Progress() string {
+	progress := fmt.Sprintf("Average loss %.0f%%, failing over at %.0f%%", e.loss*100, e.maxLoss*100)
+	if e.maxRTT > 0 {
+		progress += fmt.Sprintf(", average RTT %s out of %s", e.rtt.Round(time.Microsecond), e.maxRTT)
+	}
+	return progress
+}
+
+// Reason names the average above its maximum.
+func (e *ewma) Reason() string {
+	if e.loss >= e.maxLoss {
+		return fmt.Sprintf("average loss %.0f%% at least %.0f%%", e.loss*100, e.maxLoss*100)
+	}
+	return fmt.Sprintf("average RTT %s above %s", e.rtt.Round(time.Microsecond), e.maxRTT)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package diagnosis gathers what helps finding out after the fact why a link
+// failed: the hops of a traceroute toward an endpoint, telling where the path
+// breaks, and the counters of the interface, telling a carrier or radio
+// problem from a failure further away.
+package diagnosis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Hop is one hop of a traceroute.
+type Hop struct {
+	TTL int `json:"ttl"`
+	// Address is the address of the router that answered, empty if none
+	// did.
+	Address   string  `json:"address,omitempty"`
+	RTTMillis float64 `json:"rtt_ms,omitempty"`
+}
+
+// String describes h, e.g. "3 198.51.100.1 12.3ms" or "4 *".
+func (h Hop) String() string {
+	if h.Address == "" {
+		return fmt.Sprintf("%d *", h.TTL)
+	}
+	return fmt.Sprintf("%d %s %.1fms", h.TTL, h.Address, h.RTTMillis)
+}
+
+// ParseTraceroute parses the output of traceroute -n -q 1: a header line
+// followed by one line per hop, e.g. " 2  192.0.2.1  0.512 ms" or " 3  *".
+func ParseTraceroute(output string) []Hop {
+	var hops []Hop
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		ttl, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		hop := Hop{TTL: ttl}
+		if net.ParseIP(fields[1]) != nil {
+			hop.Address = fields[1]
+			if len(fields) >= 4 && fields[3] == "ms" {
+				hop.RTTMillis, _ = strconv.ParseFloat(fields[2], 64)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// Reached reports whether the last hop is dst.
+func Reached(hops []Hop, dst string) bool {
+	return len(hops) > 0 && hops[len(hops)-1].Address == dst
+}
+
+// LastAnswer returns the last hop that answered, and false if none did.
+func LastAnswer(hops []Hop) (Hop, bool) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i].Address != "" {
+			return hops[i], true
+		}
+	}
+	return Hop{}, false
+}
+
+// Link is a snapshot of an interface.
+type Link struct {
+	// State is the operational state, e.g. "up", "down" or "dormant".
+	State string `json:"state"`
+	// CarrierChanges counts the carrier going up or down since the
+	// interface was created, unknown if nil.
+	CarrierChanges *uint64 `json:"carrier_changes,omitempty"`
+	// Counters are the packet, byte, error and drop counters of the
+	// interface, named like in ip -s link, e.g. rx_errors or tx_carrier.
+	Counters map[string]uint64 `json:"counters"`
+}
+
+// Errors returns the sum of the error and drop counters of l.
+func (l Link) Errors() uint64 {
+	return l.Counters["rx_errors"] + l.Counters["tx_errors"] + l.Counters["rx_dropped"] + l.Counters["tx_dropped"]
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package diagnosis
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// ReadLink returns a snapshot of ifname read over rtnetlink, in the network
+// namespace of the calling thread. The carrier changes are read from sysfs,
+// which shows the namespace the process was started in.
+func ReadLink(ifname string) (Link, error) {
+	link, err := netlink.LinkByName(ifname)
+	if err != nil {
+		return Link{}, err
+	}
+	attrs := link.Attrs()
+	l := Link{State: attrs.OperState.String(), Counters: map[string]uint64{}}
+	if s := attrs.Statistics; s != nil {
+		for name, value := range map[string]uint64{
+			"rx_packets":   s.RxPackets,
+			"tx_packets":   s.TxPackets,
+			"rx_bytes":     s.RxBytes,
+			"tx_bytes":     s.TxBytes,
+			"rx_errors":    s.RxErrors,
+			"tx_errors":    s.TxErrors,
+			"rx_dropped":   s.RxDropped,
+			"tx_dropped":   s.TxDropped,
+			"rx_crc":       s.RxCrcErrors,
+			"rx_frame":     s.RxFrameErrors,
+			"rx_fifo":      s.RxFifoErrors,
+			"rx_missed":    s.RxMissedErrors,
+			"tx_aborted":   s.TxAbortedErrors,
+			"tx_carrier":   s.TxCarrierErrors,
+			"tx_fifo":      s.TxFifoErrors,
+			"tx_heartbeat": s.TxHeartbeatErrors,
+			"collisions":   s.Collisions,
+		} {
+			l.Counters[name] = value
+		}
+	}
+	if data, err := os.ReadFile("/sys/class/net/" + ifname + "/carrier_changes"); err == nil {
+		if changes, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			l.CarrierChanges = &changes
+		}
+	}
+	return l, nil
+}
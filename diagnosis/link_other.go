@@ -0,0 +1,13 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package diagnosis
+
+import "errors"
+
+// ReadLink fails, rtnetlink is Linux-only.
+func ReadLink(ifname string) (Link, error) {
+	return Link{}, errors.New("interface counters are only read on Linux")
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// init registers the dispatcher commands.
+func init() {
+	dispatchCmd.Flags().String("socket", reliability.DefaultTriggerSocket, "Trigger socket of the running instance")
+	dispatcherInstallCmd.Flags().String("path", "/etc/NetworkManager/dispatcher.d/90-if-reliability", "Path of the dispatcher script")
+	dispatcherInstallCmd.Flags().String("socket", reliability.DefaultTriggerSocket, "Trigger socket of the running instance")
+	dispatcherCmd.AddCommand(dispatcherInstallCmd)
+	rootCmd.AddCommand(dispatcherCmd)
+	rootCmd.AddCommand(dispatchCmd)
+}
+
+var dispatcherCmd = &cobra.Command{
+	Use:   "dispatcher",
+	Short: "Manage the NetworkManager dispatcher integration",
+}
+
+var dispatcherInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the NetworkManager dispatcher script",
+	Long:  "Install a NetworkManager dispatcher script forwarding interface up/down and connectivity events to the running instance.",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, _ := cmd.Flags().GetString("path")
+		socket, _ := cmd.Flags().GetString("socket")
+		binary, err := os.Executable()
+		if err == nil {
+			binary, err = filepath.EvalSymlinks(binary)
+		}
+		if err != nil {
+			log.Error().Msgf("Error locating the executable: %s", err)
+			os.Exit(1)
+		}
+		if err := dispatcher.Install(path, binary, socket); err != nil {
+			log.Error().Msgf("Error installing dispatcher script: %s", err)
+			os.Exit(1)
+		}
+		log.Info().Msgf("Installed dispatcher script %s", path)
+	},
+}
+
+var dispatchCmd = &cobra.Command{
+	Use:    "dispatch <interface> <action>",
+	Short:  "Forward a NetworkManager dispatcher event to the running instance",
+	Args:   cobra.ExactArgs(2),
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		socket, _ := cmd.Flags().GetString("socket")
+		event := dispatcher.Event{
+			Interface:    args[0],
+			Action:       args[1],
+			Connectivity: os.Getenv("CONNECTIVITY_STATE"),
+		}
+		if err := dispatcher.Send(socket, event); err != nil {
+			// Not running: nothing to notify, and NetworkManager must not
+			// see the script fail.
+			log.Debug().Msgf("Could not forward event: %s", err)
+		}
+	},
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package dispatcher relays NetworkManager dispatcher events, and
+// administrative requests, to the running instance. NetworkManager runs the installed dispatcher script on every
+// interface up/down and connectivity change; the script forwards the event
+// over a Unix datagram socket so that the tool can react immediately instead
+// of waiting for its next probe.
+package dispatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Actions sent by NetworkManager that the tool reacts to.
+const (
+	ActionUp                 = "up"
+	ActionDown               = "down"
+	ActionConnectivityChange = "connectivity-change"
+	// ActionEvacuate is an administrative request to gracefully move
+	// traffic off a link, sent by the evacuate command.
+	ActionEvacuate = "evacuate"
+	// ActionHealth is a health signal about a link pushed by an external
+	// agent, sent by the report command.
+	ActionHealth = "health"
+)
+
+// Event is a NetworkManager dispatcher event.
+type Event struct {
+	Interface string `json:"interface"`
+	Action    string `json:"action"`
+	// Connectivity is the global connectivity state on
+	// connectivity-change events (NONE, PORTAL, LIMITED, FULL, UNKNOWN).
+	Connectivity string `json:"connectivity,omitempty"`
+	// Timeout bounds the draining of evacuate requests, and is how long a
+	// health signal stays in effect.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Source, Failure and Weight describe a health signal: the reporting
+	// agent, the failure ratio it observed and the weight of its report.
+	Source  string  `json:"source,omitempty"`
+	Failure float64 `json:"failure,omitempty"`
+	Weight  float64 `json:"weight,omitempty"`
+}
+
+// Degraded reports whether the event signals lost connectivity.
+func (e Event) Degraded() bool {
+	if e.Action != ActionConnectivityChange {
+		return false
+	}
+	switch e.Connectivity {
+	case "NONE", "PORTAL", "LIMITED":
+		return true
+	}
+	return false
+}
+
+// Script returns a dispatcher script forwarding events to socket by running
+// binary.
+func Script(binary string, socket string) string {
+	return fmt.Sprintf(`#!/bin/sh
+# Installed by if-reliability: forwards NetworkManager events to the running instance.
+exec %q dispatch --socket %q "$1" "$2"
+`, binary, socket)
+}
+
+// Install writes the dispatcher script to path.
+func Install(path string, binary string, socket string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(Script(binary, socket)), 0o755)
+}
+
+// Send forwards an event to the instance listening on socket.
+func Send(socket string, e Event) error {
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// Listen receives events on socket until the returned connection is closed.
+func Listen(socket string) (<-chan Event, net.PacketConn, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0o755); err != nil {
+		return nil, nil, err
+	}
+	os.Remove(socket)
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		return nil, nil, err
+	}
+	events := make(chan Event, 16)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 4096)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var e Event
+			if err := json.Unmarshal(buf[:n], &e); err != nil {
+				continue
+			}
+			e.Action = strings.ToLower(e.Action)
+			select {
+			case events <- e:
+			default:
+				// The monitor is busy, it will probe again anyway.
+			}
+		}
+	}()
+	return events, conn, nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// init registers the doctor command.
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [monitor flags]",
+	Short: "Check the privileges, dependencies, interfaces and endpoints before monitoring",
+	Long: "Check, given the same flags and environment as the monitor, that it can run: root or the CAP_NET_ADMIN " +
+		"and CAP_NET_RAW capabilities, rtnetlink access, NetworkManager on the system bus, the named interfaces, " +
+		"the resolution of the endpoints and the external programs the settings run. " +
+		"Every failed check is printed with how to fix it and the exit status is 1.",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := rootCmd.Flags()
+		if err := flags.Parse(args); err != nil {
+			log.Error().Msgf("Error parsing flags: %s", err)
+			os.Exit(1)
+		}
+		applyConfig()
+		passed, err := reliability.Doctor(monitorConfig)
+		if err != nil {
+			log.Error().Msgf("Cannot run the checks: %s", err)
+			os.Exit(1)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package echo
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+)
+
+// DefaultLossWindow is the default number of probes loss is measured over.
+const DefaultLossWindow = 100
+
+// Client sends probes to a responder.
+type Client struct {
+	// Key authenticates probes and replies when not empty. It must match
+	// the responder key.
+	Key []byte
+	// Timeout bounds the wait for a reply.
+	Timeout time.Duration
+	// LossWindow is the number of probes directional loss is measured over
+	// (DefaultLossWindow if zero).
+	LossWindow int
+	// Network is the network host names are resolved in: "udp" (the
+	// default) for either family, "udp4" or "udp6".
+	Network string
+
+	mu      sync.Mutex
+	streams map[stream]*streamState
+}
+
+// stream identifies the probes sent to one responder address over one
+// interface. Each stream has its own session, sequence numbers and loss
+// window, so that the responder count and the sequence gaps only cover the
+// probes of that path.
+type stream struct {
+	address string
+	ifname  string
+}
+
+// streamState is the state of a stream.
+type streamState struct {
+	session uint32
+	seq     uint32
+	window  *lossWindow
+}
+
+// state returns the state of s, creating it on first use. c.mu must be held.
+func (c *Client) state(s stream) *streamState {
+	if c.streams == nil {
+		c.streams = make(map[stream]*streamState)
+	}
+	st, ok := c.streams[s]
+	if !ok {
+		b := make([]byte, 4)
+		rand.Read(b)
+		size := c.LossWindow
+		if size <= 0 {
+			size = DefaultLossWindow
+		}
+		st = &streamState{session: binary.BigEndian.Uint32(b) | 1, window: &lossWindow{size: size}}
+		c.streams[s] = st
+	}
+	return st
+}
+
+// next returns the sequence number and session of the next probe of s.
+func (c *Client) next(s stream) (uint32, uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.state(s)
+	st.seq++
+	return st.seq, st.session
+}
+
+// record stores the outcome of a probe of s.
+func (c *Client) record(s stream, r probeRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state(s).window.add(r)
+}
+
+// Loss returns the directional loss measured toward the responder at address
+// over ifname, empty for the probes following the routing table. It returns
+// false until at least two replies were received.
+func (c *Client) Loss(address string, ifname string) (Loss, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.streams[stream{address, ifname}]
+	if !ok {
+		return Loss{}, false
+	}
+	return st.window.loss()
+}
+
+// Probe sends one UDP probe to the responder at address, bound to ifname if
+// not empty, and returns the reply.
+func (c *Client) Probe(address string, ifname string) (*Packet, error) {
+	s := stream{address, ifname}
+	seq, session := c.next(s)
+	reply, err := c.probe(address, ifname, seq, session)
+	if err != nil {
+		c.record(s, probeRecord{seq: seq})
+		return nil, err
+	}
+	c.record(s, probeRecord{seq: seq, replied: true, count: reply.Count})
+	return reply, nil
+}
+
+func (c *Client) probe(address string, ifname string, seq uint32, session uint32) (*Packet, error) {
+	dialer := net.Dialer{Timeout: c.Timeout, Control: bind.Control(ifname)}
+	network := c.Network
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	request := Packet{Kind: KindRequest, Seq: seq, Session: session, Sent: time.Now()}
+	if _, err := conn.Write(request.Marshal(c.Key)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var reply Packet
+		if err := reply.Unmarshal(buf[:n], c.Key); err != nil || reply.Kind != KindReply || reply.Seq != request.Seq || reply.Session != session {
+			// Forged reply, late reply to an earlier probe or garbage,
+			// keep waiting.
+			continue
+		}
+		if reply.Sent.UnixNano() != request.Sent.UnixNano() {
+			return nil, fmt.Errorf("reply to probe %d carries a different timestamp", request.Seq)
+		}
+		reply.Sent = request.Sent
+		return &reply, nil
+	}
+}
+
+// RTT returns the round-trip time of a reply received now, excluding the time
+// spent in the responder.
+func (p *Packet) RTT(now time.Time) time.Duration {
+	rtt := now.Sub(p.Sent)
+	if !p.Received.IsZero() && !p.Replied.IsZero() {
+		if held := p.Replied.Sub(p.Received); held > 0 && held < rtt {
+			rtt -= held
+		}
+	}
+	return rtt
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package echo
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long the responder remembers an idle client session.
+const sessionTTL = 10 * time.Minute
+
+// maxSessions is the most client sessions counted at once. Requests of new
+// sessions are not counted while that many are active.
+const maxSessions = 4096
+
+type sessionKey struct {
+	host    string
+	session uint32
+}
+
+type sessionCount struct {
+	count uint32
+	seen  time.Time
+}
+
+// sessionCounter counts the requests received from each client session. It
+// is only fed authenticated requests, and bounded by maxSessions.
+type sessionCounter struct {
+	mu       sync.Mutex
+	sessions map[sessionKey]*sessionCount
+	swept    time.Time
+}
+
+func newSessionCounter() *sessionCounter {
+	return &sessionCounter{sessions: make(map[sessionKey]*sessionCount)}
+}
+
+// received records a request and returns the number of requests received
+// from the session so far, or 0 if the session is not counted.
+func (c *sessionCounter) received(host string, session uint32, now time.Time) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.swept) > sessionTTL || len(c.sessions) >= maxSessions {
+		for k, s := range c.sessions {
+			if now.Sub(s.seen) > sessionTTL {
+				delete(c.sessions, k)
+			}
+		}
+		c.swept = now
+	}
+	key := sessionKey{host: host, session: session}
+	s, ok := c.sessions[key]
+	if !ok {
+		if len(c.sessions) >= maxSessions {
+			return 0
+		}
+		s = &sessionCount{}
+		c.sessions[key] = s
+	}
+	s.count++
+	s.seen = now
+	return s.count
+}
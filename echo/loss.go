@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package echo
+
+import (
+	"fmt"
+)
+
+// Loss is the packet loss measured in each direction over the last probes.
+type Loss struct {
+	// Sent is the number of probes the measure is based on.
+	Sent int
+	// Uplink is the fraction of probes lost toward the responder.
+	Uplink float64
+	// Downlink is the fraction of replies lost on the way back.
+	Downlink float64
+}
+
+// String describes the loss, e.g. "uplink loss 40%, downlink clean".
+func (l Loss) String() string {
+	return fmt.Sprintf("uplink %s, downlink %s", describe(l.Uplink), describe(l.Downlink))
+}
+
+func describe(loss float64) string {
+	if loss <= 0 {
+		return "clean"
+	}
+	return fmt.Sprintf("loss %.0f%%", loss*100)
+}
+
+// probeRecord is the outcome of one probe.
+type probeRecord struct {
+	seq     uint32
+	replied bool
+	count   uint32
+}
+
+// lossWindow keeps the outcome of the last probes of one stream, whose
+// sequence numbers are consecutive.
+type lossWindow struct {
+	records []probeRecord
+	size    int
+}
+
+func (w *lossWindow) add(r probeRecord) {
+	w.records = append(w.records, r)
+	if len(w.records) > w.size {
+		w.records = w.records[len(w.records)-w.size:]
+	}
+}
+
+// loss computes the directional loss between the first and the last replied
+// probes of the window, since the responder count is only known from
+// replies. It is unknown if the responder does not count the session.
+func (w *lossWindow) loss() (Loss, bool) {
+	first, last := -1, -1
+	for i, r := range w.records {
+		if r.replied {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 || first == last || w.records[first].count == 0 || w.records[last].count == 0 {
+		return Loss{}, false
+	}
+	sent := int(w.records[last].seq - w.records[first].seq)
+	received := int(w.records[last].count - w.records[first].count)
+	replies := 0
+	for _, r := range w.records[first+1 : last+1] {
+		if r.replied {
+			replies++
+		}
+	}
+	l := Loss{Sent: sent}
+	if sent > 0 && received <= sent {
+		l.Uplink = 1 - float64(received)/float64(sent)
+	}
+	if received > 0 && replies <= received {
+		l.Downlink = 1 - float64(replies)/float64(received)
+	}
+	return l, true
+}
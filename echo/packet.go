@@ -0,0 +1,145 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package echo implements the probe protocol spoken between the tool and the
+// companion responder. A probe is a small fixed-size packet carrying a
+// sequence number and the client transmit time; the responder stamps it with
+// its own receive and transmit times and sends it back. Replies also carry
+// the number of requests the responder received from the client session, so
+// that loss can be measured separately in each direction, and the source
+// address the responder observed, so that the client can tell which path
+// its traffic actually takes.
+//
+// When a shared key is configured, every packet carries an HMAC-SHA256 tag
+// over its content, so replies cannot be forged by on-path middleboxes, and
+// the responder rejects stale or replayed requests.
+package echo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// Packet kinds.
+const (
+	KindRequest uint8 = 0
+	KindReply   uint8 = 1
+)
+
+// version is the protocol version.
+const version = 3
+
+// flagAuth marks an authenticated packet.
+const flagAuth = 0x01
+
+// PacketSize is the size of an encoded unauthenticated packet.
+const PacketSize = 64
+
+// TagSize is the size of the authentication tag appended to authenticated
+// packets (truncated HMAC-SHA256).
+const TagSize = 16
+
+var magic = [4]byte{'I', 'F', 'R', 'E'}
+
+var (
+	// ErrInvalidPacket is returned when decoding a malformed packet.
+	ErrInvalidPacket = errors.New("invalid echo packet")
+	// ErrAuthentication is returned when a packet is not authenticated with
+	// the expected key.
+	ErrAuthentication = errors.New("echo packet authentication failed")
+)
+
+// Packet is a probe request or reply.
+type Packet struct {
+	Kind uint8
+	Seq  uint32
+	// Sent is the client transmit time.
+	Sent time.Time
+	// Received is the responder receive time.
+	Received time.Time
+	// Replied is the responder transmit time.
+	Replied time.Time
+	// Session identifies the client instance.
+	Session uint32
+	// Count is the number of requests of the session received by the
+	// responder, including this one, or 0 if the responder does not count
+	// them: it only counts authenticated sessions.
+	Count uint32
+	// Observed is the client source address seen by the responder.
+	Observed net.IP
+}
+
+// Marshal encodes the packet, authenticating it if key is not empty.
+func (p *Packet) Marshal(key []byte) []byte {
+	b := make([]byte, PacketSize, PacketSize+TagSize)
+	copy(b[0:4], magic[:])
+	b[4] = version
+	b[5] = p.Kind
+	binary.BigEndian.PutUint32(b[8:12], p.Seq)
+	binary.BigEndian.PutUint64(b[16:24], uint64(unixNano(p.Sent)))
+	binary.BigEndian.PutUint64(b[24:32], uint64(unixNano(p.Received)))
+	binary.BigEndian.PutUint64(b[32:40], uint64(unixNano(p.Replied)))
+	binary.BigEndian.PutUint32(b[40:44], p.Session)
+	binary.BigEndian.PutUint32(b[44:48], p.Count)
+	if ip := p.Observed.To16(); ip != nil {
+		copy(b[48:64], ip)
+	}
+	if len(key) == 0 {
+		return b
+	}
+	b[6] |= flagAuth
+	return append(b, tag(key, b)...)
+}
+
+// Unmarshal decodes a packet. If key is not empty, the packet must be
+// authenticated with it.
+func (p *Packet) Unmarshal(b []byte, key []byte) error {
+	if len(b) < PacketSize || [4]byte(b[0:4]) != magic || b[4] != version {
+		return ErrInvalidPacket
+	}
+	if len(key) > 0 {
+		if b[6]&flagAuth == 0 || len(b) < PacketSize+TagSize {
+			return ErrAuthentication
+		}
+		if !hmac.Equal(b[PacketSize:PacketSize+TagSize], tag(key, b[:PacketSize])) {
+			return ErrAuthentication
+		}
+	}
+	p.Kind = b[5]
+	p.Seq = binary.BigEndian.Uint32(b[8:12])
+	p.Sent = fromUnixNano(int64(binary.BigEndian.Uint64(b[16:24])))
+	p.Received = fromUnixNano(int64(binary.BigEndian.Uint64(b[24:32])))
+	p.Replied = fromUnixNano(int64(binary.BigEndian.Uint64(b[32:40])))
+	p.Session = binary.BigEndian.Uint32(b[40:44])
+	p.Count = binary.BigEndian.Uint32(b[44:48])
+	p.Observed = nil
+	if ip := net.IP(b[48:64]); !ip.IsUnspecified() {
+		p.Observed = append(net.IP(nil), ip...)
+	}
+	return nil
+}
+
+// tag computes the authentication tag of an encoded packet header.
+func tag(key []byte, header []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header)
+	return mac.Sum(nil)[:TagSize]
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package echo
+
+import (
+	"sync"
+	"time"
+)
+
+// replayKey identifies a request by the fields its tag covers: a replayed
+// request is byte-for-byte identical to the original, whatever address it is
+// resent from, so it has the same session, sequence number and transmit
+// time. The source address is not covered by the tag and is left out.
+type replayKey struct {
+	session uint32
+	seq     uint32
+	sent    int64
+}
+
+// replayGuard rejects requests outside the freshness window and requests
+// already seen within it. Entries older than the window are dropped since
+// the freshness check alone rejects them.
+type replayGuard struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[replayKey]time.Time
+	swept  time.Time
+}
+
+func newReplayGuard(window time.Duration) *replayGuard {
+	return &replayGuard{window: window, seen: make(map[replayKey]time.Time)}
+}
+
+// accept reports whether the request is fresh and seen for the first time.
+func (g *replayGuard) accept(p *Packet, now time.Time) bool {
+	if d := now.Sub(p.Sent); d > g.window || d < -g.window {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.swept) > g.window {
+		for k, t := range g.seen {
+			if now.Sub(t) > 2*g.window {
+				delete(g.seen, k)
+			}
+		}
+		g.swept = now
+	}
+	key := replayKey{session: p.Session, seq: p.Seq, sent: p.Sent.UnixNano()}
+	if _, ok := g.seen[key]; ok {
+		return false
+	}
+	g.seen[key] = now
+	return true
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package echo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/throttle"
+)
+
+// The first rejectedBurst rejected requests are logged, then one per
+// rejectedInterval.
+const (
+	rejectedBurst    = 5
+	rejectedInterval = time.Minute
+)
+
+// Responder answers probes over UDP, TCP and HTTP. It is meant to run on a
+// server the operator controls, as the far-end probe target.
+type Responder struct {
+	// UDP, TCP and HTTP are the listen addresses; empty disables the
+	// corresponding listener.
+	UDP  string
+	TCP  string
+	HTTP string
+	// Key, when not empty, is required on every request and used to
+	// authenticate replies.
+	Key []byte
+	// Window is the maximum clock difference accepted on authenticated
+	// requests. Replays are rejected within the window.
+	Window time.Duration
+
+	replay   *replayGuard
+	sessions *sessionCounter
+	// rejected limits the warnings on rejected requests, which any sender
+	// can trigger.
+	rejected throttle.Limiter
+}
+
+// Run starts the configured listeners and blocks until one of them fails.
+func (r *Responder) Run() error {
+	if len(r.Key) > 0 {
+		if r.Window <= 0 {
+			r.Window = 30 * time.Second
+		}
+		r.replay = newReplayGuard(r.Window)
+		r.rejected = throttle.Limiter{Burst: rejectedBurst, Interval: rejectedInterval}
+		log.Info().Msgf("Requiring authenticated probes, replay window %s", r.Window)
+	}
+	r.sessions = newSessionCounter()
+	errs := make(chan error, 3)
+	started := 0
+	if r.UDP != "" {
+		conn, err := net.ListenPacket("udp", r.UDP)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Answering UDP probes on %s", conn.LocalAddr())
+		go func() { errs <- r.serveUDP(conn) }()
+		started++
+	}
+	if r.TCP != "" {
+		listener, err := net.Listen("tcp", r.TCP)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Answering TCP probes on %s", listener.Addr())
+		go func() { errs <- r.serveTCP(listener) }()
+		started++
+	}
+	if r.HTTP != "" {
+		listener, err := net.Listen("tcp", r.HTTP)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Answering HTTP probes on %s", listener.Addr())
+		go func() { errs <- http.Serve(listener, http.HandlerFunc(r.serveHTTP)) }()
+		started++
+	}
+	if started == 0 {
+		return errors.New("no listener configured")
+	}
+	return <-errs
+}
+
+// answer turns a request from source into a reply. It returns nil if the
+// packet must be ignored.
+func (r *Responder) answer(b []byte, source net.Addr, received time.Time) []byte {
+	var p Packet
+	if err := p.Unmarshal(b, r.Key); err != nil || p.Kind != KindRequest {
+		return nil
+	}
+	if r.replay != nil && !r.replay.accept(&p, received) {
+		if streak, ok := r.rejected.Allow("rejected", received); ok {
+			log.Warn().Msgf("Rejecting stale or replayed probe %d from %s (%d more since the last warning)", p.Seq, source, streak.Suppressed)
+		}
+		return nil
+	}
+	p.Kind = KindReply
+	p.Count = 0
+	if r.replay != nil {
+		// Unauthenticated senders could fill the counter with made-up
+		// sessions, their requests are not counted.
+		p.Count = r.sessions.received(hostOf(source), p.Session, received)
+	}
+	p.Observed = net.ParseIP(hostOf(source))
+	p.Received = received
+	p.Replied = time.Now().UTC()
+	return p.Marshal(r.Key)
+}
+
+// hostOf returns the host part of addr.
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (r *Responder) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if reply := r.answer(buf[:n], addr, time.Now().UTC()); reply != nil {
+			conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func (r *Responder) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			size := PacketSize
+			if len(r.Key) > 0 {
+				size += TagSize
+			}
+			buf := make([]byte, size)
+			for {
+				conn.SetReadDeadline(time.Now().Add(time.Minute))
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				reply := r.answer(buf, conn.RemoteAddr(), time.Now().UTC())
+				if reply == nil {
+					return
+				}
+				if _, err := conn.Write(reply); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// ProbeHeader is the header of HTTP probes carrying an encoded request
+// packet, base64url-encoded. It is required when the responder has a key.
+const ProbeHeader = "X-Echo-Probe"
+
+// httpReply is the body returned to HTTP probes. Reply is the encoded reply
+// packet, base64url-encoded, when the probe carried a request packet.
+type httpReply struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Reply  string    `json:"reply,omitempty"`
+}
+
+// serveHTTP answers HTTP probes. With a key, the probe must carry an
+// authenticated request packet in ProbeHeader, checked like the UDP and TCP
+// ones, and the body then holds the authenticated reply.
+func (r *Responder) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	received := time.Now().UTC()
+	reply := httpReply{Time: received, Remote: req.RemoteAddr}
+	if header := req.Header.Get(ProbeHeader); header != "" || len(r.Key) > 0 {
+		b, _ := base64.RawURLEncoding.DecodeString(header)
+		answer := r.answer(b, httpAddr(req.RemoteAddr), received)
+		if answer == nil {
+			http.Error(w, "probe not authenticated", http.StatusUnauthorized)
+			return
+		}
+		reply.Reply = base64.RawURLEncoding.EncodeToString(answer)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// httpAddr is the remote address of an HTTP request, as a net.Addr.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
@@ -0,0 +1,210 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package echo
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"github.com/shynuu/if-reliability/netns"
+)
+
+// jitterGain is the weight of a new RTT variation in the smoothed jitter, as
+// in RFC 3550.
+const jitterGain = 1.0 / 16
+
+// Session streams requests to a responder over one socket, BFD-style: a
+// request goes out every Interval whatever the replies, and the path is down
+// once no reply arrived for Multiplier intervals, or while the smoothed
+// jitter is above MaxJitter if set. Detection thus takes Multiplier times
+// Interval, e.g. 300ms, instead of the probe rounds of the endpoints.
+type Session struct {
+	// Address is the responder, as host:port.
+	Address string
+	// Interface is the interface the requests are bound to, the routing
+	// table deciding if empty.
+	Interface string
+	// Key authenticates the requests and replies if not empty.
+	Key        []byte
+	Interval   time.Duration
+	Multiplier int
+	MaxJitter  time.Duration
+	// Network is "udp" (the default), "udp4" or "udp6".
+	Network string
+	// Namespace is the network namespace the socket is opened in, the
+	// current one if empty.
+	Namespace string
+	// OnChange, if set, is called when the path goes down or comes back up,
+	// with the statistics at that time.
+	OnChange func(up bool, s Stats)
+
+	mu    sync.Mutex
+	stats Stats
+	up    bool
+	last  time.Time
+	// pending holds the transmit time of the requests not answered yet,
+	// by sequence number.
+	pending map[uint32]time.Time
+}
+
+// Stats are the statistics of a session.
+type Stats struct {
+	Sent     uint64
+	Received uint64
+	// RTT is the RTT of the last reply, excluding the time spent in the
+	// responder, and Jitter the smoothed variation between consecutive
+	// RTTs.
+	RTT    time.Duration
+	Jitter time.Duration
+}
+
+// Loss returns the share of requests not answered.
+func (s Stats) Loss() float64 {
+	if s.Sent == 0 || s.Received >= s.Sent {
+		return 0
+	}
+	return 1 - float64(s.Received)/float64(s.Sent)
+}
+
+// Stats returns the statistics of the session so far.
+func (s *Session) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Run streams requests until done is closed. The path is considered up at
+// start, so that a path never answering is reported down after the
+// detection time. A socket that cannot be opened, e.g. while the interface
+// has no address, counts as unanswered requests and is opened again on the
+// next interval.
+func (s *Session) Run(done <-chan struct{}) {
+	b := make([]byte, 4)
+	rand.Read(b)
+	session := binary.BigEndian.Uint32(b) | 1
+	network := s.Network
+	if network == "" {
+		network = "udp"
+	}
+	s.mu.Lock()
+	s.up, s.last = true, time.Now()
+	s.pending = make(map[uint32]time.Time)
+	s.mu.Unlock()
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	var seq uint32
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if conn == nil {
+			dialer := net.Dialer{Timeout: s.Interval, Control: bind.Control(s.Interface)}
+			netns.Do(s.Namespace, func() error {
+				c, err := dialer.Dial(network, s.Address)
+				conn = c
+				return err
+			})
+			if conn != nil {
+				go s.receive(conn, session)
+			}
+		}
+		seq++
+		request := Packet{Kind: KindRequest, Seq: seq, Session: session, Sent: time.Now()}
+		if conn != nil {
+			if _, err := conn.Write(request.Marshal(s.Key)); err != nil {
+				// The route or the address may have changed: open
+				// the socket again.
+				conn.Close()
+				conn = nil
+			}
+		}
+		s.mu.Lock()
+		s.stats.Sent++
+		s.pending[seq] = request.Sent
+		for n, sent := range s.pending {
+			if request.Sent.Sub(sent) > s.detection() {
+				delete(s.pending, n)
+			}
+		}
+		s.mu.Unlock()
+		s.judge()
+	}
+}
+
+// detection returns the time without replies after which the path is down.
+// Replies to requests older than that are stale.
+func (s *Session) detection() time.Duration {
+	return time.Duration(s.Multiplier) * s.Interval
+}
+
+// receive reads the replies of conn until it is closed. A reply only counts
+// if it answers a request still pending, with the transmit time it was sent
+// with: a reply captured and resent later, even a signed one, matches no
+// pending request.
+func (s *Session) receive(conn net.Conn, session uint32) {
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			// An ICMP port unreachable only loses this reply.
+			continue
+		}
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		var reply Packet
+		if err := reply.Unmarshal(buf[:n], s.Key); err != nil || reply.Kind != KindReply || reply.Session != session {
+			continue
+		}
+		s.mu.Lock()
+		sent, ok := s.pending[reply.Seq]
+		if !ok || !sent.Equal(reply.Sent) || now.Sub(sent) > s.detection() {
+			s.mu.Unlock()
+			continue
+		}
+		delete(s.pending, reply.Seq)
+		reply.Sent = sent
+		rtt := reply.RTT(now)
+		s.stats.Received++
+		if s.stats.RTT > 0 {
+			variation := math.Abs(float64(rtt - s.stats.RTT))
+			s.stats.Jitter += time.Duration((variation - float64(s.stats.Jitter)) * jitterGain)
+		}
+		s.stats.RTT = rtt
+		s.last = now
+		s.mu.Unlock()
+		s.judge()
+	}
+}
+
+// judge updates the state of the path, calling OnChange if it changed.
+func (s *Session) judge() {
+	s.mu.Lock()
+	silent := time.Since(s.last) > s.detection()
+	jittery := s.MaxJitter > 0 && s.stats.Jitter > s.MaxJitter
+	up := !silent && !jittery
+	changed := up != s.up
+	s.up = up
+	stats := s.stats
+	s.mu.Unlock()
+	if changed && s.OnChange != nil {
+		s.OnChange(up, stats)
+	}
+}
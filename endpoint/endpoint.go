@@ -0,0 +1,113 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package endpoint parses probe endpoint definitions.
+//
+// An endpoint is an IP address, a host name or a URL, optionally followed by
+// "%" and the name of the interface the probe must be bound to:
+//
+//	8.8.8.8
+//	8.8.8.8%wwan0
+//	https://health.example.com%wlan0
+package endpoint
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maxInterfaceName is the maximum length of a Linux interface name (IFNAMSIZ - 1).
+const maxInterfaceName = 15
+
+// Endpoint is a parsed probe endpoint.
+type Endpoint struct {
+	// Address is the endpoint as written, without the interface suffix.
+	Address string
+	// Host is the IP address or host name to probe.
+	Host string
+	// Interface is the interface the probe is bound to, or empty to follow
+	// the routing table.
+	Interface string
+	// URL is set when the endpoint is a URL.
+	URL *url.URL
+}
+
+// Parse parses an endpoint definition.
+func Parse(s string) (Endpoint, error) {
+	address, ifname := splitInterface(s)
+	if address == "" {
+		return Endpoint{}, fmt.Errorf("invalid endpoint: %q", s)
+	}
+	e := Endpoint{Address: address, Host: address, Interface: ifname}
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return Endpoint{}, fmt.Errorf("invalid endpoint URL %q: %s", address, err)
+		}
+		if u.Hostname() == "" {
+			return Endpoint{}, fmt.Errorf("endpoint URL %q has no host", address)
+		}
+		e.URL = u
+		e.Host = u.Hostname()
+	}
+	return e, nil
+}
+
+// ParseList parses several endpoint definitions.
+func ParseList(list []string) ([]Endpoint, error) {
+	endpoints := make([]Endpoint, 0, len(list))
+	for _, s := range list {
+		e, err := Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+// Bind returns a copy of e bound to ifname unless it is already bound to an
+// interface.
+func (e Endpoint) Bind(ifname string) Endpoint {
+	if e.Interface == "" {
+		e.Interface = ifname
+	}
+	return e
+}
+
+// String returns the endpoint in its definition syntax.
+func (e Endpoint) String() string {
+	if e.Interface == "" {
+		return e.Address
+	}
+	return e.Address + "%" + e.Interface
+}
+
+// splitInterface splits the "%ifname" suffix from s. A suffix that cannot be
+// an interface name (it contains URL delimiters or is a percent-encoded byte)
+// is left as part of the address.
+func splitInterface(s string) (string, string) {
+	i := strings.LastIndex(s, "%")
+	if i < 0 {
+		return s, ""
+	}
+	ifname := s[i+1:]
+	if ifname == "" || len(ifname) > maxInterfaceName || strings.ContainsAny(ifname, "/:?#[]@ ") || isEscape(ifname) {
+		return s, ""
+	}
+	return s[:i], ifname
+}
+
+// isEscape reports whether s is a percent-encoded byte such as "2F".
+func isEscape(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
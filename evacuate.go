@@ -0,0 +1,39 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// init registers the evacuate command.
+func init() {
+	evacuateCmd.Flags().String("socket", reliability.DefaultTriggerSocket, "Trigger socket of the running instance")
+	evacuateCmd.Flags().Duration("timeout", 5*time.Minute, "Maximum time established flows are given to finish before switching")
+	rootCmd.AddCommand(evacuateCmd)
+}
+
+var evacuateCmd = &cobra.Command{
+	Use:   "evacuate <interface|primary>",
+	Short: "Gracefully move traffic off a link for planned maintenance",
+	Long: "Ask the running instance to evacuate a link: new flows are routed through the backup link at once, " +
+		"established flows are given until the timeout to finish, then the switch completes.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		socket, _ := cmd.Flags().GetString("socket")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		event := dispatcher.Event{Interface: args[0], Action: dispatcher.ActionEvacuate, Timeout: timeout}
+		if err := dispatcher.Send(socket, event); err != nil {
+			log.Error().Msgf("Error contacting the running instance: %s", err)
+			os.Exit(1)
+		}
+		log.Info().Msgf("Requested evacuation of %s, draining for up to %s", args[0], timeout)
+	},
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package eventlog appends the significant events of the monitor to a file,
+// one JSON object per line, for log shippers to index and incidents to be
+// reconstructed from. The field names are stable: new fields may be added,
+// existing ones are neither renamed nor given another meaning.
+package eventlog
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/diagnosis"
+	"github.com/shynuu/if-reliability/persist"
+)
+
+// Event kinds.
+const (
+	// KindProbe is a probe result.
+	KindProbe = "probe"
+	// KindTransition is a transition of the failover state machine.
+	KindTransition = "transition"
+	// KindSwitch is the traffic moving from one link to another, with the
+	// system changes made.
+	KindSwitch = "switch"
+	// KindCommand is a run of an external program or of an in-process
+	// operation, e.g. a route change over netlink.
+	KindCommand = "command"
+	// KindDecision is a decision of the monitor, e.g. refusing a weak
+	// WiFi link.
+	KindDecision = "decision"
+	// KindError is an error logged by the monitor.
+	KindError = "error"
+	// KindDiagnosis is the diagnosis of a link that reached the failure
+	// threshold.
+	KindDiagnosis = "diagnosis"
+)
+
+// Event is one line of the log. Only the fields of its kind are set.
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Interface is the link the event is about, empty for the default
+	// route.
+	Interface string `json:"interface,omitempty"`
+
+	// Endpoint, Success and RTT describe a KindProbe event, OutageID the
+	// outage it was part of.
+	Endpoint  string  `json:"endpoint,omitempty"`
+	Success   *bool   `json:"success,omitempty"`
+	RTTMillis float64 `json:"rtt_ms,omitempty"`
+	OutageID  string  `json:"outage_id,omitempty"`
+
+	// From, To and Reason describe a KindTransition event, states, or a
+	// KindSwitch event, links, with the changes made.
+	From    string           `json:"from,omitempty"`
+	To      string           `json:"to,omitempty"`
+	Reason  string           `json:"reason,omitempty"`
+	Changes []changes.Change `json:"changes,omitempty"`
+
+	// Program, Args and Duration describe a KindCommand event, Error its
+	// failure.
+	Program        string   `json:"program,omitempty"`
+	Args           []string `json:"args,omitempty"`
+	DurationMillis float64  `json:"duration_ms,omitempty"`
+	Error          string   `json:"error,omitempty"`
+
+	// Message describes a KindDecision or KindError event.
+	Message string `json:"message,omitempty"`
+
+	// Hops, Link and SignalDBM describe a KindDiagnosis event: the
+	// traceroute toward Endpoint, the snapshot of the interface and the
+	// WiFi signal, Message summing them up and Error telling what could not
+	// be gathered.
+	Hops      []diagnosis.Hop `json:"hops,omitempty"`
+	Link      *diagnosis.Link `json:"link,omitempty"`
+	SignalDBM int             `json:"signal_dbm,omitempty"`
+}
+
+// Millis returns d in milliseconds.
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Log appends events to a file.
+type Log struct {
+	out *persist.Appender
+}
+
+// Open opens the log at path, created if needed and appended to otherwise.
+// Events are batched in memory and written out according to policy.
+func Open(path string, policy persist.Policy) (*Log, error) {
+	out, err := persist.OpenAppender(path, policy)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{out: out}, nil
+}
+
+// Write appends e, timestamped now if its time is not set. Writing to a nil
+// log does nothing. Each event is buffered whole and the buffer is written
+// at once, so that a shipper tailing the file never reads part of one.
+func (l *Log) Write(e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = l.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close writes out the buffered events and closes the file.
+func (l *Log) Close() error {
+	return l.out.Close()
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package firewall renders the firewall rules following the egress
+// interface, e.g. the NAT of the LAN, and the commands removing them.
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Egress is the data of the rule templates.
+type Egress struct {
+	// Interface is the interface the traffic leaves through.
+	Interface string
+	// Previous is the interface it left through before, empty at startup.
+	Previous string
+}
+
+// Rule is a rule template: an iptables, ip6tables or nft command adding a
+// rule, e.g. "iptables -t nat -A POSTROUTING -s 192.168.1.0/24 -o
+// {{.Interface}} -j MASQUERADE".
+type Rule struct {
+	text     string
+	template *template.Template
+}
+
+// families are the nftables address families.
+var families = map[string]bool{"ip": true, "ip6": true, "inet": true, "arp": true, "bridge": true, "netdev": true}
+
+// Parse parses a rule template.
+func Parse(text string) (Rule, error) {
+	t, err := template.New("rule").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return Rule{}, err
+	}
+	r := Rule{text: text, template: t}
+	command, err := r.Command(Egress{Interface: "eth0", Previous: "wlan0"})
+	if err != nil {
+		return Rule{}, err
+	}
+	if _, err := Undo(command, "# handle 1"); err != nil {
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+// String returns the template.
+func (r Rule) String() string {
+	return r.text
+}
+
+// Command renders the command adding the rule toward e. nft commands echo
+// the handle of the rule, needed to delete it.
+func (r Rule) Command(e Egress) ([]string, error) {
+	var b bytes.Buffer
+	if err := r.template.Execute(&b, e); err != nil {
+		return nil, err
+	}
+	command, err := split(b.String())
+	if err != nil {
+		return nil, err
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	if command[0] == "nft" {
+		command = append([]string{"nft", "--echo", "--handle"}, command[1:]...)
+	}
+	return command, nil
+}
+
+// handlePattern matches the handle nft echoes for an added rule.
+var handlePattern = regexp.MustCompile(`# handle (\d+)`)
+
+// Undo returns the command deleting the rule added by command, given its
+// output.
+func Undo(command []string, output string) ([]string, error) {
+	switch command[0] {
+	case "iptables", "ip6tables":
+		for i, arg := range command {
+			if arg != "-A" && arg != "-I" && arg != "--append" && arg != "--insert" {
+				continue
+			}
+			if i+1 >= len(command) {
+				break
+			}
+			rest := command[i+2:]
+			// An insertion may give the position of the rule.
+			if (arg == "-I" || arg == "--insert") && len(rest) > 0 && isNumber(rest[0]) {
+				rest = rest[1:]
+			}
+			deletion := append(append(append([]string{}, command[:i]...), "-D", command[i+1]), rest...)
+			return deletion, nil
+		}
+		return nil, fmt.Errorf("%s command without -A or -I: %s", command[0], strings.Join(command, " "))
+	case "nft":
+		args := command[3:]
+		if len(args) < 4 || (args[0] != "add" && args[0] != "insert") || args[1] != "rule" {
+			return nil, fmt.Errorf("nft command not adding a rule: %s", strings.Join(command, " "))
+		}
+		location := args[2:]
+		family := "ip"
+		if families[location[0]] {
+			family, location = location[0], location[1:]
+		}
+		if len(location) < 2 {
+			return nil, fmt.Errorf("nft rule without table and chain: %s", strings.Join(command, " "))
+		}
+		match := handlePattern.FindStringSubmatch(output)
+		if match == nil {
+			return nil, fmt.Errorf("no handle echoed for %s", strings.Join(command, " "))
+		}
+		return []string{"nft", "delete", "rule", family, location[0], location[1], "handle", match[1]}, nil
+	}
+	return nil, fmt.Errorf("unsupported program %s, expected iptables, ip6tables or nft", command[0])
+}
+
+// isNumber reports whether s is a decimal number.
+func isNumber(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// split splits a command into words, honoring single and double quotes.
+func split(text string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, c := range text {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", text)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package fsm is the state machine driving the failover: the states the tool
+// goes through, the transitions allowed between them, and the hooks run on
+// each transition.
+package fsm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is a state of the failover.
+type State string
+
+const (
+	// MonitoringPrimary probes the primary link carrying the traffic.
+	MonitoringPrimary State = "monitoring-primary"
+	// FailingOver connects the backup link and moves the routes to it.
+	FailingOver State = "failing-over"
+	// OnBackup verifies connectivity over the backup link carrying the
+	// traffic.
+	OnBackup State = "on-backup"
+	// Recovering probes the primary link until it can be failed back to.
+	Recovering State = "recovering"
+	// FailingBack moves the traffic back to the primary link.
+	FailingBack State = "failing-back"
+	// Stopped is the final state.
+	Stopped State = "stopped"
+)
+
+// allowed lists the states each state may transition to.
+var allowed = map[State][]State{
+	MonitoringPrimary: {FailingOver, Stopped},
+	FailingOver:       {OnBackup, MonitoringPrimary, Stopped},
+	OnBackup:          {Recovering, FailingBack, Stopped},
+	Recovering:        {FailingBack, Stopped},
+	FailingBack:       {MonitoringPrimary, Stopped},
+}
+
+// Transition is a change of state.
+type Transition struct {
+	From   State
+	To     State
+	Reason string
+	Time   time.Time
+}
+
+// String renders the transition, e.g.
+// "monitoring-primary -> failing-over (5 consecutive failures)".
+func (t Transition) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", t.From, t.To, t.Reason)
+}
+
+// Hook is run on a transition.
+type Hook func(Transition)
+
+// Machine holds the current state and the hooks. Its methods may be called
+// from several goroutines.
+type Machine struct {
+	mu    sync.Mutex
+	state State
+	since time.Time
+	hooks map[State][]Hook
+	any   []Hook
+}
+
+// New returns a machine in the initial state.
+func New(initial State) *Machine {
+	return &Machine{state: initial, since: time.Now(), hooks: map[State][]Hook{}}
+}
+
+// State returns the current state and since when the machine is in it.
+func (m *Machine) State() (State, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.since
+}
+
+// On registers hook to be run on every transition to state.
+func (m *Machine) On(state State, hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks[state] = append(m.hooks[state], hook)
+}
+
+// OnAny registers hook to be run on every transition.
+func (m *Machine) OnAny(hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.any = append(m.any, hook)
+}
+
+// Transition moves the machine to state for reason, then runs the hooks
+// registered for any transition followed by the ones registered for state,
+// in registration order. It fails if the transition is not allowed.
+func (m *Machine) Transition(state State, reason string) error {
+	m.mu.Lock()
+	if !Allowed(m.state, state) {
+		from := m.state
+		m.mu.Unlock()
+		return fmt.Errorf("transition from %s to %s not allowed", from, state)
+	}
+	t := Transition{From: m.state, To: state, Reason: reason, Time: time.Now()}
+	m.state = state
+	m.since = t.Time
+	hooks := append(append([]Hook{}, m.any...), m.hooks[state]...)
+	m.mu.Unlock()
+	for _, hook := range hooks {
+		hook(t)
+	}
+	return nil
+}
+
+// Parse returns the state named name.
+func Parse(name string) (State, error) {
+	switch s := State(name); s {
+	case MonitoringPrimary, FailingOver, OnBackup, Recovering, FailingBack, Stopped:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown state %q", name)
+}
+
+// Allowed reports whether the machine may go from one state to another.
+func Allowed(from, to State) bool {
+	for _, s := range allowed[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
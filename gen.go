@@ -0,0 +1,66 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/dashboards"
+	"github.com/spf13/cobra"
+)
+
+// init registers the gen command and its subcommands.
+func init() {
+	genDashboardsCmd.Flags().StringP("output-dir", "o", ".", "Directory the files are written to")
+	genDashboardsCmd.Flags().String("job", "if-reliability", "Prometheus job name scraping the tool")
+	genDashboardsCmd.Flags().Float64("max-rtt", 0.3, "p95 RTT in seconds above which the high latency alert fires")
+	genDashboardsCmd.Flags().Int("max-failures", 3, "Consecutive probe failures above which the probe alert fires")
+	genDashboardsCmd.Flags().Duration("backup-max-age", 7*24*time.Hour, "Age of the last backup verification above which the unverified backup alert fires")
+	genCmd.AddCommand(genDashboardsCmd)
+	rootCmd.AddCommand(genCmd)
+}
+
+var genCmd = &cobra.Command{
+	Use:   "gen",
+	Short: "Generate integration files",
+}
+
+var genDashboardsCmd = &cobra.Command{
+	Use:   "dashboards",
+	Short: "Generate a Grafana dashboard and Prometheus alerting rules",
+	Long:  "Generate a Grafana dashboard (grafana-dashboard.json) and Prometheus alerting rules (prometheus-alerts.yml) matching the exported metrics.",
+	Run: func(cmd *cobra.Command, args []string) {
+		outputDir, _ := cmd.Flags().GetString("output-dir")
+		job, _ := cmd.Flags().GetString("job")
+		maxRTT, _ := cmd.Flags().GetFloat64("max-rtt")
+		maxFailures, _ := cmd.Flags().GetInt("max-failures")
+		backupMaxAge, _ := cmd.Flags().GetDuration("backup-max-age")
+		opts := dashboards.Options{Job: job, MaxRTT: maxRTT, MaxFailures: maxFailures, BackupMaxAge: backupMaxAge}
+
+		dashboard, err := dashboards.Grafana(opts)
+		if err != nil {
+			log.Error().Msgf("Error generating Grafana dashboard: %s", err)
+			os.Exit(1)
+		}
+		rules, err := dashboards.AlertRules(opts)
+		if err != nil {
+			log.Error().Msgf("Error generating alerting rules: %s", err)
+			os.Exit(1)
+		}
+		for name, data := range map[string][]byte{
+			"grafana-dashboard.json": dashboard,
+			"prometheus-alerts.yml":  rules,
+		} {
+			path := filepath.Join(outputDir, name)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				log.Error().Msgf("Error writing %s: %s", path, err)
+				os.Exit(1)
+			}
+			log.Info().Msgf("Wrote %s", path)
+		}
+	},
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/sdnotify"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// groupRestartDelay is the time before a group whose monitor failed is
+// started again.
+const groupRestartDelay = 5 * time.Second
+
+// superviseGroups lifts the requirements of the flags of the monitor, set in
+// the sections of the groups and checked by their monitors, from the
+// command, which monitors no interface itself.
+func superviseGroups(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		delete(f.Annotations, cobra.BashCompOneRequiredFlag)
+	})
+}
+
+// groupConfig returns the configuration of the monitor of group, read from
+// flags with its section, with the logger of the command and SIGHUP reading
+// it again. The command notifies systemd for every group, so that the
+// monitor only restores the primary link on exit, as in daemon mode.
+func groupConfig(flags *pflag.FlagSet, group string) (reliability.Config, error) {
+	config, err := reliability.GroupConfig(flags, group)
+	if err != nil {
+		return reliability.Config{}, err
+	}
+	config.Logger = &log.Logger
+	config.Daemon, config.RestoreOnExit = false, config.RestoreOnExit || config.Daemon
+	config.Reload = func() (reliability.Config, error) { return groupConfig(flags, group) }
+	return config, nil
+}
+
+// runGroup runs the monitor of group until ctx is done, starting it again
+// after groupRestartDelay whenever it stops or fails.
+func runGroup(ctx context.Context, flags *pflag.FlagSet, group string) {
+	for {
+		config, err := groupConfig(flags, group)
+		if err == nil {
+			var m *reliability.Manager
+			if m, err = reliability.NewManager(config); err == nil {
+				log.Info().Msgf("Starting the monitor of group %s", group)
+				err = m.Run(ctx)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			log.Warn().Msgf("Monitor of group %s stopped, starting it again in %s", group, groupRestartDelay)
+		} else {
+			log.Error().Msgf("Monitor of group %s failed: %s, starting it again in %s", group, err, groupRestartDelay)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(groupRestartDelay):
+		}
+	}
+}
+
+// runGroups runs the monitor of each of the groups with a manager of its
+// own, so that their states, routes and sockets stay apart, until SIGINT or
+// SIGTERM. Each monitor handles SIGHUP, SIGUSR1 and SIGUSR2.
+func runGroups(cmd *cobra.Command, groups []string) {
+	logger, err := reliability.NewLogger(monitorConfig)
+	if err != nil {
+		log.Error().Msgf("Cannot monitor: setting up the logs: %s", err)
+		os.Exit(1)
+	}
+	log.Logger = logger
+	notify := func(states ...string) {
+		if !monitorConfig.Daemon {
+			return
+		}
+		if _, err := sdnotify.Notify(states...); err != nil {
+			log.Warn().Msgf("Error notifying systemd: %s", err)
+		}
+	}
+	ctx := interruptContext()
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+			runGroup(ctx, cmd.Flags(), group)
+		}(group)
+	}
+	notify(sdnotify.Ready, sdnotify.Status(fmt.Sprintf("Monitoring the groups %s", strings.Join(groups, ", "))))
+	log.Info().Msgf("Monitoring the groups %s", strings.Join(groups, ", "))
+	<-ctx.Done()
+	notify(sdnotify.Stopping)
+	wg.Wait()
+	log.Info().Msg("Every group stopped, exiting")
+}
+
+// groupStatus is the state of the monitor of a group.
+type groupStatus struct {
+	Group  string          `json:"group"`
+	Socket string          `json:"socket"`
+	Status *control.Status `json:"status,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// init registers the groups command.
+func init() {
+	groupsCmd.Flags().String("config", "", "Configuration file defining the groups, as --config of the monitor (required)")
+	groupsCmd.Flags().Duration("timeout", 2*reliability.CommandTimeout, "Time to wait for the monitor of each group to answer")
+	groupsCmd.Flags().Bool("json", false, "Print the states as JSON")
+	groupsCmd.MarkFlagRequired("config")
+	rootCmd.AddCommand(groupsCmd)
+}
+
+var groupsCmd = &cobra.Command{
+	Use:   "groups",
+	Short: "Show the state of every group of the running instance",
+	Long: "List the groups the configuration file defines and the state of the monitor of each, " +
+		"asked over its control socket. status --group <name> shows one group in detail.",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, _ := cmd.Flags().GetString("config")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		sockets, err := reliability.GroupSockets(path)
+		if err != nil {
+			log.Error().Msgf("Invalid configuration file %s", err)
+			os.Exit(1)
+		}
+		if len(sockets) == 0 {
+			log.Error().Msgf("%s defines no groups", path)
+			os.Exit(1)
+		}
+		names := make([]string, 0, len(sockets))
+		for name := range sockets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		statuses := make([]groupStatus, len(names))
+		for i, name := range names {
+			statuses[i] = groupStatus{Group: name, Socket: sockets[name]}
+			status, err := control.NewClient(statuses[i].Socket, "", "", timeout).Status()
+			if err != nil {
+				statuses[i].Error = err.Error()
+				continue
+			}
+			statuses[i].Status = &status
+		}
+		if raw, _ := cmd.Flags().GetBool("json"); raw {
+			printJSON(statuses)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "GROUP\tSTATE\tACTIVE LINK\tSINCE")
+		for _, s := range statuses {
+			if s.Status == nil {
+				fmt.Fprintf(w, "%s\tunreachable\t-\t%s\n", s.Group, s.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Group, s.Status.State, s.Status.ActiveLink, timefmt.Format(s.Status.Since))
+		}
+		w.Flush()
+	},
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package health combines the built-in probes with health signals pushed by
+// external agents, e.g. an application reporting 30% request failures over
+// a link. Each input has a weight, and a link is unhealthy when the weighted
+// mean of the failure ratios reaches one half.
+package health
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signal is a health report from an external source about a link.
+type Signal struct {
+	Source string
+	Link   string
+	// Failure is the failure ratio observed by the source, between 0 and 1.
+	Failure float64
+	// Weight is the weight of the signal relative to the probes.
+	Weight  float64
+	Expires time.Time
+}
+
+// Inputs holds the signals in effect. ProbeWeight must be set before use.
+type Inputs struct {
+	// ProbeWeight is the weight of the built-in probe result.
+	ProbeWeight float64
+
+	mu      sync.Mutex
+	signals map[string]Signal
+}
+
+// Validate checks that s can be used.
+func (s Signal) Validate() error {
+	switch {
+	case s.Source == "":
+		return fmt.Errorf("missing source")
+	case s.Failure < 0 || s.Failure > 1:
+		return fmt.Errorf("failure ratio %v outside [0, 1]", s.Failure)
+	case s.Weight < 0:
+		return fmt.Errorf("negative weight %v", s.Weight)
+	}
+	return nil
+}
+
+// Set records s, replacing the previous signal of the same source about the
+// same link.
+func (in *Inputs) Set(s Signal) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.signals == nil {
+		in.signals = map[string]Signal{}
+	}
+	in.signals[s.Source+"\x00"+s.Link] = s
+}
+
+// active returns the unexpired signals about link, dropping the expired ones.
+func (in *Inputs) active(link string, now time.Time) []Signal {
+	var signals []Signal
+	for key, s := range in.signals {
+		if now.After(s.Expires) {
+			delete(in.signals, key)
+			continue
+		}
+		if s.Link == link {
+			signals = append(signals, s)
+		}
+	}
+	sort.Slice(signals, func(i, j int) bool { return signals[i].Source < signals[j].Source })
+	return signals
+}
+
+// Failure returns the weighted failure ratio of link given the result of
+// the built-in probe.
+func (in *Inputs) Failure(link string, probeFailed bool, now time.Time) float64 {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	total, failure := in.ProbeWeight, 0.0
+	if probeFailed {
+		failure = in.ProbeWeight
+	}
+	for _, s := range in.active(link, now) {
+		total += s.Weight
+		failure += s.Weight * s.Failure
+	}
+	if total == 0 {
+		return 0
+	}
+	return failure / total
+}
+
+// Unhealthy reports whether link is unhealthy given the result of the
+// built-in probe.
+func (in *Inputs) Unhealthy(link string, probeFailed bool, now time.Time) bool {
+	return in.Failure(link, probeFailed, now) >= 0.5
+}
+
+// Describe lists the signals in effect about link, e.g. for logs.
+func (in *Inputs) Describe(link string, now time.Time) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var parts []string
+	for _, s := range in.active(link, now) {
+		parts = append(parts, fmt.Sprintf("%s %.0f%% failures (weight %v)", s.Source, s.Failure*100, s.Weight))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shynuu/if-reliability/eventlog"
+)
+
+// EndpointStats summarizes the history of one endpoint.
+type EndpointStats struct {
+	Endpoint     string        `json:"endpoint"`
+	Samples      int           `json:"samples"`
+	SuccessRatio float64       `json:"success_ratio"`
+	MeanRTT      time.Duration `json:"-"`
+	StdDevRTT    time.Duration `json:"-"`
+	// MeanMillis and StdDevMillis are MeanRTT and StdDevRTT in
+	// milliseconds.
+	MeanMillis   float64 `json:"mean_rtt_ms"`
+	StdDevMillis float64 `json:"stddev_rtt_ms"`
+	Failures     int     `json:"failures"`
+	// Correlated is the number of failures that happened during a
+	// confirmed outage.
+	Correlated int `json:"correlated_failures"`
+	// Correlation is the fraction of failures that happened during a
+	// confirmed outage, 1 if the endpoint never failed.
+	Correlation float64 `json:"correlation"`
+}
+
+// Noisy reports whether the endpoint fails mostly outside confirmed outages,
+// i.e. its failures do not reflect user-visible impact.
+func (s EndpointStats) Noisy() bool {
+	return s.Failures > 0 && s.Correlation < 0.5
+}
+
+// Analyze computes per-endpoint statistics. An outage is confirmed when it
+// lasted at least minOutage or when more than one endpoint failed during it;
+// failures during short outages seen by a single endpoint are considered
+// noise. The result is sorted from the most to the least recommended
+// endpoint: highest correlation first, then lowest RTT variance.
+func Analyze(samples []Sample, minOutage time.Duration) []EndpointStats {
+	type outageInfo struct {
+		first, last time.Time
+		endpoints   map[string]bool
+	}
+	outages := map[string]*outageInfo{}
+	for _, s := range samples {
+		if s.OutageID == "" || s.Success {
+			continue
+		}
+		o, ok := outages[s.OutageID]
+		if !ok {
+			o = &outageInfo{first: s.Time, last: s.Time, endpoints: map[string]bool{}}
+			outages[s.OutageID] = o
+		}
+		if s.Time.Before(o.first) {
+			o.first = s.Time
+		}
+		if s.Time.After(o.last) {
+			o.last = s.Time
+		}
+		o.endpoints[s.Endpoint] = true
+	}
+	confirmed := func(id string) bool {
+		o, ok := outages[id]
+		return ok && (o.last.Sub(o.first) >= minOutage || len(o.endpoints) > 1)
+	}
+
+	type accumulator struct {
+		stats      EndpointStats
+		successes  int
+		sumRTT     float64
+		sumSquared float64
+	}
+	byEndpoint := map[string]*accumulator{}
+	for _, s := range samples {
+		if s.Grade != "" {
+			// Bufferbloat test result, not a probe.
+			continue
+		}
+		a, ok := byEndpoint[s.Endpoint]
+		if !ok {
+			a = &accumulator{stats: EndpointStats{Endpoint: s.Endpoint}}
+			byEndpoint[s.Endpoint] = a
+		}
+		a.stats.Samples++
+		if s.Success {
+			a.successes++
+			rtt := float64(s.RTT)
+			a.sumRTT += rtt
+			a.sumSquared += rtt * rtt
+			continue
+		}
+		a.stats.Failures++
+		if confirmed(s.OutageID) {
+			a.stats.Correlated++
+		}
+	}
+
+	result := make([]EndpointStats, 0, len(byEndpoint))
+	for _, a := range byEndpoint {
+		st := a.stats
+		st.SuccessRatio = float64(a.successes) / float64(st.Samples)
+		if a.successes > 0 {
+			mean := a.sumRTT / float64(a.successes)
+			variance := a.sumSquared/float64(a.successes) - mean*mean
+			st.MeanRTT = time.Duration(mean)
+			st.StdDevRTT = time.Duration(math.Sqrt(math.Max(variance, 0)))
+			st.MeanMillis, st.StdDevMillis = eventlog.Millis(st.MeanRTT), eventlog.Millis(st.StdDevRTT)
+		}
+		st.Correlation = 1
+		if st.Failures > 0 {
+			st.Correlation = float64(st.Correlated) / float64(st.Failures)
+		}
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Correlation != result[j].Correlation {
+			return result[i].Correlation > result[j].Correlation
+		}
+		return result[i].StdDevRTT < result[j].StdDevRTT
+	})
+	return result
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/persist"
+)
+
+// Event kinds.
+const (
+	// EventTransition is a transition of the failover state machine.
+	EventTransition = "transition"
+	// EventSwitch is the traffic moving from one link to another.
+	EventSwitch = "switch"
+)
+
+// Event is a state transition or a switch of link, kept in the archive
+// alongside the samples.
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// From and To are states for a transition, links for a switch.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Reason is why a transition happened, or the changes a switch made.
+	Reason string `json:"reason,omitempty"`
+}
+
+// record is one line of a segment of the archive.
+type record struct {
+	Sample *Sample `json:"sample,omitempty"`
+	Event  *Event  `json:"event,omitempty"`
+}
+
+// segmentLayout names the segments after their UTC day.
+const (
+	segmentLayout = "2006-01-02"
+	segmentExt    = ".jsonl"
+)
+
+// Archive keeps the samples and the events on disk across restarts and
+// reboots, appending them to one segment file per UTC day in a directory.
+// Segments older than the retention, and the oldest ones while the archive
+// outgrows its size limit, are deleted when a new day starts. Writes go
+// through a persist.Appender, so they reach the disk once per flush
+// interval.
+type Archive struct {
+	dir       string
+	retention time.Duration
+	maxSize   int64
+	policy    persist.Policy
+
+	mu  sync.Mutex
+	day string
+	out *persist.Appender
+}
+
+// OpenArchive opens the archive in dir, creating it if needed. A zero
+// retention or maxSize disables that limit.
+func OpenArchive(dir string, retention time.Duration, maxSize int64, policy persist.Policy) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	a := &Archive{dir: dir, retention: retention, maxSize: maxSize, policy: policy}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Add appends a sample.
+func (a *Archive) Add(s Sample) error {
+	return a.append(s.Time, record{Sample: &s})
+}
+
+// AddEvent appends an event.
+func (a *Archive) AddEvent(e Event) error {
+	return a.append(e.Time, record{Event: &e})
+}
+
+// Close flushes the pending records and closes the current segment.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out == nil {
+		return nil
+	}
+	err := a.out.Close()
+	a.out = nil
+	return err
+}
+
+// append appends r, recorded at t, to the segment of the current day.
+func (a *Archive) append(t time.Time, r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out == nil {
+		return errors.New("archive closed")
+	}
+	if t.UTC().Format(segmentLayout) > a.day {
+		if err := a.rotate(t); err != nil {
+			return err
+		}
+	}
+	_, err = a.out.Write(append(data, '\n'))
+	return err
+}
+
+// rotate moves to the segment of the day of now and applies the limits. The
+// caller holds the lock.
+func (a *Archive) rotate(now time.Time) error {
+	if a.out != nil {
+		if err := a.out.Close(); err != nil {
+			log.Error().Msgf("Error closing history segment %s: %s", a.day, err)
+		}
+		a.out = nil
+	}
+	a.day = now.UTC().Format(segmentLayout)
+	a.prune(now)
+	out, err := persist.OpenAppender(filepath.Join(a.dir, a.day+segmentExt), a.policy)
+	if err != nil {
+		return err
+	}
+	a.out = out
+	return nil
+}
+
+// prune deletes the segments before the day of now older than the retention,
+// then the oldest ones while the archive is larger than its size limit.
+func (a *Archive) prune(now time.Time) {
+	days, err := segments(a.dir)
+	if err != nil {
+		log.Warn().Msgf("Cannot list history segments: %s", err)
+		return
+	}
+	var size int64
+	sizes := map[string]int64{}
+	for _, day := range days {
+		if info, err := os.Stat(filepath.Join(a.dir, day+segmentExt)); err == nil {
+			sizes[day] = info.Size()
+			size += info.Size()
+		}
+	}
+	for _, day := range days {
+		if day >= a.day {
+			break
+		}
+		start, _ := time.Parse(segmentLayout, day)
+		expired := a.retention > 0 && now.Sub(start.AddDate(0, 0, 1)) > a.retention
+		if !expired && (a.maxSize == 0 || size <= a.maxSize) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(a.dir, day+segmentExt)); err != nil {
+			log.Warn().Msgf("Cannot delete history segment %s: %s", day, err)
+			continue
+		}
+		log.Info().Msgf("Deleted history segment %s", day)
+		size -= sizes[day]
+	}
+}
+
+// segments returns the days of the segments in dir, oldest first.
+func segments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, entry := range entries {
+		day, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(segmentLayout, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// Query reads the samples and the events recorded in the archive in dir from
+// from, included, to to, excluded, oldest first. It only sees the records
+// already flushed by a running instance. Lines cut short by a power loss are
+// skipped.
+func Query(dir string, from, to time.Time) ([]Sample, []Event, error) {
+	days, err := segments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var samples []Sample
+	var events []Event
+	in := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+	for _, day := range days {
+		start, _ := time.Parse(segmentLayout, day)
+		if !start.Before(to) || !start.AddDate(0, 0, 1).After(from) {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, day+segmentExt))
+		if err != nil {
+			return nil, nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var r record
+			if json.Unmarshal(scanner.Bytes(), &r) != nil {
+				continue
+			}
+			switch {
+			case r.Sample != nil && in(r.Sample.Time):
+				samples = append(samples, *r.Sample)
+			case r.Event != nil && in(r.Event.Time):
+				events = append(events, *r.Event)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading history segment %s: %w", day, err)
+		}
+	}
+	return samples, events, nil
+}
+
+// Archived is a Store keeping the samples in memory, where they are read
+// from, and in an archive.
+type Archived struct {
+	Store
+	Archive *Archive
+}
+
+// Add records a sample in memory and in the archive.
+func (a Archived) Add(s Sample) error {
+	if err := a.Store.Add(s); err != nil {
+		return err
+	}
+	return a.Archive.Add(s)
+}
+
+// Close closes the store and the archive.
+func (a Archived) Close() error {
+	return errors.Join(a.Store.Close(), a.Archive.Close())
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package history keeps a record of probe samples so that past connectivity
+// can be inspected after an incident.
+package history
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/shynuu/if-reliability/eventlog"
+)
+
+// Sample is a single probe result. Its RTTs are encoded in milliseconds, as
+// rtt_ms and loaded_rtt_ms.
+type Sample struct {
+	Time      time.Time     `json:"time"`
+	Endpoint  string        `json:"endpoint"`
+	Interface string        `json:"interface,omitempty"`
+	Success   bool          `json:"success"`
+	RTT       time.Duration `json:"-"`
+	OutageID  string        `json:"outage_id,omitempty"`
+	// LoadedRTT and Grade are set on bufferbloat test results, RTT then
+	// being the idle RTT.
+	LoadedRTT time.Duration `json:"-"`
+	Grade     string        `json:"grade,omitempty"`
+	// Hops is the distance of the reply estimated from its TTL, 0 if
+	// unknown. PathChange marks the sample confirming that replies come from
+	// a different distance, so that the RTT step it comes with is not taken
+	// for a link degradation.
+	Hops       int  `json:"hops,omitempty"`
+	PathChange bool `json:"path_change,omitempty"`
+}
+
+// sampleFields are the fields of a Sample encoded as is.
+type sampleFields Sample
+
+// encodedSample is the JSON encoding of a Sample. LegacyRTT and
+// LegacyLoadedRTT are the RTTs in nanoseconds of the records written before
+// they were encoded in milliseconds, still read.
+type encodedSample struct {
+	*sampleFields
+	RTTMillis       float64 `json:"rtt_ms"`
+	LoadedRTTMillis float64 `json:"loaded_rtt_ms,omitempty"`
+	LegacyRTT       int64   `json:"rtt,omitempty"`
+	LegacyLoadedRTT int64   `json:"loaded_rtt,omitempty"`
+}
+
+// MarshalJSON encodes s with its RTTs in milliseconds.
+func (s Sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal(encodedSample{
+		sampleFields:    (*sampleFields)(&s),
+		RTTMillis:       eventlog.Millis(s.RTT),
+		LoadedRTTMillis: eventlog.Millis(s.LoadedRTT),
+	})
+}
+
+// UnmarshalJSON decodes a sample encoded by MarshalJSON, or with its RTTs
+// in nanoseconds.
+func (s *Sample) UnmarshalJSON(data []byte) error {
+	e := encodedSample{sampleFields: (*sampleFields)(s)}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	s.RTT = fromMillis(e.RTTMillis) + time.Duration(e.LegacyRTT)
+	s.LoadedRTT = fromMillis(e.LoadedRTTMillis) + time.Duration(e.LegacyLoadedRTT)
+	return nil
+}
+
+// fromMillis converts a duration in milliseconds.
+func fromMillis(ms float64) time.Duration {
+	return time.Duration(math.Round(ms * float64(time.Millisecond)))
+}
+
+// Store is a history backend.
+type Store interface {
+	// Add records a sample.
+	Add(s Sample) error
+	// Samples returns the recorded samples newer than since, oldest first.
+	Samples(since time.Time) ([]Sample, error)
+	// Close flushes any pending data and releases the backend.
+	Close() error
+}
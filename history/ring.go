@@ -0,0 +1,179 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/persist"
+)
+
+// Ring is an in-memory history backend holding the last N samples. It never
+// touches the disk unless a snapshot path is configured, in which case the
+// buffer is written out periodically and reloaded at startup. This keeps
+// flash writes to one file per flush interval, and none at all while no new
+// samples arrive.
+type Ring struct {
+	mu      sync.Mutex
+	samples []Sample
+	next    int
+	full    bool
+	// changes counts the samples added, saved those in the last
+	// snapshot.
+	changes  uint64
+	saved    uint64
+	snapshot string
+	policy   persist.Policy
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewRing creates a ring buffer of the given size. If snapshot is not empty,
+// samples are loaded from it and the buffer is saved to it according to the
+// persistence policy.
+func NewRing(size int, snapshot string, policy persist.Policy) (*Ring, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid history size: %d", size)
+	}
+	r := &Ring{
+		samples:  make([]Sample, size),
+		snapshot: snapshot,
+		policy:   policy,
+	}
+	if snapshot == "" {
+		return r, nil
+	}
+	if err := r.load(); err != nil {
+		log.Warn().Msgf("Could not load history snapshot %s: %s", snapshot, err)
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.snapshotLoop()
+	return r, nil
+}
+
+// Add records a sample, overwriting the oldest one when the buffer is full.
+func (r *Ring) Add(s Sample) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = s
+	r.changes++
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+// Samples returns the samples newer than since, oldest first.
+func (r *Ring) Samples(since time.Time) ([]Sample, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Sample
+	for _, s := range r.ordered() {
+		if s.Time.After(since) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// Close stops the snapshot loop and writes a final snapshot.
+func (r *Ring) Close() error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+	}
+	if r.snapshot == "" {
+		return nil
+	}
+	return r.save()
+}
+
+// ordered returns the buffer content oldest first. The caller holds the lock.
+func (r *Ring) ordered() []Sample {
+	if !r.full {
+		return append([]Sample(nil), r.samples[:r.next]...)
+	}
+	out := make([]Sample, 0, len(r.samples))
+	out = append(out, r.samples[r.next:]...)
+	return append(out, r.samples[:r.next]...)
+}
+
+func (r *Ring) snapshotLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.policy.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.save(); err != nil {
+				log.Error().Msgf("Error writing history snapshot: %s", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// save writes the buffer to the snapshot file if it changed since the last
+// snapshot. A failed snapshot is written again on the next call.
+func (r *Ring) save() error {
+	r.mu.Lock()
+	if r.changes == r.saved {
+		r.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(r.ordered())
+	changes := r.changes
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := persist.WriteFile(r.snapshot, data, r.policy.Fsync); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.saved = changes
+	r.mu.Unlock()
+	return nil
+}
+
+// load fills the buffer from the snapshot file, keeping the newest samples
+// if the snapshot holds more than the buffer size.
+func (r *Ring) load() error {
+	samples, err := LoadSnapshot(r.snapshot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(samples) > len(r.samples) {
+		samples = samples[len(samples)-len(r.samples):]
+	}
+	for _, s := range samples {
+		r.Add(s)
+	}
+	r.saved = r.changes
+	return nil
+}
+
+// LoadSnapshot reads the samples saved in a snapshot file, oldest first.
+func LoadSnapshot(path string) ([]Sample, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var samples []Sample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
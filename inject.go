@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var injectFailureCmd = &cobra.Command{
+	Use:   "inject-failure <interface>",
+	Short: "Make the probes over an interface fail for a while",
+	Long: "Ask the running instance to fail the probes over an interface for a while, as if the link were down, " +
+		"to rehearse a failover and its hooks. Combine with --dry-run on the instance to leave the routes untouched.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		duration, _ := cmd.Flags().GetDuration("for")
+		carrier, _ := cmd.Flags().GetBool("carrier")
+		if duration <= 0 {
+			log.Error().Msgf("Invalid --for %s: it must be positive", duration)
+			os.Exit(1)
+		}
+		status, err := controlClient(cmd).Inject(args[0], duration, carrier)
+		if err != nil {
+			log.Error().Msgf("Error contacting the running instance: %s", err)
+			os.Exit(1)
+		}
+		if raw, _ := cmd.Flags().GetBool("json"); raw {
+			printJSON(status)
+			return
+		}
+		printStatus(status)
+	},
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package live streams probe samples and decisions from the running instance
+// to any number of watchers over a Unix socket, one JSON record per line.
+// Watchers that cannot keep up lose records rather than slowing the monitor.
+package live
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/history"
+)
+
+// Record kinds.
+const (
+	// KindSample is a probe result.
+	KindSample = "sample"
+	// KindDecision is a decision of the monitor, e.g. a failover.
+	KindDecision = "decision"
+)
+
+// Record is one streamed event.
+type Record struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// Interface is the link the record is about, empty for the default
+	// route.
+	Interface string `json:"interface,omitempty"`
+	// Sample is the probe result of a KindSample record.
+	Sample *history.Sample `json:"sample,omitempty"`
+	// Message describes a KindDecision record.
+	Message string `json:"message,omitempty"`
+}
+
+// Hub fans records out to the connected watchers.
+type Hub struct {
+	mu       sync.Mutex
+	listener net.Listener
+	watchers map[chan Record]struct{}
+}
+
+// Listen accepts watchers on socket.
+func Listen(socket string) (*Hub, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0o755); err != nil {
+		return nil, err
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	h := &Hub{listener: listener, watchers: map[chan Record]struct{}{}}
+	go h.accept()
+	return h, nil
+}
+
+func (h *Hub) accept() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		records := make(chan Record, 64)
+		h.mu.Lock()
+		h.watchers[records] = struct{}{}
+		h.mu.Unlock()
+		go h.serve(conn, records)
+	}
+}
+
+func (h *Hub) serve(conn net.Conn, records chan Record) {
+	defer func() {
+		h.mu.Lock()
+		delete(h.watchers, records)
+		h.mu.Unlock()
+		conn.Close()
+	}()
+	enc := json.NewEncoder(conn)
+	for r := range records {
+		if err := enc.Encode(r); err != nil {
+			return
+		}
+	}
+}
+
+// Publish sends r to every watcher. It never blocks.
+func (h *Hub) Publish(r Record) {
+	if h == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for records := range h.watchers {
+		select {
+		case records <- r:
+		default:
+		}
+	}
+}
+
+// Close stops accepting watchers and disconnects the connected ones.
+func (h *Hub) Close() error {
+	err := h.listener.Close()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for records := range h.watchers {
+		close(records)
+		delete(h.watchers, records)
+	}
+	return err
+}
+
+// Watch connects to the instance listening on socket and calls fn with each
+// record until the connection ends.
+func Watch(socket string, fn func(Record)) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		fn(r)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package lock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLock locks file exclusively, failing at once if it is held.
+func tryLock(file *os.File) error {
+	return lockFile(file, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY)
+}
+
+// waitLock locks file exclusively, waiting until it is released.
+func waitLock(file *os.File) error {
+	return lockFile(file, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+// lockFile locks the first byte of file with flags.
+func lockFile(file *os.File, flags uint32) error {
+	return windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, new(windows.Overlapped))
+}
+
+// unlock releases the lock on file.
+func unlock(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, new(windows.Overlapped))
+}
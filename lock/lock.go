@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package lock prevents two instances from managing the same interface. Each
+// managed interface is protected by an flock(2), or LockFileEx on Windows, on
+// a pid file, so a lock held by a crashed instance is released by the kernel.
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// takeoverTimeout is how long the previous instance is given to exit after
+// SIGTERM before it is killed.
+const takeoverTimeout = 10 * time.Second
+
+// Lock is a set of held instance locks.
+type Lock struct {
+	files []*os.File
+}
+
+// Acquire locks each of names in dir, in sorted order so that two instances
+// sharing several names cannot each hold a part of them. If a lock is held
+// by another instance, it fails, releasing the locks already taken, unless
+// takeover is set, in which case the other instance is terminated first.
+func Acquire(dir string, names []string, takeover bool) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	names = slices.Clone(names)
+	slices.Sort(names)
+	l := &Lock{}
+	for _, name := range slices.Compact(names) {
+		file, err := acquire(dir, name, takeover)
+		if err != nil {
+			l.Release()
+			return nil, err
+		}
+		l.files = append(l.files, file)
+	}
+	return l, nil
+}
+
+// acquire locks name in dir and writes the pid of the process in its file.
+func acquire(dir string, name string, takeover bool) (*os.File, error) {
+	path := filepath.Join(dir, name+".lock")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(file); err != nil {
+		pid := readPID(file)
+		if !takeover {
+			file.Close()
+			return nil, fmt.Errorf("%s is managed by another instance (pid %d), use --takeover to replace it", name, pid)
+		}
+		if err := terminate(file, pid); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Release unlocks the locks, in the reverse order.
+func (l *Lock) Release() error {
+	var first error
+	for i := len(l.files) - 1; i >= 0; i-- {
+		unlock(l.files[i])
+		if err := l.files[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	l.files = nil
+	return first
+}
+
+// terminate stops the instance holding the lock on file and waits for the
+// lock to be released.
+func terminate(file *os.File, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("lock %s is held by an unknown process", file.Name())
+	}
+	log.Warn().Msgf("Taking over from instance with pid %d", pid)
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	process.Signal(syscall.SIGTERM)
+	deadline := time.Now().Add(takeoverTimeout)
+	for {
+		if err := tryLock(file); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			log.Warn().Msgf("Instance with pid %d did not exit, killing it", pid)
+			process.Kill()
+			return waitLock(file)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// readPID returns the pid written in the lock file, or 0.
+func readPID(file *os.File) int {
+	data := make([]byte, 32)
+	n, _ := file.ReadAt(data, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	return pid
+}
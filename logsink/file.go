@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package logsink
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupFormat is the time format of the suffix of the rotated files.
+const backupFormat = "20060102-150405.000"
+
+// File appends log events to a file, one JSON object per line, and rotates
+// it once it grows past MaxSize or is older than MaxAge: the file is renamed
+// with the time of the rotation as suffix, e.g.
+// if-reliability.log.20240612-030000.000, and only the MaxBackups newest
+// rotated files are kept.
+type File struct {
+	// Path is the file written to.
+	Path string
+	// MaxSize is the size in bytes past which the file is rotated, never if
+	// 0.
+	MaxSize int64
+	// MaxAge is the age past which the file is rotated, never if 0.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept, all if 0.
+	MaxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// Open opens the file, appending to it if it exists.
+func (f *File) Open() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+// open opens the file, with f locked.
+func (f *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), info.ModTime()
+	if f.size == 0 {
+		f.opened = time.Now()
+	}
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if p would make it
+// too large or it is too old.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	tooLarge := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	tooOld := f.MaxAge > 0 && time.Since(f.opened) >= f.MaxAge
+	if tooLarge || tooOld {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate renames the file, opens a new one and removes the oldest rotated
+// files, with f locked.
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Rename(f.Path, f.Path+"."+time.Now().Format(backupFormat)); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes the rotated files beyond MaxBackups, oldest first.
+func (f *File) prune() {
+	if f.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, backup := range backups {
+		if _, err := time.Parse(backupFormat, strings.TrimPrefix(backup, f.Path+".")); err == nil {
+			rotated = append(rotated, backup)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > f.MaxBackups {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package logsink provides the destinations of the log besides the console:
+// the systemd journal with the fields of each event as journal fields, and
+// files rotated by size and age, and syslog, through zerolog.SyslogCEEWriter.
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// JournalSocket is the socket of the native protocol of systemd-journald.
+const JournalSocket = "/run/systemd/journal/socket"
+
+// Journald writes log events to the systemd journal, the message as MESSAGE,
+// the level as PRIORITY and the other fields of the event, e.g. interface
+// or severity, as upper-case journal fields, so that journalctl can filter
+// on them, e.g. journalctl SEVERITY=critical.
+type Journald struct {
+	mu         sync.Mutex
+	conn       *net.UnixConn
+	identifier string
+}
+
+// DialJournald connects to the journal, tagging the events with identifier
+// as SYSLOG_IDENTIFIER.
+func DialJournald(identifier string) (*Journald, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journald{conn: conn, identifier: identifier}, nil
+}
+
+// Write implements io.Writer, for events without a level.
+func (j *Journald) Write(p []byte) (int, error) {
+	return j.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter, sending p, a JSON event, as one
+// journal entry.
+func (j *Journald) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		fields = map[string]interface{}{zerolog.MessageFieldName: string(bytes.TrimSpace(p))}
+	}
+	var b bytes.Buffer
+	writeField(&b, "PRIORITY", fmt.Sprint(priority(level)))
+	writeField(&b, "SYSLOG_IDENTIFIER", j.identifier)
+	if msg, ok := fields[zerolog.MessageFieldName]; ok {
+		writeField(&b, "MESSAGE", fmt.Sprint(msg))
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch name {
+		case zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.TimestampFieldName:
+			continue
+		}
+		value, ok := fields[name].(string)
+		if !ok {
+			data, _ := json.Marshal(fields[name])
+			value = string(data)
+		}
+		writeField(&b, fieldName(name), value)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to the journal.
+func (j *Journald) Close() error {
+	return j.conn.Close()
+}
+
+// writeField appends a field in the native journal format, in the binary
+// form if value spans several lines.
+func writeField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// fieldName returns name as a journal field name: upper case letters, digits
+// and underscores, not starting with an underscore or a digit.
+func fieldName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	field := strings.TrimLeft(b.String(), "_0123456789")
+	if field == "" {
+		return "FIELD"
+	}
+	return field
+}
+
+// priority returns the syslog priority of level.
+func priority(level zerolog.Level) int {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 0
+	}
+	return 6
+}
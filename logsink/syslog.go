@@ -0,0 +1,23 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !windows && !plan9
+
+package logsink
+
+import (
+	"log/syslog"
+
+	"github.com/rs/zerolog"
+)
+
+// DialSyslog connects to the syslog daemon at address over network, the
+// local one if both are empty, and returns a writer sending the log events
+// to it as CEE-formatted messages of the daemon facility, tagged tag.
+func DialSyslog(network, address, tag string) (zerolog.LevelWriter, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return zerolog.SyslogCEEWriter(w), nil
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build windows || plan9
+
+package logsink
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+// DialSyslog fails, log/syslog being unavailable on this platform.
+func DialSyslog(network, address, tag string) (zerolog.LevelWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package lookup
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostsFile holds the static addresses of host names.
+const hostsFile = "/etc/hosts"
+
+// Cache resolves names from the hosts file, or else through the DNS servers
+// of an interface, and keeps their addresses for the TTL of the records,
+// bounded by MinTTL and MaxTTL.
+// Once they expired, the addresses last resolved are still returned while
+// the servers do not answer, retried every MinTTL.
+type Cache struct {
+	// Servers returns the DNS servers of ifname, those of the host if
+	// ifname is empty. A server on the loopback, such as a local stub
+	// resolver, is queried without binding the socket to ifname.
+	Servers func(ifname string) []string
+	// IPv4 and IPv6 query the A and AAAA records, the IPv4 addresses coming
+	// first if both are set.
+	IPv4    bool
+	IPv6    bool
+	Timeout time.Duration
+	MinTTL  time.Duration
+	MaxTTL  time.Duration
+	// OnChange, if set, is called when the addresses of a name over an
+	// interface change, the first resolution included.
+	OnChange func(name string, ifname string, addrs []netip.Addr)
+
+	mu      sync.Mutex
+	entries map[key]*entry
+}
+
+// key names the addresses of a name resolved over an interface.
+type key struct {
+	name   string
+	ifname string
+}
+
+// entry is the last resolution of a name: its addresses, empty if it
+// failed, until when they are fresh, and the next address to rotate to.
+type entry struct {
+	addrs   []netip.Addr
+	expires time.Time
+	err     error
+	next    int
+}
+
+// Lookup returns the addresses of name resolved over ifname.
+func (c *Cache) Lookup(name string, ifname string) ([]netip.Addr, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key{name, ifname}]
+	if ok && time.Now().Before(e.expires) {
+		addrs, err := e.addrs, e.err
+		c.mu.Unlock()
+		return addrs, err
+	}
+	c.mu.Unlock()
+	addrs, ttl, err := c.resolve(name, ifname)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[key]*entry{}
+	}
+	e, ok = c.entries[key{name, ifname}]
+	if !ok {
+		e = &entry{}
+		c.entries[key{name, ifname}] = e
+	}
+	if err != nil {
+		e.expires = time.Now().Add(c.MinTTL)
+		if len(e.addrs) > 0 {
+			// The servers may only be unreachable over a failed link:
+			// the addresses last resolved are better than none.
+			return e.addrs, nil
+		}
+		e.err = err
+		return nil, err
+	}
+	e.expires = time.Now().Add(min(max(ttl, c.MinTTL), c.MaxTTL))
+	e.err = nil
+	if !slices.Equal(e.addrs, addrs) {
+		e.addrs, e.next = addrs, 0
+		if c.OnChange != nil {
+			c.OnChange(name, ifname, addrs)
+		}
+	}
+	return e.addrs, nil
+}
+
+// Next returns the addresses of name resolved over ifname one after the
+// other, rotating through them at each call.
+func (c *Cache) Next(name string, ifname string) (netip.Addr, error) {
+	addrs, err := c.Lookup(name, ifname)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key{name, ifname}]
+	addr := addrs[e.next%len(addrs)]
+	e.next++
+	return addr, nil
+}
+
+// resolve queries the servers of ifname in turn for name until one answers.
+func (c *Cache) resolve(name string, ifname string) ([]netip.Addr, time.Duration, error) {
+	if addrs := c.hosts(name); len(addrs) > 0 {
+		return addrs, 0, nil
+	}
+	servers := c.Servers(ifname)
+	if len(servers) == 0 {
+		return nil, 0, fmt.Errorf("no DNS server to resolve %s over %s", name, interfaceName(ifname))
+	}
+	var errs []error
+	for _, server := range servers {
+		bound := ifname
+		if ip, err := netip.ParseAddr(server); err == nil && ip.IsLoopback() {
+			bound = ""
+		}
+		addrs, ttl, err := c.query(server, name, bound)
+		if err == nil {
+			return addrs, ttl, nil
+		}
+		if errors.Is(err, ErrNoAddress) || errors.Is(err, ErrNotFound) {
+			return nil, 0, fmt.Errorf("%s: %w", name, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+	}
+	return nil, 0, fmt.Errorf("cannot resolve %s over %s: %w", name, interfaceName(ifname), errors.Join(errs...))
+}
+
+// query asks server for the addresses of name of the families of the cache
+// and returns them, sorted per family, with their smallest TTL.
+func (c *Cache) query(server string, name string, ifname string) ([]netip.Addr, time.Duration, error) {
+	var all []netip.Addr
+	var ttl time.Duration
+	for _, ipv6 := range []bool{false, true} {
+		if ipv6 && !c.IPv6 || !ipv6 && !c.IPv4 {
+			continue
+		}
+		addrs, t, err := Query(server, name, ipv6, ifname, c.Timeout)
+		if errors.Is(err, ErrNoAddress) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		slices.SortFunc(addrs, netip.Addr.Compare)
+		if len(all) == 0 || t < ttl {
+			ttl = t
+		}
+		all = append(all, addrs...)
+	}
+	if len(all) == 0 {
+		return nil, 0, ErrNoAddress
+	}
+	return all, ttl, nil
+}
+
+// hosts returns the addresses of name of the families of the cache in the
+// hosts file, which take precedence over DNS.
+func (c *Cache) hosts(name string) []netip.Addr {
+	content, err := os.ReadFile(hostsFile)
+	if err != nil {
+		return nil
+	}
+	var v4, v6 []netip.Addr
+	for _, line := range strings.Split(string(content), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !slices.ContainsFunc(fields[1:], func(alias string) bool { return strings.EqualFold(alias, name) }) {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		switch {
+		case err != nil:
+		case addr.Is4() && c.IPv4:
+			v4 = append(v4, addr)
+		case addr.Is6() && c.IPv6:
+			v6 = append(v6, addr)
+		}
+	}
+	return append(v4, v6...)
+}
+
+// interfaceName names ifname in the errors.
+func interfaceName(ifname string) string {
+	if ifname == "" {
+		return "the routing table"
+	}
+	return ifname
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package lookup resolves the host names of the probe endpoints through the
+// DNS servers of the interface probed, over a socket bound to it, so that a
+// name still resolves over the backup link while the servers of the primary
+// link are unreachable. The addresses are cached for the TTL of their
+// records.
+package lookup
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Errors of the answers: a name that does not exist, and a name without
+// address of the family queried.
+var (
+	ErrNotFound  = errors.New("no such host")
+	ErrNoAddress = errors.New("no address")
+)
+
+// Query asks server, an IP address with or without port, for the A records
+// of name, or its AAAA records if ipv6 is set, leaving through ifname if not
+// empty. It returns the addresses and the smallest TTL of their records.
+func Query(server string, name string, ipv6 bool, ifname string, timeout time.Duration) ([]netip.Addr, time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	qtype := dnsmessage.TypeA
+	if ipv6 {
+		qtype = dnsmessage.TypeAAAA
+	}
+	id := uint16(rand.N(1 << 16))
+	packet, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	dialer := net.Dialer{Timeout: timeout, Control: bind.Control(ifname)}
+	conn, err := dialer.Dial("udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(packet); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			continue
+		}
+		if header.RCode == dnsmessage.RCodeNameError {
+			return nil, 0, ErrNotFound
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			return nil, 0, fmt.Errorf("%s answered %s", server, strings.TrimPrefix(header.RCode.String(), "RCode"))
+		}
+		return answer(&parser, qtype)
+	}
+}
+
+// answer returns the addresses of type qtype of the answer parsed by parser
+// and the smallest TTL of the answer, that of the CNAME records leading to
+// them included.
+func answer(parser *dnsmessage.Parser, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+	var addrs []netip.Addr
+	var ttl uint32
+	first := true
+	for {
+		header, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if first || header.TTL < ttl {
+			ttl, first = header.TTL, false
+		}
+		switch {
+		case header.Type == qtype && qtype == dnsmessage.TypeA:
+			r, err := parser.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, netip.AddrFrom4(r.A))
+		case header.Type == qtype && qtype == dnsmessage.TypeAAAA:
+			r, err := parser.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			addrs = append(addrs, netip.AddrFrom16(r.AAAA))
+		default:
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, ErrNoAddress
+	}
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
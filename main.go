@@ -4,186 +4,105 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-// init initializes the command-line flags for the application.
-// It sets up persistent flags for LTE interface, WiFi interface, WiFi SSID,
-// WiFi password, and failure detection delay. It also marks the LTE interface,
-// WiFi interface, WiFi SSID, and WiFi password flags as required.
-func init() {
-	rootCmd.PersistentFlags().StringP("wifi-if", "w", "", "WiFi interface (required)")
-	rootCmd.PersistentFlags().StringP("wifi-ssid", "s", "", "WiFi SSID (required)")
-	rootCmd.PersistentFlags().StringP("wifi-password", "p", "", "WiFi password (required)")
-	rootCmd.PersistentFlags().StringP("endpoint", "e", "", "Probe server endpoint (required)")
-	rootCmd.PersistentFlags().IntP("retry", "r", 5, "Retry count before switching to WiFi (default: 5)")
-	rootCmd.MarkPersistentFlagRequired("wifi-if")
-	rootCmd.MarkPersistentFlagRequired("wifi-ssid")
-	rootCmd.MarkPersistentFlagRequired("wifi-password")
-	rootCmd.MarkPersistentFlagRequired("endpoint")
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-}
-
-// pingIP uses ICMP to ping an IP address and returns the response time in milliseconds.
-// Returns -1 if there is an error or if the ping fails.
-func pingIP(ip string) int {
-	cmd := exec.Command("ping", "-c", "1", "-W", "2", ip)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return -1
-	}
-	outputStr := string(output)
-	if !strings.Contains(outputStr, "1 received") {
-		return -1
-	}
+// monitorConfig is the configuration of the monitor, bound to the flags of
+// the root command, shared by the monitor, doctor, plan and simulate
+// commands.
+var monitorConfig reliability.Config
 
-	// Extract response time
-	lines := strings.Split(outputStr, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "time=") {
-			parts := strings.Split(line, " ")
-			for _, part := range parts {
-				if strings.HasPrefix(part, "time=") {
-					timeStr := strings.TrimPrefix(part, "time=")
-					timeStr = strings.TrimSuffix(timeStr, " ms")
-					responseTime, err := strconv.ParseFloat(timeStr, 32)
-					if err != nil {
-						return -1
-					}
-					return int(responseTime)
-				}
-			}
-		}
+// init defines the flags of the monitor, shared by the root and monitor
+// commands.
+func init() {
+	reliability.AddFlags(rootCmd.Flags(), &monitorConfig)
+	rootCmd.MarkFlagRequired("wifi-if")
+	// Running the root command monitors too, as before the subcommands.
+	monitorCmd.Flags().AddFlagSet(rootCmd.Flags())
+	monitorCmd.Run = rootCmd.Run
+	rootCmd.AddCommand(monitorCmd)
+	zerolog.TimestampFunc = func() time.Time { return time.Now().UTC() }
+	if logger, err := reliability.NewLogger(reliability.DefaultConfig()); err == nil {
+		log.Logger = logger
 	}
+}
 
-	return -1
+var monitorCmd = &cobra.Command{
+	Use:   "monitor",
+	Short: "Monitor the primary link and fail over to WiFi",
+	Long: "Monitor the endpoints over the primary link and fail over to WiFi when they stop answering. " +
+		"Running if-reliability without a command does the same.",
 }
 
-// pingInterface pings an interface and when the retry-count is met with consecutive failures, it returns -1.
-func pingInterface(endpoint string, retry int) int {
-	log.Info().Msgf("Pinging endpoint %s", endpoint)
-	failures := 0
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt)
-	go func() {
-		<-signalChannel
-		log.Warn().Msgf("Stopping ping due to user interrupt...")
-		log.Info().Msg("Exiting the program...")
-		os.Exit(0)
-	}()
-	for {
-		time.Sleep(time.Second)
-		responseTime := pingIP(endpoint)
-		if responseTime != -1 {
-			failures = 0
-		} else {
-			failures++
-			log.Warn().Msgf("Failed to ping %s. Attempt %d out of %d. Retrying...", endpoint, failures, retry)
-			if failures >= retry {
-				return -1
+var rootCmd = &cobra.Command{
+	Use:   "if-reliability",
+	Short: "Interface Reliability tool",
+	Long: "Interface Reliability tool is a tool to check the reliability of an interface. " +
+		"Without a command, it monitors the primary link like the monitor command.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(groupNames) > 0 {
+			runGroups(cmd, groupNames)
+			return
+		}
+		config, err := commandConfig(cmd.Flags())
+		if err == nil {
+			var m *reliability.Manager
+			if m, err = reliability.NewManager(config); err == nil {
+				err = m.Run(interruptContext())
 			}
 		}
-	}
-}
-
-// connectToWiFi connects to the given wifi bssid with the given password.
-func connectToWiFi(ifwifi string, bssid string, password string) (string, error) {
-	cmd := exec.Command("nmcli", "d", "wifi", "connect", bssid, "password", password, "ifname", ifwifi)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", err
-	}
-	log.Info().Msg(string(output))
-	// ping the default router to check if the connection is successful
-	for {
-		time.Sleep(time.Second)
-		output, err := exec.Command("ip", "route", "show", "default", "dev", ifwifi).CombinedOutput()
 		if err != nil {
-			log.Error().Msgf("Error getting default route after connecting to WiFi: %s", err)
-			return "", nil
-		}
-		route := strings.Split(string(output), " ")[2]
-		log.Info().Msgf("Pinging default router: %s", route)
-		responseTime := pingIP(route)
-		if responseTime != -1 {
-			return route, nil
+			log.Error().Msgf("Cannot monitor: %s", err)
+			os.Exit(1)
 		}
-	}
-	return "", nil
+	},
 }
 
-// replaceRoute takes an IPv4 address, a CIDR mask, and a network interface name.
-// It calculates the network address and replaces a route for this network using the specified interface.
-func replaceRoute(ipv4 string, cidrMask int, ifname string, router string) error {
-	// Parse the IP address
-	ip := net.ParseIP(ipv4)
-	if ip == nil {
-		log.Error().Msgf("invalid IP address: %s", ipv4)
-		return fmt.Errorf("invalid IP address: %s", ipv4)
+// commandConfig returns the configuration of the monitor of flags, bound to
+// monitorConfig, with its secrets read, its logger, which becomes that of
+// the command too, and SIGHUP reading the configuration file again.
+func commandConfig(flags *pflag.FlagSet) (reliability.Config, error) {
+	config := monitorConfig
+	if err := reliability.ReadSecrets(flags, &config); err != nil {
+		return reliability.Config{}, err
 	}
-	mask := net.CIDRMask(cidrMask, 32)
-
-	// Calculate the network address
-	network := ip.Mask(mask)
-	log.Info().Msgf("Network address: %s", network)
-
-	// Build the CIDR notation
-	cidr := fmt.Sprintf("%s/%d", network, cidrMask)
-	log.Info().Msgf("Replacing default route for network %s", cidr)
-
-	// Execute the command to replace the route
-	cmd := exec.Command("ip", "route", "replace", cidr, "via", router, "dev", ifname)
-	output, err := cmd.CombinedOutput()
+	logger, err := reliability.NewLogger(config)
 	if err != nil {
-		log.Error().Msgf("failed to replace route: %s, output: %s", err, strings.TrimSpace(string(output)))
-		return fmt.Errorf("failed to replace route: %s, output: %s", err, strings.TrimSpace(string(output)))
+		return reliability.Config{}, fmt.Errorf("setting up the logs: %w", err)
 	}
-
-	return nil
+	log.Logger = logger
+	config.Logger = &logger
+	config.Sources = reliability.SettingSources(flags)
+	config.Reload = func() (reliability.Config, error) { return reliability.ReloadConfigFile(flags) }
+	return config, nil
 }
 
-var rootCmd = &cobra.Command{
-	Use:   "if-reliability",
-	Short: "Interface Reliability tool",
-	Long:  "Interface Reliability tool is a tool to check the reliability of an interface.",
-	Run: func(cmd *cobra.Command, args []string) {
-		log.Info().Msg("Starting Interface Reliability tool...")
-		wifiIF, _ := cmd.Flags().GetString("wifi-if")
-		wifiSSID, _ := cmd.Flags().GetString("wifi-ssid")
-		wifiPassword, _ := cmd.Flags().GetString("wifi-password")
-		endPoint, _ := cmd.Flags().GetString("endpoint")
-		retry, _ := cmd.Flags().GetString("retry")
-
-		log.Info().Msgf("Starting Interface Reliability tool with:")
-		log.Info().Msgf("- WiFi interface: %s", wifiIF)
-		log.Info().Msgf("- WiFi SSID: %s", wifiSSID)
-		log.Info().Msgf("- WiFi password: %s", wifiPassword)
-		log.Info().Msgf("- Endpoint to check connectivity: %s", endPoint)
-		log.Info().Msgf("- Max retry: %s", retry)
-
-		pingInterface(endPoint, 5)
-		log.Error().Msgf("Ping toward %s endpoint failed", endPoint)
-		router, err := connectToWiFi(wifiIF, wifiSSID, wifiPassword)
-		if err != nil {
-			log.Error().Msgf("Error connecting to WiFi: %s", err)
-			os.Exit(1)
+// interruptContext returns a context done on SIGINT or SIGTERM, stopping the
+// monitor cleanly.
+func interruptContext() context.Context {
+	ctx, stop := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if <-signals == syscall.SIGTERM {
+			log.Warn().Msg("Stopping the monitor on SIGTERM...")
+		} else {
+			log.Warn().Msg("Stopping the monitor on user interrupt...")
 		}
-		log.Info().Msgf("Successfully connected to WiFi with SSID %s", wifiSSID)
-		replaceRoute(endPoint, 24, wifiIF, router)
-		log.Info().Msgf("Successfully changed default route to %s", wifiIF)
-		pingInterface(endPoint, 5)
-	},
+		stop()
+	}()
+	return ctx
 }
 
 func main() {
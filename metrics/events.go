@@ -0,0 +1,30 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package metrics
+
+// Event metric names.
+const (
+	// Events counts the events emitted by the tool, labelled by severity.
+	Events = "if_reliability_events_total"
+	// LabelSeverity is the label holding the event severity.
+	LabelSeverity = "severity"
+)
+
+// CountEvent records an event of the given severity.
+func (r *Registry) CountEvent(severity string) {
+	r.eventMu.Lock()
+	defer r.eventMu.Unlock()
+	r.eventCounts[severity]++
+}
+
+// EventCounts returns the number of events per severity.
+func (r *Registry) EventCounts() map[string]uint64 {
+	r.eventMu.Lock()
+	defer r.eventMu.Unlock()
+	counts := make(map[string]uint64, len(r.eventCounts))
+	for severity, n := range r.eventCounts {
+		counts[severity] = n
+	}
+	return counts
+}
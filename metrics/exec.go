@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Subprocess metric names.
+const (
+	// ExecDuration is a summary of the run time of external programs in
+	// seconds, labelled by program.
+	ExecDuration = "if_reliability_exec_duration_seconds"
+	// ExecFailures counts external programs that could not run or failed,
+	// labelled by program.
+	ExecFailures = "if_reliability_exec_failures_total"
+	// LabelProgram is the label holding the program name.
+	LabelProgram = "program"
+)
+
+// ExecStats are the statistics of one external program.
+type ExecStats struct {
+	Program  string
+	Calls    uint64
+	Failures uint64
+	Total    time.Duration
+	Max      time.Duration
+}
+
+// Mean returns the mean run time.
+func (s ExecStats) Mean() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// ObserveExec records a run of program.
+func (r *Registry) ObserveExec(program string, duration time.Duration, failed bool) {
+	r.execMu.Lock()
+	defer r.execMu.Unlock()
+	s, ok := r.execStats[program]
+	if !ok {
+		s = &ExecStats{Program: program}
+		r.execStats[program] = s
+	}
+	s.Calls++
+	s.Total += duration
+	if duration > s.Max {
+		s.Max = duration
+	}
+	if failed {
+		s.Failures++
+	}
+}
+
+// Exec returns the statistics of every program run so far, sorted by name.
+func (r *Registry) Exec() []ExecStats {
+	r.execMu.Lock()
+	defer r.execMu.Unlock()
+	stats := make([]ExecStats, 0, len(r.execStats))
+	for _, s := range r.execStats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Program < stats[j].Program })
+	return stats
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path is the HTTP path the metrics are served on.
+const Path = "/metrics"
+
+// Listen starts serving the metrics over HTTP on addr, host:port, in the
+// background. Errors are returned only for the initial listen.
+func (r *Registry) Listen(addr string) (io.Closer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, r.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(l)
+	return server, nil
+}
+
+// Content types of the exposition formats.
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Handler returns an HTTP handler serving the metrics in the OpenMetrics
+// format, with exemplars, to the scrapers accepting it, as Prometheus does
+// with the exemplar storage enabled, and in the Prometheus text exposition
+// format otherwise.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", contentTypeOpenMetrics)
+			r.WriteOpenMetrics(w)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeText)
+		r.Write(w)
+	})
+}
+
+// Write writes every metric in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	return r.write(w, false)
+}
+
+// WriteOpenMetrics writes every metric in the OpenMetrics text format, the
+// failover counter and the probe RTT histogram carrying the ID of the outage
+// of their last observation as an exemplar, if it happened during one.
+func (r *Registry) WriteOpenMetrics(w io.Writer) error {
+	return r.write(w, true)
+}
+
+// write writes every metric, in the OpenMetrics format if openMetrics is
+// set.
+func (r *Registry) write(w io.Writer, openMetrics bool) error {
+	b := bufio.NewWriter(w)
+	e := &encoder{w: b, openMetrics: openMetrics}
+	r.writeProbes(e)
+	r.writeLinks(e)
+	r.writeExec(e)
+	r.writeEvents(e)
+	if openMetrics {
+		fmt.Fprint(b, "# EOF\n")
+	}
+	return b.Flush()
+}
+
+// encoder writes metrics in the Prometheus text format or, if openMetrics
+// is set, in the OpenMetrics one.
+type encoder struct {
+	w           io.Writer
+	openMetrics bool
+}
+
+// header writes the HELP and TYPE lines of a metric. In OpenMetrics, the
+// family of a counter is named without its _total suffix.
+func (e *encoder) header(name, kind, help string) {
+	if e.openMetrics && kind == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelEscaper escapes label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelSet renders labels, alternating names and values, as {name="value"}.
+func labelSet(labels ...string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sample writes one sample line. labels alternate names and values.
+func (e *encoder) sample(name string, value float64, labels ...string) {
+	e.exemplarSample(name, value, exemplar{}, labels...)
+}
+
+// exemplarSample writes one sample line followed, in OpenMetrics, by ex
+// unless it is empty.
+func (e *encoder) exemplarSample(name string, value float64, ex exemplar, labels ...string) {
+	fmt.Fprint(e.w, name)
+	if len(labels) > 0 {
+		fmt.Fprint(e.w, labelSet(labels...))
+	}
+	fmt.Fprintf(e.w, " %s", strconv.FormatFloat(value, 'g', -1, 64))
+	if e.openMetrics && ex.outage != "" {
+		fmt.Fprintf(e.w, " # %s %s %s", labelSet(LabelOutage, ex.outage), strconv.FormatFloat(ex.value, 'g', -1, 64), strconv.FormatFloat(float64(ex.at.UnixMilli())/1e3, 'f', 3, 64))
+	}
+	fmt.Fprint(e.w, "\n")
+}
+
+// bound renders a bucket bound, with a fractional part in OpenMetrics,
+// which wants le="1.0" rather than le="1".
+func (e *encoder) bound(value float64) string {
+	s := strconv.FormatFloat(value, 'g', -1, 64)
+	if e.openMetrics && !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// writeProbes writes the probe RTT histogram and consecutive failures.
+func (r *Registry) writeProbes(e *encoder) {
+	r.probeMu.Lock()
+	defer r.probeMu.Unlock()
+	keys := make([]probeKey, 0, len(r.probeMetrics))
+	for key := range r.probeMetrics {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Interface != keys[j].Interface {
+			return keys[i].Interface < keys[j].Interface
+		}
+		return keys[i].Endpoint < keys[j].Endpoint
+	})
+	e.header(ProbeRTT, "histogram", "Probe round-trip times in seconds.")
+	for _, key := range keys {
+		s := r.probeMetrics[key]
+		for i, bound := range RTTBuckets {
+			e.exemplarSample(ProbeRTT+"_bucket", float64(s.buckets[i]), s.exemplars[i], LabelInterface, key.Interface, LabelEndpoint, key.Endpoint, "le", e.bound(bound))
+		}
+		e.exemplarSample(ProbeRTT+"_bucket", float64(s.count), s.exemplars[len(RTTBuckets)], LabelInterface, key.Interface, LabelEndpoint, key.Endpoint, "le", "+Inf")
+		e.sample(ProbeRTT+"_sum", s.sum, LabelInterface, key.Interface, LabelEndpoint, key.Endpoint)
+		e.sample(ProbeRTT+"_count", float64(s.count), LabelInterface, key.Interface, LabelEndpoint, key.Endpoint)
+	}
+	e.header(ConsecutiveFailures, "gauge", "Current number of consecutive probe failures.")
+	for _, key := range keys {
+		e.sample(ConsecutiveFailures, float64(r.probeMetrics[key].failures), LabelInterface, key.Interface, LabelEndpoint, key.Endpoint)
+	}
+	e.header(ProbeFailures, "counter", "Failed probes.")
+	for _, key := range keys {
+		e.sample(ProbeFailures, float64(r.probeMetrics[key].failed), LabelInterface, key.Interface, LabelEndpoint, key.Endpoint)
+	}
+	e.header(ProbeLastRTT, "gauge", "Round-trip time of the last successful probe in seconds.")
+	for _, key := range keys {
+		if s := r.probeMetrics[key]; s.count > 0 {
+			e.sample(ProbeLastRTT, s.last, LabelInterface, key.Interface, LabelEndpoint, key.Endpoint)
+		}
+	}
+	e.header(LastProbe, "gauge", "Unix time of the last probe.")
+	for _, key := range keys {
+		if s := r.probeMetrics[key]; !s.at.IsZero() {
+			e.sample(LastProbe, float64(s.at.UnixNano())/1e9, LabelInterface, key.Interface, LabelEndpoint, key.Endpoint)
+		}
+	}
+	header := false
+	for _, key := range keys {
+		phases := r.probeMetrics[key].phases
+		if len(phases) > 0 && !header {
+			e.header(ProbePhase, "gauge", "Duration of a phase of the last probe in seconds.")
+			header = true
+		}
+		for _, name := range sortedKeys(phases) {
+			e.sample(ProbePhase, phases[name], LabelInterface, key.Interface, LabelEndpoint, key.Endpoint, LabelPhase, name)
+		}
+	}
+}
+
+// writeLinks writes the active interface, failover and per-link metrics.
+func (r *Registry) writeLinks(e *encoder) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	if r.state != "" {
+		e.header(State, "gauge", "1 for the current state of the instance.")
+		e.sample(State, 1, LabelState, r.state)
+	}
+	e.header(ActiveInterface, "gauge", "1 for the interface currently carrying traffic, 0 for the others.")
+	for _, ifname := range sortedKeys(r.links) {
+		active := 0.0
+		if ifname == r.activeLink {
+			active = 1
+		}
+		e.sample(ActiveInterface, active, LabelInterface, ifname)
+	}
+	keys := make([]failoverKey, 0, len(r.failovers))
+	for key := range r.failovers {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].From != keys[j].From {
+			return keys[i].From < keys[j].From
+		}
+		return keys[i].To < keys[j].To
+	})
+	e.header(Failovers, "counter", "Failover events.")
+	for _, key := range keys {
+		e.exemplarSample(Failovers, float64(r.failovers[key]), r.failoverExemplars[key], LabelFrom, key.From, LabelTo, key.To)
+	}
+	if !r.lastFailover.IsZero() {
+		e.header(LastFailover, "gauge", "Unix time of the last failover event.")
+		e.sample(LastFailover, float64(r.lastFailover.UnixNano())/1e9)
+	}
+	e.header(BackupVerified, "gauge", "Unix time the backup path was last proven to work.")
+	for _, ifname := range sortedKeys(r.backupVerified) {
+		e.sample(BackupVerified, float64(r.backupVerified[ifname].UnixNano())/1e9, LabelInterface, ifname)
+	}
+	e.header(Reliability, "gauge", "Long-term reliability score of the link between 0 and 1.")
+	for _, ifname := range sortedKeys(r.reliability) {
+		e.sample(Reliability, r.reliability[ifname], LabelInterface, ifname)
+	}
+	if len(r.signal) > 0 {
+		e.header(Signal, "gauge", "Last signal strength of the WiFi link in dBm.")
+		for _, ifname := range sortedKeys(r.signal) {
+			e.sample(Signal, float64(r.signal[ifname]), LabelInterface, ifname)
+		}
+	}
+	if len(r.throughput) > 0 {
+		e.header(Throughput, "gauge", "Last throughput measured on the link in Mbit/s.")
+		for _, ifname := range sortedKeys(r.throughput) {
+			e.sample(Throughput, r.throughput[ifname], LabelInterface, ifname)
+		}
+	}
+	if len(r.pathMTU) > 0 {
+		e.header(PathMTU, "gauge", "Last path MTU discovered over the link in bytes.")
+		for _, ifname := range sortedKeys(r.pathMTU) {
+			e.sample(PathMTU, float64(r.pathMTU[ifname]), LabelInterface, ifname)
+		}
+	}
+	if len(r.pathScore) > 0 {
+		e.header(PathScore, "gauge", "Last path score of the link between 0 and 100.")
+		for _, ifname := range sortedKeys(r.pathScore) {
+			e.sample(PathScore, r.pathScore[ifname], LabelInterface, ifname)
+		}
+	}
+	if len(r.usageRx) > 0 {
+		e.header(LinkUsage, "gauge", "Traffic of the link since the start of the billing period in bytes.")
+		for _, ifname := range sortedKeys(r.usageRx) {
+			e.sample(LinkUsage, float64(r.usageRx[ifname]), LabelInterface, ifname, LabelDirection, "rx")
+			e.sample(LinkUsage, float64(r.usageTx[ifname]), LabelInterface, ifname, LabelDirection, "tx")
+		}
+	}
+	if r.peerMaster >= 0 {
+		e.header(PeerMaster, "gauge", "1 while the instance is the master of its pair, 0 while it stands by.")
+		e.sample(PeerMaster, r.peerMaster)
+	}
+	if len(r.dataCap) > 0 {
+		e.header(DataCap, "gauge", "Data cap of the link over a billing period in bytes.")
+		for _, ifname := range sortedKeys(r.dataCap) {
+			e.sample(DataCap, float64(r.dataCap[ifname]), LabelInterface, ifname)
+		}
+	}
+}
+
+// writeExec writes the external program statistics.
+func (r *Registry) writeExec(e *encoder) {
+	stats := r.Exec()
+	e.header(ExecDuration, "summary", "Run time of external programs in seconds.")
+	for _, s := range stats {
+		e.sample(ExecDuration+"_sum", s.Total.Seconds(), LabelProgram, s.Program)
+		e.sample(ExecDuration+"_count", float64(s.Calls), LabelProgram, s.Program)
+	}
+	e.header(ExecFailures, "counter", "External programs that could not run or failed.")
+	for _, s := range stats {
+		e.sample(ExecFailures, float64(s.Failures), LabelProgram, s.Program)
+	}
+}
+
+// writeEvents writes the event counts.
+func (r *Registry) writeEvents(e *encoder) {
+	counts := r.EventCounts()
+	e.header(Events, "counter", "Events emitted by the tool.")
+	for _, severity := range sortedKeys(counts) {
+		e.sample(Events, float64(counts[severity]), LabelSeverity, severity)
+	}
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
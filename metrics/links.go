@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package metrics
+
+import (
+	"time"
+)
+
+// failoverKey identifies the failovers from one interface to another.
+type failoverKey struct {
+	From string
+	To   string
+}
+
+// AddLink exports ifname as an interface that can carry traffic, inactive
+// until set active.
+func (r *Registry) AddLink(ifname string) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.links[ifname] = true
+}
+
+// SetActive records the interface currently carrying traffic. Every
+// interface ever added stays exported, with 0 while it is not active.
+func (r *Registry) SetActive(ifname string) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.activeLink = ifname
+	r.links[ifname] = true
+}
+
+// ObserveFailover records a switch from one interface to another, made
+// during the outage whose ID is outage if not empty.
+func (r *Registry) ObserveFailover(from, to string, at time.Time, outage string) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	key := failoverKey{From: from, To: to}
+	r.failovers[key]++
+	r.lastFailover = at
+	if outage != "" {
+		r.failoverExemplars[key] = exemplar{outage: outage, value: 1, at: at}
+	}
+}
+
+// SetBackupVerified records when the backup path over ifname was last
+// proven to work.
+func (r *Registry) SetBackupVerified(ifname string, at time.Time) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.backupVerified[ifname] = at
+}
+
+// SetReliability records the long-term reliability score of ifname.
+func (r *Registry) SetReliability(ifname string, ratio float64) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.reliability[ifname] = ratio
+}
+
+// SetSignal records the last signal strength of the WiFi link ifname in dBm.
+func (r *Registry) SetSignal(ifname string, dbm int) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.signal[ifname] = dbm
+}
+
+// SetPathMTU records the last path MTU discovered over ifname in bytes.
+func (r *Registry) SetPathMTU(ifname string, mtu int) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.pathMTU[ifname] = mtu
+}
+
+// SetPathScore records the last path score of ifname between 0 and 100.
+func (r *Registry) SetPathScore(ifname string, score float64) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.pathScore[ifname] = score
+}
+
+// SetThroughput records the last throughput measured on ifname in Mbit/s.
+func (r *Registry) SetThroughput(ifname string, mbps float64) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.throughput[ifname] = mbps
+}
+
+// SetUsage records the bytes received and sent over ifname since the start
+// of the billing period.
+func (r *Registry) SetUsage(ifname string, rx, tx uint64) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.usageRx[ifname] = rx
+	r.usageTx[ifname] = tx
+}
+
+// SetState records the current state of the instance.
+func (r *Registry) SetState(name string) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.state = name
+}
+
+// FailoverCount returns the number of failovers and the time of the last
+// one, zero if none.
+func (r *Registry) FailoverCount() (uint64, time.Time) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	var total uint64
+	for _, count := range r.failovers {
+		total += count
+	}
+	return total, r.lastFailover
+}
+
+// SetPeerMaster records whether the instance is the master of its pair.
+func (r *Registry) SetPeerMaster(master bool) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.peerMaster = 0
+	if master {
+		r.peerMaster = 1
+	}
+}
+
+// SetDataCap records the data cap of ifname in bytes.
+func (r *Registry) SetDataCap(ifname string, bytes uint64) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+	r.dataCap[ifname] = bytes
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package metrics defines the Prometheus metrics exported by the tool, in the
+// Prometheus text format or in OpenMetrics, which adds exemplars. Each
+// instance of the engine records its metrics in a Registry of its own.
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Metric names.
+const (
+	// ProbeRTT is a histogram of probe round-trip times in seconds, labelled
+	// by interface and endpoint.
+	ProbeRTT = "if_reliability_probe_rtt_seconds"
+	// ProbePhase is the duration of a phase of the last probe in seconds,
+	// for the probe types timing them, labelled by interface, endpoint and
+	// phase (dns, connect, tls or first_byte).
+	ProbePhase = "if_reliability_probe_phase_seconds"
+	// ProbeLastRTT is the round-trip time of the last successful probe in
+	// seconds, labelled by interface and endpoint.
+	ProbeLastRTT = "if_reliability_probe_last_rtt_seconds"
+	// LastProbe is the Unix time of the last probe, labelled by interface
+	// and endpoint.
+	LastProbe = "if_reliability_probe_last_timestamp_seconds"
+	// ProbeFailures counts failed probes, labelled by interface and
+	// endpoint.
+	ProbeFailures = "if_reliability_probe_failures_total"
+	// ConsecutiveFailures is a gauge of the current number of consecutive
+	// probe failures, labelled by interface and endpoint.
+	ConsecutiveFailures = "if_reliability_probe_consecutive_failures"
+	// State is 1 for the current state of the instance, labelled by state.
+	State = "if_reliability_state"
+	// ActiveInterface is 1 for the interface currently carrying traffic and
+	// 0 for the others, labelled by interface.
+	ActiveInterface = "if_reliability_active_interface"
+	// Failovers counts failover events, labelled by source and destination
+	// interface.
+	Failovers = "if_reliability_failovers_total"
+	// LastFailover is the Unix time of the last failover event.
+	LastFailover = "if_reliability_last_failover_timestamp_seconds"
+	// BackupVerified is the Unix time the backup path was last proven to
+	// work by a drill or a real failover, labelled by interface.
+	BackupVerified = "if_reliability_backup_last_verified_timestamp_seconds"
+	// Reliability is the long-term reliability score of a link between 0
+	// and 1, the success ratio with older probes decayed, labelled by
+	// interface.
+	Reliability = "if_reliability_link_reliability_ratio"
+	// Signal is the last signal strength (RSSI) of a WiFi link in dBm,
+	// labelled by interface.
+	Signal = "if_reliability_wifi_signal_dbm"
+	// PathMTU is the last path MTU discovered toward the first endpoint over
+	// a link in bytes, labelled by interface.
+	PathMTU = "if_reliability_path_mtu_bytes"
+	// Throughput is the last throughput measured on a link before failing
+	// over to it in Mbit/s, labelled by interface.
+	Throughput = "if_reliability_throughput_mbps"
+	// PathScore is the last path score of a link in best-path mode between
+	// 0 and 100, labelled by interface.
+	PathScore = "if_reliability_path_score"
+	// LinkUsage is the traffic of a link since the start of the billing
+	// period in bytes, labelled by interface and direction (rx or tx).
+	LinkUsage = "if_reliability_link_usage_bytes"
+	// PeerMaster is 1 while the instance is the master of a pair of
+	// gateways and asserts the routes, 0 while it stands by.
+	PeerMaster = "if_reliability_peer_master"
+	// DataCap is the data cap of a link over a billing period in bytes,
+	// labelled by interface.
+	DataCap = "if_reliability_data_cap_bytes"
+)
+
+// Label names.
+const (
+	LabelInterface = "interface"
+	LabelEndpoint  = "endpoint"
+	LabelFrom      = "from"
+	LabelTo        = "to"
+	LabelDirection = "direction"
+	LabelPhase     = "phase"
+	LabelState     = "state"
+	// LabelOutage is the label of the exemplars, holding the ID of the
+	// outage they were observed during.
+	LabelOutage = "outage_id"
+)
+
+// Registry holds the metrics of an instance. Its methods are safe for
+// concurrent use.
+type Registry struct {
+	linkMu     sync.Mutex
+	state      string
+	activeLink string
+	links      map[string]bool
+	failovers  map[failoverKey]uint64
+	// failoverExemplars are the last failovers made during an outage.
+	failoverExemplars map[failoverKey]exemplar
+	lastFailover      time.Time
+	backupVerified    map[string]time.Time
+	reliability       map[string]float64
+	signal            map[string]int
+	throughput        map[string]float64
+	pathScore         map[string]float64
+	pathMTU           map[string]int
+	usageRx           map[string]uint64
+	usageTx           map[string]uint64
+	dataCap           map[string]uint64
+	// peerMaster is 1 for the master of a pair, 0 for the standby, and
+	// negative outside of a pair.
+	peerMaster float64
+
+	probeMu      sync.Mutex
+	probeMetrics map[probeKey]*probeStats
+
+	execMu    sync.Mutex
+	execStats map[string]*ExecStats
+
+	eventMu     sync.Mutex
+	eventCounts map[string]uint64
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{
+		links:             map[string]bool{},
+		failovers:         map[failoverKey]uint64{},
+		failoverExemplars: map[failoverKey]exemplar{},
+		backupVerified:    map[string]time.Time{},
+		reliability:       map[string]float64{},
+		signal:            map[string]int{},
+		throughput:        map[string]float64{},
+		pathScore:         map[string]float64{},
+		pathMTU:           map[string]int{},
+		usageRx:           map[string]uint64{},
+		usageTx:           map[string]uint64{},
+		dataCap:           map[string]uint64{},
+		peerMaster:        -1,
+		probeMetrics:      map[probeKey]*probeStats{},
+		execStats:         map[string]*ExecStats{},
+		eventCounts:       map[string]uint64{},
+	}
+}
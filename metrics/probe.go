@@ -0,0 +1,174 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// RTTBuckets are the upper bounds, in seconds, of the probe RTT histogram
+// buckets.
+var RTTBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// probeKey identifies the probes of one endpoint over one interface.
+type probeKey struct {
+	Interface string
+	Endpoint  string
+}
+
+// probeStats are the statistics of the probes of one endpoint over one
+// interface.
+type probeStats struct {
+	// buckets counts the RTTs up to each bound of RTTBuckets.
+	buckets  []uint64
+	count    uint64
+	sum      float64
+	failures uint64
+	// failed counts every failure, last is the RTT of the last successful
+	// probe in seconds, at the time of the last probe and failing the time
+	// of the first of the consecutive failures.
+	failed  uint64
+	last    float64
+	at      time.Time
+	failing time.Time
+	// phases are the phases of the last probe, by name, if timed.
+	phases map[string]float64
+	// exemplars are the last RTTs observed during an outage that fell in
+	// each bucket, the last one for +Inf.
+	exemplars []exemplar
+}
+
+// exemplar is an observation made during an outage, exposed with the ID of
+// the outage in OpenMetrics so that a dashboard can link a point of a
+// series to the logs and alerts of the outage.
+type exemplar struct {
+	outage string
+	value  float64
+	at     time.Time
+}
+
+// newProbeStats returns empty probe statistics.
+func newProbeStats() *probeStats {
+	return &probeStats{buckets: make([]uint64, len(RTTBuckets)), exemplars: make([]exemplar, len(RTTBuckets)+1)}
+}
+
+// ObserveProbe records a probe toward endpoint over ifname: its RTT if it
+// succeeded, a consecutive failure otherwise. The RTT of a probe made during
+// the outage whose ID is outage, if not empty, becomes the exemplar of its
+// bucket.
+func (r *Registry) ObserveProbe(ifname, endpoint string, rtt time.Duration, ok bool, outage string) {
+	r.probeMu.Lock()
+	defer r.probeMu.Unlock()
+	key := probeKey{Interface: ifname, Endpoint: endpoint}
+	s, found := r.probeMetrics[key]
+	if !found {
+		s = newProbeStats()
+		r.probeMetrics[key] = s
+	}
+	s.at = time.Now()
+	if !ok {
+		if s.failures == 0 {
+			s.failing = s.at
+		}
+		s.failures++
+		s.failed++
+		return
+	}
+	s.failures = 0
+	s.failing = time.Time{}
+	seconds := rtt.Seconds()
+	s.last = seconds
+	bucket := len(RTTBuckets)
+	for i, bound := range RTTBuckets {
+		if seconds <= bound {
+			s.buckets[i]++
+			bucket = min(bucket, i)
+		}
+	}
+	s.count++
+	s.sum += seconds
+	if outage != "" {
+		s.exemplars[bucket] = exemplar{outage: outage, value: seconds, at: s.at}
+	}
+}
+
+// ObservePhases records the durations of the phases of the last probe toward
+// endpoint over ifname, by name.
+func (r *Registry) ObservePhases(ifname, endpoint string, phases map[string]time.Duration) {
+	r.probeMu.Lock()
+	defer r.probeMu.Unlock()
+	key := probeKey{Interface: ifname, Endpoint: endpoint}
+	s, found := r.probeMetrics[key]
+	if !found {
+		s = newProbeStats()
+		r.probeMetrics[key] = s
+	}
+	s.phases = map[string]float64{}
+	for name, d := range phases {
+		s.phases[name] = d.Seconds()
+	}
+}
+
+// RemoveEndpoint forgets the probes toward endpoint over every interface,
+// e.g. once it is no longer probed.
+func (r *Registry) RemoveEndpoint(endpoint string) {
+	r.probeMu.Lock()
+	defer r.probeMu.Unlock()
+	for key := range r.probeMetrics {
+		if key.Endpoint == endpoint {
+			delete(r.probeMetrics, key)
+		}
+	}
+}
+
+// ProbeStatus is the last outcome of the probes of one endpoint over one
+// interface.
+type ProbeStatus struct {
+	Interface string `json:"interface"`
+	Endpoint  string `json:"endpoint"`
+	// RTT is the round-trip time of the last successful probe, zero if
+	// none succeeded.
+	RTT                 time.Duration `json:"rtt"`
+	ConsecutiveFailures uint64        `json:"consecutive_failures"`
+	Failures            uint64        `json:"failures"`
+	// Last is the time of the last probe.
+	Last time.Time `json:"last"`
+	// FailingSince is the time of the first of the consecutive failures,
+	// omitted if the last probe succeeded.
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+}
+
+// Probes returns the last outcome of the probes of every endpoint over every
+// interface, sorted by interface and endpoint.
+func (r *Registry) Probes() []ProbeStatus {
+	r.probeMu.Lock()
+	defer r.probeMu.Unlock()
+	probes := make([]ProbeStatus, 0, len(r.probeMetrics))
+	for key, s := range r.probeMetrics {
+		if s.at.IsZero() {
+			continue
+		}
+		p := ProbeStatus{
+			Interface:           key.Interface,
+			Endpoint:            key.Endpoint,
+			RTT:                 time.Duration(s.last * float64(time.Second)),
+			ConsecutiveFailures: s.failures,
+			Failures:            s.failed,
+			Last:                s.at,
+		}
+		if !s.failing.IsZero() {
+			failing := s.failing
+			p.FailingSince = &failing
+		}
+		probes = append(probes, p)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		if probes[i].Interface != probes[j].Interface {
+			return probes[i].Interface < probes[j].Interface
+		}
+		return probes[i].Endpoint < probes[j].Endpoint
+	})
+	return probes
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package modem reads the signal quality of an LTE modem and reconnects it
+// through ModemManager on the system bus.
+package modem
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// BusName is the well-known name of ModemManager on the system bus.
+const BusName = "org.freedesktop.ModemManager1"
+
+// D-Bus object paths and interfaces of ModemManager.
+const (
+	objectPath      = "/org/freedesktop/ModemManager1"
+	modemInterface  = BusName + ".Modem"
+	signalInterface = BusName + ".Modem.Signal"
+	bearerInterface = BusName + ".Bearer"
+)
+
+// portNet is the type of the network ports of a modem, see MMModemPortType.
+const portNet = 2
+
+// ErrNotFound is returned when no modem matches the interface.
+var ErrNotFound = errors.New("modem not found")
+
+// Client performs ModemManager operations over the system bus.
+type Client struct {
+	conn *dbus.Conn
+}
+
+// Dial connects to ModemManager on the system bus.
+func Dial() (*Client, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection to the bus.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Find returns the object path of the modem whose network port is ifname,
+// e.g. wwan0, or of the only modem if ifname is empty.
+func (c *Client) Find(ifname string) (dbus.ObjectPath, error) {
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err := c.conn.Object(BusName, objectPath).Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects)
+	if err != nil {
+		return "", err
+	}
+	var modems []dbus.ObjectPath
+	for path, interfaces := range objects {
+		properties, ok := interfaces[modemInterface]
+		if !ok {
+			continue
+		}
+		modems = append(modems, path)
+		var ports [][]interface{}
+		if err := dbus.Store([]interface{}{properties["Ports"].Value()}, &ports); err != nil {
+			continue
+		}
+		for _, port := range ports {
+			if len(port) == 2 && port[0] == ifname && port[1] == uint32(portNet) {
+				return path, nil
+			}
+		}
+	}
+	if ifname == "" && len(modems) == 1 {
+		return modems[0], nil
+	}
+	if ifname == "" {
+		return "", fmt.Errorf("%w: %d modems, name the interface", ErrNotFound, len(modems))
+	}
+	return "", fmt.Errorf("%w with the network interface %s", ErrNotFound, ifname)
+}
+
+// SetupSignal has the modem refresh its signal quality every rate.
+func (c *Client) SetupSignal(modem dbus.ObjectPath, rate time.Duration) error {
+	seconds := max(uint32(rate/time.Second), 1)
+	return c.conn.Object(BusName, modem).Call(signalInterface+".Setup", 0, seconds).Err
+}
+
+// Signal returns the last LTE signal quality of the modem.
+func (c *Client) Signal(modem dbus.ObjectPath) (Signal, error) {
+	value, err := c.conn.Object(BusName, modem).GetProperty(signalInterface + ".Lte")
+	if err != nil {
+		return Signal{}, err
+	}
+	values, _ := value.Value().(map[string]dbus.Variant)
+	return signalOf(values), nil
+}
+
+// Reconnect resets the data bearers of the modem: each one is disconnected
+// and connected again, waiting up to timeout for it to come back.
+func (c *Client) Reconnect(modem dbus.ObjectPath, timeout time.Duration) error {
+	value, err := c.conn.Object(BusName, modem).GetProperty(modemInterface + ".Bearers")
+	if err != nil {
+		return err
+	}
+	bearers, _ := value.Value().([]dbus.ObjectPath)
+	if len(bearers) == 0 {
+		return fmt.Errorf("no bearer to reconnect")
+	}
+	for _, path := range bearers {
+		bearer := c.conn.Object(BusName, path)
+		if err := bearer.Call(bearerInterface+".Disconnect", 0).Err; err != nil {
+			return fmt.Errorf("disconnecting bearer %s: %w", path, err)
+		}
+		call := bearer.Go(bearerInterface+".Connect", 0, make(chan *dbus.Call, 1))
+		select {
+		case <-call.Done:
+			if call.Err != nil {
+				return fmt.Errorf("connecting bearer %s: %w", path, call.Err)
+			}
+		case <-time.After(timeout):
+			return fmt.Errorf("bearer %s not connected after %s", path, timeout)
+		}
+	}
+	return nil
+}
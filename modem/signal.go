@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package modem
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Signal is the LTE signal quality of a modem. A value the modem does not
+// report is NaN.
+type Signal struct {
+	// RSRP is the reference signal received power in dBm.
+	RSRP float64
+	// RSRQ is the reference signal received quality in dB.
+	RSRQ float64
+	// SINR is the signal to interference plus noise ratio in dB.
+	SINR float64
+}
+
+// signalOf returns the signal of the Lte property of ModemManager.
+func signalOf(values map[string]dbus.Variant) Signal {
+	get := func(key string) float64 {
+		if value, ok := values[key].Value().(float64); ok {
+			return value
+		}
+		return math.NaN()
+	}
+	return Signal{RSRP: get("rsrp"), RSRQ: get("rsrq"), SINR: get("snr")}
+}
+
+// String renders the signal, e.g. "RSRP -98 dBm, RSRQ -11 dB, SINR 7.5 dB".
+func (s Signal) String() string {
+	return fmt.Sprintf("RSRP %s dBm, RSRQ %s dB, SINR %s dB", format(s.RSRP), format(s.RSRQ), format(s.SINR))
+}
+
+// Fields renders the signal as the three values separated by spaces, as
+// parsed by ParseFields.
+func (s Signal) Fields() string {
+	return strings.Join([]string{format(s.RSRP), format(s.RSRQ), format(s.SINR)}, " ")
+}
+
+// ParseFields parses a signal rendered by Fields.
+func ParseFields(text string) (Signal, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return Signal{}, fmt.Errorf("invalid signal %q", text)
+	}
+	var values [3]float64
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return Signal{}, fmt.Errorf("invalid signal %q", text)
+		}
+		values[i] = value
+	}
+	return Signal{RSRP: values[0], RSRQ: values[1], SINR: values[2]}, nil
+}
+
+// format renders a value, "NaN" if it is not reported.
+func format(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// Thresholds are the minimum signal quality of a usable link. A threshold of
+// 0 is disabled.
+type Thresholds struct {
+	MinRSRP float64
+	MinRSRQ float64
+	MinSINR float64
+}
+
+// Enabled reports whether any threshold is set.
+func (t Thresholds) Enabled() bool {
+	return t.MinRSRP != 0 || t.MinRSRQ != 0 || t.MinSINR != 0
+}
+
+// Below describes the values of s below their threshold, e.g.
+// "RSRP -121 dBm below -110 dBm", or returns an empty string. Values the
+// modem does not report are never below.
+func (t Thresholds) Below(s Signal) string {
+	var below []string
+	check := func(name string, value, threshold float64, unit string) {
+		if threshold != 0 && value < threshold {
+			below = append(below, fmt.Sprintf("%s %s %s below %s %s", name, format(value), unit, format(threshold), unit))
+		}
+	}
+	check("RSRP", s.RSRP, t.MinRSRP, "dBm")
+	check("RSRQ", s.RSRQ, t.MinRSRQ, "dB")
+	check("SINR", s.SINR, t.MinSINR, "dB")
+	return strings.Join(below, ", ")
+}
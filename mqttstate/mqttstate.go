@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package mqttstate publishes the connectivity state of a device to an MQTT
+// broker as a retained JSON message, so that a fleet dashboard subscribing
+// to the topics of the devices always has the last state of each, including
+// the devices that went silent. The broker clears the online flag of a
+// device it lost the connection to.
+package mqttstate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Options configure the connection to the broker.
+type Options struct {
+	// Broker is the URL of the broker: tcp://host:1883, or ssl://host:8883
+	// over TLS.
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// CAFile holds the certificates the broker certificate is verified
+	// against, the system ones if empty. CertFile and KeyFile hold the
+	// client certificate, if the broker authenticates clients with one.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Topic is the topic the state is published to, and Topic + "/online"
+	// the one of the online flag.
+	Topic string
+	// QoS is the MQTT quality of service of the messages, 0, 1 or 2.
+	QoS byte
+	// Timeout bounds the connection and each publication.
+	Timeout time.Duration
+}
+
+// Link is the recent quality of a link.
+type Link struct {
+	// RTTMillis is the mean RTT of the successful probes.
+	RTTMillis float64 `json:"rtt_ms"`
+	// Loss is the ratio of failed probes, between 0 and 1.
+	Loss    float64 `json:"loss"`
+	Samples int     `json:"samples"`
+}
+
+// State is the published message.
+type State struct {
+	Time time.Time `json:"time"`
+	Site string    `json:"site"`
+	// State is the state of the failover, e.g. on-backup.
+	State           string          `json:"state"`
+	ActiveInterface string          `json:"active_interface"`
+	Links           map[string]Link `json:"links"`
+	// LastFailover is when the traffic last moved to another link, unset
+	// if it never did since the start.
+	LastFailover *time.Time `json:"last_failover,omitempty"`
+}
+
+// Publisher publishes states to a broker, reconnecting when the connection
+// is lost.
+type Publisher struct {
+	client  paho.Client
+	topic   string
+	qos     byte
+	timeout time.Duration
+}
+
+// Connect connects to the broker of o.
+func Connect(o Options) (*Publisher, error) {
+	if o.QoS > 2 {
+		return nil, fmt.Errorf("invalid QoS %d", o.QoS)
+	}
+	if o.Topic == "" {
+		return nil, fmt.Errorf("empty topic")
+	}
+	online := o.Topic + "/online"
+	opts := paho.NewClientOptions().
+		AddBroker(o.Broker).
+		SetClientID(o.ClientID).
+		SetUsername(o.Username).
+		SetPassword(o.Password).
+		SetConnectTimeout(o.Timeout).
+		SetWriteTimeout(o.Timeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetWill(online, "false", o.QoS, true).
+		SetOnConnectHandler(func(c paho.Client) {
+			c.Publish(online, o.QoS, true, "true")
+		})
+	if o.CAFile != "" || o.CertFile != "" {
+		config, err := tlsConfig(o)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(config)
+	}
+	p := &Publisher{client: paho.NewClient(opts), topic: o.Topic, qos: o.QoS, timeout: o.Timeout}
+	// The client keeps retrying in the background, so that a device
+	// starting offline publishes once the broker is reachable: the
+	// publisher is returned with the error.
+	token := p.client.Connect()
+	if !token.WaitTimeout(o.Timeout) {
+		return p, fmt.Errorf("not connected to %s within %s", o.Broker, o.Timeout)
+	}
+	if err := token.Error(); err != nil {
+		return p, fmt.Errorf("connecting to %s: %w", o.Broker, err)
+	}
+	return p, nil
+}
+
+// tlsConfig returns the TLS settings of o.
+func tlsConfig(o Options) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Publish publishes s as the retained state of the device.
+func (p *Publisher) Publish(s State) error {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if !p.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to the broker")
+	}
+	token := p.client.Publish(p.topic, p.qos, true, payload)
+	if !token.WaitTimeout(p.timeout) {
+		return fmt.Errorf("publication not acknowledged within %s", p.timeout)
+	}
+	return token.Error()
+}
+
+// Close marks the device offline and disconnects, the broker then keeping
+// the last published state.
+func (p *Publisher) Close() {
+	if p.client.IsConnectionOpen() {
+		p.client.Publish(p.topic+"/online", p.qos, true, "false").WaitTimeout(p.timeout)
+	}
+	p.client.Disconnect(uint(p.timeout / time.Millisecond))
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package netns runs code inside a named network namespace. Sockets created
+// inside the namespace stay in it after Do returns.
+package netns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// dir is where ip-netns(8) keeps the named namespaces.
+const dir = "/var/run/netns"
+
+// Do runs fn inside the named network namespace. If name is empty, fn runs in
+// the current namespace.
+func Do(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+	runtime.LockOSThread()
+
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+	target, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("network namespace %s: %w", name, err)
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("entering network namespace %s: %w", name, err)
+	}
+	err = fn()
+	if restoreErr := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); restoreErr != nil {
+		// Keep the thread locked so that it is never reused by other
+		// goroutines while still inside the namespace.
+		return fmt.Errorf("leaving network namespace %s: %w", name, restoreErr)
+	}
+	runtime.UnlockOSThread()
+	return err
+}
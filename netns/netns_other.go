@@ -0,0 +1,21 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+// Package netns runs code inside a named network namespace. Sockets created
+// inside the namespace stay in it after Do returns.
+package netns
+
+import (
+	"fmt"
+)
+
+// Do runs fn. Network namespaces only exist on Linux, so a non-empty name is
+// an error.
+func Do(name string, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+	return fmt.Errorf("network namespaces are not supported on this platform")
+}
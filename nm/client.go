@@ -0,0 +1,426 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package nm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// D-Bus object paths and interfaces of NetworkManager.
+const (
+	objectPath      = "/org/freedesktop/NetworkManager"
+	settingsPath    = "/org/freedesktop/NetworkManager/Settings"
+	deviceInterface = BusName + ".Device"
+	wifiInterface   = BusName + ".Device.Wireless"
+)
+
+// Device states, see NMDeviceState.
+const (
+	stateDisconnected = 30
+	statePrepare      = 40
+	stateIPConfig     = 70
+	stateActivated    = 100
+	stateFailed       = 120
+)
+
+// Failure reasons of a connection.
+var (
+	// ErrNotFound is returned when no access point broadcasts the SSID.
+	ErrNotFound = errors.New("access point not found")
+	// ErrAuth is returned when the access point rejected the credentials,
+	// usually because of a wrong password.
+	ErrAuth = errors.New("authentication failed, wrong password?")
+	// ErrIPConfig is returned when no IP configuration was obtained, e.g.
+	// because DHCP failed.
+	ErrIPConfig = errors.New("IP configuration failed")
+	// ErrTimeout is returned when the connection did not complete in time.
+	ErrTimeout = errors.New("timeout")
+	// ErrFailed is returned for the other activation failures.
+	ErrFailed = errors.New("activation failed")
+)
+
+// reasons maps the NMDeviceStateReason values to the failure reasons.
+var reasons = map[uint32]error{
+	5:  ErrIPConfig, // IP_CONFIG_UNAVAILABLE
+	7:  ErrAuth,     // NO_SECRETS
+	8:  ErrAuth,     // SUPPLICANT_DISCONNECT
+	9:  ErrAuth,     // SUPPLICANT_CONFIG_FAILED
+	15: ErrIPConfig, // DHCP_START_FAILED
+	16: ErrIPConfig, // DHCP_ERROR
+	17: ErrIPConfig, // DHCP_FAILED
+	53: ErrNotFound, // SSID_NOT_FOUND
+}
+
+// reasonError returns the failure reason of a device state reason.
+func reasonError(reason uint32) error {
+	if err, ok := reasons[reason]; ok {
+		return fmt.Errorf("%w (reason %d)", err, reason)
+	}
+	return fmt.Errorf("%w (reason %d)", ErrFailed, reason)
+}
+
+// Client performs NetworkManager operations over the system bus.
+type Client struct {
+	conn *dbus.Conn
+}
+
+// Dial connects to NetworkManager on the system bus.
+func Dial() (*Client, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection to the bus.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// device returns the object path of the device managing ifname.
+func (c *Client) device(ifname string) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	err := c.conn.Object(BusName, objectPath).Call(BusName+".GetDeviceByIpIface", 0, ifname).Store(&path)
+	if err != nil {
+		return "", fmt.Errorf("device %s: %w", ifname, err)
+	}
+	return path, nil
+}
+
+// Profile returns the object path and name of an existing WiFi connection
+// profile for ssid, or an empty path if there is none.
+func (c *Client) Profile(ssid string) (dbus.ObjectPath, string, error) {
+	var paths []dbus.ObjectPath
+	if err := c.conn.Object(BusName, settingsPath).Call(BusName+".Settings.ListConnections", 0).Store(&paths); err != nil {
+		return "", "", err
+	}
+	for _, path := range paths {
+		var settings map[string]map[string]dbus.Variant
+		if err := c.conn.Object(BusName, path).Call(BusName+".Settings.Connection.GetSettings", 0).Store(&settings); err != nil {
+			continue
+		}
+		value, _ := settings["802-11-wireless"]["ssid"].Value().([]byte)
+		if !bytes.Equal(value, []byte(ssid)) {
+			continue
+		}
+		name, _ := settings["connection"]["id"].Value().(string)
+		return path, name, nil
+	}
+	return "", "", nil
+}
+
+// AccessPoint is an access point seen by a device.
+type AccessPoint struct {
+	SSID  string
+	BSSID string
+	// Strength is the signal quality in percent.
+	Strength uint8
+}
+
+// accessPoints returns the access points seen by the device, with their
+// object paths.
+func (c *Client) accessPoints(device dbus.ObjectPath) ([]AccessPoint, []dbus.ObjectPath, error) {
+	var paths []dbus.ObjectPath
+	if err := c.conn.Object(BusName, device).Call(wifiInterface+".GetAllAccessPoints", 0).Store(&paths); err != nil {
+		return nil, nil, err
+	}
+	var aps []AccessPoint
+	var found []dbus.ObjectPath
+	for _, path := range paths {
+		ap := c.conn.Object(BusName, path)
+		ssid, err := ap.GetProperty(BusName + ".AccessPoint.Ssid")
+		if err != nil {
+			continue
+		}
+		name, _ := ssid.Value().([]byte)
+		var bssid string
+		if value, err := ap.GetProperty(BusName + ".AccessPoint.HwAddress"); err == nil {
+			bssid, _ = value.Value().(string)
+		}
+		var strength uint8
+		if value, err := ap.GetProperty(BusName + ".AccessPoint.Strength"); err == nil {
+			strength, _ = value.Value().(uint8)
+		}
+		aps = append(aps, AccessPoint{SSID: string(name), BSSID: bssid, Strength: strength})
+		found = append(found, path)
+	}
+	return aps, found, nil
+}
+
+// accessPoint returns the path of an access point seen by the device
+// broadcasting ssid, with the given bssid if not empty, or an empty path.
+func (c *Client) accessPoint(device dbus.ObjectPath, ssid, bssid string) (dbus.ObjectPath, error) {
+	aps, paths, err := c.accessPoints(device)
+	if err != nil {
+		return "", err
+	}
+	for i, ap := range aps {
+		if ap.SSID == ssid && (bssid == "" || strings.EqualFold(ap.BSSID, bssid)) {
+			return paths[i], nil
+		}
+	}
+	return "", nil
+}
+
+// findAccessPoint returns an access point broadcasting ssid, with the given
+// bssid if not empty, requesting a scan and waiting for up to timeout if none
+// is known yet.
+func (c *Client) findAccessPoint(device dbus.ObjectPath, ssid, bssid string, timeout time.Duration) (dbus.ObjectPath, error) {
+	if path, err := c.accessPoint(device, ssid, bssid); err != nil || path != "" {
+		return path, err
+	}
+	// Scans are refused while one is running, which is fine.
+	c.conn.Object(BusName, device).Call(wifiInterface+".RequestScan", 0, map[string]dbus.Variant{})
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		if path, err := c.accessPoint(device, ssid, bssid); err != nil || path != "" {
+			return path, err
+		}
+	}
+	if bssid != "" {
+		return "", fmt.Errorf("%w: %s (%s)", ErrNotFound, ssid, bssid)
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, ssid)
+}
+
+// Scan requests a scan on ifname, waits up to wait for it to complete, and
+// returns the access points seen.
+func (c *Client) Scan(ifname string, wait time.Duration) ([]AccessPoint, error) {
+	device, err := c.device(ifname)
+	if err != nil {
+		return nil, err
+	}
+	obj := c.conn.Object(BusName, device)
+	lastScan := func() int64 {
+		value, err := obj.GetProperty(wifiInterface + ".LastScan")
+		if err != nil {
+			return 0
+		}
+		last, _ := value.Value().(int64)
+		return last
+	}
+	before := lastScan()
+	// Scans are refused while one is running, whose results are as good.
+	obj.Call(wifiInterface+".RequestScan", 0, map[string]dbus.Variant{})
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) && lastScan() == before {
+		time.Sleep(500 * time.Millisecond)
+	}
+	aps, _, err := c.accessPoints(device)
+	return aps, err
+}
+
+// ConnectWiFi connects ifname to ssid, through the access point bssid if not
+// empty, and waits up to timeout until it is associated and authenticated.
+// An existing connection profile for ssid is activated as is, otherwise a
+// profile is created with the 802.1X authentication eap if not nil, or with
+// password, open if empty. The IP configuration continues in the background.
+func (c *Client) ConnectWiFi(ifname, ssid, bssid, password string, eap *wifi.Enterprise, timeout time.Duration) error {
+	device, err := c.device(ifname)
+	if err != nil {
+		return err
+	}
+	ap, err := c.findAccessPoint(device, ssid, bssid, timeout)
+	if err != nil {
+		return err
+	}
+	// NetworkManager picks the access point of the SSID unless given one.
+	specific := dbus.ObjectPath("/")
+	if bssid != "" {
+		specific = ap
+	}
+	return c.activate(device, stateIPConfig, timeout, func() error {
+		profile, name, err := c.Profile(ssid)
+		if err != nil {
+			return err
+		}
+		nm := c.conn.Object(BusName, objectPath)
+		if profile != "" {
+			log.Debug().Msgf("Activating the existing connection profile %s for %s", name, ssid)
+			return nm.Call(BusName+".ActivateConnection", 0, profile, device, specific).Err
+		}
+		settings := map[string]map[string]dbus.Variant{
+			"connection": {
+				"id":          dbus.MakeVariant(ssid),
+				"type":        dbus.MakeVariant("802-11-wireless"),
+				"autoconnect": dbus.MakeVariant(false),
+			},
+			"802-11-wireless": {
+				"ssid": dbus.MakeVariant([]byte(ssid)),
+				"mode": dbus.MakeVariant("infrastructure"),
+			},
+			"ipv4": {"method": dbus.MakeVariant("auto")},
+		}
+		switch {
+		case eap != nil:
+			settings["802-11-wireless-security"] = map[string]dbus.Variant{
+				"key-mgmt": dbus.MakeVariant("wpa-eap"),
+			}
+			settings["802-1x"] = enterpriseSettings(eap, password)
+		case password != "":
+			settings["802-11-wireless-security"] = map[string]dbus.Variant{
+				"key-mgmt": dbus.MakeVariant("wpa-psk"),
+				"psk":      dbus.MakeVariant(password),
+			}
+		}
+		return nm.Call(BusName+".AddAndActivateConnection", 0, settings, device, specific).Err
+	})
+}
+
+// enterpriseSettings returns the 802-1x settings of eap, with password the
+// user password of PEAP and TTLS or the password of the TLS private key.
+func enterpriseSettings(eap *wifi.Enterprise, password string) map[string]dbus.Variant {
+	settings := map[string]dbus.Variant{
+		"eap":      dbus.MakeVariant([]string{eap.EAP}),
+		"identity": dbus.MakeVariant(eap.Identity),
+	}
+	// Certificates are given to NetworkManager as NUL-terminated file URIs
+	// of absolute paths.
+	file := func(path string) dbus.Variant {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		return dbus.MakeVariant([]byte("file://" + path + "\x00"))
+	}
+	if eap.CACert != "" {
+		settings["ca-cert"] = file(eap.CACert)
+	}
+	if eap.EAP == wifi.EAPTLS {
+		settings["client-cert"] = file(eap.ClientCert)
+		settings["private-key"] = file(eap.PrivateKey)
+		if password != "" {
+			settings["private-key-password"] = dbus.MakeVariant(password)
+		}
+		return settings
+	}
+	settings["phase2-auth"] = dbus.MakeVariant(eap.Inner())
+	settings["password"] = dbus.MakeVariant(password)
+	if eap.AnonymousIdentity != "" {
+		settings["anonymous-identity"] = dbus.MakeVariant(eap.AnonymousIdentity)
+	}
+	return settings
+}
+
+// ConnectDevice activates the best available connection profile on ifname
+// and waits up to timeout until it is fully activated.
+func (c *Client) ConnectDevice(ifname string, timeout time.Duration) error {
+	device, err := c.device(ifname)
+	if err != nil {
+		return err
+	}
+	return c.activate(device, stateActivated, timeout, func() error {
+		return c.conn.Object(BusName, objectPath).Call(BusName+".ActivateConnection", 0, dbus.ObjectPath("/"), device, dbus.ObjectPath("/")).Err
+	})
+}
+
+// Disconnect disconnects ifname and keeps it from connecting automatically.
+func (c *Client) Disconnect(ifname string) error {
+	device, err := c.device(ifname)
+	if err != nil {
+		return err
+	}
+	return c.conn.Object(BusName, device).Call(deviceInterface+".Disconnect", 0).Err
+}
+
+// Nameservers returns the DNS servers NetworkManager learned on ifname, IPv4
+// ones first.
+func (c *Client) Nameservers(ifname string) ([]string, error) {
+	device, err := c.device(ifname)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	value, err := c.conn.Object(BusName, device).GetProperty(deviceInterface + ".Ip4Config")
+	if err != nil {
+		return nil, err
+	}
+	if path, _ := value.Value().(dbus.ObjectPath); path != "" && path != "/" {
+		data, err := c.conn.Object(BusName, path).GetProperty(BusName + ".IP4Config.NameserverData")
+		if err != nil {
+			return nil, err
+		}
+		entries, _ := data.Value().([]map[string]dbus.Variant)
+		for _, entry := range entries {
+			if address, ok := entry["address"].Value().(string); ok {
+				servers = append(servers, address)
+			}
+		}
+	}
+	value, err = c.conn.Object(BusName, device).GetProperty(deviceInterface + ".Ip6Config")
+	if err != nil {
+		return nil, err
+	}
+	if path, _ := value.Value().(dbus.ObjectPath); path != "" && path != "/" {
+		data, err := c.conn.Object(BusName, path).GetProperty(BusName + ".IP6Config.Nameservers")
+		if err != nil {
+			return nil, err
+		}
+		addresses, _ := data.Value().([][]byte)
+		for _, address := range addresses {
+			if len(address) == net.IPv6len {
+				servers = append(servers, net.IP(address).String())
+			}
+		}
+	}
+	return servers, nil
+}
+
+// activate calls start, then follows the state changes of device until it
+// reaches the target state, fails or timeout elapses.
+func (c *Client) activate(device dbus.ObjectPath, target uint32, timeout time.Duration, start func() error) error {
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(device),
+		dbus.WithMatchInterface(deviceInterface),
+		dbus.WithMatchMember("StateChanged"),
+	}
+	if err := c.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer c.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 16)
+	c.conn.Signal(signals)
+	defer c.conn.RemoveSignal(signals)
+
+	if err := start(); err != nil {
+		return err
+	}
+	deadline := time.After(timeout)
+	started := false
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != device || len(signal.Body) != 3 {
+				continue
+			}
+			state, _ := signal.Body[0].(uint32)
+			reason, _ := signal.Body[2].(uint32)
+			log.Debug().Msgf("Device %s state %d, reason %d", device, state, reason)
+			switch {
+			case state >= target && state <= stateActivated:
+				return nil
+			case state == stateFailed:
+				return reasonError(reason)
+			case state >= statePrepare && state < stateActivated:
+				// Not while deactivating a previous connection.
+				started = true
+			case state <= stateDisconnected && started:
+				return reasonError(reason)
+			}
+		case <-deadline:
+			return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package nm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// ErrNoConnection is returned when renewing the lease of a device without
+// active connection.
+var ErrNoConnection = errors.New("no active connection")
+
+// Lease is the DHCPv4 lease NetworkManager obtained on a device.
+type Lease struct {
+	// Address is the leased address.
+	Address string
+	// Server is the DHCP server that granted the lease.
+	Server string
+	// Router is the first router of the lease, empty if it has none.
+	Router string
+	// Nameservers are the DNS servers of the lease.
+	Nameservers []string
+	// Expiry is when the lease expires, zero if unknown or infinite.
+	Expiry time.Time
+}
+
+// Expired reports whether the lease expired at now.
+func (l *Lease) Expired(now time.Time) bool {
+	return !l.Expiry.IsZero() && !now.Before(l.Expiry)
+}
+
+// String describes the lease, e.g. "192.168.1.23 from 192.168.1.1, router
+// 192.168.1.1, expires 2024-05-01T10:00:00Z".
+func (l *Lease) String() string {
+	text := l.Address
+	if l.Server != "" {
+		text += " from " + l.Server
+	}
+	if l.Router != "" {
+		text += ", router " + l.Router
+	}
+	if !l.Expiry.IsZero() {
+		text += ", expires " + l.Expiry.UTC().Format(time.RFC3339)
+	}
+	return text
+}
+
+// Lease returns the DHCPv4 lease of ifname, nil if it has none yet or is not
+// configured with DHCP.
+func (c *Client) Lease(ifname string) (*Lease, error) {
+	device, err := c.device(ifname)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.conn.Object(BusName, device).GetProperty(deviceInterface + ".Dhcp4Config")
+	if err != nil {
+		return nil, err
+	}
+	path, _ := value.Value().(dbus.ObjectPath)
+	if path == "" || path == "/" {
+		return nil, nil
+	}
+	value, err = c.conn.Object(BusName, path).GetProperty(BusName + ".DHCP4Config.Options")
+	if err != nil {
+		return nil, err
+	}
+	options, _ := value.Value().(map[string]dbus.Variant)
+	option := func(name string) string {
+		text, _ := options[name].Value().(string)
+		return strings.TrimSpace(text)
+	}
+	l := &Lease{
+		Address:     option("ip_address"),
+		Server:      option("dhcp_server_identifier"),
+		Nameservers: strings.Fields(option("domain_name_servers")),
+	}
+	if l.Address == "" {
+		return nil, nil
+	}
+	if routers := strings.Fields(option("routers")); len(routers) > 0 {
+		l.Router = routers[0]
+	}
+	if expiry, err := strconv.ParseInt(option("expiry"), 10, 64); err == nil && expiry > 0 {
+		l.Expiry = time.Unix(expiry, 0)
+	}
+	return l, nil
+}
+
+// Renew makes NetworkManager obtain a new lease on ifname by activating its
+// active connection again, and waits up to timeout until it is fully
+// activated.
+func (c *Client) Renew(ifname string, timeout time.Duration) error {
+	device, err := c.device(ifname)
+	if err != nil {
+		return err
+	}
+	value, err := c.conn.Object(BusName, device).GetProperty(deviceInterface + ".ActiveConnection")
+	if err != nil {
+		return err
+	}
+	active, _ := value.Value().(dbus.ObjectPath)
+	if active == "" || active == "/" {
+		return fmt.Errorf("device %s: %w", ifname, ErrNoConnection)
+	}
+	value, err = c.conn.Object(BusName, active).GetProperty(BusName + ".Connection.Active.Connection")
+	if err != nil {
+		return err
+	}
+	profile, _ := value.Value().(dbus.ObjectPath)
+	return c.activate(device, stateActivated, timeout, func() error {
+		return c.conn.Object(BusName, objectPath).Call(BusName+".ActivateConnection", 0, profile, device, dbus.ObjectPath("/")).Err
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package nm talks to NetworkManager over D-Bus.
+package nm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// BusName is the well-known D-Bus name of NetworkManager.
+const BusName = "org.freedesktop.NetworkManager"
+
+// Watcher follows the presence of NetworkManager on the system bus, so that
+// operations can be held while the daemon restarts instead of failing.
+type Watcher struct {
+	mu       sync.Mutex
+	ready    chan struct{}
+	onChange func(running bool)
+}
+
+// NewWatcher returns a watcher that is not connected to the bus, its state
+// is driven with Set.
+func NewWatcher(running bool) *Watcher {
+	w := &Watcher{ready: make(chan struct{})}
+	w.Set(running)
+	return w
+}
+
+// Watch starts watching NetworkManager on the system bus.
+func Watch() (*Watcher, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, BusName),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	var running bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, BusName).Store(&running); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	w := &Watcher{ready: make(chan struct{})}
+	w.Set(running)
+	go func() {
+		for signal := range signals {
+			if len(signal.Body) != 3 {
+				continue
+			}
+			owner, _ := signal.Body[2].(string)
+			w.Set(owner != "")
+		}
+	}()
+	return w, nil
+}
+
+// Set records whether NetworkManager owns its bus name.
+func (w *Watcher) Set(running bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.ready:
+		if running {
+			return
+		}
+		log.Warn().Msg("NetworkManager left the system bus, holding WiFi operations")
+		w.ready = make(chan struct{})
+	default:
+		if !running {
+			return
+		}
+		log.Info().Msg("NetworkManager is available on the system bus")
+		close(w.ready)
+	}
+	if w.onChange != nil {
+		w.onChange(running)
+	}
+}
+
+// OnChange registers fn to be called on each change of presence.
+func (w *Watcher) OnChange(fn func(running bool)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onChange = fn
+}
+
+// Available reports whether NetworkManager is currently running.
+func (w *Watcher) Available() bool {
+	w.mu.Lock()
+	ready := w.ready
+	w.mu.Unlock()
+	select {
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitAvailable blocks until NetworkManager is running or timeout elapses,
+// and reports whether it is running.
+func (w *Watcher) WaitAvailable(timeout time.Duration) bool {
+	w.mu.Lock()
+	ready := w.ready
+	w.mu.Unlock()
+	select {
+	case <-ready:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Running reports whether NetworkManager is running on the system bus.
+func Running() (bool, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	var running bool
+	err = conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, BusName).Store(&running)
+	return running, err
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package outage correlates everything that happens during one incident. An
+// outage ID is assigned at the first failure detection and kept until
+// connectivity is recovered, so logs, history rows and notifications about the
+// same incident can be grouped downstream.
+package outage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Tracker holds the ongoing outage, if any. The zero value is ready to use.
+type Tracker struct {
+	mu      sync.Mutex
+	id      string
+	started time.Time
+}
+
+// Open returns the ID of the ongoing outage, starting a new one if needed.
+func (t *Tracker) Open() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.id == "" {
+		t.id = NewID()
+		t.started = time.Now().UTC()
+	}
+	return t.id
+}
+
+// Close ends the ongoing outage and returns its ID and duration. It returns
+// an empty ID if there is no ongoing outage.
+func (t *Tracker) Close() (string, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, started := t.id, t.started
+	t.id = ""
+	if id == "" {
+		return "", 0
+	}
+	return id, time.Since(started)
+}
+
+// ID returns the ID of the ongoing outage, or an empty string.
+func (t *Tracker) ID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.id
+}
+
+// Started returns the ID of the ongoing outage and when it started, or an
+// empty ID.
+func (t *Tracker) Started() (string, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.id, t.started
+}
+
+// Run implements zerolog.Hook, adding the outage_id field to every log event
+// emitted during an outage.
+func (t *Tracker) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if id := t.ID(); id != "" {
+		e.Str("outage_id", id)
+	}
+}
+
+// NewID returns a new outage ID made of the UTC start time and a random
+// suffix, e.g. 20240601T140305Z-3fa2c1.
+func NewID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
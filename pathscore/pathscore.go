@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package pathscore rates the current quality of the path over each link from
+// its recent probes and its radio signal, so that the traffic can follow the
+// best link rather than a fixed priority. Unlike the score package, which
+// remembers days of outages, it tracks the last few dozen probes only.
+package pathscore
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gain is the weight of a new probe in the moving averages: the last 16
+// probes weigh about two thirds.
+const gain = 1.0 / 16
+
+// Points a path loses from a perfect 100.
+const (
+	// LossPoints is lost per percent of probes lost.
+	LossPoints = 2
+	// RTTPoints is lost per 10 ms of RTT.
+	RTTPoints = 1
+	// JitterPoints is lost per 5 ms of RTT variation.
+	JitterPoints = 1
+	// SignalPoints is lost per dB of signal below ExcellentSignal.
+	SignalPoints = 1
+)
+
+// ExcellentSignal is the signal strength in dBm at and above which a radio
+// link loses no point.
+const ExcellentSignal = -60
+
+// Quality is the recent quality of the path over a link.
+type Quality struct {
+	// Samples is the number of probes the averages are made of.
+	Samples int
+	RTT     time.Duration
+	Jitter  time.Duration
+	// Loss is the ratio of lost probes between 0 and 1.
+	Loss float64
+	// Signal is the signal strength of a radio link in dBm, 0 if unknown
+	// or wired.
+	Signal int
+}
+
+// Score rates q from 0 to 100, 100 being a path without loss, delay, jitter
+// or weak signal.
+func (q Quality) Score() float64 {
+	penalty := q.Loss*100*LossPoints +
+		float64(q.RTT)/float64(10*time.Millisecond)*RTTPoints +
+		float64(q.Jitter)/float64(5*time.Millisecond)*JitterPoints
+	if q.Signal != 0 && q.Signal < ExcellentSignal {
+		penalty += float64(ExcellentSignal-q.Signal) * SignalPoints
+	}
+	return math.Max(0, 100-penalty)
+}
+
+// String describes q, e.g. "score 87.5: rtt 22ms, jitter 3ms, loss 2.0%".
+func (q Quality) String() string {
+	parts := []string{
+		fmt.Sprintf("rtt %s", q.RTT.Round(time.Millisecond)),
+		fmt.Sprintf("jitter %s", q.Jitter.Round(time.Millisecond)),
+		fmt.Sprintf("loss %.1f%%", q.Loss*100),
+	}
+	if q.Signal != 0 {
+		parts = append(parts, fmt.Sprintf("signal %d dBm", q.Signal))
+	}
+	return fmt.Sprintf("score %.1f: %s", q.Score(), strings.Join(parts, ", "))
+}
+
+// average is the moving averages of the probes toward one endpoint.
+type average struct {
+	samples int
+	// rtt and jitter are in nanoseconds, over the answered probes.
+	rtt    float64
+	jitter float64
+	loss   float64
+	// last is the RTT of the last answered probe, 0 after a lost one.
+	last float64
+}
+
+// add records a probe.
+func (a *average) add(rtt time.Duration, ok bool) {
+	a.samples++
+	lost := 1.0
+	if ok {
+		lost = 0
+	}
+	if a.samples == 1 {
+		a.loss = lost
+	} else {
+		a.loss += (lost - a.loss) * gain
+	}
+	if !ok {
+		a.last = 0
+		return
+	}
+	value := float64(rtt)
+	if a.rtt == 0 {
+		a.rtt = value
+	} else {
+		a.rtt += (value - a.rtt) * gain
+	}
+	if a.last != 0 {
+		a.jitter += (math.Abs(value-a.last) - a.jitter) * gain
+	}
+	a.last = value
+}
+
+// Tracker keeps the moving averages of the probes of each link toward each
+// endpoint. It is safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	averages map[string]map[string]*average
+}
+
+// Add records a probe toward endpoint over link.
+func (t *Tracker) Add(link, endpoint string, rtt time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.averages == nil {
+		t.averages = map[string]map[string]*average{}
+	}
+	if t.averages[link] == nil {
+		t.averages[link] = map[string]*average{}
+	}
+	a, found := t.averages[link][endpoint]
+	if !found {
+		a = &average{}
+		t.averages[link][endpoint] = a
+	}
+	a.add(rtt, ok)
+}
+
+// Quality returns the quality of link, the mean of its averages toward each
+// endpoint, without signal. A link without probe has no sample.
+func (t *Tracker) Quality(link string) Quality {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var q Quality
+	var rtt, jitter float64
+	answered := 0
+	for _, a := range t.averages[link] {
+		q.Samples += a.samples
+		q.Loss += a.loss
+		if a.rtt != 0 {
+			rtt += a.rtt
+			jitter += a.jitter
+			answered++
+		}
+	}
+	if n := len(t.averages[link]); n > 0 {
+		q.Loss /= float64(n)
+	}
+	if answered > 0 {
+		q.RTT = time.Duration(rtt / float64(answered))
+		q.Jitter = time.Duration(jitter / float64(answered))
+	}
+	return q
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package pathwatch detects when the replies of a probe endpoint suddenly
+// come from a different distance, judged from their TTL. This happens when
+// an anycast endpoint switches node or a carrier starts answering from a
+// local cache, and explains an RTT step change that does not mean the link
+// degraded.
+package pathwatch
+
+import (
+	"fmt"
+	"sync"
+)
+
+// confirm is the number of consecutive replies at a new distance needed to
+// confirm a path change.
+const confirm = 3
+
+// Hops estimates the number of hops a reply travelled from its TTL, assuming
+// the sender used the smallest common initial TTL above it.
+func Hops(ttl int) int {
+	for _, initial := range []int{32, 64, 128, 255} {
+		if ttl <= initial {
+			return initial - ttl
+		}
+	}
+	return 0
+}
+
+// Change is a confirmed path change of an endpoint.
+type Change struct {
+	Endpoint string
+	From     int
+	To       int
+}
+
+// Suspicious reports whether the replies now come from so close that a
+// carrier cache or transparent proxy is likely answering.
+func (c Change) Suspicious() bool {
+	return c.To <= 2 && c.From > 2
+}
+
+// String describes the change.
+func (c Change) String() string {
+	s := fmt.Sprintf("replies from %s now travel %d hops instead of %d", c.Endpoint, c.To, c.From)
+	if c.Suspicious() {
+		return s + ", likely a carrier cache or transparent proxy"
+	}
+	return s + ", likely an anycast node switch"
+}
+
+type endpointState struct {
+	baseline  int
+	candidate int
+	seen      int
+}
+
+// Detector follows the reply distance of each endpoint. The zero value is
+// ready to use.
+type Detector struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointState
+}
+
+// Observe records the TTL of a reply from endpoint and returns the
+// estimated hop count, and the path change it confirms, if any.
+func (d *Detector) Observe(endpoint string, ttl int) (int, *Change) {
+	if ttl <= 0 {
+		return 0, nil
+	}
+	hops := Hops(ttl)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.endpoints == nil {
+		d.endpoints = map[string]*endpointState{}
+	}
+	s, ok := d.endpoints[endpoint]
+	if !ok {
+		d.endpoints[endpoint] = &endpointState{baseline: hops}
+		return hops, nil
+	}
+	if hops == s.baseline {
+		s.seen = 0
+		return hops, nil
+	}
+	if hops != s.candidate {
+		s.candidate, s.seen = hops, 0
+	}
+	s.seen++
+	if s.seen < confirm {
+		return hops, nil
+	}
+	change := &Change{Endpoint: endpoint, From: s.baseline, To: hops}
+	s.baseline, s.seen = hops, 0
+	return hops, change
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package peer elects which of two gateway hosts carries the traffic of a
+// site, in the manner of VRRP. Each instance sends a heartbeat advertising
+// its priority and the health of its uplinks to the other one every
+// interval; the healthier one, or the one of higher priority at equal
+// health, is the master and asserts the routes, the other one stands by. An
+// instance not heard from within the dead interval is taken for down.
+//
+// When a shared key is configured, every heartbeat carries an HMAC-SHA256
+// tag over its content, and heartbeats replayed from an earlier point of the
+// session of a peer are dropped.
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Health is how well an instance can carry the traffic of the site.
+type Health uint8
+
+// Health levels, in increasing order.
+const (
+	// Down is an instance without working uplink.
+	Down Health = iota
+	// Degraded is an instance carrying traffic over a backup link.
+	Degraded
+	// Healthy is an instance whose primary link works.
+	Healthy
+)
+
+// String returns the name of h.
+func (h Health) String() string {
+	switch h {
+	case Down:
+		return "down"
+	case Degraded:
+		return "degraded"
+	case Healthy:
+		return "healthy"
+	}
+	return "unknown"
+}
+
+// version is the protocol version.
+const version = 1
+
+// flagAuth marks an authenticated heartbeat, flagMaster one sent by the
+// master.
+const (
+	flagAuth   = 0x01
+	flagMaster = 0x02
+)
+
+// advertSize is the size of an encoded unauthenticated heartbeat, and
+// nameSize the most of the name of the instance it carries.
+const (
+	advertSize = 64
+	nameSize   = 32
+)
+
+// tagSize is the size of the authentication tag appended to authenticated
+// heartbeats (truncated HMAC-SHA256).
+const tagSize = 16
+
+var magic = [4]byte{'I', 'F', 'R', 'P'}
+
+var (
+	// ErrInvalidAdvert is returned when decoding a malformed heartbeat.
+	ErrInvalidAdvert = errors.New("invalid peer heartbeat")
+	// ErrAuthentication is returned when a heartbeat is not authenticated
+	// with the expected key.
+	ErrAuthentication = errors.New("peer heartbeat authentication failed")
+)
+
+// Advert is the heartbeat of an instance.
+type Advert struct {
+	// Name identifies the instance, usually its host name.
+	Name     string
+	Priority uint8
+	Health   Health
+	// Master reports whether the instance asserts the routes.
+	Master bool
+	// Session identifies a run of the instance, and Seq numbers its
+	// heartbeats within it.
+	Session uint32
+	Seq     uint32
+	Sent    time.Time
+}
+
+// Marshal encodes a, authenticating it if key is not empty.
+func (a *Advert) Marshal(key []byte) []byte {
+	b := make([]byte, advertSize, advertSize+tagSize)
+	copy(b[0:4], magic[:])
+	b[4] = version
+	if a.Master {
+		b[5] |= flagMaster
+	}
+	b[6] = a.Priority
+	b[7] = uint8(a.Health)
+	binary.BigEndian.PutUint32(b[8:12], a.Session)
+	binary.BigEndian.PutUint32(b[12:16], a.Seq)
+	binary.BigEndian.PutUint64(b[16:24], uint64(a.Sent.UnixNano()))
+	copy(b[24:24+nameSize], a.Name)
+	if len(key) == 0 {
+		return b
+	}
+	b[5] |= flagAuth
+	return append(b, tag(key, b)...)
+}
+
+// Unmarshal decodes a heartbeat. If key is not empty, it must be
+// authenticated with it.
+func (a *Advert) Unmarshal(b []byte, key []byte) error {
+	if len(b) < advertSize || [4]byte(b[0:4]) != magic || b[4] != version {
+		return ErrInvalidAdvert
+	}
+	if len(key) > 0 {
+		if b[5]&flagAuth == 0 || len(b) < advertSize+tagSize {
+			return ErrAuthentication
+		}
+		if !hmac.Equal(b[advertSize:advertSize+tagSize], tag(key, b[:advertSize])) {
+			return ErrAuthentication
+		}
+	}
+	a.Master = b[5]&flagMaster != 0
+	a.Priority = b[6]
+	a.Health = Health(b[7])
+	a.Session = binary.BigEndian.Uint32(b[8:12])
+	a.Seq = binary.BigEndian.Uint32(b[12:16])
+	a.Sent = time.Unix(0, int64(binary.BigEndian.Uint64(b[16:24]))).UTC()
+	name := b[24 : 24+nameSize]
+	for i, c := range name {
+		if c == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	a.Name = string(name)
+	return nil
+}
+
+// tag computes the authentication tag of an encoded heartbeat.
+func tag(key []byte, header []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header)
+	return mac.Sum(nil)[:tagSize]
+}
+
+// outranks reports whether a should be master rather than b: it is
+// healthier, or of higher priority at equal health, ties broken by name and
+// then session.
+func (a Advert) outranks(b Advert) bool {
+	switch {
+	case a.Health != b.Health:
+		return a.Health > b.Health
+	case a.Priority != b.Priority:
+		return a.Priority > b.Priority
+	case a.Name != b.Name:
+		return a.Name > b.Name
+	}
+	return a.Session > b.Session
+}
+
+// Elect reports whether self should be master, given the last heartbeat of
+// the other instance, nil if it is down, and whether self is master now.
+// Without preempt, a standby instance only takes over from a live master
+// that is less healthy, not from one of lower priority.
+func Elect(self Advert, other *Advert, master, preempt bool) bool {
+	switch {
+	case other == nil:
+		return true
+	case master && other.Master:
+		// Both asserting the routes: the lower ranked one steps down.
+		return self.outranks(*other)
+	case master:
+		return true
+	case other.Master:
+		return self.outranks(*other) && (preempt || self.Health > other.Health)
+	}
+	return self.outranks(*other)
+}
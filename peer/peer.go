@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package peer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Change is a change of role of the local instance.
+type Change struct {
+	// Master reports whether the local instance became master.
+	Master bool
+	// Reason tells why, e.g. "peer b2 down".
+	Reason string
+}
+
+// Node is the local instance of a pair.
+type Node struct {
+	// Name identifies the instance to its peer, up to 32 bytes.
+	Name string
+	// Priority ranks the instance at equal health, higher first.
+	Priority uint8
+	// Preempt lets the instance take over from a live master of lower
+	// priority.
+	Preempt bool
+	// Interval is the time between heartbeats, and DeadInterval the time
+	// after which a silent peer is taken for down.
+	Interval     time.Duration
+	DeadInterval time.Duration
+	// Key authenticates the heartbeats when not empty. It must match the
+	// key of the peer.
+	Key []byte
+	// Health returns the health of the local instance.
+	Health func() Health
+
+	conn    *net.UDPConn
+	address *net.UDPAddr
+
+	// elect serializes the elections, so that the changes are sent in
+	// order.
+	elect   sync.Mutex
+	mu      sync.Mutex
+	session uint32
+	seq     uint32
+	master  bool
+	started time.Time
+	// last is the last heartbeat of the peer, and heard when it came.
+	last  *Advert
+	heard time.Time
+}
+
+// Listen opens the socket of n on listen, a host:port, sending heartbeats
+// to address.
+func (n *Node) Listen(listen string, address string) error {
+	laddr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return err
+	}
+	if n.address, err = net.ResolveUDPAddr("udp", address); err != nil {
+		return err
+	}
+	if n.conn, err = net.ListenUDP("udp", laddr); err != nil {
+		return err
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	n.session = binary.BigEndian.Uint32(b) | 1
+	return nil
+}
+
+// Run sends heartbeats, receives those of the peer and sends the changes of
+// role of the local instance to changes, until the socket is closed. The
+// instance starts as standby, and waits for the peer for a dead interval
+// before becoming master alone.
+func (n *Node) Run(changes chan<- Change) {
+	n.mu.Lock()
+	n.started = time.Now()
+	n.mu.Unlock()
+	go n.receive(changes)
+	ticker := time.NewTicker(n.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n.evaluate(changes)
+		if err := n.send(); errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Close closes the socket.
+func (n *Node) Close() error {
+	return n.conn.Close()
+}
+
+// Master reports whether the local instance is master.
+func (n *Node) Master() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.master
+}
+
+// Peer returns the last heartbeat of the peer and whether it is alive.
+func (n *Node) Peer() (Advert, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.last == nil {
+		return Advert{}, false
+	}
+	return *n.last, time.Since(n.heard) < n.DeadInterval
+}
+
+// advert returns the heartbeat of the local instance, with n locked.
+func (n *Node) advert() Advert {
+	return Advert{Name: n.Name, Priority: n.Priority, Health: n.Health(), Master: n.master, Session: n.session, Seq: n.seq, Sent: time.Now()}
+}
+
+// send sends a heartbeat to the peer.
+func (n *Node) send() error {
+	n.mu.Lock()
+	n.seq++
+	a := n.advert()
+	n.mu.Unlock()
+	_, err := n.conn.WriteToUDP(a.Marshal(n.Key), n.address)
+	return err
+}
+
+// receive records the heartbeats of the peer, re-electing on each.
+func (n *Node) receive(changes chan<- Change) {
+	buf := make([]byte, 512)
+	for {
+		size, _, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var a Advert
+		if a.Unmarshal(buf[:size], n.Key) != nil {
+			continue
+		}
+		n.mu.Lock()
+		if a.Session == n.session {
+			// Our own heartbeat, e.g. both ends on one host.
+			n.mu.Unlock()
+			continue
+		}
+		if n.last != nil && a.Session == n.last.Session && a.Seq <= n.last.Seq {
+			n.mu.Unlock()
+			continue
+		}
+		n.last, n.heard = &a, time.Now()
+		n.mu.Unlock()
+		n.evaluate(changes)
+	}
+}
+
+// evaluate elects the role of the local instance, and reports a change.
+func (n *Node) evaluate(changes chan<- Change) {
+	n.elect.Lock()
+	defer n.elect.Unlock()
+	n.mu.Lock()
+	self := n.advert()
+	var other *Advert
+	if n.last != nil && time.Since(n.heard) < n.DeadInterval {
+		other = n.last
+	}
+	if other == nil && time.Since(n.started) < n.DeadInterval {
+		// Waiting for the peer to show up at startup.
+		n.mu.Unlock()
+		return
+	}
+	master := Elect(self, other, n.master, n.Preempt)
+	if master == n.master {
+		n.mu.Unlock()
+		return
+	}
+	n.master = master
+	c := Change{Master: master, Reason: reason(self, other, master)}
+	n.mu.Unlock()
+	changes <- c
+}
+
+// reason describes why self became master or standby against other, nil if
+// down.
+func reason(self Advert, other *Advert, master bool) string {
+	switch {
+	case other == nil:
+		return "peer down"
+	case self.Health != other.Health:
+		return "peer " + other.Name + " " + other.Health.String() + ", local " + self.Health.String()
+	case master:
+		return "priority above peer " + other.Name
+	}
+	return "peer " + other.Name + " ranks higher"
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package persist implements flash-friendly file persistence. Writes are
+// batched in memory and flushed at a configurable interval, so the amount of
+// data lost on power failure is bounded by the flush interval while the
+// number of erase cycles stays low.
+package persist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Fsync modes.
+const (
+	// FsyncAlways calls fsync after every flush.
+	FsyncAlways = "always"
+	// FsyncNever leaves write-back to the kernel.
+	FsyncNever = "never"
+)
+
+// Policy controls how often batched data reaches the disk.
+type Policy struct {
+	// FlushInterval is the maximum time data stays in memory.
+	FlushInterval time.Duration
+	// Fsync forces data to stable storage after each flush.
+	Fsync bool
+}
+
+// NewPolicy builds a policy from a flush interval and an fsync mode name.
+func NewPolicy(interval time.Duration, fsync string) (Policy, error) {
+	if interval <= 0 {
+		return Policy{}, fmt.Errorf("invalid flush interval: %s", interval)
+	}
+	switch fsync {
+	case FsyncAlways:
+		return Policy{FlushInterval: interval, Fsync: true}, nil
+	case FsyncNever:
+		return Policy{FlushInterval: interval}, nil
+	default:
+		return Policy{}, fmt.Errorf("invalid fsync mode: %s", fsync)
+	}
+}
+
+// WriteFile atomically replaces path with data, readable by its owner only,
+// syncing the file before the rename when sync is set.
+func WriteFile(path string, data []byte, sync bool) error {
+	return WriteFilePerm(path, data, 0o600, sync)
+}
+
+// WriteFilePerm is WriteFile with the permissions of the file.
+func WriteFilePerm(path string, data []byte, perm os.FileMode, sync bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// maxBuffered is the most data an Appender holds while the file cannot be
+// written, e.g. on a full disk. Writes beyond it are dropped.
+const maxBuffered = 4 << 20
+
+// Appender is an append-only file whose writes are buffered and flushed
+// according to a Policy. Each write is meant to be one record, kept or
+// dropped as a whole.
+type Appender struct {
+	mu     sync.Mutex
+	file   *os.File
+	buf    []byte
+	policy Policy
+	stop   chan struct{}
+	done   chan struct{}
+	// dropped counts the writes dropped since the buffer was full, and
+	// reported the ones already logged.
+	dropped  int
+	reported int
+}
+
+// OpenAppender opens path for appending, creating it if needed.
+func OpenAppender(path string, policy Policy) (*Appender, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	a := &Appender{
+		file:   file,
+		policy: policy,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.flushLoop()
+	return a, nil
+}
+
+// Write buffers p. It never touches the disk. p is dropped if the buffer
+// already holds maxBuffered bytes, which only happens while flushes fail.
+func (a *Appender) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.buf)+len(p) > maxBuffered {
+		a.dropped++
+		return len(p), nil
+	}
+	a.buf = append(a.buf, p...)
+	return len(p), nil
+}
+
+// Dropped returns the number of writes dropped so far because the buffer
+// was full.
+func (a *Appender) Dropped() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.dropped
+}
+
+// Flush writes the buffered data out. On a partial write, the bytes written
+// leave the buffer, so that the next flush does not write them again.
+func (a *Appender) Flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.buf) == 0 {
+		return nil
+	}
+	n, err := a.file.Write(a.buf)
+	a.buf = a.buf[:copy(a.buf, a.buf[n:])]
+	if err != nil {
+		return err
+	}
+	if a.policy.Fsync {
+		return a.file.Sync()
+	}
+	return nil
+}
+
+// Close flushes the remaining data and closes the file.
+func (a *Appender) Close() error {
+	close(a.stop)
+	<-a.done
+	err := a.Flush()
+	a.reportDropped()
+	if err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+func (a *Appender) flushLoop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.policy.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Flush(); err != nil {
+				log.Error().Msgf("Error flushing %s: %s", a.file.Name(), err)
+			}
+			a.reportDropped()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// reportDropped logs the writes dropped since the last report.
+func (a *Appender) reportDropped() {
+	a.mu.Lock()
+	dropped := a.dropped - a.reported
+	a.reported = a.dropped
+	a.mu.Unlock()
+	if dropped > 0 {
+		log.Error().Msgf("%d records dropped from %s, its buffer being full", dropped, a.file.Name())
+	}
+}
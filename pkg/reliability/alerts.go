@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/shynuu/if-reliability/alert"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/severity"
+)
+
+// alertFlushTimeout is the time the alerts under delivery are waited for on
+// exit.
+const alertFlushTimeout = 30 * time.Second
+
+// setupAlerts enables the alerts if --alert-email, --alert-slack-url or
+// --alert-teams-url is set.
+func (m *Manager) setupAlerts() error {
+	timeout := m.config.AlertTimeout
+	client := &http.Client{Timeout: timeout}
+	var sinks []alert.Sink
+	if to := m.config.AlertEmails; len(to) > 0 {
+		e := &alert.Email{To: to}
+		e.Server = m.config.AlertSMTPServer
+		e.From = m.config.AlertSMTPFrom
+		e.Username = m.config.AlertSMTPUsername
+		e.Password = m.config.AlertSMTPPassword
+		if _, _, err := net.SplitHostPort(e.Server); err != nil {
+			return fmt.Errorf("invalid --alert-smtp-server %q, expected host:port", e.Server)
+		}
+		if e.From == "" {
+			hostname, _ := os.Hostname()
+			e.From = "if-reliability@" + hostname
+		}
+		sinks = append(sinks, e)
+	}
+	if url := m.config.AlertSlackURL; url != "" {
+		sinks = append(sinks, &alert.Slack{URL: url, Client: client})
+	}
+	if url := m.config.AlertTeamsURL; url != "" {
+		sinks = append(sinks, &alert.Teams{URL: url, Client: client})
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	name := m.config.AlertSeverity
+	minSeverity, err := severity.Parse(name)
+	if err != nil {
+		return fmt.Errorf("invalid --alert-severity: %s", err)
+	}
+	subject := m.config.AlertSubject
+	body := m.config.AlertBody
+	templates, err := alert.ParseTemplates(subject, body)
+	if err != nil {
+		return err
+	}
+	// Rendering a failover catches the mistakes of the templates at start,
+	// not at the first outage.
+	if _, _, err := templates.Render(alert.Alert{Event: alert.Failover, Time: time.Now()}); err != nil {
+		return fmt.Errorf("invalid alert template: %s", err)
+	}
+	minDuration := m.config.AlertMinDuration
+	attempts := m.config.AlertAttempts
+	if m.alerts, err = alert.New(sinks, templates, minSeverity, minDuration, attempts, 5*time.Second); err != nil {
+		return err
+	}
+	if m.alertSite = m.config.SiteID; m.alertSite == "" {
+		m.alertSite, _ = os.Hostname()
+	}
+	for _, sink := range sinks {
+		m.log.Info().Msgf("Sending the %s alerts and above to %s", minSeverity, sink.Name())
+	}
+	return nil
+}
+
+// sendAlert sends an alert of event, if enabled.
+func (m *Manager) sendAlert(event string, level severity.Level, from string, to string, reason string) {
+	if m.alerts == nil {
+		return
+	}
+	m.alerts.Notify(alert.Alert{
+		Site:     m.alertSite,
+		Group:    m.config.Group,
+		Event:    event,
+		Severity: level,
+		Time:     time.Now(),
+		From:     LinkKey(from),
+		To:       LinkKey(to),
+		Reason:   reason,
+		Outage:   m.outages.ID(),
+	})
+}
+
+// alertPath alerts the path change c: a failover is critical, a failback
+// only informative.
+func (m *Manager) alertPath(c pathChange) {
+	if c.event == pathFailback {
+		m.sendAlert(alert.Failback, severity.Info, c.from, c.to, c.reason)
+		return
+	}
+	m.sendAlert(alert.Failover, severity.Critical, c.from, c.to, c.reason)
+}
+
+// flushAlerts waits for the alerts under delivery, if enabled, before the
+// monitor exits.
+func (m *Manager) flushAlerts() {
+	if m.alerts != nil && !m.alerts.Flush(alertFlushTimeout) {
+		m.log.Warn().Msgf("Alerts still undelivered after %s, exiting anyway", alertFlushTimeout)
+	}
+}
+
+// alertHook returns a hook alerting the failovers refused, a warning, and
+// the monitor stopping after the backup link failed, critical.
+func (f *failover) alertHook() fsm.Hook {
+	return func(t fsm.Transition) {
+		switch {
+		case t.From == fsm.FailingOver && t.To == fsm.MonitoringPrimary:
+			f.m.sendAlert(alert.Refused, severity.Warning, f.primaryIF, f.wifiIF, t.Reason)
+		case t.To == fsm.Stopped:
+			f.m.sendAlert(alert.Stopped, severity.Critical, f.wifiIF, f.wifiIF, t.Reason)
+		}
+	}
+}
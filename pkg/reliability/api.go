@@ -0,0 +1,126 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/history"
+)
+
+// DefaultControlSocket is where the running instance serves the control API.
+const DefaultControlSocket = "/run/if-reliability/control.sock"
+
+// DefaultTriggerSocket is where the running instance receives dispatcher events.
+const DefaultTriggerSocket = "/run/if-reliability/trigger.sock"
+
+// CommandTimeout is how long a failover or failback command waits for the
+// monitoring loop to pick it up.
+const CommandTimeout = 5 * time.Second
+
+// commandRequest is a failover or failback command sent to the monitoring
+// loop, which answers on reply.
+type commandRequest struct {
+	command string
+	reply   chan error
+}
+
+// controlBackend returns what the control API exposes.
+func (m *Manager) controlBackend() control.Backend {
+	return control.Backend{Status: m.controlStatus, Samples: m.lastSamples, Command: m.runCommand, Availability: m.availabilityReports, Inject: m.faults.inject}
+}
+
+// controlStatus returns the state of the instance.
+func (m *Manager) controlStatus() control.Status {
+	current, since := m.machine.State()
+	s := control.Status{State: string(current), Since: since, ActiveLink: m.activeLink, Paused: m.paused.Load()}
+	if m.activeCascade != nil {
+		s.State = "cascade"
+		if m.activeCascade.balance != nil {
+			s.State = "load-balance"
+		}
+	}
+	if w, ok := m.inMaintenance(time.Now()); ok {
+		s.Maintenance = w.String()
+	}
+	s.PreferredLink, _ = m.scheduledLink(time.Now())
+	s.Usage = m.usageStatus()
+	s.Pair = m.pair.status()
+	s.Faults = m.faults.active()
+	return s
+}
+
+// lastSamples returns the last n probe results.
+func (m *Manager) lastSamples(n int) ([]history.Sample, error) {
+	all, err := m.samples.Samples(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all, nil
+}
+
+// runCommand pauses or resumes the automatic decisions, or hands a failover
+// or failback command to the monitoring loop.
+func (m *Manager) runCommand(command string) error {
+	switch command {
+	case control.CommandPause:
+		if !m.paused.Swap(true) {
+			m.log.Warn().Msg("Automatic failover and failback paused")
+			m.decide(m.activeLink, "automatic failover and failback paused")
+		}
+		return nil
+	case control.CommandResume:
+		if m.paused.Swap(false) {
+			m.log.Info().Msg("Automatic failover and failback resumed")
+			m.decide(m.activeLink, "automatic failover and failback resumed")
+		}
+		return nil
+	}
+	req := commandRequest{command: command, reply: make(chan error, 1)}
+	select {
+	case m.commands <- req:
+		return <-req.reply
+	case <-time.After(CommandTimeout):
+		current, _ := m.machine.State()
+		return fmt.Errorf("busy in state %s, try again", current)
+	}
+}
+
+// acceptCommand answers req, accepting it if the machine is in one of the
+// states the command applies to. An accepted command is kept in
+// manualCommand.
+func (m *Manager) acceptCommand(req commandRequest) bool {
+	current, _ := m.machine.State()
+	var valid []fsm.State
+	switch req.command {
+	case control.CommandFailover:
+		valid = []fsm.State{fsm.MonitoringPrimary}
+	case control.CommandFailback:
+		valid = []fsm.State{fsm.OnBackup, fsm.Recovering}
+	}
+	if m.activeCascade != nil {
+		valid = nil
+	}
+	for _, s := range valid {
+		if s == current {
+			m.log.Warn().Msgf("Manual %s requested", req.command)
+			m.decide(m.activeLink, "manual %s requested", req.command)
+			m.manualCommand = req.command
+			req.reply <- nil
+			return true
+		}
+	}
+	if m.activeCascade != nil {
+		req.reply <- fmt.Errorf("%w: %s is not supported with --interfaces", control.ErrRejected, req.command)
+	} else {
+		req.reply <- fmt.Errorf("%w: %s does not apply in state %s", control.ErrRejected, req.command, current)
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"github.com/shynuu/if-reliability/history"
+)
+
+// DefaultHistoryDir is where the history archive is kept.
+const DefaultHistoryDir = "/var/lib/if-reliability/history"
+
+// archiveEvent appends e to the archive, if enabled.
+func (m *Manager) archiveEvent(e history.Event) {
+	if m.archive == nil {
+		return
+	}
+	if err := m.archive.AddEvent(e); err != nil {
+		m.log.Error().Msgf("Error archiving %s: %s", e.Kind, err)
+	}
+}
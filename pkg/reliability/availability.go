@@ -0,0 +1,111 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/availability"
+	"github.com/shynuu/if-reliability/persist"
+	"github.com/shynuu/if-reliability/timefmt"
+)
+
+// startAvailability sets up a tracker per period and reports the periods as
+// they end, appending them to report if not empty, written out according to
+// policy.
+func (m *Manager) startAvailability(periods []time.Duration, report string, policy persist.Policy) error {
+	if report != "" && len(periods) > 0 {
+		out, err := persist.OpenAppender(report, policy)
+		if err != nil {
+			return err
+		}
+		m.availabilityReport = out
+	}
+	now := time.Now()
+	for _, period := range periods {
+		m.availabilityTrackers = append(m.availabilityTrackers, availability.NewTracker(period, now))
+	}
+	for _, t := range m.availabilityTrackers {
+		go m.rotateAvailability(t)
+	}
+	return nil
+}
+
+// closeAvailability writes out the reports not yet on disk.
+func (m *Manager) closeAvailability() {
+	if m.availabilityReport == nil {
+		return
+	}
+	if err := m.availabilityReport.Close(); err != nil {
+		m.log.Error().Msgf("Error writing the availability report: %s", err)
+	}
+}
+
+// rotateAvailability reports the availability over each period of t when it
+// ends.
+func (m *Manager) rotateAvailability(t *availability.Tracker) {
+	for {
+		time.Sleep(time.Until(t.End()))
+		r, ended := t.Rotate(time.Now())
+		if !ended {
+			continue
+		}
+		m.log.Info().
+			Interface("availability", r).
+			Msgf("Availability over the %s from %s: %s", r.Period, timefmt.FormatIn(r.Start, m.location), r)
+		if m.availabilityReport != nil {
+			if err := appendReport(m.availabilityReport, r); err != nil {
+				m.log.Error().Msgf("Error writing the availability report: %s", err)
+			}
+		}
+	}
+}
+
+// appendReport appends r to out as a JSON line.
+func appendReport(out *persist.Appender, r availability.Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// observeAvailability adds a probe result over ifname to the trackers.
+func (m *Manager) observeAvailability(ifname, endpoint string, success bool, rtt time.Duration, at time.Time) {
+	for _, t := range m.availabilityTrackers {
+		t.Probe(LinkKey(ifname), endpoint, success, rtt, at)
+	}
+}
+
+// switchAvailability records a switch from one link to another in the
+// trackers.
+func (m *Manager) switchAvailability(from, to string) {
+	now := time.Now()
+	for _, t := range m.availabilityTrackers {
+		t.Switch(from, to, m.onBackup(to), now)
+	}
+}
+
+// onBackup reports whether the traffic over link left the primary link: the
+// first interface of the cascade, or the default route without --interfaces.
+func (m *Manager) onBackup(link string) bool {
+	if m.activeCascade == nil {
+		return link != PrimaryLink
+	}
+	return !slices.Contains(strings.Split(link, "+"), m.activeCascade.ifaces[0])
+}
+
+// availabilityReports returns the reports of the periods under way.
+func (m *Manager) availabilityReports() []availability.Report {
+	now := time.Now()
+	reports := []availability.Report{}
+	for _, t := range m.availabilityTrackers {
+		reports = append(reports, t.Report(now))
+	}
+	return reports
+}
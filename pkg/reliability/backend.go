@@ -0,0 +1,270 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/nm"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// The manager acts on the system through a Pinger, a WiFiManager, a
+// RouteManager and a CommandRunner, so that other backends, such as the
+// fakes of the fake package, can take the place of the real ones.
+
+// Pinger sends one probe toward address, bound to ifname if not empty,
+// abandoned once ctx is done. Every probe.Prober is a Pinger.
+type Pinger interface {
+	Probe(ctx context.Context, address string, ifname string) probe.Result
+}
+
+// WiFiManager activates and deactivates the connections of the interfaces,
+// WiFi or wired, like NetworkManager.
+type WiFiManager interface {
+	// ConnectWiFi connects ifname to the network ssid, on the access point
+	// bssid if not empty, authenticated with password, or with eap if not
+	// nil, and returns once activated or after timeout.
+	ConnectWiFi(ifname, ssid, bssid, password string, eap *wifi.Enterprise, timeout time.Duration) error
+	// ConnectDevice activates the best connection profile of ifname.
+	ConnectDevice(ifname string, timeout time.Duration) error
+	// Disconnect deactivates the connection of ifname.
+	Disconnect(ifname string) error
+	// Scan scans for access points on ifname, waiting up to wait for the
+	// results.
+	Scan(ifname string, wait time.Duration) ([]nm.AccessPoint, error)
+	// Lease returns the DHCPv4 lease of ifname, nil if it has none, and
+	// Renew obtains a new one, waiting up to timeout.
+	Lease(ifname string) (*nm.Lease, error)
+	Renew(ifname string, timeout time.Duration) error
+	// Nameservers returns the DNS servers of the connection of ifname.
+	Nameservers(ifname string) ([]string, error)
+	// Profile returns the name of the connection profile of ssid, empty if
+	// there is none.
+	Profile(ssid string) (string, error)
+}
+
+// RouteManager reads and changes the routing tables, the main one or that
+// of vrf if not empty, see the route package.
+type RouteManager interface {
+	// Device returns the interface the route toward ip goes through.
+	Device(ip, vrf string) (string, error)
+	// DefaultGateway returns the gateway of the default route of family
+	// through ifname, or an empty string if there is none yet, and
+	// Defaults the default routes of family.
+	DefaultGateway(ifname, vrf string, family int) (string, error)
+	Defaults(vrf string, family int) ([]route.Route, error)
+	// Replace installs r, replacing the route toward the same network.
+	Replace(r route.Route) error
+	// Delete removes the routes toward dst through ifname in table, the
+	// table of vrf if 0, and DeleteMetric only the one with metric,
+	// reporting whether there was one.
+	Delete(dst, ifname, vrf string, table int) error
+	DeleteMetric(dst, ifname, vrf string, table, metric int) (bool, error)
+	// ReplaceMultipath installs r spreading the flows over hops, and
+	// DeleteMultipath removes the multipath route toward dst with metric
+	// and protocol, reporting whether there was one.
+	ReplaceMultipath(r route.Route, hops []route.Nexthop) error
+	DeleteMultipath(dst, vrf string, table, metric, protocol int) (bool, error)
+	// AddRule adds a policy routing rule, and DeleteRules removes the rules
+	// of family with priority, returning how many there were.
+	AddRule(r route.Rule) error
+	DeleteRules(family, priority int) (int, error)
+	// AddAddress adds addr, in CIDR notation, to ifname, and DeleteAddress
+	// removes it, reporting whether it was there.
+	AddAddress(ifname, addr string) error
+	DeleteAddress(ifname, addr string) (bool, error)
+	// Watch reports the changes of table, the table of vrf if 0, until
+	// done is closed.
+	Watch(vrf string, table int, done <-chan struct{}) (<-chan route.Change, error)
+}
+
+// CommandRunner runs the external programs, such as iw, sysctl and the
+// hooks, and returns their combined output.
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+// NetworkManager is the WiFiManager talking to NetworkManager over D-Bus,
+// connected on first use.
+type NetworkManager struct {
+	mu     sync.Mutex
+	client *nm.Client
+}
+
+// dial returns the client, connecting it on first use.
+func (n *NetworkManager) dial() (*nm.Client, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.client == nil {
+		client, err := nm.Dial()
+		if err != nil {
+			return nil, fmt.Errorf("cannot reach NetworkManager on the system bus: %w", err)
+		}
+		n.client = client
+	}
+	return n.client, nil
+}
+
+// ConnectWiFi implements WiFiManager.
+func (n *NetworkManager) ConnectWiFi(ifname, ssid, bssid, password string, eap *wifi.Enterprise, timeout time.Duration) error {
+	client, err := n.dial()
+	if err != nil {
+		return err
+	}
+	return client.ConnectWiFi(ifname, ssid, bssid, password, eap, timeout)
+}
+
+// ConnectDevice implements WiFiManager.
+func (n *NetworkManager) ConnectDevice(ifname string, timeout time.Duration) error {
+	client, err := n.dial()
+	if err != nil {
+		return err
+	}
+	return client.ConnectDevice(ifname, timeout)
+}
+
+// Disconnect implements WiFiManager.
+func (n *NetworkManager) Disconnect(ifname string) error {
+	client, err := n.dial()
+	if err != nil {
+		return err
+	}
+	return client.Disconnect(ifname)
+}
+
+// Scan implements WiFiManager.
+func (n *NetworkManager) Scan(ifname string, wait time.Duration) ([]nm.AccessPoint, error) {
+	client, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+	return client.Scan(ifname, wait)
+}
+
+// Lease implements WiFiManager.
+func (n *NetworkManager) Lease(ifname string) (*nm.Lease, error) {
+	client, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+	return client.Lease(ifname)
+}
+
+// Renew implements WiFiManager.
+func (n *NetworkManager) Renew(ifname string, timeout time.Duration) error {
+	client, err := n.dial()
+	if err != nil {
+		return err
+	}
+	return client.Renew(ifname, timeout)
+}
+
+// Nameservers implements WiFiManager.
+func (n *NetworkManager) Nameservers(ifname string) ([]string, error) {
+	client, err := n.dial()
+	if err != nil {
+		return nil, err
+	}
+	return client.Nameservers(ifname)
+}
+
+// Profile implements WiFiManager.
+func (n *NetworkManager) Profile(ssid string) (string, error) {
+	client, err := n.dial()
+	if err != nil {
+		return "", err
+	}
+	_, name, err := client.Profile(ssid)
+	return name, err
+}
+
+// Netlink is the RouteManager changing the routing tables over rtnetlink.
+type Netlink struct{}
+
+// Device implements RouteManager.
+func (Netlink) Device(ip, vrf string) (string, error) {
+	return route.Device(ip, vrf)
+}
+
+// DefaultGateway implements RouteManager.
+func (Netlink) DefaultGateway(ifname, vrf string, family int) (string, error) {
+	return route.DefaultGateway(ifname, vrf, family)
+}
+
+// Defaults implements RouteManager.
+func (Netlink) Defaults(vrf string, family int) ([]route.Route, error) {
+	return route.Defaults(vrf, family)
+}
+
+// Replace implements RouteManager.
+func (Netlink) Replace(r route.Route) error {
+	return route.Replace(r)
+}
+
+// Delete implements RouteManager.
+func (Netlink) Delete(dst, ifname, vrf string, table int) error {
+	return route.Delete(dst, ifname, vrf, table)
+}
+
+// DeleteMetric implements RouteManager.
+func (Netlink) DeleteMetric(dst, ifname, vrf string, table, metric int) (bool, error) {
+	return route.DeleteMetric(dst, ifname, vrf, table, metric)
+}
+
+// ReplaceMultipath implements RouteManager.
+func (Netlink) ReplaceMultipath(r route.Route, hops []route.Nexthop) error {
+	return route.ReplaceMultipath(r, hops)
+}
+
+// DeleteMultipath implements RouteManager.
+func (Netlink) DeleteMultipath(dst, vrf string, table, metric, protocol int) (bool, error) {
+	return route.DeleteMultipath(dst, vrf, table, metric, protocol)
+}
+
+// AddRule implements RouteManager.
+func (Netlink) AddRule(r route.Rule) error {
+	return route.AddRule(r)
+}
+
+// DeleteRules implements RouteManager.
+func (Netlink) DeleteRules(family, priority int) (int, error) {
+	return route.DeleteRules(family, priority)
+}
+
+// AddAddress implements RouteManager.
+func (Netlink) AddAddress(ifname, addr string) error {
+	return route.AddAddress(ifname, addr)
+}
+
+// DeleteAddress implements RouteManager.
+func (Netlink) DeleteAddress(ifname, addr string) (bool, error) {
+	return route.DeleteAddress(ifname, addr)
+}
+
+// Watch implements RouteManager.
+func (Netlink) Watch(vrf string, table int, done <-chan struct{}) (<-chan route.Change, error) {
+	return route.Watch(vrf, table, done)
+}
+
+// Exec is the CommandRunner executing the programs, inside the named
+// network namespace Namespace if set.
+type Exec struct {
+	Namespace string
+}
+
+// Run implements CommandRunner.
+func (e Exec) Run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if e.Namespace != "" {
+		cmd = exec.Command("ip", append([]string{"netns", "exec", e.Namespace, name}, args...)...)
+	}
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/state"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// recordBackupVerified records that the backup path over ifname was proven
+// to work by source.
+func (m *Manager) recordBackupVerified(ifname, source string) {
+	now := time.Now()
+	m.metrics.SetBackupVerified(ifname, now)
+	if m.store == nil {
+		return
+	}
+	err := m.store.Update(func(s *state.State) {
+		if s.BackupVerified == nil {
+			s.BackupVerified = map[string]state.Verification{}
+		}
+		s.BackupVerified[ifname] = state.Verification{Time: now, Source: source}
+	})
+	if err != nil {
+		m.log.Error().Msgf("Error saving state: %s", err)
+	}
+}
+
+// checkBackupAge logs when the backup path over ifname was last proven to
+// work, and warns if that is longer ago than maxAge.
+func (m *Manager) checkBackupAge(ifname string, maxAge time.Duration) {
+	if m.store == nil {
+		return
+	}
+	v, ok := m.store.Get().BackupVerified[ifname]
+	if !ok {
+		m.log.Warn().Msgf("Backup path over %s was never verified, run a drill with --drill", ifname)
+		return
+	}
+	age := time.Since(v.Time)
+	if maxAge > 0 && age > maxAge {
+		m.log.Warn().Msgf("Backup path over %s last verified %s ago by a %s, longer than %s", ifname, age.Round(time.Minute), v.Source, maxAge)
+		return
+	}
+	m.log.Info().Msgf("Backup path over %s last verified %s ago by a %s", ifname, age.Round(time.Minute), v.Source)
+}
+
+// watchBackupAge checks the backup verification age every hour.
+func (m *Manager) watchBackupAge(ifname string, maxAge time.Duration) {
+	for range m.tick(time.Hour) {
+		m.checkBackupAge(ifname, maxAge)
+	}
+}
+
+// runDrill proves the backup path works without failing over: it brings the
+// WiFi interface up, connects, verifies connectivity over it, then
+// disconnects. It reports whether the backup path was verified.
+func (m *Manager) runDrill(spare coldSpare, ifwifi, bssid, password string, opts wifi.ConnectOptions, endpoints []endpoint.Endpoint, attempts, minHealth int) bool {
+	m.log.Info().Msgf("Starting failover drill over %s", ifwifi)
+	if spare.enabled() {
+		if err := spare.activate(ifwifi); err != nil {
+			m.log.Error().Msgf("Error activating backup interface: %s", err)
+			return false
+		}
+		defer spare.park()
+	}
+	if _, err := m.connectToWiFi(ifwifi, bssid, password, opts); err != nil {
+		m.log.Error().Msgf("Drill failed, error connecting to WiFi: %s", err)
+		return false
+	}
+	defer func() {
+		if err := m.runNM(func(c WiFiManager) error { return c.Disconnect(ifwifi) }, "device", "disconnect", ifwifi); err != nil {
+			m.log.Error().Msgf("Error disconnecting %s after the drill: %s", ifwifi, err)
+		}
+	}()
+	verified := m.verifyConnectivity(endpoints, ifwifi, attempts)
+	if !m.checkWiFiHealth(ifwifi, minHealth) {
+		verified = false
+	}
+	if !verified {
+		m.log.Error().Msgf("Drill failed, connectivity over %s could not be verified", ifwifi)
+		return false
+	}
+	m.log.Info().Msgf("Drill succeeded, backup path over %s verified", ifwifi)
+	m.recordBackupVerified(ifwifi, state.SourceDrill)
+	return true
+}
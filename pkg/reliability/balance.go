@@ -0,0 +1,194 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// balancer spreads the moved networks over the healthy interfaces of the
+// cascade with multipath routes, each interface taking a share of the flows
+// in proportion to its weight, instead of switching between them.
+type balancer struct {
+	m *Manager
+
+	weights map[string]int
+	// nexthops are the interfaces the installed routes go through, in
+	// priority order, and networks the networks they route.
+	nexthops []string
+	networks []string
+}
+
+// parseWeights parses the weights of the interfaces, given as ifname=weight,
+// of ifaces; the unlisted ones weigh 1.
+func parseWeights(texts, ifaces []string) (map[string]int, error) {
+	weights := map[string]int{}
+	for _, ifname := range ifaces {
+		weights[ifname] = 1
+	}
+	for _, text := range texts {
+		ifname, value, _ := strings.Cut(text, "=")
+		if _, listed := weights[ifname]; !listed {
+			return nil, fmt.Errorf("%s is not in --interfaces", ifname)
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 || weight > 256 {
+			return nil, fmt.Errorf("invalid weight %q of %s, expected 1 to 256", value, ifname)
+		}
+		weights[ifname] = weight
+	}
+	return weights, nil
+}
+
+// label names a set of nexthops, e.g. "wwan0+wlan0".
+func label(nexthops []string) string {
+	if len(nexthops) == 0 {
+		return "none"
+	}
+	return strings.Join(nexthops, "+")
+}
+
+// healthy returns the healthy interfaces of c that are neither held down nor
+// avoided, or all the healthy ones if they all are.
+func (b *balancer) healthy(c *cascade) []string {
+	now := time.Now()
+	var healthy, stable []string
+	for _, ifname := range c.ifaces {
+		if !c.health[ifname].healthy {
+			continue
+		}
+		healthy = append(healthy, ifname)
+		if b.m.eligible(ifname, now) {
+			stable = append(stable, ifname)
+		}
+	}
+	if len(stable) > 0 {
+		return stable
+	}
+	return healthy
+}
+
+// update routes the networks of c over its healthy interfaces when they
+// changed, and keeps the installed routes if none is healthy.
+func (b *balancer) update(c *cascade) {
+	nexthops := b.healthy(c)
+	if len(nexthops) == 0 {
+		if len(b.nexthops) > 0 {
+			b.m.log.Error().Msgf("No healthy interface left, keeping the traffic over %s", label(b.nexthops))
+			b.m.decide(label(b.nexthops), "no healthy interface left")
+			b.nexthops = []string{}
+		}
+		return
+	}
+	if networks := b.m.movedNetworks(b.m.targets); len(networks) > 0 {
+		c.networks = networks
+	}
+	if slices.Equal(nexthops, b.nexthops) && slices.Equal(c.networks, b.networks) {
+		return
+	}
+	if b.m.suspended() && b.nexthops != nil {
+		b.m.log.Debug().Msgf("Automatic switching paused, staying on %s instead of %s", b.m.activeLink, label(nexthops))
+		return
+	}
+	routers := map[string]map[int]string{}
+	for _, ifname := range nexthops {
+		r, err := b.m.defaultRouters(ifname)
+		if err != nil {
+			b.m.log.Error().Msgf("Error reading the default routers of %s: %s", ifname, err)
+		}
+		routers[ifname] = r
+	}
+	for _, cidr := range c.networks {
+		b.route(cidr, nexthops, routers)
+	}
+	var stale []string
+	for _, cidr := range b.networks {
+		if !slices.Contains(c.networks, cidr) {
+			stale = append(stale, cidr)
+		}
+	}
+	b.remove(stale)
+	for _, ifname := range nexthops {
+		b.m.conntrackFlush.remember(ifname)
+	}
+	for _, ifname := range b.nexthops {
+		if !slices.Contains(nexthops, ifname) {
+			b.m.conntrackFlush.left(ifname)
+		}
+	}
+	from := b.m.activeLink
+	b.nexthops, b.networks = nexthops, c.networks
+	c.active, c.since = nexthops[0], time.Now()
+	b.m.activeLink = label(nexthops)
+	b.m.logTransition(from, b.m.activeLink)
+	networks := b.networks
+	b.m.restoreOnStop = func() { b.remove(networks) }
+	b.m.tunnels.rehome(b.m.activeLink)
+}
+
+// route routes cidr over the nexthops having a default router of its family.
+func (b *balancer) route(cidr string, nexthops []string, routers map[string]map[int]string) {
+	family := cidrFamily(cidr)
+	var hops []route.Nexthop
+	args := []string{"route", "replace", cidr}
+	for _, ifname := range nexthops {
+		router, ok := routers[ifname][family]
+		if !ok {
+			b.m.log.Warn().Msgf("No %s default router on %s, the route toward %s does not go through it", familyName(family), ifname, cidr)
+			continue
+		}
+		hops = append(hops, route.Nexthop{Gateway: router, Device: ifname, Weight: b.weights[ifname]})
+		args = append(args, "nexthop", router, ifname, strconv.Itoa(b.weights[ifname]))
+	}
+	if len(hops) == 0 {
+		b.m.log.Error().Msgf("No %s default router on %s, the route toward %s is left unchanged", familyName(family), label(nexthops), cidr)
+		return
+	}
+	r := route.Route{
+		Dst:      cidr,
+		VRF:      b.m.vrf,
+		Table:    b.m.routingPolicy.table,
+		Metric:   b.m.prefixMetrics[cidr],
+		Protocol: b.m.routeProto,
+		Realm:    b.m.routeRealm,
+		RTOMin:   b.m.hints.RTOMin,
+		QuickAck: b.m.hints.QuickAck,
+		InitCwnd: b.m.hints.InitCwnd,
+	}
+	b.m.routeOwner.own(r, hops)
+	if _, err := b.m.routing(func() (string, error) { return "", b.m.routeManager.ReplaceMultipath(r, hops) }, b.m.routingPolicy.routeArgs(args...)...); err != nil {
+		b.m.routeOwner.disown(cidr, "", r.Metric)
+		b.m.log.Error().Msgf("Failed to route %s over %s: %s", cidr, label(nexthops), err)
+		return
+	}
+	shares := make([]string, len(hops))
+	for i, hop := range hops {
+		shares[i] = fmt.Sprintf("%s (%d)", hop.Device, hop.Weight)
+	}
+	b.m.changeLog.Record(changes.Route, "replaced", "%s over %s", cidr, strings.Join(shares, ", "))
+}
+
+// remove removes the multipath routes toward networks.
+func (b *balancer) remove(networks []string) {
+	for _, cidr := range networks {
+		metric := b.m.prefixMetrics[cidr]
+		b.m.routeOwner.disown(cidr, "", metric)
+		output, err := b.m.routing(func() (string, error) {
+			removed, err := b.m.routeManager.DeleteMultipath(cidr, b.m.vrf, b.m.routingPolicy.table, metric, b.m.routeProto)
+			return strconv.FormatBool(removed), err
+		}, b.m.routingPolicy.routeArgs("route", "del", cidr, b.m.vrf, "metric", strconv.Itoa(metric))...)
+		if err != nil {
+			b.m.log.Error().Msgf("Failed to remove the route toward %s: %s", cidr, err)
+		} else if output == "true" {
+			b.m.changeLog.Record(changes.Route, "removed", "%s", cidr)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/persist"
+	"github.com/shynuu/if-reliability/wifi"
+	"github.com/spf13/pflag"
+)
+
+// BootstrapOptions configures Bootstrap.
+type BootstrapOptions struct {
+	// Links are the wired or cellular interfaces to try, and WiFiInterface
+	// the one trying the candidate WiFiNetworks, as ssid:password or ssid
+	// for an open network.
+	Links         []string
+	WiFiInterface string
+	WiFiNetworks  []string
+	// Check is the endpoint proving connectivity over a link.
+	Check string
+	// ConfigURL is where the device configuration, an environment file of
+	// IF_RELIABILITY_ settings, is fetched from, and ConfigPath the file it
+	// is saved to.
+	ConfigURL  string
+	ConfigPath string
+	// RoundDelay separates two rounds over all the links and networks.
+	RoundDelay time.Duration
+	// Exec starts monitoring with the fetched configuration once
+	// bootstrapped.
+	Exec bool
+	// Namespace is the named network namespace to operate in.
+	Namespace string
+}
+
+// AddBootstrapFlags defines the flags of Bootstrap on fs, bound to o.
+func AddBootstrapFlags(fs *pflag.FlagSet, o *BootstrapOptions) {
+	fs.StringSliceVar(&o.Links, "link", nil, "Wired or cellular interface to try, may be repeated")
+	fs.StringVar(&o.WiFiInterface, "wifi-if", "", "WiFi interface used to try the candidate networks")
+	fs.StringSliceVar(&o.WiFiNetworks, "wifi-network", nil, "Candidate WiFi network as ssid:password, or ssid for an open network, may be repeated")
+	fs.StringVar(&o.Check, "check", "8.8.8.8", "Endpoint proving connectivity over a link")
+	fs.StringVar(&o.ConfigURL, "config-url", "", "URL of the device configuration, an environment file of IF_RELIABILITY_ settings (required)")
+	fs.StringVar(&o.ConfigPath, "config-path", "/etc/if-reliability/env", "File the fetched configuration is saved to")
+	fs.DurationVar(&o.RoundDelay, "round-delay", 30*time.Second, "Pause between two rounds over all the links and networks")
+	fs.BoolVar(&o.Exec, "exec", true, "Start monitoring with the fetched configuration once bootstrapped")
+	fs.StringVar(&o.Namespace, "netns", "", "Named network namespace to operate in")
+}
+
+// Bootstrap cycles through the links and candidate WiFi networks of o until
+// one of them reaches the check endpoint, fetches the device configuration
+// through it and saves it. With Exec, it then replaces the process with the
+// monitor configured by it.
+func Bootstrap(o BootstrapOptions) error {
+	c := DefaultConfig()
+	c.Netns = o.Namespace
+	return newManager(c).bootstrap(o)
+}
+
+// bootstrap runs Bootstrap.
+func (m *Manager) bootstrap(o BootstrapOptions) error {
+	links, wifiIF, networks := o.Links, o.WiFiInterface, o.WiFiNetworks
+	check, configURL, configPath := o.Check, o.ConfigURL, o.ConfigPath
+	roundDelay, execMonitor := o.RoundDelay, o.Exec
+	if len(links) == 0 && (wifiIF == "" || len(networks) == 0) {
+		return errors.New("nothing to try, give --link or --wifi-if with --wifi-network")
+	}
+	target, err := endpoint.Parse(check)
+	if err != nil {
+		return fmt.Errorf("parsing check endpoint: %w", err)
+	}
+	ring, err := history.NewRing(64, "", persist.Policy{FlushInterval: time.Minute})
+	if err != nil {
+		return fmt.Errorf("creating history: %w", err)
+	}
+	m.samples = ring
+	opts := wifi.ConnectOptions{AssociationTimeout: 30 * time.Second, DHCPTimeout: 30 * time.Second, MaxAttempts: 1}
+
+	for round := 1; ; round++ {
+		m.log.Info().Msgf("Bootstrap round %d", round)
+		for _, link := range links {
+			if m.tryLink(link, target) && m.fetchConfig(configURL, link, configPath) {
+				return m.startMonitor(execMonitor, configPath)
+			}
+		}
+		for _, network := range networks {
+			ssid, password, _ := strings.Cut(network, ":")
+			if m.tryWiFi(wifiIF, ssid, password, opts, target) && m.fetchConfig(configURL, wifiIF, configPath) {
+				return m.startMonitor(execMonitor, configPath)
+			}
+		}
+		m.log.Warn().Msgf("No connectivity over any link, next round in %s", roundDelay)
+		time.Sleep(roundDelay)
+	}
+}
+
+// tryLink activates a wired or cellular link and reports whether target is
+// reachable over it.
+func (m *Manager) tryLink(ifname string, target endpoint.Endpoint) bool {
+	m.log.Info().Msgf("Trying %s", ifname)
+	if err := m.runNM(func(c WiFiManager) error { return c.ConnectDevice(ifname, time.Minute) }, "device", "connect", ifname); err != nil {
+		m.log.Warn().Msgf("Cannot activate %s: %s", ifname, err)
+		return false
+	}
+	return m.verifyConnectivity([]endpoint.Endpoint{target}, ifname, 3)
+}
+
+// tryWiFi connects to a candidate WiFi network and reports whether target is
+// reachable over it.
+func (m *Manager) tryWiFi(ifwifi, ssid, password string, opts wifi.ConnectOptions, target endpoint.Endpoint) bool {
+	m.log.Info().Msgf("Trying WiFi network %s on %s", ssid, ifwifi)
+	if _, err := m.connectToWiFi(ifwifi, ssid, password, opts); err != nil {
+		m.log.Warn().Msgf("Cannot connect to %s: %s", ssid, err)
+		return false
+	}
+	return m.verifyConnectivity([]endpoint.Endpoint{target}, ifwifi, 3)
+}
+
+// fetchConfig downloads the device configuration over ifname and saves it to
+// path. It reports whether a valid configuration was saved.
+func (m *Manager) fetchConfig(url, ifname, path string) bool {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: bind.Control(ifname)}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		var conn net.Conn
+		err := netns.Do(m.namespace, func() error {
+			var err error
+			conn, err = dialer.DialContext(ctx, network, address)
+			return err
+		})
+		return conn, err
+	}
+	client := &http.Client{Timeout: time.Minute, Transport: &http.Transport{DialContext: dial}}
+	resp, err := client.Get(url)
+	if err != nil {
+		m.log.Error().Msgf("Error fetching configuration over %s: %s", ifname, err)
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		m.log.Error().Msgf("Error fetching configuration over %s: %s", ifname, resp.Status)
+		return false
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		m.log.Error().Msgf("Error fetching configuration over %s: %s", ifname, err)
+		return false
+	}
+	if _, err := parseEnvFile(data); err != nil {
+		m.log.Error().Msgf("Invalid configuration fetched from %s: %s", url, err)
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		m.log.Error().Msgf("Error saving configuration: %s", err)
+		return false
+	}
+	if err := persist.WriteFile(path, data, true); err != nil {
+		m.log.Error().Msgf("Error saving configuration: %s", err)
+		return false
+	}
+	m.log.Info().Msgf("Fetched configuration over %s, saved to %s", ifname, path)
+	return true
+}
+
+// parseEnvFile parses an environment file of IF_RELIABILITY_ settings, one
+// KEY=value per line, ignoring blank lines and # comments.
+func parseEnvFile(data []byte) (map[string]string, error) {
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			return nil, fmt.Errorf("line %d: expected %sKEY=value", line, EnvPrefix)
+		}
+		settings[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("no settings")
+	}
+	return settings, scanner.Err()
+}
+
+// startMonitor replaces the process with the monitor configured by the
+// environment file at path, unless execMonitor is false.
+func (m *Manager) startMonitor(execMonitor bool, path string) error {
+	if !execMonitor {
+		m.log.Info().Msgf("Bootstrapped, start the monitor with the settings of %s", path)
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading configuration: %w", err)
+	}
+	settings, err := parseEnvFile(data)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	env := os.Environ()
+	for key, value := range settings {
+		env = append(env, key+"="+value)
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating the executable: %w", err)
+	}
+	m.log.Info().Msg("Bootstrapped, switching to normal operation")
+	// Exec only returns on failure.
+	err = syscall.Exec(binary, []string{os.Args[0], "monitor"}, env)
+	return fmt.Errorf("starting the monitor: %w", err)
+}
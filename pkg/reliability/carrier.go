@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"github.com/shynuu/if-reliability/carrier"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/netns"
+)
+
+// sourceCarrier is the source of the up and down events made from the link
+// state changes reported by the kernel.
+const sourceCarrier = "carrier"
+
+// watchCarrier returns events, the dispatcher events if not nil, merged with
+// up and down events on the link state changes of the interfaces.
+func (m *Manager) watchCarrier(events <-chan dispatcher.Event) (<-chan dispatcher.Event, error) {
+	var changes <-chan carrier.Event
+	err := netns.Do(m.namespace, func() error {
+		var err error
+		changes, err = carrier.Watch(nil)
+		return err
+	})
+	if err != nil {
+		return events, err
+	}
+	merged := make(chan dispatcher.Event, 16)
+	go func() {
+		for change := range changes {
+			e := dispatcher.Event{Interface: change.Interface, Action: dispatcher.ActionUp, Source: sourceCarrier}
+			if change.Up {
+				m.log.Debug().Msgf("Link %s is up", change.Interface)
+			} else {
+				e.Action = dispatcher.ActionDown
+				m.log.Info().Msgf("Link %s is down: %s", change.Interface, change.Reason)
+			}
+			merged <- e
+		}
+		m.log.Warn().Msg("Link state notifications stopped, carrier losses are only noticed by the probes")
+	}()
+	if events != nil {
+		go func() {
+			for e := range events {
+				merged <- e
+			}
+		}()
+	}
+	return merged, nil
+}
+
+// reporter names who reported a dispatcher event.
+func reporter(e dispatcher.Event) string {
+	switch e.Source {
+	case sourceCarrier:
+		return "the kernel"
+	case sourceGateway:
+		return "the gateway probe"
+	case sourceInjected:
+		return "an injected failure"
+	}
+	return "NetworkManager"
+}
@@ -0,0 +1,403 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shynuu/if-reliability/alert"
+	"github.com/shynuu/if-reliability/detect"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/pathscore"
+	"github.com/shynuu/if-reliability/severity"
+	"github.com/shynuu/if-reliability/webhook"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// linkHealth is the health of one interface of the cascade.
+type linkHealth struct {
+	m *Manager
+
+	healthy bool
+	// detector judges the probe rounds of the healthy link, and successes
+	// counts the consecutive successful ones of the unhealthy link.
+	detector  detect.Detector
+	successes int
+}
+
+// newLinkHealth returns the health of a healthy link.
+func (m *Manager) newLinkHealth() *linkHealth {
+	return &linkHealth{m: m, healthy: true, detector: detect.New(m.detection)}
+}
+
+// observe records a probe round and reports whether the link changed
+// health: it goes down once the detection algorithm declares it down and
+// comes back after successes good rounds in a row.
+func (h *linkHealth) observe(r detect.Round, successes int) bool {
+	if h.healthy {
+		if h.detector.Observe(r) {
+			h.healthy, h.successes = false, 0
+			return true
+		}
+		return false
+	}
+	if r.Failed {
+		h.successes = 0
+		return false
+	}
+	h.successes++
+	if h.successes >= successes {
+		h.healthy, h.detector = true, detect.New(h.m.detection)
+		return true
+	}
+	return false
+}
+
+// cascade routes the endpoint networks through the highest-priority healthy
+// interface of an ordered list. The first interface is the primary link,
+// carrying the default route: while it is healthy the tool installs no
+// route.
+type cascade struct {
+	m *Manager
+
+	ifaces []string
+	health map[string]*linkHealth
+	// networks are the endpoint networks, resolved at startup and on each
+	// switch.
+	networks []string
+	// active is the interface the endpoint networks are routed through,
+	// since when.
+	active string
+	since  time.Time
+	// balance spreads the traffic over the healthy interfaces instead if
+	// not nil.
+	balance *balancer
+	// bestPath routes through the healthy interface with the best path
+	// score instead of the highest-priority one, leaving a healthy active
+	// interface only for one scoring margin more. qualities are the path
+	// qualities of the last probe round.
+	bestPath  bool
+	margin    float64
+	qualities map[string]pathscore.Quality
+	// webhook, if not nil, is posted the switches on behalf of site.
+	webhook *webhook.Notifier
+	site    string
+}
+
+// newCascade returns a cascade over ifaces, all considered healthy.
+func (m *Manager) newCascade(ifaces []string) *cascade {
+	c := &cascade{m: m, ifaces: ifaces, health: map[string]*linkHealth{}, qualities: map[string]pathscore.Quality{}, active: ifaces[0], since: time.Now()}
+	for _, ifname := range ifaces {
+		c.health[ifname] = m.newLinkHealth()
+		m.metrics.AddLink(ifname)
+	}
+	m.metrics.SetActive(ifaces[0])
+	c.networks = m.movedNetworks(m.targets)
+	m.activeLink = ifaces[0]
+	m.activeCascade = c
+	return c
+}
+
+// best returns the highest-priority healthy interface that is neither held
+// down nor avoided, the highest-priority healthy one if they all
+// are, or an empty string if none is healthy. In best-path mode, the best-scoring one is returned
+// instead of the highest-priority one, the first in priority order on a tie.
+func (c *cascade) best() string {
+	now := time.Now()
+	healthy, stable := "", ""
+	for _, ifname := range c.ifaces {
+		if !c.health[ifname].healthy {
+			continue
+		}
+		if c.m.eligible(ifname, now) {
+			if !c.bestPath {
+				return ifname
+			}
+			if stable == "" || c.score(ifname) > c.score(stable) {
+				stable = ifname
+			}
+		}
+		if healthy == "" || c.bestPath && c.score(ifname) > c.score(healthy) {
+			healthy = ifname
+		}
+	}
+	if stable != "" {
+		return stable
+	}
+	return healthy
+}
+
+// eligible reports whether ifname is neither held down for flapping nor
+// avoided, over its data cap or for a scheduled preference.
+func (m *Manager) eligible(ifname string, now time.Time) bool {
+	avoid, _ := m.avoided(ifname)
+	return m.flaps.Suppressed(ifname, now) == 0 && !avoid
+}
+
+// preferred reports whether a has a higher priority than b, or in best-path
+// mode whether it scores more than the margin above b.
+func (c *cascade) preferred(a, b string) bool {
+	if c.bestPath {
+		return c.score(a) > c.score(b)+c.margin
+	}
+	for _, ifname := range c.ifaces {
+		switch ifname {
+		case a:
+			return true
+		case b:
+			return false
+		}
+	}
+	return false
+}
+
+// score returns the path score of ifname in the last probe round.
+func (c *cascade) score(ifname string) float64 {
+	return c.qualities[ifname].Score()
+}
+
+// rate updates the path quality of ifname, with the signal strength of the
+// WiFi interface ifwifi.
+func (c *cascade) rate(ifname, ifwifi string) {
+	q := c.m.pathScores.Quality(LinkKey(ifname))
+	if ifname == ifwifi {
+		if link, err := c.m.readSignal(ifname); err != nil {
+			c.m.log.Debug().Msgf("Cannot read the signal of %s: %s", ifname, err)
+		} else {
+			q.Signal = link.Signal
+		}
+	}
+	c.qualities[ifname] = q
+	c.m.metrics.SetPathScore(LinkKey(ifname), q.Score())
+	c.m.log.Debug().Msgf("Path over %s: %s", ifname, q)
+}
+
+// run probes every interface each probe interval and switches to the best one
+// whenever it changes, until the manager stops. The WiFi interface and the wired ones, if listed and
+// without default router, are connected first.
+func (c *cascade) run(ifwifi, ssid, password string, opts wifi.ConnectOptions) {
+	if c.bestPath {
+		c.m.log.Info().Msgf("Monitoring %s over %v, routing through the best path (quorum %d)", endpointList(c.m.targets), c.ifaces, c.m.probeQuorum)
+	} else {
+		c.m.log.Info().Msgf("Monitoring %s over %v in priority order (quorum %d)", endpointList(c.m.targets), c.ifaces, c.m.probeQuorum)
+	}
+	for _, ifname := range c.ifaces {
+		if ifname != ifwifi && !c.m.wired(ifname) {
+			continue
+		}
+		if router, _ := c.m.defaultRouter(ifname); router == "" {
+			if _, err := c.m.connectToWiFi(ifname, ssid, password, opts); err != nil {
+				c.m.log.Error().Msgf("Error connecting %s, it starts unhealthy: %s", ifname, err)
+				c.health[ifname].healthy = false
+			}
+		}
+	}
+	for _, ifname := range c.ifaces {
+		c.m.conntrackFlush.remember(ifname)
+	}
+	if c.balance != nil {
+		c.balance.update(c)
+	}
+	c.m.metrics.SetState(c.m.controlStatus().State)
+	noneHealthy := false
+	for {
+		select {
+		case <-c.m.probeContext().Done():
+			return
+		case <-time.After(c.m.probeInterval):
+		case req := <-c.m.commands:
+			c.m.acceptCommand(req)
+			continue
+		case event := <-c.m.triggers:
+			switch {
+			case event.Action == dispatcher.ActionHealth:
+				c.m.acceptHealth(event)
+			case event.Action == dispatcher.ActionDown && c.health[event.Interface] != nil:
+				c.m.log.Warn().Msgf("%s reported down by %s", event.Interface, reporter(event))
+				c.health[event.Interface].healthy = false
+				c.health[event.Interface].successes = 0
+			case event.Action == dispatcher.ActionEvacuate:
+				c.m.log.Warn().Msg("Evacuate requests are not supported with --interfaces, ignored")
+			}
+		}
+		if c.m.applyReload() {
+			c.m.log.Info().Msgf("Monitoring %s over %v (quorum %d)", endpointList(c.m.targets), c.ifaces, c.m.probeQuorum)
+			for _, h := range c.health {
+				if h.healthy {
+					h.detector = detect.New(c.m.detection)
+				}
+			}
+		}
+		for _, ifname := range c.ifaces {
+			round := c.m.probeRound(bindAll(c.m.targets, ifname))
+			poor, misses := c.m.degraded(ifname, round)
+			weak, signal := c.m.weakSignal(ifname)
+			unhealthy := c.m.healthInputs.Unhealthy(LinkKey(ifname), round.Failed() || poor || weak, time.Now())
+			if c.bestPath {
+				c.rate(ifname, ifwifi)
+			}
+			h := c.health[ifname]
+			if !h.observe(detect.Round{Failed: unhealthy, RTT: roundRTT(round)}, c.m.failbackSuccesses) {
+				continue
+			}
+			if h.healthy {
+				c.m.log.Info().Msgf("%s is healthy again after %d good probe rounds", ifname, c.m.failbackSuccesses)
+				c.m.decide(ifname, "healthy again")
+			} else {
+				reason := h.detector.Reason()
+				if weak && !round.Failed() && !poor {
+					c.m.log.Warn().Msgf("%s is unhealthy, %s with a weak signal: %s", ifname, reason, signal)
+				} else if poor && !round.Failed() {
+					c.m.log.Warn().Msgf("%s is unhealthy, %s below the SLA: %s", ifname, reason, misses)
+				} else {
+					if tier := c.m.gatewayTier(ifname); tier != "" {
+						c.m.log.Warn().Msgf("%s is unhealthy, %s: %s, %s", ifname, reason, round, tier)
+					} else {
+						c.m.log.Warn().Msgf("%s is unhealthy, %s: %s", ifname, reason, round)
+					}
+				}
+				c.m.decide(ifname, "unhealthy, %s", reason)
+				c.m.diagnose(ifname, failedTarget(bindAll(c.m.targets, ifname), round))
+				c.m.holdDown(ifname)
+			}
+		}
+		if c.balance != nil {
+			c.balance.update(c)
+			continue
+		}
+		best := c.best()
+		if best == "" {
+			if !noneHealthy {
+				c.m.log.Error().Msgf("No healthy interface left, staying on %s", c.active)
+				c.m.decide(c.active, "no healthy interface left")
+			}
+			noneHealthy = true
+			continue
+		}
+		noneHealthy = false
+		if best == c.active {
+			continue
+		}
+		// A healthy active link is only left for a preferred one, once
+		// the minimum dwell elapsed, or when avoided for an eligible one.
+		avoid, why := c.m.avoided(c.active)
+		avoid = avoid && c.m.eligible(best, time.Now())
+		if c.health[c.active].healthy && !avoid && (!c.preferred(best, c.active) || time.Since(c.since) < c.m.minDwell) {
+			continue
+		}
+		if c.m.suspended() {
+			c.m.log.Debug().Msgf("Automatic switching paused, staying on %s instead of %s", c.active, best)
+			continue
+		}
+		reason := c.active + " unhealthy"
+		switch {
+		case c.health[c.active].healthy && avoid:
+			reason = why
+		case c.health[c.active].healthy && c.bestPath:
+			reason = fmt.Sprintf("%s scores %.1f against %.1f", best, c.score(best), c.score(c.active))
+			c.m.log.Info().Msgf("Path over %s (%s) better than over %s (%s)", best, c.qualities[best], c.active, c.qualities[c.active])
+		case c.health[c.active].healthy:
+			reason = best + " preferred"
+		}
+		for !c.switchTo(best, reason) {
+			failed := best
+			if best = c.best(); best == "" || best == c.active {
+				break
+			}
+			reason = "switch to " + failed + " failed"
+		}
+	}
+}
+
+// switchTo routes the endpoint networks through ifname, or removes the
+// installed routes when ifname is the primary link, because of reason. When
+// the routes cannot be installed or the new path fails verification, it
+// leaves the routes as they were, marks ifname unhealthy and returns false;
+// a failed verification is alerted as a refused switch.
+func (c *cascade) switchTo(ifname string, reason string) bool {
+	from := c.active
+	if networks := c.m.movedNetworks(c.m.targets); len(networks) > 0 {
+		c.networks = networks
+	}
+	if ifname == c.ifaces[0] {
+		c.removeRoutes(c.networks)
+		c.m.restoreOnStop = nil
+	} else {
+		routers, err := c.m.defaultRouters(ifname)
+		if err == nil && len(routers) == 0 {
+			err = errors.New("no default router")
+		}
+		if err != nil {
+			c.m.log.Error().Msgf("Cannot switch to %s: %s", ifname, err)
+			c.health[ifname].healthy = false
+			return false
+		}
+		if err := c.m.routeSet(c.networks, ifname, routers, 0); err != nil {
+			c.m.log.Error().Msgf("Cannot switch to %s: %s", ifname, err)
+			c.health[ifname].healthy = false
+			return false
+		}
+		networks := c.networks
+		c.m.restoreOnStop = func() { c.removeRoutes(networks) }
+	}
+	if !c.m.verifyPath(c.m.targets, ifname) {
+		c.m.sendAlert(alert.Refused, severity.Warning, from, ifname, "path verification failed")
+		c.rollBack(ifname)
+		c.health[ifname].healthy = false
+		c.health[ifname].successes = 0
+		c.m.holdDown(ifname)
+		return false
+	}
+	c.active = ifname
+	c.since = time.Now()
+	c.m.activeLink = ifname
+	c.m.conntrackFlush.remember(ifname)
+	c.m.conntrackFlush.left(from)
+	c.m.logTransition(from, ifname)
+	event := pathFailover
+	if ifname == c.ifaces[0] {
+		event = pathFailback
+	}
+	c.m.tunnels.rehome(ifname)
+	change := pathChange{event: event, from: from, to: ifname, gateway: c.m.gatewayOf(ifname), reason: reason}
+	c.m.runPathHook(change)
+	if c.webhook != nil {
+		c.m.webhookPath(c.webhook, c.site, change)
+	}
+	return true
+}
+
+// rollBack routes the endpoint networks through the active interface again
+// after the path through ifname failed verification.
+func (c *cascade) rollBack(ifname string) {
+	c.m.log.Error().Msgf("Rolling the routes back from %s to %s", ifname, c.active)
+	if c.active == c.ifaces[0] {
+		c.m.removeRoutes(c.networks, ifname)
+		c.m.restoreOnStop = nil
+		return
+	}
+	routers, err := c.m.defaultRouters(c.active)
+	if err == nil && len(routers) == 0 {
+		err = errors.New("no default router")
+	}
+	if err == nil {
+		err = c.m.routeSet(c.networks, c.active, routers, 0)
+	}
+	if err != nil {
+		c.m.log.Error().Msgf("Cannot route through %s again: %s", c.active, err)
+	}
+	networks := c.networks
+	c.m.restoreOnStop = func() { c.removeRoutes(networks) }
+}
+
+// removeRoutes removes the routes toward networks through the active
+// interface.
+func (c *cascade) removeRoutes(networks []string) {
+	if c.active == c.ifaces[0] {
+		return
+	}
+	c.m.removeRoutes(networks, c.active)
+}
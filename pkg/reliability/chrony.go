@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+)
+
+// chronyPolicy adjusts chrony, when it serves time to the LAN, so that
+// downstream clients follow the active link instead of timing out against
+// NTP servers the backup carrier blocks.
+type chronyPolicy struct {
+	m *Manager
+
+	// primary are the NTP sources only reachable over the primary link,
+	// taken offline on failover.
+	primary []string
+	// backup are the NTP sources used over the backup link, added on
+	// failover.
+	backup []string
+	// stratum is the local stratum advertised while on the backup link, so
+	// that clients keep a reference when no source is reachable, or 0.
+	stratum int
+}
+
+// enabled reports whether chrony is managed.
+func (c chronyPolicy) enabled() bool {
+	return len(c.primary) > 0 || len(c.backup) > 0 || c.stratum > 0
+}
+
+// chronyc runs a chronyc command and reports whether it succeeded. A failure
+// whose output contains tolerated, if not empty, counts as a success.
+func (m *Manager) chronyc(tolerated string, args ...string) bool {
+	output, err := m.run("chronyc", args...)
+	if err != nil && (tolerated == "" || !strings.Contains(string(output), tolerated)) {
+		m.log.Error().Msgf("chronyc %s failed: %s, output: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+		return false
+	}
+	return true
+}
+
+// failover switches the time sources to the backup link.
+func (c chronyPolicy) failover() {
+	for _, server := range c.backup {
+		if c.m.chronyc("already present", "add", "server", server, "iburst") {
+			c.m.changeLog.Record(changes.NTP, "added", "source %s", server)
+		}
+	}
+	for _, server := range c.primary {
+		if c.m.chronyc("", "offline", server) {
+			c.m.changeLog.Record(changes.NTP, "offline", "source %s", server)
+		}
+	}
+	if c.stratum > 0 {
+		if c.m.chronyc("", "local", "stratum", strconv.Itoa(c.stratum)) {
+			c.m.changeLog.Record(changes.NTP, "set", "local stratum %d", c.stratum)
+		}
+	}
+	if len(c.backup) > 0 {
+		c.m.chronyc("", "burst", "4/4")
+	}
+}
+
+// restore switches the time sources back to the primary link.
+func (c chronyPolicy) restore() {
+	for _, server := range c.primary {
+		if c.m.chronyc("", "online", server) {
+			c.m.changeLog.Record(changes.NTP, "online", "source %s", server)
+		}
+	}
+	for _, server := range c.backup {
+		if c.m.chronyc("", "delete", server) {
+			c.m.changeLog.Record(changes.NTP, "removed", "source %s", server)
+		}
+	}
+	if c.stratum > 0 {
+		if c.m.chronyc("", "local", "off") {
+			c.m.changeLog.Record(changes.NTP, "disabled", "local stratum")
+		}
+	}
+}
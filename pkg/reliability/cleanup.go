@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/resolver"
+	"github.com/spf13/pflag"
+)
+
+// AddCleanupFlags defines the flags of Cleanup on fs, bound to c.
+func AddCleanupFlags(fs *pflag.FlagSet, c *Config) {
+	fs.IntVar(&c.Protocol, "route-proto", DefaultProtocol, "Routing protocol number of the routes to remove")
+	fs.StringVar(&c.VRF, "vrf", "", "VRF whose table is cleaned instead of the main table")
+	fs.IntVar(&c.RouteTable, "route-table", 0, "Dedicated table cleaned instead of the main table, whose ip rules are removed too")
+	fs.IntVar(&c.RulePriority, "rule-priority", defaultRulePriority, "Priority of the ip rules looking up --route-table")
+	fs.StringVar(&c.ResolvConf, "resolv-conf", defaultResolvConf, "resolv.conf restored from the copy saved on failover, if any")
+	fs.StringVar(&c.Netns, "netns", "", "Named network namespace to operate in")
+}
+
+// Cleanup removes the routes tagged with the routing protocol number of
+// config, bound to the flags of AddCleanupFlags, e.g. after a crash, and
+// restores resolv.conf if it was rewritten on failover.
+func Cleanup(config Config) error {
+	return newManager(config).cleanup()
+}
+
+// cleanup runs Cleanup.
+func (m *Manager) cleanup() error {
+	proto := m.config.Protocol
+	vrf := m.config.VRF
+	table := m.config.RouteTable
+	priority := m.config.RulePriority
+	if proto <= 0 {
+		return fmt.Errorf("invalid routing protocol number: %d", proto)
+	}
+	for _, family := range []string{"-4", "-6"} {
+		flush := []string{family, "route", "flush", "proto", strconv.Itoa(proto)}
+		if vrf != "" {
+			flush = []string{family, "route", "flush", "vrf", vrf, "proto", strconv.Itoa(proto)}
+		}
+		if table != 0 {
+			flush = []string{family, "route", "flush", "table", strconv.Itoa(table), "proto", strconv.Itoa(proto)}
+		}
+		output, err := m.run("ip", flush...)
+		if err != nil {
+			return fmt.Errorf("failed to flush routes: %w, output: %s", err, strings.TrimSpace(string(output)))
+		}
+		// Leftovers of an interrupted evacuation, usually absent.
+		m.run("ip", family, "rule", "del", "priority", strconv.Itoa(drainPrio))
+		m.run("ip", family, "route", "flush", "table", strconv.Itoa(drainTable))
+	}
+	if table != 0 {
+		policyRouting{m: m, table: table, priority: priority}.remove()
+		m.removeClasses()
+	}
+	resolvConf := m.config.ResolvConf
+	if restored, err := resolver.Restore(resolvConf); err != nil {
+		m.log.Error().Msgf("Failed to restore %s: %s", resolvConf, err)
+	} else if restored {
+		m.log.Info().Msgf("Restored %s", resolvConf)
+	}
+	m.log.Info().Msgf("Removed routes with protocol %d", proto)
+	return nil
+}
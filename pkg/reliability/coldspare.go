@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// coldSpare describes a backup radio kept off until a failover needs it,
+// saving power and reducing RF interference during normal operation.
+type coldSpare struct {
+	m *Manager
+
+	// rfkill is the rfkill device id or type (e.g. wlan) blocked while
+	// idle, or empty.
+	rfkill string
+	// powerCmd is a shell command powering the device up, or empty.
+	powerCmd string
+	// timeout bounds the wait for the interface to appear.
+	timeout time.Duration
+}
+
+// enabled reports whether the backup radio is managed as a cold spare.
+func (c coldSpare) enabled() bool {
+	return c.rfkill != "" || c.powerCmd != ""
+}
+
+// park blocks the radio until it is needed.
+func (c coldSpare) park() {
+	if c.rfkill == "" {
+		return
+	}
+	output, err := c.m.run("rfkill", "block", c.rfkill)
+	if err != nil {
+		c.m.log.Error().Msgf("Failed to block rfkill %s: %s, output: %s", c.rfkill, err, strings.TrimSpace(string(output)))
+		return
+	}
+	c.m.log.Info().Msgf("Backup radio %s blocked until needed", c.rfkill)
+}
+
+// activate powers the radio up and waits for ifname to appear and be up.
+func (c coldSpare) activate(ifname string) error {
+	if c.powerCmd != "" {
+		output, err := c.m.run("sh", "-c", c.powerCmd)
+		if err != nil {
+			return fmt.Errorf("power-on command failed: %s, output: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	if c.rfkill != "" {
+		output, err := c.m.run("rfkill", "unblock", c.rfkill)
+		if err != nil {
+			return fmt.Errorf("failed to unblock rfkill %s: %s, output: %s", c.rfkill, err, strings.TrimSpace(string(output)))
+		}
+	}
+	if c.m.dryRun {
+		return nil
+	}
+	start := time.Now()
+	for {
+		if _, err := c.m.run("ip", "link", "show", "dev", ifname); err == nil {
+			if output, err := c.m.run("ip", "link", "set", "dev", ifname, "up"); err != nil {
+				return fmt.Errorf("failed to bring %s up: %s, output: %s", ifname, err, strings.TrimSpace(string(output)))
+			}
+			c.m.log.Info().Msgf("Backup interface %s available after %s", ifname, time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+		if time.Since(start) > c.timeout {
+			return fmt.Errorf("interface %s did not appear within %s", ifname, c.timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
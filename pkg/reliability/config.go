@@ -0,0 +1,316 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Setting sources, see Source.
+const (
+	SourceDefault = "default"
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+)
+
+// EnvPrefix prefixes the environment variables holding settings.
+const EnvPrefix = "IF_RELIABILITY_"
+
+// sourceAnnotation annotates the flags set from the environment or the
+// configuration file with SourceEnv or SourceFile.
+const sourceAnnotation = "if-reliability-source"
+
+// ApplyEnv sets the flags not given on the command line from the
+// environment variables name returns for them, if set.
+func ApplyEnv(flags *pflag.FlagSet, name func(flag string) string) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" {
+			return
+		}
+		value, ok := os.LookupEnv(name(f.Name))
+		if !ok {
+			return
+		}
+		if err = flags.Set(f.Name, value); err != nil {
+			err = fmt.Errorf("%s: %s", name(f.Name), err)
+			return
+		}
+		flags.SetAnnotation(f.Name, sourceAnnotation, []string{SourceEnv})
+	})
+	return err
+}
+
+// loadConfigFile reads the settings of a YAML (.yaml, .yml) or TOML (.toml)
+// configuration file, keyed by flag name.
+func loadConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return nil, fmt.Errorf("%s: unknown format %q, expected .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return settings, nil
+}
+
+// configValues returns the flag values of a configuration file setting: one
+// for a scalar, one per element for a list.
+func configValues(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, e := range value {
+			switch e.(type) {
+			case []interface{}, map[string]interface{}:
+				return nil, errors.New("expected a list of values")
+			}
+			values = append(values, fmt.Sprint(e))
+		}
+		return values, nil
+	case map[string]interface{}:
+		return nil, errors.New("expected a value or a list")
+	case nil:
+		return nil, nil
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
+
+// ApplyConfigFile sets the flags of the monitor given neither on the command
+// line nor in the environment from the configuration file named by --config,
+// the section of the group named by --group taking precedence over the
+// top-level settings. Without --group, it returns the groups the file
+// defines, each run by a manager of its own.
+func ApplyConfigFile(flags *pflag.FlagSet) ([]string, error) {
+	path, _ := flags.GetString("config")
+	group, _ := flags.GetString("group")
+	if path == "" {
+		if group != "" {
+			return nil, fmt.Errorf("invalid --group %s: the groups are defined in the configuration file, set --config", group)
+		}
+		return nil, nil
+	}
+	settings, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	section, groups, err := groupSettings(settings, group)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := flags.Lookup(name)
+		if f == nil || f.Hidden || name == "help" || name == "config" {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if f.Changed {
+			continue
+		}
+		values, err := configValues(settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", path, name, err)
+		}
+		for _, value := range values {
+			if err := flags.Set(name, value); err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", path, name, err)
+			}
+		}
+		if len(values) > 0 {
+			flags.SetAnnotation(name, sourceAnnotation, []string{SourceFile})
+		}
+	}
+	if group != "" {
+		if err := applyGroup(flags, group, section); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return nil, nil
+	}
+	return groups, nil
+}
+
+// ReloadConfigFile returns the configuration of flags, defined by AddFlags
+// and set up by ApplyEnv and ApplyConfigFile, with the configuration file
+// and the secrets read again: the settings given on the command line or in
+// the environment keep their value, the others take that of the file, or
+// their default once removed from it. flags are left as they are.
+func ReloadConfigFile(flags *pflag.FlagSet) (Config, error) {
+	if path, _ := flags.GetString("config"); path == "" {
+		return Config{}, errors.New("no --config to reload")
+	}
+	return readConfig(flags, "")
+}
+
+// GroupConfig returns the configuration of group, read like by
+// ReloadConfigFile from flags, set up without --group, with --group group.
+func GroupConfig(flags *pflag.FlagSet, group string) (Config, error) {
+	return readConfig(flags, group)
+}
+
+// readConfig runs ReloadConfigFile, with --group group if not empty.
+func readConfig(flags *pflag.FlagSet, group string) (Config, error) {
+	var c Config
+	fresh := pflag.NewFlagSet("reliability", pflag.ContinueOnError)
+	AddFlags(fresh, &c)
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		kept := fresh.Lookup(f.Name)
+		values, source := flagValues(f), Source(f)
+		if base := f.Annotations[groupAnnotation]; len(base) == 2 {
+			values, source = base[:1], base[1]
+		}
+		if err != nil || kept == nil || source == SourceDefault || source == SourceFile || (group != "" && f.Name == "group") {
+			return
+		}
+		if slice, ok := kept.Value.(pflag.SliceValue); ok {
+			err = slice.Replace(values)
+		} else {
+			err = kept.Value.Set(values[0])
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %s", f.Name, err)
+			return
+		}
+		kept.Changed = true
+		if source == SourceEnv {
+			fresh.SetAnnotation(f.Name, sourceAnnotation, []string{SourceEnv})
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	if group != "" {
+		fresh.Set("group", group)
+	}
+	if _, err := ApplyConfigFile(fresh); err != nil {
+		return Config{}, err
+	}
+	if err := ReadSecrets(fresh, &c); err != nil {
+		return Config{}, err
+	}
+	c.Sources = SettingSources(fresh)
+	return c, nil
+}
+
+// flagValues returns the values of f, one per element for a list.
+func flagValues(f *pflag.Flag) []string {
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		return slice.GetSlice()
+	}
+	return []string{f.Value.String()}
+}
+
+// secretFlags are the settings never shown in clear.
+var secretFlags = map[string]bool{"wifi-password": true, "mqtt-password": true, "alert-smtp-password": true, "alert-slack-url": true, "alert-teams-url": true}
+
+// redactedValue replaces the value of a secret setting.
+const redactedValue = "<redacted>"
+
+// setting is one entry of the effective configuration.
+type setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Source returns where the value of f comes from: SourceEnv,
+// SourceFile, SourceFlag or SourceDefault.
+func Source(f *pflag.Flag) string {
+	if source := f.Annotations[sourceAnnotation]; len(source) > 0 {
+		return source[0]
+	}
+	if f.Changed {
+		return SourceFlag
+	}
+	return SourceDefault
+}
+
+// SettingSources returns the source of the settings of flags not left to
+// their default, by flag name, as Config.Sources holds them.
+func SettingSources(flags *pflag.FlagSet) map[string]string {
+	sources := map[string]string{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if source := Source(f); source != SourceDefault {
+			sources[f.Name] = source
+		}
+	})
+	return sources
+}
+
+// configFlags returns flags bound to the fields of c by AddFlags, holding
+// their values rather than the defaults, to walk the settings of c by flag
+// name.
+func configFlags(c *Config) *pflag.FlagSet {
+	saved := *c
+	flags := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	AddFlags(flags, c)
+	*c = saved
+	return flags
+}
+
+// effectiveConfig returns the effective configuration c, with the secrets
+// redacted.
+func effectiveConfig(c Config) map[string]setting {
+	registerSecrets(c)
+	config := map[string]setting{}
+	configFlags(&c).VisitAll(func(f *pflag.Flag) {
+		if f.Hidden || f.Name == "help" {
+			return
+		}
+		s := setting{Value: f.Value.String(), Source: orDefault(c.Sources[f.Name], SourceDefault)}
+		if secretFlags[f.Name] && s.Value != "" {
+			s.Value = redactedValue
+		}
+		s.Value = redact(s.Value)
+		config[f.Name] = s
+	})
+	return config
+}
+
+// encodeConfig returns the effective configuration c as JSON, indented if
+// indent is set.
+func encodeConfig(c Config, indent bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(effectiveConfig(c)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// EffectiveConfig returns the effective configuration c as indented JSON:
+// the value and source, from c.Sources, of every setting, with the secrets
+// redacted.
+func EffectiveConfig(c Config) ([]byte, error) {
+	return encodeConfig(c, true)
+}
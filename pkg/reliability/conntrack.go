@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/conntrack"
+	"github.com/shynuu/if-reliability/netns"
+)
+
+// Connection tracking flush modes.
+const (
+	conntrackAll    = "all"
+	conntrackEgress = "egress"
+)
+
+// conntrackPolicy deletes connection tracking entries when the traffic
+// leaves an interface, so that the flows, NATed ones included, are set up
+// again over the new path instead of sticking to the old one: every entry,
+// or the ones of the flows leaving through the addresses of the interface.
+// It is disabled if mode is empty.
+type conntrackPolicy struct {
+	m *Manager
+
+	mode string
+	// addresses are the last addresses seen on each interface, used when
+	// a failed link lost its own by the time the traffic leaves it.
+	mu        sync.Mutex
+	addresses map[string][]net.IP
+}
+
+// newConntrackPolicy returns the connection tracking policy of mode: off,
+// all or egress.
+func (m *Manager) newConntrackPolicy(mode string) (*conntrackPolicy, error) {
+	c := &conntrackPolicy{m: m, addresses: map[string][]net.IP{}}
+	switch mode {
+	case "off":
+	case conntrackAll, conntrackEgress:
+		c.mode = mode
+	default:
+		return c, fmt.Errorf("unknown conntrack flush mode %q (off, %s or %s)", mode, conntrackAll, conntrackEgress)
+	}
+	return c, nil
+}
+
+// enabled reports whether entries are deleted on switches.
+func (c *conntrackPolicy) enabled() bool {
+	return c.mode != ""
+}
+
+// remember records the addresses of ifname, if it has any, in the egress
+// mode, and returns its last known ones.
+func (c *conntrackPolicy) remember(ifname string) []net.IP {
+	if c.mode != conntrackEgress || ifname == "" {
+		return nil
+	}
+	ips := c.m.interfaceIPs(ifname)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(ips) == 0 {
+		return c.addresses[ifname]
+	}
+	c.addresses[ifname] = ips
+	return ips
+}
+
+// left deletes the entries of the flows that went through ifname, now that
+// the traffic left it.
+func (c *conntrackPolicy) left(ifname string) {
+	if !c.enabled() || ifname == "" {
+		return
+	}
+	if c.mode == conntrackAll {
+		if _, err := c.m.conntrackOp(func() (string, error) { return "", conntrack.Flush() }, "flush"); err != nil {
+			c.m.log.Error().Msgf("Failed to flush the connection tracking table: %s", err)
+			return
+		}
+		c.m.changeLog.Record(changes.Conntrack, "flushed", "all entries, leaving %s", ifname)
+		return
+	}
+	ips := c.remember(ifname)
+	if len(ips) == 0 {
+		c.m.log.Warn().Msgf("No address known for %s, its connection tracking entries are kept", ifname)
+		return
+	}
+	args := []string{"delete", ifname}
+	for _, ip := range ips {
+		args = append(args, ip.String())
+	}
+	output, err := c.m.conntrackOp(func() (string, error) {
+		deleted, err := conntrack.DeleteEgress(ips)
+		return strconv.FormatUint(uint64(deleted), 10), err
+	}, args...)
+	if err != nil {
+		c.m.log.Error().Msgf("Failed to delete the connection tracking entries of %s: %s", ifname, err)
+		return
+	}
+	if !c.m.dryRun {
+		c.m.log.Info().Msgf("Deleted %s connection tracking entries of the flows through %s", output, ifname)
+	}
+	c.m.changeLog.Record(changes.Conntrack, "deleted", "%s entries through %s", output, ifname)
+}
+
+// conntrackOp runs a connection tracking operation over netlink from within
+// the configured network namespace, recorded and replayed as a run of
+// "conntrack-netlink" with args describing it.
+func (m *Manager) conntrackOp(op func() (string, error), args ...string) (string, error) {
+	return m.operate("conntrack-netlink", func() (string, error) {
+		var result string
+		err := netns.Do(m.namespace, func() error {
+			var err error
+			result, err = op()
+			return err
+		})
+		return result, err
+	}, args...)
+}
+
+// interfaceIPs returns the global unicast addresses of ifname, none if it is
+// gone.
+func (m *Manager) interfaceIPs(ifname string) []net.IP {
+	var ips []net.IP
+	netns.Do(m.namespace, func() error {
+		iface, err := net.InterfaceByName(ifname)
+		if err != nil {
+			return err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.IsGlobalUnicast() {
+				ips = append(ips, prefix.IP)
+			}
+		}
+		return nil
+	})
+	return ips
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/sdnotify"
+)
+
+// beat records that the monitor made progress.
+func (m *Manager) beat() {
+	m.heartbeat.Store(time.Now().UnixNano())
+}
+
+// notify sends states to systemd in daemon mode.
+func (m *Manager) notify(states ...string) {
+	if !m.daemon {
+		return
+	}
+	if _, err := sdnotify.Notify(states...); err != nil {
+		m.log.Warn().Msgf("Error notifying systemd: %s", err)
+	}
+}
+
+// startDaemon tells systemd the monitor is ready and, if the service has a
+// watchdog, answers it for as long as the monitor makes progress, so that
+// systemd restarts a stalled monitor.
+func (m *Manager) startDaemon(status string) {
+	m.notify(sdnotify.Ready, sdnotify.Status(status))
+	interval, ok := sdnotify.WatchdogInterval()
+	if !m.daemon || !ok {
+		return
+	}
+	m.log.Info().Msgf("Answering the systemd watchdog, timeout %s", interval)
+	m.beat()
+	go func() {
+		for range m.tick(interval / 2) {
+			last := time.Unix(0, m.heartbeat.Load())
+			if time.Since(last) >= interval {
+				m.log.Error().Msgf("Monitor stalled since %s, no longer answering the systemd watchdog", last.Format(time.RFC3339))
+				continue
+			}
+			m.notify(sdnotify.Watchdog)
+		}
+	}()
+}
+
+// stopDaemon tells systemd the monitor is stopping and, in daemon mode or
+// with --restore-on-exit, restores the primary routes if failed over,
+// removes the policy routing rules and restores the default routes the
+// monitor started from.
+func (m *Manager) stopDaemon() {
+	m.notify(sdnotify.Stopping)
+	if m.statePublisher != nil {
+		m.statePublisher.Close()
+	}
+	if m.pair.enabled() {
+		m.pair.release()
+	}
+	if !m.daemon && !m.restoreOnExit {
+		return
+	}
+	if m.restoreOnStop != nil {
+		m.log.Info().Msg("Restoring the primary link before exiting")
+		m.restoreOnStop()
+	}
+	if m.routeMetrics.enabled() {
+		m.routeMetrics.remove()
+	}
+	if m.routingPolicy.enabled() {
+		m.routingPolicy.remove()
+	}
+	m.restoreDefaults()
+	m.pathMTU.restore()
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/nm"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// readLease returns the DHCPv4 lease NetworkManager obtained on ifname, nil
+// if it has none.
+func (m *Manager) readLease(ifname string) (*nm.Lease, error) {
+	var lease *nm.Lease
+	err := m.runNM(func(c WiFiManager) error {
+		var err error
+		lease, err = c.Lease(ifname)
+		return err
+	}, "device", "lease", ifname)
+	return lease, err
+}
+
+// renewLease makes NetworkManager obtain a new lease on ifname, waiting up to
+// timeout.
+func (m *Manager) renewLease(ifname string, timeout time.Duration) error {
+	m.log.Warn().Msgf("Renewing the DHCP lease of %s...", ifname)
+	err := m.runNM(func(c WiFiManager) error { return c.Renew(ifname, timeout) }, "device", "renew", ifname)
+	if err != nil {
+		return err
+	}
+	m.changeLog.Record(changes.Connection, "reactivated", "%s to renew its DHCP lease", ifname)
+	return nil
+}
+
+// awaitLease waits up to timeout for a DHCP lease on ifwifi that has not
+// expired, with a router answering pings, and returns the router. An expired
+// lease is renewed once. An interface without DHCPv4 lease, e.g. configured
+// statically or routing IPv6 first, uses the router of its default route.
+func (m *Manager) awaitLease(ifwifi string, timeout time.Duration) (string, error) {
+	if m.families()[0] == route.IPv6 {
+		return m.awaitRouter(ifwifi, timeout)
+	}
+	deadline := time.Now().Add(timeout)
+	renewed := false
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		lease, err := m.readLease(ifwifi)
+		if err != nil {
+			m.log.Warn().Msgf("Cannot read the DHCP lease of %s, waiting for its default route instead: %s", ifwifi, err)
+			return m.awaitRouter(ifwifi, time.Until(deadline))
+		}
+		if lease != nil && lease.Expired(time.Now()) {
+			if renewed {
+				continue
+			}
+			m.log.Warn().Msgf("DHCP lease of %s expired: %s", ifwifi, lease)
+			if err := m.renewLease(ifwifi, time.Until(deadline)); err != nil {
+				return "", fmt.Errorf("renewing the expired DHCP lease of %s: %w", ifwifi, err)
+			}
+			renewed = true
+			continue
+		}
+		router := ""
+		if lease != nil {
+			router = lease.Router
+		}
+		if router == "" {
+			if router, err = m.defaultRouter(ifwifi); err != nil {
+				return "", err
+			}
+		}
+		if router == "" {
+			continue
+		}
+		m.log.Debug().Msgf("Pinging default router: %s", router)
+		if m.pingIP(router, ifwifi).OK() {
+			if lease != nil {
+				m.log.Info().Msgf("DHCP lease of %s: %s", ifwifi, lease)
+			}
+			return router, nil
+		}
+	}
+	return "", fmt.Errorf("no valid DHCP lease with a reachable router on %s within %s", ifwifi, timeout)
+}
+
+// refreshLease renews the DHCP lease of ifwifi if it expired, e.g. on a warm
+// standby whose lease ran out unnoticed, and returns the router of the new
+// lease, or router if the lease is still valid.
+func (m *Manager) refreshLease(ifwifi, router string, timeout time.Duration) (string, error) {
+	if m.dryRun || m.families()[0] == route.IPv6 {
+		return router, nil
+	}
+	lease, err := m.readLease(ifwifi)
+	if err != nil || lease == nil || !lease.Expired(time.Now()) {
+		return router, nil
+	}
+	m.log.Warn().Msgf("DHCP lease of %s expired: %s", ifwifi, lease)
+	if err := m.renewLease(ifwifi, timeout); err != nil {
+		return "", err
+	}
+	return m.awaitLease(ifwifi, timeout)
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/diagnosis"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/quorum"
+)
+
+// failedTarget returns the first target that failed in round, or the first
+// target if none did, e.g. a round below the SLA.
+func failedTarget(targets []endpoint.Endpoint, round quorum.Round) endpoint.Endpoint {
+	for _, s := range round.Statuses {
+		if s.Result.OK() {
+			continue
+		}
+		for _, target := range targets {
+			if target.String() == s.Endpoint {
+				return target
+			}
+		}
+	}
+	return targets[0]
+}
+
+// diagnose appends to the event log, in the background so that the failover
+// is not delayed, a traceroute toward target over ifname and a snapshot of
+// the counters of ifname and of its signal, to tell after the fact a carrier
+// or radio problem from a failure further away.
+func (m *Manager) diagnose(ifname string, target endpoint.Endpoint) {
+	if !m.diagnoseFailures || m.eventLog == nil || ifname == "" {
+		return
+	}
+	id := m.outages.ID()
+	go func() {
+		e := eventlog.Event{Kind: eventlog.KindDiagnosis, Interface: ifname, Endpoint: target.String(), OutageID: id}
+		var summary, failures []string
+		err := errors.New("not read while replaying")
+		if m.player == nil {
+			err = netns.Do(m.namespace, func() error {
+				link, err := diagnosis.ReadLink(ifname)
+				e.Link = &link
+				return err
+			})
+		}
+		if err != nil {
+			e.Link = nil
+			failures = append(failures, fmt.Sprintf("counters: %s", err))
+		} else {
+			description := fmt.Sprintf("%s %s with %d errors and drops", ifname, e.Link.State, e.Link.Errors())
+			if e.Link.CarrierChanges != nil {
+				description += fmt.Sprintf(", %d carrier changes", *e.Link.CarrierChanges)
+			}
+			summary = append(summary, description)
+		}
+		switch {
+		case ifname == m.rssiIF:
+			if link, err := m.readSignal(ifname); err != nil {
+				failures = append(failures, fmt.Sprintf("signal: %s", err))
+			} else {
+				e.SignalDBM = link.Signal
+				summary = append(summary, fmt.Sprintf("signal %d dBm", link.Signal))
+			}
+		case m.primaryModem.enabled() && ifname == m.primaryModem.ifname:
+			if s, err := m.primaryModem.signal(); err != nil {
+				failures = append(failures, fmt.Sprintf("modem signal: %s", err))
+			} else {
+				summary = append(summary, fmt.Sprintf("modem signal %s", s))
+			}
+		}
+		output, err := m.run("traceroute", "-n", "-q", "1", "-w", "1", "-m", strconv.Itoa(m.diagnoseHops), "-i", ifname, target.Host)
+		e.Hops = diagnosis.ParseTraceroute(string(output))
+		switch last, answered := diagnosis.LastAnswer(e.Hops); {
+		case err != nil && len(e.Hops) == 0:
+			failures = append(failures, fmt.Sprintf("traceroute: %s", err))
+		case diagnosis.Reached(e.Hops, target.Host):
+			summary = append(summary, fmt.Sprintf("%s reached in %d hops", target.Host, len(e.Hops)))
+		case answered:
+			summary = append(summary, fmt.Sprintf("traceroute toward %s stops after hop %s", target.Host, last))
+		default:
+			summary = append(summary, fmt.Sprintf("no hop toward %s answered", target.Host))
+		}
+		e.Message = strings.Join(summary, ", ")
+		e.Error = strings.Join(failures, "; ")
+		m.log.Info().Msgf("Diagnosis of %s: %s", ifname, e.Message)
+		m.logEvent(e)
+	}()
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/shynuu/if-reliability/netns"
+)
+
+// anycastEndpoints are the public anycast resolvers probed when no
+// --endpoint is given.
+var anycastEndpoints = []string{"1.1.1.1", "8.8.8.8", "9.9.9.9", "2606:4700:4700::1111", "2001:4860:4860::8888", "2620:fe::fe"}
+
+// discoverEndpoints returns the endpoints probed when none is given: the
+// DNS servers NetworkManager learned on the primary link, typically from
+// DHCP, and the --anycast-endpoint addresses, of the enabled address
+// families. Only public addresses are kept, as the networks of the endpoints
+// are moved on failover: a resolver on the local network, often the gateway
+// itself, must stay reachable over the primary link.
+func (m *Manager) discoverEndpoints() ([]string, error) {
+	anycast := m.config.AnycastEndpoints
+	ns := m.config.Netns
+	vrfName := m.config.VRF
+	var candidates []string
+	for _, address := range anycast {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid anycast endpoint %q: it must be an IP address", address)
+		}
+		if m.familyEnabled(familyOf(ip)) {
+			candidates = append(candidates, ip.String())
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no --anycast-endpoint in the enabled address families")
+	}
+	var primary string
+	err := netns.Do(ns, func() error {
+		var err error
+		primary, err = m.routeManager.Device(candidates[0], vrfName)
+		return err
+	})
+	if err != nil {
+		m.log.Warn().Msgf("Cannot find the primary link, its DNS servers are not probed: %s", err)
+	}
+	var servers []string
+	if primary != "" {
+		if servers, err = m.learnedNameservers(primary); err != nil {
+			m.log.Warn().Msgf("Cannot read the DNS servers of %s, they are not probed: %s", primary, err)
+		}
+	}
+	seen := map[string]bool{}
+	var found []string
+	for _, server := range servers {
+		ip := net.ParseIP(server)
+		switch {
+		case ip == nil || !m.familyEnabled(familyOf(ip)):
+			continue
+		case !public(ip):
+			m.log.Info().Msgf("DNS server %s of %s is on a local network, not probed", server, primary)
+			continue
+		}
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			found = append(found, ip.String())
+		}
+	}
+	if len(found) > 0 {
+		m.log.Info().Msgf("Discovered DNS servers of %s: %s", primary, strings.Join(found, ", "))
+	}
+	for _, address := range candidates {
+		if !seen[address] {
+			seen[address] = true
+			found = append(found, address)
+		}
+	}
+	return found, nil
+}
+
+// learnedNameservers returns the DNS servers the WiFi backend learned on
+// ifname.
+func (m *Manager) learnedNameservers(ifname string) ([]string, error) {
+	return m.wifiManager.Nameservers(ifname)
+}
+
+// public reports whether ip is a globally routable unicast address.
+func public(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// gatewayTier probes the default router of ifname when gatewayCheck is set
+// and describes where a failed probe round breaks: at the local link if the
+// gateway does not answer either, beyond it otherwise. It returns an empty
+// string when the check is disabled or there is no gateway to probe.
+func (m *Manager) gatewayTier(ifname string) string {
+	if !m.gatewayCheck || ifname == "" {
+		return ""
+	}
+	router, err := m.defaultRouter(ifname)
+	if err != nil || router == "" {
+		return ""
+	}
+	if m.probeFrom(m.routerPinger, router, ifname).OK() {
+		return fmt.Sprintf("gateway %s answers, internet unreachable beyond the local network", router)
+	}
+	return fmt.Sprintf("gateway %s unreachable, the local link is down", router)
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/resolver"
+)
+
+// DNS modes.
+const (
+	dnsResolvConf = "resolv.conf"
+	dnsResolved   = "resolved"
+)
+
+// defaultResolvConf is the resolv.conf rewritten in the resolv.conf mode.
+const defaultResolvConf = "/etc/resolv.conf"
+
+// dnsPolicy switches the DNS servers of the host to the backup link on
+// failover, as the servers learned over the primary link are often only
+// reachable through it, and back on failback. It is disabled if mode is
+// empty.
+type dnsPolicy struct {
+	m *Manager
+
+	mode string
+	// path is the resolv.conf rewritten in the resolv.conf mode, pointed
+	// to servers, or to the servers NetworkManager learned on WiFi if
+	// empty.
+	path    string
+	servers []string
+	// defaults are the systemd-resolved default route settings of the
+	// links changed on failover, restored on failback.
+	defaults map[string]bool
+}
+
+// newDNSPolicy returns the DNS policy of mode: off, resolv.conf or resolved.
+func (m *Manager) newDNSPolicy(mode, path string, servers []string) (*dnsPolicy, error) {
+	d := &dnsPolicy{m: m, path: path, servers: servers, defaults: map[string]bool{}}
+	for _, server := range servers {
+		if net.ParseIP(server) == nil {
+			return d, fmt.Errorf("invalid DNS server %q", server)
+		}
+	}
+	switch mode {
+	case "off":
+		if len(servers) > 0 {
+			return d, fmt.Errorf("--dns-servers needs --dns-mode")
+		}
+	case dnsResolvConf:
+		if err := resolver.Check(path); err != nil {
+			return d, fmt.Errorf("cannot rewrite %s, use --dns-mode %s if systemd-resolved manages it: %w", path, dnsResolved, err)
+		}
+		d.mode = mode
+	case dnsResolved:
+		if len(servers) > 0 {
+			return d, fmt.Errorf("--dns-servers only applies to --dns-mode %s, systemd-resolved uses the servers of each link", dnsResolvConf)
+		}
+		d.mode = mode
+	default:
+		return d, fmt.Errorf("unknown DNS mode %q (off, %s or %s)", mode, dnsResolvConf, dnsResolved)
+	}
+	return d, nil
+}
+
+// enabled reports whether the DNS servers are switched.
+func (d *dnsPolicy) enabled() bool {
+	return d.mode != ""
+}
+
+// runResolved runs a systemd-resolved operation over D-Bus and returns its
+// result, recorded and replayed as a run of "resolved" with args describing
+// it.
+func (m *Manager) runResolved(op func(r *resolver.Resolved) (string, error), args ...string) (string, error) {
+	return m.operate("resolved", func() (string, error) {
+		if m.resolvedClient == nil {
+			client, err := resolver.DialResolved()
+			if err != nil {
+				return "", fmt.Errorf("cannot reach systemd-resolved on the system bus: %w", err)
+			}
+			m.resolvedClient = client
+		}
+		return op(m.resolvedClient)
+	}, args...)
+}
+
+// failover switches the DNS servers from primaryIF, empty if unknown, to
+// ifwifi.
+func (d *dnsPolicy) failover(primaryIF, ifwifi string) {
+	switch d.mode {
+	case dnsResolvConf:
+		d.switchResolvConf(ifwifi)
+	case dnsResolved:
+		if primaryIF != "" {
+			d.setDefaultRoute(primaryIF, false)
+		}
+		d.setDefaultRoute(ifwifi, true)
+		d.flushCaches()
+	}
+}
+
+// restore switches the DNS servers back to the primary link.
+func (d *dnsPolicy) restore() {
+	switch d.mode {
+	case dnsResolvConf:
+		output, err := d.m.operate("resolv.conf", func() (string, error) {
+			restored, err := resolver.Restore(d.path)
+			return strconv.FormatBool(restored), err
+		}, "restore", d.path)
+		if err != nil {
+			d.m.log.Error().Msgf("Failed to restore %s: %s", d.path, err)
+		} else if output == "true" {
+			d.m.changeLog.Record(changes.DNS, "restored", "%s", d.path)
+		}
+	case dnsResolved:
+		for ifname, enabled := range d.defaults {
+			if err := d.m.setResolvedDefault(ifname, enabled); err != nil {
+				d.m.log.Error().Msgf("Failed to restore the DNS default route of %s: %s", ifname, err)
+				continue
+			}
+			d.m.changeLog.Record(changes.DNS, "restored", "default route of %s to %t", ifname, enabled)
+			delete(d.defaults, ifname)
+		}
+		d.flushCaches()
+	}
+}
+
+// switchResolvConf points resolv.conf to the servers of ifwifi.
+func (d *dnsPolicy) switchResolvConf(ifwifi string) {
+	servers := d.servers
+	if len(servers) == 0 {
+		if err := d.m.runNM(func(c WiFiManager) error {
+			// The servers of the DHCP lease, or those of the IP
+			// configuration without lease.
+			lease, err := c.Lease(ifwifi)
+			if err == nil && lease != nil && len(lease.Nameservers) > 0 {
+				servers = lease.Nameservers
+				return nil
+			}
+			servers, err = c.Nameservers(ifwifi)
+			return err
+		}, "device", "dns", ifwifi); err != nil {
+			d.m.log.Error().Msgf("Cannot read the DNS servers of %s: %s", ifwifi, err)
+			return
+		}
+	}
+	if len(servers) == 0 {
+		d.m.log.Error().Msgf("No DNS server learned on %s, %s left unchanged", ifwifi, d.path)
+		return
+	}
+	args := append([]string{"switch", d.path}, servers...)
+	if _, err := d.m.operate("resolv.conf", func() (string, error) { return "", resolver.Switch(d.path, servers) }, args...); err != nil {
+		d.m.log.Error().Msgf("Failed to rewrite %s: %s", d.path, err)
+		return
+	}
+	d.m.changeLog.Record(changes.DNS, "replaced", "nameservers of %s with %s", d.path, strings.Join(servers, " "))
+	d.m.log.Info().Msgf("DNS servers switched to %s", strings.Join(servers, " "))
+}
+
+// setDefaultRoute sets whether systemd-resolved sends the queries of the
+// domains no link claims to the servers of ifname, saving the previous
+// setting.
+func (d *dnsPolicy) setDefaultRoute(ifname string, enabled bool) {
+	output, err := d.m.runResolved(func(r *resolver.Resolved) (string, error) {
+		ifindex, err := interfaceIndex(ifname)
+		if err != nil {
+			return "", err
+		}
+		current, err := r.DefaultRoute(ifindex)
+		return strconv.FormatBool(current), err
+	}, "link", ifname)
+	if err != nil {
+		d.m.log.Error().Msgf("Cannot read the DNS settings of %s: %s", ifname, err)
+		return
+	}
+	current, _ := strconv.ParseBool(output)
+	if current == enabled {
+		return
+	}
+	if err := d.m.setResolvedDefault(ifname, enabled); err != nil {
+		d.m.log.Error().Msgf("Failed to set the DNS default route of %s: %s", ifname, err)
+		return
+	}
+	if _, saved := d.defaults[ifname]; !saved {
+		d.defaults[ifname] = current
+	}
+	d.m.changeLog.Record(changes.DNS, "set", "default route of %s to %t", ifname, enabled)
+}
+
+// setResolvedDefault sets whether systemd-resolved sends the queries of the
+// domains no link claims to the servers of ifname.
+func (m *Manager) setResolvedDefault(ifname string, enabled bool) error {
+	_, err := m.runResolved(func(r *resolver.Resolved) (string, error) {
+		ifindex, err := interfaceIndex(ifname)
+		if err != nil {
+			return "", err
+		}
+		return "", r.SetDefaultRoute(ifindex, enabled)
+	}, "default-route", ifname, strconv.FormatBool(enabled))
+	return err
+}
+
+// flushCaches drops the answers systemd-resolved cached from the previous
+// servers.
+func (d *dnsPolicy) flushCaches() {
+	if _, err := d.m.runResolved(func(r *resolver.Resolved) (string, error) { return "", r.FlushCaches() }, "flush-caches"); err != nil {
+		d.m.log.Warn().Msgf("Failed to flush the DNS caches: %s", err)
+	}
+}
+
+// interfaceIndex returns the index of ifname.
+func interfaceIndex(ifname string) (int, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return 0, err
+	}
+	return iface.Index, nil
+}
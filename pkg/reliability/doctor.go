@@ -0,0 +1,318 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/capability"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/nm"
+)
+
+// resolveTimeout bounds the resolution of an endpoint host name by doctor.
+const resolveTimeout = 5 * time.Second
+
+// finding is the outcome of one preflight check. A failed check has an
+// error, and a hint on how to fix it; a warning does not prevent the
+// monitor from running but disables part of it.
+type finding struct {
+	check   string
+	err     error
+	hint    string
+	warning bool
+}
+
+// doctor runs the preflight checks of the monitor configured by the config
+// of its manager.
+type doctor struct {
+	m *Manager
+
+	findings []finding
+}
+
+// ok records a passed check.
+func (d *doctor) ok(check string, format string, args ...interface{}) {
+	d.findings = append(d.findings, finding{check: check, hint: fmt.Sprintf(format, args...)})
+}
+
+// fail records a failed check, or a warning.
+func (d *doctor) fail(check string, err error, warning bool, hint string) {
+	d.findings = append(d.findings, finding{check: check, err: err, hint: hint, warning: warning})
+}
+
+// privileges checks that the process may change routes and send raw ICMP.
+func (d *doctor) privileges() {
+	if os.Geteuid() == 0 {
+		d.ok("privileges", "running as root")
+		return
+	}
+	caps, err := capability.Effective()
+	if err != nil {
+		d.fail("privileges", err, false, "run as root")
+		return
+	}
+	missing := caps.Missing(capability.NetAdmin, capability.NetRaw)
+	if len(missing) == 0 {
+		d.ok("privileges", "%s and %s granted", capability.NetAdmin, capability.NetRaw)
+		return
+	}
+	names := make([]string, len(missing))
+	for i, c := range missing {
+		names[i] = c.String()
+	}
+	d.fail("privileges", fmt.Errorf("missing %s", strings.Join(names, ", ")), false,
+		"run as root, or grant them with AmbientCapabilities=CAP_NET_ADMIN CAP_NET_RAW in the systemd unit or setcap cap_net_admin,cap_net_raw+ep")
+}
+
+// netlink checks that the routing tables can be read over rtnetlink.
+func (d *doctor) netlink() {
+	err := netns.Do(d.m.namespace, func() error {
+		_, err := d.m.routeManager.Defaults(d.m.vrf, d.m.families()[0])
+		return err
+	})
+	if err != nil {
+		d.fail("netlink", err, false, "check the --netns and --vrf settings, and that the kernel has rtnetlink")
+		return
+	}
+	d.ok("netlink", "routing tables readable")
+}
+
+// networkManager checks that NetworkManager, which connects WiFi, runs.
+func (d *doctor) networkManager() {
+	if replayPath := d.m.config.Replay; replayPath != "" {
+		d.ok("NetworkManager", "replayed from %s", replayPath)
+		return
+	}
+	running, err := nm.Running()
+	switch {
+	case err != nil:
+		d.fail("NetworkManager", fmt.Errorf("system bus unreachable: %w", err), false, "start dbus, or make its socket available to the service")
+	case !running:
+		d.fail("NetworkManager", fmt.Errorf("not running on the system bus"), false, "systemctl enable --now NetworkManager")
+	default:
+		d.ok("NetworkManager", "running")
+	}
+}
+
+// interfaces checks that the named interfaces exist. A cold spare WiFi
+// interface may only appear once its radio is unblocked.
+func (d *doctor) interfaces() {
+	wifiIF := d.m.config.WiFiInterface
+	names := map[string]bool{}
+	if wifiIF != "" {
+		names[wifiIF] = true
+	}
+	ifaces := d.m.config.Interfaces
+	for _, name := range ifaces {
+		names[name] = true
+	}
+	if modem := d.m.config.Modem; modem != "" {
+		names[modem] = true
+	}
+	if d.m.vrf != "" {
+		names[d.m.vrf] = true
+	}
+	endpoints := d.m.config.Endpoints
+	verify := d.m.config.VerifyEndpoints
+	targets, _ := endpoint.ParseList(append(endpoints, verify...))
+	for _, target := range targets {
+		if target.Interface != "" {
+			names[target.Interface] = true
+		}
+	}
+	rfkill := d.m.config.ColdSpareRFKill
+	powerCmd := d.m.config.ColdSparePowerCommand
+	for _, name := range sortedKeys(names) {
+		err := netns.Do(d.m.namespace, func() error {
+			_, err := net.InterfaceByName(name)
+			return err
+		})
+		switch {
+		case err == nil:
+			d.ok("interface "+name, "present")
+		case name == wifiIF && (rfkill != "" || powerCmd != ""):
+			d.fail("interface "+name, err, true, "expected while the cold spare is off, it must appear once activated")
+		default:
+			d.fail("interface "+name, err, false, "check the interface name with ip link")
+		}
+	}
+}
+
+// endpoints checks that the host names of the endpoints resolve in the
+// enabled address families, or that endpoints are discovered if none is
+// given.
+func (d *doctor) endpoints() {
+	endpoints := d.m.config.Endpoints
+	if len(endpoints) == 0 {
+		discovered, err := d.m.discoverEndpoints()
+		if err != nil {
+			d.fail("endpoints", err, false, "give at least one --endpoint or --anycast-endpoint")
+			return
+		}
+		d.ok("endpoints", "none given, discovered %s", strings.Join(discovered, ", "))
+		endpoints = discovered
+	}
+	verify := d.m.config.VerifyEndpoints
+	targets, err := endpoint.ParseList(append(endpoints, verify...))
+	if err != nil {
+		d.fail("endpoints", err, false, "fix the --endpoint and --verify-endpoint syntax")
+		return
+	}
+	if err := d.m.checkFamilies(targets); err != nil {
+		d.fail("endpoints", err, false, "change --ip-family or the endpoints")
+		return
+	}
+	for _, target := range targets {
+		if net.ParseIP(target.Host) != nil {
+			continue
+		}
+		var ips []net.IP
+		err := netns.Do(d.m.namespace, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+			defer cancel()
+			var err error
+			ips, err = net.DefaultResolver.LookupIP(ctx, "ip"+d.m.networkSuffix(), target.Host)
+			return err
+		})
+		if err != nil {
+			d.fail("endpoint "+target.Host, err, false, "check the name and the DNS servers, or use an IP address")
+			continue
+		}
+		d.ok("endpoint "+target.Host, "resolves to %s", ips[0])
+	}
+}
+
+// programs checks that the external programs the configuration runs are
+// installed. iw and conntrack are only needed by optional checks.
+func (d *doctor) programs() {
+	needed := map[string]string{}
+	if d.m.namespace != "" {
+		needed["ip"] = "--netns"
+	}
+	if rfkill := d.m.config.ColdSpareRFKill; rfkill != "" {
+		needed["rfkill"] = "--cold-spare-rfkill"
+		needed["ip"] = "--cold-spare-rfkill"
+	}
+	if len(d.m.config.ChronyPrimaryServers) > 0 {
+		needed["chronyc"] = "--chrony-primary-servers"
+	}
+	if len(d.m.config.ChronyBackupServers) > 0 {
+		needed["chronyc"] = "--chrony-backup-servers"
+	}
+	if stratum := d.m.config.ChronyFailoverStratum; stratum > 0 {
+		needed["chronyc"] = "--chrony-failover-stratum"
+	}
+	if d.m.config.TCPKeepaliveTime > 0 {
+		needed["sysctl"] = "--tcp-keepalive-time"
+	}
+	if d.m.config.TCPKeepaliveInterval > 0 {
+		needed["sysctl"] = "--tcp-keepalive-interval"
+	}
+	if probes := d.m.config.TCPKeepaliveProbes; probes > 0 {
+		needed["sysctl"] = "--tcp-keepalive-probes"
+	}
+	if iperf3 := d.m.config.ThroughputIperf3; iperf3 != "" {
+		needed["iperf3"] = "--throughput-iperf3"
+	}
+	rules := d.m.config.FirewallRules
+	for _, rule := range rules {
+		if fields := strings.Fields(rule); len(fields) > 0 {
+			needed[fields[0]] = "--firewall-rule"
+		}
+	}
+	if cgroups := d.m.config.RuleCgroups; len(cgroups) > 0 {
+		needed["iptables"] = "--rule-cgroup"
+	}
+	for _, program := range sortedKeys(needed) {
+		flag := needed[program]
+		if path, err := exec.LookPath(program); err != nil {
+			d.fail(program, err, false, "install it, "+flag+" runs it")
+		} else {
+			d.ok(program, "%s", path)
+		}
+	}
+	optional := map[string]string{
+		"iw":        "the WiFi health and signal checks are skipped",
+		"conntrack": "evacuations cannot wait for the established flows",
+	}
+	eventLog := d.m.config.EventLog
+	if diagnose := d.m.config.Diagnose; diagnose && eventLog != "" {
+		optional["traceroute"] = "the failure diagnoses have no traceroute"
+	}
+	for _, program := range sortedKeys(optional) {
+		loss := optional[program]
+		if _, ok := needed[program]; ok {
+			continue
+		}
+		if path, err := exec.LookPath(program); err != nil {
+			d.fail(program, err, true, "install it, otherwise "+loss)
+		} else {
+			d.ok(program, "%s", path)
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in order, so that the findings are
+// always printed in the same order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// report prints the findings and reports whether no check failed.
+func (d *doctor) report() bool {
+	passed := true
+	for _, f := range d.findings {
+		switch {
+		case f.err == nil:
+			fmt.Printf("ok    %s: %s\n", f.check, f.hint)
+		case f.warning:
+			fmt.Printf("warn  %s: %s\n      %s\n", f.check, f.err, f.hint)
+		default:
+			passed = false
+			fmt.Printf("FAIL  %s: %s\n      %s\n", f.check, f.err, f.hint)
+		}
+	}
+	return passed
+}
+
+// Doctor runs the preflight checks of the monitor configured by config:
+// root or the CAP_NET_ADMIN and CAP_NET_RAW
+// capabilities, rtnetlink access, NetworkManager on the system bus, the
+// named interfaces, the resolution of the endpoints and the external
+// programs the settings run. It prints every finding, with how to fix the
+// failed checks, and reports whether they all passed.
+func Doctor(config Config) (bool, error) {
+	return newManager(config).doctor()
+}
+
+// doctor runs Doctor.
+func (m *Manager) doctor() (bool, error) {
+	family := m.config.IPFamily
+	if err := m.setFamily(family); err != nil {
+		return false, fmt.Errorf("parsing --ip-family: %w", err)
+	}
+	m.vrf = m.config.VRF
+	d := &doctor{m: m}
+	d.privileges()
+	d.netlink()
+	d.networkManager()
+	d.interfaces()
+	d.endpoints()
+	d.programs()
+	return d.report(), nil
+}
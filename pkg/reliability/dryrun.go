@@ -0,0 +1,59 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"strings"
+)
+
+// readOnly reports whether running program with args, an external program
+// or an in-process operation, leaves the system unchanged.
+func readOnly(program string, args []string) bool {
+	switch program {
+	case "netlink":
+		return len(args) > 1 && args[0] == "route" && (args[1] == "get" || args[1] == "default" || args[1] == "defaults")
+	case "iw", "iperf3", "traceroute", "sysfs":
+		return true
+	case "wireguard":
+		return len(args) > 0 && args[0] == "show"
+	case "nm":
+		return len(args) > 0 && args[0] == "profile" || len(args) > 1 && (args[0] == "device" && (args[1] == "dns" || args[1] == "lease") || args[0] == "wifi" && args[1] == "scan")
+	case "resolved":
+		return len(args) > 0 && args[0] == "link"
+	case "mm":
+		return len(args) > 0 && (args[0] == "find" || args[0] == "signal")
+	case "conntrack":
+		return len(args) > 0 && args[0] == "-L"
+	case "ip":
+		return len(args) > 1 && args[0] == "link" && args[1] == "show"
+	case "sysctl":
+		return len(args) > 0 && args[0] == "-n"
+	}
+	return false
+}
+
+// skipDryRun logs and reports whether running program with args must be
+// skipped because it would change the system during a dry run.
+func (m *Manager) skipDryRun(program string, args []string) bool {
+	if !m.dryRun || readOnly(program, args) {
+		return false
+	}
+	var words []string
+	for _, arg := range args {
+		if arg != "" {
+			words = append(words, arg)
+		}
+	}
+	m.log.Warn().Msgf("Dry run, not running: %s %s", program, strings.Join(words, " "))
+	return true
+}
+
+// dryRunNote returns a note to append to the messages reporting changes
+// during a dry run.
+func (m *Manager) dryRunNote() string {
+	if !m.dryRun {
+		return ""
+	}
+	return " (dry run, nothing changed)"
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/echo"
+)
+
+// sourceEcho is the source of the up and down events made from the echo
+// sessions to a reflector.
+const sourceEcho = "echo"
+
+// echoWatch keeps a BFD-style echo session to a reflector, the responder
+// command, over each link: a request goes out every interval and the link is
+// reported down after multiplier intervals without a reply, or while the
+// jitter of its RTT is above maxJitter if set. It detects failures in a
+// fraction of a second, while the endpoint probes still decide from their
+// quorum whether a link works.
+type echoWatch struct {
+	m *Manager
+
+	address    string
+	interval   time.Duration
+	multiplier int
+	maxJitter  time.Duration
+	key        []byte
+	// events receives the changes of the sessions.
+	events chan dispatcher.Event
+}
+
+// watchEcho returns events, the dispatcher events if not nil, merged with
+// down and up events from the echo session of each link in links. links is
+// read every second, sessions starting and stopping as links come and go.
+func (w *echoWatch) watchEcho(events <-chan dispatcher.Event, links func() []string) <-chan dispatcher.Event {
+	merged := make(chan dispatcher.Event, 16)
+	w.events = merged
+	go func() {
+		sessions := map[string]chan struct{}{}
+		for ; ; time.Sleep(time.Second) {
+			current := map[string]bool{}
+			for _, ifname := range links() {
+				if ifname == "" {
+					continue
+				}
+				current[ifname] = true
+				if _, ok := sessions[ifname]; ok {
+					continue
+				}
+				done := make(chan struct{})
+				sessions[ifname] = done
+				go w.session(ifname).Run(done)
+			}
+			for ifname, done := range sessions {
+				if !current[ifname] {
+					close(done)
+					delete(sessions, ifname)
+				}
+			}
+		}
+	}()
+	if events != nil {
+		go func() {
+			for e := range events {
+				merged <- e
+			}
+		}()
+	}
+	return merged
+}
+
+// session returns the echo session of ifname, reporting its changes to the
+// watch events.
+func (w *echoWatch) session(ifname string) *echo.Session {
+	return &echo.Session{
+		Address:    w.address,
+		Interface:  ifname,
+		Key:        w.key,
+		Interval:   w.interval,
+		Multiplier: w.multiplier,
+		MaxJitter:  w.maxJitter,
+		Network:    "udp" + w.m.networkSuffix(),
+		Namespace:  w.m.namespace,
+		OnChange: func(up bool, s echo.Stats) {
+			if up {
+				w.m.log.Info().Msgf("Reflector %s answers again over %s, RTT %s, jitter %s", w.address, ifname, s.RTT, s.Jitter)
+				w.events <- dispatcher.Event{Interface: ifname, Action: dispatcher.ActionUp, Source: sourceEcho}
+				return
+			}
+			if w.maxJitter > 0 && s.Jitter > w.maxJitter {
+				w.m.log.Warn().Msgf("Jitter toward reflector %s over %s is %s, above %s, the path is down", w.address, ifname, s.Jitter, w.maxJitter)
+			} else {
+				w.m.log.Warn().Msgf("Reflector %s did not answer over %s for %s, the path is down (%.0f%% loss so far)", w.address, ifname, time.Duration(w.multiplier)*w.interval, s.Loss()*100)
+			}
+			w.events <- dispatcher.Event{Interface: ifname, Action: dispatcher.ActionDown, Source: sourceEcho}
+		},
+	}
+}
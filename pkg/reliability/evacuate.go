@@ -0,0 +1,162 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// Draining marks new flows with drainMark and routes them through drainTable
+// while the flows already established keep the current route.
+const (
+	drainMark  = 0x4d0000
+	drainTable = 77
+	drainPrio  = 7700
+)
+
+// PrimaryLink names the primary link in evacuation requests when it has no
+// interface of its own.
+const PrimaryLink = "primary"
+
+// acceptEvacuation reports whether an evacuation request for ifname targets
+// the active link, reached through primaryIF.
+func (m *Manager) acceptEvacuation(ifname, primaryIF string) bool {
+	if m.activeLink != PrimaryLink {
+		return false
+	}
+	return ifname == PrimaryLink || (ifname != "" && ifname == primaryIF)
+}
+
+// drain routes new flows toward cidrs through ifname via the router of their
+// address family, waits until the flows established over the current route
+// are gone or timeout elapses, then removes the drain setup. The caller
+// switches the main route. Networks of a family without a router are not
+// drained.
+func (m *Manager) drain(cidrs []string, ifname string, routers map[int]string, timeout time.Duration) {
+	mark := fmt.Sprintf("0x%x", drainMark)
+	table := strconv.Itoa(drainTable)
+	var setup, teardown [][]string
+	var drained []string
+	for _, family := range m.families() {
+		router, ok := routers[family]
+		if !ok {
+			continue
+		}
+		ip, iptables := "-4", "iptables"
+		if family == route.IPv6 {
+			ip, iptables = "-6", "ip6tables"
+		}
+		var rules [][]string
+		for _, cidr := range cidrs {
+			if cidrFamily(cidr) != family {
+				continue
+			}
+			drained = append(drained, cidr)
+			rules = append(rules,
+				[]string{"-d", cidr, "-m", "conntrack", "--ctstate", "NEW", "-j", "CONNMARK", "--set-mark", mark + "/" + mark},
+				[]string{"-d", cidr, "-j", "CONNMARK", "--restore-mark", "--nfmask", mark, "--ctmask", mark})
+			setup = append(setup, []string{"ip", ip, "route", "replace", cidr, "via", router, "dev", ifname, "table", table})
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		setup = append(setup, []string{"ip", ip, "rule", "add", "fwmark", mark + "/" + mark, "table", table, "priority", strconv.Itoa(drainPrio)})
+		teardown = append(teardown,
+			[]string{"ip", ip, "rule", "del", "priority", strconv.Itoa(drainPrio)},
+			[]string{"ip", ip, "route", "flush", "table", table})
+		for _, chain := range []string{"OUTPUT", "PREROUTING"} {
+			for i, rule := range rules {
+				setup = append(setup, append([]string{iptables, "-t", "mangle", "-I", chain, strconv.Itoa(i + 1)}, rule...))
+				teardown = append([][]string{append([]string{iptables, "-t", "mangle", "-D", chain}, rule...)}, teardown...)
+			}
+		}
+	}
+	if len(drained) == 0 {
+		return
+	}
+	cidrs = drained
+	defer func() {
+		for _, c := range teardown {
+			if output, err := m.run(c[0], c[1:]...); err != nil {
+				m.log.Error().Msgf("Failed to remove drain setup %s: %s, output: %s", strings.Join(c, " "), err, strings.TrimSpace(string(output)))
+			}
+		}
+		m.changeLog.Record(changes.Firewall, "removed", "drain marking toward %s", strings.Join(cidrs, ", "))
+	}()
+	for _, c := range setup {
+		if output, err := m.run(c[0], c[1:]...); err != nil {
+			m.log.Error().Msgf("Failed to set up draining, switching at once: %s, output: %s", err, strings.TrimSpace(string(output)))
+			return
+		}
+	}
+	m.changeLog.Record(changes.Firewall, "added", "drain marking toward %s, new flows via %s", strings.Join(cidrs, ", "), ifname)
+	m.log.Info().Msgf("New flows toward %s now use %s, waiting up to %s for established flows", strings.Join(cidrs, ", "), ifname, timeout)
+
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining, err := m.establishedFlows(networks)
+		if err != nil {
+			m.log.Warn().Msgf("Cannot count established flows, waiting for the full timeout: %s", err)
+			time.Sleep(time.Until(deadline))
+			return
+		}
+		if remaining == 0 {
+			m.log.Info().Msg("All established flows are gone")
+			return
+		}
+		if time.Now().After(deadline) {
+			m.log.Warn().Msgf("Drain timeout reached, %d established flows will be moved", remaining)
+			return
+		}
+		m.log.Debug().Msgf("%d established flows remaining", remaining)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// establishedFlows counts the established TCP flows toward networks that
+// were not marked as new since draining started.
+func (m *Manager) establishedFlows(networks []*net.IPNet) (int, error) {
+	var output []byte
+	for _, family := range m.families() {
+		name := "ipv4"
+		if family == route.IPv6 {
+			name = "ipv6"
+		}
+		listed, err := m.run("conntrack", "-L", "-f", name, "-p", "tcp", "--state", "ESTABLISHED", "--mark", "0/"+fmt.Sprintf("0x%x", drainMark))
+		if err != nil {
+			return 0, fmt.Errorf("%s, output: %s", err, strings.TrimSpace(string(listed)))
+		}
+		output = append(output, listed...)
+	}
+	count := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, field := range strings.Fields(line) {
+			if dst, ok := strings.CutPrefix(field, "dst="); ok {
+				if ip := net.ParseIP(dst); ip != nil {
+					for _, network := range networks {
+						if network.Contains(ip) {
+							count++
+							break
+						}
+					}
+				}
+				break
+			}
+		}
+	}
+	return count, nil
+}
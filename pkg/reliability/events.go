@@ -0,0 +1,34 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/shynuu/if-reliability/eventlog"
+)
+
+// logEvent appends e to the event log, if enabled.
+func (m *Manager) logEvent(e eventlog.Event) {
+	if e.OutageID == "" {
+		e.OutageID = m.outages.ID()
+	}
+	if err := m.eventLog.Write(e); err != nil {
+		m.log.Warn().Msgf("Cannot write to the event log: %s", err)
+	}
+}
+
+// eventHook is a zerolog.Hook appending the logged errors to the event log
+// and the events of its manager.
+type eventHook struct{ m *Manager }
+
+// Run implements zerolog.Hook.
+func (h eventHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level < zerolog.ErrorLevel || level >= zerolog.NoLevel {
+		return
+	}
+	if h.m.eventLog != nil {
+		h.m.logEvent(eventlog.Event{Kind: eventlog.KindError, Message: redact(msg)})
+	}
+	h.m.emit(Event{Kind: EventError, Err: redact(msg)})
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/endpoint"
+)
+
+// routeDevice returns the interface the route toward ip currently goes
+// through, or an empty string if it cannot be determined.
+func (m *Manager) routeDevice(ip string) string {
+	device, err := m.routing(func() (string, error) { return m.routeManager.Device(ip, m.vrf) }, "route", "get", ip, m.vrf)
+	if err != nil {
+		m.log.Warn().Msgf("Cannot find the route toward %s: %s", ip, err)
+		return ""
+	}
+	return device
+}
+
+// awaitRecovery probes the endpoints, bound to ifname, the interface of the
+// primary link, until --failback-successes rounds in a row passed the quorum
+// and the SLA thresholds and at least the failback hold, the minimum dwell
+// and the hold-down of the link elapsed since the failover. Any failed round restarts the count, so that a flapping link is
+// not failed back to. While the WiFi signal is below --min-rssi, a single
+// good round is enough, and while the primary link is avoided, over its
+// data cap or for a scheduled preference, it is not failed back to at all.
+// A reload of the endpoints or the detection restarts the count too.
+// It returns early once the manager stops.
+func (m *Manager) awaitRecovery(ifname string) {
+	since := time.Now()
+	suppressed := m.flaps.Suppressed(LinkKey(m.targets[0].Interface), since)
+	var targets []endpoint.Endpoint
+	var successes, streak int
+	var hold time.Duration
+	start := func() {
+		targets, successes = bindAll(m.targets, ifname), m.failbackSuccesses
+		hold = max(m.failbackHold, m.minDwell, suppressed)
+		m.log.Info().Msgf("Probing %s for recovery, failing back after %d consecutive successes and at least %s", endpointList(targets), successes, hold)
+	}
+	start()
+	defer m.endRepeated("recovery", "Flapping of the primary link")
+	for {
+		select {
+		case <-m.probeContext().Done():
+			return
+		case <-time.After(m.probeInterval):
+		case req := <-m.commands:
+			if m.acceptCommand(req) {
+				return
+			}
+			continue
+		}
+		if m.applyReload() {
+			streak = 0
+			start()
+		} else if successes != m.failbackSuccesses || hold != max(m.failbackHold, m.minDwell, suppressed) {
+			start()
+		}
+		round := m.probeRound(targets)
+		poor, misses := m.degraded(targets[0].Interface, round)
+		if round.Failed() || poor {
+			if streak > 0 && round.Failed() {
+				m.warnRepeated("recovery", "Primary link failed again after %d successes: %s", streak, round)
+			} else if streak > 0 {
+				m.warnRepeated("recovery", "Primary link below the SLA again after %d successes: %s", streak, misses)
+			}
+			streak = 0
+			continue
+		}
+		streak++
+		if avoid, why := m.avoided(targets[0].Interface); avoid {
+			if streak == successes {
+				m.log.Info().Msgf("Primary link healthy again, staying on the backup link: %s", why)
+			}
+			continue
+		}
+		if weak, signal := m.weakSignal(m.defaultIF); weak && !m.suspended() {
+			m.log.Warn().Msgf("Primary link answering and %s, failing back now", signal)
+			m.decide(targets[0].Interface, "answering while the %s, failing back", signal)
+			return
+		}
+		if streak >= successes && time.Since(since) >= hold && m.suspended() {
+			if streak == successes {
+				m.log.Warn().Msgf("Primary link healthy for %d consecutive probe rounds, automatic failback paused", streak)
+			}
+			continue
+		}
+		if streak >= successes && time.Since(since) >= hold {
+			m.log.Info().Msgf("Primary link healthy for %d consecutive probe rounds toward %s", streak, endpointList(targets))
+			m.decide(targets[0].Interface, "recovered after %d consecutive successes, failing back", streak)
+			return
+		}
+	}
+}
+
+// failBack removes the backup routes toward the moved networks so that
+// traffic follows the primary link again, and undoes the other failover
+// changes.
+func (m *Manager) failBack(networks []string, ifwifi string, chrony chronyPolicy, dns *dnsPolicy, fw *firewallPolicy, spare coldSpare) {
+	if m.routeMetrics.enabled() {
+		m.routeMetrics.demote(networks, ifwifi, !spare.enabled())
+	} else {
+		m.removeRoutes(networks, ifwifi)
+	}
+	m.restoreSysctls()
+	if chrony.enabled() {
+		chrony.restore()
+	}
+	if dns.enabled() {
+		dns.restore()
+	}
+	if fw.enabled() {
+		fw.restore()
+	}
+	if spare.enabled() {
+		if err := m.runNM(func(c WiFiManager) error { return c.Disconnect(ifwifi) }, "device", "disconnect", ifwifi); err != nil {
+			m.log.Error().Msgf("Error disconnecting %s: %s", ifwifi, err)
+		}
+		spare.park()
+	}
+	m.activeLink = PrimaryLink
+	m.evacuation = nil
+	m.restoreOnStop = nil
+	m.conntrackFlush.left(ifwifi)
+	m.logTransition(ifwifi, "primary")
+}
@@ -0,0 +1,544 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package fake provides in-memory backends of the reliability manager, to
+// run it against simulated links: a routing table, a WiFi manager adding a
+// default route on connection, a pinger answering over the links that are
+// up, and a command runner recording the programs run.
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/nm"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// ErrNoRoute is returned when deleting a route that is not installed.
+var ErrNoRoute = errors.New("no such route")
+
+// gatewayKey identifies the default route of an interface and family.
+type gatewayKey struct {
+	ifname string
+	family int
+}
+
+// Routes is an in-memory routing table: the routes installed through it, the
+// default gateways of the interfaces, and the interface of the default route,
+// along with the rules and addresses added through it.
+type Routes struct {
+	mu        sync.Mutex
+	defaultIF string
+	gateways  map[gatewayKey]string
+	routes    map[string]route.Route
+	// hops are the next hops of the multipath routes, by destination.
+	hops      map[string][]route.Nexthop
+	rules     []route.Rule
+	addresses map[string][]string
+	// watchers are notified of the changes of the routes.
+	watchers []chan route.Change
+	// replaceErr fails the route replacements if not nil.
+	replaceErr error
+}
+
+// NewRoutes returns a table whose default route goes through defaultIF.
+func NewRoutes(defaultIF string) *Routes {
+	return &Routes{
+		defaultIF: defaultIF,
+		gateways:  map[gatewayKey]string{},
+		routes:    map[string]route.Route{},
+		hops:      map[string][]route.Nexthop{},
+		addresses: map[string][]string{},
+	}
+}
+
+// SetGateway sets the default gateway of family through ifname, none if
+// empty.
+func (t *Routes) SetGateway(ifname string, family int, gateway string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gateway == "" {
+		delete(t.gateways, gatewayKey{ifname, family})
+		return
+	}
+	t.gateways[gatewayKey{ifname, family}] = gateway
+}
+
+// FailReplace makes the route replacements fail with err, or succeed if nil.
+func (t *Routes) FailReplace(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.replaceErr = err
+}
+
+// Route returns the route toward dst installed through the table.
+func (t *Routes) Route(dst string) (route.Route, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[dst]
+	return r, ok
+}
+
+// Len returns the number of routes installed through the table.
+func (t *Routes) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.routes)
+}
+
+// Remove removes the route toward dst like another program would, notifying
+// the watchers, and reports whether there was one.
+func (t *Routes) Remove(dst string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[dst]
+	if ok {
+		t.notify(route.Change{Route: r, Hops: t.hops[dst], Deleted: true})
+		t.remove(dst)
+	}
+	return ok
+}
+
+// Rules returns the rules added through the table.
+func (t *Routes) Rules() []route.Rule {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]route.Rule(nil), t.rules...)
+}
+
+// Addresses returns the addresses added to ifname through the table.
+func (t *Routes) Addresses(ifname string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.addresses[ifname]...)
+}
+
+// remove removes the route toward dst.
+func (t *Routes) remove(dst string) {
+	delete(t.routes, dst)
+	delete(t.hops, dst)
+}
+
+// notify sends c to the watchers, dropping it for those lagging behind.
+func (t *Routes) notify(c route.Change) {
+	for _, w := range t.watchers {
+		select {
+		case w <- c:
+		default:
+		}
+	}
+}
+
+// Device implements reliability.RouteManager: it returns the interface of
+// the installed route with the longest prefix matching ip, or of the default
+// route. There is a single table, whatever the VRF.
+func (t *Routes) Device(ip, vrf string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	device, longest := t.defaultIF, -1
+	for dst, r := range t.routes {
+		_, network, err := net.ParseCIDR(dst)
+		if err != nil || !network.Contains(addr) {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones > longest {
+			device, longest = r.Device, ones
+		}
+	}
+	if device == "" {
+		return "", fmt.Errorf("no route toward %s", ip)
+	}
+	return device, nil
+}
+
+// DefaultGateway implements reliability.RouteManager.
+func (t *Routes) DefaultGateway(ifname, vrf string, family int) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gateways[gatewayKey{ifname, family}], nil
+}
+
+// Defaults implements reliability.RouteManager: the default gateways of the
+// interfaces are the default routes.
+func (t *Routes) Defaults(vrf string, family int) ([]route.Route, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var defaults []route.Route
+	for key, gateway := range t.gateways {
+		if key.family == family {
+			defaults = append(defaults, route.Route{Dst: defaultDst(family), Gateway: gateway, Device: key.ifname})
+		}
+	}
+	return defaults, nil
+}
+
+// defaultDst returns the destination of the default route of family.
+func defaultDst(family int) string {
+	if family == route.IPv6 {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+// Replace implements reliability.RouteManager.
+func (t *Routes) Replace(r route.Route) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.replaceErr != nil {
+		return t.replaceErr
+	}
+	t.routes[r.Dst] = r
+	delete(t.hops, r.Dst)
+	t.notify(route.Change{Route: r})
+	return nil
+}
+
+// Delete implements reliability.RouteManager, removing the default gateway
+// of ifname for a default route.
+func (t *Routes) Delete(dst, ifname, vrf string, table int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, family := range []int{route.IPv4, route.IPv6} {
+		if key := (gatewayKey{ifname, family}); dst == defaultDst(family) && t.gateways[key] != "" {
+			delete(t.gateways, key)
+			return nil
+		}
+	}
+	r, ok := t.routes[dst]
+	if !ok || r.Device != ifname {
+		return fmt.Errorf("%s dev %s: %w", dst, ifname, ErrNoRoute)
+	}
+	t.remove(dst)
+	t.notify(route.Change{Route: r, Deleted: true})
+	return nil
+}
+
+// DeleteMetric implements reliability.RouteManager.
+func (t *Routes) DeleteMetric(dst, ifname, vrf string, table, metric int) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[dst]
+	if !ok || r.Device != ifname || r.Metric != metric {
+		return false, nil
+	}
+	t.remove(dst)
+	t.notify(route.Change{Route: r, Deleted: true})
+	return true, nil
+}
+
+// ReplaceMultipath implements reliability.RouteManager: the route goes
+// through the interface of the first hop.
+func (t *Routes) ReplaceMultipath(r route.Route, hops []route.Nexthop) error {
+	if len(hops) == 0 {
+		return errors.New("no next hop")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.replaceErr != nil {
+		return t.replaceErr
+	}
+	r.Device = hops[0].Device
+	t.routes[r.Dst] = r
+	t.hops[r.Dst] = append([]route.Nexthop(nil), hops...)
+	t.notify(route.Change{Route: route.Route{Dst: r.Dst, Metric: r.Metric, Protocol: r.Protocol}, Hops: hops})
+	return nil
+}
+
+// DeleteMultipath implements reliability.RouteManager.
+func (t *Routes) DeleteMultipath(dst, vrf string, table, metric, protocol int) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.routes[dst]
+	hops := t.hops[dst]
+	if !ok || len(hops) == 0 || r.Metric != metric {
+		return false, nil
+	}
+	t.remove(dst)
+	t.notify(route.Change{Route: route.Route{Dst: dst, Metric: metric, Protocol: protocol}, Hops: hops, Deleted: true})
+	return true, nil
+}
+
+// AddRule implements reliability.RouteManager.
+func (t *Routes) AddRule(r route.Rule) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = append(t.rules, r)
+	return nil
+}
+
+// DeleteRules implements reliability.RouteManager.
+func (t *Routes) DeleteRules(family, priority int) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kept, n := t.rules[:0], 0
+	for _, r := range t.rules {
+		if r.Family == family && r.Priority == priority {
+			n++
+			continue
+		}
+		kept = append(kept, r)
+	}
+	t.rules = kept
+	return n, nil
+}
+
+// AddAddress implements reliability.RouteManager.
+func (t *Routes) AddAddress(ifname, addr string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, a := range t.addresses[ifname] {
+		if a == addr {
+			return fmt.Errorf("address %s already on %s", addr, ifname)
+		}
+	}
+	t.addresses[ifname] = append(t.addresses[ifname], addr)
+	return nil
+}
+
+// DeleteAddress implements reliability.RouteManager.
+func (t *Routes) DeleteAddress(ifname, addr string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, a := range t.addresses[ifname] {
+		if a == addr {
+			t.addresses[ifname] = append(t.addresses[ifname][:i], t.addresses[ifname][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Watch implements reliability.RouteManager, reporting the changes of the
+// routes made through the table, whatever the VRF and table.
+func (t *Routes) Watch(vrf string, table int, done <-chan struct{}) (<-chan route.Change, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := make(chan route.Change, 64)
+	t.watchers = append(t.watchers, w)
+	if done != nil {
+		go func() {
+			<-done
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			for i, other := range t.watchers {
+				if other == w {
+					t.watchers = append(t.watchers[:i], t.watchers[i+1:]...)
+					break
+				}
+			}
+			close(w)
+		}()
+	}
+	return w, nil
+}
+
+// WiFi connects WiFi interfaces instantly, setting their default gateway in
+// a routing table, after failing a given number of times.
+type WiFi struct {
+	mu        sync.Mutex
+	routes    *Routes
+	gateway   string
+	failures  int
+	connected map[string]string
+	attempts  int
+}
+
+// NewWiFi returns a WiFi manager setting the IPv4 default gateway of the
+// interfaces it connects to gateway in routes.
+func NewWiFi(routes *Routes, gateway string) *WiFi {
+	return &WiFi{routes: routes, gateway: gateway, connected: map[string]string{}}
+}
+
+// Fail makes the next n connections fail.
+func (w *WiFi) Fail(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failures = n
+}
+
+// Connected returns the SSID ifname is connected to, or an empty string.
+func (w *WiFi) Connected(ifname string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.connected[ifname]
+}
+
+// Attempts returns the number of connections made or attempted.
+func (w *WiFi) Attempts() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.attempts
+}
+
+// ConnectWiFi implements reliability.WiFiManager.
+func (w *WiFi) ConnectWiFi(ifname, ssid, bssid, password string, eap *wifi.Enterprise, timeout time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts++
+	if w.failures > 0 {
+		w.failures--
+		return fmt.Errorf("connecting %s to %s: simulated failure", ifname, ssid)
+	}
+	w.connected[ifname] = ssid
+	w.routes.SetGateway(ifname, route.IPv4, w.gateway)
+	return nil
+}
+
+// ConnectDevice implements reliability.WiFiManager, connecting ifname like
+// a network named after it.
+func (w *WiFi) ConnectDevice(ifname string, timeout time.Duration) error {
+	return w.ConnectWiFi(ifname, ifname, "", "", nil, timeout)
+}
+
+// Disconnect implements reliability.WiFiManager, removing the default
+// gateway of ifname.
+func (w *WiFi) Disconnect(ifname string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.connected, ifname)
+	w.routes.SetGateway(ifname, route.IPv4, "")
+	return nil
+}
+
+// Scan implements reliability.WiFiManager, finding no access point.
+func (w *WiFi) Scan(ifname string, wait time.Duration) ([]nm.AccessPoint, error) {
+	return nil, nil
+}
+
+// Lease implements reliability.WiFiManager: the interfaces have no DHCP
+// lease, and Renew does nothing.
+func (w *WiFi) Lease(ifname string) (*nm.Lease, error) {
+	return nil, nil
+}
+
+// Renew implements reliability.WiFiManager.
+func (w *WiFi) Renew(ifname string, timeout time.Duration) error {
+	return nil
+}
+
+// Nameservers implements reliability.WiFiManager, returning none.
+func (w *WiFi) Nameservers(ifname string) ([]string, error) {
+	return nil, nil
+}
+
+// Profile implements reliability.WiFiManager: there is no connection
+// profile.
+func (w *WiFi) Profile(ssid string) (string, error) {
+	return "", nil
+}
+
+// Pinger answers the probes sent over the interfaces that are up: the one
+// they are bound to, or else the one the routing table routes them through.
+type Pinger struct {
+	mu     sync.Mutex
+	routes *Routes
+	rtt    time.Duration
+	down   map[string]bool
+	lost   map[string]bool
+	probes map[string]int
+	// patterns are the answers of the probes over the interfaces flapping,
+	// in turn.
+	patterns map[string][]bool
+}
+
+// NewPinger returns a pinger routing the unbound probes with routes and
+// answering them after rtt.
+func NewPinger(routes *Routes, rtt time.Duration) *Pinger {
+	return &Pinger{routes: routes, rtt: rtt, down: map[string]bool{}, lost: map[string]bool{}, probes: map[string]int{}, patterns: map[string][]bool{}}
+}
+
+// SetDown takes the link of ifname down, or brings it back up.
+func (p *Pinger) SetDown(ifname string, down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down[ifname] = down
+}
+
+// Flap makes the probes over ifname answer in turn as answers tells, over
+// and over, or as the link state tells again if empty.
+func (p *Pinger) Flap(ifname string, answers ...bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.patterns[ifname] = answers
+	p.probes[ifname] = 0
+}
+
+// SetLost makes address stop answering over every link, or answer again.
+func (p *Pinger) SetLost(address string, lost bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lost[address] = lost
+}
+
+// Probes returns the number of probes sent over ifname.
+func (p *Pinger) Probes(ifname string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.probes[ifname]
+}
+
+// Probe implements reliability.Pinger.
+func (p *Pinger) Probe(ctx context.Context, address string, ifname string) probe.Result {
+	if err := ctx.Err(); err != nil {
+		return probe.Failed(err)
+	}
+	if ifname == "" {
+		device, err := p.routes.Device(address, "")
+		if err != nil {
+			return probe.Failed(probe.ErrUnreachable)
+		}
+		ifname = device
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.probes[ifname]
+	p.probes[ifname]++
+	down := p.down[ifname]
+	if pattern := p.patterns[ifname]; len(pattern) > 0 {
+		down = !pattern[n%len(pattern)]
+	}
+	if down || p.lost[address] {
+		return probe.Failed(probe.ErrTimeout)
+	}
+	return probe.Result{RTT: p.rtt}
+}
+
+// Commands records the programs run instead of running them: they succeed
+// without output.
+type Commands struct {
+	mu   sync.Mutex
+	runs []string
+}
+
+// NewCommands returns a runner running nothing.
+func NewCommands() *Commands {
+	return &Commands{}
+}
+
+// Runs returns the command lines run, in order.
+func (c *Commands) Runs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.runs...)
+}
+
+// Run implements reliability.CommandRunner.
+func (c *Commands) Run(name string, args ...string) ([]byte, error) {
+	commandLine := strings.Join(append([]string{name}, args...), " ")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs = append(c.runs, commandLine)
+	return nil, nil
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// Address family modes.
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+	familyDual = "dual"
+)
+
+// setPrefixes parses the networks moved on failover, each in CIDR notation
+// optionally followed by "@" and the metric of its route, e.g.
+// "0.0.0.0/0@50".
+func (m *Manager) setPrefixes(texts []string) error {
+	for _, text := range texts {
+		cidr, metricText, hasMetric := strings.Cut(text, "@")
+		ip, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %q: %w", text, err)
+		}
+		if !ip.Equal(network.IP) {
+			return fmt.Errorf("invalid prefix %q: host bits set, did you mean %s?", text, network)
+		}
+		if !m.familyEnabled(familyOf(ip)) {
+			return fmt.Errorf("%s is an %s prefix, use --ip-family %s or %s", network, familyName(familyOf(ip)), familyIPv6, familyDual)
+		}
+		metric := 0
+		if hasMetric {
+			if metric, err = strconv.Atoi(metricText); err != nil || metric < 0 {
+				return fmt.Errorf("invalid metric in prefix %q", text)
+			}
+		}
+		cidr = network.String()
+		if _, seen := m.prefixMetrics[cidr]; seen {
+			return fmt.Errorf("prefix %s given twice", cidr)
+		}
+		m.routePrefixes = append(m.routePrefixes, cidr)
+		m.prefixMetrics[cidr] = metric
+	}
+	return nil
+}
+
+// movedNetworks returns the networks moved on failover: the configured
+// prefixes, or else the endpoint networks of targets.
+func (m *Manager) movedNetworks(targets []endpoint.Endpoint) []string {
+	if len(m.routePrefixes) > 0 {
+		return m.routePrefixes
+	}
+	return m.endpointNetworks(targets)
+}
+
+// setFamily sets the address family mode.
+func (m *Manager) setFamily(mode string) error {
+	switch mode {
+	case familyIPv4, familyIPv6, familyDual:
+		m.ipFamily = mode
+		return nil
+	}
+	return fmt.Errorf("invalid IP family %q: expected ipv4, ipv6 or dual", mode)
+}
+
+// families returns the address families enabled by the mode, the preferred
+// one first.
+func (m *Manager) families() []int {
+	switch m.ipFamily {
+	case familyIPv6:
+		return []int{route.IPv6}
+	case familyDual:
+		return []int{route.IPv4, route.IPv6}
+	}
+	return []int{route.IPv4}
+}
+
+// familyEnabled reports whether family is enabled by the mode.
+func (m *Manager) familyEnabled(family int) bool {
+	for _, f := range m.families() {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// familyOf returns the address family of ip.
+func familyOf(ip net.IP) int {
+	if ip.To4() != nil {
+		return route.IPv4
+	}
+	return route.IPv6
+}
+
+// familyName returns the name of family, e.g. "IPv6".
+func familyName(family int) string {
+	return fmt.Sprintf("IPv%d", family)
+}
+
+// networkSuffix returns the suffix restricting Go networks, e.g. "tcp4", to
+// the enabled families, empty for both.
+func (m *Manager) networkSuffix() string {
+	switch m.ipFamily {
+	case familyIPv4:
+		return "4"
+	case familyIPv6:
+		return "6"
+	}
+	return ""
+}
+
+// checkFamilies fails if an endpoint is an IP address of a family the mode
+// does not enable.
+func (m *Manager) checkFamilies(targets []endpoint.Endpoint) error {
+	for _, target := range targets {
+		if ip := net.ParseIP(target.Host); ip != nil && !m.familyEnabled(familyOf(ip)) {
+			return fmt.Errorf("%s is an %s endpoint, use --ip-family %s or %s", target, familyName(familyOf(ip)), familyIPv6, familyDual)
+		}
+	}
+	return nil
+}
+
+// networkCIDR returns the network of ip with the given prefix length in CIDR
+// notation.
+func networkCIDR(ip string, prefix int) string {
+	parsed := net.ParseIP(ip)
+	bits := 32
+	if familyOf(parsed) == route.IPv6 {
+		bits = 128
+	} else {
+		parsed = parsed.To4()
+	}
+	return fmt.Sprintf("%s/%d", parsed.Mask(net.CIDRMask(prefix, bits)), prefix)
+}
+
+// cidrFamily returns the address family of a network in CIDR notation.
+func cidrFamily(cidr string) int {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return route.IPv4
+	}
+	return familyOf(ip)
+}
+
+// endpointNetworks returns the networks of targets moved on failover, once
+// each: the network of their address, or of every address of the enabled
+// families their host name resolves to.
+func (m *Manager) endpointNetworks(targets []endpoint.Endpoint) []string {
+	var networks []string
+	seen := map[string]bool{}
+	for _, target := range targets {
+		ips := []net.IP{net.ParseIP(target.Host)}
+		if ips[0] == nil {
+			resolved, err := net.LookupIP(target.Host)
+			if err != nil {
+				m.log.Error().Msgf("Cannot resolve %s, its network will not be moved on failover: %s", target.Host, err)
+				continue
+			}
+			ips = resolved
+		}
+		for _, ip := range ips {
+			family := familyOf(ip)
+			if !m.familyEnabled(family) {
+				continue
+			}
+			cidr := networkCIDR(ip.String(), m.prefixLen[family])
+			if !seen[cidr] {
+				seen[cidr] = true
+				networks = append(networks, cidr)
+			}
+		}
+	}
+	return networks
+}
+
+// defaultRouters returns the default routers of ifname per enabled address
+// family, leaving out the families it has none for.
+func (m *Manager) defaultRouters(ifname string) (map[int]string, error) {
+	routers := map[int]string{}
+	for _, family := range m.families() {
+		router, err := m.defaultRouterOf(ifname, family)
+		if err != nil {
+			return nil, err
+		}
+		if router != "" {
+			routers[family] = router
+		}
+	}
+	return routers, nil
+}
+
+// routeNetworks routes networks through ifname via the router of their
+// family with metric, or the metric of each prefix if 0, returning the
+// networks moved and reporting the ones that could not be.
+func (m *Manager) routeNetworks(networks []string, ifname string, routers map[int]string, metric int) ([]string, error) {
+	var moved []string
+	var errs []error
+	for _, cidr := range networks {
+		router, ok := routers[cidrFamily(cidr)]
+		if !ok {
+			m.log.Error().Msgf("No %s default router on %s, the route toward %s is not moved", familyName(cidrFamily(cidr)), ifname, cidr)
+			errs = append(errs, fmt.Errorf("no %s default router for %s", familyName(cidrFamily(cidr)), cidr))
+			continue
+		}
+		routeMetric := metric
+		if routeMetric == 0 {
+			routeMetric = m.prefixMetrics[cidr]
+		}
+		if err := m.replaceRoute(cidr, ifname, router, routeMetric); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		moved = append(moved, cidr)
+	}
+	return moved, errors.Join(errs...)
+}
+
+// routeSet routes the configured prefixes through ifname with metric as
+// routeNetworks, as a set: if one cannot be moved, the ones that were are
+// removed again. Endpoint networks are moved one by one, as many as possible.
+func (m *Manager) routeSet(networks []string, ifname string, routers map[int]string, metric int) error {
+	moved, err := m.routeNetworks(networks, ifname, routers, metric)
+	if err == nil || len(m.routePrefixes) == 0 {
+		return nil
+	}
+	m.log.Error().Msgf("Not all the prefixes could be routed through %s, removing the %d moved", ifname, len(moved))
+	for _, cidr := range moved {
+		routeMetric := metric
+		if routeMetric == 0 {
+			routeMetric = m.prefixMetrics[cidr]
+		}
+		m.removeRoute(cidr, ifname, routeMetric)
+	}
+	return err
+}
+
+// removeRoute removes the route toward cidr through ifname with metric, if
+// there is one.
+func (m *Manager) removeRoute(cidr, ifname string, metric int) {
+	args := m.routingPolicy.routeArgs("route", "del", cidr, ifname, m.vrf, "metric", strconv.Itoa(metric))
+	m.routeOwner.disown(cidr, ifname, metric)
+	output, err := m.routing(func() (string, error) {
+		removed, err := m.routeManager.DeleteMetric(cidr, ifname, m.vrf, m.routingPolicy.table, metric)
+		return strconv.FormatBool(removed), err
+	}, args...)
+	if err != nil {
+		m.log.Error().Msgf("Failed to remove the route toward %s via %s metric %d: %s", cidr, ifname, metric, err)
+		return
+	}
+	if output != "true" {
+		return
+	}
+	m.changeLog.Record(changes.Route, "removed", "%s dev %s metric %d", cidr, ifname, metric)
+}
+
+// removeRoutes removes the routes toward networks through ifname.
+func (m *Manager) removeRoutes(networks []string, ifname string) {
+	for _, cidr := range networks {
+		m.routeOwner.disown(cidr, ifname, -1)
+		_, err := m.routing(func() (string, error) { return "", m.routeManager.Delete(cidr, ifname, m.vrf, m.routingPolicy.table) }, m.routingPolicy.routeArgs("route", "del", cidr, ifname, m.vrf)...)
+		if err != nil {
+			m.log.Error().Msgf("Failed to remove the route toward %s via %s: %s", cidr, ifname, err)
+		} else {
+			m.changeLog.Record(changes.Route, "removed", "%s dev %s", cidr, ifname)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/firewall"
+)
+
+// firewallPolicy keeps the firewall rules following the egress interface,
+// e.g. the masquerading of the LAN and the fwmark rules, on the link carrying
+// the traffic. It is disabled without rules.
+type firewallPolicy struct {
+	m *Manager
+
+	rules []firewall.Rule
+	// egress is the interface the rules are installed for, primary the
+	// primary link's, and installed the commands deleting them.
+	egress    string
+	primary   string
+	installed [][]string
+}
+
+// newFirewallPolicy parses the rule templates.
+func (m *Manager) newFirewallPolicy(templates []string) (*firewallPolicy, error) {
+	p := &firewallPolicy{m: m}
+	for _, text := range templates {
+		rule, err := firewall.Parse(text)
+		if err != nil {
+			return p, fmt.Errorf("invalid firewall rule %q: %w", text, err)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// enabled reports whether firewall rules are managed.
+func (p *firewallPolicy) enabled() bool {
+	return len(p.rules) > 0
+}
+
+// primaryLink installs the rules for ifname, the interface of the primary
+// link, unless they already are.
+func (p *firewallPolicy) primaryLink(ifname string) {
+	p.primary = ifname
+	if ifname != "" && ifname != p.egress {
+		p.apply(ifname)
+	}
+}
+
+// failover moves the rules to ifwifi.
+func (p *firewallPolicy) failover(ifwifi string) {
+	p.apply(ifwifi)
+}
+
+// restore moves the rules back to the primary link. They stay installed on
+// exit, following the primary link.
+func (p *firewallPolicy) restore() {
+	if p.primary != "" {
+		p.apply(p.primary)
+	}
+}
+
+// apply deletes the installed rules and adds the rules for ifname. A rule
+// that cannot be added is logged and the other ones are still added.
+func (p *firewallPolicy) apply(ifname string) {
+	for _, command := range p.installed {
+		if output, err := p.m.run(command[0], command[1:]...); err != nil {
+			p.m.log.Error().Msgf("Failed to delete the firewall rule: %s: %s, output: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+		}
+	}
+	if len(p.installed) > 0 {
+		p.m.changeLog.Record(changes.Firewall, "removed", "%d rules for %s", len(p.installed), p.egress)
+	}
+	p.installed = nil
+	egress := firewall.Egress{Interface: ifname, Previous: p.egress}
+	for _, rule := range p.rules {
+		command, err := rule.Command(egress)
+		if err != nil {
+			p.m.log.Error().Msgf("Cannot render the firewall rule %q: %s", rule, err)
+			continue
+		}
+		// A rule left by a previous run is not added twice.
+		if deletion, err := firewall.Undo(command, ""); err == nil {
+			p.m.run(deletion[0], deletion[1:]...)
+		}
+		output, err := p.m.run(command[0], command[1:]...)
+		if err != nil {
+			p.m.log.Error().Msgf("Failed to add the firewall rule: %s: %s, output: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+			continue
+		}
+		deletion, err := firewall.Undo(command, string(output))
+		if err != nil {
+			p.m.log.Warn().Msgf("The firewall rule %s cannot be deleted on the next switch: %s", strings.Join(command, " "), err)
+			continue
+		}
+		p.installed = append(p.installed, deletion)
+	}
+	p.m.changeLog.Record(changes.Firewall, "added", "%d rules for %s", len(p.rules), ifname)
+	p.m.log.Info().Msgf("Firewall rules now follow %s", ifname)
+	p.egress = ifname
+}
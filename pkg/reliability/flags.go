@@ -0,0 +1,258 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/detect"
+	"github.com/shynuu/if-reliability/persist"
+	"github.com/shynuu/if-reliability/portal"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/spf13/pflag"
+)
+
+// AddFlags defines the flags of the monitor in fs, shared by the root and
+// monitor commands, bound to the fields of c, which take their defaults.
+// Only --wifi-if is always required: the WiFi SSID and password are required
+// unless the backup interface is wired, and the endpoints are discovered
+// from the primary link when none is given.
+func AddFlags(fs *pflag.FlagSet, c *Config) {
+	fs.StringVar(&c.ConfigFile, "config", "", "YAML or TOML file holding the settings, keyed by flag name (flags and environment variables take precedence)")
+	fs.StringVar(&c.Group, "group", "", "Group of the configuration file to monitor, all of them in one process if empty")
+	fs.StringVarP(&c.WiFiInterface, "wifi-if", "w", "", "WiFi interface (required), or wired backup interface with --interface-type")
+	fs.StringVarP(&c.WiFiSSID, "wifi-ssid", "s", "", "WiFi SSID (required unless --wifi-if is of type ethernet)")
+	fs.StringVarP(&c.WiFiPassword, "wifi-password", "p", "", "WiFi password (required unless given by --wifi-password-file or the wifi-password systemd credential, or --wifi-if is of type ethernet)")
+	fs.StringSliceVar(&c.InterfaceTypes, "interface-type", nil, "Type of an interface as ifname=type: wifi, associated to --wifi-ssid, or ethernet, only waited for carrier and DHCP (default wifi)")
+	fs.IntVar(&c.MinLinkSpeed, "min-link-speed", 0, "Speed in Mbit/s a wired interface must have negotiated to be failed over to, left as soon as the primary link answers below it (disabled if 0)")
+	fs.StringVar(&c.WiFiPasswordFile, "wifi-password-file", "", "File holding the WiFi password, kept out of the process list")
+	fs.StringSliceVarP(&c.Endpoints, "endpoint", "e", nil, "Probe server endpoint, may be repeated (default: discovered from the primary link)")
+	fs.StringVar(&c.EndpointRecords, "endpoint-records", recordsRotate, "How the addresses of an endpoint host name are probed: rotate, one after the other, or all, each time, the probe succeeding if one answers")
+	fs.DurationVar(&c.EndpointMinTTL, "endpoint-min-ttl", 30*time.Second, "Least time the addresses of an endpoint host name are kept before resolving it again, also the retry interval when its DNS servers do not answer")
+	fs.DurationVar(&c.EndpointMaxTTL, "endpoint-max-ttl", time.Hour, "Longest time the addresses of an endpoint host name are kept, whatever the TTL of its records")
+	fs.StringSliceVar(&c.AnycastEndpoints, "anycast-endpoint", anycastEndpoints, "Public anycast addresses probed along with the discovered DNS servers when no --endpoint is given")
+	fs.IntVar(&c.Quorum, "quorum", 0, "Number of endpoints that must fail at once for the link to be considered down (default: more than half)")
+	fs.DurationVar(&c.MaxRTT, "max-rtt", 0, "Highest mean RTT accepted over the SLA window before the link is considered degraded (disabled if 0)")
+	fs.Float64Var(&c.MaxLoss, "max-loss", 0, "Highest probe loss in percent accepted over the SLA window before the link is considered degraded (disabled if 0)")
+	fs.DurationVar(&c.MaxJitter, "max-jitter", 0, "Highest mean RTT variation accepted over the SLA window before the link is considered degraded (disabled if 0)")
+	fs.IntVar(&c.SLAWindow, "sla-window", 30, "Number of probes per endpoint the SLA thresholds are judged over")
+	fs.DurationSliceVar(&c.AvailabilityPeriods, "availability-periods", []time.Duration{time.Hour, 24 * time.Hour}, "Periods the availability of the links is reported over, aligned on UTC (disabled if empty)")
+	fs.StringVar(&c.AvailabilityReport, "availability-report", "", "File the availability report of each ended period is appended to, one JSON object per line (only logged if empty)")
+	fs.StringSliceVar(&c.VerifyEndpoints, "verify-endpoint", nil, "Endpoint used to verify connectivity after failover, may be repeated (default: the probe endpoint)")
+	fs.IntVar(&c.VerifyAttempts, "verify-attempts", 3, "Ping attempts per verification endpoint")
+	fs.DurationVar(&c.VerifyGrace, "verify-grace", 0, "Time the probes have to get through the routes moved to a new link before they are rolled back and the next link is tried (not verified if 0)")
+	fs.StringSliceVar(&c.Interfaces, "interfaces", nil, "Interfaces in priority order, the first one being the primary link: traffic goes through the highest-priority healthy one")
+	fs.BoolVar(&c.LoadBalance, "load-balance", false, "Spread the traffic over all the healthy --interfaces with multipath routes instead of switching between them")
+	fs.StringArrayVar(&c.Preferences, "prefer", nil, "Link preferred even while the others are healthy during a window, as interface@window, e.g. wlan0@mon-fri 08:00-18:00, may be repeated")
+	fs.StringArrayVar(&c.MaintenanceWindows, "maintenance-window", nil, "Window without automatic failover or failback, e.g. sun 02:00-04:00 or 2024-12-24 22:00-06:00, may be repeated")
+	fs.BoolVar(&c.BestPath, "best-path", false, "Route through the healthy --interfaces with the best path score, from RTT, loss, jitter and signal, instead of the highest-priority one")
+	fs.Float64Var(&c.BestPathMargin, "best-path-margin", 10, "Score points another interface must beat the active one by before --best-path switches to it")
+	fs.StringSliceVar(&c.Weights, "weight", nil, "Share of the flows an interface takes with --load-balance, as ifname=weight from 1 to 256 (default 1)")
+	fs.IntVarP(&c.Retry, "retry", "r", DefaultRetry, "Retry count before switching to WiFi (default: 5)")
+	fs.StringVar(&c.Detection, "detection", detect.Consecutive, "Algorithm declaring the link down from the probe rounds: consecutive (--retry failed rounds in a row), window (loss over the last rounds) or ewma (moving averages of the loss and RTT)")
+	fs.IntVar(&c.DetectionWindow, "detection-window", 10, "Number of probe rounds the window detection judges the loss over")
+	fs.Float64Var(&c.DetectionLoss, "detection-loss", 50, "Share of failed probe rounds, in percent, at which the window and ewma detections declare the link down")
+	fs.Float64Var(&c.DetectionAlpha, "detection-alpha", 0.3, "Weight of the last probe round in the moving averages of the ewma detection, between 0 and 1: the higher, the faster and the noisier")
+	fs.DurationVar(&c.DetectionMaxRTT, "detection-max-rtt", 0, "Average RTT above which the ewma detection declares the link down (disabled if 0)")
+	fs.IntVar(&c.HistorySize, "history-size", 3600, "Number of probe samples kept in memory")
+	fs.StringVar(&c.HistorySnapshot, "history-snapshot", "", "File the in-memory history is periodically saved to (disabled if empty)")
+	fs.StringVar(&c.HistoryDir, "history-dir", "", "Directory the probe history and the state transitions are archived to, one file per day, for the history command (disabled if empty), e.g. "+DefaultHistoryDir)
+	fs.DurationVar(&c.HistoryRetention, "history-retention", 30*24*time.Hour, "Age past which the days of the history archive are deleted (never if 0)")
+	fs.StringVar(&c.HistoryMaxSize, "history-max-size", "100MB", "Size past which the oldest days of the history archive are deleted (never if empty)")
+	fs.DurationVar(&c.FlushInterval, "flush-interval", time.Minute, "Maximum time persisted data is kept in memory before being written")
+	fs.StringVar(&c.Fsync, "fsync", persist.FsyncNever, "Fsync policy for persisted data: always or never")
+	fs.StringVar(&c.StateFile, "state-file", "/var/lib/if-reliability/state.json", "File holding the state kept across restarts (disabled if empty)")
+	fs.BoolVar(&c.Failback, "failback", false, "Keep probing over the primary link after failover and switch back once it recovered")
+	fs.IntVar(&c.FailbackSuccesses, "failback-successes", DefaultFailbackSuccesses, "Consecutive successful probes over the primary link required before failing back")
+	fs.DurationVar(&c.FailbackHold, "failback-hold", time.Minute, "Minimum time spent on the backup link before failing back")
+	fs.DurationVar(&c.HoldDown, "hold-down", 30*time.Second, "Time a failed link is kept out of use, doubled for each recent failure (disabled if 0)")
+	fs.DurationVar(&c.HoldDownMax, "hold-down-max", time.Hour, "Longest hold-down of a link failing repeatedly")
+	fs.DurationVar(&c.HoldDownHalfLife, "hold-down-half-life", 15*time.Minute, "Time after which a failure weighs half as much in the hold-down of a link")
+	fs.DurationVar(&c.MinDwell, "min-dwell", 0, "Minimum time spent on a link before switching back to a preferred one")
+	fs.StringSliceVar(&c.ChronyPrimaryServers, "chrony-primary-servers", nil, "NTP sources of chrony only reachable over the primary link, taken offline on failover")
+	fs.StringSliceVar(&c.ChronyBackupServers, "chrony-backup-servers", nil, "NTP sources added to chrony on failover")
+	fs.IntVar(&c.ChronyFailoverStratum, "chrony-failover-stratum", 0, "Local stratum chrony advertises to the LAN while on the backup link (disabled if 0)")
+	fs.StringVar(&c.DNSMode, "dns-mode", "off", "How the DNS servers are switched to WiFi on failover: off, resolv.conf (rewrite --resolv-conf) or resolved (systemd-resolved over D-Bus)")
+	fs.StringSliceVar(&c.DNSServers, "dns-servers", nil, "DNS servers written to resolv.conf on failover instead of the servers learned on WiFi")
+	fs.StringVar(&c.ResolvConf, "resolv-conf", defaultResolvConf, "resolv.conf rewritten with --dns-mode resolv.conf")
+	fs.StringArrayVar(&c.FirewallRules, "firewall-rule", nil, "iptables, ip6tables or nft command adding a rule for the link carrying the traffic, {{.Interface}}, moved on every failover, may be repeated")
+	fs.Float64Var(&c.ProbeWeight, "probe-weight", 1, "Weight of the built-in probe against the external health reports (see report)")
+	fs.DurationVar(&c.ReliabilityHalfLife, "reliability-half-life", 72*time.Hour, "Age at which a probe result weighs half as much in the long-term reliability score of a link")
+	fs.DurationVar(&c.BackupMaxAge, "backup-max-age", 7*24*time.Hour, "Warn when the backup path was last verified longer ago than this (disabled if 0)")
+	fs.BoolVar(&c.DryRun, "dry-run", false, "Probe and decide as usual but only log the route, NetworkManager and system changes instead of making them")
+	fs.BoolVar(&c.Drill, "drill", false, "Run a failover drill: connect to WiFi, verify connectivity over it, disconnect and exit without touching the routes")
+	fs.StringVar(&c.ProbeType, "probe-type", probe.TypeICMP, "Type of the probes sent to the endpoints: icmp, tcp, http, https, dns or a type compiled in (udp:// endpoints always use the responder protocol)")
+	fs.StringArrayVar(&c.ProbeOptions, "probe-option", nil, "Setting of the probe type as name=value, e.g. name=example.com, qtype=a or expect=192.0.2.0/24 for dns probes, may be repeated")
+	fs.DurationVar(&c.Interval, "interval", DefaultInterval, "Delay between two probe rounds")
+	fs.IntVar(&c.ProbeCount, "probe-count", 1, "Probes sent to each endpoint per round: the endpoint answers the round if any probe was answered, with their median RTT")
+	fs.DurationVar(&c.ProbeTimeout, "probe-timeout", 5*time.Second, "Time to wait for a TCP handshake, an HTTP response or a DNS answer")
+	fs.IntVar(&c.TCPPort, "tcp-port", 443, "Port TCP probes connect to when the endpoint has none")
+	fs.IntVar(&c.HTTPStatus, "http-status", 200, "Status code HTTP and HTTPS probes expect")
+	fs.DurationVar(&c.ICMPTimeout, "icmp-timeout", 2*time.Second, "Time to wait for an ICMP echo reply")
+	fs.IntVar(&c.ICMPPayloadSize, "icmp-payload-size", 56, "Payload size of the ICMP echo requests in bytes (at least 8)")
+	fs.IntVar(&c.ICMPTTL, "icmp-ttl", 0, "TTL of the ICMP echo requests (system default if 0)")
+	fs.StringVar(&c.IPFamily, "ip-family", familyIPv4, "Address families endpoints are probed and routed over: ipv4, ipv6 or dual")
+	fs.IntVar(&c.IPv4Prefix, "ipv4-prefix", DefaultIPv4Prefix, "Prefix length of the IPv4 endpoint networks moved on failover")
+	fs.IntVar(&c.IPv6Prefix, "ipv6-prefix", DefaultIPv6Prefix, "Prefix length of the IPv6 endpoint networks moved on failover")
+	fs.StringArrayVar(&c.Networks, "route-prefix", nil, "Network moved on failover in place of the endpoint networks, in CIDR notation with an optional route metric, e.g. 0.0.0.0/0@50, may be repeated")
+	fs.StringVar(&c.PMTU, "pmtu", "off", "Path MTU discovery toward the first endpoint over the interface the traffic moves to: off, probe (log and export it only), mtu (set the MTU of the interface to it) or clamp (clamp the TCP MSS over the interface to it)")
+	fs.StringVar(&c.ConntrackFlush, "conntrack-flush", "off", "Connection tracking entries deleted when the traffic leaves an interface: off, all, or egress for the flows through its addresses")
+	fs.StringVar(&c.FailoverMode, "failover-mode", modeReplace, "How routes fail over: replace, adding the WiFi routes on failover, or metric, keeping routes through both links and adjusting their metrics")
+	fs.IntVar(&c.PrimaryMetric, "primary-metric", 20, "Metric of the routes through the primary link with --failover-mode metric")
+	fs.IntVar(&c.BackupMetric, "backup-metric", 30, "Metric of the routes through WiFi while on the primary link with --failover-mode metric")
+	fs.IntVar(&c.FailoverMetric, "failover-metric", 10, "Metric of the routes through WiFi while failed over with --failover-mode metric")
+	fs.StringVar(&c.ProbeKeyFile, "probe-key-file", "", "File holding the shared key authenticating probes to the responder")
+	fs.StringVar(&c.BufferbloatURL, "bufferbloat-url", "", "Large file downloaded to measure latency under load on each link (test disabled if empty)")
+	fs.DurationVar(&c.BufferbloatDuration, "bufferbloat-duration", 5*time.Second, "Duration of the loaded phase of the bufferbloat test")
+	fs.StringVar(&c.BufferbloatMinGrade, "bufferbloat-min-grade", "", "Worst bufferbloat grade (A+, A, B, C, D, F) accepted on WiFi for verification to succeed")
+	fs.Float64Var(&c.MinThroughput, "min-throughput", 0, "Minimum WiFi throughput in Mbit/s measured before moving the routes to it, below which the traffic stays on the primary link (disabled if 0)")
+	fs.StringVar(&c.ThroughputURL, "throughput-url", "", "File downloaded over WiFi to measure its throughput")
+	fs.StringVar(&c.ThroughputIperf3, "throughput-iperf3", "", "iperf3 server, as host or host:port, receiving from over WiFi to measure its throughput")
+	fs.DurationVar(&c.ThroughputDuration, "throughput-duration", 5*time.Second, "Maximum duration of the throughput test")
+	fs.IntVar(&c.MinWiFiHealth, "min-wifi-health", 0, "Minimum WiFi health score (0-100, from channel utilization and noise) for verification to succeed")
+	fs.IntVar(&c.MinRSSI, "min-rssi", 0, "Minimum WiFi signal strength in dBm, e.g. -75: weaker WiFi is not failed over to and is left once the primary link answers (disabled if 0)")
+	fs.DurationVar(&c.RouteRTOMin, "route-rto-min", 0, "Minimum TCP retransmission timeout set on failover routes (kernel default if 0)")
+	fs.BoolVar(&c.RouteQuickAck, "route-quickack", false, "Disable delayed ACKs on failover routes")
+	fs.IntVar(&c.RouteInitCwnd, "route-initcwnd", 0, "Initial congestion window set on failover routes (kernel default if 0)")
+	fs.DurationVar(&c.TCPKeepaliveTime, "tcp-keepalive-time", 0, "TCP keepalive idle time applied host-wide on failover, restored on failback (system default if 0)")
+	fs.DurationVar(&c.TCPKeepaliveInterval, "tcp-keepalive-interval", 0, "TCP keepalive probe interval applied host-wide on failover, restored on failback (system default if 0)")
+	fs.IntVar(&c.TCPKeepaliveProbes, "tcp-keepalive-probes", 0, "TCP keepalive probe count applied host-wide on failover, restored on failback (system default if 0)")
+	fs.StringSliceVar(&c.WireGuardInterfaces, "wireguard", nil, "WireGuard interfaces whose peers are re-homed to the new link on every failover and failback, comma-separated")
+	fs.StringArrayVar(&c.WireGuardEndpoints, "wireguard-endpoint", nil, "Endpoint of a WireGuard peer resolved again on re-homing, as publickey=host:port, may be repeated")
+	fs.DurationVar(&c.WireGuardHandshakeTimeout, "wireguard-handshake-timeout", 10*time.Second, "Time a re-homed WireGuard peer has to complete a handshake over the new link before a warning (not waited for if 0)")
+	fs.StringVar(&c.Peer, "peer", "", "Address of the other instance of a gateway pair, as host:port: the two instances elect which one asserts the routes (disabled if empty)")
+	fs.StringVar(&c.PeerListen, "peer-listen", ":7778", "Address the heartbeats of the peer are received on")
+	fs.StringVar(&c.PeerName, "peer-name", "", "Name of the instance in its pair, up to 32 bytes (default: the host name)")
+	fs.IntVar(&c.PeerPriority, "peer-priority", 100, "Priority of the instance in its pair, between 1 and 255, the higher one is master at equal health")
+	fs.BoolVar(&c.PeerPreempt, "peer-preempt", true, "Take over from a live master of lower priority, not only from a less healthy one")
+	fs.DurationVar(&c.PeerInterval, "peer-interval", time.Second, "Interval between two heartbeats sent to the peer")
+	fs.DurationVar(&c.PeerDeadInterval, "peer-dead-interval", 0, "Time after which a silent peer is taken for down (default: 3 heartbeat intervals)")
+	fs.StringVar(&c.PeerKeyFile, "peer-key-file", "", "File holding the key shared with the peer to authenticate the heartbeats (unauthenticated if empty)")
+	fs.StringVar(&c.PeerVIP, "peer-vip", "", "Virtual address of the site held by the master, in CIDR notation, e.g. 192.168.1.1/24 (none if empty)")
+	fs.StringVar(&c.PeerVIPInterface, "peer-vip-interface", "", "Network interface facing the site the virtual address is added to")
+	fs.StringArrayVar(&c.Hooks, "hook", nil, "Shell command run on a state transition, as state=command or *=command for all, may be repeated")
+	fs.StringVar(&c.OnFailover, "on-failover", "", "Executable run once the traffic moved to a backup link, with the transition in IF_RELIABILITY_* environment variables")
+	fs.StringVar(&c.OnFailback, "on-failback", "", "Executable run once the traffic moved back to the primary link, with the transition in IF_RELIABILITY_* environment variables")
+	fs.BoolVar(&c.Dispatcher, "dispatcher", false, "React to NetworkManager dispatcher events (see dispatcher install) in addition to probing")
+	fs.StringVar(&c.Modem, "modem", "", "Network interface of the LTE modem of the primary link, e.g. wwan0, managed by ModemManager (disabled if empty)")
+	fs.Float64Var(&c.MinRSRP, "min-rsrp", 0, "Minimum LTE RSRP of the modem in dBm, e.g. -110: a weaker signal counts as a failed probe round (disabled if 0)")
+	fs.Float64Var(&c.MinRSRQ, "min-rsrq", 0, "Minimum LTE RSRQ of the modem in dB, e.g. -15 (disabled if 0)")
+	fs.Float64Var(&c.MinSINR, "min-sinr", 0, "Minimum LTE SINR of the modem in dB, e.g. -3 (disabled if 0)")
+	fs.BoolVar(&c.ModemReconnect, "modem-reconnect", false, "Reset the bearers of the modem once the primary link failed, and fail over only if that did not bring it back")
+	fs.DurationVar(&c.ModemReconnectTimeout, "modem-reconnect-timeout", 30*time.Second, "Time the modem reconnect and the primary link have to answer again")
+	fs.DurationVar(&c.UsageInterval, "usage-interval", time.Minute, "Interval between two reads of the byte counters of the links accounting their traffic (disabled if 0)")
+	fs.StringVar(&c.DataCap, "data-cap", "", "Data cap of --data-cap-interface over a billing period, e.g. 20GB: once --data-cap-threshold of it is used, the other links are preferred even while it is healthy (disabled if empty)")
+	fs.StringVar(&c.DataCapInterface, "data-cap-interface", "", "Network interface the data cap applies to (default: the --modem interface)")
+	fs.Float64Var(&c.DataCapThreshold, "data-cap-threshold", 90, "Percentage of the data cap from which the other links are preferred")
+	fs.IntVar(&c.DataCapResetDay, "data-cap-reset-day", 1, "Day of the month the billing period starts, between 1 and 28")
+	fs.BoolVar(&c.CarrierWatch, "carrier-watch", true, "Fail over as soon as the kernel reports the monitored interface down or without carrier, in addition to probing")
+	fs.BoolVar(&c.GatewayProbe, "gateway-probe", false, "Probe the gateway of the monitored interface with ARP or neighbor solicitations every --gateway-interval, failing over as soon as it stops answering")
+	fs.DurationVar(&c.GatewayInterval, "gateway-interval", 200*time.Millisecond, "Interval between two gateway probes, also their timeout")
+	fs.IntVar(&c.GatewayRetry, "gateway-retry", 3, "Consecutive unanswered gateway probes reporting the first hop down")
+	fs.StringVar(&c.EchoReflector, "echo-reflector", "", "Reflector, the responder command of another host, as host:port or udp://host:port, streamed echo requests over each link every --echo-interval for sub-second failure detection (disabled if empty)")
+	fs.DurationVar(&c.EchoInterval, "echo-interval", 100*time.Millisecond, "Interval between two echo requests to the reflector")
+	fs.IntVar(&c.EchoMultiplier, "echo-multiplier", 3, "Number of echo intervals without a reply reporting the path down")
+	fs.DurationVar(&c.EchoMaxJitter, "echo-max-jitter", 0, "Smoothed jitter of the echo RTT above which the path is reported down (disabled if 0)")
+	fs.StringVar(&c.WatchSocket, "watch-socket", DefaultWatchSocket, "Socket streaming live probe results and decisions to the watch command (disabled if empty)")
+	fs.StringVar(&c.EventLog, "event-log", "", "File the probe results, state transitions, commands run and errors are appended to, one JSON object per line (disabled if empty)")
+	fs.BoolVar(&c.Diagnose, "diagnose", true, "Append a traceroute toward a failed endpoint and the counters of the interface to the event log when a link reaches the failure threshold")
+	fs.IntVar(&c.DiagnoseMaxHops, "diagnose-max-hops", 15, "Maximum number of hops of the diagnosis traceroute")
+	fs.StringVar(&c.WebhookURL, "webhook-url", "", "URL every state transition, or link switch with --interfaces, is POSTed to as JSON (disabled if empty)")
+	fs.StringVar(&c.SiteID, "site-id", "", "Site identifier sent in the webhooks, the alerts and the MQTT states (default: the host name)")
+	fs.StringVar(&c.MQTTBroker, "mqtt-broker", "", "MQTT broker the state is published to as a retained message, tcp://host:1883 or ssl://host:8883 (disabled if empty)")
+	fs.StringVar(&c.MQTTTopic, "mqtt-topic", "", "Topic of the MQTT state messages (default: if-reliability/<site-id>, followed by /<group> in a group)")
+	fs.StringVar(&c.MQTTClientID, "mqtt-client-id", "", "MQTT client identifier (default: if-reliability-<site-id>, followed by -<group> in a group)")
+	fs.StringVar(&c.MQTTUsername, "mqtt-username", "", "MQTT user name")
+	fs.StringVar(&c.MQTTPassword, "mqtt-password", "", "MQTT password")
+	fs.StringVar(&c.MQTTCAFile, "mqtt-ca-file", "", "Certificates the MQTT broker certificate is verified against (default: the system ones)")
+	fs.StringVar(&c.MQTTCertFile, "mqtt-cert-file", "", "Client certificate presented to the MQTT broker")
+	fs.StringVar(&c.MQTTKeyFile, "mqtt-key-file", "", "Key of the MQTT client certificate")
+	fs.IntVar(&c.MQTTQoS, "mqtt-qos", 1, "MQTT quality of service of the state messages: 0, 1 or 2")
+	fs.DurationVar(&c.MQTTInterval, "mqtt-interval", 30*time.Second, "Interval between two MQTT state messages, also published on every switch")
+	fs.DurationVar(&c.WebhookTimeout, "webhook-timeout", 10*time.Second, "Time to wait for the webhook server to answer")
+	fs.IntVar(&c.WebhookAttempts, "webhook-attempts", 5, "Delivery attempts per webhook event")
+	fs.DurationVar(&c.WebhookBackoff, "webhook-backoff", time.Second, "Delay before retrying a failed webhook delivery, doubled on each retry up to a minute")
+	fs.StringSliceVar(&c.AlertEmails, "alert-email", nil, "Email addresses the alerts are sent to through --alert-smtp-server (disabled if empty)")
+	fs.StringVar(&c.AlertSMTPServer, "alert-smtp-server", "localhost:25", "SMTP relay (host:port) sending the alert emails, with STARTTLS if offered")
+	fs.StringVar(&c.AlertSMTPFrom, "alert-smtp-from", "", "Sender of the alert emails (default: if-reliability@<host name>)")
+	fs.StringVar(&c.AlertSMTPUsername, "alert-smtp-username", "", "User name authenticating to the SMTP relay (no authentication if empty)")
+	fs.StringVar(&c.AlertSMTPPassword, "alert-smtp-password", "", "Password authenticating to the SMTP relay, also read from the alert-smtp-password systemd credential")
+	fs.StringVar(&c.AlertSlackURL, "alert-slack-url", "", "Slack incoming webhook URL the alerts are posted to (disabled if empty)")
+	fs.StringVar(&c.AlertTeamsURL, "alert-teams-url", "", "Microsoft Teams incoming webhook URL the alerts are posted to (disabled if empty)")
+	fs.StringVar(&c.AlertSeverity, "alert-severity", "warning", "Least severity of the alerts sent: info, warning or critical")
+	fs.DurationVar(&c.AlertMinDuration, "alert-min-duration", 0, "Time a failover must last before it is alerted, shorter ones and their failback not being alerted at all (alerted at once if 0)")
+	fs.StringVar(&c.AlertSubject, "alert-subject", "", "Go text/template of the subject of the alerts (default: a summary of the event)")
+	fs.StringVar(&c.AlertBody, "alert-body", "", "Go text/template of the body of the alerts (default: the event, its reason, time and outage)")
+	fs.DurationVar(&c.AlertTimeout, "alert-timeout", 10*time.Second, "Time to wait for Slack or Teams to answer")
+	fs.IntVar(&c.AlertAttempts, "alert-attempts", 3, "Delivery attempts per alert and destination")
+	fs.BoolVar(&c.Daemon, "daemon", false, "Run as a systemd Type=notify service: notify readiness, answer the watchdog and restore the primary link on SIGTERM")
+	fs.BoolVar(&c.RestoreOnExit, "restore-on-exit", true, "Restore the primary link and the default routes the monitor started from on SIGINT and SIGTERM, always done with --daemon")
+	fs.BoolVar(&c.DisconnectOnExit, "disconnect-on-exit", false, "Also disconnect the WiFi connection the monitor made when restoring on exit")
+	fs.BoolVar(&c.Notify, "notify", false, "Pop a desktop notification on every failover and failback through org.freedesktop.Notifications")
+	fs.StringVar(&c.NotifyUser, "notify-user", "", "User name or ID whose desktop is notified (default: the user who ran sudo, or else the one running the tool)")
+	fs.StringVar(&c.NotifyBus, "notify-bus", "", "Address of the session bus of --notify-user (default: $DBUS_SESSION_BUS_ADDRESS for the user running the tool, unix:path=/run/user/<uid>/bus otherwise)")
+	fs.StringVar(&c.StatusFile, "status-file", "", "File the state, the last probe results and the failure counters are written to, in the Prometheus text format if it ends with .prom, JSON otherwise (disabled if empty)")
+	fs.DurationVar(&c.StatusFileInterval, "status-file-interval", 10*time.Second, "Interval between two writes of --status-file, also written on every state change")
+	fs.StringVar(&c.MetricsListen, "metrics-listen", "", "Address (host:port) the Prometheus metrics are served on at /metrics (disabled if empty)")
+	fs.StringVar(&c.ControlSocket, "control-socket", DefaultControlSocket, "Socket serving the control API (disabled if empty)")
+	fs.StringVar(&c.ControlListen, "control-listen", "", "Loopback address (host:port) also serving the control API (disabled if empty), authenticated with --control-token-file")
+	fs.StringVar(&c.ControlTokenFile, "control-token-file", "", "File holding the bearer token the requests on --control-listen must carry")
+	fs.StringVar(&c.TriggerSocket, "trigger-socket", DefaultTriggerSocket, "Socket receiving NetworkManager dispatcher events and evacuate requests (disabled if empty)")
+	fs.IntVar(&c.Protocol, "route-proto", DefaultProtocol, "Routing protocol number installed routes are tagged with (see ip route show proto)")
+	fs.IntVar(&c.RouteRealm, "route-realm", 0, "Realm installed routes are tagged with (untagged if 0)")
+	fs.StringVar(&c.ReconcileRoutes, "reconcile-routes", reconcileReassert, "What to do when another program removes or replaces a route the tool installed: reassert it, log it or off")
+	fs.DurationVar(&c.ReconcileBackoff, "reconcile-backoff", time.Second, "Delay before re-asserting a route changed by another program, doubled while it keeps changing it")
+	fs.DurationVar(&c.ReconcileMaxBackoff, "reconcile-max-backoff", 5*time.Minute, "Maximum delay before re-asserting a route changed by another program")
+	fs.StringVar(&c.SyslogAddr, "syslog-addr", "", "Remote syslog server (host:port) probe samples are exported to (disabled if empty)")
+	fs.StringVar(&c.SyslogNetwork, "syslog-network", "udp", "Network used to reach the syslog server: udp or tcp")
+	fs.IntVar(&c.SyslogSampleHealthy, "syslog-sample-healthy", 10, "Export one probe sample out of N while the link is healthy")
+	fs.IntVar(&c.SyslogSampleDegraded, "syslog-sample-degraded", 1, "Export one probe sample out of N while the link is degraded")
+	fs.DurationVar(&c.SlowExec, "slow-exec", 2*time.Second, "Run time above which an external program (iw, iptables...) or a NetworkManager or routing operation is reported as slow")
+	fs.DurationVar(&c.Connect.AssociationTimeout, "wifi-association-timeout", 30*time.Second, "Maximum time for WiFi association and authentication")
+	fs.DurationVar(&c.Connect.DHCPTimeout, "wifi-dhcp-timeout", 30*time.Second, "Maximum time for a DHCP lease and a reachable default router once associated")
+	fs.IntVar(&c.Connect.MaxAttempts, "wifi-connect-attempts", 3, "Number of WiFi connection attempts")
+	fs.DurationVar(&c.Connect.RetrySpacing, "wifi-retry-spacing", 5*time.Second, "Delay before the second WiFi connection attempt, doubled before each following one")
+	fs.DurationVar(&c.Connect.MaxSpacing, "wifi-max-retry-spacing", time.Minute, "Maximum delay between two WiFi connection attempts")
+	fs.DurationVar(&c.Connect.Deadline, "wifi-connect-deadline", 0, "Maximum time for all the connection attempts to a WiFi network (unbounded if 0)")
+	fs.StringVar(&c.WiFiEAP, "wifi-eap", "", "EAP method of a WPA2-Enterprise --wifi-ssid: peap, ttls or tls, WPA2-Personal if empty")
+	fs.StringVar(&c.WiFiIdentity, "wifi-identity", "", "802.1X identity of --wifi-eap")
+	fs.StringVar(&c.WiFiAnonymousIdentity, "wifi-anonymous-identity", "", "802.1X identity sent in clear with --wifi-eap peap or ttls")
+	fs.StringVar(&c.WiFiCACert, "wifi-ca-cert", "", "Certificate authority checking the 802.1X server, not checked if empty")
+	fs.StringVar(&c.WiFiClientCert, "wifi-client-cert", "", "Client certificate of --wifi-eap tls")
+	fs.StringVar(&c.WiFiPrivateKey, "wifi-private-key", "", "Private key of --wifi-eap tls, decrypted with --wifi-password if encrypted")
+	fs.StringVar(&c.WiFiPhase2, "wifi-phase2", "", "Inner authentication of --wifi-eap peap or ttls (default mschapv2)")
+	fs.StringArrayVar(&c.WiFiFallbacks, "wifi-fallback", nil, "WiFi network tried when --wifi-ssid cannot be connected to, as ssid:password, ssid, or ssid=,bssid=,priority=,password= with the password last, may be repeated")
+	fs.StringVar(&c.PortalCheck, "portal-check", "", "URL answering 204 No Content when the Internet is reachable, e.g. "+portal.DefaultURL+", fetched over WiFi before moving the routes to it (captive portal detection disabled if empty)")
+	fs.StringVar(&c.PortalLoginHook, "portal-login-hook", "", "Shell command run with the WiFi interface and the portal page as arguments when a captive portal is detected")
+	fs.DurationVar(&c.PortalTimeout, "portal-timeout", time.Minute, "Maximum wait for connectivity once a captive portal is detected, after which the traffic stays on the primary link")
+	fs.StringVar(&c.ColdSpareRFKill, "cold-spare-rfkill", "", "rfkill device id or type (e.g. wlan) of the WiFi radio, kept blocked until a failover needs it")
+	fs.StringVar(&c.ColdSparePowerCommand, "cold-spare-power-cmd", "", "Shell command powering the WiFi device up on failover")
+	fs.DurationVar(&c.ColdSpareTimeout, "cold-spare-timeout", 30*time.Second, "Maximum time for the WiFi interface to appear once activated")
+	fs.DurationVar(&c.WarmStandby, "warm-standby", 0, "Keep WiFi associated while the primary link is up and probe the verification endpoints over it at this interval, so that failing over is only a route switch (disabled if 0)")
+	fs.IntVar(&c.WarmStandbyRetry, "warm-standby-retry", 3, "Failed warm standby probe rounds in a row after which WiFi is reconnected")
+	fs.StringVar(&c.Record, "record", "", "Record the interactions with the WiFi backend (NetworkManager operations and restarts) into the given file")
+	fs.StringVar(&c.Replay, "replay", "", "Replay the interactions recorded in the given file instead of running the WiFi backend")
+	fs.DurationVar(&c.NMRestartTimeout, "nm-restart-timeout", time.Minute, "How long WiFi operations are held while NetworkManager restarts")
+	fs.StringVar(&c.Netns, "netns", "", "Named network namespace to operate in (see ip netns)")
+	fs.IntVar(&c.RouteTable, "route-table", 0, "Dedicated routing table the failover routes are installed in, looked up through ip rules, leaving the main table untouched (main table if 0)")
+	fs.IntVar(&c.RulePriority, "rule-priority", defaultRulePriority, "Priority of the ip rules looking up --route-table")
+	fs.StringVar(&c.RuleFwmark, "rule-fwmark", "", "Firewall mark (mark[/mask]) of the traffic looking up --route-table (all traffic if empty)")
+	fs.StringSliceVar(&c.RuleFrom, "rule-from", nil, "Source networks of the traffic looking up --route-table (all sources if empty)")
+	fs.StringSliceVar(&c.RuleCgroups, "rule-cgroup", nil, "Cgroups whose traffic is marked with --rule-fwmark, so that only it fails over: cgroup v2 paths, e.g. system.slice/telemetry.service, or net_cls class IDs, e.g. 10:1")
+	fs.StringVar(&c.VRF, "vrf", "", "VRF device the links are enslaved to: probes are bound to it and routes installed in its table")
+	fs.StringVar(&c.LockDir, "lock-dir", "/run/if-reliability", "Directory holding the per-interface instance locks (disabled if empty)")
+	fs.BoolVar(&c.Takeover, "takeover", false, "Terminate another instance managing the same interfaces instead of exiting")
+	fs.StringVar(&c.LogSeverity, "log-severity", "info", "Minimum severity of the logged events: info, warning or critical")
+	fs.StringArrayVar(&c.LogSinks, "log-sink", nil, "Other destination of the log as kind[:target][@level]: journald, syslog, syslog:udp://host:514 or file:/var/log/if-reliability.log, keeping the events at level (debug, info, warn or error) or above, may be repeated")
+	fs.BoolVar(&c.LogConsole, "log-console", true, "Write the log to the console (standard error)")
+	fs.StringVar(&c.LogFileMaxSize, "log-file-max-size", "10MB", "Size past which the log files of --log-sink are rotated (never if empty)")
+	fs.DurationVar(&c.LogFileMaxAge, "log-file-max-age", 24*time.Hour, "Age past which the log files of --log-sink are rotated (never if 0)")
+	fs.IntVar(&c.LogFileBackups, "log-file-backups", 7, "Rotated log files kept (all if 0)")
+	fs.IntVar(&c.LogRepeatBurst, "log-repeat-burst", 5, "Warnings repeated every probe round during an outage logged before they are limited to one per --log-repeat-interval")
+	fs.DurationVar(&c.LogRepeatInterval, "log-repeat-interval", time.Minute, "Least time between two warnings repeated during an outage once past --log-repeat-burst, the others logged at debug level and counted (all logged if 0)")
+	fs.DurationVar(&c.LogHeartbeat, "log-heartbeat", 5*time.Minute, "Interval of the summaries of an ongoing outage: its duration, the link in use and the endpoints failing (disabled if 0)")
+	fs.StringVar(&c.MetricsSeverity, "metrics-severity", "info", "Minimum severity of the events counted in the metrics")
+	fs.StringVar(&c.SyslogSeverity, "syslog-severity", "info", "Minimum severity of the probe samples exported to syslog (healthy samples are info, degraded ones warnings)")
+	fs.StringVar(&c.Timezone, "timezone", "Local", "Time zone used to display timestamps (e.g. UTC, Europe/Luxembourg)")
+}
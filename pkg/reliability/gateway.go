@@ -0,0 +1,78 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/probe"
+)
+
+// sourceGateway is the source of the up and down events made from the
+// neighbor probes of the gateways.
+const sourceGateway = "gateway"
+
+// gatewayProbe probes the default router of the links with ARP requests or
+// neighbor solicitations every interval, much faster than the endpoints: a
+// link whose first hop stopped answering retry times in a row is reported
+// down, failing over at once, while an endpoint down beyond a working first
+// hop is left to the end-to-end probes and their quorum.
+type gatewayProbe struct {
+	m *Manager
+
+	interval time.Duration
+	retry    int
+	prober   *probe.Neighbor
+}
+
+// watchGateways returns events, the dispatcher events if not nil, merged with
+// down events on the gateway of a link in links stopping to answer and up
+// events once it answers again. links returns the links to watch, read on
+// every probe as the link carrying traffic changes.
+func (g *gatewayProbe) watchGateways(events <-chan dispatcher.Event, links func() []string) <-chan dispatcher.Event {
+	merged := make(chan dispatcher.Event, 16)
+	go func() {
+		failures := map[string]int{}
+		for range g.m.tick(g.interval) {
+			for _, ifname := range links() {
+				if ifname == "" {
+					continue
+				}
+				router, err := g.m.defaultRouter(ifname)
+				if err != nil || router == "" {
+					continue
+				}
+				var result probe.Result
+				netns.Do(g.m.namespace, func() error {
+					result = g.prober.Probe(g.m.probeContext(), router, ifname)
+					return nil
+				})
+				if result.OK() {
+					if failures[ifname] >= g.retry {
+						g.m.log.Info().Msgf("Gateway %s of %s answers again", router, ifname)
+						merged <- dispatcher.Event{Interface: ifname, Action: dispatcher.ActionUp, Source: sourceGateway}
+					}
+					failures[ifname] = 0
+					continue
+				}
+				failures[ifname]++
+				g.m.log.Debug().Msgf("Gateway %s of %s did not answer: %s", router, ifname, result.Err)
+				if failures[ifname] == g.retry {
+					g.m.log.Warn().Msgf("Gateway %s of %s did not answer %d neighbor probes in a row, the first hop is down", router, ifname, g.retry)
+					merged <- dispatcher.Event{Interface: ifname, Action: dispatcher.ActionDown, Source: sourceGateway}
+				}
+			}
+		}
+	}()
+	if events != nil {
+		go func() {
+			for e := range events {
+				merged <- e
+			}
+		}()
+	}
+	return merged
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// groupsKey is the setting of the configuration file defining the groups.
+const groupsKey = "groups"
+
+// groupPattern matches the names of the groups, used in file names.
+var groupPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// groupFiles are the settings naming the files and sockets of a monitor,
+// given a per-group name unless set in the section of the group, and
+// groupDirs the directories, given a per-group subdirectory.
+var (
+	groupFiles = []string{"state-file", "control-socket", "trigger-socket", "watch-socket", "status-file", "event-log", "history-snapshot", "availability-report", "record"}
+	groupDirs  = []string{"history-dir"}
+)
+
+// groupAnnotation annotates the flags given a per-group value with the value
+// they were derived from and its source.
+const groupAnnotation = "if-reliability-group-base"
+
+// groupListeners are the settings that cannot be shared by the groups, each
+// with the setting enabling it if not itself.
+var groupListeners = map[string]string{
+	"metrics-listen": "metrics-listen",
+	"control-listen": "control-listen",
+	"peer-listen":    "peer",
+}
+
+// configGroups returns the sections of the groups of the settings of a
+// configuration file, by name, and removes them from the settings.
+func configGroups(settings map[string]interface{}) (map[string]map[string]interface{}, error) {
+	value, ok := settings[groupsKey]
+	if !ok {
+		return nil, nil
+	}
+	delete(settings, groupsKey)
+	sections, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected the settings of each group by name", groupsKey)
+	}
+	groups := map[string]map[string]interface{}{}
+	for name, value := range sections {
+		if !groupPattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid group name %q, expected lower case letters, digits, - and _", groupsKey, name)
+		}
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: %s: expected settings keyed by flag name", groupsKey, name)
+		}
+		groups[name] = section
+	}
+	return groups, nil
+}
+
+// groupSettings merges the section of group, if not empty, into settings,
+// the top-level settings of a configuration file, and returns it with the
+// names of the groups.
+func groupSettings(settings map[string]interface{}, group string) (map[string]interface{}, []string, error) {
+	groups, err := configGroups(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if group == "" {
+		return nil, names, nil
+	}
+	section, ok := groups[group]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown group %q", group)
+	}
+	for name, value := range section {
+		settings[name] = value
+	}
+	return section, names, nil
+}
+
+// GroupFile returns the name of the file at path for group, e.g.
+// /run/if-reliability/control-ot.sock for control.sock.
+func GroupFile(path string, group string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + group + ext
+}
+
+// applyGroup gives the files, sockets and directories of flags not set in
+// section, the settings of group, per-group names, and checks that the
+// listeners are not shared.
+func applyGroup(flags *pflag.FlagSet, group string, section map[string]interface{}) error {
+	for _, name := range groupFiles {
+		if _, set := section[name]; set {
+			continue
+		}
+		if value, _ := flags.GetString(name); value != "" {
+			source := Source(flags.Lookup(name))
+			flags.Set(name, GroupFile(value, group))
+			flags.SetAnnotation(name, groupAnnotation, []string{value, source})
+		}
+	}
+	for _, name := range groupDirs {
+		if _, set := section[name]; set {
+			continue
+		}
+		if value, _ := flags.GetString(name); value != "" {
+			source := Source(flags.Lookup(name))
+			flags.Set(name, filepath.Join(value, group))
+			flags.SetAnnotation(name, groupAnnotation, []string{value, source})
+		}
+	}
+	for name, enabler := range groupListeners {
+		if _, set := section[name]; set {
+			continue
+		}
+		value, _ := flags.GetString(name)
+		enabled, _ := flags.GetString(enabler)
+		if value != "" && enabled != "" {
+			return fmt.Errorf("%s: %s is shared by the groups, set it in the section of each group", group, name)
+		}
+	}
+	return nil
+}
+
+// groupSocket returns the control socket of group, given its section and the
+// top-level settings of the configuration file.
+func groupSocket(settings, section map[string]interface{}, group string) string {
+	if socket, ok := section["control-socket"]; ok {
+		return fmt.Sprint(socket)
+	}
+	socket := DefaultControlSocket
+	if value, ok := settings["control-socket"]; ok {
+		socket = fmt.Sprint(value)
+	}
+	return GroupFile(socket, group)
+}
+
+// GroupSockets returns the control socket of the monitor of each group the
+// configuration file at path defines, none if it defines no group.
+func GroupSockets(path string) (map[string]string, error) {
+	settings, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := configGroups(settings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	sockets := map[string]string{}
+	for name, section := range groups {
+		sockets[name] = groupSocket(settings, section, name)
+	}
+	return sockets, nil
+}
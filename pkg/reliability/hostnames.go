@@ -0,0 +1,135 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/resolver"
+)
+
+// Modes of --endpoint-records, probing the addresses of a host name.
+const (
+	recordsRotate = "rotate"
+	recordsAll    = "all"
+)
+
+// lookupTimeout bounds the wait for the answer of a DNS server resolving an
+// endpoint.
+const lookupTimeout = 2 * time.Second
+
+// setupHostNames sets the resolution of the endpoint host names up from the
+// config, once the address family is set.
+func (m *Manager) setupHostNames() error {
+	m.endpointRecords = m.config.EndpointRecords
+	m.hostNames.MinTTL = m.config.EndpointMinTTL
+	m.hostNames.MaxTTL = m.config.EndpointMaxTTL
+	switch m.endpointRecords {
+	case recordsRotate, recordsAll:
+	default:
+		return fmt.Errorf("invalid --endpoint-records %q: expected %s or %s", m.endpointRecords, recordsRotate, recordsAll)
+	}
+	if m.hostNames.MaxTTL < m.hostNames.MinTTL {
+		return fmt.Errorf("--endpoint-max-ttl %s is below --endpoint-min-ttl %s", m.hostNames.MaxTTL, m.hostNames.MinTTL)
+	}
+	m.hostNames.IPv4, m.hostNames.IPv6 = m.ipFamily != familyIPv6, m.ipFamily != familyIPv4
+	return nil
+}
+
+// nameServers returns the DNS servers host names are resolved through over
+// ifname: those NetworkManager learned on it, or else those of
+// /etc/resolv.conf, the only ones when following the routing table.
+func (m *Manager) nameServers(ifname string) []string {
+	if ifname != "" {
+		servers, err := m.learnedNameservers(ifname)
+		if err == nil && len(servers) > 0 {
+			return servers
+		}
+		if err != nil {
+			m.log.Debug().Msgf("Cannot read the DNS servers of %s, resolving through %s: %s", ifname, defaultResolvConf, err)
+		}
+	}
+	content, err := os.ReadFile(defaultResolvConf)
+	if err != nil {
+		m.log.Debug().Msgf("Cannot read the DNS servers of %s: %s", defaultResolvConf, err)
+		return nil
+	}
+	return resolver.Nameservers(string(content))
+}
+
+// logResolved logs the addresses a host name resolved to over ifname.
+func (m *Manager) logResolved(name string, ifname string, addrs []netip.Addr) {
+	list := make([]string, len(addrs))
+	for i, addr := range addrs {
+		list[i] = addr.String()
+	}
+	m.log.Info().Msgf("Endpoint %s resolves to %s over %s", name, strings.Join(list, ", "), LinkKey(ifname))
+}
+
+// probeAddresses returns the addresses to probe in place of address, a host
+// or host:port, when its host is a name: the next of its addresses resolved
+// over ifname, or all of them with --endpoint-records all. Any other address
+// is returned as is. It must run in the network namespace of the probes.
+func (m *Manager) probeAddresses(address string, ifname string) ([]string, error) {
+	if strings.Contains(address, "://") {
+		return []string{address}, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	if _, err := netip.ParseAddr(host); err == nil || host == "" {
+		return []string{address}, nil
+	}
+	var addrs []netip.Addr
+	if m.endpointRecords == recordsAll {
+		if addrs, err = m.hostNames.Lookup(host, ifname); err != nil {
+			return nil, err
+		}
+	} else {
+		addr, err := m.hostNames.Next(host, ifname)
+		if err != nil {
+			return nil, err
+		}
+		addrs = []netip.Addr{addr}
+	}
+	addresses := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = addr.String()
+		if port != "" {
+			addresses[i] = net.JoinHostPort(addresses[i], port)
+		}
+	}
+	return addresses, nil
+}
+
+// probeAll probes each of addresses with p over ifname, within ctx, and
+// returns the best result: the fastest answer, or the last failure if none
+// answered.
+func (m *Manager) probeAll(ctx context.Context, p probe.Prober, addresses []string, ifname string) probe.Result {
+	var best probe.Result
+	answered := false
+	for _, address := range addresses {
+		result := p.Probe(ctx, address, ifname)
+		switch {
+		case !result.OK():
+			if len(addresses) > 1 {
+				m.log.Debug().Msgf("Probe toward %s failed: %s", address, result.Err)
+			}
+			if !answered {
+				best = result
+			}
+		case !answered || result.RTT < best.RTT:
+			best, answered = result, true
+		}
+	}
+	return best
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/probe"
+)
+
+// sourceInjected is the source of the down events of the injected failures.
+const sourceInjected = "injected"
+
+// errInjected is the error of the probes failed on purpose.
+var errInjected = errors.New("injected failure")
+
+// faultInjector makes the probes over some interfaces fail on purpose, to
+// rehearse a failover and its hooks.
+type faultInjector struct {
+	m *Manager
+
+	mu     sync.Mutex
+	faults map[string]control.Fault
+	// events carries the injected losses of carrier to the monitoring
+	// loop.
+	events chan dispatcher.Event
+}
+
+// newFaultInjector returns an injector with no failure under way.
+func (m *Manager) newFaultInjector() *faultInjector {
+	return &faultInjector{m: m, faults: map[string]control.Fault{}, events: make(chan dispatcher.Event, 16)}
+}
+
+// inject makes the probes over ifname fail for duration, and reports a loss
+// of carrier of ifname if carrier is set.
+func (f *faultInjector) inject(ifname string, duration time.Duration, carrier bool) error {
+	fault := control.Fault{Interface: ifname, Until: time.Now().Add(duration), Carrier: carrier}
+	f.mu.Lock()
+	f.faults[ifname] = fault
+	f.mu.Unlock()
+	f.m.log.Warn().Msgf("Injecting a failure of %s for %s", ifname, duration)
+	f.m.decide(ifname, "failure injected for %s", duration)
+	if carrier {
+		select {
+		case f.events <- dispatcher.Event{Interface: ifname, Action: dispatcher.ActionDown, Source: sourceInjected}:
+		default:
+			return errors.New("too many injected losses of carrier pending")
+		}
+	}
+	return nil
+}
+
+// failing reports whether the probes over ifname fail on purpose.
+func (f *faultInjector) failing(ifname string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fault, ok := f.faults[ifname]
+	if ok && time.Now().After(fault.Until) {
+		delete(f.faults, ifname)
+		f.m.log.Info().Msgf("Injected failure of %s over", ifname)
+		return false
+	}
+	return ok
+}
+
+// active returns the injected failures not over yet.
+func (f *faultInjector) active() []control.Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var active []control.Fault
+	for _, fault := range f.faults {
+		if time.Now().Before(fault.Until) {
+			active = append(active, fault)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Interface < active[j].Interface })
+	return active
+}
+
+// synthetic returns the result of a probe over ifname, or over the default
+// interface if empty, that is not actually sent: failed if a failure of the
+// interface is injected, answered after the simulated round-trip time during
+// a simulation.
+func (m *Manager) synthetic(ifname string) (probe.Result, bool) {
+	if ifname == "" {
+		ifname = m.defaultIF
+	}
+	switch {
+	case ifname != "" && m.faults.failing(ifname):
+		return probe.Failed(errInjected), true
+	case m.simulated != nil:
+		time.Sleep(m.simulated.rtt)
+		return probe.Result{RTT: m.simulated.rtt}, true
+	}
+	return probe.Result{}, false
+}
+
+// withInjected returns events, the other events if not nil, merged with the
+// injected losses of carrier.
+func (m *Manager) withInjected(events <-chan dispatcher.Event) <-chan dispatcher.Event {
+	merged := make(chan dispatcher.Event, 16)
+	injected, done := m.faults.events, m.probeContext().Done()
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case e := <-injected:
+				merged <- e
+			}
+		}
+	}()
+	if events != nil {
+		go func() {
+			for e := range events {
+				merged <- e
+			}
+		}()
+	}
+	return merged
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/shynuu/if-reliability/logsink"
+	"github.com/shynuu/if-reliability/severity"
+	"github.com/shynuu/if-reliability/usage"
+)
+
+// Log sink kinds.
+const (
+	sinkJournald = "journald"
+	sinkSyslog   = "syslog"
+	sinkFile     = "file"
+)
+
+// fileRotation is how the log files are rotated.
+type fileRotation struct {
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+}
+
+// parseLogSink parses a --log-sink value, kind[:target][@level]: journald,
+// syslog to the local daemon or to a network://host:port target, or file
+// with the path of the file as target, keeping the events at level or above
+// (debug, info, warn or error), every event if omitted.
+func parseLogSink(value string, rotation fileRotation) (io.Writer, error) {
+	spec, levelName, _ := strings.Cut(value, "@")
+	level := zerolog.DebugLevel
+	if levelName != "" {
+		var err error
+		if level, err = zerolog.ParseLevel(levelName); err != nil || level < zerolog.DebugLevel || level > zerolog.ErrorLevel {
+			return nil, fmt.Errorf("invalid log sink %q: unknown level %q, expected debug, info, warn or error", value, levelName)
+		}
+	}
+	kind, target, _ := strings.Cut(spec, ":")
+	var sink zerolog.LevelWriter
+	switch kind {
+	case sinkJournald:
+		if target != "" {
+			return nil, fmt.Errorf("invalid log sink %q: journald takes no target", value)
+		}
+		j, err := logsink.DialJournald("if-reliability")
+		if err != nil {
+			return nil, fmt.Errorf("journald: %w", err)
+		}
+		sink = j
+	case sinkSyslog:
+		network, address := "", ""
+		if target != "" {
+			u, err := url.Parse(target)
+			if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Port() == "" {
+				return nil, fmt.Errorf("invalid log sink %q: the syslog target must be udp://host:port or tcp://host:port", value)
+			}
+			network, address = u.Scheme, u.Host
+		}
+		w, err := logsink.DialSyslog(network, address, "if-reliability")
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %w", err)
+		}
+		sink = w
+	case sinkFile:
+		if target == "" {
+			return nil, fmt.Errorf("invalid log sink %q: file needs a path, e.g. file:/var/log/if-reliability.log", value)
+		}
+		f := &logsink.File{Path: target, MaxSize: rotation.maxSize, MaxAge: rotation.maxAge, MaxBackups: rotation.maxBackups}
+		if err := f.Open(); err != nil {
+			return nil, err
+		}
+		sink = zerolog.LevelWriterAdapter{Writer: f}
+	default:
+		return nil, fmt.Errorf("invalid log sink %q: unknown kind %q, expected %s, %s or %s", value, kind, sinkJournald, sinkSyslog, sinkFile)
+	}
+	return &zerolog.FilteredLevelWriter{Writer: redactor{out: sink}, Level: level}, nil
+}
+
+// NewLogger returns the logger of the log settings of c: the console, with
+// timestamps in the time zone of c, and the log sinks, with the secrets
+// redacted.
+func NewLogger(c Config) (zerolog.Logger, error) {
+	var rotation fileRotation
+	if c.LogFileMaxSize != "" {
+		bytes, err := usage.ParseSize(c.LogFileMaxSize)
+		if err != nil {
+			return zerolog.Logger{}, fmt.Errorf("invalid --log-file-max-size %q: it is a size, e.g. 10MB", c.LogFileMaxSize)
+		}
+		rotation.maxSize = int64(bytes)
+	}
+	rotation.maxAge, rotation.maxBackups = c.LogFileMaxAge, c.LogFileBackups
+	if rotation.maxAge < 0 || rotation.maxBackups < 0 {
+		return zerolog.Logger{}, fmt.Errorf("invalid log file rotation after %s keeping %d files", rotation.maxAge, rotation.maxBackups)
+	}
+	location, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return zerolog.Logger{}, fmt.Errorf("invalid time zone %s: %w", c.Timezone, err)
+	}
+	var writers []io.Writer
+	if c.LogConsole {
+		writers = append(writers, zerolog.ConsoleWriter{
+			Out:          redactor{out: os.Stderr},
+			TimeFormat:   "2006-01-02 15:04:05 -07:00",
+			TimeLocation: location,
+		})
+	}
+	for _, value := range c.LogSinks {
+		sink, err := parseLogSink(value, rotation)
+		if err != nil {
+			return zerolog.Logger{}, err
+		}
+		writers = append(writers, sink)
+	}
+	if len(writers) == 0 {
+		return zerolog.Logger{}, errors.New("--log-console=false needs a --log-sink")
+	}
+	return zerolog.New(zerolog.MultiLevelWriter(writers...)).With().Timestamp().Logger(), nil
+}
+
+// setupLogger tags the events of the logger of m with their severity, the
+// ongoing outage and the group of m, and sends the errors to the event log
+// and the events.
+func (m *Manager) setupLogger() error {
+	var err error
+	if m.severities.Log, err = severity.Parse(m.config.LogSeverity); err != nil {
+		return fmt.Errorf("invalid --log-severity: %w", err)
+	}
+	if m.severities.Metrics, err = severity.Parse(m.config.MetricsSeverity); err != nil {
+		return fmt.Errorf("invalid --metrics-severity: %w", err)
+	}
+	m.severities.Registry = m.metrics
+	logger := m.log.Hook(m.outages).Hook(m.severities).Hook(eventHook{m: m})
+	if m.config.Group != "" {
+		logger = logger.With().Str("group", m.config.Group).Logger()
+	}
+	m.log = logger
+	return nil
+}
+
+// warnRepeated logs a warning repeated while the condition key lasts, e.g.
+// every probe round of an outage, unless the --log-repeat-burst first ones
+// went through and one did less than --log-repeat-interval ago. The ones held
+// back are logged at debug level and summarized in the next one logged.
+func (m *Manager) warnRepeated(key string, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	streak, ok := m.repeats.Allow(key, time.Now())
+	if !ok {
+		m.log.Debug().Msg(message)
+		return
+	}
+	if streak.Suppressed > 0 {
+		message += fmt.Sprintf(" (for %s, %d in a row, %d similar messages suppressed)", time.Since(streak.Since).Round(time.Second), streak.Count, streak.Suppressed)
+	}
+	m.log.Warn().Msg(message)
+}
+
+// endRepeated ends the condition key of warnRepeated, summarizing it if some
+// of its warnings were held back.
+func (m *Manager) endRepeated(key string, what string) {
+	if streak, ok := m.repeats.End(key); ok && streak.Total > 0 {
+		m.log.Info().Msgf("%s ended after %s, %d in a row, %d similar messages suppressed", what, time.Since(streak.Since).Round(time.Second), streak.Count, streak.Total)
+	}
+}
+
+// logHeartbeat logs a summary of the ongoing outage, if any, every interval:
+// how long it lasts, the link carrying the traffic and the endpoints still
+// failing, so that a long outage stays visible with its warnings limited.
+func (m *Manager) logHeartbeat(interval time.Duration) {
+	for range m.tick(interval) {
+		id, started := m.outages.Started()
+		if id == "" {
+			continue
+		}
+		var failing []string
+		for _, p := range m.metrics.Probes() {
+			if p.FailingSince != nil {
+				failing = append(failing, fmt.Sprintf("%s over %s failing for %s, %d consecutive failures",
+					p.Endpoint, p.Interface, time.Since(*p.FailingSince).Round(time.Second), p.ConsecutiveFailures))
+			}
+		}
+		summary := "every endpoint answering"
+		if len(failing) > 0 {
+			summary = strings.Join(failing, "; ")
+		}
+		m.log.Info().Msgf("Outage %s under way for %s, traffic on %s: %s", id, time.Since(started).Round(time.Second), m.controlStatus().ActiveLink, summary)
+	}
+}
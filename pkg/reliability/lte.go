@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/modem"
+)
+
+// modemSignalRate is how often ModemManager refreshes the signal quality of
+// the modem.
+const modemSignalRate = 5 * time.Second
+
+// modemReconnectHoldoff is the minimum time between two reconnects, so that
+// a link failing again right after a reconnect is failed over instead.
+const modemReconnectHoldoff = 10 * time.Minute
+
+// lteModem is the LTE modem of the primary link, managed by ModemManager. Its
+// signal quality counts in the health of its interface and, if reconnect is
+// set, its bearers are reset before failing over. It is disabled if ifname
+// is empty.
+type lteModem struct {
+	m *Manager
+
+	ifname     string
+	thresholds modem.Thresholds
+	reconnect  bool
+	timeout    time.Duration
+
+	path dbus.ObjectPath
+	last time.Time
+}
+
+// runMM runs a ModemManager operation over D-Bus and returns its result,
+// recorded and replayed as a run of "mm" with args describing it.
+func (m *Manager) runMM(op func(c *modem.Client) (string, error), args ...string) (string, error) {
+	return m.operate("mm", func() (string, error) {
+		if m.mmClient == nil {
+			client, err := modem.Dial()
+			if err != nil {
+				return "", fmt.Errorf("cannot reach ModemManager on the system bus: %w", err)
+			}
+			m.mmClient = client
+		}
+		return op(m.mmClient)
+	}, args...)
+}
+
+// enabled reports whether the primary link goes through a managed modem.
+func (m *lteModem) enabled() bool {
+	return m.ifname != ""
+}
+
+// attach finds the modem of the interface and has it report its signal
+// quality.
+func (m *lteModem) attach() error {
+	path, err := m.m.runMM(func(c *modem.Client) (string, error) {
+		path, err := c.Find(m.ifname)
+		return string(path), err
+	}, "find", m.ifname)
+	if err != nil {
+		return err
+	}
+	m.path = dbus.ObjectPath(path)
+	if m.thresholds.Enabled() {
+		_, err = m.m.runMM(func(c *modem.Client) (string, error) { return "", c.SetupSignal(m.path, modemSignalRate) }, "signal", "setup", string(m.path), modemSignalRate.String())
+		if err != nil {
+			return fmt.Errorf("cannot enable the signal quality reports: %w", err)
+		}
+	}
+	m.m.log.Info().Msgf("Primary link %s goes through the modem %s", m.ifname, m.path)
+	return nil
+}
+
+// signal reads the LTE signal quality of the modem.
+func (m *lteModem) signal() (modem.Signal, error) {
+	output, err := m.m.runMM(func(c *modem.Client) (string, error) {
+		s, err := c.Signal(m.path)
+		return s.Fields(), err
+	}, "signal", "get", string(m.path))
+	if err != nil {
+		return modem.Signal{}, err
+	}
+	return modem.ParseFields(output)
+}
+
+// weak reports whether the signal quality of the modem is below the
+// thresholds, and describes it. A signal that cannot be read is not
+// considered weak.
+func (m *lteModem) weak() (bool, string) {
+	if !m.thresholds.Enabled() {
+		return false, ""
+	}
+	s, err := m.signal()
+	if err != nil {
+		m.m.log.Debug().Msgf("Cannot read the signal of the modem %s: %s", m.path, err)
+		return false, ""
+	}
+	below := m.thresholds.Below(s)
+	if below == "" {
+		return false, ""
+	}
+	return true, "LTE " + below
+}
+
+// remediate reconnects the modem once the primary link failed through it,
+// and probes targets for up to the timeout. It reports whether the link
+// answered again, sparing the failover.
+func (m *lteModem) remediate(targets []endpoint.Endpoint) bool {
+	if !m.reconnect || m.m.defaultIF != m.ifname || time.Since(m.last) < modemReconnectHoldoff {
+		return false
+	}
+	if avoid, _ := m.m.avoided(m.ifname); avoid {
+		return false
+	}
+	m.last = time.Now()
+	signal, _ := m.signal()
+	m.m.log.Warn().Msgf("Reconnecting the modem of %s (%s) before failing over", m.ifname, signal)
+	m.m.decide(m.ifname, "reconnecting the modem before failing over")
+	_, err := m.m.runMM(func(c *modem.Client) (string, error) { return "", c.Reconnect(m.path, m.timeout) }, "reconnect", string(m.path))
+	if err != nil {
+		m.m.log.Error().Msgf("Error reconnecting the modem of %s: %s", m.ifname, err)
+		return false
+	}
+	m.m.changeLog.Record(changes.Connection, "reconnected", "modem bearers of %s", m.ifname)
+	deadline := time.Now().Add(m.timeout)
+	for time.Now().Before(deadline) {
+		round := m.m.probeRound(targets)
+		if !round.Failed() {
+			m.m.log.Info().Msgf("Primary link answering again after the modem reconnect: %s", round)
+			m.m.decide(m.ifname, "answering again after the modem reconnect")
+			return true
+		}
+		time.Sleep(m.m.probeInterval)
+	}
+	m.m.log.Error().Msgf("Primary link still failing %s after the modem reconnect", m.timeout)
+	return false
+}
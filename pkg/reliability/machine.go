@@ -0,0 +1,418 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/state"
+	"github.com/shynuu/if-reliability/webhook"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// failover moves the traffic between the primary link and WiFi, one state
+// of the machine at a time.
+type failover struct {
+	m *Manager
+
+	verifyEndpoints []endpoint.Endpoint
+	verifyAttempts  int
+	minWiFiHealth   int
+
+	wifiIF       string
+	wifiSSID     string
+	wifiPassword string
+	connect      wifi.ConnectOptions
+	spare        coldSpare
+	standby      *warmStandby
+	chrony       chronyPolicy
+	dns          *dnsPolicy
+	firewall     *firewallPolicy
+	portal       portalCheck
+	throughput   throughputCheck
+
+	bufferbloatURL      string
+	bufferbloatDuration time.Duration
+	bufferbloatMinGrade string
+
+	failback bool
+
+	// primaryIF is the interface of the primary link, found when the
+	// failover starts, and networks the endpoint networks routed over
+	// WiFi, resolved while monitoring the primary link.
+	primaryIF string
+	networks  []string
+	// cause is why the ongoing failover or failback started, and err why
+	// the machine stopped if a step failed.
+	cause string
+	err   error
+}
+
+// step runs a state and returns the next one and why.
+type step func(f *failover) (fsm.State, string)
+
+// steps maps each state to its step.
+var steps = map[fsm.State]step{
+	fsm.MonitoringPrimary: (*failover).monitorPrimary,
+	fsm.FailingOver:       (*failover).failOver,
+	fsm.OnBackup:          (*failover).onBackup,
+	fsm.Recovering:        (*failover).recover,
+	fsm.FailingBack:       (*failover).failBack,
+}
+
+// run runs the steps until the machine stops, once the manager stops or a
+// step failed, and returns the error of the step.
+func (f *failover) run() error {
+	for {
+		current, _ := f.m.machine.State()
+		if current == fsm.Stopped {
+			return f.err
+		}
+		next, reason := steps[current](f)
+		if f.m.stopping() && next != fsm.Stopped {
+			next, reason = fsm.Stopped, "stopped"
+		}
+		if err := f.m.machine.Transition(next, reason); err != nil {
+			return fmt.Errorf("in state %s: %w", current, err)
+		}
+		if next == fsm.FailingOver || next == fsm.FailingBack {
+			f.cause = reason
+		}
+	}
+}
+
+// monitorPrimary probes the primary link until it fails, and a modem
+// reconnect did not bring it back, or is evacuated.
+func (f *failover) monitorPrimary() (fsm.State, string) {
+	f.findPrimary()
+	for {
+		if f.m.pingInterface() {
+			f.findPrimary()
+			continue
+		}
+		if f.m.stopping() || f.m.manualCommand != "" || f.m.evacuation != nil || !f.m.primaryModem.remediate(f.m.targets) {
+			break
+		}
+	}
+	if f.m.stopping() {
+		return fsm.Stopped, "stopped"
+	}
+	if f.m.manualCommand == control.CommandFailover {
+		f.m.manualCommand = ""
+		return fsm.FailingOver, "manual failover"
+	}
+	if f.m.evacuation != nil {
+		return fsm.FailingOver, "evacuation requested"
+	}
+	if avoid, why := f.m.avoided(f.primaryIF); avoid {
+		return fsm.FailingOver, why
+	}
+	f.m.log.Error().Msgf("Ping toward %s failed%s", endpointList(f.m.targets), f.m.lossSummaries(f.m.targets))
+	f.m.holdDown(f.m.targets[0].Interface)
+	return fsm.FailingOver, "primary link failed"
+}
+
+// findPrimary resolves the endpoint networks and the interface of the
+// primary link they go through, and prepares their failover.
+func (f *failover) findPrimary() {
+	networks := f.m.endpointNetworks(f.m.targets)
+	host := primaryHost(f.m.targets, networks)
+	if len(f.m.routePrefixes) > 0 {
+		networks = f.m.routePrefixes
+	}
+	if len(networks) > 0 {
+		f.networks = networks
+	}
+	f.primaryIF = f.m.routeDevice(host)
+	f.m.defaultIF = f.primaryIF
+	f.m.notePrimary(f.primaryIF)
+	if f.m.routeMetrics.enabled() {
+		f.m.routeMetrics.standby(f.networks, f.primaryIF, f.wifiIF)
+	}
+	f.m.conntrackFlush.remember(f.primaryIF)
+	if f.firewall.enabled() {
+		f.firewall.primaryLink(f.primaryIF)
+	}
+}
+
+// primaryHost returns an address the route toward tells the primary link:
+// the first endpoint if it is an IP address, else the first of networks,
+// the networks of the endpoints.
+func primaryHost(targets []endpoint.Endpoint, networks []string) string {
+	host := targets[0].Host
+	if net.ParseIP(host) == nil && len(networks) > 0 {
+		host, _, _ = strings.Cut(networks[0], "/")
+	}
+	return host
+}
+
+// failOver connects WiFi and routes the endpoint networks through it. It
+// stops the machine if WiFi cannot be connected, and leaves the traffic on the primary
+// link if the WiFi signal is too weak, a captive portal intercepts it or its
+// throughput is too low.
+func (f *failover) failOver() (fsm.State, string) {
+	if f.spare.enabled() {
+		if err := f.spare.activate(f.wifiIF); err != nil {
+			f.err = fmt.Errorf("activating backup interface: %w", err)
+			return fsm.Stopped, "backup interface activation failed"
+		}
+	}
+	router := f.standby.ready()
+	if router != "" {
+		f.m.log.Info().Msgf("Failing over to the warm standby %s", f.wifiIF)
+		var err error
+		if router, err = f.m.refreshLease(f.wifiIF, router, f.connect.DHCPTimeout); err != nil {
+			f.m.log.Error().Msgf("Error renewing the DHCP lease of %s, connecting again: %s", f.wifiIF, err)
+		}
+	}
+	if router == "" {
+		var err error
+		if router, err = f.m.connectToWiFi(f.wifiIF, f.wifiSSID, f.wifiPassword, f.connect); err != nil {
+			f.err = fmt.Errorf("connecting to WiFi: %w", err)
+			return fsm.Stopped, "WiFi connection failed"
+		}
+	}
+	if weak, signal := f.m.weakSignal(f.wifiIF); weak {
+		f.m.log.Error().Msgf("Not failing over to %s: %s", f.wifiIF, signal)
+		f.m.decide(f.wifiIF, "%s, not failing over", signal)
+		return f.stayOnPrimary("WiFi signal too weak")
+	}
+	if reason := f.portal.blocked(f.wifiIF); reason != "" {
+		f.m.log.Error().Msgf("Not failing over to %s: %s", f.wifiIF, reason)
+		f.m.decide(f.wifiIF, "%s, not failing over", reason)
+		return f.stayOnPrimary(reason)
+	}
+	if reason := f.throughput.blocked(f.wifiIF); reason != "" {
+		f.m.log.Error().Msgf("Not failing over to %s: %s", f.wifiIF, reason)
+		f.m.decide(f.wifiIF, "%s, not failing over", reason)
+		return f.stayOnPrimary(reason)
+	}
+	routers, err := f.m.defaultRouters(f.wifiIF)
+	if err != nil {
+		f.m.log.Error().Msgf("Error reading the default routers of %s: %s", f.wifiIF, err)
+		routers = map[int]string{}
+	}
+	routers[f.m.families()[0]] = router
+	if f.m.evacuation != nil {
+		f.m.drain(f.networks, f.wifiIF, routers, f.m.evacuation.Timeout)
+	}
+	move := func() error { return f.m.routeSet(f.networks, f.wifiIF, routers, 0) }
+	if f.m.routeMetrics.enabled() {
+		move = func() error { return f.m.routeMetrics.promote(f.networks, f.wifiIF, routers) }
+	}
+	if err := move(); err != nil {
+		f.m.log.Error().Msgf("Not failing over to %s: %s", f.wifiIF, err)
+		f.m.decide(f.wifiIF, "the prefixes could not all be routed, not failing over")
+		return f.stayOnPrimary("prefixes not routed")
+	}
+	if !f.m.verifyPath(f.m.targets, f.wifiIF) {
+		f.m.log.Error().Msgf("Rolling the routes through %s back", f.wifiIF)
+		if f.m.routeMetrics.enabled() {
+			f.m.routeMetrics.demote(f.networks, f.wifiIF, !f.spare.enabled())
+		} else {
+			f.m.removeRoutes(f.networks, f.wifiIF)
+		}
+		return f.stayOnPrimary("path through " + f.wifiIF + " failed verification")
+	}
+	f.m.applySysctls()
+	if f.chrony.enabled() {
+		f.chrony.failover()
+	}
+	if f.dns.enabled() {
+		f.dns.failover(f.primaryIF, f.wifiIF)
+	}
+	if f.firewall.enabled() {
+		f.firewall.failover(f.wifiIF)
+	}
+	f.m.activeLink = f.wifiIF
+	f.m.defaultIF = f.wifiIF
+	f.m.conntrackFlush.remember(f.wifiIF)
+	f.m.conntrackFlush.left(f.primaryIF)
+	f.m.logTransition("primary", f.wifiIF)
+	networks := f.networks
+	f.m.restoreOnStop = func() { f.m.failBack(networks, f.wifiIF, f.chrony, f.dns, f.firewall, f.spare) }
+	f.m.tunnels.rehome(f.wifiIF)
+	f.m.runPathHook(pathChange{event: pathFailover, from: f.primaryIF, to: f.wifiIF, gateway: routers[f.m.families()[0]], reason: f.cause})
+	return fsm.OnBackup, "routes moved to " + f.wifiIF
+}
+
+// stayOnPrimary leaves the traffic on the primary link after WiFi was
+// refused, parking the cold spare.
+func (f *failover) stayOnPrimary(reason string) (fsm.State, string) {
+	if f.spare.enabled() {
+		if err := f.m.runNM(func(c WiFiManager) error { return c.Disconnect(f.wifiIF) }, "device", "disconnect", f.wifiIF); err != nil {
+			f.m.log.Error().Msgf("Error disconnecting %s: %s", f.wifiIF, err)
+		}
+		f.spare.park()
+	}
+	f.m.evacuation = nil
+	return fsm.MonitoringPrimary, reason
+}
+
+// onBackup verifies connectivity over WiFi, then waits for the primary link
+// to recover if failing back is enabled, or monitors WiFi until it fails
+// too otherwise.
+func (f *failover) onBackup() (fsm.State, string) {
+	target := f.m.targets[0]
+	verified := f.m.verifyConnectivity(f.verifyEndpoints, f.wifiIF, f.verifyAttempts)
+	if !f.m.checkWiFiHealth(f.wifiIF, f.minWiFiHealth) {
+		verified = false
+	}
+	if f.bufferbloatURL != "" && !f.m.measureBufferbloat(f.bufferbloatURL, f.verifyEndpoints[0].Bind(f.wifiIF), f.bufferbloatDuration, f.bufferbloatMinGrade) {
+		verified = false
+	}
+	if verified {
+		f.m.log.Info().Msgf("Connectivity over %s verified", f.wifiIF)
+		f.m.decide(f.wifiIF, "connectivity verified")
+		f.m.recordBackupVerified(f.wifiIF, state.SourceFailover)
+	} else {
+		f.m.log.Error().Msgf("Connectivity over %s could not be verified", f.wifiIF)
+		f.m.decide(f.wifiIF, "connectivity could not be verified")
+	}
+	f.m.detectAsymmetry(target, f.verifyEndpoints, f.wifiIF)
+	if !f.failback || f.primaryIF == "" {
+		if f.failback {
+			f.m.log.Error().Msg("Cannot tell which interface the primary link uses, failback disabled")
+		}
+		for f.m.pingInterface() {
+		}
+		if f.m.manualCommand == control.CommandFailback {
+			f.m.manualCommand = ""
+			return fsm.FailingBack, "manual failback"
+		}
+		return fsm.Stopped, "backup link failed"
+	}
+	return fsm.Recovering, "failback enabled"
+}
+
+// recover waits until the primary link can be failed back to.
+func (f *failover) recover() (fsm.State, string) {
+	f.m.awaitRecovery(f.primaryIF)
+	if f.m.manualCommand == control.CommandFailback {
+		f.m.manualCommand = ""
+		return fsm.FailingBack, "manual failback"
+	}
+	return fsm.FailingBack, "primary link recovered"
+}
+
+// failBack moves the traffic back to the primary link.
+func (f *failover) failBack() (fsm.State, string) {
+	f.m.failBack(f.networks, f.wifiIF, f.chrony, f.dns, f.firewall, f.spare)
+	f.m.tunnels.rehome(f.primaryIF)
+	f.m.runPathHook(pathChange{event: pathFailback, from: f.wifiIF, to: f.primaryIF, gateway: f.m.gatewayOf(f.primaryIF), reason: f.cause})
+	return fsm.MonitoringPrimary, "failed back"
+}
+
+// linkIn returns the link carrying the traffic in state s.
+func (f *failover) linkIn(s fsm.State) string {
+	switch s {
+	case fsm.OnBackup, fsm.Recovering, fsm.FailingBack:
+		return f.wifiIF
+	}
+	return PrimaryLink
+}
+
+// webhookStats is how far back the probe statistics posted to webhooks go.
+const webhookStats = time.Minute
+
+// webhookDrainTimeout is the time the queued webhook events are waited for
+// on exit.
+const webhookDrainTimeout = 30 * time.Second
+
+// closeWebhook delivers the events queued on n before the monitor exits.
+func (m *Manager) closeWebhook(n *webhook.Notifier) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookDrainTimeout)
+	defer cancel()
+	if err := n.Close(ctx); err != nil {
+		m.log.Warn().Msgf("Webhook events still undelivered after %s, exiting anyway: %s", webhookDrainTimeout, err)
+	}
+}
+
+// webhookHook returns a hook posting the transitions to n on behalf of site.
+func (f *failover) webhookHook(n *webhook.Notifier, site string) fsm.Hook {
+	return func(t fsm.Transition) {
+		e := webhook.Event{
+			Site:         site,
+			Time:         t.Time.UTC(),
+			From:         string(t.From),
+			To:           string(t.To),
+			Reason:       t.Reason,
+			OldInterface: f.linkIn(t.From),
+			NewInterface: f.linkIn(t.To),
+			OutageID:     f.m.outages.ID(),
+		}
+		recent, err := f.m.samples.Samples(t.Time.Add(-webhookStats))
+		if err != nil {
+			f.m.log.Warn().Msgf("Cannot read the recent probes for the webhook: %s", err)
+		}
+		e.Probes = history.Analyze(recent, 0)
+		n.Notify(e)
+	}
+}
+
+// webhookPath posts the path change c of the cascade to n on behalf of site.
+func (m *Manager) webhookPath(n *webhook.Notifier, site string, c pathChange) {
+	e := webhook.Event{
+		Site:         site,
+		Time:         time.Now().UTC(),
+		Path:         c.event,
+		Reason:       c.reason,
+		OldInterface: c.from,
+		NewInterface: c.to,
+		OutageID:     m.outages.ID(),
+	}
+	recent, err := m.samples.Samples(e.Time.Add(-webhookStats))
+	if err != nil {
+		m.log.Warn().Msgf("Cannot read the recent probes for the webhook: %s", err)
+	}
+	e.Probes = history.Analyze(recent, 0)
+	n.Notify(e)
+}
+
+// logState logs the transitions of the machine.
+func (m *Manager) logState(t fsm.Transition) {
+	m.log.Info().Str("from", string(t.From)).Str("to", string(t.To)).Msgf("State %s", t)
+	m.logEvent(eventlog.Event{Time: t.Time.UTC(), Kind: eventlog.KindTransition, From: string(t.From), To: string(t.To), Reason: t.Reason})
+}
+
+// shellHook returns a hook running command with sh, with the previous state,
+// the new one and the reason as $1, $2 and $3.
+func (m *Manager) shellHook(command string) fsm.Hook {
+	return func(t fsm.Transition) {
+		output, err := m.run("sh", "-c", command, "if-reliability", string(t.From), string(t.To), t.Reason)
+		if err != nil {
+			m.log.Error().Msgf("Hook %q on %s failed: %s, output: %s", command, t, err, strings.TrimSpace(string(output)))
+		}
+	}
+}
+
+// registerHooks registers the shell hooks given as state=command, or
+// *=command for every transition.
+func (m *Manager) registerHooks(specs []string) error {
+	for _, spec := range specs {
+		name, command, ok := strings.Cut(spec, "=")
+		if !ok || command == "" {
+			return fmt.Errorf("invalid hook %q: expected state=command", spec)
+		}
+		if name == "*" {
+			m.machine.OnAny(m.shellHook(command))
+			continue
+		}
+		s, err := fsm.Parse(name)
+		if err != nil {
+			return fmt.Errorf("invalid hook %q: %w", spec, err)
+		}
+		m.machine.On(s, m.shellHook(command))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+)
+
+// Failover modes.
+const (
+	modeReplace = "replace"
+	modeMetric  = "metric"
+)
+
+// metricPolicy routes the moved networks through both uplinks at once in the
+// metric failover mode: through the primary link at the primary metric and
+// through WiFi at the backup one, above it. Failing over lowers the metric of
+// the WiFi routes below the primary one, and failing back raises it again, so
+// that a route toward each network always exists. It is disabled if primary
+// is 0.
+type metricPolicy struct {
+	m *Manager
+
+	primary  int
+	backup   int
+	failover int
+	// networks are the networks routed through primaryIF and wifiIF.
+	networks  []string
+	primaryIF string
+	wifiIF    string
+}
+
+// newMetricPolicy returns the metric policy of mode: replace or metric.
+func (m *Manager) newMetricPolicy(mode string, primary, backup, failover int) (*metricPolicy, error) {
+	switch mode {
+	case modeReplace:
+		return &metricPolicy{m: m}, nil
+	case modeMetric:
+	default:
+		return nil, fmt.Errorf("unknown failover mode %q (%s or %s)", mode, modeReplace, modeMetric)
+	}
+	if failover < 1 || failover >= primary || primary >= backup {
+		return nil, fmt.Errorf("the metrics must satisfy 0 < failover (%d) < primary (%d) < backup (%d)", failover, primary, backup)
+	}
+	for cidr, metric := range m.prefixMetrics {
+		if metric != 0 {
+			return nil, fmt.Errorf("the metric of %s does not apply to --failover-mode %s", cidr, modeMetric)
+		}
+	}
+	return &metricPolicy{m: m, primary: primary, backup: backup, failover: failover}, nil
+}
+
+// enabled reports whether failovers adjust metrics.
+func (m *metricPolicy) enabled() bool {
+	return m.primary != 0
+}
+
+// standby routes networks through primaryIF at the primary metric, and
+// through wifiIF at the backup metric if it has a default router.
+func (m *metricPolicy) standby(networks []string, primaryIF, wifiIF string) {
+	m.networks, m.primaryIF, m.wifiIF = networks, primaryIF, wifiIF
+	if primaryIF != "" && primaryIF != wifiIF {
+		routers, err := m.m.defaultRouters(primaryIF)
+		if err != nil {
+			m.m.log.Error().Msgf("Error reading the default routers of %s: %s", primaryIF, err)
+		} else {
+			m.m.routeNetworks(networks, primaryIF, routers, m.primary)
+		}
+	}
+	routers, err := m.m.defaultRouters(wifiIF)
+	if err != nil || len(routers) == 0 {
+		m.m.log.Debug().Msgf("No default router on %s yet, the backup routes are installed on failover", wifiIF)
+		return
+	}
+	m.m.routeNetworks(networks, wifiIF, routers, m.backup)
+}
+
+// promote lowers the metric of the routes through wifiIF below the primary
+// one, the networks being routed at the failover metric before the routes at
+// the backup metric are removed.
+func (m *metricPolicy) promote(networks []string, wifiIF string, routers map[int]string) error {
+	if err := m.m.routeSet(networks, wifiIF, routers, m.failover); err != nil {
+		return err
+	}
+	for _, cidr := range networks {
+		m.m.removeRoute(cidr, wifiIF, m.backup)
+	}
+	return nil
+}
+
+// demote raises the metric of the routes through wifiIF back to the backup
+// one, or only removes them if keep is false, e.g. when WiFi is about to be
+// disconnected.
+func (m *metricPolicy) demote(networks []string, wifiIF string, keep bool) {
+	if keep {
+		if routers, err := m.m.defaultRouters(wifiIF); err != nil {
+			m.m.log.Error().Msgf("Error reading the default routers of %s: %s", wifiIF, err)
+		} else {
+			m.m.routeNetworks(networks, wifiIF, routers, m.backup)
+		}
+	}
+	for _, cidr := range networks {
+		m.m.removeRoute(cidr, wifiIF, m.failover)
+	}
+}
+
+// remove removes the routes installed at every metric, on exit.
+func (m *metricPolicy) remove() {
+	for _, cidr := range m.networks {
+		if m.primaryIF != "" && m.primaryIF != m.wifiIF {
+			m.m.removeRoute(cidr, m.primaryIF, m.primary)
+		}
+		m.m.removeRoute(cidr, m.wifiIF, m.backup)
+		m.m.removeRoute(cidr, m.wifiIF, m.failover)
+	}
+}
@@ -0,0 +1,1114 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/bufferbloat"
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/detect"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/live"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/nm"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/quorum"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/shynuu/if-reliability/sdnotify"
+	"github.com/shynuu/if-reliability/severity"
+	"github.com/shynuu/if-reliability/sla"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// probeContext returns the context of the probes of the running manager.
+func (m *Manager) probeContext() context.Context {
+	m.probeMu.Lock()
+	defer m.probeMu.Unlock()
+	return m.probeCtx
+}
+
+// expectedExit are exit statuses reporting a network condition rather than a
+// failure of the program itself, e.g. ping exits with 1 when no reply came back.
+var expectedExit = map[string]int{"ping": 1}
+
+// run runs the given program inside the configured network namespace and
+// returns its combined output. The run time and outcome are recorded, so that
+// a slow or failing subprocess can be told apart from a network problem.
+func (m *Manager) run(name string, args ...string) ([]byte, error) {
+	if m.player != nil {
+		output, err := m.player.Exec(name, args...)
+		if err != nil {
+			m.log.Debug().Msgf("Replayed %s: %s", name, err)
+		}
+		return output, err
+	}
+	if m.skipDryRun(name, args) {
+		return nil, nil
+	}
+	m.beat()
+	start := time.Now()
+	output, err := m.commandRunner.Run(name, args...)
+	duration := time.Since(start)
+	if m.recorder != nil {
+		m.recorder.Exec(name, args, output, err, duration)
+	}
+	failed := err != nil
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if code, ok := expectedExit[name]; ok && exitErr.ExitCode() == code {
+			failed = false
+		}
+	}
+	m.metrics.ObserveExec(name, duration, failed)
+	e := eventlog.Event{Kind: eventlog.KindCommand, Program: name, Args: args, DurationMillis: eventlog.Millis(duration)}
+	if failed {
+		e.Error = err.Error()
+	}
+	m.logEvent(e)
+	if m.slowExec > 0 && duration > m.slowExec {
+		m.log.Warn().Msgf("%s took %s to run, the system may be overloaded", name, duration.Round(time.Millisecond))
+	}
+	return output, err
+}
+
+// logUnreplayed warns about recorded runs that were not replayed.
+func (m *Manager) logUnreplayed() {
+	if m.player == nil {
+		return
+	}
+	for _, e := range m.player.Unused() {
+		m.log.Warn().Msgf("Recorded run not replayed: %s %s", e.Program, strings.Join(e.Args, " "))
+	}
+}
+
+// logExecStats logs the run time statistics of the external programs.
+func (m *Manager) logExecStats() {
+	for _, s := range m.metrics.Exec() {
+		m.log.Info().Msgf("%s: %d runs, %d failures, mean %s, max %s", s.Program, s.Calls, s.Failures,
+			s.Mean().Round(time.Millisecond), s.Max.Round(time.Millisecond))
+	}
+}
+
+// probeEndpoint probes an endpoint. udp:// endpoints are probed with the
+// responder protocol, dns:// ones with a DNS query, any other endpoint with
+// the configured probe type.
+func (m *Manager) probeEndpoint(target endpoint.Endpoint) probe.Result {
+	if target.URL != nil && target.URL.Scheme == "dns" {
+		address := target.Host
+		if target.URL.Port() != "" {
+			address = target.URL.Host
+		}
+		return m.probeFrom(m.resolverProber, address, target.Interface)
+	}
+	if target.URL == nil || target.URL.Scheme != "udp" {
+		return m.probeFrom(m.endpointProber, m.probeAddress(target), target.Interface)
+	}
+	if result, ok := m.synthetic(target.Interface); ok {
+		return result
+	}
+	var result probe.Result
+	err := netns.Do(m.namespace, func() error {
+		reply, err := m.prober.Probe(target.URL.Host, target.Interface)
+		if err != nil {
+			return err
+		}
+		result.RTT = reply.RTT(time.Now())
+		if reply.Observed != nil {
+			m.observed[target.String()] = reply.Observed
+		}
+		return nil
+	})
+	if err != nil {
+		m.log.Debug().Msgf("Probe toward %s failed: %s", target, err)
+		return probe.Failed(err)
+	}
+	return result
+}
+
+// lossSummary describes the directional loss measured toward a udp://
+// endpoint, or returns an empty string if it is not known.
+func (m *Manager) lossSummary(target endpoint.Endpoint) string {
+	if target.URL == nil || target.URL.Scheme != "udp" {
+		return ""
+	}
+	loss, ok := m.prober.Loss(target.URL.Host, target.Interface)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (%s over the last %d probes)", loss, loss.Sent)
+}
+
+// lossSummaries describes the directional loss measured toward each udp://
+// endpoint of targets.
+func (m *Manager) lossSummaries(targets []endpoint.Endpoint) string {
+	var summary string
+	for _, target := range targets {
+		if loss := m.lossSummary(target); loss != "" {
+			summary += fmt.Sprintf(", %s%s", target, loss)
+		}
+	}
+	return summary
+}
+
+// endpointList renders targets as a comma-separated list.
+func endpointList(targets []endpoint.Endpoint) string {
+	list := make([]string, len(targets))
+	for i, target := range targets {
+		list[i] = target.String()
+	}
+	return strings.Join(list, ", ")
+}
+
+// probeRound probes every target once, records the samples and logs the
+// endpoints going down or coming back up.
+func (m *Manager) probeRound(targets []endpoint.Endpoint) quorum.Round {
+	m.beat()
+	round := quorum.Round{Quorum: m.probeQuorum}
+	for _, target := range targets {
+		round.Statuses = append(round.Statuses, quorum.Status{Endpoint: target.String(), Result: m.probeBurst(target)})
+	}
+	if len(targets) == 1 {
+		return round
+	}
+	for _, s := range m.endpointStatus.Observe(round) {
+		if s.Result.OK() {
+			m.log.Info().Msgf("Endpoint %s is back up, %d of %d endpoints failing (quorum %d)", s, round.Failures(), len(targets), round.Quorum)
+		} else {
+			m.log.Warn().Msgf("Endpoint %s, %d of %d endpoints failing (quorum %d)", s, round.Failures(), len(targets), round.Quorum)
+		}
+	}
+	return round
+}
+
+// probeBurst sends probeCount probes to target, each recorded, and returns
+// their aggregate: answered if any probe was, with the median RTT of the
+// answered ones, or the last failure.
+func (m *Manager) probeBurst(target endpoint.Endpoint) probe.Result {
+	var answered probe.Result
+	var rtts []time.Duration
+	var last probe.Result
+	for i := 0; i < m.probeCount; i++ {
+		result := m.probeEndpoint(target)
+		m.recordSample(target, result)
+		if result.OK() {
+			answered = result
+			rtts = append(rtts, result.RTT)
+		}
+		last = result
+	}
+	if len(rtts) == 0 {
+		return last
+	}
+	answered.RTT = median(rtts)
+	return answered
+}
+
+// median returns the median of rtts, which it sorts.
+func median(rtts []time.Duration) time.Duration {
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	mid := len(rtts) / 2
+	if len(rtts)%2 == 0 {
+		return (rtts[mid-1] + rtts[mid]) / 2
+	}
+	return rtts[mid]
+}
+
+// holdDown records a failure of the link over ifname and logs how long it is
+// held down.
+func (m *Manager) holdDown(ifname string) {
+	now := time.Now()
+	link := LinkKey(ifname)
+	if hold := m.flaps.Fail(link, now); hold > 0 {
+		m.log.Warn().Msgf("Link %s held down for %s, %.1f recent failures", link, hold, m.flaps.Penalty(link, now))
+		m.decide(ifname, "held down for %s", hold)
+	}
+}
+
+// degraded reports whether the probes over ifname miss the SLA thresholds
+// toward at least a quorum of the endpoints of round, and describes the
+// misses.
+func (m *Manager) degraded(ifname string, round quorum.Round) (bool, string) {
+	var misses []string
+	for _, s := range round.Statuses {
+		stats, violations := m.quality.Check(LinkKey(ifname), s.Endpoint)
+		if len(violations) > 0 {
+			misses = append(misses, fmt.Sprintf("%s: %s (%s)", s.Endpoint, strings.Join(violations, ", "), stats))
+		}
+	}
+	if len(misses) == 0 || len(misses) < round.Quorum {
+		return false, ""
+	}
+	return true, strings.Join(misses, "; ")
+}
+
+// probeAddress returns the address the probes toward target are sent to:
+// its host for ICMP, its host and port if any for TCP and DNS, its URL if it
+// is an http or https one for HTTP, or an https one for HTTPS, and the
+// endpoint as written for the probe types registered by other packages.
+func (m *Manager) probeAddress(target endpoint.Endpoint) string {
+	switch {
+	case target.URL == nil:
+		return target.Host
+	case (m.probeType == probe.TypeTCP || m.probeType == probe.TypeDNS) && target.URL.Port() != "":
+		return target.URL.Host
+	case m.probeType == probe.TypeHTTP && (target.URL.Scheme == "http" || target.URL.Scheme == "https"):
+		return target.Address
+	case m.probeType == probe.TypeHTTPS && target.URL.Scheme == "https":
+		return target.Address
+	case !probe.Builtin(m.probeType):
+		return target.Address
+	}
+	return target.Host
+}
+
+// configureProbes sets the probers up from the probe flags, shared by the
+// monitor and the probe command, once the address family is set.
+func (m *Manager) configureProbes() error {
+	m.pinger.Timeout = m.config.ICMPTimeout
+	m.pinger.PayloadSize = m.config.ICMPPayloadSize
+	m.pinger.TTL = m.config.ICMPTTL
+	m.pinger.Network = "ip" + m.networkSuffix()
+	m.prober.Network = "udp" + m.networkSuffix()
+	m.probeType = m.config.ProbeType
+	probeTimeout := m.config.ProbeTimeout
+	port := m.config.TCPPort
+	status := m.config.HTTPStatus
+	params := map[string]string{"port": strconv.Itoa(port), "status": strconv.Itoa(status)}
+	options := m.config.ProbeOptions
+	for _, option := range options {
+		name, value, ok := strings.Cut(option, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid probe option %q: expected name=value", option)
+		}
+		params[name] = value
+	}
+	o := probe.Options{Timeout: probeTimeout, Namespace: m.namespace, Family: m.networkSuffix(), Params: params}
+	var err error
+	if m.probeType == probe.TypeICMP {
+		m.endpointProber = m.pinger
+	} else if m.endpointProber, err = probe.New(m.probeType, o); err != nil {
+		return err
+	}
+	if m.resolverProber, err = probe.New(probe.TypeDNS, o); err != nil {
+		return err
+	}
+	keyFile := m.config.ProbeKeyFile
+	key, err := ReadKey(keyFile)
+	if err != nil {
+		return fmt.Errorf("reading probe key: %w", err)
+	}
+	m.prober.Key = key
+	return m.setupHostNames()
+}
+
+// probeFrom sends one probe with p from within the configured network
+// namespace, unless its result is synthetic.
+func (m *Manager) probeFrom(p probe.Prober, address string, ifname string) probe.Result {
+	if result, ok := m.synthetic(ifname); ok {
+		return result
+	}
+	var result probe.Result
+	err := netns.Do(m.namespace, func() error {
+		addresses, err := m.probeAddresses(address, ifname)
+		if err != nil {
+			result = probe.Failed(err)
+			return nil
+		}
+		result = m.probeAll(m.probeContext(), p, addresses, ifname)
+		return nil
+	})
+	if err != nil {
+		return probe.Failed(err)
+	}
+	return result
+}
+
+// pingIP sends one ICMP echo request to an IP address from within the
+// configured network namespace. If ifname is not empty, the request is bound
+// to that interface.
+func (m *Manager) pingIP(ip string, ifname string) probe.Result {
+	return m.probeFrom(m.routerPinger, ip, ifname)
+}
+
+// probeSettings are the settings of the probes a reload may change: the
+// endpoints and their quorum, the probe interval, the detection and the SLA
+// and failback thresholds.
+type probeSettings struct {
+	targets           []endpoint.Endpoint
+	quorum            int
+	interval          time.Duration
+	detection         detect.Options
+	thresholds        sla.Thresholds
+	slaWindow         int
+	failbackSuccesses int
+	failbackHold      time.Duration
+}
+
+// parseProbeSettings checks the probe settings of c, probing targets.
+func parseProbeSettings(c Config, targets []endpoint.Endpoint) (probeSettings, error) {
+	p := probeSettings{targets: targets, quorum: c.Quorum, interval: c.Interval, slaWindow: c.SLAWindow, failbackSuccesses: c.FailbackSuccesses, failbackHold: c.FailbackHold}
+	if p.quorum == 0 {
+		p.quorum = quorum.Majority(len(targets))
+	}
+	if p.quorum < 1 || p.quorum > len(targets) {
+		return probeSettings{}, fmt.Errorf("invalid quorum %d: it must be between 1 and the number of endpoints, %d", p.quorum, len(targets))
+	}
+	p.detection = detect.Options{
+		Algorithm: c.Detection,
+		Retry:     c.Retry,
+		Window:    c.DetectionWindow,
+		MaxLoss:   c.DetectionLoss / 100,
+		Alpha:     c.DetectionAlpha,
+		MaxRTT:    c.DetectionMaxRTT,
+	}
+	if err := p.detection.Validate(); err != nil {
+		return probeSettings{}, fmt.Errorf("invalid --detection settings: %w", err)
+	}
+	if p.interval < 10*time.Millisecond {
+		return probeSettings{}, fmt.Errorf("invalid --interval %s: at least 10ms", p.interval)
+	}
+	if c.MaxLoss < 0 || c.MaxLoss > 100 {
+		return probeSettings{}, fmt.Errorf("invalid maximum loss %g%%: it must be between 0 and 100", c.MaxLoss)
+	}
+	p.thresholds = sla.Thresholds{MaxRTT: c.MaxRTT, MaxLoss: c.MaxLoss / 100, MaxJitter: c.MaxJitter}
+	if p.thresholds.Enabled() && p.slaWindow < 2 {
+		return probeSettings{}, fmt.Errorf("invalid SLA window %d: it must hold at least 2 probes", p.slaWindow)
+	}
+	return p, nil
+}
+
+// setProbeSettings makes p the probe settings of m.
+func (m *Manager) setProbeSettings(p probeSettings) {
+	m.targets, m.probeQuorum, m.probeInterval = p.targets, p.quorum, p.interval
+	m.detection = p.detection
+	m.quality.Configure(p.thresholds, p.slaWindow)
+	m.failbackSuccesses, m.failbackHold = p.failbackSuccesses, p.failbackHold
+}
+
+// pingInterface probes the endpoints every probe interval and when the
+// detection algorithm declares the link down from the rounds, or once the
+// manager stops, it returns false. It returns true instead of probing once
+// a reload changed the endpoints or the detection, to be called again.
+// A round fails when at least a quorum of the targets did not answer.
+func (m *Manager) pingInterface() bool {
+	targets := m.targets
+	m.log.Info().Msgf("Pinging %s (quorum %d)", endpointList(targets), m.probeQuorum)
+	ifname := targets[0].Interface
+	device := ifname
+	if device == "" {
+		device = m.defaultIF
+	}
+	failures := 0
+	detector := detect.New(m.detection)
+	paused := false
+	for {
+		select {
+		case <-m.probeContext().Done():
+			return false
+		case <-time.After(m.probeInterval):
+		case req := <-m.commands:
+			if m.acceptCommand(req) {
+				return false
+			}
+			continue
+		case event := <-m.triggers:
+			if event.Action == dispatcher.ActionHealth {
+				m.acceptHealth(event)
+				continue
+			}
+			if event.Action == dispatcher.ActionEvacuate {
+				if !m.acceptEvacuation(event.Interface, ifname) {
+					m.log.Warn().Msgf("Cannot evacuate %s, it is not the link carrying traffic", event.Interface)
+					continue
+				}
+				m.log.Warn().Msgf("Evacuation of %s requested", LinkName(ifname))
+				m.decide(ifname, "evacuation requested, draining for up to %s", event.Timeout)
+				m.evacuation = &event
+				return false
+			}
+			if event.Action == dispatcher.ActionDown && event.Interface != "" && event.Interface == device && m.suspended() {
+				m.log.Warn().Msgf("%s reported down by %s, automatic failover paused", event.Interface, reporter(event))
+				continue
+			}
+			if event.Action == dispatcher.ActionDown && event.Interface != "" && event.Interface == device {
+				id := m.outages.Open()
+				m.log.Warn().Msgf("%s reported down by %s, outage %s", event.Interface, reporter(event), id)
+				m.decide(ifname, "reported down by %s, failing over", reporter(event))
+				return false
+			}
+			if event.Degraded() {
+				m.log.Warn().Msgf("NetworkManager reports connectivity %s, probing now", event.Connectivity)
+			} else {
+				m.log.Debug().Msgf("Event %s on %s from %s, probing now", event.Action, event.Interface, reporter(event))
+			}
+		}
+		if m.applyReload() {
+			return true
+		}
+		round := m.probeRound(targets)
+		if avoid, why := m.avoided(device); avoid && !m.suspended() {
+			m.log.Warn().Msgf("%s, failing over while the primary link is healthy", why)
+			m.decide(ifname, "%s, failing over", why)
+			return false
+		}
+		link := LinkKey(ifname)
+		poor, misses := m.degraded(ifname, round)
+		weak, signal := m.weakSignal(device)
+		unhealthy := m.healthInputs.Unhealthy(link, round.Failed() || poor || weak, time.Now())
+		tier := ""
+		if round.Failed() {
+			tier = m.gatewayTier(device)
+		}
+		if unhealthy && failures == 0 {
+			id := m.outages.Open()
+			m.log.Warn().Msgf("Failure detected, %s, outage %s%s", round, id, m.lossSummaries(targets))
+			m.decide(ifname, "failure detected, %s, outage %s", round, id)
+			if tier != "" {
+				m.decide(ifname, "%s", tier)
+			}
+		}
+		repeated := "unhealthy " + link
+		down := detector.Observe(detect.Round{Failed: unhealthy, RTT: roundRTT(round)})
+		if !unhealthy {
+			m.endRepeated(repeated, fmt.Sprintf("Failure of %s", LinkName(ifname)))
+			m.closeOutage()
+			failures = 0
+		} else {
+			failures++
+			progress := detector.Progress()
+			switch {
+			case round.Failed():
+				if tier != "" {
+					m.warnRepeated(repeated, "Probes failed: %s, %s. %s. Retrying...", round, tier, progress)
+				} else {
+					m.warnRepeated(repeated, "Probes failed: %s. %s. Retrying...", round, progress)
+				}
+			case poor:
+				m.warnRepeated(repeated, "Link quality below the SLA: %s. %s. Retrying...", misses, progress)
+			case weak:
+				m.warnRepeated(repeated, "Weak signal: %s. %s. Retrying...", signal, progress)
+			default:
+				m.warnRepeated(repeated, "External health reports mark %s unhealthy: %s. %s. Retrying...", link, m.healthInputs.Describe(link, time.Now()), progress)
+			}
+		}
+		if !down {
+			paused = false
+			continue
+		}
+		if m.suspended() {
+			if !paused {
+				m.log.Warn().Msgf("%s, automatic failover paused", detector.Reason())
+				paused = true
+			}
+			continue
+		}
+		if failures == 0 {
+			// Only the averages of the answered rounds show the failure.
+			id := m.outages.Open()
+			m.log.Warn().Msgf("Failure detected, %s, outage %s", detector.Reason(), id)
+		}
+		switch {
+		case weak && !round.Failed() && !poor:
+			m.decide(ifname, "%s, weak signal (%s), failing over", detector.Reason(), signal)
+		case poor && !round.Failed():
+			m.decide(ifname, "%s, below the SLA (%s), failing over", detector.Reason(), misses)
+		case round.Failed():
+			m.decide(ifname, "%s (%s), failing over", detector.Reason(), round)
+		default:
+			m.decide(ifname, "%s, failing over", detector.Reason())
+		}
+		m.diagnose(device, failedTarget(targets, round))
+		return false
+	}
+}
+
+// roundRTT returns the mean RTT of the answered endpoints of round, 0 if
+// none answered.
+func roundRTT(round quorum.Round) time.Duration {
+	var total time.Duration
+	answered := 0
+	for _, s := range round.Statuses {
+		if s.Result.OK() {
+			total += s.Result.RTT
+			answered++
+		}
+	}
+	if answered == 0 {
+		return 0
+	}
+	return total / time.Duration(answered)
+}
+
+// recordSample stores a ping result in the probe history.
+func (m *Manager) recordSample(target endpoint.Endpoint, result probe.Result) {
+	sample := history.Sample{
+		Time:      time.Now().UTC(),
+		Endpoint:  target.Address,
+		Interface: target.Interface,
+		Success:   result.OK(),
+		OutageID:  m.outages.ID(),
+	}
+	if sample.Success {
+		sample.RTT = result.RTT
+		hops, change := m.paths.Observe(target.String(), result.TTL)
+		sample.Hops = hops
+		if change != nil {
+			sample.PathChange = true
+			m.log.Warn().Msgf("Path change toward %s: %s, the RTT step that comes with it is not a link degradation", target, change)
+			m.decide(target.Interface, "path change: %s", change)
+		}
+	}
+	if err := m.samples.Add(sample); err != nil {
+		m.log.Error().Msgf("Error recording probe sample: %s", err)
+	}
+	m.metrics.ObserveProbe(LinkKey(sample.Interface), sample.Endpoint, sample.RTT, sample.Success, m.outages.ID())
+	if result.Phases != nil {
+		m.metrics.ObservePhases(LinkKey(sample.Interface), sample.Endpoint, result.Phases.Map())
+		if !sample.Success {
+			m.log.Debug().Msgf("Probe toward %s failed after %s", target, result.Phases)
+		}
+	}
+	m.quality.Add(LinkKey(sample.Interface), target.String(), sample.RTT, sample.Success)
+	m.pathScores.Add(LinkKey(sample.Interface), target.String(), sample.RTT, sample.Success)
+	m.scoreSample(sample.Interface, sample.Success, sample.Time)
+	m.observeAvailability(sample.Interface, sample.Endpoint, sample.Success, sample.RTT, sample.Time)
+	m.liveHub.Publish(live.Record{Kind: live.KindSample, Interface: sample.Interface, Sample: &sample})
+	m.logEvent(eventlog.Event{
+		Time:      sample.Time,
+		Kind:      eventlog.KindProbe,
+		Interface: sample.Interface,
+		Endpoint:  sample.Endpoint,
+		Success:   &sample.Success,
+		RTTMillis: eventlog.Millis(sample.RTT),
+		OutageID:  sample.OutageID,
+	})
+	if m.exporter != nil {
+		if err := m.exporter.Export(sample); err != nil {
+			m.log.Debug().Msgf("Error exporting probe sample to syslog: %s", err)
+		}
+	}
+	e := Event{Time: sample.Time, Kind: EventProbe, Endpoint: target.String(), Interface: target.Interface, RTT: sample.RTT}
+	if !sample.Success {
+		e.Err = result.Err.Error()
+	}
+	m.emit(e)
+}
+
+// closeOutage ends the ongoing outage, if any.
+func (m *Manager) closeOutage() {
+	id, duration := m.outages.Close()
+	if id != "" {
+		m.log.Info().Str("outage_id", id).Msgf("Connectivity recovered, outage %s lasted %s", id, duration.Round(time.Second))
+		m.decide("", "connectivity recovered, outage %s lasted %s", id, duration.Round(time.Second))
+	}
+}
+
+// runNM runs an operation of the WiFi backend, NetworkManager unless the
+// manager was given another, recorded and replayed as a run of "nm" with args
+// describing it. While NetworkManager is restarting, the operation is held
+// until it is back on the system bus and then retried, instead of failing
+// because the daemon momentarily could not be reached.
+func (m *Manager) runNM(op func(c WiFiManager) error, args ...string) error {
+	call := func() error {
+		_, err := m.operate("nm", func() (string, error) { return "", op(m.wifiManager) }, args...)
+		return err
+	}
+	if m.nmWatcher == nil {
+		return call()
+	}
+	deadline := time.Now().Add(m.nmRestartTimeout)
+	for {
+		if !m.nmWatcher.WaitAvailable(time.Until(deadline)) {
+			return fmt.Errorf("NetworkManager did not come back within %s", m.nmRestartTimeout)
+		}
+		err := call()
+		if err == nil {
+			return nil
+		}
+		// Give the name owner change a moment to arrive before deciding
+		// whether the failure was caused by a restart.
+		time.Sleep(500 * time.Millisecond)
+		if m.nmWatcher.Available() || time.Now().After(deadline) {
+			return err
+		}
+		m.log.Warn().Msg("NetworkManager is restarting, retrying once it is back")
+	}
+}
+
+// wifiScanWait bounds the scan picking among the networks to connect to.
+const wifiScanWait = 5 * time.Second
+
+// connectToWiFi connects to the given wifi ssid with the given password within
+// the bounds of the connect options and returns the default router of the WiFi network.
+// With fallback networks in the options, the networks are scanned for and tried
+// in the order of wifi.Order, falling through to the next when one cannot be
+// connected to. A wired interface is brought up by connectWired instead.
+func (m *Manager) connectToWiFi(ifwifi string, ssid string, password string, opts wifi.ConnectOptions) (string, error) {
+	if m.wired(ifwifi) {
+		return m.connectWired(ifwifi, opts)
+	}
+	networks := append([]wifi.Network{{SSID: ssid, Password: password, Enterprise: opts.Enterprise}}, opts.Fallbacks...)
+	if len(networks) > 1 {
+		networks = wifi.Order(networks, m.scanNetworks(ifwifi))
+	}
+	var router string
+	var err error
+	for i, network := range networks {
+		if i > 0 {
+			m.log.Warn().Msgf("%s, trying the network %s", err, network)
+		}
+		if router, err = m.connectNetwork(ifwifi, network, opts); err == nil {
+			if network.SSID != ssid {
+				m.log.Info().Msgf("Connected to the fallback network %s", network)
+				m.decide(ifwifi, "connected to the fallback network %s", network)
+			}
+			break
+		}
+	}
+	return router, err
+}
+
+// scanNetworks scans for the access points ifwifi sees and returns whether a
+// network is among them. If the scan fails, no network is reported visible,
+// keeping the configured order.
+func (m *Manager) scanNetworks(ifwifi string) func(wifi.Network) bool {
+	var aps []nm.AccessPoint
+	if err := m.runNM(func(c WiFiManager) error {
+		var err error
+		aps, err = c.Scan(ifwifi, wifiScanWait)
+		return err
+	}, "wifi", "scan", ifwifi); err != nil {
+		m.log.Warn().Msgf("Cannot scan for WiFi networks on %s, trying them in the configured order: %s", ifwifi, err)
+	}
+	return func(n wifi.Network) bool {
+		for _, ap := range aps {
+			if ap.SSID == n.SSID && (n.BSSID == "" || strings.EqualFold(ap.BSSID, n.BSSID)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// connectNetwork makes up to the maximum number of connection attempts to
+// network, backing off exponentially between them, until the deadline. It
+// returns a *wifi.ConnectError if none succeeded.
+func (m *Manager) connectNetwork(ifwifi string, network wifi.Network, opts wifi.ConnectOptions) (string, error) {
+	var deadline time.Time
+	if opts.Deadline > 0 {
+		deadline = time.Now().Add(opts.Deadline)
+	}
+	failure := &wifi.ConnectError{SSID: network.String()}
+	for attempt := 1; attempt <= opts.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			spacing := opts.Spacing(attempt - 1)
+			if !deadline.IsZero() && time.Now().Add(spacing).After(deadline) {
+				failure.Deadline = opts.Deadline
+				return "", failure
+			}
+			m.log.Warn().Msgf("Connection attempt %d out of %d to %s failed: %s. Retrying in %s...", attempt-1, opts.MaxAttempts, network, failure.Err, spacing)
+			time.Sleep(spacing)
+		}
+		remaining := time.Duration(0)
+		if !deadline.IsZero() {
+			remaining = time.Until(deadline)
+		}
+		router, phase, err := m.connectOnce(ifwifi, network, opts.Within(remaining))
+		if err == nil {
+			return router, nil
+		}
+		failure.Phase, failure.Attempts, failure.Err = phase, attempt, err
+	}
+	return "", failure
+}
+
+// connectOnce makes one connection attempt and waits for a reachable default
+// router. On failure, it returns the phase that failed.
+func (m *Manager) connectOnce(ifwifi string, network wifi.Network, opts wifi.ConnectOptions) (string, string, error) {
+	args := []string{"wifi", "connect", network.SSID, ifwifi}
+	if network.BSSID != "" {
+		args = append(args, network.BSSID)
+	}
+	err := m.runNM(func(c WiFiManager) error {
+		return c.ConnectWiFi(ifwifi, network.SSID, network.BSSID, network.Password, network.Enterprise, opts.AssociationTimeout)
+	}, args...)
+	if err != nil {
+		return "", wifi.PhaseAssociation, err
+	}
+	m.changeLog.Record(changes.Connection, "activated", "%s on %s", network, ifwifi)
+	m.connectedWiFi = ifwifi
+	if m.dryRun {
+		route, err := m.defaultRouter(ifwifi)
+		if err == nil && route == "" {
+			m.log.Warn().Msgf("Dry run, %s has no default router, the routes would go through the one its DHCP server provides", ifwifi)
+			route = "<DHCP router>"
+		}
+		return route, wifi.PhaseDHCP, err
+	}
+	router, err := m.awaitLease(ifwifi, opts.DHCPTimeout)
+	if err != nil {
+		return "", wifi.PhaseDHCP, err
+	}
+	return router, "", nil
+}
+
+// awaitRouter waits up to timeout for a default router on ifwifi answering
+// pings, and returns it.
+func (m *Manager) awaitRouter(ifwifi string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		route, err := m.defaultRouter(ifwifi)
+		if err != nil {
+			return "", err
+		}
+		if route == "" {
+			continue
+		}
+		m.log.Debug().Msgf("Pinging default router: %s", route)
+		if m.pingIP(route, ifwifi).OK() {
+			return route, nil
+		}
+	}
+	return "", fmt.Errorf("no reachable default router on %s within %s", ifwifi, timeout)
+}
+
+// defaultRouter returns the gateway of the default route through the given
+// interface for the preferred address family, or an empty string if there is
+// none yet.
+func (m *Manager) defaultRouter(ifname string) (string, error) {
+	return m.defaultRouterOf(ifname, m.families()[0])
+}
+
+// defaultRouterOf returns the gateway of the default route of family through
+// the given interface, or an empty string if there is none yet.
+func (m *Manager) defaultRouterOf(ifname string, family int) (string, error) {
+	args := []string{"route", "default", ifname, m.vrf}
+	if family == route.IPv6 {
+		args = append(args, familyName(family))
+	}
+	router, err := m.routing(func() (string, error) { return m.routeManager.DefaultGateway(ifname, m.vrf, family) }, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to get default route: %s", err)
+	}
+	return router, nil
+}
+
+// verifyConnectivity pings every verification endpoint over the given interface,
+// unless the endpoint is bound to another one, and reports whether all of them
+// answered within the given number of attempts.
+func (m *Manager) verifyConnectivity(endpoints []endpoint.Endpoint, ifname string, attempts int) bool {
+	verified := true
+	for _, target := range endpoints {
+		target = target.Bind(ifname)
+		reachable := false
+		for i := 0; i < attempts && !reachable; i++ {
+			result := m.probeEndpoint(target)
+			m.recordSample(target, result)
+			reachable = result.OK()
+		}
+		if reachable {
+			m.log.Info().Msgf("Verification endpoint %s is reachable", target)
+		} else {
+			m.log.Error().Msgf("Verification endpoint %s is not reachable", target)
+			verified = false
+		}
+	}
+	return verified
+}
+
+// detectAsymmetry compares the source address the responder observes over the
+// backup interface with the one it observed over the primary link. Seeing the
+// same address means the traffic still leaves, and therefore comes back, over
+// the old link. It returns false when asymmetry is detected.
+func (m *Manager) detectAsymmetry(primary endpoint.Endpoint, endpoints []endpoint.Endpoint, ifname string) bool {
+	before, ok := m.observed[primary.String()]
+	if !ok {
+		return true
+	}
+	symmetric := true
+	for _, target := range endpoints {
+		target = target.Bind(ifname)
+		if target.URL == nil || target.URL.Scheme != "udp" || target.Interface != ifname {
+			continue
+		}
+		after, ok := m.observed[target.String()]
+		if !ok {
+			continue
+		}
+		if after.Equal(before) {
+			m.log.Error().Msgf("Asymmetric routing detected: responder %s still sees %s, the address used over the primary link", target.URL.Host, after)
+			symmetric = false
+		} else {
+			m.log.Info().Msgf("Responder %s now sees %s over %s (was %s)", target.URL.Host, after, ifname, before)
+		}
+	}
+	return symmetric
+}
+
+// measureBufferbloat runs a loaded-latency test toward url over the link used
+// to reach target and records the grade in the history. It returns false if
+// the grade is worse than minGrade.
+func (m *Manager) measureBufferbloat(url string, target endpoint.Endpoint, duration time.Duration, minGrade string) bool {
+	m.log.Info().Msgf("Measuring latency under load over %s", LinkName(target.Interface))
+	result, err := bufferbloat.Measure(bufferbloat.Options{
+		URL:       url,
+		Interface: target.Interface,
+		Duration:  duration,
+		Ping: func() (time.Duration, bool) {
+			result := m.probeEndpoint(target)
+			return result.RTT, result.OK()
+		},
+	})
+	if err != nil {
+		m.log.Error().Msgf("Bufferbloat test over %s failed: %s", LinkName(target.Interface), err)
+		return true
+	}
+	m.log.Info().Msgf("Bufferbloat grade over %s: %s (idle RTT %s, loaded RTT %s)", LinkName(target.Interface), result.Grade, result.Idle, result.Loaded)
+	err = m.samples.Add(history.Sample{
+		Time:      time.Now().UTC(),
+		Endpoint:  url,
+		Interface: target.Interface,
+		Success:   true,
+		RTT:       result.Idle,
+		LoadedRTT: result.Loaded,
+		Grade:     result.Grade,
+		OutageID:  m.outages.ID(),
+	})
+	if err != nil {
+		m.log.Error().Msgf("Error recording bufferbloat result: %s", err)
+	}
+	if minGrade != "" && bufferbloat.Worse(result.Grade, minGrade) {
+		m.log.Error().Msgf("Bufferbloat grade %s over %s is worse than the minimum %s", result.Grade, LinkName(target.Interface), minGrade)
+		return false
+	}
+	return true
+}
+
+// checkWiFiHealth scores the WiFi link from the nl80211 survey of its channel
+// and returns false if the score is below minScore. A wired interface has no
+// channel to score.
+func (m *Manager) checkWiFiHealth(ifwifi string, minScore int) bool {
+	if m.wired(ifwifi) {
+		return true
+	}
+	output, err := m.run("iw", "dev", ifwifi, "survey", "dump")
+	if err != nil {
+		m.log.Warn().Msgf("Could not read channel survey of %s: %s", ifwifi, strings.TrimSpace(string(output)))
+		return true
+	}
+	survey, err := wifi.ParseSurvey(string(output))
+	if err != nil {
+		m.log.Warn().Msgf("Could not read channel survey of %s: %s", ifwifi, err)
+		return true
+	}
+	health := wifi.Evaluate(survey)
+	m.log.Info().Msgf("WiFi health of %s: %d/100 (channel %d MHz, utilization %.0f%%, noise %d dBm)",
+		ifwifi, health.Score, survey.Frequency, health.Utilization*100, health.Noise)
+	if health.Score < minScore {
+		m.log.Error().Msgf("WiFi health of %s is below the minimum %d", ifwifi, minScore)
+		return false
+	}
+	return true
+}
+
+// LinkName names the link an interface-bound probe uses.
+func LinkName(ifname string) string {
+	if ifname == "" {
+		return "the default route"
+	}
+	return ifname
+}
+
+// replaceRoute routes the network cidr through ifname via router with metric,
+// in the dedicated table of the policy routing or of the configured VRF if
+// any.
+func (m *Manager) replaceRoute(cidr string, ifname string, router string, metric int) error {
+	m.log.Debug().Msgf("Replacing default route for network %s", cidr)
+
+	r := route.Route{
+		Dst:      cidr,
+		Gateway:  router,
+		Device:   ifname,
+		VRF:      m.vrf,
+		Table:    m.routingPolicy.table,
+		Metric:   metric,
+		Protocol: m.routeProto,
+		Realm:    m.routeRealm,
+		RTOMin:   m.hints.RTOMin,
+		QuickAck: m.hints.QuickAck,
+		InitCwnd: m.hints.InitCwnd,
+	}
+	args := []string{"route", "replace", cidr, router, ifname, m.vrf}
+	via := fmt.Sprintf("%s via %s dev %s", cidr, router, ifname)
+	if metric != 0 {
+		args = append(args, "metric", strconv.Itoa(metric))
+		via += fmt.Sprintf(" metric %d", metric)
+	}
+	// Owned before being installed, not to take its notification for
+	// another program's change.
+	m.routeOwner.own(r, nil)
+	_, err := m.routing(func() (string, error) { return "", m.routeManager.Replace(r) }, m.routingPolicy.routeArgs(args...)...)
+	if err != nil {
+		m.routeOwner.disown(cidr, ifname, metric)
+		m.log.Error().Msgf("failed to replace route: %s", err)
+		return fmt.Errorf("failed to replace route: %s", err)
+	}
+	m.changeLog.Record(changes.Route, "replaced", "%s", via)
+
+	return nil
+}
+
+// routing runs a routing table operation over rtnetlink from within the
+// configured network namespace, recorded and replayed as a run of "netlink"
+// with args describing it.
+func (m *Manager) routing(op func() (string, error), args ...string) (string, error) {
+	return m.operate("netlink", func() (string, error) {
+		var result string
+		err := netns.Do(m.namespace, func() error {
+			var err error
+			result, err = op()
+			return err
+		})
+		return result, err
+	}, args...)
+}
+
+// operate runs an in-process operation taking the place of an external
+// program and returns its result. Operations are timed, recorded and
+// replayed like the runs of external programs, as runs of program with args
+// describing them.
+func (m *Manager) operate(program string, op func() (string, error), args ...string) (string, error) {
+	if m.player != nil {
+		output, err := m.player.Exec(program, args...)
+		return string(output), err
+	}
+	if m.skipDryRun(program, args) {
+		return "", nil
+	}
+	m.beat()
+	start := time.Now()
+	result, err := op()
+	duration := time.Since(start)
+	if m.recorder != nil {
+		m.recorder.Exec(program, args, []byte(result), err, duration)
+	}
+	m.metrics.ObserveExec(program, duration, err != nil)
+	e := eventlog.Event{Kind: eventlog.KindCommand, Program: program, Args: args, DurationMillis: eventlog.Millis(duration)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	m.logEvent(e)
+	if m.slowExec > 0 && duration > m.slowExec {
+		m.log.Warn().Msgf("%s operation took %s, the system may be overloaded", program, duration.Round(time.Millisecond))
+	}
+	return result, err
+}
+
+// bindAll returns copies of targets bound to ifname, see endpoint.Bind.
+func bindAll(targets []endpoint.Endpoint, ifname string) []endpoint.Endpoint {
+	bound := make([]endpoint.Endpoint, len(targets))
+	for i, target := range targets {
+		bound[i] = target.Bind(ifname)
+	}
+	return bound
+}
+
+// applySysctls applies the sysctl tuning hints, saving the values they
+// replace. The keepalive sysctls are global: they apply to every TCP
+// connection of the host, whatever its link, until restored.
+func (m *Manager) applySysctls() {
+	for name, value := range m.hints.Sysctls() {
+		if _, ok := m.savedSysctls[name]; !ok {
+			previous, err := m.run("sysctl", "-n", name)
+			if value := strings.TrimSpace(string(previous)); err == nil && value != "" {
+				m.savedSysctls[name] = value
+			} else {
+				m.log.Warn().Msgf("Cannot read %s, it is not restored on failback: %v", name, err)
+			}
+		}
+		output, err := m.run("sysctl", "-w", name+"="+value)
+		if err != nil {
+			m.log.Error().Msgf("Failed to set %s to %s: %s, output: %s", name, value, err, strings.TrimSpace(string(output)))
+			continue
+		}
+		m.log.Debug().Msgf("Set %s to %s", name, value)
+		m.changeLog.Record(changes.Sysctl, "set", "%s=%s", name, value)
+	}
+}
+
+// restoreSysctls sets the sysctls changed on failover back to their
+// previous values.
+func (m *Manager) restoreSysctls() {
+	for name, value := range m.savedSysctls {
+		output, err := m.run("sysctl", "-w", name+"="+value)
+		if err != nil {
+			m.log.Error().Msgf("Failed to restore %s to %s: %s, output: %s", name, value, err, strings.TrimSpace(string(output)))
+			continue
+		}
+		m.log.Debug().Msgf("Restored %s to %s", name, value)
+		m.changeLog.Record(changes.Sysctl, "restored", "%s=%s", name, value)
+		delete(m.savedSysctls, name)
+	}
+}
+
+// logTransition logs a state transition together with a summary of the
+// changes made during it.
+func (m *Manager) logTransition(from string, to string) {
+	made := m.changeLog.Flush()
+	m.log.Info().
+		Ctx(severity.Context(severity.Critical)).
+		Str("from", from).
+		Str("to", to).
+		Interface("changes", made).
+		Msgf("Switched from %s to %s at %s, %s%s", from, to, timefmt.FormatIn(time.Now(), m.location), changes.Summary(made), m.dryRunNote())
+	m.decide(to, "switched from %s to %s, %s", from, to, changes.Summary(made))
+	m.logEvent(eventlog.Event{Kind: eventlog.KindSwitch, Interface: to, From: from, To: to, Changes: made})
+	m.archiveEvent(history.Event{Time: time.Now().UTC(), Kind: history.EventSwitch, From: from, To: to, Reason: changes.Summary(made)})
+	m.metrics.ObserveFailover(from, to, time.Now(), m.outages.ID())
+	m.switchAvailability(from, to)
+	m.lastSwitch = time.Now()
+	if m.statePublisher != nil {
+		go m.publishState()
+	}
+	m.noteActive(to)
+	m.metrics.SetActive(to)
+	m.notify(sdnotify.Status(fmt.Sprintf("Traffic over %s since %s", to, timefmt.FormatIn(time.Now(), m.location))))
+}
+
+// lockNames returns the names of the instance locks: the interfaces the
+// instance manages, those of --interfaces or the primary link and wifiIF,
+// prefixed with the network namespace, if any, so that instances managing
+// interfaces of the same name in different namespaces do not exclude each
+// other.
+func (m *Manager) lockNames(targets []endpoint.Endpoint, ifaces []string, wifiIF string) []string {
+	managed := ifaces
+	if len(managed) == 0 {
+		managed = []string{wifiIF}
+		if primary := m.routeDevice(primaryHost(targets, m.endpointNetworks(targets[:1]))); primary != "" && primary != wifiIF {
+			managed = append(managed, primary)
+		}
+	}
+	if m.vrf != "" {
+		managed = append(managed, m.vrf)
+	}
+	var names []string
+	for _, ifname := range managed {
+		if m.namespace != "" {
+			ifname = m.namespace + "-" + ifname
+		}
+		names = append(names, ifname)
+	}
+	return names
+}
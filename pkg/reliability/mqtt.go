@@ -0,0 +1,79 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/mqttstate"
+)
+
+// mqttStats is how far back the link statistics published over MQTT go.
+const mqttStats = time.Minute
+
+// publishState publishes the current state, if enabled.
+func (m *Manager) publishState() {
+	if m.statePublisher == nil {
+		return
+	}
+	status := m.controlStatus()
+	s := mqttstate.State{
+		Time:            time.Now().UTC(),
+		Site:            m.stateSite,
+		State:           status.State,
+		ActiveInterface: status.ActiveLink,
+	}
+	if status.ActiveLink == PrimaryLink && m.defaultIF != "" {
+		s.ActiveInterface = m.defaultIF
+	}
+	if !m.lastSwitch.IsZero() {
+		at := m.lastSwitch.UTC()
+		s.LastFailover = &at
+	}
+	recent, err := m.samples.Samples(s.Time.Add(-mqttStats))
+	if err != nil {
+		m.log.Warn().Msgf("Cannot read the recent probes for MQTT: %s", err)
+	}
+	s.Links = linkStats(recent)
+	if err := m.statePublisher.Publish(s); err != nil {
+		m.log.Warn().Msgf("Cannot publish the state over MQTT: %s", err)
+	}
+}
+
+// linkStats returns the mean RTT and the loss of the probes over each link.
+func linkStats(recent []history.Sample) map[string]mqttstate.Link {
+	counts := map[string]int{}
+	failures := map[string]int{}
+	rtts := map[string]time.Duration{}
+	for _, sample := range recent {
+		// Bufferbloat test results are not probes.
+		if sample.Grade != "" {
+			continue
+		}
+		link := LinkKey(sample.Interface)
+		counts[link]++
+		if sample.Success {
+			rtts[link] += sample.RTT
+		} else {
+			failures[link]++
+		}
+	}
+	links := map[string]mqttstate.Link{}
+	for link, count := range counts {
+		stats := mqttstate.Link{Samples: count, Loss: float64(failures[link]) / float64(count)}
+		if successes := count - failures[link]; successes > 0 {
+			stats.RTTMillis = float64(rtts[link]/time.Duration(successes)) / float64(time.Millisecond)
+		}
+		links[link] = stats
+	}
+	return links
+}
+
+// publishStateEvery publishes the state every interval.
+func (m *Manager) publishStateEvery(interval time.Duration) {
+	for range m.tick(interval) {
+		m.publishState()
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/shynuu/if-reliability/desktop"
+)
+
+// setupDesktopNotify enables the desktop notifications if --notify is set.
+// They go to --notify-user, by default the user who ran sudo or else the
+// one running the tool, on the session bus of that user.
+func (m *Manager) setupDesktopNotify() error {
+	if !m.config.Notify {
+		return nil
+	}
+	name := m.config.NotifyUser
+	if name == "" {
+		name = os.Getenv("SUDO_UID")
+	}
+	uid := os.Getuid()
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			if u, err = user.LookupId(name); err != nil {
+				return fmt.Errorf("unknown --notify-user %q", name)
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("invalid user ID %q of %s", u.Uid, name)
+		}
+	}
+	address := m.config.NotifyBus
+	if address == "" && uid == os.Getuid() {
+		address = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	}
+	if address == "" {
+		address = fmt.Sprintf("unix:path=/run/user/%d/bus", uid)
+	}
+	m.desktopNotifier = &desktop.Notifier{App: "if-reliability", Address: address, UID: uid}
+	m.log.Info().Msgf("Notifying the path changes on the desktop of user %d through %s", uid, address)
+	return nil
+}
+
+// notifyDesktop pops c on the desktop, if enabled, in the background.
+func (m *Manager) notifyDesktop(c pathChange) {
+	if m.desktopNotifier == nil {
+		return
+	}
+	from, to := LinkKey(c.from), LinkKey(c.to)
+	n := desktop.Notification{
+		Summary: "Failed over to " + to,
+		Body:    "Left " + from + ": " + c.reason,
+		Icon:    "network-error",
+		Urgency: desktop.Critical,
+	}
+	if c.event == pathFailback {
+		n = desktop.Notification{
+			Summary: "Back on " + to,
+			Body:    "Left " + from + ": " + c.reason,
+			Icon:    "network-transmit-receive",
+			Urgency: desktop.Normal,
+		}
+	}
+	go func() {
+		if err := m.desktopNotifier.Notify(n); err != nil {
+			m.log.Warn().Msgf("Cannot pop the desktop notification: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/peer"
+)
+
+// pairing makes two instances on two gateway hosts of a site elect which one
+// asserts the routes, so that the site survives the loss of a host as well
+// as that of an uplink. The standby keeps probing but neither fails over nor
+// back, and hands the virtual address of the site over to the master.
+type pairing struct {
+	m *Manager
+
+	node *peer.Node
+	// vip is the virtual address, in CIDR notation, the master holds on
+	// vipIF, none if empty.
+	vip   string
+	vipIF string
+}
+
+// setupPair joins the pair configured by the peer flags, if any.
+func (m *Manager) setupPair() error {
+	address := m.config.Peer
+	if address == "" {
+		return nil
+	}
+	n := &peer.Node{Health: m.pair.health}
+	n.Name = m.config.PeerName
+	if n.Name == "" {
+		n.Name, _ = os.Hostname()
+	}
+	if len(n.Name) > 32 {
+		return fmt.Errorf("--peer-name %q is longer than 32 bytes", n.Name)
+	}
+	priority := m.config.PeerPriority
+	if priority < 1 || priority > 255 {
+		return fmt.Errorf("--peer-priority %d must be between 1 and 255", priority)
+	}
+	n.Priority = uint8(priority)
+	n.Preempt = m.config.PeerPreempt
+	n.Interval = m.config.PeerInterval
+	n.DeadInterval = m.config.PeerDeadInterval
+	if n.DeadInterval == 0 {
+		n.DeadInterval = 3 * n.Interval
+	}
+	if n.Interval <= 0 || n.DeadInterval <= n.Interval {
+		return fmt.Errorf("--peer-dead-interval %s must be longer than --peer-interval %s", n.DeadInterval, n.Interval)
+	}
+	keyFile := m.config.PeerKeyFile
+	key, err := ReadKey(keyFile)
+	if err != nil {
+		return fmt.Errorf("reading peer key: %w", err)
+	}
+	n.Key = key
+	m.pair.vip = m.config.PeerVIP
+	m.pair.vipIF = m.config.PeerVIPInterface
+	if m.pair.vip != "" {
+		if _, _, err := net.ParseCIDR(m.pair.vip); err != nil {
+			return fmt.Errorf("invalid --peer-vip %q: it is an address in CIDR notation, e.g. 192.168.1.1/24", m.pair.vip)
+		}
+		if m.pair.vipIF == "" {
+			return fmt.Errorf("--peer-vip needs --peer-vip-interface")
+		}
+	}
+	listen := m.config.PeerListen
+	err = netns.Do(m.namespace, func() error {
+		return n.Listen(listen, address)
+	})
+	if err != nil {
+		return err
+	}
+	m.log.Info().Msgf("Pairing as %s with priority %d, heartbeats to %s from %s", n.Name, n.Priority, address, listen)
+	m.pair.node = n
+	return nil
+}
+
+// enabled reports whether the instance is part of a pair.
+func (p pairing) enabled() bool {
+	return p.node != nil
+}
+
+// standby reports whether the instance stands by for the master of its pair.
+func (p pairing) standby() bool {
+	return p.enabled() && !p.node.Master()
+}
+
+// start exchanges heartbeats with the peer and follows the elections in the
+// background. The instance stands by until elected.
+func (p pairing) start() {
+	p.m.metrics.SetPeerMaster(false)
+	p.release()
+	elections := make(chan peer.Change, 1)
+	go p.node.Run(elections)
+	go func() {
+		for c := range elections {
+			p.changed(c)
+		}
+	}()
+}
+
+// changed applies the outcome of an election.
+func (p pairing) changed(c peer.Change) {
+	p.m.metrics.SetPeerMaster(c.Master)
+	if c.Master {
+		p.m.log.Warn().Msgf("Master of the pair, %s: asserting the routes", c.Reason)
+		p.m.decide(p.m.activeLink, "master of the pair, %s", c.Reason)
+		p.claim()
+		return
+	}
+	p.m.log.Warn().Msgf("Standby of the pair, %s: automatic failover and failback suspended", c.Reason)
+	p.m.decide(p.m.activeLink, "standby of the pair, %s", c.Reason)
+	p.release()
+	if p.m.activeCascade != nil {
+		return
+	}
+	if current, _ := p.m.machine.State(); current == fsm.OnBackup || current == fsm.Recovering {
+		p.m.log.Warn().Msg("Failing back to withdraw the failover routes while standing by")
+		if err := p.m.runCommand(control.CommandFailback); err != nil {
+			p.m.log.Error().Msgf("Cannot fail back while standing by: %s", err)
+		}
+	}
+}
+
+// claim adds the virtual address on the master and announces it, so that
+// the hosts of the site send their traffic to it.
+func (p pairing) claim() {
+	if p.vip == "" {
+		return
+	}
+	_, err := p.m.routing(func() (string, error) { return "", p.m.routeManager.AddAddress(p.vipIF, p.vip) }, "address", "add", p.vip, "dev", p.vipIF)
+	if err != nil {
+		p.m.log.Error().Msgf("Error adding the virtual address %s to %s: %s", p.vip, p.vipIF, err)
+		return
+	}
+	p.m.log.Info().Msgf("Virtual address %s added to %s", p.vip, p.vipIF)
+	p.m.changeLog.Record(changes.Address, "added", "%s on %s", p.vip, p.vipIF)
+	ip, _, _ := strings.Cut(p.vip, "/")
+	if net.ParseIP(ip).To4() == nil {
+		// The kernel announces new IPv6 addresses with unsolicited
+		// neighbor advertisements.
+		return
+	}
+	if output, err := p.m.run("arping", "-U", "-c", "3", "-I", p.vipIF, ip); err != nil {
+		p.m.log.Warn().Msgf("Cannot announce %s with gratuitous ARP, the hosts of the site learn it as their ARP entries expire: %s", ip, strings.TrimSpace(string(output)))
+	}
+}
+
+// release removes the virtual address, if the instance holds it.
+func (p pairing) release() {
+	if p.vip == "" {
+		return
+	}
+	var removed bool
+	_, err := p.m.routing(func() (string, error) {
+		var err error
+		removed, err = p.m.routeManager.DeleteAddress(p.vipIF, p.vip)
+		return "", err
+	}, "address", "del", p.vip, "dev", p.vipIF)
+	if err != nil {
+		p.m.log.Error().Msgf("Error removing the virtual address %s from %s: %s", p.vip, p.vipIF, err)
+		return
+	}
+	if removed {
+		p.m.log.Info().Msgf("Virtual address %s removed from %s", p.vip, p.vipIF)
+		p.m.changeLog.Record(changes.Address, "removed", "%s on %s", p.vip, p.vipIF)
+	}
+}
+
+// health returns the health the instance advertises to its peer: healthy
+// while its primary link carries the traffic without outage, degraded while
+// a backup link does, down otherwise.
+func (p pairing) health() peer.Health {
+	if p.m.activeCascade != nil {
+		switch p.m.activeLink {
+		case PrimaryLink:
+			return peer.Healthy
+		case "":
+			return peer.Down
+		}
+		return peer.Degraded
+	}
+	switch current, _ := p.m.machine.State(); current {
+	case fsm.MonitoringPrimary:
+		if p.m.outages.ID() == "" {
+			return peer.Healthy
+		}
+	case fsm.OnBackup, fsm.Recovering:
+		return peer.Degraded
+	}
+	return peer.Down
+}
+
+// status returns the role of the instance for the status, nil outside of a
+// pair.
+func (p pairing) status() *control.Pair {
+	if !p.enabled() {
+		return nil
+	}
+	s := &control.Pair{Master: p.node.Master()}
+	if a, alive := p.node.Peer(); a.Name != "" {
+		s.Peer, s.PeerAlive, s.PeerHealth, s.PeerMaster = a.Name, alive, a.Health.String(), a.Master
+	}
+	return s
+}
+
+// PairRole names the role of an instance in its pair.
+func PairRole(master bool) string {
+	if master {
+		return "master"
+	}
+	return "standby"
+}
+
+// DescribePeer describes the other instance of a pair, e.g. "peer gw2
+// healthy standby".
+func DescribePeer(p control.Pair) string {
+	switch {
+	case p.Peer == "":
+		return "peer never heard from"
+	case !p.PeerAlive:
+		return "peer " + p.Peer + " down"
+	}
+	return "peer " + p.Peer + " " + p.PeerHealth + " " + PairRole(p.PeerMaster)
+}
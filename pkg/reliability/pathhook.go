@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"strings"
+)
+
+// Path change events.
+const (
+	pathFailover = "failover"
+	pathFailback = "failback"
+)
+
+// pathChange is the traffic moving from one interface to another.
+type pathChange struct {
+	event string
+	from  string
+	to    string
+	// gateway is the router of the new interface, empty if unknown.
+	gateway string
+	reason  string
+}
+
+// runPathHook pops c on the desktop and alerts it, if enabled, starts the discovery of the
+// path MTU over the new interface, if enabled, and runs the executable of the
+// event of c, if any, with c in its environment. The executable runs to
+// completion before the monitor goes on, so that the hook sees the new routes
+// and has its work done before the next change.
+func (m *Manager) runPathHook(c pathChange) {
+	m.notifyDesktop(c)
+	m.alertPath(c)
+	go m.pathMTU.adjust(c.to)
+	path := m.onFailover
+	if c.event == pathFailback {
+		path = m.onFailback
+	}
+	if path == "" {
+		return
+	}
+	env := []string{
+		"IF_RELIABILITY_EVENT=" + c.event,
+		"IF_RELIABILITY_OLD_INTERFACE=" + c.from,
+		"IF_RELIABILITY_NEW_INTERFACE=" + c.to,
+		"IF_RELIABILITY_GATEWAY=" + c.gateway,
+		"IF_RELIABILITY_REASON=" + c.reason,
+	}
+	output, err := m.run("env", append(env, path)...)
+	if err != nil {
+		m.log.Error().Msgf("%s hook %s failed: %s, output: %s", c.event, path, err, strings.TrimSpace(string(output)))
+		return
+	}
+	m.log.Debug().Msgf("%s hook %s: %s", c.event, path, strings.TrimSpace(string(output)))
+}
+
+// gatewayOf returns the default router of ifname, or an empty string if it
+// is unknown.
+func (m *Manager) gatewayOf(ifname string) string {
+	if ifname == "" {
+		return ""
+	}
+	router, err := m.defaultRouter(ifname)
+	if err != nil {
+		m.log.Debug().Msgf("Cannot read the default router of %s: %s", ifname, err)
+	}
+	return router
+}
@@ -0,0 +1,384 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// plan describes what the monitor configured by the config of its manager
+// would do on failover and failback, from the interfaces and routes as they
+// are now.
+type plan struct {
+	m *Manager
+
+	targets   []endpoint.Endpoint
+	wifiIF    string
+	primaryIF string
+	networks  []string
+	// startup are the actions of the monitor starting, steps those of the
+	// failover and undo those of the failback.
+	startup []string
+	steps   []string
+	undo    []string
+}
+
+// start adds an action to the startup.
+func (p *plan) start(format string, args ...interface{}) {
+	p.startup = append(p.startup, fmt.Sprintf(format, args...))
+}
+
+// step adds an action to the failover.
+func (p *plan) step(format string, args ...interface{}) {
+	p.steps = append(p.steps, fmt.Sprintf(format, args...))
+}
+
+// back adds an action to the failback.
+func (p *plan) back(format string, args ...interface{}) {
+	p.undo = append(p.undo, fmt.Sprintf(format, args...))
+}
+
+// snapshot prints the default routes and the interface the moved networks
+// go through now, and finds the primary link as the monitor does.
+func (p *plan) snapshot() {
+	fmt.Println("Current routes:")
+	routes, err := p.m.defaultRoutes()
+	switch {
+	case err != nil:
+		fmt.Printf("  cannot read the default routes: %s\n", err)
+	case len(routes) == 0:
+		fmt.Println("  no default route")
+	default:
+		for _, line := range strings.Split(describeRoutes(routes), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	p.networks = p.m.movedNetworks(p.targets)
+	for _, cidr := range p.networks {
+		host, _, _ := strings.Cut(cidr, "/")
+		if net.ParseIP(host).IsUnspecified() {
+			// A default prefix is among the default routes above.
+			continue
+		}
+		device := p.m.routeDevice(host)
+		if device == "" {
+			device = "no route"
+		}
+		fmt.Printf("  %s through %s\n", cidr, device)
+	}
+	// The primary link is the interface of the route toward the first
+	// endpoint, or toward its first network if it is a host name.
+	host := p.targets[0].Host
+	if net.ParseIP(host) == nil {
+		if networks := p.m.endpointNetworks(p.targets[:1]); len(networks) > 0 {
+			host, _, _ = strings.Cut(networks[0], "/")
+		}
+	}
+	p.primaryIF = p.m.routeDevice(host)
+	fmt.Println()
+}
+
+// connection plans how the backup interface is brought up: the cold spare
+// powered up, and the NetworkManager profile activated or created.
+func (p *plan) connection() {
+	spare := coldSpare{m: p.m}
+	spare.rfkill = p.m.config.ColdSpareRFKill
+	spare.powerCmd = p.m.config.ColdSparePowerCommand
+	if spare.rfkill != "" {
+		p.step("unblock the rfkill device %s and bring %s up", spare.rfkill, p.wifiIF)
+	}
+	if spare.powerCmd != "" {
+		p.step("power %s up with: %s", p.wifiIF, spare.powerCmd)
+	}
+	if p.m.wired(p.wifiIF) {
+		p.step("activate %s through NetworkManager and wait for its carrier and DHCP lease", p.wifiIF)
+		if p.m.minLinkSpeed > 0 {
+			p.step("refuse %s if it negotiated less than %d Mbit/s", p.wifiIF, p.m.minLinkSpeed)
+		}
+		return
+	}
+	ssid := p.m.config.WiFiSSID
+	networks := []wifi.Network{{SSID: ssid}}
+	fallbacks := p.m.config.WiFiFallbacks
+	for _, text := range fallbacks {
+		if network, err := wifi.ParseNetwork(text); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	eap := p.m.config.WiFiEAP
+	for i, network := range networks {
+		var name string
+		err := p.m.runNM(func(c WiFiManager) error {
+			var err error
+			name, err = c.Profile(network.SSID)
+			return err
+		}, "profile", network.SSID)
+		var how string
+		switch {
+		case err != nil:
+			how = fmt.Sprintf("NetworkManager profiles unreadable: %s", err)
+		case name != "":
+			how = fmt.Sprintf("activating the existing NetworkManager profile %q as is", name)
+		case i == 0 && eap != "":
+			how = fmt.Sprintf("creating the NetworkManager profile %q with 802.1X %s", network.SSID, eap)
+		case network.Password != "" || i == 0:
+			how = fmt.Sprintf("creating the NetworkManager profile %q with WPA-PSK", network.SSID)
+		default:
+			how = fmt.Sprintf("creating the open NetworkManager profile %q", network.SSID)
+		}
+		if i == 0 {
+			p.step("connect %s to %s, %s", p.wifiIF, network, how)
+		} else {
+			p.step("if it fails, connect %s to the fallback network %s, %s", p.wifiIF, network, how)
+		}
+	}
+}
+
+// routes plans the routes moved through the backup interface, via its
+// default routers as they are now.
+func (p *plan) routes() {
+	if len(p.networks) == 0 {
+		p.step("move no route: no endpoint network resolved and no --route-prefix")
+		return
+	}
+	routers, err := p.m.defaultRouters(p.wifiIF)
+	if err != nil {
+		p.m.log.Warn().Msgf("Cannot read the default routers of %s: %s", p.wifiIF, err)
+		routers = map[int]string{}
+	}
+	if _, ok := routers[p.m.families()[0]]; !ok {
+		// The router only comes with the lease the failover obtains.
+		routers[p.m.families()[0]] = "<DHCP router>"
+	}
+	table := ""
+	if p.m.routingPolicy.enabled() {
+		table = " table " + strconv.Itoa(p.m.routingPolicy.table)
+		for _, r := range p.m.routingPolicy.rules() {
+			p.start("look %s traffic%s up in table %d at priority %d", familyName(r.Family), ruleMatch(r), r.Table, r.Priority)
+		}
+	}
+	if p.m.vrf != "" {
+		table = " vrf " + p.m.vrf
+	}
+	for _, cidr := range p.networks {
+		router, ok := routers[cidrFamily(cidr)]
+		if !ok {
+			p.step("leave %s on %s: no %s default router on %s", cidr, LinkName(p.primaryIF), familyName(cidrFamily(cidr)), p.wifiIF)
+			continue
+		}
+		if p.m.routeMetrics.enabled() {
+			p.start("route %s through %s at metric %d, and through %s at metric %d once it has a default router", cidr, LinkName(p.primaryIF), p.m.routeMetrics.primary, p.wifiIF, p.m.routeMetrics.backup)
+			p.step("route %s via %s dev %s%s metric %d, below the primary route at metric %d, and remove the backup route at metric %d",
+				cidr, router, p.wifiIF, table, p.m.routeMetrics.failover, p.m.routeMetrics.primary, p.m.routeMetrics.backup)
+			p.back("route %s via %s dev %s%s metric %d again and remove the route at metric %d", cidr, router, p.wifiIF, table, p.m.routeMetrics.backup, p.m.routeMetrics.failover)
+			continue
+		}
+		metric := ""
+		if m := p.m.prefixMetrics[cidr]; m != 0 {
+			metric = " metric " + strconv.Itoa(m)
+		}
+		p.step("route %s via %s dev %s%s%s, in place of the route through %s", cidr, router, p.wifiIF, table, metric, LinkName(p.primaryIF))
+		p.back("remove the route toward %s through %s", cidr, p.wifiIF)
+	}
+	if len(p.m.routePrefixes) > 0 {
+		p.step("roll all the prefixes back if one cannot be routed, and stay on %s", LinkName(p.primaryIF))
+	}
+}
+
+// ruleMatch renders the selectors of a policy routing rule.
+func ruleMatch(r route.Rule) string {
+	var match string
+	if r.Src != "" {
+		match += " from " + r.Src
+	}
+	if r.Mark != 0 {
+		match += fmt.Sprintf(" marked 0x%x", r.Mark)
+		if r.Mask != 0 {
+			match += fmt.Sprintf("/0x%x", r.Mask)
+		}
+	}
+	return match
+}
+
+// system plans the changes beyond routing: sysctls, time sources, DNS,
+// firewall rules and the hooks run.
+func (p *plan) system() {
+	sysctls := p.m.hints.Sysctls()
+	names := make([]string, 0, len(sysctls))
+	for name := range sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.step("set the sysctl %s=%s", name, sysctls[name])
+	}
+	var chrony chronyPolicy
+	chrony.primary = p.m.config.ChronyPrimaryServers
+	chrony.backup = p.m.config.ChronyBackupServers
+	chrony.stratum = p.m.config.ChronyFailoverStratum
+	if len(chrony.backup) > 0 {
+		p.step("add the NTP sources %s to chrony", strings.Join(chrony.backup, ", "))
+	}
+	if len(chrony.primary) > 0 {
+		p.step("take the NTP sources %s offline", strings.Join(chrony.primary, ", "))
+	}
+	if chrony.stratum > 0 {
+		p.step("advertise the local stratum %d", chrony.stratum)
+	}
+	if chrony.enabled() {
+		p.back("restore the chrony sources")
+	}
+	dnsMode := p.m.config.DNSMode
+	dnsServers := p.m.config.DNSServers
+	resolvConf := p.m.config.ResolvConf
+	switch dnsMode {
+	case dnsResolvConf:
+		servers := "the DNS servers NetworkManager learned on " + p.wifiIF
+		if len(dnsServers) > 0 {
+			servers = strings.Join(dnsServers, ", ")
+		}
+		p.step("point %s to %s", resolvConf, servers)
+		p.back("restore %s", resolvConf)
+	case dnsResolved:
+		p.step("make %s the default DNS route of systemd-resolved in place of %s and flush its caches", p.wifiIF, LinkName(p.primaryIF))
+		p.back("make %s the default DNS route of systemd-resolved again", LinkName(p.primaryIF))
+	}
+	rules := p.m.config.FirewallRules
+	if fw, err := p.m.newFirewallPolicy(rules); err == nil && fw.enabled() {
+		p.step("move the %d firewall rules from %s to %s", len(fw.rules), LinkName(p.primaryIF), p.wifiIF)
+		p.back("move the firewall rules back to %s", LinkName(p.primaryIF))
+	}
+	switch mode := p.m.config.ConntrackFlush; mode {
+	case conntrackAll:
+		p.step("flush every connection tracking entry")
+		p.back("flush every connection tracking entry")
+	case conntrackEgress:
+		p.step("flush the connection tracking entries of the flows through %s", LinkName(p.primaryIF))
+		p.back("flush the connection tracking entries of the flows through %s", p.wifiIF)
+	}
+	if onFailover := p.m.config.OnFailover; onFailover != "" {
+		p.step("run %s", onFailover)
+	}
+	if onFailback := p.m.config.OnFailback; onFailback != "" {
+		p.back("run %s", onFailback)
+	}
+	hooks := p.m.config.Hooks
+	for _, hook := range hooks {
+		state, command, _ := strings.Cut(hook, "=")
+		p.step("run on entering %s: %s", state, command)
+	}
+}
+
+// report prints the failover and failback plans.
+func (p *plan) report() {
+	fmt.Printf("Monitoring %s over %s, failing over to %s.\n\n", endpointList(p.targets), LinkName(p.primaryIF), p.wifiIF)
+	if len(p.startup) > 0 {
+		fmt.Println("On startup:")
+		for i, s := range p.startup {
+			fmt.Printf("  %d. %s\n", i+1, s)
+		}
+		fmt.Println()
+	}
+	fmt.Println("On failover:")
+	for i, s := range p.steps {
+		fmt.Printf("  %d. %s\n", i+1, s)
+	}
+	fmt.Println()
+	if failback := p.m.config.Failback; !failback {
+		fmt.Printf("No failback: the traffic stays on %s until a manual failback or exit.\n", p.wifiIF)
+		return
+	}
+	fmt.Println("On failback:")
+	for i, s := range p.undo {
+		fmt.Printf("  %d. %s\n", i+1, s)
+	}
+	rfkill := p.m.config.ColdSpareRFKill
+	powerCmd := p.m.config.ColdSparePowerCommand
+	if rfkill != "" || powerCmd != "" {
+		fmt.Printf("  %d. disconnect %s and park it again\n", len(p.undo)+1, p.wifiIF)
+	}
+}
+
+// Plan prints what the monitor configured by config would do on failover and
+// failback from the interfaces and routes as they are now: the routes
+// replaced and the gateway used, the NetworkManager profile activated or
+// created, and the DNS, time, firewall and hook changes. Nothing is changed.
+func Plan(config Config) error {
+	return newManager(config).plan()
+}
+
+// plan runs Plan.
+func (m *Manager) plan() error {
+	// Nothing is changed, even should a plan step call an operation
+	// that would.
+	m.dryRun = true
+	family := m.config.IPFamily
+	if err := m.setFamily(family); err != nil {
+		return fmt.Errorf("parsing --ip-family: %w", err)
+	}
+	m.vrf = m.config.VRF
+	p := &plan{m: m}
+	p.wifiIF = m.config.WiFiInterface
+	typeList := m.config.InterfaceTypes
+	types, err := parseInterfaceTypes(typeList)
+	if err != nil {
+		return fmt.Errorf("parsing --interface-type: %w", err)
+	}
+	m.wiredIFs = types
+	m.minLinkSpeed = m.config.MinLinkSpeed
+	if ifaces := m.config.Interfaces; len(ifaces) > 0 {
+		m.log.Warn().Msgf("--interfaces is set, the plan only covers the failover to --wifi-if %s", p.wifiIF)
+	}
+	endpoints := m.config.Endpoints
+	if len(endpoints) == 0 {
+		if endpoints, err = m.discoverEndpoints(); err != nil {
+			return fmt.Errorf("no --endpoint given and none discovered: %w", err)
+		}
+	}
+	if p.targets, err = endpoint.ParseList(endpoints); err != nil {
+		return fmt.Errorf("parsing endpoint: %w", err)
+	}
+	if len(p.targets) == 0 {
+		return errors.New("no endpoint to plan for")
+	}
+	m.prefixLen[route.IPv4] = m.config.IPv4Prefix
+	m.prefixLen[route.IPv6] = m.config.IPv6Prefix
+	prefixes := m.config.Networks
+	if err := m.setPrefixes(prefixes); err != nil {
+		return fmt.Errorf("parsing --route-prefix: %w", err)
+	}
+	failoverMode := m.config.FailoverMode
+	primaryMetric := m.config.PrimaryMetric
+	backupMetric := m.config.BackupMetric
+	failoverMetric := m.config.FailoverMetric
+	if m.routeMetrics, err = m.newMetricPolicy(failoverMode, primaryMetric, backupMetric, failoverMetric); err != nil {
+		return fmt.Errorf("parsing --failover-mode: %w", err)
+	}
+	routeTable := m.config.RouteTable
+	rulePriority := m.config.RulePriority
+	ruleFwmark := m.config.RuleFwmark
+	ruleFrom := m.config.RuleFrom
+	ruleCgroups := m.config.RuleCgroups
+	if m.routingPolicy, err = m.newPolicyRouting(routeTable, rulePriority, ruleFwmark, ruleFrom, ruleCgroups); err != nil {
+		return fmt.Errorf("invalid policy routing: %w", err)
+	}
+	m.hints.KeepaliveTime = m.config.TCPKeepaliveTime
+	m.hints.KeepaliveInterval = m.config.TCPKeepaliveInterval
+	m.hints.KeepaliveProbes = m.config.TCPKeepaliveProbes
+	p.snapshot()
+	p.connection()
+	p.routes()
+	p.system()
+	p.report()
+	return nil
+}
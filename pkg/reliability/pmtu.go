@@ -0,0 +1,235 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/firewall"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/pmtu"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// Path MTU modes.
+const (
+	pmtuProbe = "probe"
+	pmtuMTU   = "mtu"
+	pmtuClamp = "clamp"
+)
+
+// pmtuAttempts is the number of probes of one size before concluding that
+// it does not fit, so that a lost probe is not taken for a black hole.
+const pmtuAttempts = 2
+
+// pmtuPolicy discovers the path MTU toward the first endpoint over the
+// interface the traffic moved to, and matches it: by lowering the MTU of the
+// interface, or by clamping the MSS of the TCP connections leaving through
+// it, so that large packets are not black-holed on a path with a smaller MTU
+// than the interface, e.g. LTE or a tunnel. It is disabled if mode is empty.
+type pmtuPolicy struct {
+	m *Manager
+
+	mode   string
+	target string
+	ipv6   bool
+
+	// mtus are the MTUs of the interfaces before they were lowered, clamp
+	// the command deleting the clamp rule, if installed, and stopped set
+	// once they are restored on exit.
+	mu      sync.Mutex
+	mtus    map[string]int
+	clamp   []string
+	stopped bool
+}
+
+// newPMTUPolicy returns the path MTU policy of mode: off, probe, mtu or
+// clamp, probing target.
+func (m *Manager) newPMTUPolicy(mode string, target string) (*pmtuPolicy, error) {
+	p := &pmtuPolicy{m: m, target: target, ipv6: m.families()[0] == route.IPv6, mtus: map[string]int{}}
+	switch mode {
+	case "off":
+	case pmtuProbe, pmtuMTU, pmtuClamp:
+		p.mode = mode
+	default:
+		return p, fmt.Errorf("unknown path MTU mode %q (off, %s, %s or %s)", mode, pmtuProbe, pmtuMTU, pmtuClamp)
+	}
+	return p, nil
+}
+
+// enabled reports whether the path MTU is discovered on switches.
+func (p *pmtuPolicy) enabled() bool {
+	return p.mode != ""
+}
+
+// interfaceMTU returns the MTU of ifname.
+func (m *Manager) interfaceMTU(ifname string) (int, error) {
+	var mtu int
+	err := netns.Do(m.namespace, func() error {
+		iface, err := net.InterfaceByName(ifname)
+		if err != nil {
+			return err
+		}
+		mtu = iface.MTU
+		return nil
+	})
+	return mtu, err
+}
+
+// discover returns the path MTU toward the target over ifname, at most the
+// MTU of the interface before it was lowered.
+func (p *pmtuPolicy) discover(ifname string) (int, error) {
+	p.mu.Lock()
+	high, ok := p.mtus[ifname]
+	p.mu.Unlock()
+	if !ok {
+		var err error
+		if high, err = p.m.interfaceMTU(ifname); err != nil {
+			p.m.log.Debug().Msgf("Cannot read the MTU of %s, probing up to 1500 bytes: %s", ifname, err)
+			high = 1500
+		}
+	}
+	// The largest IP packet, below the MTU of the loopback.
+	high = min(high, 65535)
+	low := pmtu.Min(p.ipv6)
+	network := "ip4"
+	if p.ipv6 {
+		network = "ip6"
+	}
+	fits := func(size int) bool {
+		prober := &probe.ICMP{Timeout: p.m.pinger.Timeout, PayloadSize: pmtu.PayloadSize(size, p.ipv6), Network: network, DontFragment: true}
+		for attempt := 0; attempt < pmtuAttempts; attempt++ {
+			result := p.m.probeFrom(prober, p.target, ifname)
+			if result.OK() {
+				return true
+			}
+			if errors.Is(result.Err, probe.ErrTooBig) {
+				return false
+			}
+		}
+		return false
+	}
+	if !fits(low) {
+		return 0, fmt.Errorf("%s does not answer %d-byte probes over %s", p.target, low, ifname)
+	}
+	return pmtu.Search(low, high, fits), nil
+}
+
+// adjust discovers the path MTU over ifname, the interface the traffic moved
+// to, and matches it. It is meant to run in the background, not to delay the
+// switch.
+func (p *pmtuPolicy) adjust(ifname string) {
+	if !p.enabled() || ifname == "" {
+		return
+	}
+	start := time.Now()
+	mtu, err := p.discover(ifname)
+	if err != nil {
+		p.m.log.Warn().Msgf("Cannot discover the path MTU over %s: %s", ifname, err)
+		return
+	}
+	p.m.metrics.SetPathMTU(ifname, mtu)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	current, err := p.m.interfaceMTU(ifname)
+	if err != nil {
+		current = 0
+	}
+	p.m.log.Info().Msgf("Path MTU toward %s over %s: %d bytes, found in %s", p.target, ifname, mtu, time.Since(start).Round(time.Millisecond))
+	switch p.mode {
+	case pmtuMTU:
+		original, lowered := p.mtus[ifname]
+		if !lowered {
+			original = current
+		}
+		if mtu == current || current == 0 && !lowered {
+			return
+		}
+		if err := p.m.setMTU(ifname, mtu); err != nil {
+			p.m.log.Error().Msgf("Failed to set the MTU of %s to %d: %s", ifname, mtu, err)
+			return
+		}
+		if mtu == original {
+			delete(p.mtus, ifname)
+		} else {
+			p.mtus[ifname] = original
+		}
+	case pmtuClamp:
+		p.removeClamp()
+		if current != 0 && mtu >= current {
+			return
+		}
+		p.addClamp(ifname, pmtu.MSS(mtu, p.ipv6))
+	}
+}
+
+// setMTU sets the MTU of ifname.
+func (m *Manager) setMTU(ifname string, mtu int) error {
+	if output, err := m.run("ip", "link", "set", "dev", ifname, "mtu", strconv.Itoa(mtu)); err != nil {
+		return fmt.Errorf("%s, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	m.changeLog.Record(changes.Link, "set", "MTU of %s to %d", ifname, mtu)
+	return nil
+}
+
+// addClamp clamps the MSS of the TCP connections leaving through ifname to
+// mss. The caller holds the lock.
+func (p *pmtuPolicy) addClamp(ifname string, mss int) {
+	program := "iptables"
+	if p.ipv6 {
+		program = "ip6tables"
+	}
+	command := []string{program, "-t", "mangle", "-A", "POSTROUTING", "-o", ifname, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--set-mss", strconv.Itoa(mss)}
+	output, err := p.m.run(command[0], command[1:]...)
+	if err != nil {
+		p.m.log.Error().Msgf("Failed to clamp the MSS over %s: %s: %s, output: %s", ifname, strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+		return
+	}
+	p.clamp, _ = firewall.Undo(command, string(output))
+	p.m.changeLog.Record(changes.Firewall, "added", "MSS clamp to %d over %s", mss, ifname)
+	p.m.log.Info().Msgf("MSS of the TCP connections over %s clamped to %d", ifname, mss)
+}
+
+// removeClamp deletes the clamp rule, if installed. The caller holds the
+// lock.
+func (p *pmtuPolicy) removeClamp() {
+	if p.clamp == nil {
+		return
+	}
+	if output, err := p.m.run(p.clamp[0], p.clamp[1:]...); err != nil {
+		p.m.log.Error().Msgf("Failed to delete the MSS clamp: %s: %s, output: %s", strings.Join(p.clamp, " "), err, strings.TrimSpace(string(output)))
+	} else {
+		p.m.changeLog.Record(changes.Firewall, "removed", "MSS clamp")
+	}
+	p.clamp = nil
+}
+
+// restore puts back the MTUs of the interfaces it lowered and deletes the
+// clamp rule, on exit.
+func (p *pmtuPolicy) restore() {
+	if !p.enabled() {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ifname, mtu := range p.mtus {
+		if err := p.m.setMTU(ifname, mtu); err != nil {
+			p.m.log.Error().Msgf("Failed to restore the MTU of %s to %d: %s", ifname, mtu, err)
+		}
+	}
+	p.mtus = map[string]int{}
+	p.removeClamp()
+	p.stopped = true
+}
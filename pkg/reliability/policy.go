@@ -0,0 +1,257 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// defaultRulePriority is the priority of the rules looking up the dedicated
+// table, ahead of the drain rule and of the main table.
+const defaultRulePriority = 7600
+
+// classChain is the iptables chain marking the traffic of the classified
+// cgroups in the mangle table, and masquerading the marked traffic in the
+// nat table.
+const classChain = "IF_RELIABILITY_CLASS"
+
+// policyRouting installs the failover routes in a dedicated table looked up
+// through ip rules, leaving the main table to the other daemons
+// (NetworkManager, dhcpcd). It is disabled if table is 0.
+type policyRouting struct {
+	m *Manager
+
+	table    int
+	priority int
+	// mark and mask restrict the rules to traffic with a firewall mark,
+	// and from to source networks. The rules match all traffic otherwise:
+	// lookups of networks missing from the table go on to the main table.
+	mark uint32
+	mask uint32
+	from []string
+	// cgroups are the cgroups whose traffic is marked, so that only it
+	// follows the failover routes: cgroup v2 paths or net_cls class IDs.
+	cgroups []string
+}
+
+// newPolicyRouting returns the policy routing into table at priority, for
+// traffic marked with fwmark (mark[/mask]) if not empty and from the source
+// networks if any. The traffic of cgroups, if any, is marked with fwmark.
+func (m *Manager) newPolicyRouting(table, priority int, fwmark string, from, cgroups []string) (policyRouting, error) {
+	p := policyRouting{m: m, table: table, priority: priority, from: from, cgroups: cgroups}
+	if table == 0 {
+		if fwmark != "" || len(from) > 0 || len(cgroups) > 0 {
+			return p, fmt.Errorf("--rule-fwmark, --rule-from and --rule-cgroup need --route-table")
+		}
+		return p, nil
+	}
+	if len(cgroups) > 0 && fwmark == "" {
+		return p, fmt.Errorf("--rule-cgroup needs --rule-fwmark, the mark set on the traffic of the cgroups")
+	}
+	for _, cgroup := range cgroups {
+		if _, err := cgroupMatch(cgroup); err != nil {
+			return p, err
+		}
+	}
+	switch {
+	case table < 0 || int64(table) > 0xffffffff:
+		return p, fmt.Errorf("invalid table %d", table)
+	case table >= 253 && table <= 255:
+		return p, fmt.Errorf("table %d is reserved for the default, main and local tables", table)
+	case table == drainTable:
+		return p, fmt.Errorf("table %d is used for draining", table)
+	case priority <= 0 || priority >= 32766:
+		return p, fmt.Errorf("invalid rule priority %d: it must be below the main table's, 32766", priority)
+	}
+	if fwmark != "" {
+		mark, mask, masked := strings.Cut(fwmark, "/")
+		value, err := strconv.ParseUint(mark, 0, 32)
+		if err != nil || value == 0 {
+			return p, fmt.Errorf("invalid fwmark %q", fwmark)
+		}
+		p.mark = uint32(value)
+		if masked {
+			value, err := strconv.ParseUint(mask, 0, 32)
+			if err != nil || value == 0 {
+				return p, fmt.Errorf("invalid fwmark mask %q", fwmark)
+			}
+			p.mask = uint32(value)
+		}
+	}
+	for _, cidr := range from {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return p, fmt.Errorf("invalid source network %q: %w", cidr, err)
+		}
+		if !m.familyEnabled(familyOf(ip)) {
+			return p, fmt.Errorf("source network %s is an %s network, not enabled by --ip-family", cidr, familyName(familyOf(ip)))
+		}
+	}
+	return p, nil
+}
+
+// enabled reports whether the failover routes go to a dedicated table.
+func (p policyRouting) enabled() bool {
+	return p.table != 0
+}
+
+// rules returns the rules of the enabled families: one per source network of
+// the family, or a single one without source networks.
+func (p policyRouting) rules() []route.Rule {
+	var rules []route.Rule
+	for _, family := range p.m.families() {
+		rule := route.Rule{Family: family, Table: p.table, Priority: p.priority, Mark: p.mark, Mask: p.mask}
+		if len(p.from) == 0 {
+			rules = append(rules, rule)
+			continue
+		}
+		for _, cidr := range p.from {
+			if cidrFamily(cidr) == family {
+				rule.Src = cidr
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// install replaces the rules left at the priority, e.g. by a previous run,
+// with the rules looking up the table.
+func (p policyRouting) install() error {
+	p.remove()
+	for _, r := range p.rules() {
+		args := []string{"rule", "add", familyName(r.Family), r.Src, strconv.FormatUint(uint64(r.Mark), 10), strconv.FormatUint(uint64(r.Mask), 10), strconv.Itoa(r.Table), strconv.Itoa(r.Priority)}
+		if _, err := p.m.routing(func() (string, error) { return "", p.m.routeManager.AddRule(r) }, args...); err != nil {
+			return fmt.Errorf("failed to add the %s rule toward table %d: %w", familyName(r.Family), r.Table, err)
+		}
+	}
+	p.m.changeLog.Record(changes.Route, "added", "rules toward table %d at priority %d", p.table, p.priority)
+	p.m.log.Info().Msgf("Failover routes go to table %d, looked up at priority %d", p.table, p.priority)
+	if len(p.cgroups) > 0 {
+		return p.classify()
+	}
+	return nil
+}
+
+// classify marks the traffic the processes of the cgroups send, in
+// classChain hooked to the mangle OUTPUT chain, so that it is routed again
+// through the table. The marked traffic is masqueraded, in classChain hooked
+// to the nat POSTROUTING chain, as it leaves with the source address chosen
+// for the main table.
+func (p policyRouting) classify() error {
+	p.m.removeClasses()
+	mark := fmt.Sprintf("0x%x", p.mark)
+	if p.mask != 0 {
+		mark += fmt.Sprintf("/0x%x", p.mask)
+	}
+	for _, family := range p.m.families() {
+		iptables := iptablesOf(family)
+		commands := [][]string{{iptables, "-t", "mangle", "-N", classChain}}
+		for _, cgroup := range p.cgroups {
+			match, _ := cgroupMatch(cgroup)
+			rule := append([]string{iptables, "-t", "mangle", "-A", classChain}, match...)
+			commands = append(commands, append(rule, "-j", "MARK", "--set-mark", mark))
+		}
+		commands = append(commands,
+			[]string{iptables, "-t", "mangle", "-A", "OUTPUT", "-j", classChain},
+			[]string{iptables, "-t", "nat", "-N", classChain},
+			[]string{iptables, "-t", "nat", "-A", classChain, "-m", "mark", "--mark", mark, "-j", "MASQUERADE"},
+			[]string{iptables, "-t", "nat", "-A", "POSTROUTING", "-j", classChain})
+		for _, c := range commands {
+			if output, err := p.m.run(c[0], c[1:]...); err != nil {
+				return fmt.Errorf("failed to mark the traffic of the cgroups: %s: %w, output: %s", strings.Join(c, " "), err, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+	p.m.changeLog.Record(changes.Firewall, "added", "mark %s on the traffic of %s", mark, strings.Join(p.cgroups, ", "))
+	p.m.log.Info().Msgf("Traffic of %s marked %s, only it follows the failover routes", strings.Join(p.cgroups, ", "), mark)
+	return nil
+}
+
+// removeClasses removes the classChain chains and their hooks left by
+// classify, if any.
+func (m *Manager) removeClasses() {
+	removed := false
+	for _, family := range []int{route.IPv4, route.IPv6} {
+		iptables := iptablesOf(family)
+		for _, hook := range [][2]string{{"mangle", "OUTPUT"}, {"nat", "POSTROUTING"}} {
+			table := hook[0]
+			m.run(iptables, "-t", table, "-D", hook[1], "-j", classChain)
+			m.run(iptables, "-t", table, "-F", classChain)
+			if _, err := m.run(iptables, "-t", table, "-X", classChain); err == nil {
+				removed = true
+			}
+		}
+	}
+	if removed {
+		m.changeLog.Record(changes.Firewall, "removed", "marking of the classified cgroups")
+	}
+}
+
+// iptablesOf returns the iptables program of family.
+func iptablesOf(family int) string {
+	if family == route.IPv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// cgroupMatch returns the iptables match of the traffic of cgroup: a net_cls
+// class ID, as major:minor in hexadecimal like tc handles, e.g. 10:1, or as
+// a number, e.g. 0x100001, or else a cgroup v2 path relative to the root of
+// the hierarchy, e.g. system.slice/telemetry.service.
+func cgroupMatch(cgroup string) ([]string, error) {
+	if major, minor, ok := strings.Cut(cgroup, ":"); ok {
+		hi, err1 := strconv.ParseUint(major, 16, 16)
+		lo, err2 := strconv.ParseUint(minor, 16, 16)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid net_cls class ID %q", cgroup)
+		}
+		return []string{"-m", "cgroup", "--cgroup", strconv.FormatUint(hi<<16|lo, 10)}, nil
+	}
+	if classid, err := strconv.ParseUint(cgroup, 0, 32); err == nil {
+		return []string{"-m", "cgroup", "--cgroup", strconv.FormatUint(classid, 10)}, nil
+	}
+	path := strings.Trim(cgroup, "/")
+	if path == "" {
+		return nil, fmt.Errorf("invalid cgroup %q", cgroup)
+	}
+	return []string{"-m", "cgroup", "--path", path}, nil
+}
+
+// remove deletes the rules at the priority, of both families, and the
+// marking of the cgroups.
+func (p policyRouting) remove() {
+	if len(p.cgroups) > 0 {
+		p.m.removeClasses()
+	}
+	for _, family := range []int{route.IPv4, route.IPv6} {
+		output, err := p.m.routing(func() (string, error) {
+			n, err := p.m.routeManager.DeleteRules(family, p.priority)
+			return strconv.Itoa(n), err
+		}, "rule", "del", familyName(family), strconv.Itoa(p.priority))
+		if err != nil {
+			p.m.log.Error().Msgf("Failed to remove the %s rules at priority %d: %s", familyName(family), p.priority, err)
+			continue
+		}
+		if output != "" && output != "0" {
+			p.m.changeLog.Record(changes.Route, "removed", "%s %s rules at priority %d", output, familyName(family), p.priority)
+		}
+	}
+}
+
+// routeArgs appends the table to the args describing a route operation when
+// the failover routes go to a dedicated table.
+func (p policyRouting) routeArgs(args ...string) []string {
+	if !p.enabled() {
+		return args
+	}
+	return append(args, "table", strconv.Itoa(p.table))
+}
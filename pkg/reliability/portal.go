@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/portal"
+	"github.com/shynuu/if-reliability/probe"
+)
+
+// portalRecheck is the delay between two connectivity checks while a captive
+// portal intercepts them.
+const portalRecheck = 5 * time.Second
+
+// portalCheck refuses to move the traffic to a WiFi network whose captive
+// portal intercepts it, until real connectivity is confirmed. It is disabled
+// if url is empty.
+type portalCheck struct {
+	m *Manager
+
+	url string
+	// loginHook is a shell command logging in to the portal, run with the
+	// interface and the portal page as arguments, or empty.
+	loginHook string
+	// timeout bounds the wait for connectivity once a portal is detected.
+	timeout time.Duration
+}
+
+// enabled reports whether WiFi is checked for a captive portal.
+func (p portalCheck) enabled() bool {
+	return p.url != ""
+}
+
+// check fetches the connectivity check URL through ifwifi.
+func (p portalCheck) check(ifwifi string) (portal.Result, error) {
+	client := (&probe.HTTP{Timeout: portalRecheck, Namespace: p.m.namespace, Network: "tcp" + p.m.networkSuffix()}).Client(ifwifi)
+	return portal.Check(client, p.url)
+}
+
+// blocked checks ifwifi for a captive portal. Once one is detected, it runs
+// the login hook and checks again until connectivity is confirmed or the
+// timeout expires. It returns why the traffic cannot move to ifwifi, or an
+// empty string if it can.
+func (p portalCheck) blocked(ifwifi string) string {
+	if !p.enabled() {
+		return ""
+	}
+	result, err := p.check(ifwifi)
+	if err == nil && !result.Portal {
+		return ""
+	}
+	if err != nil {
+		p.m.log.Warn().Msgf("Connectivity check over %s failed: %s", ifwifi, err)
+	} else {
+		p.m.log.Warn().Msgf("Captive portal on %s: connectivity check %s", ifwifi, result)
+		p.m.decide(ifwifi, "captive portal detected, connectivity check %s", result)
+		if p.loginHook != "" {
+			output, err := p.m.run("sh", "-c", p.loginHook, "if-reliability", ifwifi, result.Location)
+			if err != nil {
+				p.m.log.Error().Msgf("Portal login hook failed: %s, output: %s", err, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+	deadline := time.Now().Add(p.timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(min(portalRecheck, time.Until(deadline)))
+		if result, err = p.check(ifwifi); err == nil && !result.Portal {
+			p.m.log.Info().Msgf("Connectivity over %s confirmed", ifwifi)
+			p.m.decide(ifwifi, "connectivity confirmed past the captive portal")
+			return ""
+		}
+	}
+	if err != nil {
+		return "WiFi connectivity check failed"
+	}
+	return "captive portal"
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/spf13/pflag"
+)
+
+// AddProbeFlags defines the flags of Probe on fs, bound to c, with the probe
+// flags of the monitor.
+func AddProbeFlags(fs *pflag.FlagSet, c *Config) {
+	fs.StringSliceVarP(&c.Endpoints, "endpoint", "e", nil, "Endpoint to probe, may be repeated (required)")
+	fs.DurationVar(&c.Interval, "interval", time.Second, "Delay between two probes toward an endpoint")
+	fs.StringVar(&c.ProbeType, "probe-type", probe.TypeICMP, "Type of the probes: icmp, tcp, http, dns or a type compiled in (udp:// endpoints always use the responder protocol)")
+	fs.StringArrayVar(&c.ProbeOptions, "probe-option", nil, "Setting of the probe type as name=value, e.g. name=example.com for dns probes, may be repeated")
+	fs.DurationVar(&c.ProbeTimeout, "probe-timeout", 5*time.Second, "Time to wait for a TCP handshake, an HTTP response or a DNS answer")
+	fs.IntVar(&c.TCPPort, "tcp-port", 443, "Port TCP probes connect to when the endpoint has none")
+	fs.IntVar(&c.HTTPStatus, "http-status", 200, "Status code HTTP probes expect")
+	fs.DurationVar(&c.ICMPTimeout, "icmp-timeout", 2*time.Second, "Time to wait for an ICMP echo reply")
+	fs.IntVar(&c.ICMPPayloadSize, "icmp-payload-size", 56, "Payload size of the ICMP echo requests in bytes (at least 8)")
+	fs.IntVar(&c.ICMPTTL, "icmp-ttl", 0, "TTL of the ICMP echo requests (system default if 0)")
+	fs.StringVar(&c.EndpointRecords, "endpoint-records", recordsRotate, "How the addresses of an endpoint host name are probed: rotate, one after the other, or all, each time, the probe succeeding if one answers")
+	fs.DurationVar(&c.EndpointMinTTL, "endpoint-min-ttl", 30*time.Second, "Least time the addresses of an endpoint host name are kept before resolving it again")
+	fs.DurationVar(&c.EndpointMaxTTL, "endpoint-max-ttl", time.Hour, "Longest time the addresses of an endpoint host name are kept, whatever the TTL of its records")
+	fs.StringVar(&c.IPFamily, "ip-family", familyIPv4, "Address families host names are resolved in: ipv4, ipv6 or dual")
+	fs.StringVar(&c.ProbeKeyFile, "probe-key-file", "", "File holding the shared key authenticating probes to the responder")
+	fs.StringVar(&c.Netns, "netns", "", "Named network namespace to probe from")
+}
+
+// ProbeReport is the outcome of the probes toward one endpoint. The RTTs
+// are encoded in milliseconds.
+type ProbeReport struct {
+	Endpoint   string        `json:"endpoint"`
+	Sent       int           `json:"sent"`
+	Received   int           `json:"received"`
+	Loss       float64       `json:"loss"`
+	MinRTT     time.Duration `json:"-"`
+	MeanRTT    time.Duration `json:"-"`
+	MaxRTT     time.Duration `json:"-"`
+	MinMillis  float64       `json:"min_rtt_ms"`
+	MeanMillis float64       `json:"mean_rtt_ms"`
+	MaxMillis  float64       `json:"max_rtt_ms"`
+	// TTL is the TTL of the last reply, 0 if unknown.
+	TTL int `json:"ttl,omitempty"`
+	// Phases are the phases of the last probe, for https probes, and
+	// PhaseMillis their durations by name in milliseconds.
+	Phases      *probe.Phases      `json:"-"`
+	PhaseMillis map[string]float64 `json:"phases_ms,omitempty"`
+	Errors      []string           `json:"errors,omitempty"`
+}
+
+// add records the result of one probe.
+func (r *ProbeReport) add(result probe.Result) {
+	r.Sent++
+	if result.Phases != nil {
+		r.Phases = result.Phases
+		r.PhaseMillis = make(map[string]float64)
+		for name, d := range result.Phases.Map() {
+			r.PhaseMillis[name] = eventlog.Millis(d)
+		}
+	}
+	if !result.OK() {
+		r.Errors = append(r.Errors, result.Err.Error())
+		return
+	}
+	if r.Received == 0 || result.RTT < r.MinRTT {
+		r.MinRTT = result.RTT
+	}
+	r.MaxRTT = max(r.MaxRTT, result.RTT)
+	r.MeanRTT = (r.MeanRTT*time.Duration(r.Received) + result.RTT) / time.Duration(r.Received+1)
+	r.Received++
+	r.MinMillis, r.MeanMillis, r.MaxMillis = eventlog.Millis(r.MinRTT), eventlog.Millis(r.MeanRTT), eventlog.Millis(r.MaxRTT)
+	if result.TTL > 0 {
+		r.TTL = result.TTL
+	}
+}
+
+// Probe probes the endpoints of config, bound to the flags of AddProbeFlags,
+// like the monitor does, count times each over ifname, or as the routing
+// table decides if empty, and returns one report per endpoint.
+func Probe(config Config, ifname string, count int) ([]*ProbeReport, error) {
+	return newManager(config).probe(ifname, count)
+}
+
+// probe runs Probe.
+func (m *Manager) probe(ifname string, count int) ([]*ProbeReport, error) {
+	list := m.config.Endpoints
+	interval := m.config.Interval
+	if count < 1 {
+		return nil, fmt.Errorf("invalid count %d: at least one probe is needed", count)
+	}
+	family := m.config.IPFamily
+	if err := m.setFamily(family); err != nil {
+		return nil, fmt.Errorf("parsing --ip-family: %w", err)
+	}
+	targets, err := endpoint.ParseList(list)
+	if err != nil {
+		return nil, fmt.Errorf("parsing endpoint: %w", err)
+	}
+	if err := m.configureProbes(); err != nil {
+		return nil, fmt.Errorf("configuring probes: %w", err)
+	}
+	reports := make([]*ProbeReport, len(targets))
+	for i, target := range bindAll(targets, ifname) {
+		reports[i] = &ProbeReport{Endpoint: target.String()}
+		for n := 0; n < count; n++ {
+			if n > 0 {
+				time.Sleep(interval)
+			}
+			reports[i].add(m.probeEndpoint(target))
+		}
+		reports[i].Loss = 1 - float64(reports[i].Received)/float64(reports[i].Sent)
+	}
+	return reports, nil
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// Reconciliation modes of the routes the tool installed when another
+// program changes them.
+const (
+	reconcileReassert = "reassert"
+	reconcileLog      = "log"
+	reconcileOff      = "off"
+)
+
+// ownedRoute is a route the tool installed and expects to find in the
+// table, a multipath route if hops is not empty.
+type ownedRoute struct {
+	route route.Route
+	hops  []route.Nexthop
+}
+
+// String describes o, e.g. "10.0.0.0/8 via 192.0.2.1 dev wlan0 metric 50".
+func (o ownedRoute) String() string {
+	return describeRoute(o.route, o.hops)
+}
+
+// reconciler keeps the routes the tool installed, and notices another
+// program, e.g. a DHCP client or NetworkManager, removing or replacing them.
+// In reassert mode it installs them again, after a delay starting at
+// backoff and doubling up to maxBackoff while the other program keeps
+// changing them, so that the two do not fight in a tight loop.
+type reconciler struct {
+	m *Manager
+
+	mode       string
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu    sync.Mutex
+	owned map[string]ownedRoute
+	// delay is the next re-assertion delay of a route, last when it was
+	// last re-asserted, and pending whether a re-assertion is scheduled.
+	delay   map[string]time.Duration
+	last    map[string]time.Time
+	pending map[string]bool
+}
+
+// newReconciler returns a reconciler keeping no route yet, disabled.
+func (m *Manager) newReconciler() *reconciler {
+	return &reconciler{m: m,
+		mode:    reconcileOff,
+		owned:   map[string]ownedRoute{},
+		delay:   map[string]time.Duration{},
+		last:    map[string]time.Time{},
+		pending: map[string]bool{},
+	}
+}
+
+// routeKey identifies a route in its table by destination and metric.
+func routeKey(dst string, metric int) string {
+	return dst + " metric " + strconv.Itoa(metric)
+}
+
+// own records r, over hops if not empty, as installed by the tool.
+func (rc *reconciler) own(r route.Route, hops []route.Nexthop) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.owned[routeKey(r.Dst, r.Metric)] = ownedRoute{route: r, hops: hops}
+}
+
+// disown forgets the routes toward dst through device, or whatever their
+// device if empty, with metric, or whatever their metric if negative, before
+// the tool removes them.
+func (rc *reconciler) disown(dst, device string, metric int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for key, o := range rc.owned {
+		if o.route.Dst == dst && (device == "" || o.route.Device == device) && (metric < 0 || o.route.Metric == metric) {
+			delete(rc.owned, key)
+		}
+	}
+}
+
+// setMode switches between re-asserting and logging the changed routes
+// while watching.
+func (rc *reconciler) setMode(mode string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.mode = mode
+}
+
+// watch follows the changes of the routing table of the failover routes,
+// and reacts to the owned routes being removed or replaced.
+func (rc *reconciler) watch() error {
+	var routes <-chan route.Change
+	err := netns.Do(rc.m.namespace, func() error {
+		var err error
+		routes, err = rc.m.routeManager.Watch(rc.m.vrf, rc.m.routingPolicy.table, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		for c := range routes {
+			rc.changed(c)
+		}
+		rc.m.log.Warn().Msg("Route notifications stopped, routes changed by other programs are no longer noticed")
+	}()
+	return nil
+}
+
+// changed handles a change of the routing table.
+func (rc *reconciler) changed(c route.Change) {
+	key := routeKey(c.Route.Dst, c.Route.Metric)
+	rc.mu.Lock()
+	o, ok := rc.owned[key]
+	mode := rc.mode
+	rc.mu.Unlock()
+	if !ok {
+		return
+	}
+	same := sameNexthops(o, c)
+	if same != c.Deleted {
+		// Our own route installed, or another one removed.
+		return
+	}
+	what := "removed"
+	if !c.Deleted {
+		what = "replaced with " + describeRoute(c.Route, c.Hops)
+	}
+	rc.m.log.Warn().Msgf("Route %s %s by another program", o, what)
+	rc.m.decide(o.route.Device, "route toward %s %s by another program", o.route.Dst, what)
+	if mode == reconcileReassert {
+		rc.schedule(key)
+	}
+}
+
+// schedule re-asserts the owned route of key after a delay, double the last
+// one if it was re-asserted less than the maximum backoff ago.
+func (rc *reconciler) schedule(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.pending[key] {
+		return
+	}
+	delay := rc.backoff
+	if last := rc.delay[key]; last > 0 && time.Since(rc.last[key]) < rc.maxBackoff {
+		delay = min(2*last, rc.maxBackoff)
+		if delay == rc.maxBackoff && last < rc.maxBackoff {
+			rc.m.log.Warn().Msgf("Another program keeps changing the route toward %s, re-asserting it at most every %s", rc.owned[key].route.Dst, rc.maxBackoff)
+		}
+	}
+	rc.delay[key] = delay
+	rc.pending[key] = true
+	rc.m.log.Info().Msgf("Re-asserting the route toward %s in %s", rc.owned[key].route.Dst, delay)
+	time.AfterFunc(delay, func() { rc.reassert(key) })
+}
+
+// reassert installs the owned route of key again, unless the tool removed
+// it meanwhile.
+func (rc *reconciler) reassert(key string) {
+	rc.mu.Lock()
+	o, ok := rc.owned[key]
+	rc.pending[key] = false
+	rc.last[key] = time.Now()
+	rc.mu.Unlock()
+	if !ok {
+		return
+	}
+	args := []string{"route", "replace", o.route.Dst}
+	op := func() (string, error) { return "", rc.m.routeManager.Replace(o.route) }
+	if len(o.hops) > 0 {
+		op = func() (string, error) { return "", rc.m.routeManager.ReplaceMultipath(o.route, o.hops) }
+		for _, hop := range o.hops {
+			args = append(args, "nexthop", hop.Gateway, hop.Device, strconv.Itoa(hop.Weight))
+		}
+	} else {
+		args = append(args, o.route.Gateway, o.route.Device, o.route.VRF)
+	}
+	args = append(args, "metric", strconv.Itoa(o.route.Metric))
+	if _, err := rc.m.routing(op, rc.m.routingPolicy.routeArgs(args...)...); err != nil {
+		rc.m.log.Error().Msgf("Error re-asserting the route %s: %s", o, err)
+		return
+	}
+	rc.m.log.Info().Msgf("Route %s re-asserted", o)
+	rc.m.changeLog.Record(changes.Route, "re-asserted", "%s", o)
+}
+
+// sameNexthops reports whether the route of c goes where o does.
+func sameNexthops(o ownedRoute, c route.Change) bool {
+	if len(o.hops) != len(c.Hops) {
+		return false
+	}
+	if len(o.hops) == 0 {
+		return c.Route.Device == o.route.Device && sameIP(c.Route.Gateway, o.route.Gateway)
+	}
+	for i, hop := range o.hops {
+		if c.Hops[i].Device != hop.Device || !sameIP(c.Hops[i].Gateway, hop.Gateway) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameIP reports whether a and b are the same IP address, whatever their
+// notation.
+func sameIP(a, b string) bool {
+	return net.ParseIP(a).Equal(net.ParseIP(b))
+}
+
+// describeRoute describes r, over hops if not empty.
+func describeRoute(r route.Route, hops []route.Nexthop) string {
+	var b strings.Builder
+	b.WriteString(r.Dst)
+	if len(hops) == 0 {
+		if r.Gateway != "" {
+			fmt.Fprintf(&b, " via %s", r.Gateway)
+		}
+		if r.Device != "" {
+			fmt.Fprintf(&b, " dev %s", r.Device)
+		}
+	}
+	for _, hop := range hops {
+		fmt.Fprintf(&b, " nexthop via %s dev %s weight %d", hop.Gateway, hop.Device, hop.Weight)
+	}
+	if r.Metric != 0 {
+		fmt.Fprintf(&b, " metric %d", r.Metric)
+	}
+	return b.String()
+}
@@ -0,0 +1,1026 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package reliability is the failover engine of if-reliability: it probes
+// endpoints over the primary link, connects WiFi and routes the endpoint
+// networks through it once they stop answering, and moves them back once
+// the primary link recovered. The if-reliability command is a thin wrapper
+// around it, and Go programs may embed it:
+//
+//	config := reliability.DefaultConfig()
+//	config.Endpoints = []string{"8.8.8.8", "1.1.1.1"}
+//	config.WiFiInterface = "wlan0"
+//	config.WiFiSSID = "backup"
+//	config.WiFiPassword = password
+//	config.Failback = true
+//	m, err := reliability.NewManager(config)
+//	if err != nil {
+//		return err
+//	}
+//	go func() {
+//		for e := range m.Events() {
+//			log.Println(e)
+//		}
+//	}()
+//	return m.Run(ctx)
+//
+// Each manager keeps its own state, logger and metrics, so that several may
+// run in a process as long as they manage different links and files.
+package reliability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/alert"
+	"github.com/shynuu/if-reliability/availability"
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/damping"
+	"github.com/shynuu/if-reliability/desktop"
+	"github.com/shynuu/if-reliability/detect"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/echo"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/health"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/live"
+	"github.com/shynuu/if-reliability/lookup"
+	"github.com/shynuu/if-reliability/metrics"
+	"github.com/shynuu/if-reliability/modem"
+	"github.com/shynuu/if-reliability/mqttstate"
+	"github.com/shynuu/if-reliability/nm"
+	"github.com/shynuu/if-reliability/outage"
+	"github.com/shynuu/if-reliability/pathscore"
+	"github.com/shynuu/if-reliability/pathwatch"
+	"github.com/shynuu/if-reliability/persist"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/quorum"
+	"github.com/shynuu/if-reliability/replay"
+	"github.com/shynuu/if-reliability/resolver"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/shynuu/if-reliability/schedule"
+	"github.com/shynuu/if-reliability/severity"
+	"github.com/shynuu/if-reliability/sla"
+	"github.com/shynuu/if-reliability/state"
+	"github.com/shynuu/if-reliability/syslogexport"
+	"github.com/shynuu/if-reliability/throttle"
+	"github.com/shynuu/if-reliability/tuning"
+	"github.com/shynuu/if-reliability/usage"
+	"github.com/shynuu/if-reliability/wifi"
+	"github.com/spf13/pflag"
+)
+
+// Defaults of the settings.
+const (
+	DefaultInterval          = time.Second
+	DefaultRetry             = 5
+	DefaultFailbackSuccesses = 10
+	DefaultIPv4Prefix        = 24
+	DefaultIPv6Prefix        = 64
+	// DefaultProtocol tags the routes installed by the manager.
+	DefaultProtocol = 77
+)
+
+// eventBuffer is the number of events kept for a slow reader of Events.
+const eventBuffer = 64
+
+// Config configures a Manager. The if-reliability command binds its flags,
+// defined by AddFlags, to the fields of a Config: those not documented below
+// hold the setting of the flag of the same name, e.g. MaxRTT that of
+// --max-rtt. Programs embedding the manager start from DefaultConfig.
+type Config struct {
+	// Endpoints are the probe endpoints, in the syntax of the endpoint
+	// package, e.g. "8.8.8.8" or "https://health.example.com%wwan0",
+	// discovered from the primary link if empty.
+	Endpoints []string
+	// Quorum is the number of endpoints that must fail at once for a probe
+	// round to fail, more than half of them if 0.
+	Quorum int
+	// Networks are the networks routed through WiFi on failover, in CIDR
+	// notation optionally followed by @metric. They default to the
+	// network of each endpoint, of IPv4Prefix or IPv6Prefix bits.
+	Networks []string
+
+	WiFiInterface string
+	// WiFiSSID is required unless WiFiInterface is wired, see
+	// InterfaceTypes.
+	WiFiSSID string
+	// WiFiPassword is empty for an open network or an existing connection
+	// profile.
+	WiFiPassword string
+	// Connect bounds the WiFi connect phase, see wifi.ConnectOptions. Its
+	// zero value makes a single attempt with 30 second timeouts.
+	Connect wifi.ConnectOptions
+
+	// Pinger sends the probes, those of ProbeType if nil. Any prober of the
+	// probe package may be used.
+	Pinger Pinger
+	// WiFi activates the connections, NetworkManager if nil, and Routes
+	// changes the routing tables, Netlink if nil.
+	WiFi   WiFiManager
+	Routes RouteManager
+	// Commands runs the external programs, such as iw and sysctl, Exec in
+	// the namespace of Netns if nil.
+	Commands CommandRunner
+	// Logger is where the manager logs, with a copy of log.Logger if nil.
+	Logger *zerolog.Logger
+	// Reload returns the configuration SIGHUP applies, as the command reads
+	// it again from its configuration file. The manager handles SIGHUP,
+	// SIGUSR1 and SIGUSR2 only if it is set.
+	Reload func() (Config, error)
+	// Sources are where the settings come from, SourceFlag, SourceEnv or
+	// SourceFile by flag name, as SettingSources returns them, the others
+	// being reported as defaults.
+	Sources map[string]string
+
+	// Interval separates two probe rounds, DefaultInterval if 0, and at
+	// least 10ms.
+	Interval time.Duration
+	// Retry is the number of failed rounds in a row failing over,
+	// DefaultRetry if 0.
+	Retry int
+
+	// Failback moves the traffic back to the primary link once
+	// FailbackSuccesses rounds in a row, DefaultFailbackSuccesses if 0,
+	// succeeded over it. Without it, the traffic stays on WiFi until WiFi
+	// fails too.
+	Failback          bool
+	FailbackSuccesses int
+
+	// Metric is set on the routes of the Networks without a metric of
+	// their own, and Protocol on every route installed, DefaultProtocol if
+	// 0.
+	Metric   int
+	Protocol int
+
+	ConfigFile                string
+	Group                     string
+	InterfaceTypes            []string
+	MinLinkSpeed              int
+	WiFiPasswordFile          string
+	EndpointRecords           string
+	EndpointMinTTL            time.Duration
+	EndpointMaxTTL            time.Duration
+	AnycastEndpoints          []string
+	MaxRTT                    time.Duration
+	MaxLoss                   float64
+	MaxJitter                 time.Duration
+	SLAWindow                 int
+	AvailabilityPeriods       []time.Duration
+	AvailabilityReport        string
+	VerifyEndpoints           []string
+	VerifyAttempts            int
+	VerifyGrace               time.Duration
+	Interfaces                []string
+	LoadBalance               bool
+	Preferences               []string
+	MaintenanceWindows        []string
+	BestPath                  bool
+	BestPathMargin            float64
+	Weights                   []string
+	Detection                 string
+	DetectionWindow           int
+	DetectionLoss             float64
+	DetectionAlpha            float64
+	DetectionMaxRTT           time.Duration
+	HistorySize               int
+	HistorySnapshot           string
+	HistoryDir                string
+	HistoryRetention          time.Duration
+	HistoryMaxSize            string
+	FlushInterval             time.Duration
+	Fsync                     string
+	StateFile                 string
+	FailbackHold              time.Duration
+	HoldDown                  time.Duration
+	HoldDownMax               time.Duration
+	HoldDownHalfLife          time.Duration
+	MinDwell                  time.Duration
+	ChronyPrimaryServers      []string
+	ChronyBackupServers       []string
+	ChronyFailoverStratum     int
+	DNSMode                   string
+	DNSServers                []string
+	ResolvConf                string
+	FirewallRules             []string
+	ProbeWeight               float64
+	ReliabilityHalfLife       time.Duration
+	BackupMaxAge              time.Duration
+	DryRun                    bool
+	Drill                     bool
+	ProbeType                 string
+	ProbeOptions              []string
+	ProbeCount                int
+	ProbeTimeout              time.Duration
+	TCPPort                   int
+	HTTPStatus                int
+	ICMPTimeout               time.Duration
+	ICMPPayloadSize           int
+	ICMPTTL                   int
+	IPFamily                  string
+	IPv4Prefix                int
+	IPv6Prefix                int
+	PMTU                      string
+	ConntrackFlush            string
+	FailoverMode              string
+	PrimaryMetric             int
+	BackupMetric              int
+	FailoverMetric            int
+	ProbeKeyFile              string
+	BufferbloatURL            string
+	BufferbloatDuration       time.Duration
+	BufferbloatMinGrade       string
+	MinThroughput             float64
+	ThroughputURL             string
+	ThroughputIperf3          string
+	ThroughputDuration        time.Duration
+	MinWiFiHealth             int
+	MinRSSI                   int
+	RouteRTOMin               time.Duration
+	RouteQuickAck             bool
+	RouteInitCwnd             int
+	TCPKeepaliveTime          time.Duration
+	TCPKeepaliveInterval      time.Duration
+	TCPKeepaliveProbes        int
+	WireGuardInterfaces       []string
+	WireGuardEndpoints        []string
+	WireGuardHandshakeTimeout time.Duration
+	Peer                      string
+	PeerListen                string
+	PeerName                  string
+	PeerPriority              int
+	PeerPreempt               bool
+	PeerInterval              time.Duration
+	PeerDeadInterval          time.Duration
+	PeerKeyFile               string
+	PeerVIP                   string
+	PeerVIPInterface          string
+	Hooks                     []string
+	OnFailover                string
+	OnFailback                string
+	Dispatcher                bool
+	Modem                     string
+	MinRSRP                   float64
+	MinRSRQ                   float64
+	MinSINR                   float64
+	ModemReconnect            bool
+	ModemReconnectTimeout     time.Duration
+	UsageInterval             time.Duration
+	DataCap                   string
+	DataCapInterface          string
+	DataCapThreshold          float64
+	DataCapResetDay           int
+	CarrierWatch              bool
+	GatewayProbe              bool
+	GatewayInterval           time.Duration
+	GatewayRetry              int
+	EchoReflector             string
+	EchoInterval              time.Duration
+	EchoMultiplier            int
+	EchoMaxJitter             time.Duration
+	WatchSocket               string
+	EventLog                  string
+	Diagnose                  bool
+	DiagnoseMaxHops           int
+	WebhookURL                string
+	SiteID                    string
+	MQTTBroker                string
+	MQTTTopic                 string
+	MQTTClientID              string
+	MQTTUsername              string
+	MQTTPassword              string
+	MQTTCAFile                string
+	MQTTCertFile              string
+	MQTTKeyFile               string
+	MQTTQoS                   int
+	MQTTInterval              time.Duration
+	WebhookTimeout            time.Duration
+	WebhookAttempts           int
+	WebhookBackoff            time.Duration
+	AlertEmails               []string
+	AlertSMTPServer           string
+	AlertSMTPFrom             string
+	AlertSMTPUsername         string
+	AlertSMTPPassword         string
+	AlertSlackURL             string
+	AlertTeamsURL             string
+	AlertSeverity             string
+	AlertMinDuration          time.Duration
+	AlertSubject              string
+	AlertBody                 string
+	AlertTimeout              time.Duration
+	AlertAttempts             int
+	Daemon                    bool
+	RestoreOnExit             bool
+	DisconnectOnExit          bool
+	Notify                    bool
+	NotifyUser                string
+	NotifyBus                 string
+	StatusFile                string
+	StatusFileInterval        time.Duration
+	MetricsListen             string
+	ControlSocket             string
+	ControlListen             string
+	ControlTokenFile          string
+	TriggerSocket             string
+	RouteRealm                int
+	ReconcileRoutes           string
+	ReconcileBackoff          time.Duration
+	ReconcileMaxBackoff       time.Duration
+	SyslogAddr                string
+	SyslogNetwork             string
+	SyslogSampleHealthy       int
+	SyslogSampleDegraded      int
+	SlowExec                  time.Duration
+	WiFiEAP                   string
+	WiFiIdentity              string
+	WiFiAnonymousIdentity     string
+	WiFiCACert                string
+	WiFiClientCert            string
+	WiFiPrivateKey            string
+	WiFiPhase2                string
+	WiFiFallbacks             []string
+	PortalCheck               string
+	PortalLoginHook           string
+	PortalTimeout             time.Duration
+	ColdSpareRFKill           string
+	ColdSparePowerCommand     string
+	ColdSpareTimeout          time.Duration
+	WarmStandby               time.Duration
+	WarmStandbyRetry          int
+	Record                    string
+	Replay                    string
+	NMRestartTimeout          time.Duration
+	Netns                     string
+	RouteTable                int
+	RulePriority              int
+	RuleFwmark                string
+	RuleFrom                  []string
+	RuleCgroups               []string
+	VRF                       string
+	LockDir                   string
+	Takeover                  bool
+	LogSeverity               string
+	LogSinks                  []string
+	LogConsole                bool
+	LogFileMaxSize            string
+	LogFileMaxAge             time.Duration
+	LogFileBackups            int
+	LogRepeatBurst            int
+	LogRepeatInterval         time.Duration
+	LogHeartbeat              time.Duration
+	MetricsSeverity           string
+	SyslogSeverity            string
+	Timezone                  string
+}
+
+// DefaultConfig returns the defaults of the flags of AddFlags, but for the
+// features acting on the whole host, off: the state file, the control,
+// trigger and watch sockets, the instance locks and the diagnosis and
+// carrier watch. The hold times are 0 too.
+func DefaultConfig() Config {
+	var c Config
+	AddFlags(pflag.NewFlagSet("reliability", pflag.ContinueOnError), &c)
+	c.StateFile, c.ControlSocket, c.TriggerSocket, c.WatchSocket, c.LockDir = "", "", "", "", ""
+	c.Diagnose, c.CarrierWatch = false, false
+	c.FailbackHold, c.HoldDown = 0, 0
+	return c
+}
+
+// Event kinds.
+const (
+	// EventProbe is the result of a probe.
+	EventProbe = "probe"
+	// EventTransition is a change of state.
+	EventTransition = "transition"
+	// EventChange is a change made to the system, such as a route
+	// installed or re-asserted.
+	EventChange = "change"
+	// EventError is an error the manager logged.
+	EventError = "error"
+)
+
+// Event is something that happened in the manager.
+type Event struct {
+	Time time.Time
+	Kind string
+	// From, To and Reason describe a transition. Reason also describes
+	// a change, e.g. "route re-asserted 8.8.8.0/24 via 192.168.1.1 dev
+	// wlan0".
+	From   fsm.State
+	To     fsm.State
+	Reason string
+	// Endpoint, Interface and RTT describe a probe, successful if Err is
+	// empty. Interface is empty for a probe following the routing table.
+	Endpoint  string
+	Interface string
+	RTT       time.Duration
+	// Err describes a failed probe or an error.
+	Err string
+}
+
+// String describes the event.
+func (e Event) String() string {
+	switch e.Kind {
+	case EventTransition:
+		return fsm.Transition{From: e.From, To: e.To, Reason: e.Reason}.String()
+	case EventChange:
+		return e.Reason
+	case EventProbe:
+		if e.Err != "" {
+			return fmt.Sprintf("probe %s: %s", e.Endpoint, e.Err)
+		}
+		return fmt.Sprintf("probe %s: %s", e.Endpoint, e.RTT)
+	}
+	return e.Err
+}
+
+// Status is the state of a manager.
+type Status struct {
+	State fsm.State
+	Since time.Time
+	// PrimaryInterface is the interface of the primary link, found once
+	// the manager monitors it, and Active the link carrying the traffic:
+	// an interface, or several with --load-balance.
+	PrimaryInterface string
+	Active           string
+	// Paused is set while the automatic failovers and failbacks are
+	// suspended.
+	Paused bool
+}
+
+// Manager runs the failover between the primary link and WiFi, or between
+// the Interfaces of its config.
+type Manager struct {
+	config Config
+	// started is set once Run was called.
+	started atomic.Bool
+	// simulation answers the operations and the probes of Simulate.
+	simulation *simulation
+
+	mu     sync.Mutex
+	events chan Event
+	closed bool
+	// primaryIF and active are the interfaces of Status, noted by the
+	// engine as they change.
+	primaryIF string
+	active    string
+
+	// log is the logger of the manager, and metrics and location the
+	// registry of its metrics and the time zone of its schedules.
+	log      zerolog.Logger
+	metrics  *metrics.Registry
+	location *time.Location
+
+	// alerts sends the failovers and failbacks by email, to Slack or to Teams,
+	// nil if no alert sink is set, and alertSite is the site named in them.
+	alerts    *alert.Dispatcher
+	alertSite string
+
+	// commands carries the failover and failback commands to the monitoring
+	// loop.
+	commands chan commandRequest
+
+	// paused is set while the automatic failovers and failbacks are suspended.
+	paused atomic.Bool
+
+	// manualCommand is the command that ended the last monitoring or recovery
+	// wait, or empty if it ended on its own.
+	manualCommand string
+
+	// activeCascade is the running cascade with --interfaces, or nil.
+	activeCascade *cascade
+
+	// archive keeps the probe history on disk, nil if disabled.
+	archive *history.Archive
+
+	// availabilityTrackers accumulate the availability over each period of
+	// --availability-periods, none if disabled.
+	availabilityTrackers []*availability.Tracker
+
+	// availabilityReport is the file the reports of the ended periods are
+	// appended to, one JSON object per line, nil if not written.
+	availabilityReport *persist.Appender
+
+	// wifiManager, routeManager and commandRunner are the backends of the
+	// manager, the real ones unless it was given others.
+	wifiManager   WiFiManager
+	routeManager  RouteManager
+	commandRunner CommandRunner
+
+	// store holds the persisted state, or is nil if it could not be opened.
+	store *state.Store
+
+	// defaultIF is the interface the probes toward endpoints not bound to one
+	// leave through, if known: the primary link's while monitoring it, WiFi once
+	// failed over.
+	defaultIF string
+
+	// conntrackFlush is the connection tracking policy, set by --conntrack-flush.
+	conntrackFlush *conntrackPolicy
+
+	// daemon is set when running as a systemd Type=notify service.
+	daemon bool
+
+	// heartbeat is the Unix time in nanoseconds the monitor last made progress:
+	// a probe round or an external program run.
+	heartbeat atomic.Int64
+
+	// restoreOnStop undoes the failover changes, set while failed over.
+	restoreOnStop func()
+
+	// diagnoseFailures diagnoses the links reaching the failure threshold into
+	// the event log, with traceroutes of at most diagnoseHops hops.
+	diagnoseFailures bool
+	diagnoseHops     int
+
+	// gatewayCheck is set when the endpoints were discovered: failed rounds then
+	// probe the default router of the link too, to tell a failure of the local
+	// link from one beyond it.
+	gatewayCheck bool
+
+	// resolvedClient talks to systemd-resolved over D-Bus, connected on first
+	// use.
+	resolvedClient *resolver.Resolved
+
+	// dryRun is set when the operations changing the system are only logged.
+	dryRun bool
+
+	// activeLink is the link currently carrying traffic.
+	activeLink string
+
+	// evacuation is the pending evacuation request, or nil.
+	evacuation *dispatcher.Event
+
+	// eventLog is the --event-log the significant events are appended to, nil if
+	// disabled.
+	eventLog *eventlog.Log
+
+	// failbackSuccesses and failbackHold are the probe rounds in a row the
+	// primary link must pass and the least time spent on the backup link
+	// before failing back.
+	failbackSuccesses int
+	failbackHold      time.Duration
+
+	// ipFamily is the address family mode: the families endpoint host names are
+	// resolved in and routes are moved for.
+	ipFamily string
+
+	// prefixLen is the length of the endpoint networks moved on failover, per
+	// address family.
+	prefixLen map[int]int
+
+	// routePrefixes are the networks moved on failover in place of the endpoint
+	// networks if not empty, and prefixMetrics the metrics of their routes.
+	routePrefixes []string
+	prefixMetrics map[string]int
+
+	// hostNames resolves the host names of the endpoints through the DNS
+	// servers of the interface probed, and endpointRecords is how the addresses
+	// of a name are probed: one after the other or all of them each time.
+	hostNames       *lookup.Cache
+	endpointRecords string
+
+	// faults are the failures injected through the control API or by the
+	// simulation.
+	faults *faultInjector
+
+	// repeats limits the warnings repeated every probe round while a link
+	// keeps failing.
+	repeats *throttle.Limiter
+
+	// primaryModem is the modem of the primary link.
+	primaryModem lteModem
+
+	// mmClient talks to ModemManager over D-Bus, connected on first use.
+	mmClient *modem.Client
+
+	// machine is the state machine of the failover between the primary link and
+	// WiFi. Hooks may be registered on it before the failover runs.
+	machine *fsm.Machine
+
+	// routeMetrics is the metric policy, set by --failover-mode metric.
+	routeMetrics *metricPolicy
+
+	// samples records every probe result for later inspection.
+	samples history.Store
+
+	// namespace is the named network namespace the tool operates in, or empty for
+	// the namespace it was started in.
+	namespace string
+
+	// vrf is the Linux VRF device the managed links are enslaved to, or empty
+	// when the main routing table is used.
+	vrf string
+
+	// prober sends probes to udp:// endpoints.
+	prober *echo.Client
+
+	// pinger sends ICMP echo requests.
+	pinger *probe.ICMP
+
+	// routerPinger pings the routers, pinger unless the manager was given a
+	// Pinger.
+	routerPinger Pinger
+
+	// probeType is the type of the probes sent to the endpoints other than
+	// udp:// and dns:// ones, and endpointProber the prober sending them.
+	probeType      string
+	endpointProber probe.Prober
+
+	// probeInterval separates two probe rounds, in which probeCount probes are
+	// sent to each endpoint.
+	probeInterval time.Duration
+	probeCount    int
+
+	// resolverProber queries the resolvers of dns:// endpoints, whatever the
+	// probe type.
+	resolverProber probe.Prober
+
+	// probeCtx is cancelled by stopProbes once the monitor stops, abandoning
+	// the probes under way so that exiting does not wait for their timeouts.
+	// Each manager replaces them, under probeMu since the goroutines of the
+	// previous one may still be reading probeCtx.
+	probeMu    sync.Mutex
+	probeCtx   context.Context
+	stopProbes context.CancelFunc
+
+	// observed holds, per udp:// endpoint, the source address the responder saw
+	// in the last reply.
+	observed map[string]net.IP
+
+	// paths detects endpoints whose replies suddenly come from another distance.
+	paths *pathwatch.Detector
+
+	// targets are the endpoints probed, and probeQuorum the number of them
+	// that must fail at once for a probe round to fail.
+	targets     []endpoint.Endpoint
+	probeQuorum int
+
+	// detection is the algorithm declaring the primary link down from the probe
+	// rounds, and its settings.
+	detection detect.Options
+
+	// endpointStatus follows which probe endpoints are down.
+	endpointStatus *quorum.Tracker
+
+	// flaps holds down links that fail repeatedly, and minDwell is the minimum
+	// time spent on a link before switching to a preferred one.
+	flaps    *damping.Damper
+	minDwell time.Duration
+
+	// quality judges the probes of each link against the SLA thresholds.
+	quality *sla.Monitor
+
+	// pathScores rates the path over each link for --best-path.
+	pathScores *pathscore.Tracker
+
+	// hints are the optional tuning hints applied with the failover routes.
+	hints tuning.Hints
+
+	// changeLog records the system changes made during the current transition.
+	changeLog *changes.Recorder
+
+	// triggers receives NetworkManager dispatcher events when the dispatcher
+	// integration is enabled.
+	triggers <-chan dispatcher.Event
+
+	// routeProto is the routing protocol number all routes installed by the tool
+	// are tagged with, so that they can be listed and flushed by protocol.
+	routeProto int
+
+	// routeRealm is the realm installed routes are tagged with, or 0.
+	routeRealm int
+
+	// exporter ships sampled probe results to a remote syslog server, if
+	// configured.
+	exporter *syslogexport.Exporter
+
+	// nmWatcher follows NetworkManager restarts, or is nil if the system bus is
+	// not reachable.
+	nmWatcher *nm.Watcher
+
+	// nmRestartTimeout is how long WiFi operations are held while NetworkManager
+	// is not running.
+	nmRestartTimeout time.Duration
+
+	// outages tracks the ongoing outage so that related events share one ID.
+	outages *outage.Tracker
+
+	// severities tags log events with their severity and filters them.
+	severities severity.Hook
+
+	// slowExec is the run time above which an external program is reported as slow.
+	slowExec time.Duration
+
+	// recorder records the interactions with the WiFi backend, or is nil.
+	recorder *replay.Recorder
+
+	// player replays recorded interactions instead of running programs, or is nil.
+	player *replay.Player
+
+	// savedSysctls are the values the sysctls set on failover had before, keyed
+	// by name, restored on failback.
+	savedSysctls map[string]string
+
+	// statePublisher publishes the state to MQTT on behalf of stateSite, nil if
+	// disabled, and lastSwitch is when the traffic last moved to another link.
+	statePublisher *mqttstate.Publisher
+	stateSite      string
+	lastSwitch     time.Time
+
+	// desktopNotifier pops the path changes on the desktop of a user, nil if
+	// disabled.
+	desktopNotifier *desktop.Notifier
+
+	// pair is the pairing of the instance, with a nil node outside of a pair.
+	pair pairing
+
+	// onFailover and onFailback are the executables run once the traffic moved
+	// to a backup link and back to the primary link, or empty.
+	onFailover string
+	onFailback string
+
+	// pathMTU is the path MTU policy, set by --pmtu.
+	pathMTU *pmtuPolicy
+
+	// routingPolicy is the policy routing of the failover routes.
+	routingPolicy policyRouting
+
+	// routeOwner keeps the routes installed by the tool.
+	routeOwner *reconciler
+
+	// healthInputs combines the probe results with the external health signals.
+	healthInputs *health.Inputs
+
+	// activeFailover is the running failover between the primary link and WiFi,
+	// or nil.
+	activeFailover *failover
+
+	scheduleMu sync.RWMutex
+	// preferences are the scheduled link preferences, the first one
+	// holding the current time applying.
+	preferences []preference
+	// maintenance are the windows without automatic failover or failback.
+	maintenance []schedule.Window
+	// preferLinks are the links a preference may name.
+	preferLinks []string
+	// scheduleWatch starts watchSchedule once.
+	scheduleWatch sync.Once
+
+	// reliabilityHalfLife is the age at which a probe result weighs half as much
+	// in the reliability score.
+	reliabilityHalfLife time.Duration
+
+	// restoreOnExit is set when the routing state the monitor started from is
+	// restored on SIGINT and SIGTERM, as it always is in daemon mode, and
+	// disconnectOnExit when the WiFi connection the monitor made is closed too.
+	restoreOnExit    bool
+	disconnectOnExit bool
+
+	// startupDefaults are the default routes when the monitor started, if
+	// defaultsRecorded is set, and connectedWiFi the WiFi interface the monitor
+	// connected, if any.
+	startupDefaults  []route.Route
+	defaultsRecorded bool
+	connectedWiFi    string
+
+	// minRSSI is the signal strength (dBm) below which the WiFi interface,
+	// rssiIF, is not failed over to and is left as soon as the primary link
+	// answers. It is disabled if 0.
+	minRSSI int
+	rssiIF  string
+
+	// reloaded applies the settings read again on SIGHUP at the next probe
+	// round, and reports whether the endpoints or the detection changed. It
+	// is nil if none is waiting. reloadMu guards it and the writes of the
+	// reloaded settings.
+	reloadMu sync.Mutex
+	reloaded func() bool
+
+	// simulated is the running simulation, nil outside of the simulate command.
+	simulated *simulation
+
+	// statusFile is the path of the status file, none if empty or once removed.
+	// statusFileMu keeps the writes and the removal apart.
+	statusFile   string
+	statusFileMu sync.Mutex
+
+	// tunnels re-homes the WireGuard tunnels.
+	tunnels tunnelRehoming
+
+	// dataCap is the data cap of capIF over a billing period starting on
+	// day resetDay of a month.
+	dataCap   usage.Cap
+	capIF     string
+	resetDay  int
+	trafficMu sync.Mutex
+	// traffic is the traffic of each tracked interface over the current
+	// billing period.
+	traffic map[string]usage.Period
+	// capWarned is the start of the billing period capIF was last reported
+	// over its cap in.
+	capWarned time.Time
+
+	// verifyGrace is the time the probes have to get through the routes moved
+	// to a new link before they are rolled back, not verified if 0.
+	verifyGrace time.Duration
+
+	// liveHub streams samples and decisions to watchers, or is nil.
+	liveHub *live.Hub
+
+	// wiredIFs are the interfaces of type ethernet: they are not associated but
+	// waited for carrier, and have no WiFi signal or channel to check.
+	// minLinkSpeed is the speed (Mbit/s) below which a wired interface is not
+	// failed over to, disabled if 0.
+	wiredIFs     map[string]bool
+	minLinkSpeed int
+}
+
+// NewManager checks config and returns a manager, started by Run.
+func NewManager(config Config) (*Manager, error) {
+	config.setDefaults()
+	targets, err := endpoint.ParseList(config.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	if config.WiFiInterface == "" {
+		return nil, errors.New("the WiFi interface is required")
+	}
+	wired, err := parseInterfaceTypes(config.InterfaceTypes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the interface types: %w", err)
+	}
+	if config.WiFiSSID == "" && !wired[config.WiFiInterface] {
+		return nil, fmt.Errorf("the WiFi SSID is required unless %s is of type ethernet", config.WiFiInterface)
+	}
+	if len(targets) > 0 && (config.Quorum < 0 || config.Quorum > len(targets)) {
+		return nil, fmt.Errorf("invalid quorum %d: it must be between 1 and the number of endpoints, %d", config.Quorum, len(targets))
+	}
+	if config.Metric != 0 {
+		networks := make([]string, len(config.Networks))
+		for i, cidr := range config.Networks {
+			if !strings.Contains(cidr, "@") {
+				cidr += "@" + strconv.Itoa(config.Metric)
+			}
+			networks[i] = cidr
+		}
+		config.Networks = networks
+	}
+	for _, text := range config.Networks {
+		cidr, _, _ := strings.Cut(text, "@")
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", text, err)
+		}
+	}
+	if err := config.Connect.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := time.LoadLocation(config.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid time zone %s: %w", config.Timezone, err)
+	}
+	return newManager(config), nil
+}
+
+// newManager returns a manager of config, checked by the caller.
+func newManager(config Config) *Manager {
+	m := &Manager{config: config, events: make(chan Event, eventBuffer), location: time.Local}
+	m.metrics = metrics.New()
+	m.commands = make(chan commandRequest)
+	m.conntrackFlush = &conntrackPolicy{m: m}
+	m.activeLink = PrimaryLink
+	m.ipFamily = familyIPv4
+	m.prefixLen = map[int]int{route.IPv4: 24, route.IPv6: 64}
+	m.prefixMetrics = map[string]int{}
+	m.endpointRecords = recordsRotate
+	m.faults = m.newFaultInjector()
+	m.repeats = &throttle.Limiter{}
+	m.machine = fsm.New(fsm.MonitoringPrimary)
+	m.routeMetrics = &metricPolicy{m: m}
+	m.prober = &echo.Client{Timeout: 2 * time.Second}
+	m.pinger = &probe.ICMP{Timeout: 2 * time.Second, PayloadSize: 56}
+	m.probeType = probe.TypeICMP
+	m.probeInterval = time.Second
+	m.probeCount = 1
+	m.resolverProber = &probe.DNS{Timeout: 5 * time.Second}
+	m.observed = map[string]net.IP{}
+	m.paths = &pathwatch.Detector{}
+	m.probeQuorum = 1
+	m.detection = detect.Options{Algorithm: detect.Consecutive, Retry: 5}
+	m.endpointStatus = &quorum.Tracker{}
+	m.flaps = &damping.Damper{}
+	m.quality = &sla.Monitor{}
+	m.pathScores = &pathscore.Tracker{}
+	m.outages = &outage.Tracker{}
+	m.savedSysctls = map[string]string{}
+	m.pathMTU = &pmtuPolicy{m: m}
+	m.routeOwner = m.newReconciler()
+	m.healthInputs = &health.Inputs{ProbeWeight: 1}
+	m.resetDay = 1
+	m.traffic = map[string]usage.Period{}
+	m.wiredIFs = map[string]bool{}
+	m.hostNames = &lookup.Cache{Servers: m.nameServers, Timeout: lookupTimeout, MinTTL: 30 * time.Second, MaxTTL: time.Hour, OnChange: m.logResolved}
+	m.changeLog = &changes.Recorder{OnRecord: m.noteChange}
+	m.primaryModem.m, m.pair.m, m.routingPolicy.m, m.tunnels.m = m, m, m, m
+	m.probeCtx, m.stopProbes = context.WithCancel(context.Background())
+	m.routerPinger, m.endpointProber = m.pinger, m.pinger
+	m.namespace = config.Netns
+	m.wifiManager, m.routeManager, m.commandRunner = &NetworkManager{}, Netlink{}, Exec{Namespace: config.Netns}
+	if config.Pinger != nil {
+		m.routerPinger = config.Pinger
+	}
+	if config.WiFi != nil {
+		m.wifiManager = config.WiFi
+	}
+	if config.Routes != nil {
+		m.routeManager = config.Routes
+	}
+	if config.Commands != nil {
+		m.commandRunner = config.Commands
+	}
+	if loc, err := time.LoadLocation(config.Timezone); err == nil && config.Timezone != "" {
+		m.location = loc
+	}
+	m.log = log.Logger
+	if config.Logger != nil {
+		m.log = *config.Logger
+	}
+	return m
+}
+
+// setDefaults gives the settings of c left to zero their default.
+func (c *Config) setDefaults() {
+	c.Interval = orDefault(c.Interval, DefaultInterval)
+	c.Retry = orDefault(c.Retry, DefaultRetry)
+	c.FailbackSuccesses = orDefault(c.FailbackSuccesses, DefaultFailbackSuccesses)
+	c.Protocol = orDefault(c.Protocol, DefaultProtocol)
+	c.Connect.MaxAttempts = orDefault(c.Connect.MaxAttempts, 1)
+	c.Connect.AssociationTimeout = orDefault(c.Connect.AssociationTimeout, 30*time.Second)
+	c.Connect.DHCPTimeout = orDefault(c.Connect.DHCPTimeout, 30*time.Second)
+}
+
+// orDefault returns value, or def if value is zero.
+func orDefault[T comparable](value, def T) T {
+	var zero T
+	if value == zero {
+		return def
+	}
+	return value
+}
+
+// Events returns the events of the manager, closed once Run returns.
+// Events are dropped while the channel is full, so that a slow reader
+// never delays a failover.
+func (m *Manager) Events() <-chan Event {
+	return m.events
+}
+
+// Status returns the state of the manager.
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.machine == nil {
+		return Status{}
+	}
+	state, since := m.machine.State()
+	s := Status{State: state, Since: since, PrimaryInterface: m.primaryIF, Active: m.active, Paused: m.paused.Load()}
+	if s.Active == PrimaryLink {
+		s.Active = s.PrimaryInterface
+	}
+	return s
+}
+
+// emit sends e unless the channel is full or closed.
+func (m *Manager) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.events <- e:
+	default:
+	}
+}
+
+// close closes the events.
+func (m *Manager) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	close(m.events)
+}
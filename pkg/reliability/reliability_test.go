@@ -0,0 +1,360 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/pkg/reliability/fake"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// waitTimeout bounds the wait for an event.
+const waitTimeout = 5 * time.Second
+
+// link simulates a primary link eth0 and a WiFi backup wlan0.
+type link struct {
+	routes   *fake.Routes
+	wifi     *fake.WiFi
+	pinger   *fake.Pinger
+	commands *fake.Commands
+}
+
+func newLink() *link {
+	routes := fake.NewRoutes("eth0")
+	return &link{
+		routes:   routes,
+		wifi:     fake.NewWiFi(routes, "192.168.1.1"),
+		pinger:   fake.NewPinger(routes, 10*time.Millisecond),
+		commands: fake.NewCommands(),
+	}
+}
+
+// config returns a configuration probing fast over the simulated link.
+func (l *link) config() reliability.Config {
+	c := reliability.DefaultConfig()
+	c.Endpoints = []string{"8.8.8.8"}
+	c.WiFiInterface, c.WiFiSSID, c.WiFiPassword = "wlan0", "backup", "secret"
+	c.Pinger, c.WiFi, c.Routes, c.Commands = l.pinger, l.wifi, l.routes, l.commands
+	c.Interval, c.Retry = 10*time.Millisecond, 3
+	return c
+}
+
+// start runs a manager of config until the test ends, and returns it with
+// the channel Run returns on.
+func start(t *testing.T, config reliability.Config) (*reliability.Manager, <-chan error, context.CancelFunc) {
+	t.Helper()
+	m, err := reliability.NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		for range m.Events() {
+		}
+	})
+	return m, done, cancel
+}
+
+// next reads the events of m until one matching match, and returns it.
+func next(t *testing.T, m *reliability.Manager, what string, match func(reliability.Event) bool) reliability.Event {
+	t.Helper()
+	timeout := time.After(waitTimeout)
+	for {
+		select {
+		case e, ok := <-m.Events():
+			if !ok {
+				t.Fatalf("events closed before %s", what)
+			}
+			if match(e) {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("%s not within %s, in %s", what, waitTimeout, m.Status().State)
+		}
+	}
+}
+
+// waitState reads the events of m until a transition to state, and returns
+// its reason.
+func waitState(t *testing.T, m *reliability.Manager, state fsm.State) string {
+	t.Helper()
+	return next(t, m, "transition to "+string(state), func(e reliability.Event) bool {
+		return e.Kind == reliability.EventTransition && e.To == state
+	}).Reason
+}
+
+// waitChange reads the events of m until a change made to the system
+// holding detail, and returns it.
+func waitChange(t *testing.T, m *reliability.Manager, detail string) string {
+	t.Helper()
+	return next(t, m, "change "+detail, func(e reliability.Event) bool {
+		return e.Kind == reliability.EventChange && strings.Contains(e.Reason, detail)
+	}).Reason
+}
+
+// waitProbes reads the events of m until n more probes over ifname, failing
+// on any transition meanwhile.
+func waitProbes(t *testing.T, m *reliability.Manager, ifname string, n int) {
+	t.Helper()
+	next(t, m, fmt.Sprintf("%d probes over %s", n, ifname), func(e reliability.Event) bool {
+		if e.Kind == reliability.EventTransition {
+			t.Fatalf("transition %s while probing", e)
+		}
+		if e.Kind == reliability.EventProbe && e.Interface == ifname {
+			n--
+		}
+		return n == 0
+	})
+}
+
+func TestFailover(t *testing.T) {
+	l := newLink()
+	m, _, _ := start(t, l.config())
+	l.pinger.SetDown("eth0", true)
+	if reason := waitState(t, m, fsm.FailingOver); reason != "primary link failed" {
+		t.Errorf("failover reason %q, want primary link failed", reason)
+	}
+	waitState(t, m, fsm.OnBackup)
+	if ssid := l.wifi.Connected("wlan0"); ssid != "backup" {
+		t.Errorf("wlan0 connected to %q, want backup", ssid)
+	}
+	r, ok := l.routes.Route("8.8.8.0/24")
+	if !ok || r.Device != "wlan0" || r.Gateway != "192.168.1.1" || r.Protocol != reliability.DefaultProtocol {
+		t.Errorf("route of 8.8.8.0/24 %+v (installed %t), want via 192.168.1.1 dev wlan0 proto %d", r, ok, reliability.DefaultProtocol)
+	}
+	status := m.Status()
+	if status.PrimaryInterface != "eth0" || status.Active != "wlan0" {
+		t.Errorf("status %+v, want primary eth0 and active wlan0", status)
+	}
+	if runs := l.commands.Runs(); !slices.Contains(runs, "iw dev wlan0 survey dump") {
+		t.Errorf("programs run %q, want the survey of wlan0 through the runner", runs)
+	}
+}
+
+func TestNoFailoverOnTransientFailures(t *testing.T) {
+	l := newLink()
+	m, _, _ := start(t, l.config())
+	l.pinger.Flap("eth0", false, false, true)
+	waitProbes(t, m, "", 6)
+	if state := m.Status().State; state != fsm.MonitoringPrimary {
+		t.Errorf("state %s after transient failures, want %s", state, fsm.MonitoringPrimary)
+	}
+	if n := l.wifi.Attempts(); n != 0 {
+		t.Errorf("%d WiFi connections, want none", n)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	l := newLink()
+	config := l.config()
+	config.Endpoints = []string{"8.8.8.8", "1.1.1.1", "9.9.9.9"}
+	m, _, _ := start(t, config)
+	l.pinger.SetLost("8.8.8.8", true)
+	waitProbes(t, m, "", 15)
+	if state := m.Status().State; state != fsm.MonitoringPrimary {
+		t.Fatalf("state %s with one endpoint out of 3 failing, want %s", state, fsm.MonitoringPrimary)
+	}
+	l.pinger.SetLost("1.1.1.1", true)
+	waitState(t, m, fsm.OnBackup)
+}
+
+func TestReloadEndpoints(t *testing.T) {
+	l := newLink()
+	config := l.config()
+	reloaded := config
+	reloaded.Endpoints = []string{"1.1.1.1"}
+	config.Reload = func() (reliability.Config, error) { return reloaded, nil }
+	m, _, _ := start(t, config)
+	waitProbes(t, m, "", 2)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	next(t, m, "probe of 1.1.1.1", func(e reliability.Event) bool {
+		return e.Kind == reliability.EventProbe && e.Endpoint == "1.1.1.1"
+	})
+	l.pinger.SetLost("8.8.8.8", true)
+	waitProbes(t, m, "", 6)
+	if state := m.Status().State; state != fsm.MonitoringPrimary {
+		t.Fatalf("state %s with the endpoint removed failing, want %s", state, fsm.MonitoringPrimary)
+	}
+	l.pinger.SetLost("1.1.1.1", true)
+	waitState(t, m, fsm.OnBackup)
+	if r, ok := l.routes.Route("1.1.1.0/24"); !ok || r.Device != "wlan0" {
+		t.Errorf("route of 1.1.1.0/24 %+v (installed %t), want via wlan0", r, ok)
+	}
+	if _, ok := l.routes.Route("8.8.8.0/24"); ok {
+		t.Error("route of 8.8.8.0/24, the endpoint removed, moved to wlan0")
+	}
+}
+
+func TestFailback(t *testing.T) {
+	l := newLink()
+	config := l.config()
+	config.Failback = true
+	config.FailbackSuccesses = 5
+	m, _, _ := start(t, config)
+	l.pinger.SetDown("eth0", true)
+	waitState(t, m, fsm.Recovering)
+	l.pinger.SetDown("eth0", false)
+	waitState(t, m, fsm.FailingBack)
+	if reason := waitState(t, m, fsm.MonitoringPrimary); reason != "failed back" {
+		t.Errorf("reason %q, want failed back", reason)
+	}
+	if n := l.routes.Len(); n != 0 {
+		t.Errorf("%d routes left after failing back, want none", n)
+	}
+	if l.pinger.Probes("eth0") == 0 {
+		t.Error("the primary link was not probed while recovering")
+	}
+}
+
+func TestNoFailbackWhilePrimaryDown(t *testing.T) {
+	l := newLink()
+	config := l.config()
+	config.Failback = true
+	m, _, _ := start(t, config)
+	l.pinger.SetDown("eth0", true)
+	waitState(t, m, fsm.Recovering)
+	waitProbes(t, m, "eth0", 6)
+	if state := m.Status().State; state != fsm.Recovering {
+		t.Errorf("state %s while the primary link is down, want %s", state, fsm.Recovering)
+	}
+	if _, ok := l.routes.Route("8.8.8.0/24"); !ok {
+		t.Error("route through WiFi removed while the primary link is down")
+	}
+}
+
+func TestWiFiConnectFailure(t *testing.T) {
+	l := newLink()
+	l.wifi.Fail(1)
+	config := l.config()
+	config.Connect.MaxAttempts = 1
+	m, done, _ := start(t, config)
+	l.pinger.SetDown("eth0", true)
+	if reason := waitState(t, m, fsm.Stopped); reason != "WiFi connection failed" {
+		t.Errorf("reason %q, want WiFi connection failed", reason)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Run succeeded, want the connection error")
+		}
+	case <-time.After(waitTimeout):
+		t.Fatalf("Run did not return within %s", waitTimeout)
+	}
+	if n := l.routes.Len(); n != 0 {
+		t.Errorf("%d routes installed without WiFi, want none", n)
+	}
+}
+
+func TestRouteFailure(t *testing.T) {
+	l := newLink()
+	l.routes.FailReplace(errors.New("simulated failure"))
+	config := l.config()
+	config.Networks = []string{"8.8.8.0/24"}
+	m, _, _ := start(t, config)
+	l.pinger.SetDown("eth0", true)
+	if reason := waitState(t, m, fsm.MonitoringPrimary); reason != "prefixes not routed" {
+		t.Errorf("reason %q, want prefixes not routed", reason)
+	}
+	if n := l.routes.Len(); n != 0 {
+		t.Errorf("%d routes left, want none", n)
+	}
+}
+
+func TestReassertRemovedRoute(t *testing.T) {
+	l := newLink()
+	m, _, _ := start(t, l.config())
+	l.pinger.SetDown("eth0", true)
+	waitState(t, m, fsm.OnBackup)
+	// Without failback, the route through WiFi stays once the primary link
+	// is back, which keeps the probes answering while it is removed.
+	l.pinger.SetDown("eth0", false)
+	if !l.routes.Remove("8.8.8.0/24") {
+		t.Fatal("no route of 8.8.8.0/24 to remove")
+	}
+	waitChange(t, m, "route re-asserted 8.8.8.0/24")
+	if r, ok := l.routes.Route("8.8.8.0/24"); !ok || r.Device != "wlan0" {
+		t.Errorf("route of 8.8.8.0/24 %+v (installed %t) once re-asserted, want through wlan0", r, ok)
+	}
+}
+
+func TestCascade(t *testing.T) {
+	l := newLink()
+	l.routes.SetGateway("wwan0", route.IPv4, "10.0.0.1")
+	config := l.config()
+	config.Interfaces, config.FailbackSuccesses = []string{"eth0", "wwan0"}, 3
+	m, _, _ := start(t, config)
+	l.pinger.SetDown("eth0", true)
+	waitChange(t, m, "route replaced 8.8.8.0/24")
+	if r, ok := l.routes.Route("8.8.8.0/24"); !ok || r.Device != "wwan0" || r.Gateway != "10.0.0.1" {
+		t.Errorf("route of 8.8.8.0/24 %+v (installed %t), want via 10.0.0.1 dev wwan0", r, ok)
+	}
+	if n := l.wifi.Attempts(); n != 0 {
+		t.Errorf("%d WiFi connections with wwan0 healthy, want none", n)
+	}
+	l.pinger.SetDown("eth0", false)
+	waitChange(t, m, "route removed 8.8.8.0/24")
+	if n := l.routes.Len(); n != 0 {
+		t.Errorf("%d routes left once eth0 is back, want none", n)
+	}
+}
+
+func TestStopRestoresPrimary(t *testing.T) {
+	l := newLink()
+	m, done, cancel := start(t, l.config())
+	l.pinger.SetDown("eth0", true)
+	waitState(t, m, fsm.OnBackup)
+	cancel()
+	waitState(t, m, fsm.Stopped)
+	if err := <-done; err != nil {
+		t.Errorf("Run: %s", err)
+	}
+	// The changes undoing the failover come last, then the events close.
+	for e := range m.Events() {
+		if e.Kind != reliability.EventChange {
+			t.Errorf("event %s once stopped, want only the changes of the shutdown", e)
+		}
+	}
+	if n := l.routes.Len(); n != 0 {
+		t.Errorf("%d routes left once stopped, want none", n)
+	}
+}
+
+func TestNewManager(t *testing.T) {
+	valid := newLink().config()
+	for _, tt := range []struct {
+		name   string
+		modify func(c *reliability.Config)
+	}{
+		{"invalid endpoint", func(c *reliability.Config) { c.Endpoints = []string{"tcp://8.8.8.8:port"} }},
+		{"no WiFi interface", func(c *reliability.Config) { c.WiFiInterface = "" }},
+		{"no SSID", func(c *reliability.Config) { c.WiFiSSID = "" }},
+		{"quorum above endpoints", func(c *reliability.Config) { c.Quorum = 2 }},
+		{"invalid network", func(c *reliability.Config) { c.Networks = []string{"10.0.0.0"} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.modify(&c)
+			if _, err := reliability.NewManager(c); err == nil {
+				t.Error("NewManager succeeded, want an error")
+			}
+		})
+	}
+	if _, err := reliability.NewManager(valid); err != nil {
+		t.Errorf("NewManager: %s", err)
+	}
+}
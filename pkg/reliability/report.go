@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/health"
+)
+
+// SignalOf returns the health signal carried by a health event.
+func SignalOf(e dispatcher.Event) health.Signal {
+	link := e.Interface
+	if link == "" {
+		link = PrimaryLink
+	}
+	return health.Signal{
+		Source:  e.Source,
+		Link:    link,
+		Failure: e.Failure,
+		Weight:  e.Weight,
+		Expires: time.Now().Add(e.Timeout),
+	}
+}
+
+// acceptHealth records the health signal carried by e.
+func (m *Manager) acceptHealth(e dispatcher.Event) {
+	s := SignalOf(e)
+	if err := s.Validate(); err != nil {
+		m.log.Warn().Msgf("Ignoring invalid health report: %s", err)
+		return
+	}
+	m.healthInputs.Set(s)
+	m.log.Info().Msgf("%s reports %.0f%% failures over %s (weight %v, for %s)", s.Source, s.Failure*100, s.Link, s.Weight, e.Timeout)
+}
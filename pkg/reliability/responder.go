@@ -0,0 +1,26 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// ReadKey reads a shared probe key from path. An empty path means no key.
+func ReadKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < 16 {
+		return nil, fmt.Errorf("key in %s is shorter than 16 bytes", path)
+	}
+	return key, nil
+}
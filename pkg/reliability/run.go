@@ -0,0 +1,870 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/bufferbloat"
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/live"
+	"github.com/shynuu/if-reliability/lock"
+	"github.com/shynuu/if-reliability/metrics"
+	"github.com/shynuu/if-reliability/mqttstate"
+	"github.com/shynuu/if-reliability/nm"
+	"github.com/shynuu/if-reliability/persist"
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/replay"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/shynuu/if-reliability/severity"
+	"github.com/shynuu/if-reliability/state"
+	"github.com/shynuu/if-reliability/syslogexport"
+	"github.com/shynuu/if-reliability/usage"
+	"github.com/shynuu/if-reliability/webhook"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// Run monitors the links and fails over until ctx is done or the backup
+// link fails too without failback, then undoes the failover changes as
+// configured and returns. It returns an error if the configuration is
+// invalid or WiFi cannot be connected on failover. A manager runs once.
+func (m *Manager) Run(ctx context.Context) error {
+	if m.started.Swap(true) {
+		return errors.New("the manager already ran")
+	}
+	defer m.close()
+	if err := m.setupLogger(); err != nil {
+		return err
+	}
+	m.probeMu.Lock()
+	m.probeCtx, m.stopProbes = context.WithCancel(ctx)
+	m.probeMu.Unlock()
+	defer m.stopProbes()
+	if m.simulation != nil {
+		m.simulated, m.player = m.simulation, &replay.Player{Fallback: m.simulation.answer}
+		defer func() { m.simulated = nil }()
+		go m.simulation.play(m.probeContext())
+	}
+	m.mu.Lock()
+	m.active = PrimaryLink
+	m.mu.Unlock()
+	m.machine.OnAny(func(t fsm.Transition) {
+		m.emit(Event{Time: t.Time, Kind: EventTransition, From: t.From, To: t.To, Reason: t.Reason})
+	})
+	return m.monitor()
+}
+
+// notePrimary notes the interface of the primary link for Status.
+func (m *Manager) notePrimary(ifname string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.primaryIF = ifname
+}
+
+// noteActive notes the link carrying the traffic for Status.
+func (m *Manager) noteActive(link string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = link
+}
+
+// noteChange sends a change made to the system to the events.
+func (m *Manager) noteChange(c changes.Change) {
+	m.emit(Event{Kind: EventChange, Reason: c.String()})
+}
+
+// tick is time.Tick closed once the manager stops, ending the loops over it
+// with Run.
+func (m *Manager) tick(interval time.Duration) <-chan time.Time {
+	done := m.probeContext().Done()
+	ticks := make(chan time.Time)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer close(ticks)
+		for {
+			select {
+			case <-done:
+				return
+			case t := <-ticker.C:
+				select {
+				case ticks <- t:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return ticks
+}
+
+// stopping reports whether the manager is stopping.
+func (m *Manager) stopping() bool {
+	return m.probeContext().Err() != nil
+}
+
+// shutdown undoes the failover changes as configured once the manager
+// stopped, and saves the history and the state.
+func (m *Manager) shutdown() {
+	m.stopProbes()
+	m.stopDaemon()
+	m.removeStatusFile()
+	m.logExecStats()
+	m.logUnreplayed()
+	m.log.Info().Msg("Exiting the program...")
+	if err := m.samples.Close(); err != nil {
+		m.log.Error().Msgf("Error closing history: %s", err)
+	}
+	if m.store != nil {
+		if err := m.store.Flush(); err != nil {
+			m.log.Error().Msgf("Error saving state: %s", err)
+		}
+	}
+}
+
+// monitor configures the engine from the config of m and runs it.
+func (m *Manager) monitor() error {
+	m.repeats.Burst, m.repeats.Interval = m.config.LogRepeatBurst, m.config.LogRepeatInterval
+	if m.repeats.Burst < 1 || m.repeats.Interval < 0 {
+		return fmt.Errorf("invalid limit of the repeated warnings to %d then one per %s", m.repeats.Burst, m.repeats.Interval)
+	}
+	flushInterval := m.config.FlushInterval
+	fsync := m.config.Fsync
+	policy, err := persist.NewPolicy(flushInterval, fsync)
+	if err != nil {
+		return fmt.Errorf("reading persistence settings: %w", err)
+	}
+	if path := m.config.EventLog; path != "" {
+		if m.eventLog, err = eventlog.Open(path, policy); err != nil {
+			return fmt.Errorf("opening the event log: %w", err)
+		}
+		defer m.eventLog.Close()
+	}
+	m.diagnoseFailures = m.config.Diagnose
+	m.diagnoseHops = m.config.DiagnoseMaxHops
+	if m.diagnoseHops < 1 || m.diagnoseHops > 255 {
+		return fmt.Errorf("invalid --diagnose-max-hops %d: it must be between 1 and 255", m.diagnoseHops)
+	}
+	m.log.Info().Msg("Starting Interface Reliability tool...")
+	m.daemon = m.config.Daemon
+	m.restoreOnExit = m.config.RestoreOnExit
+	m.disconnectOnExit = m.config.DisconnectOnExit
+	if m.dryRun = m.config.DryRun; m.dryRun {
+		m.log.Warn().Msg("Dry run, the routes, NetworkManager and the system are left untouched")
+	}
+	wifiIF := m.config.WiFiInterface
+	typeList := m.config.InterfaceTypes
+	types, err := parseInterfaceTypes(typeList)
+	if err != nil {
+		return fmt.Errorf("parsing --interface-type: %w", err)
+	}
+	m.wiredIFs = types
+	if m.minLinkSpeed = m.config.MinLinkSpeed; m.minLinkSpeed < 0 {
+		return fmt.Errorf("invalid --min-link-speed %d: it is a speed in Mbit/s", m.minLinkSpeed)
+	}
+	wifiSSID := m.config.WiFiSSID
+	if wifiSSID == "" && !m.wired(wifiIF) {
+		return fmt.Errorf("--wifi-ssid is required unless --wifi-if %s is of type ethernet", wifiIF)
+	}
+	wifiPassword := m.config.WiFiPassword
+	registerSecrets(m.config)
+	family := m.config.IPFamily
+	if err := m.setFamily(family); err != nil {
+		return fmt.Errorf("parsing --ip-family: %w", err)
+	}
+	endpointFlags := m.config.Endpoints
+	if len(endpointFlags) == 0 {
+		if endpointFlags, err = m.discoverEndpoints(); err != nil {
+			return fmt.Errorf("no --endpoint given and none discovered: %w", err)
+		}
+		m.gatewayCheck = true
+		m.log.Info().Msgf("No --endpoint given, probing %s; failed rounds probe the gateway too", strings.Join(endpointFlags, ", "))
+	}
+	verifyList := m.config.VerifyEndpoints
+	verifyAttempts := m.config.VerifyAttempts
+	m.verifyGrace = m.config.VerifyGrace
+	if m.verifyGrace < 0 {
+		return fmt.Errorf("invalid --verify-grace %s", m.verifyGrace)
+	}
+	if len(verifyList) == 0 {
+		verifyList = endpointFlags
+	}
+	targets, err := endpoint.ParseList(endpointFlags)
+	if err != nil {
+		return fmt.Errorf("parsing endpoint: %w", err)
+	}
+	if len(targets) == 0 {
+		return errors.New("at least one endpoint is required")
+	}
+	m.prefixLen[route.IPv4] = m.config.IPv4Prefix
+	m.prefixLen[route.IPv6] = m.config.IPv6Prefix
+	if m.prefixLen[route.IPv4] < 1 || m.prefixLen[route.IPv4] > 32 || m.prefixLen[route.IPv6] < 1 || m.prefixLen[route.IPv6] > 128 {
+		return fmt.Errorf("invalid prefix lengths /%d and /%d: expected 1 to 32 for IPv4 and 1 to 128 for IPv6", m.prefixLen[route.IPv4], m.prefixLen[route.IPv6])
+	}
+	if err := m.checkFamilies(targets); err != nil {
+		return fmt.Errorf("checking endpoints: %w", err)
+	}
+	prefixes := m.config.Networks
+	if err := m.setPrefixes(prefixes); err != nil {
+		return fmt.Errorf("parsing --route-prefix: %w", err)
+	}
+	conntrackMode := m.config.ConntrackFlush
+	if m.conntrackFlush, err = m.newConntrackPolicy(conntrackMode); err != nil {
+		return fmt.Errorf("parsing --conntrack-flush: %w", err)
+	}
+	pmtuMode := m.config.PMTU
+	if m.pathMTU, err = m.newPMTUPolicy(pmtuMode, targets[0].Host); err != nil {
+		return fmt.Errorf("parsing --pmtu: %w", err)
+	}
+	failoverMode := m.config.FailoverMode
+	primaryMetric := m.config.PrimaryMetric
+	backupMetric := m.config.BackupMetric
+	failoverMetric := m.config.FailoverMetric
+	if m.routeMetrics, err = m.newMetricPolicy(failoverMode, primaryMetric, backupMetric, failoverMetric); err != nil {
+		return fmt.Errorf("parsing --failover-mode: %w", err)
+	}
+	settings, err := parseProbeSettings(m.config, targets)
+	if err != nil {
+		return err
+	}
+	m.setProbeSettings(settings)
+	m.probeCount = m.config.ProbeCount
+	if m.probeCount < 1 {
+		return fmt.Errorf("invalid --probe-count %d: at least one probe per round is needed", m.probeCount)
+	}
+	verifyEndpoints, err := endpoint.ParseList(verifyList)
+	if err != nil {
+		return fmt.Errorf("parsing verification endpoint: %w", err)
+	}
+
+	if err := m.configureProbes(); err != nil {
+		return fmt.Errorf("configuring probes: %w", err)
+	}
+	if m.config.Pinger != nil {
+		m.endpointProber = m.config.Pinger
+	}
+	bufferbloatURL := m.config.BufferbloatURL
+	bufferbloatDuration := m.config.BufferbloatDuration
+	bufferbloatMinGrade := m.config.BufferbloatMinGrade
+	if bufferbloatMinGrade != "" && !bufferbloat.Valid(bufferbloatMinGrade) {
+		return fmt.Errorf("invalid bufferbloat grade: %s", bufferbloatMinGrade)
+	}
+	minWiFiHealth := m.config.MinWiFiHealth
+	m.minRSSI = m.config.MinRSSI
+	if m.minRSSI > 0 || m.minRSSI < -120 {
+		return fmt.Errorf("invalid --min-rssi %d: it is a signal strength in dBm between -120 and 0", m.minRSSI)
+	}
+	if !m.wired(wifiIF) {
+		m.rssiIF = wifiIF
+	}
+	m.primaryModem.ifname = m.config.Modem
+	m.primaryModem.thresholds.MinRSRP = m.config.MinRSRP
+	m.primaryModem.thresholds.MinRSRQ = m.config.MinRSRQ
+	m.primaryModem.thresholds.MinSINR = m.config.MinSINR
+	m.primaryModem.reconnect = m.config.ModemReconnect
+	m.primaryModem.timeout = m.config.ModemReconnectTimeout
+	if !m.primaryModem.enabled() && (m.primaryModem.thresholds.Enabled() || m.primaryModem.reconnect) {
+		return errors.New("--min-rsrp, --min-rsrq, --min-sinr and --modem-reconnect need --modem")
+	}
+	if m.primaryModem.ifname == wifiIF {
+		return fmt.Errorf("--modem %s is the WiFi interface", m.primaryModem.ifname)
+	}
+	if m.primaryModem.timeout <= 0 {
+		return fmt.Errorf("invalid --modem-reconnect-timeout %s", m.primaryModem.timeout)
+	}
+	if size := m.config.DataCap; size != "" {
+		if m.dataCap.Bytes, err = usage.ParseSize(size); err != nil || m.dataCap.Bytes == 0 {
+			return fmt.Errorf("invalid --data-cap %q: it is a size, e.g. 20GB", size)
+		}
+	}
+	threshold := m.config.DataCapThreshold
+	if threshold <= 0 || threshold > 100 {
+		return fmt.Errorf("invalid --data-cap-threshold %g: it must be between 0 and 100", threshold)
+	}
+	m.dataCap.Threshold = threshold / 100
+	m.resetDay = m.config.DataCapResetDay
+	if m.resetDay < 1 || m.resetDay > 28 {
+		return fmt.Errorf("invalid --data-cap-reset-day %d: it must be between 1 and 28", m.resetDay)
+	}
+	m.capIF = m.config.DataCapInterface
+	if m.capIF == "" {
+		m.capIF = m.primaryModem.ifname
+	}
+	if m.dataCap.Bytes > 0 && m.capIF == "" {
+		return errors.New("--data-cap needs --data-cap-interface or --modem")
+	}
+	if m.dataCap.Bytes > 0 && m.capIF == wifiIF {
+		return fmt.Errorf("--data-cap-interface %s is the WiFi interface the traffic moves to", m.capIF)
+	}
+	m.hints.RTOMin = m.config.RouteRTOMin
+	m.hints.QuickAck = m.config.RouteQuickAck
+	m.hints.InitCwnd = m.config.RouteInitCwnd
+	m.hints.KeepaliveTime = m.config.TCPKeepaliveTime
+	m.hints.KeepaliveInterval = m.config.TCPKeepaliveInterval
+	m.hints.KeepaliveProbes = m.config.TCPKeepaliveProbes
+	m.routeProto = m.config.Protocol
+	m.routeRealm = m.config.RouteRealm
+	m.routeOwner.mode = m.config.ReconcileRoutes
+	m.routeOwner.backoff = m.config.ReconcileBackoff
+	m.routeOwner.maxBackoff = m.config.ReconcileMaxBackoff
+	switch {
+	case m.routeOwner.mode != reconcileReassert && m.routeOwner.mode != reconcileLog && m.routeOwner.mode != reconcileOff:
+		return fmt.Errorf("invalid --reconcile-routes %q: it must be %s, %s or %s", m.routeOwner.mode, reconcileReassert, reconcileLog, reconcileOff)
+	case m.routeOwner.backoff <= 0 || m.routeOwner.maxBackoff < m.routeOwner.backoff:
+		return fmt.Errorf("invalid route reconciliation backoff %s up to %s", m.routeOwner.backoff, m.routeOwner.maxBackoff)
+	}
+	m.slowExec = m.config.SlowExec
+	m.vrf = m.config.VRF
+	ifaces := m.config.Interfaces
+	if len(ifaces) == 1 {
+		return errors.New("--interfaces needs at least two interfaces")
+	}
+	if len(ifaces) > 0 && m.vrf != "" {
+		return errors.New("--interfaces cannot be combined with --vrf")
+	}
+	if hooks := m.config.Hooks; len(hooks) > 0 && len(ifaces) > 0 {
+		return errors.New("--hook runs on the states of the failover, which --interfaces does not go through: use --on-failover and --on-failback")
+	}
+	if balance := m.config.LoadBalance; balance && len(ifaces) == 0 {
+		return errors.New("--load-balance needs --interfaces")
+	}
+	bestPath := m.config.BestPath
+	if bestPath && len(ifaces) == 0 {
+		return errors.New("--best-path needs --interfaces")
+	}
+	if balance := m.config.LoadBalance; balance && bestPath {
+		return errors.New("--best-path cannot be combined with --load-balance")
+	}
+	bestPathMargin := m.config.BestPathMargin
+	if bestPathMargin < 0 || bestPathMargin > 100 {
+		return fmt.Errorf("invalid --best-path-margin %g: it must be between 0 and 100", bestPathMargin)
+	}
+	preferList := m.config.Preferences
+	m.preferLinks = ifaces
+	if len(m.preferLinks) == 0 {
+		m.preferLinks = []string{PrimaryLink, wifiIF}
+	}
+	prefs, err := parsePreferences(preferList, m.preferLinks)
+	if err != nil {
+		return fmt.Errorf("parsing --prefer: %w", err)
+	}
+	windowList := m.config.MaintenanceWindows
+	windows, err := parseWindows(windowList)
+	if err != nil {
+		return fmt.Errorf("parsing --maintenance-window: %w", err)
+	}
+	m.setSchedule(prefs, windows)
+	if len(ifaces) > 0 && m.routeMetrics.enabled() {
+		return fmt.Errorf("--interfaces cannot be combined with --failover-mode %s", modeMetric)
+	}
+	routeTable := m.config.RouteTable
+	rulePriority := m.config.RulePriority
+	ruleFwmark := m.config.RuleFwmark
+	ruleFrom := m.config.RuleFrom
+	ruleCgroups := m.config.RuleCgroups
+	if m.routingPolicy, err = m.newPolicyRouting(routeTable, rulePriority, ruleFwmark, ruleFrom, ruleCgroups); err != nil {
+		return fmt.Errorf("invalid policy routing: %w", err)
+	}
+	if m.routingPolicy.enabled() && m.vrf != "" {
+		return errors.New("--route-table cannot be combined with --vrf, whose table already holds the routes")
+	}
+	if m.vrf != "" {
+		for i := range targets {
+			targets[i] = targets[i].Bind(m.vrf)
+		}
+	}
+	target := targets[0]
+
+	config, err := encodeConfig(m.config, false)
+	if err != nil {
+		return fmt.Errorf("encoding configuration: %w", err)
+	}
+	m.log.Info().RawJSON("config", config).Msgf("Monitoring %s, failing over to %s", endpointList(targets), wifiIF)
+
+	recordPath := m.config.Record
+	replayPath := m.config.Replay
+	switch {
+	case recordPath != "" && replayPath != "":
+		return errors.New("--record and --replay are mutually exclusive")
+	case replayPath != "":
+		if m.player, err = replay.Load(replayPath); err != nil {
+			return fmt.Errorf("loading recording: %w", err)
+		}
+		m.log.Warn().Msgf("Replaying the WiFi backend from %s, no command is run", replayPath)
+		m.nmWatcher = nm.NewWatcher(true)
+		go m.player.NM(m.nmWatcher.Set)
+	case recordPath != "":
+		if m.recorder, err = replay.Create(recordPath); err != nil {
+			return fmt.Errorf("creating recording: %w", err)
+		}
+		m.log.Info().Msgf("Recording the WiFi backend into %s", recordPath)
+	}
+	if lockDir := m.config.LockDir; lockDir != "" {
+		takeover := m.config.Takeover
+		instanceLock, err := lock.Acquire(lockDir, m.lockNames(targets, ifaces, wifiIF), takeover)
+		if err != nil {
+			return fmt.Errorf("acquiring instance lock: %w", err)
+		}
+		defer instanceLock.Release()
+	}
+
+	useDispatcher := m.config.Dispatcher
+	if triggerSocket := m.config.TriggerSocket; triggerSocket != "" || useDispatcher {
+		events, conn, err := dispatcher.Listen(triggerSocket)
+		switch {
+		case err == nil:
+			defer conn.Close()
+			m.triggers = events
+		case useDispatcher:
+			return fmt.Errorf("listening for dispatcher events: %w", err)
+		default:
+			m.log.Warn().Msgf("Cannot listen on %s, evacuate requests are unavailable: %s", triggerSocket, err)
+		}
+	}
+	if watchSocket := m.config.WatchSocket; watchSocket != "" {
+		if m.liveHub, err = live.Listen(watchSocket); err != nil {
+			m.log.Warn().Msgf("Cannot listen on %s, watch is unavailable: %s", watchSocket, err)
+		} else {
+			defer m.liveHub.Close()
+		}
+	}
+
+	controlSocket := m.config.ControlSocket
+	controlListen := m.config.ControlListen
+	if controlSocket != "" || controlListen != "" {
+		tokenFile := m.config.ControlTokenFile
+		token, err := ReadKey(tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading the control token: %w", err)
+		}
+		if server, err := control.Listen(controlSocket, controlListen, string(token), m.controlBackend()); err != nil {
+			m.log.Warn().Msgf("Cannot serve the control API: %s", err)
+		} else {
+			defer server.Close()
+		}
+	}
+
+	if metricsListen := m.config.MetricsListen; metricsListen != "" {
+		server, err := m.metrics.Listen(metricsListen)
+		if err != nil {
+			return fmt.Errorf("listening for metrics scrapes: %w", err)
+		}
+		defer server.Close()
+		m.log.Info().Msgf("Serving metrics on http://%s%s", metricsListen, metrics.Path)
+	}
+	m.metrics.AddLink(wifiIF)
+	m.metrics.SetActive(PrimaryLink)
+	m.metrics.SetState(string(fsm.MonitoringPrimary))
+	m.machine.OnAny(func(t fsm.Transition) { m.metrics.SetState(string(t.To)) })
+
+	if m.statusFile = m.config.StatusFile; m.statusFile != "" {
+		interval := m.config.StatusFileInterval
+		if interval < time.Second {
+			return fmt.Errorf("invalid --status-file-interval %s: at least 1s", interval)
+		}
+		m.machine.OnAny(func(fsm.Transition) { go m.writeStatusFile() })
+		go m.writeStatusFileEvery(interval)
+		m.log.Info().Msgf("Writing the status to %s every %s", m.statusFile, interval)
+	}
+	if heartbeat := m.config.LogHeartbeat; heartbeat > 0 {
+		go m.logHeartbeat(heartbeat)
+	}
+
+	syslogAddr := m.config.SyslogAddr
+	if syslogAddr != "" {
+		syslogNetwork := m.config.SyslogNetwork
+		healthyRatio := m.config.SyslogSampleHealthy
+		degradedRatio := m.config.SyslogSampleDegraded
+		syslogSeverity := m.config.SyslogSeverity
+		minSeverity, err := severity.Parse(syslogSeverity)
+		if err != nil {
+			return fmt.Errorf("invalid --syslog-severity: %w", err)
+		}
+		m.exporter, err = syslogexport.Dial(syslogNetwork, syslogAddr, healthyRatio, degradedRatio, minSeverity)
+		if err != nil {
+			return fmt.Errorf("connecting to syslog server: %w", err)
+		}
+		defer m.exporter.Close()
+	}
+
+	if broker := m.config.MQTTBroker; broker != "" {
+		m.stateSite = m.config.SiteID
+		if m.stateSite == "" {
+			m.stateSite, _ = os.Hostname()
+		}
+		o := mqttstate.Options{Broker: broker, Topic: "if-reliability/" + m.stateSite, ClientID: "if-reliability-" + m.stateSite, Timeout: 5 * time.Second}
+		if m.config.Group != "" {
+			o.Topic += "/" + m.config.Group
+			o.ClientID += "-" + m.config.Group
+		}
+		if topic := m.config.MQTTTopic; topic != "" {
+			o.Topic = topic
+		}
+		if clientID := m.config.MQTTClientID; clientID != "" {
+			o.ClientID = clientID
+		}
+		o.Username = m.config.MQTTUsername
+		o.Password = m.config.MQTTPassword
+		o.CAFile = m.config.MQTTCAFile
+		o.CertFile = m.config.MQTTCertFile
+		o.KeyFile = m.config.MQTTKeyFile
+		qos := m.config.MQTTQoS
+		if qos < 0 || qos > 2 {
+			return fmt.Errorf("invalid --mqtt-qos %d: expected 0, 1 or 2", qos)
+		}
+		o.QoS = byte(qos)
+		if (o.CertFile == "") != (o.KeyFile == "") {
+			return errors.New("--mqtt-cert-file and --mqtt-key-file go together")
+		}
+		interval := m.config.MQTTInterval
+		if interval < time.Second {
+			return fmt.Errorf("invalid --mqtt-interval %s: at least 1s", interval)
+		}
+		publisher, err := mqttstate.Connect(o)
+		if publisher == nil {
+			return fmt.Errorf("invalid MQTT settings: %w", err)
+		}
+		if err != nil {
+			m.log.Warn().Msgf("MQTT broker unavailable, retrying in the background: %s", err)
+		} else {
+			m.log.Info().Msgf("Publishing the state to %s on %s", o.Topic, broker)
+		}
+		m.statePublisher = publisher
+		m.machine.OnAny(func(fsm.Transition) { go m.publishState() })
+		go m.publishStateEvery(interval)
+	}
+
+	connectOptions := m.config.Connect
+	for _, text := range m.config.WiFiFallbacks {
+		network, err := wifi.ParseNetwork(text)
+		if err != nil {
+			return fmt.Errorf("parsing --wifi-fallback: %w", err)
+		}
+		connectOptions.Fallbacks = append(connectOptions.Fallbacks, network)
+	}
+	if eap := m.config.WiFiEAP; eap != "" {
+		e := &wifi.Enterprise{EAP: eap}
+		e.Identity = m.config.WiFiIdentity
+		e.AnonymousIdentity = m.config.WiFiAnonymousIdentity
+		e.CACert = m.config.WiFiCACert
+		e.ClientCert = m.config.WiFiClientCert
+		e.PrivateKey = m.config.WiFiPrivateKey
+		e.Phase2 = m.config.WiFiPhase2
+		if e.CACert == "" {
+			m.log.Warn().Msgf("No --wifi-ca-cert, the 802.1X server of %s is not authenticated", wifiSSID)
+		}
+		connectOptions.Enterprise = e
+	}
+	if err := connectOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid WiFi connect settings: %w", err)
+	}
+	m.log.Info().Msgf("WiFi connect phase bounded to %s per network", connectOptions.MaxDuration())
+	failback := m.config.Failback
+	m.flaps.HoldDown = m.config.HoldDown
+	m.flaps.MaxHoldDown = m.config.HoldDownMax
+	m.flaps.HalfLife = m.config.HoldDownHalfLife
+	m.minDwell = m.config.MinDwell
+	var chrony chronyPolicy
+	chrony.primary = m.config.ChronyPrimaryServers
+	chrony.backup = m.config.ChronyBackupServers
+	chrony.stratum = m.config.ChronyFailoverStratum
+	dnsMode := m.config.DNSMode
+	dnsServers := m.config.DNSServers
+	resolvConf := m.config.ResolvConf
+	dns, err := m.newDNSPolicy(dnsMode, resolvConf, dnsServers)
+	if err != nil {
+		return fmt.Errorf("invalid DNS settings: %w", err)
+	}
+	firewallRules := m.config.FirewallRules
+	fw, err := m.newFirewallPolicy(firewallRules)
+	if err != nil {
+		return fmt.Errorf("parsing --firewall-rule: %w", err)
+	}
+	var captive portalCheck
+	captive.url = m.config.PortalCheck
+	captive.loginHook = m.config.PortalLoginHook
+	captive.timeout = m.config.PortalTimeout
+	if captive.loginHook != "" && !captive.enabled() {
+		return errors.New("--portal-login-hook needs --portal-check")
+	}
+	minThroughput := m.config.MinThroughput
+	throughputURL := m.config.ThroughputURL
+	throughputIperf3 := m.config.ThroughputIperf3
+	throughputDuration := m.config.ThroughputDuration
+	rate, err := m.newThroughputCheck(minThroughput, throughputURL, throughputIperf3, throughputDuration)
+	if err != nil {
+		return fmt.Errorf("invalid throughput test: %w", err)
+	}
+	var spare coldSpare
+	spare.rfkill = m.config.ColdSpareRFKill
+	spare.powerCmd = m.config.ColdSparePowerCommand
+	spare.timeout = m.config.ColdSpareTimeout
+	standby := &warmStandby{m: m, endpoints: verifyEndpoints, quorum: min(m.probeQuorum, len(verifyEndpoints))}
+	standby.interval = m.config.WarmStandby
+	standby.retry = m.config.WarmStandbyRetry
+	if standby.enabled() && spare.enabled() {
+		return errors.New("--warm-standby cannot be combined with a cold spare, whose radio stays off until a failover")
+	}
+	if standby.enabled() && len(ifaces) > 0 {
+		return errors.New("--warm-standby cannot be combined with --interfaces, which already probes every interface")
+	}
+	if standby.interval < 0 || standby.retry < 1 {
+		return fmt.Errorf("invalid warm standby settings: interval %s, retry %d", standby.interval, standby.retry)
+	}
+	m.nmRestartTimeout = m.config.NMRestartTimeout
+	if (m.daemon || m.restoreOnExit) && m.player == nil {
+		m.recordDefaults()
+	}
+	if m.primaryModem.enabled() {
+		if err := m.primaryModem.attach(); err != nil {
+			return fmt.Errorf("finding the modem of %s: %w", m.primaryModem.ifname, err)
+		}
+	}
+	if carrierWatch := m.config.CarrierWatch; carrierWatch && m.player == nil {
+		if m.triggers, err = m.watchCarrier(m.triggers); err != nil {
+			m.log.Warn().Msgf("Cannot follow the link states, carrier losses are only noticed by the probes: %s", err)
+		}
+	}
+	if gatewayWatch := m.config.GatewayProbe; gatewayWatch && m.player == nil {
+		g := &gatewayProbe{m: m}
+		g.interval = m.config.GatewayInterval
+		g.retry = m.config.GatewayRetry
+		if g.interval < 10*time.Millisecond || g.retry < 1 {
+			return fmt.Errorf("invalid gateway probe settings: interval %s, retry %d", g.interval, g.retry)
+		}
+		g.prober = &probe.Neighbor{Timeout: g.interval}
+		links := func() []string { return []string{m.defaultIF} }
+		if len(ifaces) > 0 {
+			links = func() []string { return ifaces }
+		}
+		m.triggers = g.watchGateways(m.triggers, links)
+	}
+	if reflector := m.config.EchoReflector; reflector != "" && m.player == nil {
+		w := &echoWatch{m: m, address: strings.TrimPrefix(reflector, "udp://")}
+		w.interval = m.config.EchoInterval
+		w.multiplier = m.config.EchoMultiplier
+		w.maxJitter = m.config.EchoMaxJitter
+		if _, _, err := net.SplitHostPort(w.address); err != nil {
+			return fmt.Errorf("invalid echo reflector %q: %w", reflector, err)
+		}
+		if w.interval < 10*time.Millisecond || w.multiplier < 1 || w.maxJitter < 0 {
+			return fmt.Errorf("invalid echo settings: interval %s, multiplier %d, maximum jitter %s", w.interval, w.multiplier, w.maxJitter)
+		}
+		keyFile := m.config.ProbeKeyFile
+		if w.key, err = ReadKey(keyFile); err != nil {
+			return fmt.Errorf("reading the probe key: %w", err)
+		}
+		links := func() []string { return []string{m.defaultIF} }
+		if len(ifaces) > 0 {
+			links = func() []string { return ifaces }
+		}
+		m.triggers = w.watchEcho(m.triggers, links)
+		m.log.Info().Msgf("Streaming echo requests to %s every %s, a path down after %s without a reply", w.address, w.interval, time.Duration(w.multiplier)*w.interval)
+	}
+	m.triggers = m.withInjected(m.triggers)
+	if _, ok := m.wifiManager.(*NetworkManager); ok && m.namespace == "" && m.player == nil {
+		if m.nmWatcher, err = nm.Watch(); err != nil {
+			m.log.Warn().Msgf("Cannot follow NetworkManager restarts: %s", err)
+		}
+		if m.nmWatcher != nil && m.recorder != nil {
+			m.recorder.NM(m.nmWatcher.Available())
+			m.nmWatcher.OnChange(m.recorder.NM)
+		}
+	}
+
+	historySize := m.config.HistorySize
+	snapshot := m.config.HistorySnapshot
+	ring, err := history.NewRing(historySize, snapshot, policy)
+	if err != nil {
+		return fmt.Errorf("creating history: %w", err)
+	}
+	m.samples = ring
+	if dir := m.config.HistoryDir; dir != "" {
+		retention := m.config.HistoryRetention
+		var maxSize uint64
+		if size := m.config.HistoryMaxSize; size != "" {
+			if maxSize, err = usage.ParseSize(size); err != nil {
+				return fmt.Errorf("invalid --history-max-size %q: it is a size, e.g. 100MB", size)
+			}
+		}
+		if retention < 0 {
+			return fmt.Errorf("invalid --history-retention %s", retention)
+		}
+		if m.archive, err = history.OpenArchive(dir, retention, int64(maxSize), policy); err != nil {
+			return fmt.Errorf("opening the history archive: %w", err)
+		}
+		m.samples = history.Archived{Store: ring, Archive: m.archive}
+		m.machine.OnAny(func(t fsm.Transition) {
+			m.archiveEvent(history.Event{Time: t.Time.UTC(), Kind: history.EventTransition, From: string(t.From), To: string(t.To), Reason: t.Reason})
+		})
+		m.log.Info().Msgf("Archiving the history to %s", dir)
+	}
+
+	statePath := m.config.StateFile
+	if statePath != "" {
+		if m.store, err = state.Open(statePath, policy.Fsync); err != nil {
+			m.log.Warn().Msgf("Cannot open state file, backup verification and reliability are not tracked: %s", err)
+		} else {
+			go m.store.FlushEvery(policy.FlushInterval)
+		}
+	}
+	periods := m.config.AvailabilityPeriods
+	for _, period := range periods {
+		if period < time.Minute || (24*time.Hour)%period != 0 && period%(24*time.Hour) != 0 {
+			return fmt.Errorf("invalid availability period %s: it must divide a day or be a number of days", period)
+		}
+	}
+	report := m.config.AvailabilityReport
+	if err := m.startAvailability(periods, report, policy); err != nil {
+		return fmt.Errorf("opening the availability report: %w", err)
+	}
+	defer m.closeAvailability()
+	m.reliabilityHalfLife = m.config.ReliabilityHalfLife
+	m.healthInputs.ProbeWeight = m.config.ProbeWeight
+	m.logReliability()
+	m.exportState()
+	usageInterval := m.config.UsageInterval
+	if m.dataCap.Bytes > 0 && usageInterval <= 0 {
+		return errors.New("--data-cap needs --usage-interval")
+	}
+	if usageInterval > 0 && m.player == nil {
+		m.startUsage(m.usageLinks(ifaces, m.primaryModem.ifname, wifiIF), usageInterval)
+	}
+	m.tunnels.ifaces = m.config.WireGuardInterfaces
+	m.tunnels.timeout = m.config.WireGuardHandshakeTimeout
+	tunnelHosts := m.config.WireGuardEndpoints
+	if m.tunnels.hosts, err = parseTunnelHosts(tunnelHosts); err != nil {
+		return fmt.Errorf("parsing --wireguard-endpoint: %w", err)
+	}
+	if len(m.tunnels.hosts) > 0 && !m.tunnels.enabled() {
+		return errors.New("--wireguard-endpoint needs --wireguard")
+	}
+	m.onFailover = m.config.OnFailover
+	m.onFailback = m.config.OnFailback
+	backupMaxAge := m.config.BackupMaxAge
+	drill := m.config.Drill
+	if drill {
+		if !m.runDrill(spare, wifiIF, wifiSSID, wifiPassword, connectOptions, verifyEndpoints, verifyAttempts, minWiFiHealth) {
+			return fmt.Errorf("the drill over %s failed", wifiIF)
+		}
+		return nil
+	}
+	m.checkBackupAge(wifiIF, backupMaxAge)
+	go m.watchBackupAge(wifiIF, backupMaxAge)
+
+	if bufferbloatURL != "" {
+		m.measureBufferbloat(bufferbloatURL, target, bufferbloatDuration, "")
+	}
+	spare.park()
+	if m.routingPolicy.enabled() {
+		if err := m.routingPolicy.install(); err != nil {
+			return fmt.Errorf("setting up policy routing: %w", err)
+		}
+	}
+	if m.routeOwner.mode != reconcileOff && m.player == nil && !m.dryRun {
+		if err := m.routeOwner.watch(); err != nil {
+			m.log.Warn().Msgf("Cannot follow the routing table, routes changed by other programs are not noticed: %s", err)
+		}
+	}
+	if err := m.setupDesktopNotify(); err != nil {
+		return fmt.Errorf("setting up the desktop notifications: %w", err)
+	}
+	if err := m.setupAlerts(); err != nil {
+		return fmt.Errorf("setting up the alerts: %w", err)
+	}
+	if err := m.setupPair(); err != nil {
+		return fmt.Errorf("setting up the gateway pair: %w", err)
+	}
+	if m.pair.enabled() {
+		m.pair.start()
+	}
+	if m.config.Reload != nil {
+		m.handleSignals()
+	}
+	var notifier *webhook.Notifier
+	site := m.config.SiteID
+	if webhookURL := m.config.WebhookURL; webhookURL != "" {
+		if site == "" {
+			site, _ = os.Hostname()
+		}
+		webhookTimeout := m.config.WebhookTimeout
+		webhookAttempts := m.config.WebhookAttempts
+		webhookBackoff := m.config.WebhookBackoff
+		notifier, err = webhook.New(webhookURL, webhookTimeout, webhookAttempts, webhookBackoff)
+		if err != nil {
+			return fmt.Errorf("invalid webhook settings: %w", err)
+		}
+		// Deferred before shutdown, so that the transitions of the
+		// shutdown are delivered too.
+		defer m.closeWebhook(notifier)
+	}
+	defer m.shutdown()
+	if len(ifaces) > 0 {
+		m.startDaemon(fmt.Sprintf("Monitoring %s over %s", endpointList(targets), strings.Join(ifaces, ", ")))
+		c := m.newCascade(ifaces)
+		if m.config.LoadBalance {
+			weightList := m.config.Weights
+			weights, err := parseWeights(weightList, ifaces)
+			if err != nil {
+				return fmt.Errorf("parsing --weight: %w", err)
+			}
+			c.balance = &balancer{m: m, weights: weights}
+		}
+		c.bestPath, c.margin = bestPath, bestPathMargin
+		c.webhook, c.site = notifier, site
+		c.run(wifiIF, wifiSSID, wifiPassword, connectOptions)
+		return nil
+	}
+	m.machine.OnAny(m.logState)
+	hooks := m.config.Hooks
+	if err := m.registerHooks(hooks); err != nil {
+		return fmt.Errorf("registering hooks: %w", err)
+	}
+	m.startDaemon(fmt.Sprintf("Monitoring %s over the primary link", endpointList(targets)))
+	f := &failover{m: m,
+		verifyEndpoints:     verifyEndpoints,
+		verifyAttempts:      verifyAttempts,
+		minWiFiHealth:       minWiFiHealth,
+		wifiIF:              wifiIF,
+		wifiSSID:            wifiSSID,
+		wifiPassword:        wifiPassword,
+		connect:             connectOptions,
+		spare:               spare,
+		standby:             standby,
+		chrony:              chrony,
+		dns:                 dns,
+		firewall:            fw,
+		portal:              captive,
+		throughput:          rate,
+		bufferbloatURL:      bufferbloatURL,
+		bufferbloatDuration: bufferbloatDuration,
+		bufferbloatMinGrade: bufferbloatMinGrade,
+		failback:            failback,
+	}
+	if notifier != nil {
+		m.machine.OnAny(f.webhookHook(notifier, site))
+	}
+	if m.alerts != nil {
+		m.machine.OnAny(f.alertHook())
+	}
+	if standby.enabled() {
+		standby.start(wifiIF, wifiSSID, wifiPassword, connectOptions)
+	}
+	m.activeFailover = f
+	err = f.run()
+	m.flushAlerts()
+	return err
+}
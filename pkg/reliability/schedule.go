@@ -0,0 +1,141 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/schedule"
+)
+
+// preference is a link preferred over the others during a window, even
+// while they are healthy.
+type preference struct {
+	ifname string
+	window schedule.Window
+}
+
+// setSchedule replaces the link preferences and maintenance windows, and
+// starts following them if there are any.
+func (m *Manager) setSchedule(prefs []preference, windows []schedule.Window) {
+	m.scheduleMu.Lock()
+	m.preferences, m.maintenance = prefs, windows
+	m.scheduleMu.Unlock()
+	if len(prefs) > 0 || len(windows) > 0 {
+		m.scheduleWatch.Do(func() { go m.watchSchedule(10 * time.Second) })
+	}
+}
+
+// parsePreferences parses --prefer values, interface@window, where
+// interface is one of links.
+func parsePreferences(values []string, links []string) ([]preference, error) {
+	var prefs []preference
+	for _, value := range values {
+		ifname, text, ok := strings.Cut(value, "@")
+		if !ok || ifname == "" {
+			return nil, fmt.Errorf("invalid preference %q: it must be interface@window", value)
+		}
+		known := false
+		for _, link := range links {
+			known = known || link != "" && link == ifname
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid preference %q: %s is not one of %s", value, ifname, strings.Join(links, ", "))
+		}
+		window, err := schedule.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		prefs = append(prefs, preference{ifname: ifname, window: window})
+	}
+	return prefs, nil
+}
+
+// parseWindows parses --maintenance-window values.
+func parseWindows(values []string) ([]schedule.Window, error) {
+	var windows []schedule.Window
+	for _, text := range values {
+		w, err := schedule.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// scheduledLink returns the link preferred at now and the window preferring
+// it, or an empty string if none is.
+func (m *Manager) scheduledLink(now time.Time) (string, schedule.Window) {
+	now = now.In(m.location)
+	m.scheduleMu.RLock()
+	defer m.scheduleMu.RUnlock()
+	for _, p := range m.preferences {
+		if p.window.Contains(now) {
+			return p.ifname, p.window
+		}
+	}
+	return "", schedule.Window{}
+}
+
+// inMaintenance returns the maintenance window holding now, and false if
+// none does.
+func (m *Manager) inMaintenance(now time.Time) (schedule.Window, bool) {
+	m.scheduleMu.RLock()
+	defer m.scheduleMu.RUnlock()
+	return schedule.Any(m.maintenance, now.In(m.location))
+}
+
+// suspended reports whether the automatic failovers and failbacks are
+// paused from the control API, during a maintenance window, or while the
+// instance stands by for the master of its pair.
+func (m *Manager) suspended() bool {
+	_, ok := m.inMaintenance(time.Now())
+	return m.paused.Load() || ok || m.pair.standby()
+}
+
+// avoided reports whether traffic should leave ifname even while it is
+// healthy, over its data cap or while another link is preferred, with the
+// reason. Preferring the primary link is what happens anyway.
+func (m *Manager) avoided(ifname string) (bool, string) {
+	if capped, why := m.overCap(ifname); capped {
+		return true, why
+	}
+	preferred, window := m.scheduledLink(time.Now())
+	if preferred == "" || preferred == PrimaryLink || ifname == "" || preferred == ifname {
+		return false, ""
+	}
+	return true, fmt.Sprintf("%s preferred during %s", preferred, window)
+}
+
+// watchSchedule logs the maintenance windows and scheduled preferences
+// starting and ending, checking every interval.
+func (m *Manager) watchSchedule(interval time.Duration) {
+	var window schedule.Window
+	var preferred string
+	for now := range m.tick(interval) {
+		w, ok := m.inMaintenance(now)
+		switch {
+		case ok && w.String() != window.String():
+			m.log.Warn().Msgf("Maintenance window %s started, automatic failover and failback suspended", w)
+			m.decide(m.activeLink, "maintenance window %s started", w)
+		case !ok && window.String() != "":
+			m.log.Info().Msgf("Maintenance window %s ended, automatic failover and failback resumed", window)
+			m.decide(m.activeLink, "maintenance window %s ended", window)
+		}
+		window = w
+		link, pw := m.scheduledLink(now)
+		switch {
+		case link != "" && link != preferred:
+			m.log.Info().Msgf("%s preferred during %s", link, pw)
+			m.decide(link, "preferred during %s", pw)
+		case link == "" && preferred != "":
+			m.log.Info().Msgf("Scheduled preference for %s ended", preferred)
+			m.decide(preferred, "scheduled preference ended")
+		}
+		preferred = link
+	}
+}
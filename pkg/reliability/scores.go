@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shynuu/if-reliability/score"
+	"github.com/shynuu/if-reliability/state"
+)
+
+// LinkKey names the link of ifname in the state, primary for the default
+// route.
+func LinkKey(ifname string) string {
+	if ifname == "" {
+		return PrimaryLink
+	}
+	return ifname
+}
+
+// scoreSample adds a probe result to the reliability score of ifname.
+func (m *Manager) scoreSample(ifname string, success bool, at time.Time) {
+	if m.store == nil {
+		return
+	}
+	m.store.Modify(func(s *state.State) {
+		if s.Reliability == nil {
+			s.Reliability = map[string]score.Score{}
+		}
+		link := s.Reliability[LinkKey(ifname)]
+		link.Add(success, at, m.reliabilityHalfLife)
+		s.Reliability[LinkKey(ifname)] = link
+		m.metrics.SetReliability(LinkKey(ifname), link.Ratio())
+	})
+}
+
+// reliability returns the reliability score of ifname.
+func (m *Manager) reliability(ifname string) score.Score {
+	if m.store == nil {
+		return score.Score{}
+	}
+	return m.store.Get().Reliability[LinkKey(ifname)]
+}
+
+// exportState exports the reliability scores and backup verifications
+// loaded from the state as metrics.
+func (m *Manager) exportState() {
+	if m.store == nil {
+		return
+	}
+	s := m.store.Get()
+	for link, score := range s.Reliability {
+		m.metrics.SetReliability(link, score.Ratio())
+	}
+	for ifname, v := range s.BackupVerified {
+		m.metrics.SetBackupVerified(ifname, v.Time)
+	}
+}
+
+// logReliability logs the reliability score of every known link.
+func (m *Manager) logReliability() {
+	if m.store == nil {
+		return
+	}
+	scores := m.store.Get().Reliability
+	links := make([]string, 0, len(scores))
+	for link := range scores {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	for _, link := range links {
+		m.log.Info().Msgf("Reliability of %s: %.2f%% (half-life %s)", link, scores[link].Ratio()*100, m.reliabilityHalfLife)
+	}
+}
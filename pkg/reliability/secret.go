@@ -0,0 +1,160 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/shynuu/if-reliability/wifi"
+	"github.com/spf13/pflag"
+)
+
+// minSecret is the length below which a secret is not redacted from the
+// logs, as replacing every occurrence of so short a string would garble
+// them.
+const minSecret = 4
+
+// secrets are the secrets redacted from the log output.
+var (
+	secretsMu sync.RWMutex
+	secrets   [][]byte
+)
+
+// addSecret redacts secret from the log output from now on.
+func addSecret(secret string) {
+	if len(secret) < minSecret {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if !slices.ContainsFunc(secrets, func(known []byte) bool { return string(known) == secret }) {
+		secrets = append(secrets, []byte(secret))
+	}
+}
+
+// redact replaces the secrets in text.
+func redact(text string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, string(secret), redactedValue)
+	}
+	return text
+}
+
+// redactor writes to out with the secrets replaced.
+type redactor struct {
+	out io.Writer
+}
+
+// Write implements io.Writer, reporting the whole of p as written.
+func (r redactor) Write(p []byte) (int, error) {
+	if _, err := r.out.Write(redactBytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteLevel implements zerolog.LevelWriter, passing level on if out takes
+// it.
+func (r redactor) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	out, ok := r.out.(zerolog.LevelWriter)
+	if !ok {
+		return r.Write(p)
+	}
+	if _, err := out.WriteLevel(level, redactBytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactBytes replaces the secrets in p.
+func redactBytes(p []byte) []byte {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		p = bytes.ReplaceAll(p, secret, []byte(redactedValue))
+	}
+	return p
+}
+
+// registerSecrets redacts from the log output the secrets of c: the WiFi,
+// MQTT and SMTP passwords, the Slack and Teams URLs and the passwords of the
+// fallback networks.
+func registerSecrets(c Config) {
+	for _, secret := range []string{c.WiFiPassword, c.MQTTPassword, c.AlertSMTPPassword, c.AlertSlackURL, c.AlertTeamsURL} {
+		addSecret(secret)
+	}
+	for _, text := range c.WiFiFallbacks {
+		if network, err := wifi.ParseNetwork(text); err == nil {
+			addSecret(network.Password)
+		}
+	}
+}
+
+// ReadSecrets reads into c the secrets of flags, bound to c by AddFlags,
+// given in files or as systemd credentials rather than on the command line:
+// the WiFi password, required unless set empty on purpose or --wifi-if is
+// wired, and the SMTP password of the alerts.
+func ReadSecrets(flags *pflag.FlagSet, c *Config) error {
+	var err error
+	if c.WiFiPassword, err = readSecret(flags, "wifi-password"); err != nil {
+		return fmt.Errorf("reading the WiFi password: %w", err)
+	}
+	wired, err := parseInterfaceTypes(c.InterfaceTypes)
+	if err != nil {
+		return fmt.Errorf("parsing --interface-type: %w", err)
+	}
+	if c.WiFiPassword == "" && !flags.Changed("wifi-password") && !wired[c.WiFiInterface] {
+		return errors.New("a WiFi password is required: --wifi-password, --wifi-password-file or the wifi-password systemd credential")
+	}
+	if c.AlertSMTPPassword, err = readSecret(flags, "alert-smtp-password"); err != nil {
+		return fmt.Errorf("reading the SMTP password: %w", err)
+	}
+	registerSecrets(*c)
+	return nil
+}
+
+// readSecret returns the secret of flag name: its value if set, on the
+// command line, in the environment or in the configuration file, else the
+// content of the file of --name-file, else the systemd credential name
+// (LoadCredential=name:path). It returns an empty string if none is set.
+func readSecret(flags *pflag.FlagSet, name string) (string, error) {
+	if f := flags.Lookup(name); f != nil && f.Changed {
+		return f.Value.String(), nil
+	}
+	if path, _ := flags.GetString(name + "-file"); path != "" {
+		return readSecretFile(path)
+	}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return readSecretFile(path)
+		}
+	}
+	return "", nil
+}
+
+// readSecretFile returns the content of the file at path without its
+// trailing newline.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/route"
+)
+
+// defaultRoutes returns the default routes of the enabled families.
+func (m *Manager) defaultRoutes() ([]route.Route, error) {
+	var all []route.Route
+	for _, family := range m.families() {
+		var routes []route.Route
+		_, err := m.routing(func() (string, error) {
+			var err error
+			routes, err = m.routeManager.Defaults(m.vrf, family)
+			return describeRoutes(routes), err
+		}, "route", "defaults", familyName(family), m.vrf)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, routes...)
+	}
+	return all, nil
+}
+
+// describeRoutes renders routes, e.g. "default via 192.168.1.1 dev eth0
+// metric 100".
+func describeRoutes(routes []route.Route) string {
+	lines := make([]string, len(routes))
+	for i, r := range routes {
+		lines[i] = fmt.Sprintf("default via %s dev %s metric %d", r.Gateway, r.Device, r.Metric)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// recordDefaults saves the default routes the monitor starts from.
+func (m *Manager) recordDefaults() {
+	routes, err := m.defaultRoutes()
+	if err != nil {
+		m.log.Warn().Msgf("Cannot read the default routes, they will not be restored on exit: %s", err)
+		return
+	}
+	m.startupDefaults, m.defaultsRecorded = routes, true
+	m.log.Debug().Msgf("Default routes at startup: %s", strings.ReplaceAll(describeRoutes(routes), "\n", ", "))
+}
+
+// sameRoute reports whether a and b are the same default route.
+func sameRoute(a, b route.Route) bool {
+	return a.Dst == b.Dst && a.Gateway == b.Gateway && a.Device == b.Device && a.Metric == b.Metric
+}
+
+// restoreDefaults disconnects the WiFi connection the monitor made if
+// requested, then puts back the default routes the monitor started from and
+// removes the ones added since through WiFi.
+func (m *Manager) restoreDefaults() {
+	if m.disconnectOnExit && m.connectedWiFi != "" {
+		if err := m.runNM(func(c WiFiManager) error { return c.Disconnect(m.connectedWiFi) }, "device", "disconnect", m.connectedWiFi); err != nil {
+			m.log.Error().Msgf("Error disconnecting %s: %s", m.connectedWiFi, err)
+		} else {
+			m.changeLog.Record(changes.Connection, "disconnected", "%s", m.connectedWiFi)
+		}
+	}
+	if !m.defaultsRecorded {
+		return
+	}
+	current, err := m.defaultRoutes()
+	if err != nil {
+		m.log.Error().Msgf("Cannot read the default routes to restore them: %s", err)
+		return
+	}
+	for _, saved := range m.startupDefaults {
+		found := false
+		for _, r := range current {
+			found = found || sameRoute(r, saved)
+		}
+		if found {
+			continue
+		}
+		args := []string{"route", "replace", saved.Dst, saved.Device, saved.Gateway, m.vrf}
+		if _, err := m.routing(func() (string, error) { return "", m.routeManager.Replace(saved) }, args...); err != nil {
+			m.log.Error().Msgf("Failed to restore the default route via %s dev %s: %s", saved.Gateway, saved.Device, err)
+			continue
+		}
+		m.changeLog.Record(changes.Route, "restored", "default via %s dev %s", saved.Gateway, saved.Device)
+	}
+	if m.connectedWiFi == "" {
+		return
+	}
+	for _, r := range current {
+		found := false
+		for _, saved := range m.startupDefaults {
+			found = found || sameRoute(r, saved)
+		}
+		if found || r.Device != m.connectedWiFi {
+			continue
+		}
+		if _, err := m.routing(func() (string, error) { return "", m.routeManager.Delete(r.Dst, r.Device, m.vrf, 0) }, "route", "del", r.Dst, r.Device, m.vrf); err != nil {
+			m.log.Error().Msgf("Failed to remove the default route via %s dev %s: %s", r.Gateway, r.Device, err)
+			continue
+		}
+		m.changeLog.Record(changes.Route, "removed", "default via %s dev %s", r.Gateway, r.Device)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// readSignal reads the association of the WiFi interface ifname from
+// nl80211 and exports its signal strength.
+func (m *Manager) readSignal(ifname string) (wifi.Link, error) {
+	output, err := m.run("iw", "dev", ifname, "link")
+	if err != nil {
+		return wifi.Link{}, fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	link, err := wifi.ParseLink(string(output))
+	if err != nil {
+		return link, err
+	}
+	m.metrics.SetSignal(ifname, link.Signal)
+	return link, nil
+}
+
+// weakSignal reports whether ifname is the WiFi interface and its signal is
+// below --min-rssi, or the interface of the modem and its signal quality is
+// below the LTE thresholds, or a wired interface and its link is below
+// --min-link-speed, and describes the signal. A signal that cannot be read is
+// not considered weak: the probes tell whether the link works.
+func (m *Manager) weakSignal(ifname string) (bool, string) {
+	if ifname != "" && ifname == m.primaryModem.ifname {
+		return m.primaryModem.weak()
+	}
+	if m.wired(ifname) {
+		return m.slowLink(ifname)
+	}
+	if m.minRSSI == 0 || ifname == "" || ifname != m.rssiIF {
+		return false, ""
+	}
+	link, err := m.readSignal(ifname)
+	if err != nil {
+		m.log.Debug().Msgf("Cannot read the signal of %s: %s", ifname, err)
+		return false, ""
+	}
+	if link.Signal >= m.minRSSI {
+		return false, ""
+	}
+	return true, fmt.Sprintf("WiFi signal %d dBm below %d dBm", link.Signal, m.minRSSI)
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/shynuu/if-reliability/usage"
+	"github.com/spf13/pflag"
+)
+
+// reloadable are the settings SIGHUP applies to a running monitor, at the
+// next probe round; the others need a restart.
+var reloadable = map[string]bool{
+	"endpoint":           true,
+	"interval":           true,
+	"retry":              true,
+	"detection":          true,
+	"detection-window":   true,
+	"detection-loss":     true,
+	"detection-alpha":    true,
+	"detection-max-rtt":  true,
+	"max-rtt":            true,
+	"max-loss":           true,
+	"max-jitter":         true,
+	"sla-window":         true,
+	"failback-successes": true,
+	"failback-hold":      true,
+	"prefer":             true,
+	"maintenance-window": true,
+	"data-cap":           true,
+	"data-cap-threshold": true,
+	"reconcile-routes":   true,
+}
+
+// handleSignals reloads the configuration file on SIGHUP, toggles the pause
+// of the automatic failovers and failbacks on SIGUSR1 and logs the status on
+// SIGUSR2, in the background until the manager stops.
+func (m *Manager) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, sigUSR1, sigUSR2)
+	done := m.probeContext().Done()
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			var sig os.Signal
+			select {
+			case <-done:
+				return
+			case sig = <-signals:
+			}
+			switch sig {
+			case syscall.SIGHUP:
+				m.log.Info().Msg("Reloading the configuration on SIGHUP...")
+				if err := m.Reload(); err != nil {
+					m.log.Error().Msgf("Error reloading the configuration, keeping the running one: %s", err)
+				}
+			case sigUSR1:
+				command := control.CommandPause
+				if m.paused.Load() {
+					command = control.CommandResume
+				}
+				m.log.Info().Msgf("Received SIGUSR1, %s", command)
+				m.runCommand(command)
+			case sigUSR2:
+				m.logStatus(m.controlStatus())
+			}
+		}
+	}()
+}
+
+// logStatus logs the status of the monitor.
+func (m *Manager) logStatus(s control.Status) {
+	m.log.Info().Msgf("State %s since %s (%s), traffic over %s", s.State, timefmt.FormatIn(s.Since, m.location), time.Since(s.Since).Round(time.Second), s.ActiveLink)
+	if s.Paused {
+		m.log.Info().Msg("Automatic failover and failback paused")
+	}
+	if s.Maintenance != "" {
+		m.log.Info().Msgf("Maintenance window %s in progress, automatic failover and failback suspended", s.Maintenance)
+	}
+	if s.PreferredLink != "" {
+		m.log.Info().Msgf("%s preferred by the schedule", s.PreferredLink)
+	}
+	if s.Pair != nil {
+		m.log.Info().Msgf("Pair %s, %s", PairRole(s.Pair.Master), DescribePeer(*s.Pair))
+	}
+	for _, f := range s.Faults {
+		m.log.Info().Msgf("Failure of %s injected until %s", f.Interface, timefmt.FormatIn(f.Until, m.location))
+	}
+	for _, u := range s.Usage {
+		line := fmt.Sprintf("%s received %s and sent %s since %s", u.Interface, usage.FormatSize(u.RxBytes), usage.FormatSize(u.TxBytes), timefmt.FormatIn(u.Since, m.location))
+		if u.CapBytes > 0 {
+			line += fmt.Sprintf(", %s of its %s data cap", usage.FormatSize(u.RxBytes+u.TxBytes), usage.FormatSize(u.CapBytes))
+		}
+		m.log.Info().Msg(line)
+	}
+}
+
+// Reload reads the configuration again through Config.Reload, as on
+// SIGHUP, and applies the reloadable settings it changed at the next probe
+// round, all of them or none if one is invalid. The changes of the others
+// are reported as needing a restart.
+func (m *Manager) Reload() error {
+	if m.config.Reload == nil {
+		return errors.New("no Reload in the configuration")
+	}
+	next, err := m.config.Reload()
+	if err != nil {
+		return err
+	}
+	next.setDefaults()
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	running, reloaded := m.config, next
+	updated := configFlags(&reloaded)
+	var changed, restart []string
+	configFlags(&running).VisitAll(func(f *pflag.Flag) {
+		if f.Hidden || f.Name == "help" || f.Value.String() == updated.Lookup(f.Name).Value.String() {
+			return
+		}
+		if reloadable[f.Name] {
+			changed = append(changed, f.Name)
+		} else {
+			restart = append(restart, f.Name)
+		}
+	})
+	if len(restart) > 0 {
+		m.log.Warn().Msgf("Changed settings needing a restart, left as they are: %s", strings.Join(restart, ", "))
+	}
+	if len(changed) == 0 {
+		m.reloaded = nil
+		m.log.Info().Msg("No reloadable setting changed")
+		return nil
+	}
+	if m.reloaded, err = m.reloadSettings(next, changed); err != nil {
+		return err
+	}
+	m.log.Info().Msgf("Reloading %s at the next probe round", strings.Join(changed, ", "))
+	return nil
+}
+
+// reloadSettings parses the reloadable settings of next, changed from the
+// running ones, and returns the function applying them to the running
+// monitor, which reports whether the endpoints or the detection changed.
+func (m *Manager) reloadSettings(next Config, changed []string) (func() bool, error) {
+	targets := m.targets
+	if !slices.Equal(next.Endpoints, m.config.Endpoints) {
+		if len(next.Endpoints) == 0 {
+			return nil, errors.New("endpoint: discovering the endpoints needs a restart")
+		}
+		var err error
+		if targets, err = endpoint.ParseList(next.Endpoints); err != nil {
+			return nil, fmt.Errorf("endpoint: %s", err)
+		}
+		if err := m.checkFamilies(targets); err != nil {
+			return nil, fmt.Errorf("endpoint: %s", err)
+		}
+	}
+	probes, err := parseProbeSettings(next, targets)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := parsePreferences(next.Preferences, m.preferLinks)
+	if err != nil {
+		return nil, fmt.Errorf("prefer: %s", err)
+	}
+	windows, err := parseWindows(next.MaintenanceWindows)
+	if err != nil {
+		return nil, fmt.Errorf("maintenance-window: %s", err)
+	}
+	var limit usage.Cap
+	if size := next.DataCap; size != "" {
+		if limit.Bytes, err = usage.ParseSize(size); err != nil || limit.Bytes == 0 {
+			return nil, fmt.Errorf("data-cap: invalid size %q", size)
+		}
+		if m.capIF == "" {
+			return nil, fmt.Errorf("data-cap needs data-cap-interface or modem")
+		}
+		m.trafficMu.Lock()
+		_, tracked := m.traffic[m.capIF]
+		m.trafficMu.Unlock()
+		if !tracked && m.dataCap.Bytes == 0 {
+			return nil, fmt.Errorf("setting a data cap needs a restart unless the traffic of %s is tracked", m.capIF)
+		}
+	}
+	threshold := next.DataCapThreshold
+	if threshold <= 0 || threshold > 100 {
+		return nil, fmt.Errorf("data-cap-threshold: it must be between 0 and 100")
+	}
+	limit.Threshold = threshold / 100
+	mode := next.ReconcileRoutes
+	switch {
+	case mode != reconcileReassert && mode != reconcileLog && mode != reconcileOff:
+		return nil, fmt.Errorf("reconcile-routes: %q must be %s, %s or %s", mode, reconcileReassert, reconcileLog, reconcileOff)
+	case (mode == reconcileOff) != (m.routeOwner.mode == reconcileOff):
+		return nil, fmt.Errorf("reconcile-routes: turning route reconciliation on or off needs a restart")
+	}
+	return func() bool {
+		restart := endpointList(probes.targets) != endpointList(m.targets) || probes.detection != m.detection
+		for _, target := range m.targets {
+			if !slices.ContainsFunc(probes.targets, func(t endpoint.Endpoint) bool { return t.Address == target.Address }) {
+				m.metrics.RemoveEndpoint(target.Address)
+			}
+		}
+		if len(next.Endpoints) > 0 {
+			m.gatewayCheck = false
+		}
+		m.setProbeSettings(probes)
+		m.setSchedule(prefs, windows)
+		m.setDataCap(limit)
+		m.routeOwner.setMode(mode)
+		copySettings(&m.config, next, changed)
+		m.log.Info().Msgf("Configuration reloaded: %s", strings.Join(changed, ", "))
+		m.decide(m.activeLink, "configuration reloaded: %s", strings.Join(changed, ", "))
+		return restart
+	}, nil
+}
+
+// applyReload applies the settings reloaded since the last probe round, if
+// any, and reports whether the endpoints or the detection changed.
+func (m *Manager) applyReload() bool {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	if m.reloaded == nil {
+		return false
+	}
+	restart := m.reloaded()
+	m.reloaded = nil
+	return restart
+}
+
+// copySettings sets the settings names of c to their value in from.
+func copySettings(c *Config, from Config, names []string) {
+	to, values := configFlags(c), configFlags(&from)
+	for _, name := range names {
+		value := values.Lookup(name).Value
+		if slice, ok := value.(pflag.SliceValue); ok {
+			to.Lookup(name).Value.(pflag.SliceValue).Replace(slice.GetSlice())
+		} else {
+			to.Set(name, value.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/carrier"
+	"github.com/shynuu/if-reliability/route"
+	"github.com/spf13/pflag"
+)
+
+// Addresses of the simulated default routers, from the documentation
+// ranges.
+const (
+	simulatedRouter4 = "192.0.2.1"
+	simulatedRouter6 = "2001:db8::1"
+)
+
+// simulation answers the operations and the probes of the simulate command,
+// so that failovers are rehearsed without touching a link.
+type simulation struct {
+	m *Manager
+
+	// primary is the interface the endpoints are routed through, and rtt
+	// the round-trip time of the probes.
+	primary string
+	rtt     time.Duration
+	// steps are the failures injected.
+	steps []scenarioStep
+}
+
+// simulatedSysctls are the values the simulated sysctls read, the Linux
+// defaults.
+var simulatedSysctls = map[string]string{
+	"net.ipv4.tcp_keepalive_time":   "7200",
+	"net.ipv4.tcp_keepalive_intvl":  "75",
+	"net.ipv4.tcp_keepalive_probes": "9",
+}
+
+// answer answers an operation or a run of an external program: the hooks
+// run for real, the routes toward the endpoints go through the primary
+// interface, every interface has a default router and a gigabit carrier, the
+// sysctls read their Linux defaults, and everything else succeeds without
+// output.
+func (s *simulation) answer(program string, args []string) ([]byte, error) {
+	if program == "sh" || program == "env" {
+		return Exec{}.Run(program, args...)
+	}
+	s.m.log.Debug().Msgf("Simulated %s %s", program, strings.Join(args, " "))
+	if program == "sysfs" {
+		return []byte(carrier.Negotiation{Carrier: true, Speed: 1000, Duplex: carrier.DuplexFull}.Format()), nil
+	}
+	if program == "sysctl" && len(args) == 2 && args[0] == "-n" {
+		return []byte(simulatedSysctls[args[1]]), nil
+	}
+	if program != "netlink" || len(args) < 2 || args[0] != "route" {
+		return nil, nil
+	}
+	switch args[1] {
+	case "get":
+		return []byte(s.primary), nil
+	case "default":
+		if slices.Contains(args, familyName(route.IPv6)) {
+			return []byte(simulatedRouter6), nil
+		}
+		return []byte(simulatedRouter4), nil
+	}
+	return nil, nil
+}
+
+// scenarioStep is a failure injected at an offset from the start of the
+// simulation.
+type scenarioStep struct {
+	ifname   string
+	at       time.Duration
+	duration time.Duration
+	carrier  bool
+}
+
+// parseScenarioStep parses interface@start+duration, e.g. eth0@30s+1m.
+func parseScenarioStep(value string, carrier bool) (scenarioStep, error) {
+	ifname, timing, ok := strings.Cut(value, "@")
+	start, length, ok2 := strings.Cut(timing, "+")
+	if !ok || !ok2 || ifname == "" {
+		return scenarioStep{}, fmt.Errorf("invalid failure %q: expected interface@start+duration, e.g. eth0@30s+1m", value)
+	}
+	step := scenarioStep{ifname: ifname, carrier: carrier}
+	var err error
+	if step.at, err = time.ParseDuration(start); err != nil || step.at < 0 {
+		return scenarioStep{}, fmt.Errorf("invalid failure %q: invalid start %q", value, start)
+	}
+	if step.duration, err = time.ParseDuration(length); err != nil || step.duration <= 0 {
+		return scenarioStep{}, fmt.Errorf("invalid failure %q: invalid duration %q", value, length)
+	}
+	return step, nil
+}
+
+// play injects the failures of the scenario at their offsets, until ctx is
+// done.
+func (s *simulation) play(ctx context.Context) {
+	start := time.Now()
+	slices.SortFunc(s.steps, func(a, b scenarioStep) int { return int(a.at - b.at) })
+	for _, step := range s.steps {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(step.at))):
+		}
+		if err := s.m.faults.inject(step.ifname, step.duration, step.carrier); err != nil {
+			s.m.log.Error().Msgf("Error injecting the failure of %s: %s", step.ifname, err)
+		}
+	}
+}
+
+// SimulateOptions configures the simulated links of Simulate.
+type SimulateOptions struct {
+	// Failures are the failures of the probes over an interface, as
+	// interface@start+duration from the start of the simulation, and
+	// CarrierFailures those reported as a loss of carrier too.
+	Failures        []string
+	CarrierFailures []string
+	// PrimaryInterface is the interface the simulated routes toward the
+	// endpoints go through, and RTT the round-trip time of the probes.
+	PrimaryInterface string
+	RTT              time.Duration
+	// StopAfter is the time after which the simulation stops, never if 0.
+	StopAfter time.Duration
+}
+
+// AddSimulateFlags defines the flags of Simulate on fs, besides those of
+// AddFlags, bound to o.
+func AddSimulateFlags(fs *pflag.FlagSet, o *SimulateOptions) {
+	fs.StringArrayVar(&o.Failures, "fail", nil, "Failure of the probes over an interface, as interface@start+duration from the start of the simulation, e.g. eth0@30s+2m, may be repeated")
+	fs.StringArrayVar(&o.CarrierFailures, "fail-carrier", nil, "Failure reported as a loss of carrier too, as interface@start+duration, may be repeated")
+	fs.StringVar(&o.PrimaryInterface, "primary-if", "eth0", "Interface the simulated routes toward the endpoints go through")
+	fs.DurationVar(&o.RTT, "rtt", 20*time.Millisecond, "Round-trip time of the simulated probes")
+	fs.DurationVar(&o.StopAfter, "stop-after", 0, "Time after which the simulation stops, as on SIGTERM (runs until interrupted if 0)")
+}
+
+// Simulate monitors like a manager configured by config against the
+// simulated links of o: the probes answer unless a failure of their
+// interface is under way, and the routes, NetworkManager and the other
+// programs but the hooks are only simulated. It stops once ctx is done, or
+// after o.StopAfter.
+func Simulate(ctx context.Context, config Config, o SimulateOptions) error {
+	if config.Replay != "" {
+		return errors.New("--replay cannot be combined with simulate")
+	}
+	m, err := NewManager(config)
+	if err != nil {
+		return err
+	}
+	sim := &simulation{m: m, primary: o.PrimaryInterface, rtt: o.RTT}
+	for flag, failures := range map[string][]string{"fail": o.Failures, "fail-carrier": o.CarrierFailures} {
+		for _, value := range failures {
+			step, err := parseScenarioStep(value, flag == "fail-carrier")
+			if err != nil {
+				return fmt.Errorf("parsing --%s: %w", flag, err)
+			}
+			sim.steps = append(sim.steps, step)
+		}
+	}
+	m.simulation = sim
+	if o.StopAfter > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		timer := time.AfterFunc(o.StopAfter, func() {
+			m.log.Info().Msgf("Simulation over after %s", o.StopAfter)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	m.log.Warn().Msgf("Simulating the links, %d failures scheduled: nothing is probed or changed, only the hooks run", len(sim.steps))
+	return m.Run(ctx)
+}
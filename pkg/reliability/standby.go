@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/quorum"
+	"github.com/shynuu/if-reliability/state"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// standbyVerifyInterval is how often a healthy standby link is recorded as a
+// verified backup path.
+const standbyVerifyInterval = time.Hour
+
+// warmStandby keeps WiFi associated while the primary link is up and probes
+// it in the background, so that failing over is only a route switch. It is
+// disabled if interval is 0.
+type warmStandby struct {
+	m *Manager
+
+	interval  time.Duration
+	retry     int
+	endpoints []endpoint.Endpoint
+	// quorum is the number of endpoints that must fail at once for a
+	// round to fail.
+	quorum int
+
+	// mu serializes the connections of the monitor and of the failover,
+	// and guards router and healthy.
+	mu      sync.Mutex
+	router  string
+	healthy bool
+}
+
+// enabled reports whether WiFi is kept associated as a warm standby.
+func (s *warmStandby) enabled() bool {
+	return s.interval > 0
+}
+
+// start connects WiFi and monitors it in the background, without delaying
+// the monitoring of the primary link. A failed connection is retried by the
+// monitor.
+func (s *warmStandby) start(ifwifi, ssid, password string, opts wifi.ConnectOptions) {
+	go func() {
+		s.connect(ifwifi, ssid, password, opts)
+		s.monitor(ifwifi, ssid, password, opts)
+	}()
+}
+
+// connect connects WiFi and records its default router, healthy until the
+// probes tell otherwise.
+func (s *warmStandby) connect(ifwifi, ssid, password string, opts wifi.ConnectOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	router, err := s.m.connectToWiFi(ifwifi, ssid, password, opts)
+	if err != nil {
+		s.m.log.Error().Msgf("Error connecting %s as a warm standby: %s", ifwifi, err)
+		s.router, s.healthy = "", false
+		return
+	}
+	s.m.log.Info().Msgf("%s associated as a warm standby, default router %s", ifwifi, router)
+	s.m.decide(ifwifi, "associated as a warm standby")
+	s.router, s.healthy = router, true
+}
+
+// monitor probes the endpoints over ifwifi every interval while the primary
+// link carries the traffic, and reconnects WiFi once it failed retry rounds
+// in a row or lost its default router.
+func (s *warmStandby) monitor(ifwifi, ssid, password string, opts wifi.ConnectOptions) {
+	failures := 0
+	var verified time.Time
+	for range s.m.tick(s.interval) {
+		// Once failed over, the failover probes WiFi itself.
+		if current, _ := s.m.machine.State(); current != fsm.MonitoringPrimary {
+			failures = 0
+			continue
+		}
+		round := quorum.Round{Quorum: s.quorum}
+		for _, target := range bindAll(s.endpoints, ifwifi) {
+			result := s.m.probeEndpoint(target)
+			s.m.recordSample(target, result)
+			round.Statuses = append(round.Statuses, quorum.Status{Endpoint: target.String(), Result: result})
+		}
+		router, err := s.m.defaultRouter(ifwifi)
+		if err != nil {
+			s.m.log.Warn().Msgf("Cannot read the default router of %s: %s", ifwifi, err)
+		}
+		if !round.Failed() && router != "" {
+			s.mu.Lock()
+			recovered := !s.healthy
+			s.router, s.healthy = router, true
+			s.mu.Unlock()
+			if recovered {
+				s.m.log.Info().Msgf("Warm standby %s is healthy again", ifwifi)
+				s.m.decide(ifwifi, "warm standby healthy again")
+			}
+			if time.Since(verified) >= standbyVerifyInterval {
+				s.m.recordBackupVerified(ifwifi, state.SourceStandby)
+				verified = time.Now()
+			}
+			failures = 0
+			continue
+		}
+		failures++
+		s.mu.Lock()
+		lost := s.healthy
+		s.healthy = false
+		s.mu.Unlock()
+		if lost {
+			s.m.log.Warn().Msgf("Warm standby %s is unhealthy: %s", ifwifi, round)
+			s.m.decide(ifwifi, "warm standby unhealthy")
+		}
+		if router == "" || failures >= s.retry {
+			s.m.log.Warn().Msgf("Reconnecting the warm standby %s", ifwifi)
+			s.connect(ifwifi, ssid, password, opts)
+			failures = 0
+		}
+	}
+}
+
+// ready returns the default router of WiFi if it is associated and healthy,
+// so that failing over needs no connection, or an empty string.
+func (s *warmStandby) ready() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.healthy {
+		return ""
+	}
+	return s.router
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/metrics"
+	"github.com/shynuu/if-reliability/persist"
+)
+
+// statusFileExt is the extension of the status files written in the
+// Prometheus text format, as the node_exporter textfile collector expects.
+const statusFileExt = ".prom"
+
+// statusExport is the content of a JSON status file.
+type statusExport struct {
+	Time time.Time `json:"time"`
+	control.Status
+	// ActiveInterface is the interface carrying the traffic, the primary
+	// interface rather than the primary link if known.
+	ActiveInterface string     `json:"active_interface"`
+	Failovers       uint64     `json:"failovers"`
+	LastFailover    *time.Time `json:"last_failover,omitempty"`
+	// Probes are the last outcomes of the probes of each endpoint over each
+	// interface.
+	Probes []metrics.ProbeStatus `json:"probes"`
+}
+
+// writeStatusFile replaces the status file, if enabled, with the current
+// state: the metrics if it ends with .prom, JSON otherwise.
+func (m *Manager) writeStatusFile() {
+	m.statusFileMu.Lock()
+	defer m.statusFileMu.Unlock()
+	if m.statusFile == "" {
+		return
+	}
+	var data bytes.Buffer
+	if filepath.Ext(m.statusFile) == statusFileExt {
+		m.metrics.Write(&data)
+	} else {
+		status := m.controlStatus()
+		s := statusExport{Time: time.Now().UTC(), Status: status, ActiveInterface: status.ActiveLink, Probes: m.metrics.Probes()}
+		if status.ActiveLink == PrimaryLink && m.defaultIF != "" {
+			s.ActiveInterface = m.defaultIF
+		}
+		var last time.Time
+		if s.Failovers, last = m.metrics.FailoverCount(); !last.IsZero() {
+			last = last.UTC()
+			s.LastFailover = &last
+		}
+		encoder := json.NewEncoder(&data)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(s)
+	}
+	// Readable by the node_exporter and scripts running as other users.
+	if err := persist.WriteFilePerm(m.statusFile, data.Bytes(), 0o644, false); err != nil {
+		m.log.Warn().Msgf("Cannot write the status file %s: %s", m.statusFile, err)
+	}
+}
+
+// writeStatusFileEvery writes the status file every interval.
+func (m *Manager) writeStatusFileEvery(interval time.Duration) {
+	for range m.tick(interval) {
+		m.writeStatusFile()
+	}
+}
+
+// removeStatusFile removes the status file, if enabled, on exit, so that the
+// state of a stopped instance is not mistaken for a current one.
+func (m *Manager) removeStatusFile() {
+	m.statusFileMu.Lock()
+	defer m.statusFileMu.Unlock()
+	if m.statusFile == "" {
+		return
+	}
+	if err := os.Remove(m.statusFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		m.log.Warn().Msgf("Cannot remove the status file %s: %s", m.statusFile, err)
+	}
+	m.statusFile = ""
+}
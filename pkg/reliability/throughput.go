@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/shynuu/if-reliability/probe"
+	"github.com/shynuu/if-reliability/throughput"
+)
+
+// throughputCheck refuses to move the traffic to a WiFi link downloading
+// slower than min Mbit/s, measured with an HTTP download of url or an iperf3
+// client of iperf3, a host or host:port. It is disabled if min is 0.
+type throughputCheck struct {
+	m *Manager
+
+	min      float64
+	url      string
+	iperf3   string
+	duration time.Duration
+}
+
+// newThroughputCheck returns the check of at least minMbps measured with
+// url or iperf3.
+func (m *Manager) newThroughputCheck(minMbps float64, url, iperf3 string, duration time.Duration) (throughputCheck, error) {
+	t := throughputCheck{m: m, min: minMbps, url: url, iperf3: iperf3, duration: duration}
+	switch {
+	case minMbps < 0:
+		return t, fmt.Errorf("negative --min-throughput")
+	case minMbps == 0 && (url != "" || iperf3 != ""):
+		return t, fmt.Errorf("--throughput-url and --throughput-iperf3 need --min-throughput")
+	case minMbps == 0:
+		return t, nil
+	case (url == "") == (iperf3 == ""):
+		return t, fmt.Errorf("--min-throughput needs either --throughput-url or --throughput-iperf3")
+	case duration < time.Second:
+		return t, fmt.Errorf("throughput test duration must be at least 1s")
+	}
+	if iperf3 != "" {
+		if _, _, err := t.iperf3Server(); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// enabled reports whether the throughput of WiFi is checked.
+func (t throughputCheck) enabled() bool {
+	return t.min > 0
+}
+
+// iperf3Server returns the host and port, 0 for the iperf3 default, of the
+// iperf3 server.
+func (t throughputCheck) iperf3Server() (string, int, error) {
+	host, portText, err := net.SplitHostPort(t.iperf3)
+	if err != nil {
+		return t.iperf3, 0, nil
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid iperf3 server port %q", portText)
+	}
+	return host, port, nil
+}
+
+// measure measures the throughput of ifwifi.
+func (t throughputCheck) measure(ifwifi string) (throughput.Measurement, error) {
+	if t.url != "" {
+		client := (&probe.HTTP{Namespace: t.m.namespace, Network: "tcp" + t.m.networkSuffix()}).Client(ifwifi)
+		return throughput.Download(client, t.url, t.duration)
+	}
+	host, port, _ := t.iperf3Server()
+	output, err := t.m.run("iperf3", throughput.Iperf3Args(host, port, ifwifi, t.duration)...)
+	m, parseErr := throughput.ParseIperf3(output)
+	if err == nil {
+		return m, parseErr
+	}
+	// iperf3 reports its errors in the JSON report too.
+	if parseErr != nil {
+		return m, fmt.Errorf("%w: %s", err, parseErr)
+	}
+	return m, err
+}
+
+// blocked measures the throughput of ifwifi. It returns why the traffic
+// cannot move to ifwifi, or an empty string if it can.
+func (t throughputCheck) blocked(ifwifi string) string {
+	if !t.enabled() {
+		return ""
+	}
+	m, err := t.measure(ifwifi)
+	if err != nil {
+		t.m.log.Error().Msgf("Throughput test over %s failed: %s", ifwifi, err)
+		return "WiFi throughput could not be measured"
+	}
+	t.m.metrics.SetThroughput(ifwifi, m.Mbps())
+	if m.Mbps() < t.min {
+		t.m.log.Warn().Msgf("Throughput over %s: %s, below %g Mbit/s", ifwifi, m, t.min)
+		return fmt.Sprintf("WiFi throughput %.1f Mbit/s below %g Mbit/s", m.Mbps(), t.min)
+	}
+	t.m.log.Info().Msgf("Throughput over %s: %s", ifwifi, m)
+	t.m.decide(ifwifi, "throughput %.1f Mbit/s", m.Mbps())
+	return ""
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/wireguard"
+)
+
+// tunnelRehoming moves the WireGuard tunnels of the site to the new link
+// after a path change: the endpoint of each peer is set again, its hostname
+// resolved anew if known, so that the interface forgets the source address
+// of the old link and the tunnel comes back over the new one within a
+// handshake instead of after the peer timeouts.
+type tunnelRehoming struct {
+	m *Manager
+
+	ifaces []string
+	// hosts are the host:port endpoints of the peers, by public key,
+	// resolved on every change; the other peers keep their address.
+	hosts map[wireguard.Key]string
+	// timeout is how long a handshake over the new link is waited for.
+	timeout time.Duration
+}
+
+// parseTunnelHosts parses --wireguard-endpoint values, publickey=host:port.
+// A key always ends with a single "=" in base64, which may double as the
+// separator.
+func parseTunnelHosts(values []string) (map[wireguard.Key]string, error) {
+	hosts := map[wireguard.Key]string{}
+	for _, value := range values {
+		text, host, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid endpoint %q: it must be publickey=host:port", value)
+		}
+		host = strings.TrimPrefix(host, "=")
+		key, err := wireguard.ParseKey(text + "=")
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %w", value, err)
+		}
+		hosts[key] = host
+	}
+	return hosts, nil
+}
+
+// enabled reports whether tunnels are re-homed.
+func (t tunnelRehoming) enabled() bool {
+	return len(t.ifaces) > 0
+}
+
+// rehome sets the endpoints of the peers of the tunnels again now that the
+// traffic goes through ifname, and waits for the handshakes in the
+// background.
+func (t tunnelRehoming) rehome(ifname string) {
+	for _, tunnel := range t.ifaces {
+		device, err := t.device(tunnel)
+		if err != nil {
+			t.m.log.Error().Msgf("Cannot read the WireGuard interface %s: %s", tunnel, err)
+			continue
+		}
+		since := time.Now()
+		var rehomed []wireguard.Key
+		for _, peer := range device.Peers {
+			endpoint, err := t.resolve(peer)
+			if err != nil {
+				t.m.log.Error().Msgf("Cannot re-home peer %s of %s: %s", peer.PublicKey, tunnel, err)
+				continue
+			}
+			if endpoint == nil {
+				continue
+			}
+			_, err = t.m.operate("wireguard", func() (string, error) {
+				return "", netns.Do(t.m.namespace, func() error { return wireguard.SetEndpoint(tunnel, peer.PublicKey, endpoint) })
+			}, "set", tunnel, "peer", peer.PublicKey.String(), "endpoint", endpoint.String())
+			if err != nil {
+				t.m.log.Error().Msgf("Cannot re-home peer %s of %s: %s", peer.PublicKey, tunnel, err)
+				continue
+			}
+			t.m.log.Info().Msgf("Peer %s of %s re-homed to %s over %s", peer.PublicKey, tunnel, endpoint, ifname)
+			t.m.changeLog.Record(changes.Connection, "re-homed", "WireGuard peer %s of %s to %s", peer.PublicKey, tunnel, endpoint)
+			rehomed = append(rehomed, peer.PublicKey)
+		}
+		if len(rehomed) > 0 && t.timeout > 0 && t.m.player == nil {
+			go t.await(tunnel, ifname, rehomed, since)
+		}
+	}
+}
+
+// device returns the WireGuard interface tunnel, read over netlink.
+func (t tunnelRehoming) device(tunnel string) (wireguard.Device, error) {
+	var device wireguard.Device
+	output, err := t.m.operate("wireguard", func() (string, error) {
+		var d wireguard.Device
+		err := netns.Do(t.m.namespace, func() error {
+			var err error
+			d, err = wireguard.Get(tunnel)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(d)
+		return string(data), err
+	}, "show", tunnel)
+	if err != nil {
+		return device, err
+	}
+	err = json.Unmarshal([]byte(output), &device)
+	return device, err
+}
+
+// resolve returns the endpoint peer is re-homed to: its host resolved again
+// if known, preferring an address of the family of its current endpoint,
+// else its current endpoint, or nil if it has none.
+func (t tunnelRehoming) resolve(peer wireguard.Peer) (*net.UDPAddr, error) {
+	host, ok := t.hosts[peer.PublicKey]
+	if !ok {
+		if peer.Endpoint == "" {
+			return nil, nil
+		}
+		return net.ResolveUDPAddr("udp", peer.Endpoint)
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	current := 0
+	if addr, err := net.ResolveUDPAddr("udp", peer.Endpoint); err == nil {
+		current = familyOf(addr.IP)
+	}
+	var chosen *net.IPAddr
+	for i, addr := range addrs {
+		if !t.m.familyEnabled(familyOf(addr.IP)) {
+			continue
+		}
+		if chosen == nil || familyOf(addr.IP) == current && familyOf(chosen.IP) != current {
+			chosen = &addrs[i]
+		}
+	}
+	if chosen == nil {
+		return nil, fmt.Errorf("%s has no address of an enabled family", name)
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(chosen.String(), port))
+}
+
+// await logs the peers of tunnel completing a handshake since, over ifname,
+// or not within the timeout.
+func (t tunnelRehoming) await(tunnel, ifname string, peers []wireguard.Key, since time.Time) {
+	waiting := map[wireguard.Key]bool{}
+	for _, key := range peers {
+		waiting[key] = true
+	}
+	deadline := since.Add(t.timeout)
+	for len(waiting) > 0 && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+		var device wireguard.Device
+		err := netns.Do(t.m.namespace, func() error {
+			var err error
+			device, err = wireguard.Get(tunnel)
+			return err
+		})
+		if err != nil {
+			t.m.log.Debug().Msgf("Cannot read the WireGuard interface %s: %s", tunnel, err)
+			continue
+		}
+		for _, peer := range device.Peers {
+			if waiting[peer.PublicKey] && peer.LastHandshake.After(since) {
+				delete(waiting, peer.PublicKey)
+				t.m.log.Info().Msgf("Tunnel %s to %s re-established over %s in %s", tunnel, peer.PublicKey, ifname, peer.LastHandshake.Sub(since).Round(10*time.Millisecond))
+			}
+		}
+	}
+	for key := range waiting {
+		t.m.log.Warn().Msgf("No handshake with peer %s of %s over %s within %s, the tunnel waits for traffic or a keepalive", key, tunnel, ifname, t.timeout)
+		t.m.decide(tunnel, "no handshake with peer %s within %s after re-homing", key, t.timeout)
+	}
+}
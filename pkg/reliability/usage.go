@@ -0,0 +1,144 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/diagnosis"
+	"github.com/shynuu/if-reliability/netns"
+	"github.com/shynuu/if-reliability/state"
+	"github.com/shynuu/if-reliability/usage"
+)
+
+// startUsage loads the traffic of the billing period from the state and
+// reads the byte counters of links every interval in the background.
+func (m *Manager) startUsage(links []string, interval time.Duration) {
+	if m.store != nil {
+		for ifname, p := range m.store.Get().Usage {
+			m.traffic[ifname] = p
+			m.metrics.SetUsage(ifname, p.RxBytes, p.TxBytes)
+		}
+	}
+	if m.dataCap.Bytes > 0 {
+		m.metrics.SetDataCap(m.capIF, m.dataCap.Bytes)
+	}
+	m.readUsage(links)
+	go func() {
+		for range m.tick(interval) {
+			m.readUsage(links)
+		}
+	}()
+}
+
+// readUsage adds the byte counters of links to their traffic.
+func (m *Manager) readUsage(links []string) {
+	now := time.Now()
+	for _, ifname := range links {
+		var link diagnosis.Link
+		err := netns.Do(m.namespace, func() (err error) {
+			link, err = diagnosis.ReadLink(ifname)
+			return err
+		})
+		if err != nil {
+			m.log.Debug().Msgf("Cannot read the counters of %s: %s", ifname, err)
+			continue
+		}
+		m.trafficMu.Lock()
+		p := m.traffic[ifname]
+		start := p.Start
+		p.Add(link.Counters["rx_bytes"], link.Counters["tx_bytes"], now, m.resetDay)
+		m.traffic[ifname] = p
+		warn := ifname == m.capIF && m.dataCap.Reached(p) && !m.capWarned.Equal(p.Start)
+		if warn {
+			m.capWarned = p.Start
+		}
+		m.trafficMu.Unlock()
+		if !start.IsZero() && !start.Equal(p.Start) {
+			m.log.Info().Msgf("New billing period for %s, traffic reset", ifname)
+		}
+		m.metrics.SetUsage(ifname, p.RxBytes, p.TxBytes)
+		if warn {
+			_, why := m.overCap(ifname)
+			m.log.Warn().Msgf("%s, preferring the other links", why)
+			m.decide(ifname, "%s", why)
+		}
+	}
+	if m.store != nil {
+		m.trafficMu.Lock()
+		periods := maps.Clone(m.traffic)
+		m.trafficMu.Unlock()
+		m.store.Modify(func(s *state.State) {
+			s.Usage = periods
+		})
+	}
+}
+
+// overCap reports whether ifname has a data cap whose threshold its traffic
+// reached, with a description, e.g. "wwan0 used 18.5 GB of its 20.0 GB data
+// cap".
+func (m *Manager) overCap(ifname string) (bool, string) {
+	m.trafficMu.Lock()
+	p, limit := m.traffic[ifname], m.dataCap
+	m.trafficMu.Unlock()
+	if ifname == "" || ifname != m.capIF || limit.Bytes == 0 || !limit.Reached(p) {
+		return false, ""
+	}
+	return true, ifname + " used " + usage.FormatSize(p.Total()) + " of its " + usage.FormatSize(limit.Bytes) + " data cap"
+}
+
+// setDataCap replaces the data cap of capIF, reporting it reached again if
+// it still is.
+func (m *Manager) setDataCap(limit usage.Cap) {
+	m.trafficMu.Lock()
+	m.dataCap = limit
+	m.capWarned = time.Time{}
+	m.trafficMu.Unlock()
+	if m.capIF != "" {
+		m.metrics.SetDataCap(m.capIF, limit.Bytes)
+	}
+}
+
+// usageStatus returns the traffic of the tracked interfaces for the status.
+func (m *Manager) usageStatus() []control.Usage {
+	m.trafficMu.Lock()
+	defer m.trafficMu.Unlock()
+	links := make([]string, 0, len(m.traffic))
+	for ifname := range m.traffic {
+		links = append(links, ifname)
+	}
+	sort.Strings(links)
+	var status []control.Usage
+	for _, ifname := range links {
+		p := m.traffic[ifname]
+		u := control.Usage{Interface: ifname, Since: p.Start, RxBytes: p.RxBytes, TxBytes: p.TxBytes}
+		if ifname == m.capIF {
+			u.CapBytes = m.dataCap.Bytes
+			u.Capped = m.dataCap.Reached(p)
+		}
+		status = append(status, u)
+	}
+	return status
+}
+
+// usageLinks returns the interfaces whose traffic is tracked: every
+// interface in cascade mode, else the primary and WiFi ones, and capIF.
+func (m *Manager) usageLinks(ifaces []string, primary, ifwifi string) []string {
+	links := ifaces
+	if len(links) == 0 {
+		links = []string{primary, ifwifi}
+	}
+	seen := map[string]bool{}
+	var tracked []string
+	for _, ifname := range append(links, m.capIF) {
+		if ifname != "" && !seen[ifname] {
+			seen[ifname] = true
+			tracked = append(tracked, ifname)
+		}
+	}
+	return tracked
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !windows && !plan9
+
+package reliability
+
+import (
+	"syscall"
+)
+
+// The user signals toggling the pause and logging the status.
+const (
+	sigUSR1 = syscall.SIGUSR1
+	sigUSR2 = syscall.SIGUSR2
+)
@@ -0,0 +1,16 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"syscall"
+)
+
+// The user signals toggling the pause and logging the status, which Windows
+// does not have: these are never received, the control API takes their
+// place.
+const (
+	sigUSR1 = syscall.Signal(0x1e)
+	sigUSR2 = syscall.Signal(0x1f)
+)
@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"time"
+
+	"github.com/shynuu/if-reliability/endpoint"
+	"github.com/shynuu/if-reliability/quorum"
+)
+
+// verifyPath sends probe rounds toward targets along the routes, unless an
+// endpoint is bound to an interface, until one round succeeds or the grace
+// period elapses, and reports whether the path through ifname carries the
+// traffic. It reports success without probing in dry run and replay modes,
+// where the routes did not move.
+func (m *Manager) verifyPath(targets []endpoint.Endpoint, ifname string) bool {
+	if m.verifyGrace <= 0 || m.dryRun || m.player != nil {
+		return true
+	}
+	deadline := time.Now().Add(m.verifyGrace)
+	for {
+		round := quorum.Round{Quorum: m.probeQuorum}
+		for _, target := range targets {
+			round.Statuses = append(round.Statuses, quorum.Status{Endpoint: target.String(), Result: m.probeBurst(target)})
+		}
+		if !round.Failed() {
+			m.log.Info().Msgf("Path through %s verified: %s", ifname, round)
+			return true
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			m.log.Error().Msgf("Path through %s failed verification for %s: %s", ifname, m.verifyGrace, round)
+			m.decide(ifname, "path failed verification for %s", m.verifyGrace)
+			return false
+		}
+		m.log.Warn().Msgf("Path through %s not verified yet: %s", ifname, round)
+		time.Sleep(min(m.probeInterval, wait))
+	}
+}
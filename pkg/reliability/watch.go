@@ -0,0 +1,21 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+
+	"github.com/shynuu/if-reliability/eventlog"
+	"github.com/shynuu/if-reliability/live"
+)
+
+// DefaultWatchSocket is where the running instance streams live records.
+const DefaultWatchSocket = "/run/if-reliability/watch.sock"
+
+// decide streams a decision about ifname to the watchers.
+func (m *Manager) decide(ifname string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	m.liveHub.Publish(live.Record{Kind: live.KindDecision, Interface: ifname, Message: message})
+	m.logEvent(eventlog.Event{Kind: eventlog.KindDecision, Interface: ifname, Message: message})
+}
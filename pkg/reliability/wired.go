@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package reliability
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/carrier"
+	"github.com/shynuu/if-reliability/changes"
+	"github.com/shynuu/if-reliability/wifi"
+)
+
+// Interface types of --interface-type.
+const (
+	ifTypeWiFi     = "wifi"
+	ifTypeEthernet = "ethernet"
+)
+
+// wired reports whether ifname is of type ethernet.
+func (m *Manager) wired(ifname string) bool {
+	return ifname != "" && m.wiredIFs[ifname]
+}
+
+// parseInterfaceTypes parses the ifname=type values of --interface-type and
+// returns the interfaces of type ethernet.
+func parseInterfaceTypes(texts []string) (map[string]bool, error) {
+	types := map[string]bool{}
+	for _, text := range texts {
+		ifname, kind, _ := strings.Cut(text, "=")
+		if ifname == "" {
+			return nil, fmt.Errorf("invalid interface type %q, expected ifname=%s or ifname=%s", text, ifTypeWiFi, ifTypeEthernet)
+		}
+		switch kind {
+		case ifTypeEthernet:
+			types[ifname] = true
+		case ifTypeWiFi:
+			delete(types, ifname)
+		default:
+			return nil, fmt.Errorf("invalid type %q of %s, expected %s or %s", kind, ifname, ifTypeWiFi, ifTypeEthernet)
+		}
+	}
+	return types, nil
+}
+
+// readNegotiation reads the carrier, speed and duplex of the wired interface
+// ifname.
+func (m *Manager) readNegotiation(ifname string) (carrier.Negotiation, error) {
+	output, err := m.operate("sysfs", func() (string, error) {
+		n, err := carrier.ReadNegotiation(ifname)
+		return n.Format(), err
+	}, "link", ifname)
+	if err != nil {
+		return carrier.Negotiation{}, err
+	}
+	return carrier.ParseNegotiation(output)
+}
+
+// awaitCarrier waits up to timeout for a carrier on the wired interface
+// ifname and returns the negotiation of its link.
+func (m *Manager) awaitCarrier(ifname string, timeout time.Duration) (carrier.Negotiation, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		n, err := m.readNegotiation(ifname)
+		if err != nil {
+			return n, err
+		}
+		if n.Carrier {
+			return n, nil
+		}
+		if !time.Now().Before(deadline) {
+			return n, fmt.Errorf("no carrier on %s within %s, is the cable plugged in?", ifname, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// connectWired brings up the wired interface ifwifi in place of the WiFi
+// association: it waits for a carrier, activates the interface through
+// NetworkManager and waits for its DHCP lease, within the association and
+// DHCP timeouts of opts, then returns the default router.
+func (m *Manager) connectWired(ifwifi string, opts wifi.ConnectOptions) (string, error) {
+	n, err := m.awaitCarrier(ifwifi, opts.AssociationTimeout)
+	if err != nil {
+		return "", err
+	}
+	m.log.Info().Msgf("Carrier on %s: %s", ifwifi, n)
+	if n.Duplex == carrier.DuplexHalf {
+		m.log.Warn().Msgf("%s negotiated half duplex, a duplex mismatch with the other end loses packets under load", ifwifi)
+	}
+	if err := m.runNM(func(c WiFiManager) error { return c.ConnectDevice(ifwifi, opts.AssociationTimeout) }, "device", "connect", ifwifi); err != nil {
+		return "", fmt.Errorf("activating %s: %w", ifwifi, err)
+	}
+	m.changeLog.Record(changes.Connection, "activated", "%s", ifwifi)
+	m.connectedWiFi = ifwifi
+	if m.dryRun {
+		route, err := m.defaultRouter(ifwifi)
+		if err == nil && route == "" {
+			m.log.Warn().Msgf("Dry run, %s has no default router, the routes would go through the one its DHCP server provides", ifwifi)
+			route = "<DHCP router>"
+		}
+		return route, err
+	}
+	return m.awaitLease(ifwifi, opts.DHCPTimeout)
+}
+
+// slowLink reports whether the wired interface ifname lost its carrier or
+// negotiated a speed below --min-link-speed, and describes its link. A
+// negotiation that cannot be read is not considered slow.
+func (m *Manager) slowLink(ifname string) (bool, string) {
+	if m.minLinkSpeed == 0 {
+		return false, ""
+	}
+	n, err := m.readNegotiation(ifname)
+	if err != nil {
+		m.log.Debug().Msgf("Cannot read the negotiation of %s: %s", ifname, err)
+		return false, ""
+	}
+	switch {
+	case !n.Carrier:
+		return true, fmt.Sprintf("%s lost its carrier", ifname)
+	case n.Speed > 0 && n.Speed < m.minLinkSpeed:
+		return true, fmt.Sprintf("link speed %d Mbit/s below %d Mbit/s", n.Speed, m.minLinkSpeed)
+	}
+	return false, ""
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// init registers the plan command.
+func init() {
+	rootCmd.AddCommand(planCmd)
+}
+
+var planCmd = &cobra.Command{
+	Use:     "plan [monitor flags]",
+	Aliases: []string{"explain"},
+	Short:   "Print the routes, gateway and connection a failover would change",
+	Long: "Print, given the same flags and environment as the monitor, what it would do on failover and failback " +
+		"from the interfaces and routes as they are now: the routes replaced and the gateway used, " +
+		"the NetworkManager profile activated or created, and the DNS, time, firewall and hook changes. " +
+		"Nothing is changed, so a configuration can be reviewed on a new gateway before the monitor is enabled.",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := rootCmd.Flags()
+		if err := flags.Parse(args); err != nil {
+			log.Error().Msgf("Error parsing flags: %s", err)
+			os.Exit(1)
+		}
+		applyConfig()
+		if group, _ := flags.GetString("group"); len(groupNames) > 0 && group == "" {
+			log.Error().Msgf("The configuration defines the groups %s, select one with --group", strings.Join(groupNames, ", "))
+			os.Exit(1)
+		}
+		if err := reliability.Plan(monitorConfig); err != nil {
+			log.Error().Msgf("Cannot plan: %s", err)
+			os.Exit(1)
+		}
+	},
+}
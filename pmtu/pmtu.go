@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package pmtu discovers the path MTU toward an endpoint by bisection over
+// probes that must not be fragmented, and derives the TCP MSS filling it.
+package pmtu
+
+// Smallest MTUs every IPv4 host accepts to reassemble and every IPv6 link
+// carries, the lower bounds of the search.
+const (
+	MinIPv4 = 576
+	MinIPv6 = 1280
+)
+
+// Header sizes, without options.
+const (
+	headerIPv4 = 20
+	headerIPv6 = 40
+	headerICMP = 8
+	headerTCP  = 20
+)
+
+// Search returns the largest packet size from low to high for which fits
+// reports true, low being assumed to fit. It tries high first, the common
+// case of a path carrying the MTU of the interface, then bisects.
+func Search(low, high int, fits func(size int) bool) int {
+	if high <= low || fits(high) {
+		return max(low, high)
+	}
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		if fits(mid) {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return low
+}
+
+// Min returns the lower bound of the search over IPv6 if ipv6 is set, over
+// IPv4 otherwise.
+func Min(ipv6 bool) int {
+	if ipv6 {
+		return MinIPv6
+	}
+	return MinIPv4
+}
+
+// PayloadSize returns the size of the ICMP echo payload making a packet of
+// size bytes.
+func PayloadSize(size int, ipv6 bool) int {
+	return size - header(ipv6) - headerICMP
+}
+
+// MSS returns the TCP maximum segment size filling packets of mtu bytes.
+func MSS(mtu int, ipv6 bool) int {
+	return mtu - header(ipv6) - headerTCP
+}
+
+// header returns the size of the IP header.
+func header(ipv6 bool) int {
+	if ipv6 {
+		return headerIPv6
+	}
+	return headerIPv4
+}
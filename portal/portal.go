@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package portal detects captive portals: hotspots that associate and hand
+// out a lease, then intercept the traffic until a login page is filled in.
+// Like NetworkManager and Android, it fetches a URL answering 204 No Content
+// when the Internet is reachable; any other answer comes from the portal.
+package portal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DefaultURL is the connectivity check URL used by Android.
+const DefaultURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+// maxBody is the most of a response body read before closing it.
+const maxBody = 64 << 10
+
+// Result is the outcome of a check.
+type Result struct {
+	// Portal is set if the check was intercepted.
+	Portal bool
+	// Location is the page the check was redirected to, if any.
+	Location string
+	// Status is the status of the response.
+	Status string
+}
+
+// Check fetches url with client, which must not follow redirects. It returns
+// an error if the check could not be answered at all, or was answered with a
+// status no portal uses.
+func Check(client *http.Client, url string) (Result, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	r := Result{Status: resp.Status}
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		r.Portal = true
+		if location, err := resp.Location(); err == nil {
+			r.Location = location.String()
+		}
+	// Portals serving their login page in place of the answer.
+	case resp.StatusCode == http.StatusOK, resp.StatusCode == http.StatusNetworkAuthenticationRequired:
+		r.Portal = true
+	default:
+		return r, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return r, nil
+}
+
+// String describes the result.
+func (r Result) String() string {
+	switch {
+	case !r.Portal:
+		return "connected"
+	case r.Location != "":
+		return "redirected to " + r.Location
+	}
+	return "intercepted with " + r.Status
+}
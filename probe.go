@@ -0,0 +1,47 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// probeConfig is the configuration of the probe command, bound to its
+// flags.
+var probeConfig = reliability.DefaultConfig()
+
+// init registers the probe command, with the probe flags of the monitor.
+func init() {
+	probeCmd.Flags().StringP("interface", "i", "", "Interface the probes are bound to (default: the routing table decides)")
+	probeCmd.Flags().Int("count", 3, "Probes sent to each endpoint")
+	reliability.AddProbeFlags(probeCmd.Flags(), &probeConfig)
+	probeCmd.MarkFlagRequired("endpoint")
+	rootCmd.AddCommand(probeCmd)
+}
+
+var probeCmd = &cobra.Command{
+	Use:   "probe",
+	Short: "Probe endpoints once and print the results as JSON",
+	Long: "Probe the endpoints like the monitor does, count times each, and print one JSON report per endpoint. " +
+		"The exit status is 1 if an endpoint never answered.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ifname, _ := cmd.Flags().GetString("interface")
+		count, _ := cmd.Flags().GetInt("count")
+		reports, err := reliability.Probe(probeConfig, ifname, count)
+		if err != nil {
+			log.Error().Msgf("Cannot probe: %s", err)
+			os.Exit(1)
+		}
+		printJSON(reports)
+		for _, r := range reports {
+			if r.Received == 0 {
+				os.Exit(1)
+			}
+		}
+	},
+}
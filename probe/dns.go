@@ -0,0 +1,184 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS query types.
+const (
+	QueryNS   = "ns"
+	QueryA    = "a"
+	QueryAAAA = "aaaa"
+)
+
+// queryTypes are the DNS types of the query types.
+var queryTypes = map[string]dnsmessage.Type{QueryNS: dnsmessage.TypeNS, QueryA: dnsmessage.TypeA, QueryAAAA: dnsmessage.TypeAAAA}
+
+// DNS probes a DNS server with a query over UDP, for links on which the
+// resolvers matter more than any host, or that only let DNS through.
+type DNS struct {
+	// Timeout bounds the wait for the answer.
+	Timeout time.Duration
+	// Name is the name queried, "." if empty.
+	Name string
+	// Query is the type of the records queried: QueryNS if empty, QueryA or
+	// QueryAAAA.
+	Query string
+	// Expect, if valid, is the prefix an address of the answer to an A or
+	// AAAA query must be in, catching resolvers answering with a portal or
+	// a block page.
+	Expect netip.Prefix
+	// Network is the network host names are resolved in: "udp" (the
+	// default) for either family, "udp4" or "udp6".
+	Network string
+}
+
+// Probe queries the server at address, a host or host:port, leaving through
+// ifname if not empty. For NS records, any answer but a server failure or a
+// refusal is a success, a missing name included; A and AAAA queries must be
+// answered with a record of their type, in Expect if set. The socket is
+// opened in the network namespace of the calling thread.
+func (p *DNS) Probe(ctx context.Context, address string, ifname string) Result {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	name := p.Name
+	if name == "" {
+		name = "."
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return Failed(err)
+	}
+	query := p.Query
+	if query == "" {
+		query = QueryNS
+	}
+	qtype, ok := queryTypes[query]
+	if !ok {
+		return Failed(fmt.Errorf("unknown DNS query type %q", query))
+	}
+	id := uint16(rand.N(1 << 16))
+	packet, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return Failed(err)
+	}
+	network := p.Network
+	if network == "" {
+		network = "udp"
+	}
+	dialer := net.Dialer{Timeout: p.Timeout, Control: bind.Control(ifname)}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return Failed(failure(ctx, err))
+	}
+	defer conn.Close()
+	start := time.Now()
+	conn.SetDeadline(start.Add(p.Timeout))
+	defer abortOn(ctx, conn)()
+	if _, err := conn.Write(packet); err != nil {
+		return Failed(failure(ctx, err))
+	}
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return Failed(failure(ctx, err))
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			continue
+		}
+		rtt := time.Since(start)
+		if header.RCode == dnsmessage.RCodeServerFailure || header.RCode == dnsmessage.RCodeRefused ||
+			qtype != dnsmessage.TypeNS && header.RCode != dnsmessage.RCodeSuccess {
+			return Failed(fmt.Errorf("%w: %s", ErrRcode, strings.TrimPrefix(header.RCode.String(), "RCode")))
+		}
+		if qtype != dnsmessage.TypeNS {
+			if err := p.check(&parser, qtype); err != nil {
+				return Failed(err)
+			}
+		}
+		return Result{RTT: rtt}
+	}
+}
+
+// check checks that the answer parsed by parser holds an address record of
+// qtype, in Expect if set.
+func (p *DNS) check(parser *dnsmessage.Parser, qtype dnsmessage.Type) error {
+	if err := parser.SkipAllQuestions(); err != nil {
+		return fmt.Errorf("%w: %s", ErrAnswer, err)
+	}
+	var addrs []netip.Addr
+	for {
+		header, err := parser.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrAnswer, err)
+		}
+		switch {
+		case header.Type == qtype && qtype == dnsmessage.TypeA:
+			r, err := parser.AResource()
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrAnswer, err)
+			}
+			addrs = append(addrs, netip.AddrFrom4(r.A))
+		case header.Type == qtype && qtype == dnsmessage.TypeAAAA:
+			r, err := parser.AAAAResource()
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrAnswer, err)
+			}
+			addrs = append(addrs, netip.AddrFrom16(r.AAAA))
+		default:
+			if err := parser.SkipAnswer(); err != nil {
+				return fmt.Errorf("%w: %s", ErrAnswer, err)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%w: no %s record", ErrAnswer, strings.ToUpper(p.Query))
+	}
+	if !p.Expect.IsValid() {
+		return nil
+	}
+	for _, addr := range addrs {
+		if p.Expect.Contains(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s not in %s", ErrAnswer, addrs[0], p.Expect)
+}
+
+// parseExpect parses the prefix the answers of a DNS probe must be in, given
+// as a prefix or a single address.
+func parseExpect(text string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(text); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(text)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid expected answer %q: not an address or a prefix", text)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dontFragment sets the DF bit on the packets of conn, or forbids their
+// fragmentation over IPv6, ignoring the path MTU the kernel cached so that
+// larger packets are still probed.
+func dontFragment(conn net.PacketConn, ipv6 bool) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errNoFragmentControl
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			opErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+		} else {
+			opErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
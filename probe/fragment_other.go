@@ -0,0 +1,13 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package probe
+
+import "net"
+
+// dontFragment fails, the socket options it uses are Linux-only.
+func dontFragment(conn net.PacketConn, ipv6 bool) error {
+	return errNoFragmentControl
+}
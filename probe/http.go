@@ -0,0 +1,88 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"github.com/shynuu/if-reliability/netns"
+)
+
+// maxBody is the most of a response body read before closing it.
+const maxBody = 64 << 10
+
+// HTTP probes an endpoint with a GET request expecting a given status code.
+// Redirects are not followed, so that a redirect can be the expected status.
+type HTTP struct {
+	// Timeout bounds the whole request.
+	Timeout time.Duration
+	// Status is the expected status code.
+	Status int
+	// Namespace is the named network namespace the connections are opened
+	// in. The transport dials from its own goroutines, so the namespace of
+	// the calling thread would not apply.
+	Namespace string
+	// Network is the network host names are resolved in: "tcp" (the
+	// default) for either family, "tcp4" or "tcp6".
+	Network string
+}
+
+// Probe sends a GET request to address, a URL or a host probed over http,
+// leaving through ifname if not empty. The RTT is the time until the
+// response headers came back.
+func (p *HTTP) Probe(ctx context.Context, address string, ifname string) Result {
+	if !strings.Contains(address, "://") {
+		if strings.Contains(address, ":") && net.ParseIP(address) != nil {
+			address = "[" + address + "]"
+		}
+		address = "http://" + address + "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return Failed(err)
+	}
+	client := p.Client(ifname)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Failed(failure(ctx, err))
+	}
+	rtt := time.Since(start)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	resp.Body.Close()
+	if resp.StatusCode != p.Status {
+		return Failed(fmt.Errorf("%w %s, expected %d", ErrStatus, resp.Status, p.Status))
+	}
+	return Result{RTT: rtt}
+}
+
+// Client returns a client sending requests through ifname if not empty, from
+// within the namespace of p. It does not follow redirects.
+func (p *HTTP) Client(ifname string) *http.Client {
+	dialer := &net.Dialer{Control: bind.Control(ifname)}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if p.Network != "" {
+			network = p.Network
+		}
+		var conn net.Conn
+		err := netns.Do(p.Namespace, func() error {
+			var err error
+			conn, err = dialer.DialContext(ctx, network, addr)
+			return err
+		})
+		return conn, err
+	}
+	return &http.Client{
+		Timeout:       p.Timeout,
+		Transport:     &http.Transport{DialContext: dial, DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
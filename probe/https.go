@@ -0,0 +1,192 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"github.com/shynuu/if-reliability/netns"
+)
+
+// HTTPS probes an endpoint with a GET request over TLS, timing the DNS
+// lookup, the TCP connection, the TLS handshake and the wait for the first
+// byte of the response apart. Some cellular middleboxes let plain TCP
+// through but break TLS, which this probe catches where a TCP or ICMP one
+// does not.
+type HTTPS struct {
+	// Timeout bounds the whole request.
+	Timeout time.Duration
+	// Status is the expected status code.
+	Status int
+	// Verify validates the certificate chain and host name of the endpoint
+	// against the system roots.
+	Verify bool
+	// ServerName is the name sent in the TLS handshake and validated, the
+	// host of the URL if empty.
+	ServerName string
+	// Namespace is the named network namespace the connections are opened
+	// in.
+	Namespace string
+	// Network is the network host names are resolved in: "tcp" (the
+	// default) for either family, "tcp4" or "tcp6".
+	Network string
+}
+
+// timings collects the phases of a request from its trace hooks, called
+// from the goroutines of the transport.
+type timings struct {
+	mu                            sync.Mutex
+	dnsStart, connStart, tlsStart time.Time
+	dns, connect, tls             time.Duration
+	wrote                         time.Time
+	firstByte                     time.Duration
+	tlsErr                        error
+}
+
+// trace returns the hooks recording the phases into t.
+func (t *timings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.set(func() { t.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.set(func() { t.dns = time.Since(t.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			t.set(func() {
+				if t.connStart.IsZero() {
+					t.connStart = time.Now()
+				}
+			})
+		},
+		ConnectDone:       func(string, string, error) { t.set(func() { t.connect = time.Since(t.connStart) }) },
+		TLSHandshakeStart: func() { t.set(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.set(func() { t.tls, t.tlsErr = time.Since(t.tlsStart), err })
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(func() { t.wrote = time.Now() }) },
+		GotFirstResponseByte: func() { t.set(func() { t.firstByte = time.Since(t.wrote) }) },
+	}
+}
+
+// set runs f with t locked.
+func (t *timings) set(f func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f()
+}
+
+// phases returns the phases recorded so far.
+func (t *timings) phases() *Phases {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Phases{DNS: t.dns, Connect: t.connect, TLS: t.tls, FirstByte: t.firstByte}
+}
+
+// Probe sends a GET request to address, an https URL or a host probed over
+// https, leaving through ifname if not empty. The RTT is the time until the
+// response headers came back, and the phases are reported even when the
+// probe fails.
+func (p *HTTPS) Probe(ctx context.Context, address string, ifname string) Result {
+	if !strings.Contains(address, "://") {
+		if strings.Contains(address, ":") && net.ParseIP(address) != nil {
+			address = "[" + address + "]"
+		}
+		address = "https://" + address + "/"
+	}
+	t := &timings{}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, t.trace()), http.MethodGet, address, nil)
+	if err != nil {
+		return Failed(err)
+	}
+	if req.URL.Scheme != "https" {
+		return Failed(fmt.Errorf("%s is not an https URL", address))
+	}
+	start := time.Now()
+	resp, err := p.client(ifname).Do(req)
+	if err != nil {
+		result := Failed(p.failure(ctx, err, t))
+		result.Phases = t.phases()
+		return result
+	}
+	rtt := time.Since(start)
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxBody))
+	resp.Body.Close()
+	result := Result{RTT: rtt, Phases: t.phases()}
+	if resp.StatusCode != p.Status {
+		result.Err = fmt.Errorf("%w %s, expected %d", ErrStatus, resp.Status, p.Status)
+	}
+	return result
+}
+
+// failure maps the error of a request to the failure reasons of the
+// package, telling a broken TLS handshake or an invalid certificate from a
+// connection failure, and an abandoned request from both.
+func (p *HTTPS) failure(ctx context.Context, err error, t *timings) error {
+	if ctx.Err() != nil {
+		return failure(ctx, err)
+	}
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &verifyErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return fmt.Errorf("%w: %s", ErrCertificate, unwrapURL(err))
+	}
+	t.mu.Lock()
+	tlsErr, started, done := t.tlsErr, !t.tlsStart.IsZero(), t.tls > 0
+	t.mu.Unlock()
+	if tlsErr != nil || started && !done {
+		if tlsErr == nil {
+			tlsErr = unwrapURL(err)
+		}
+		return fmt.Errorf("%w: %s", ErrTLS, dialError(tlsErr))
+	}
+	return dialError(err)
+}
+
+// unwrapURL returns the error of a request without the method and URL the
+// client prefixes it with.
+func unwrapURL(err error) error {
+	if inner := errors.Unwrap(err); inner != nil {
+		return inner
+	}
+	return err
+}
+
+// client returns a client sending requests through ifname if not empty, from
+// within the namespace of p, over a new connection each time so that every
+// probe goes through the handshakes. It does not follow redirects.
+func (p *HTTPS) client(ifname string) *http.Client {
+	dialer := &net.Dialer{Control: bind.Control(ifname)}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if p.Network != "" {
+			network = p.Network
+		}
+		var conn net.Conn
+		err := netns.Do(p.Namespace, func() error {
+			var err error
+			conn, err = dialer.DialContext(ctx, network, addr)
+			return err
+		})
+		return conn, err
+	}
+	return &http.Client{
+		Timeout: p.Timeout,
+		Transport: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: !p.Verify, ServerName: p.ServerName},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// errNoFragmentControl is returned when the fragmentation of the requests
+// cannot be forbidden.
+var errNoFragmentControl = errors.New("cannot forbid the fragmentation of ICMP requests on this system")
+
+// nonceSize is the size of the random prefix of the payload matching replies
+// to requests.
+const nonceSize = 8
+
+// ICMP sends ICMP and ICMPv6 echo requests natively instead of running
+// ping(8). It uses unprivileged ICMP datagram sockets when allowed by
+// net.ipv4.ping_group_range, and raw sockets otherwise.
+type ICMP struct {
+	// Timeout bounds the wait for the reply.
+	Timeout time.Duration
+	// PayloadSize is the size of the echo payload, at least 8 bytes.
+	PayloadSize int
+	// TTL, or hop limit, of the requests, the system default if 0.
+	TTL int
+	// DontFragment forbids the fragmentation of the requests, by this host
+	// and the routers on the path, to probe the path MTU: a request larger
+	// than the interface or the path fails with ErrTooBig, or times out if
+	// a router drops it silently.
+	DontFragment bool
+	// Network is the network host names are resolved in: "ip4" (the
+	// default), "ip6" or "ip" for either. IP addresses are probed over
+	// their own family.
+	Network string
+
+	seq atomic.Uint32
+}
+
+// family holds what differs between ICMP and ICMPv6.
+type family struct {
+	domain   int
+	protocol int
+	// raw and any are the network and address raw sockets listen on.
+	raw   string
+	any   string
+	echo  icmp.Type
+	reply icmp.Type
+}
+
+// IANA protocol numbers of ICMP and ICMPv6, which package syscall does not
+// define on every platform.
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+var (
+	inet = family{
+		domain: syscall.AF_INET, protocol: protocolICMP, raw: "ip4:icmp", any: "0.0.0.0",
+		echo: ipv4.ICMPTypeEcho, reply: ipv4.ICMPTypeEchoReply,
+	}
+	inet6 = family{
+		domain: syscall.AF_INET6, protocol: protocolICMPv6, raw: "ip6:ipv6-icmp", any: "::",
+		echo: ipv6.ICMPTypeEchoRequest, reply: ipv6.ICMPTypeEchoReply,
+	}
+)
+
+// packetConn reads and writes ICMP messages with their TTL or hop limit.
+type packetConn interface {
+	setTTL(ttl int) error
+	writeTo(b []byte, to net.Addr) error
+	readFrom(b []byte) (n int, ttl int, err error)
+	SetReadDeadline(t time.Time) error
+}
+
+// conn4 is an ICMP packetConn.
+type conn4 struct{ *ipv4.PacketConn }
+
+func (c conn4) setTTL(ttl int) error { return c.SetTTL(ttl) }
+
+func (c conn4) writeTo(b []byte, to net.Addr) error {
+	_, err := c.WriteTo(b, nil, to)
+	return err
+}
+
+func (c conn4) readFrom(b []byte) (int, int, error) {
+	n, cm, _, err := c.ReadFrom(b)
+	if cm == nil {
+		return n, 0, err
+	}
+	return n, cm.TTL, err
+}
+
+// conn6 is an ICMPv6 packetConn.
+type conn6 struct{ *ipv6.PacketConn }
+
+func (c conn6) setTTL(ttl int) error { return c.SetHopLimit(ttl) }
+
+func (c conn6) writeTo(b []byte, to net.Addr) error {
+	_, err := c.WriteTo(b, nil, to)
+	return err
+}
+
+func (c conn6) readFrom(b []byte) (int, int, error) {
+	n, cm, _, err := c.ReadFrom(b)
+	if cm == nil {
+		return n, 0, err
+	}
+	return n, cm.HopLimit, err
+}
+
+// Probe sends one echo request to address, leaving through ifname if not
+// empty. The socket is opened in the network namespace of the calling
+// thread.
+func (p *ICMP) Probe(ctx context.Context, address string, ifname string) Result {
+	network := p.Network
+	if network == "" {
+		network = "ip4"
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		network = "ip"
+	}
+	dst, err := resolveIPAddr(ctx, network, address)
+	if err != nil {
+		return Failed(failure(ctx, err))
+	}
+	f := inet
+	if dst.IP.To4() == nil {
+		f = inet6
+	}
+	conn, raw, err := listen(ctx, f, ifname)
+	if err != nil {
+		return Failed(err)
+	}
+	defer conn.Close()
+	defer abortOn(ctx, conn)()
+	var pc packetConn
+	if f.domain == syscall.AF_INET {
+		c := conn4{ipv4.NewPacketConn(conn)}
+		// Not every kernel reports the TTL on datagram sockets, it is
+		// optional.
+		c.SetControlMessage(ipv4.FlagTTL, true)
+		pc = c
+	} else {
+		c := conn6{ipv6.NewPacketConn(conn)}
+		c.SetControlMessage(ipv6.FlagHopLimit, true)
+		pc = c
+	}
+	if p.TTL > 0 {
+		if err := pc.setTTL(p.TTL); err != nil {
+			return Failed(err)
+		}
+	}
+	if p.DontFragment {
+		if err := dontFragment(conn, f.domain == syscall.AF_INET6); err != nil {
+			return Failed(err)
+		}
+	}
+
+	payload := make([]byte, max(p.PayloadSize, nonceSize))
+	rand.Read(payload[:nonceSize])
+	var id [2]byte
+	rand.Read(id[:])
+	echo := &icmp.Echo{ID: int(binary.BigEndian.Uint16(id[:])), Seq: int(p.seq.Add(1) & 0xffff), Data: payload}
+	// The kernel computes the ICMPv6 checksum.
+	request, err := (&icmp.Message{Type: f.echo, Body: echo}).Marshal(nil)
+	if err != nil {
+		return Failed(err)
+	}
+	var to net.Addr = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	if raw {
+		to = dst
+	}
+
+	start := time.Now()
+	if err := pc.writeTo(request, to); err != nil {
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return Failed(ErrTooBig)
+		}
+		return Failed(err)
+	}
+	pc.SetReadDeadline(start.Add(p.Timeout))
+	// Large enough for the reply to a request larger than usual.
+	buf := make([]byte, max(1500, len(request)+ipv6.HeaderLen))
+	for {
+		n, ttl, err := pc.readFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Failed(failure(ctx, err))
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return Failed(ErrTimeout)
+			}
+			return Failed(err)
+		}
+		rtt := time.Since(start)
+		reply, err := icmp.ParseMessage(f.protocol, buf[:n])
+		if err != nil {
+			continue
+		}
+		switch body := reply.Body.(type) {
+		case *icmp.Echo:
+			if reply.Type != f.reply || body.Seq != echo.Seq || !bytes.HasPrefix(body.Data, payload[:nonceSize]) {
+				continue
+			}
+			return Result{RTT: rtt, TTL: ttl}
+		case *icmp.DstUnreach:
+			// Code 4 is fragmentation needed.
+			if quotes(body.Data, echo, dst.IP, raw) && f.domain == syscall.AF_INET && reply.Code == 4 {
+				return Failed(ErrTooBig)
+			}
+			if quotes(body.Data, echo, dst.IP, raw) {
+				return Failed(ErrUnreachable)
+			}
+		case *icmp.PacketTooBig:
+			if quotes(body.Data, echo, dst.IP, raw) {
+				return Failed(ErrTooBig)
+			}
+		case *icmp.TimeExceeded:
+			if quotes(body.Data, echo, dst.IP, raw) {
+				return Failed(ErrTTLExceeded)
+			}
+		}
+	}
+}
+
+// quotes reports whether the datagram quoted in an ICMP or ICMPv6 error is
+// echo, sent to dst. On datagram sockets, the kernel replaces the identifier
+// of the requests with the port of the socket, so only the sequence number
+// and the destination are matched; raw sockets send echo as is.
+func quotes(datagram []byte, echo *icmp.Echo, dst net.IP, raw bool) bool {
+	if len(datagram) < 1 {
+		return false
+	}
+	// The destination address is at 16 in IPv4 headers, 24 in IPv6 ones.
+	header, destination, want := int(datagram[0]&0x0f)*4, 16, dst.To4()
+	if datagram[0]>>4 == 6 {
+		header, destination, want = ipv6.HeaderLen, 24, dst.To16()
+	}
+	if want == nil || header < ipv4.HeaderLen || len(datagram) < header+8 {
+		return false
+	}
+	if !bytes.Equal(datagram[destination:destination+len(want)], want) {
+		return false
+	}
+	quoted := datagram[header:]
+	if raw && int(binary.BigEndian.Uint16(quoted[4:6])) != echo.ID {
+		return false
+	}
+	return int(binary.BigEndian.Uint16(quoted[6:8])) == echo.Seq
+}
+
+// resolveIPAddr resolves address in network as net.ResolveIPAddr does,
+// preferring IPv4 for "ip", but looks host names up within ctx.
+func resolveIPAddr(ctx context.Context, network, address string) (*net.IPAddr, error) {
+	if ip, err := netip.ParseAddr(address); err == nil {
+		return &net.IPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return &net.IPAddr{IP: ip}, nil
+		}
+	}
+	return &net.IPAddr{IP: ips[0]}, nil
+}
+
+// listen opens an ICMP socket of family f bound to ifname, if not empty, and
+// reports whether it is a raw socket.
+func listen(ctx context.Context, f family, ifname string) (net.PacketConn, bool, error) {
+	conn, err := listenDatagram(f, ifname)
+	if err == nil {
+		return conn, false, nil
+	}
+	lc := net.ListenConfig{Control: bind.Control(ifname)}
+	rawConn, rawErr := lc.ListenPacket(ctx, f.raw, f.any)
+	if rawErr != nil {
+		return nil, false, fmt.Errorf("no ICMP socket available: datagram: %s, raw: %s", err, rawErr)
+	}
+	return rawConn, true, nil
+}
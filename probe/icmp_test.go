@@ -0,0 +1,65 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// quotedEcho returns the datagram an ICMP error quotes for an echo request
+// with id and seq sent to dst: its IP header and the first 8 bytes of the
+// request.
+func quotedEcho(dst net.IP, id, seq int) []byte {
+	var datagram []byte
+	if ip := dst.To4(); ip != nil {
+		datagram = make([]byte, ipv4.HeaderLen+8)
+		datagram[0] = 4<<4 | ipv4.HeaderLen/4
+		copy(datagram[16:20], ip)
+	} else {
+		datagram = make([]byte, ipv6.HeaderLen+8)
+		datagram[0] = 6 << 4
+		copy(datagram[24:40], dst.To16())
+	}
+	echo := datagram[len(datagram)-8:]
+	binary.BigEndian.PutUint16(echo[4:6], uint16(id))
+	binary.BigEndian.PutUint16(echo[6:8], uint16(seq))
+	return datagram
+}
+
+func TestQuotes(t *testing.T) {
+	echo := &icmp.Echo{ID: 0x1234, Seq: 7}
+	v4, v6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+	for _, tt := range []struct {
+		name     string
+		datagram []byte
+		dst      net.IP
+		raw      bool
+		want     bool
+	}{
+		{"raw", quotedEcho(v4, 0x1234, 7), v4, true, true},
+		{"raw, other identifier", quotedEcho(v4, 0x4321, 7), v4, true, false},
+		// The kernel replaced the identifier with the port of the
+		// datagram socket.
+		{"datagram, rewritten identifier", quotedEcho(v4, 0xbeef, 7), v4, false, true},
+		{"datagram, other sequence", quotedEcho(v4, 0xbeef, 8), v4, false, false},
+		{"datagram, other destination", quotedEcho(net.ParseIP("192.0.2.2"), 0xbeef, 7), v4, false, false},
+		{"datagram, 4-byte destination", quotedEcho(v4, 0xbeef, 7), v4.To4(), false, true},
+		{"IPv6 raw", quotedEcho(v6, 0x1234, 7), v6, true, true},
+		{"IPv6 datagram, rewritten identifier", quotedEcho(v6, 0xbeef, 7), v6, false, true},
+		{"IPv6 datagram, other destination", quotedEcho(net.ParseIP("2001:db8::2"), 0xbeef, 7), v6, false, false},
+		{"other family", quotedEcho(v6, 0x1234, 7), v4, true, false},
+		{"truncated", quotedEcho(v4, 0x1234, 7)[:ipv4.HeaderLen+4], v4, true, false},
+		{"empty", nil, v4, true, false},
+	} {
+		if got := quotes(tt.datagram, echo, tt.dst, tt.raw); got != tt.want {
+			t.Errorf("%s: quotes = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
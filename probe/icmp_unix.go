@@ -0,0 +1,41 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !windows
+
+package probe
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/shynuu/if-reliability/bind"
+)
+
+// listenDatagram opens an unprivileged ICMP datagram socket of family f
+// bound to ifname, if not empty.
+func listenDatagram(f family, ifname string) (net.PacketConn, error) {
+	fd, err := syscall.Socket(f.domain, syscall.SOCK_DGRAM, f.protocol)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "icmp")
+	defer file.Close()
+	bound := false
+	if ifname != "" {
+		if bound, err = bind.Socket(fd, ifname, f.domain == syscall.AF_INET6); err != nil {
+			return nil, err
+		}
+	}
+	if !bound {
+		var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+		if f.domain == syscall.AF_INET6 {
+			sa = &syscall.SockaddrInet6{}
+		}
+		if err := syscall.Bind(fd, sa); err != nil {
+			return nil, err
+		}
+	}
+	return net.FilePacketConn(file)
+}
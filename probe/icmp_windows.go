@@ -0,0 +1,15 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"errors"
+	"net"
+)
+
+// listenDatagram fails, Windows having no unprivileged ICMP datagram
+// sockets: the probes use raw sockets.
+func listenDatagram(f family, ifname string) (net.PacketConn, error) {
+	return nil, errors.New("ICMP datagram sockets are not supported on Windows")
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// Neighbor checks that a neighbor on the link, usually the gateway, answers:
+// it sends an ARP request to an IPv4 address and a neighbor solicitation to
+// an IPv6 one, bypassing the neighbor cache of the kernel. The first hop can
+// then be probed far more often than an endpoint, at the cost of a few bytes
+// on the local link. It needs CAP_NET_RAW.
+type Neighbor struct {
+	// Timeout bounds the wait for the reply.
+	Timeout time.Duration
+}
+
+// Probe sends one request for the IP address address out of ifname, which
+// is required.
+func (n *Neighbor) Probe(ctx context.Context, address string, ifname string) Result {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return Failed(fmt.Errorf("invalid neighbor address %q", address))
+	}
+	if ifname == "" {
+		return Failed(errors.New("neighbor probes need an interface"))
+	}
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return Failed(err)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return n.arp(ctx, ip4, iface)
+	}
+	return n.solicit(ctx, ip, iface)
+}
+
+// solicit sends a neighbor solicitation for ip out of iface, to its
+// solicited-node multicast address, and waits for the advertisement.
+func (n *Neighbor) solicit(ctx context.Context, ip net.IP, iface *net.Interface) Result {
+	lc := net.ListenConfig{Control: bind.Control(iface.Name)}
+	conn, err := lc.ListenPacket(ctx, inet6.raw, inet6.any)
+	if err != nil {
+		return Failed(err)
+	}
+	defer conn.Close()
+	defer abortOn(ctx, conn)()
+	pc := ipv6.NewPacketConn(conn)
+	// Neighbor discovery messages are only valid with a hop limit of 255.
+	if err := pc.SetMulticastHopLimit(255); err != nil {
+		return Failed(err)
+	}
+	if err := pc.SetHopLimit(255); err != nil {
+		return Failed(err)
+	}
+	if err := pc.SetMulticastInterface(iface); err != nil {
+		return Failed(err)
+	}
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	pc.SetICMPFilter(&filter)
+
+	// The body is 4 reserved bytes, the target and the source link-layer
+	// address option, if the link has addresses.
+	body := append(make([]byte, 4), ip.To16()...)
+	if len(iface.HardwareAddr) > 0 {
+		option := []byte{1, byte((2 + len(iface.HardwareAddr) + 7) / 8)}
+		option = append(option, iface.HardwareAddr...)
+		body = append(body, option...)
+		body = append(body, make([]byte, int(option[1])*8-len(option))...)
+	}
+	// The kernel computes the ICMPv6 checksum.
+	request, err := (&icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{Data: body}}).Marshal(nil)
+	if err != nil {
+		return Failed(err)
+	}
+	target := ip.To16()
+	solicited := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, target[13], target[14], target[15]}
+
+	start := time.Now()
+	if _, err := pc.WriteTo(request, nil, &net.IPAddr{IP: solicited, Zone: iface.Name}); err != nil {
+		return Failed(err)
+	}
+	pc.SetReadDeadline(start.Add(n.Timeout))
+	buf := make([]byte, 1500)
+	for {
+		size, _, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Failed(failure(ctx, err))
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return Failed(ErrTimeout)
+			}
+			return Failed(err)
+		}
+		rtt := time.Since(start)
+		reply, err := icmp.ParseMessage(inet6.protocol, buf[:size])
+		if err != nil || reply.Type != ipv6.ICMPTypeNeighborAdvertisement {
+			continue
+		}
+		// The advertisement body is 4 bytes of flags and the target.
+		raw, ok := reply.Body.(*icmp.RawBody)
+		if !ok || len(raw.Data) < 20 || !bytes.Equal(raw.Data[4:20], ip.To16()) {
+			continue
+		}
+		return Result{RTT: rtt}
+	}
+}
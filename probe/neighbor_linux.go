@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// arpLen is the length of an ARP packet for IPv4 over Ethernet.
+const arpLen = 28
+
+// cancelCheck is how often an ARP probe checks whether it was abandoned.
+const cancelCheck = 100 * time.Millisecond
+
+// htons converts a short to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// arp broadcasts an ARP request for ip out of iface and waits for the reply,
+// over a packet socket. The socket cannot be given a deadline from another
+// goroutine, so ctx is checked every cancelCheck.
+func (n *Neighbor) arp(ctx context.Context, ip net.IP, iface *net.Interface) Result {
+	if len(iface.HardwareAddr) != 6 {
+		return Failed(fmt.Errorf("%s has no Ethernet address, it does not resolve neighbors with ARP", iface.Name))
+	}
+	source, err := interfaceIPv4(iface)
+	if err != nil {
+		return Failed(err)
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return Failed(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return Failed(err)
+	}
+
+	request := make([]byte, arpLen)
+	binary.BigEndian.PutUint16(request[0:], 1)      // Ethernet
+	binary.BigEndian.PutUint16(request[2:], 0x0800) // IPv4
+	request[4], request[5] = 6, 4
+	binary.BigEndian.PutUint16(request[6:], 1) // request
+	copy(request[8:], iface.HardwareAddr)
+	copy(request[14:], source)
+	copy(request[24:], ip)
+	broadcast := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	start := time.Now()
+	if err := unix.Sendto(fd, request, 0, broadcast); err != nil {
+		return Failed(err)
+	}
+	deadline := start.Add(n.Timeout)
+	buf := make([]byte, 1500)
+	for {
+		if ctx.Err() != nil {
+			return Failed(failure(ctx, nil))
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return Failed(ErrTimeout)
+		}
+		remaining = min(remaining, cancelCheck)
+		tv := unix.NsecToTimeval(remaining.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return Failed(err)
+		}
+		size, _, err := unix.Recvfrom(fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return Failed(err)
+		}
+		reply := buf[:size]
+		// A reply from ip, whatever the address it was sent to.
+		if size >= arpLen && binary.BigEndian.Uint16(reply[6:]) == 2 && bytes.Equal(reply[14:18], ip) {
+			return Result{RTT: time.Since(start)}
+		}
+	}
+}
+
+// interfaceIPv4 returns the first IPv4 address of iface, the sender address
+// of the ARP requests.
+func interfaceIPv4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no IPv4 address", iface.Name)
+}
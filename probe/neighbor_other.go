@@ -0,0 +1,17 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// arp fails, the packet sockets it uses are Linux-only.
+func (n *Neighbor) arp(ctx context.Context, ip net.IP, iface *net.Interface) Result {
+	return Failed(errors.New("ARP probes are only supported on Linux"))
+}
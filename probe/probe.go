@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package probe implements native endpoint probes returning typed results.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Failure reasons.
+var (
+	// ErrTimeout is returned when no reply came back in time.
+	ErrTimeout = errors.New("timeout")
+	// ErrUnreachable is returned when a router reported the destination
+	// unreachable.
+	ErrUnreachable = errors.New("destination unreachable")
+	// ErrTTLExceeded is returned when the request ran out of hops.
+	ErrTTLExceeded = errors.New("TTL exceeded")
+	// ErrTooBig is returned when a request that must not be fragmented is
+	// larger than the interface or a link of the path carries.
+	ErrTooBig = errors.New("packet too big")
+	// ErrRefused is returned when the endpoint refused the connection.
+	ErrRefused = errors.New("connection refused")
+	// ErrStatus is returned when an HTTP endpoint answered with another
+	// status than expected.
+	ErrStatus = errors.New("unexpected status")
+	// ErrRcode is returned when a DNS server failed or refused to answer.
+	ErrRcode = errors.New("DNS error")
+	// ErrAnswer is returned when a DNS answer lacks the records queried or
+	// holds unexpected ones.
+	ErrAnswer = errors.New("unexpected DNS answer")
+	// ErrTLS is returned when the TLS handshake failed, e.g. reset by a
+	// middlebox letting plain TCP through.
+	ErrTLS = errors.New("TLS handshake failed")
+	// ErrCertificate is returned when the certificate chain of the endpoint
+	// did not validate.
+	ErrCertificate = errors.New("invalid certificate")
+)
+
+// Probe types.
+const (
+	TypeICMP  = "icmp"
+	TypeTCP   = "tcp"
+	TypeHTTP  = "http"
+	TypeDNS   = "dns"
+	TypeHTTPS = "https"
+)
+
+// Prober sends one probe to address, leaving through ifname if not empty.
+// The probe is abandoned when ctx is done, failing with ErrTimeout past the
+// deadline of ctx and with its error otherwise. New probe types implement it
+// and are made available with Register.
+type Prober interface {
+	Probe(ctx context.Context, address string, ifname string) Result
+}
+
+// Result is the outcome of a probe.
+type Result struct {
+	// RTT is the round-trip time of a successful probe.
+	RTT time.Duration
+	// TTL is the TTL of the reply, 0 if unknown.
+	TTL int
+	// Phases are the durations of the steps of the probe, for the probers
+	// measuring them, nil otherwise.
+	Phases *Phases
+	// Err is the reason of the failure, nil on success.
+	Err error
+}
+
+// Phases are the durations of the steps of an HTTPS probe. A step that did
+// not happen, e.g. the DNS lookup of an IP address, lasts 0.
+type Phases struct {
+	DNS       time.Duration `json:"dns"`
+	Connect   time.Duration `json:"connect"`
+	TLS       time.Duration `json:"tls"`
+	FirstByte time.Duration `json:"first_byte"`
+}
+
+// Map returns the phases by name: dns, connect, tls and first_byte.
+func (p Phases) Map() map[string]time.Duration {
+	return map[string]time.Duration{"dns": p.DNS, "connect": p.Connect, "tls": p.TLS, "first_byte": p.FirstByte}
+}
+
+// String describes p, e.g. "dns 12ms, connect 40ms, tls 85ms, first byte
+// 60ms".
+func (p Phases) String() string {
+	return fmt.Sprintf("dns %s, connect %s, tls %s, first byte %s", round(p.DNS), round(p.Connect), round(p.TLS), round(p.FirstByte))
+}
+
+// round rounds d for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Microsecond)
+}
+
+// OK reports whether the probe succeeded.
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+// Failed returns the result of a probe that failed with err.
+func Failed(err error) Result {
+	return Result{Err: err}
+}
+
+// deadliner is a connection whose reads can be given a deadline.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// abortOn makes the pending and next reads of conn fail at once when ctx is
+// done. The returned function stops watching ctx.
+func abortOn(ctx context.Context, conn deadliner) func() bool {
+	return context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+}
+
+// failure returns the reason of a probe that failed with err: the error of
+// ctx if it is done, so that an abandoned probe is not taken for a lost
+// reply, and err mapped by dialError otherwise.
+func failure(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return dialError(ctx.Err())
+	}
+	return dialError(err)
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure a prober built by the factory of its type.
+type Options struct {
+	// Timeout bounds one probe.
+	Timeout time.Duration
+	// Namespace is the named network namespace the probes are sent from,
+	// for probers dialing from other goroutines than the calling one.
+	Namespace string
+	// Family is the suffix of the networks host names are resolved in:
+	// "4", "6", or empty for either, e.g. "tcp"+Family.
+	Family string
+	// Params are the settings specific to the type, e.g. "port" for TCP.
+	Params map[string]string
+}
+
+// Int returns the integer parameter name, or def if it is not set.
+func (o Options) Int(name string, def int) (int, error) {
+	text, ok := o.Params[name]
+	if !ok {
+		return def, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid probe option %s=%q: not an integer", name, text)
+	}
+	return value, nil
+}
+
+// Bool returns the boolean parameter name, or def if it is not set.
+func (o Options) Bool(name string, def bool) (bool, error) {
+	text, ok := o.Params[name]
+	if !ok {
+		return def, nil
+	}
+	value, err := strconv.ParseBool(text)
+	if err != nil {
+		return false, fmt.Errorf("invalid probe option %s=%q: not a boolean", name, text)
+	}
+	return value, nil
+}
+
+// String returns the parameter name, or def if it is not set.
+func (o Options) String(name string, def string) string {
+	if text, ok := o.Params[name]; ok {
+		return text
+	}
+	return def
+}
+
+// Factory builds a prober.
+type Factory func(o Options) (Prober, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a probe type available under name, usually from the init
+// function of the package implementing it, so that a build importing that
+// package can select it with --probe-type. It panics if name is already
+// registered.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("probe type registered twice: " + name)
+	}
+	registry[name] = f
+}
+
+// New builds a prober of the type registered under name.
+func New(name string, o Options) (Prober, error) {
+	registryMu.Lock()
+	f, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown probe type %q, expected one of %v", name, Types())
+	}
+	return f(o)
+}
+
+// Types returns the registered probe types, sorted.
+func Types() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builtin reports whether name is a probe type of this package.
+func Builtin(name string) bool {
+	return name == TypeICMP || name == TypeTCP || name == TypeHTTP || name == TypeHTTPS || name == TypeDNS
+}
+
+// init registers the built-in probe types.
+func init() {
+	Register(TypeICMP, func(o Options) (Prober, error) {
+		size, err := o.Int("payload-size", 56)
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := o.Int("ttl", 0)
+		if err != nil {
+			return nil, err
+		}
+		if size < nonceSize {
+			return nil, fmt.Errorf("ICMP payload size %d below %d bytes", size, nonceSize)
+		}
+		return &ICMP{Timeout: o.Timeout, PayloadSize: size, TTL: ttl, Network: "ip" + o.Family}, nil
+	})
+	Register(TypeTCP, func(o Options) (Prober, error) {
+		port, err := o.Int("port", 443)
+		if err != nil {
+			return nil, err
+		}
+		return &TCP{Timeout: o.Timeout, Port: port, Network: "tcp" + o.Family}, nil
+	})
+	Register(TypeHTTP, func(o Options) (Prober, error) {
+		status, err := o.Int("status", 200)
+		if err != nil {
+			return nil, err
+		}
+		return &HTTP{Timeout: o.Timeout, Status: status, Namespace: o.Namespace, Network: "tcp" + o.Family}, nil
+	})
+	Register(TypeHTTPS, func(o Options) (Prober, error) {
+		status, err := o.Int("status", 200)
+		if err != nil {
+			return nil, err
+		}
+		verify, err := o.Bool("verify", true)
+		if err != nil {
+			return nil, err
+		}
+		return &HTTPS{Timeout: o.Timeout, Status: status, Verify: verify, ServerName: o.String("server-name", ""), Namespace: o.Namespace, Network: "tcp" + o.Family}, nil
+	})
+	Register(TypeDNS, func(o Options) (Prober, error) {
+		d := &DNS{Timeout: o.Timeout, Name: o.String("name", "."), Query: o.String("qtype", QueryNS), Network: "udp" + o.Family}
+		if _, ok := queryTypes[d.Query]; !ok {
+			return nil, fmt.Errorf("unknown DNS query type %q, expected %s, %s or %s", d.Query, QueryNS, QueryA, QueryAAAA)
+		}
+		if d.Query != QueryNS && (d.Name == "" || d.Name == ".") {
+			return nil, fmt.Errorf("DNS probes of %s records need a name probe option", strings.ToUpper(d.Query))
+		}
+		if expect := o.String("expect", ""); expect != "" {
+			if d.Query == QueryNS {
+				return nil, fmt.Errorf("the expect probe option only applies to qtype=%s or qtype=%s", QueryA, QueryAAAA)
+			}
+			prefix, err := parseExpect(expect)
+			if err != nil {
+				return nil, err
+			}
+			d.Expect = prefix
+		}
+		return d, nil
+	})
+}
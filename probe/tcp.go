@@ -0,0 +1,69 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/shynuu/if-reliability/bind"
+)
+
+// TCP probes an endpoint by opening a TCP connection to it, for networks
+// deprioritizing or dropping ICMP.
+type TCP struct {
+	// Timeout bounds the handshake.
+	Timeout time.Duration
+	// Port is connected to when the address has none.
+	Port int
+	// Network is the network host names are resolved in: "tcp" (the
+	// default) for either family, "tcp4" or "tcp6".
+	Network string
+}
+
+// Probe connects to address, a host or host:port, leaving through ifname if
+// not empty, and closes the connection at once. The RTT is the duration of
+// the handshake. The socket is opened in the network namespace of the
+// calling thread.
+func (p *TCP) Probe(ctx context.Context, address string, ifname string) Result {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(p.Port))
+	}
+	dialer := net.Dialer{Timeout: p.Timeout, Control: bind.Control(ifname)}
+	start := time.Now()
+	network := p.Network
+	if network == "" {
+		network = "tcp"
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return Failed(failure(ctx, err))
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return Result{RTT: rtt}
+}
+
+// dialError maps a connection error to the failure reasons of the package,
+// or returns it unchanged.
+func dialError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrUnreachable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	return err
+}
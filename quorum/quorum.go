@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package quorum decides whether a link failed from the probes sent to
+// several endpoints, so that one endpoint going down, e.g. for maintenance,
+// does not trigger a failover.
+package quorum
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shynuu/if-reliability/probe"
+)
+
+// Majority returns the default quorum for n endpoints: more than half of
+// them.
+func Majority(n int) int {
+	return n/2 + 1
+}
+
+// Status is the result of the probe sent to one endpoint.
+type Status struct {
+	Endpoint string
+	Result   probe.Result
+}
+
+// String renders the status, e.g. "8.8.8.8 ok (12ms)" or
+// "1.1.1.1 failed (timeout)".
+func (s Status) String() string {
+	if s.Result.OK() {
+		return fmt.Sprintf("%s ok (%s)", s.Endpoint, s.Result.RTT)
+	}
+	return fmt.Sprintf("%s failed (%s)", s.Endpoint, s.Result.Err)
+}
+
+// Round is the outcome of probing every endpoint once.
+type Round struct {
+	Statuses []Status
+	// Quorum is the number of endpoints that must fail for the round to fail.
+	Quorum int
+}
+
+// Failures returns the number of endpoints that did not answer.
+func (r Round) Failures() int {
+	failures := 0
+	for _, s := range r.Statuses {
+		if !s.Result.OK() {
+			failures++
+		}
+	}
+	return failures
+}
+
+// Failed reports whether at least a quorum of endpoints did not answer.
+func (r Round) Failed() bool {
+	return r.Failures() >= r.Quorum
+}
+
+// String renders the round, e.g.
+// "1 of 3 endpoints failed, quorum 2: 8.8.8.8 failed (timeout), 1.1.1.1 ok (9ms), 9.9.9.9 ok (11ms)".
+func (r Round) String() string {
+	statuses := make([]string, len(r.Statuses))
+	for i, s := range r.Statuses {
+		statuses[i] = s.String()
+	}
+	return fmt.Sprintf("%d of %d endpoints failed, quorum %d: %s", r.Failures(), len(r.Statuses), r.Quorum, strings.Join(statuses, ", "))
+}
+
+// Tracker follows the status of each endpoint across rounds. The zero value
+// is ready to use.
+type Tracker struct {
+	down map[string]bool
+}
+
+// Observe records a round and returns the statuses of the endpoints that went
+// down or came back up since the previous one.
+func (t *Tracker) Observe(r Round) []Status {
+	if t.down == nil {
+		t.down = map[string]bool{}
+	}
+	var changed []Status
+	for _, s := range r.Statuses {
+		down := !s.Result.OK()
+		if down != t.down[s.Endpoint] {
+			changed = append(changed, s)
+		}
+		t.down[s.Endpoint] = down
+	}
+	return changed
+}
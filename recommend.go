@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/spf13/cobra"
+)
+
+// init registers the recommend command.
+func init() {
+	recommendCmd.Flags().String("history-snapshot", "", "History snapshot file to analyze (required)")
+	recommendCmd.Flags().Duration("min-outage", 30*time.Second, "Minimum duration for an outage seen by a single endpoint to count as real")
+	recommendCmd.Flags().Bool("json", false, "Print the analysis as JSON")
+	recommendCmd.MarkFlagRequired("history-snapshot")
+	rootCmd.AddCommand(recommendCmd)
+}
+
+var recommendCmd = &cobra.Command{
+	Use:   "recommend",
+	Short: "Recommend probe endpoints from the collected history",
+	Long:  "Analyze the probe history to rank endpoints by how well their failures match real outages and by RTT stability, and flag endpoints whose failures never reflect user-visible impact.",
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshot, _ := cmd.Flags().GetString("history-snapshot")
+		minOutage, _ := cmd.Flags().GetDuration("min-outage")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		samples, err := history.LoadSnapshot(snapshot)
+		if err != nil {
+			log.Error().Msgf("Error reading history: %s", err)
+			os.Exit(1)
+		}
+		stats := history.Analyze(samples, minOutage)
+		if asJSON {
+			json.NewEncoder(os.Stdout).Encode(stats)
+			return
+		}
+		if len(stats) == 0 {
+			fmt.Println("No samples in history")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RANK\tENDPOINT\tSAMPLES\tSUCCESS\tMEAN RTT\tSTDDEV\tFAILURES\tCORRELATED\tNOTE")
+		for i, st := range stats {
+			note := ""
+			if st.Noisy() {
+				note = "failures rarely match real outages"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%d\t%.1f%%\t%s\t%s\t%d\t%.0f%%\t%s\n", i+1, st.Endpoint, st.Samples, st.SuccessRatio*100,
+				st.MeanRTT.Round(time.Microsecond), st.StdDevRTT.Round(time.Microsecond), st.Failures, st.Correlation*100, note)
+		}
+		w.Flush()
+		fmt.Printf("\nRecommended endpoint: %s\n", stats[0].Endpoint)
+	},
+}
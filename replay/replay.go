@@ -0,0 +1,222 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package replay records the interactions with the WiFi backend (external
+// programs, NetworkManager operations and NetworkManager presence on D-Bus)
+// and replays them, so that backend changes can be validated against recordings of
+// real-world access points, including slow DHCP and authentication failures.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event kinds.
+const (
+	// KindExec is a run of an external program.
+	KindExec = "exec"
+	// KindNM is a change of NetworkManager presence on the system bus.
+	KindNM = "nm"
+)
+
+// redacted replaces secrets in recorded arguments.
+const redacted = "<redacted>"
+
+// Event is one recorded interaction.
+type Event struct {
+	// Offset is the time elapsed since the start of the recording.
+	Offset time.Duration `json:"offset"`
+	Kind   string        `json:"kind"`
+	// Program, Args, Output, ExitCode, Error and Duration describe a
+	// KindExec event. ExitCode is -1 when the program could not run, Error
+	// then holds the reason.
+	Program  string        `json:"program,omitempty"`
+	Args     []string      `json:"args,omitempty"`
+	Output   string        `json:"output,omitempty"`
+	ExitCode int           `json:"exit_code,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	// Running is the NetworkManager presence of a KindNM event.
+	Running bool `json:"running,omitempty"`
+}
+
+// ExitError is returned by a replayed program that exited with a non-zero
+// status.
+type ExitError struct {
+	Code int
+}
+
+// Error implements error.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the recorded exit status.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// Redact replaces the values following secret arguments, such as a
+// password, so that recordings can be shared.
+func Redact(args []string) []string {
+	out := slices.Clone(args)
+	for i := 0; i+1 < len(out); i++ {
+		switch out[i] {
+		case "password", "wifi-sec.psk", "802-11-wireless-security.psk":
+			out[i+1] = redacted
+		}
+	}
+	return out
+}
+
+// Recorder writes interactions to a file, one JSON event per line.
+type Recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+// Create starts a recording into path.
+func Create(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file), start: time.Now()}, nil
+}
+
+// Exec records a run of program. err is the error returned by the run, its
+// exit status is extracted when available.
+func (r *Recorder) Exec(program string, args []string, output []byte, err error, duration time.Duration) {
+	e := Event{Kind: KindExec, Program: program, Args: Redact(args), Output: string(output), Duration: duration}
+	var exitErr interface{ ExitCode() int }
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		e.ExitCode = exitErr.ExitCode()
+	default:
+		e.ExitCode = -1
+		e.Error = err.Error()
+	}
+	r.write(e)
+}
+
+// NM records a change of NetworkManager presence.
+func (r *Recorder) NM(running bool) {
+	r.write(Event{Kind: KindNM, Running: running})
+}
+
+func (r *Recorder) write(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.Offset = time.Since(r.start)
+	r.enc.Encode(e)
+}
+
+// Close ends the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Player replays a recording.
+type Player struct {
+	// Fallback, if not nil, answers the runs missing from the recording,
+	// which then do not fail. A Player without recording and with a
+	// Fallback simulates the backend.
+	Fallback func(program string, args []string) ([]byte, error)
+
+	mu     sync.Mutex
+	events []Event
+	used   []bool
+}
+
+// Load reads a recording from path.
+func Load(path string) (*Player, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p := &Player{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		p.events = append(p.events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	p.used = make([]bool, len(p.events))
+	return p, nil
+}
+
+// Exec replays the next recorded run of program with the same arguments,
+// taking as long as the recorded run did. It fails if no such run is left.
+func (p *Player) Exec(program string, args ...string) ([]byte, error) {
+	args = Redact(args)
+	p.mu.Lock()
+	var e *Event
+	for i := range p.events {
+		if !p.used[i] && p.events[i].Kind == KindExec && p.events[i].Program == program && slices.Equal(p.events[i].Args, args) {
+			p.used[i] = true
+			e = &p.events[i]
+			break
+		}
+	}
+	p.mu.Unlock()
+	if e == nil && p.Fallback != nil {
+		return p.Fallback(program, args)
+	}
+	if e == nil {
+		return nil, fmt.Errorf("no recorded run of %s %v left", program, args)
+	}
+	time.Sleep(e.Duration)
+	switch {
+	case e.ExitCode == -1:
+		return []byte(e.Output), errors.New(e.Error)
+	case e.ExitCode != 0:
+		return []byte(e.Output), &ExitError{Code: e.ExitCode}
+	}
+	return []byte(e.Output), nil
+}
+
+// NM calls fn with each recorded NetworkManager presence at its original
+// offset. It returns once all changes have been replayed.
+func (p *Player) NM(fn func(running bool)) {
+	start := time.Now()
+	for _, e := range p.events {
+		if e.Kind != KindNM {
+			continue
+		}
+		time.Sleep(time.Until(start.Add(e.Offset)))
+		fn(e.Running)
+	}
+}
+
+// Unused returns the recorded runs that were not replayed, which usually
+// means the backend behaves differently from the recording.
+func (p *Player) Unused() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var unused []Event
+	for i, e := range p.events {
+		if !p.used[i] && e.Kind == KindExec {
+			unused = append(unused, e)
+		}
+	}
+	return unused
+}
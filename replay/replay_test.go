@@ -0,0 +1,165 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shynuu/if-reliability/fsm"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/pkg/reliability/fake"
+	"github.com/shynuu/if-reliability/replay"
+	"github.com/shynuu/if-reliability/wifi"
+	"github.com/spf13/pflag"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	r, err := replay.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Exec("nm", []string{"wifi", "connect", "backup", "wlan0", "password", "secret"}, nil, nil, 20*time.Millisecond)
+	r.Exec("iw", []string{"dev", "wlan0", "link"}, []byte("Not connected."), &replay.ExitError{Code: 1}, 0)
+	r.Exec("iw", []string{"dev", "wlan0", "link"}, nil, errors.New("executable file not found"), 0)
+	r.Exec("sysctl", []string{"-n", "net.ipv4.tcp_keepalive_time"}, []byte("7200"), nil, 0)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := replay.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := p.Exec("nm", "wifi", "connect", "backup", "wlan0", "password", "other"); err != nil {
+		t.Errorf("connect: %s", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("connect replayed in %s, want the recorded 20ms", d)
+	}
+	output, err := p.Exec("iw", "dev", "wlan0", "link")
+	var exitErr *replay.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || string(output) != "Not connected." {
+		t.Errorf("first link: %q, %v, want the recorded exit status 1", output, err)
+	}
+	if _, err := p.Exec("iw", "dev", "wlan0", "link"); err == nil || errors.As(err, &exitErr) {
+		t.Errorf("second link: %v, want the recorded failure to run", err)
+	}
+	if _, err := p.Exec("iw", "dev", "wlan0", "link"); err == nil {
+		t.Error("third link replayed, want no recorded run left")
+	}
+	unused := p.Unused()
+	if len(unused) != 1 || unused[0].Program != "sysctl" {
+		t.Errorf("unused %+v, want the sysctl run", unused)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	args := []string{"wifi", "connect", "backup", "password", "secret"}
+	if got := replay.Redact(args); !slices.Equal(got, []string{"wifi", "connect", "backup", "password", "<redacted>"}) {
+		t.Errorf("Redact = %q", got)
+	}
+	if args[4] != "secret" {
+		t.Error("Redact changed its argument")
+	}
+}
+
+// The recordings of testdata are access points misbehaving on failover,
+// recorded with these flags.
+var fixtureFlags = []string{
+	"--wifi-if", "wlan0", "--wifi-ssid", "backup", "--wifi-password", "secret",
+	"--endpoint", "8.8.8.8", "--interval", "10ms", "--retry", "3", "--hold-down", "0s",
+	"--wifi-connect-attempts", "2", "--wifi-retry-spacing", "100ms",
+	"--diagnose=false", "--carrier-watch=false",
+	"--state-file", "", "--control-socket", "", "--trigger-socket", "", "--watch-socket", "", "--lock-dir", "",
+}
+
+func TestFixtures(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		flags []string
+		// want is the state the failover ends in, and phase the phase of
+		// the connection that failed if it stopped.
+		want  fsm.State
+		phase string
+		// atLeast is the shortest time the failover takes.
+		atLeast time.Duration
+	}{
+		{name: "association-retry", want: fsm.OnBackup, atLeast: 700 * time.Millisecond},
+		{name: "auth-failure", want: fsm.Stopped, phase: wifi.PhaseAssociation},
+		{name: "dhcp-timeout", flags: []string{"--wifi-dhcp-timeout", "2s"}, want: fsm.Stopped, phase: wifi.PhaseDHCP},
+		{name: "slow-dhcp", flags: []string{"--wifi-dhcp-timeout", "10s"}, want: fsm.OnBackup, atLeast: 4 * time.Second},
+		{name: "nm-restart", want: fsm.OnBackup, atLeast: 1400 * time.Millisecond},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var config reliability.Config
+			flags := pflag.NewFlagSet(tt.name, pflag.ContinueOnError)
+			reliability.AddFlags(flags, &config)
+			args := append(slices.Clone(fixtureFlags), tt.flags...)
+			args = append(args, "--replay", filepath.Join("testdata", tt.name+".jsonl"))
+			if err := flags.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			routes := fake.NewRoutes("eth0")
+			pinger := fake.NewPinger(routes, time.Millisecond)
+			config.Pinger, config.WiFi, config.Routes = pinger, fake.NewWiFi(routes, "192.168.1.1"), routes
+			m, err := reliability.NewManager(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- m.Run(ctx) }()
+
+			pinger.SetDown("eth0", true)
+			failingOver := wait(t, m, fsm.FailingOver)
+			// The primary link answers again behind the routes, which
+			// the replay leaves alone, so that WiFi is seen as working.
+			pinger.SetDown("eth0", false)
+			end := wait(t, m, tt.want)
+			if d := end.Time.Sub(failingOver.Time); d < tt.atLeast {
+				t.Errorf("failover took %s, want at least %s as recorded", d, tt.atLeast)
+			}
+			if tt.want != fsm.Stopped {
+				cancel()
+			}
+			err = <-done
+			var connectErr *wifi.ConnectError
+			switch {
+			case tt.phase == "" && err != nil:
+				t.Errorf("Run: %s", err)
+			case tt.phase != "" && !errors.As(err, &connectErr):
+				t.Errorf("Run: %v, want a connection failure", err)
+			case tt.phase != "" && (connectErr.Phase != tt.phase || connectErr.Attempts != 2):
+				t.Errorf("connection failed in %s after %d attempts, want %s after 2", connectErr.Phase, connectErr.Attempts, tt.phase)
+			}
+		})
+	}
+}
+
+// wait reads the events of m until a transition to state, and returns it.
+func wait(t *testing.T, m *reliability.Manager, state fsm.State) reliability.Event {
+	t.Helper()
+	timeout := time.After(15 * time.Second)
+	for {
+		select {
+		case e, ok := <-m.Events():
+			if !ok {
+				t.Fatalf("events closed before reaching %s", state)
+			}
+			if e.Kind == reliability.EventTransition && e.To == state {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("state %s not reached, in %s", state, m.Status().State)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/dispatcher"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// init registers the report command.
+func init() {
+	reportCmd.Flags().String("socket", reliability.DefaultTriggerSocket, "Trigger socket of the running instance")
+	reportCmd.Flags().String("source", "", "Name of the reporting agent, a new report replaces the previous one of the same source (required)")
+	reportCmd.Flags().Float64("failure-ratio", 0, "Failure ratio observed by the agent over the link, between 0 and 1")
+	reportCmd.Flags().Float64("weight", 1, "Weight of the report relative to the built-in probe")
+	reportCmd.Flags().Duration("ttl", 5*time.Minute, "How long the report stays in effect")
+	reportCmd.MarkFlagRequired("source")
+	rootCmd.AddCommand(reportCmd)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report <interface|primary>",
+	Short: "Push an external health signal about a link to the running instance",
+	Long: "Push a health signal from an external agent, e.g. an application seeing request failures, to the running instance. " +
+		"Signals are weighted inputs alongside the built-in probe: a link is unhealthy when the weighted mean failure ratio reaches 50%.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		socket, _ := cmd.Flags().GetString("socket")
+		event := dispatcher.Event{Interface: args[0], Action: dispatcher.ActionHealth}
+		event.Source, _ = cmd.Flags().GetString("source")
+		event.Failure, _ = cmd.Flags().GetFloat64("failure-ratio")
+		event.Weight, _ = cmd.Flags().GetFloat64("weight")
+		event.Timeout, _ = cmd.Flags().GetDuration("ttl")
+		if err := reliability.SignalOf(event).Validate(); err != nil {
+			log.Error().Msgf("Invalid report: %s", err)
+			os.Exit(1)
+		}
+		if err := dispatcher.Send(socket, event); err != nil {
+			log.Error().Msgf("Error contacting the running instance: %s", err)
+			os.Exit(1)
+		}
+	},
+}
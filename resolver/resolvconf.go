@@ -0,0 +1,124 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package resolver switches the DNS servers of the host between links, in
+// /etc/resolv.conf or through systemd-resolved on the system bus.
+package resolver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BackupSuffix is appended to the path of resolv.conf to name the copy of
+// its content before the failover, restored on failback or by the cleanup.
+const BackupSuffix = ".if-reliability"
+
+// Render returns content with its nameserver lines replaced by servers, in
+// place of the first one, or at the end if there was none. The search,
+// options and comment lines are kept.
+func Render(content string, servers []string) string {
+	var lines []string
+	replaced := false
+	add := func() {
+		for _, server := range servers {
+			lines = append(lines, "nameserver "+server)
+		}
+		replaced = true
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "nameserver" {
+			if !replaced {
+				add()
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !replaced {
+		add()
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Nameservers returns the servers of the nameserver lines of content.
+func Nameservers(content string) []string {
+	var servers []string
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// Check returns an error if path cannot be rewritten in place: resolv.conf
+// being a symbolic link means another daemon, usually systemd-resolved,
+// manages it.
+func Check(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(path)
+		return fmt.Errorf("%s is a link to %s, managed by another daemon", path, target)
+	}
+	return nil
+}
+
+// Switch saves the content of path, unless a previous run left a saved copy,
+// and replaces its nameservers with servers.
+func Switch(path string, servers []string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path + BackupSuffix); errors.Is(err, os.ErrNotExist) {
+		if err := writeFile(path+BackupSuffix, content); err != nil {
+			return fmt.Errorf("saving %s: %w", path, err)
+		}
+	}
+	return writeFile(path, []byte(Render(string(content), servers)))
+}
+
+// Restore puts back the content of path saved by Switch and reports whether
+// there was one.
+func Restore(path string) (bool, error) {
+	if _, err := os.Stat(path + BackupSuffix); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err := os.Rename(path+BackupSuffix, path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeFile replaces the content of path at once, so that resolvers never
+// read a partial file.
+func writeFile(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
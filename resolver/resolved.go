@@ -0,0 +1,64 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package resolver
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+// BusName is the well-known name of systemd-resolved on the system bus.
+const BusName = "org.freedesktop.resolve1"
+
+// D-Bus object path and interfaces of systemd-resolved.
+const (
+	objectPath       = "/org/freedesktop/resolve1"
+	managerInterface = BusName + ".Manager"
+	linkInterface    = BusName + ".Link"
+)
+
+// Resolved performs systemd-resolved operations over the system bus.
+type Resolved struct {
+	conn *dbus.Conn
+}
+
+// DialResolved connects to systemd-resolved on the system bus.
+func DialResolved() (*Resolved, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	return &Resolved{conn: conn}, nil
+}
+
+// Close closes the connection to the bus.
+func (r *Resolved) Close() error {
+	return r.conn.Close()
+}
+
+// DefaultRoute reports whether the DNS servers of the link with index
+// ifindex are used for the domains no link claims.
+func (r *Resolved) DefaultRoute(ifindex int) (bool, error) {
+	var link dbus.ObjectPath
+	if err := r.conn.Object(BusName, objectPath).Call(managerInterface+".GetLink", 0, int32(ifindex)).Store(&link); err != nil {
+		return false, err
+	}
+	value, err := r.conn.Object(BusName, link).GetProperty(linkInterface + ".DefaultRoute")
+	if err != nil {
+		return false, err
+	}
+	enabled, _ := value.Value().(bool)
+	return enabled, nil
+}
+
+// SetDefaultRoute sets whether the DNS servers of the link with index
+// ifindex are used for the domains no link claims.
+func (r *Resolved) SetDefaultRoute(ifindex int, enabled bool) error {
+	return r.conn.Object(BusName, objectPath).Call(managerInterface+".SetLinkDefaultRoute", 0, int32(ifindex), enabled).Err
+}
+
+// FlushCaches drops the cached answers, some of which may come from servers
+// no longer used.
+func (r *Resolved) FlushCaches() error {
+	return r.conn.Object(BusName, objectPath).Call(managerInterface+".FlushCaches", 0).Err
+}
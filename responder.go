@@ -0,0 +1,48 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/echo"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// init registers the responder command.
+func init() {
+	responderCmd.Flags().String("udp", ":7777", "UDP listen address (disabled if empty)")
+	responderCmd.Flags().String("tcp", ":7777", "TCP listen address (disabled if empty)")
+	responderCmd.Flags().String("http", "", "HTTP listen address (disabled if empty)")
+	responderCmd.Flags().String("key-file", "", "File holding the shared key authenticating probes (authentication disabled if empty)")
+	responderCmd.Flags().Duration("replay-window", 30*time.Second, "Maximum clock difference accepted on authenticated probes")
+	rootCmd.AddCommand(responderCmd)
+}
+
+var responderCmd = &cobra.Command{
+	Use:     "responder",
+	Aliases: []string{"reflector"},
+	Short:   "Run the far-end probe responder",
+	Long:    "Run a lightweight echo responder answering UDP, TCP and HTTP probes with timestamps. Deploy it on a server you control and probe it with udp://host:port endpoints, or stream echo requests to it with --echo-reflector.",
+	Run: func(cmd *cobra.Command, args []string) {
+		udp, _ := cmd.Flags().GetString("udp")
+		tcp, _ := cmd.Flags().GetString("tcp")
+		http, _ := cmd.Flags().GetString("http")
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		window, _ := cmd.Flags().GetDuration("replay-window")
+		key, err := reliability.ReadKey(keyFile)
+		if err != nil {
+			log.Error().Msgf("Error reading key: %s", err)
+			os.Exit(1)
+		}
+		responder := &echo.Responder{UDP: udp, TCP: tcp, HTTP: http, Key: key, Window: window}
+		if err := responder.Run(); err != nil {
+			log.Error().Msgf("Responder stopped: %s", err)
+			os.Exit(1)
+		}
+	},
+}
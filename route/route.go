@@ -0,0 +1,73 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package route reads and changes the routing table over rtnetlink instead
+// of running ip(8) and parsing its output. Calls operate in the network
+// namespace of the calling thread.
+package route
+
+import "time"
+
+// Address families.
+const (
+	IPv4 = 4
+	IPv6 = 6
+)
+
+// Route is a route toward a network through a gateway.
+type Route struct {
+	// Dst is the destination network in CIDR notation.
+	Dst string
+	// Gateway is the IP address of the next hop.
+	Gateway string
+	// Device is the outgoing interface.
+	Device string
+	// VRF is the VRF device whose table holds the route, empty for the
+	// main table.
+	VRF string
+	// Table is the routing table holding the route when not 0, overriding
+	// VRF.
+	Table int
+	// Protocol and Realm tag the route, untagged if 0.
+	Protocol int
+	Realm    int
+	// Metric is the priority of the route, lower first.
+	Metric int
+	// RTOMin, QuickAck and InitCwnd are optional route attributes, the
+	// kernel defaults if zero.
+	RTOMin   time.Duration
+	QuickAck bool
+	InitCwnd int
+}
+
+// Change is a route added, replaced or removed in a table, reported by Watch.
+type Change struct {
+	// Route is the route, with a Gateway and a Device unless it is a
+	// multipath route over Hops.
+	Route   Route
+	Hops    []Nexthop
+	Deleted bool
+}
+
+// Nexthop is one of the paths of a multipath route.
+type Nexthop struct {
+	Gateway string
+	Device  string
+	// Weight is the share of the flows taking the path, from 1 to 256.
+	Weight int
+}
+
+// Rule is a policy routing rule looking up a table.
+type Rule struct {
+	// Family is IPv4 or IPv6.
+	Family   int
+	Table    int
+	Priority int
+	// Src restricts the rule to a source network in CIDR notation, all
+	// sources if empty.
+	Src string
+	// Mark and Mask restrict the rule to traffic with a firewall mark, any
+	// traffic if Mark is 0. A Mask of 0 compares every bit.
+	Mark uint32
+	Mask uint32
+}
@@ -0,0 +1,409 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Replace atomically installs r, replacing any route toward the same
+// destination in its table.
+func Replace(r Route) error {
+	_, dst, err := net.ParseCIDR(r.Dst)
+	if err != nil {
+		return err
+	}
+	gw := net.ParseIP(r.Gateway)
+	if gw == nil {
+		return fmt.Errorf("invalid gateway %q", r.Gateway)
+	}
+	link, err := netlink.LinkByName(r.Device)
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", r.Device, err)
+	}
+	table, err := tableOf(r.VRF, r.Table)
+	if err != nil {
+		return err
+	}
+	nlr := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       dst,
+		Gw:        gw,
+		Table:     table,
+		Protocol:  netlink.RouteProtocol(r.Protocol),
+		Realm:     r.Realm,
+		Priority:  r.Metric,
+		RtoMin:    int(r.RTOMin.Milliseconds()),
+		InitCwnd:  r.InitCwnd,
+	}
+	if r.QuickAck {
+		nlr.QuickACK = 1
+	}
+	return netlink.RouteReplace(nlr)
+}
+
+// ReplaceMultipath atomically installs r as a multipath route over hops,
+// in place of its gateway and device, replacing any route toward the same
+// destination with the same metric in its table. Flows are spread over the
+// hops in proportion to their weights.
+func ReplaceMultipath(r Route, hops []Nexthop) error {
+	_, dst, err := net.ParseCIDR(r.Dst)
+	if err != nil {
+		return err
+	}
+	table, err := tableOf(r.VRF, r.Table)
+	if err != nil {
+		return err
+	}
+	nlr := &netlink.Route{
+		Dst:      dst,
+		Table:    table,
+		Protocol: netlink.RouteProtocol(r.Protocol),
+		Realm:    r.Realm,
+		Priority: r.Metric,
+		RtoMin:   int(r.RTOMin.Milliseconds()),
+		InitCwnd: r.InitCwnd,
+	}
+	if r.QuickAck {
+		nlr.QuickACK = 1
+	}
+	for _, hop := range hops {
+		gw := net.ParseIP(hop.Gateway)
+		if gw == nil {
+			return fmt.Errorf("invalid gateway %q", hop.Gateway)
+		}
+		link, err := netlink.LinkByName(hop.Device)
+		if err != nil {
+			return fmt.Errorf("interface %s: %w", hop.Device, err)
+		}
+		if hop.Weight < 1 || hop.Weight > 256 {
+			return fmt.Errorf("invalid weight %d of %s, expected 1 to 256", hop.Weight, hop.Device)
+		}
+		nlr.MultiPath = append(nlr.MultiPath, &netlink.NexthopInfo{LinkIndex: link.Attrs().Index, Gw: gw, Hops: hop.Weight - 1})
+	}
+	return netlink.RouteReplace(nlr)
+}
+
+// DeleteMultipath removes the route toward dst, in CIDR notation, with the
+// given metric and protocol from table as Delete, whatever its paths, and
+// reports whether there was one.
+func DeleteMultipath(dst, vrf string, table, metric, protocol int) (bool, error) {
+	_, network, err := net.ParseCIDR(dst)
+	if err != nil {
+		return false, err
+	}
+	table, err = tableOf(vrf, table)
+	if err != nil {
+		return false, err
+	}
+	err = netlink.RouteDel(&netlink.Route{Dst: network, Table: table, Priority: metric, Protocol: netlink.RouteProtocol(protocol)})
+	if errors.Is(err, unix.ESRCH) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the route toward dst, in CIDR notation, through device from
+// table if not 0, the table of vrf otherwise, or the main table if empty.
+func Delete(dst, device, vrf string, table int) error {
+	_, network, err := net.ParseCIDR(dst)
+	if err != nil {
+		return err
+	}
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", device, err)
+	}
+	table, err = tableOf(vrf, table)
+	if err != nil {
+		return err
+	}
+	return netlink.RouteDel(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: network, Table: table})
+}
+
+// DeleteMetric removes the route toward dst, in CIDR notation, through
+// device with the given metric, from table as Delete, and reports whether
+// there was one.
+func DeleteMetric(dst, device, vrf string, table, metric int) (bool, error) {
+	_, network, err := net.ParseCIDR(dst)
+	if err != nil {
+		return false, err
+	}
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return false, fmt.Errorf("interface %s: %w", device, err)
+	}
+	table, err = tableOf(vrf, table)
+	if err != nil {
+		return false, err
+	}
+	err = netlink.RouteDel(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: network, Table: table, Priority: metric})
+	if errors.Is(err, unix.ESRCH) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Device returns the interface the route toward ip goes through, looked up
+// in vrf if not empty.
+func Device(ip, vrf string) (string, error) {
+	dst := net.ParseIP(ip)
+	if dst == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	routes, err := netlink.RouteGetWithOptions(dst, &netlink.RouteGetOptions{VrfName: vrf})
+	if err != nil {
+		return "", err
+	}
+	if len(routes) == 0 {
+		return "", fmt.Errorf("no route toward %s", ip)
+	}
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return "", err
+	}
+	return link.Attrs().Name, nil
+}
+
+// DefaultGateway returns the gateway of the default route of family (IPv4 or
+// IPv6) through device in the table of vrf, or the main table if empty, or
+// an empty string if there is none. IPv6 gateways are usually link-local
+// addresses.
+func DefaultGateway(device, vrf string, family int) (string, error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", device, err)
+	}
+	table, err := tableOf(vrf, 0)
+	if err != nil {
+		return "", err
+	}
+	filter := &netlink.Route{LinkIndex: link.Attrs().Index, Table: table}
+	routes, err := netlink.RouteListFiltered(nlFamily(family), filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range routes {
+		if r.Gw == nil {
+			continue
+		}
+		if r.Dst == nil {
+			return r.Gw.String(), nil
+		}
+		if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			return r.Gw.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// Defaults returns the default routes of family with a gateway in the table
+// of vrf, or the main table if empty.
+func Defaults(vrf string, family int) ([]Route, error) {
+	table, err := tableOf(vrf, 0)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := netlink.RouteListFiltered(nlFamily(family), &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	dst := "0.0.0.0/0"
+	if family == IPv6 {
+		dst = "::/0"
+	}
+	var defaults []Route
+	for _, r := range routes {
+		if r.Gw == nil {
+			continue
+		}
+		if r.Dst != nil {
+			if ones, _ := r.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		link, err := netlink.LinkByIndex(r.LinkIndex)
+		if err != nil {
+			return nil, err
+		}
+		defaults = append(defaults, Route{Dst: dst, Gateway: r.Gw.String(), Device: link.Attrs().Name, VRF: vrf, Protocol: int(r.Protocol), Metric: r.Priority})
+	}
+	return defaults, nil
+}
+
+// AddRule installs r.
+func AddRule(r Rule) error {
+	nlr := netlink.NewRule()
+	nlr.Family = nlFamily(r.Family)
+	nlr.Table = r.Table
+	nlr.Priority = r.Priority
+	if r.Src != "" {
+		_, src, err := net.ParseCIDR(r.Src)
+		if err != nil {
+			return err
+		}
+		nlr.Src = src
+	}
+	if r.Mark != 0 {
+		nlr.Mark = r.Mark
+		if r.Mask != 0 {
+			mask := r.Mask
+			nlr.Mask = &mask
+		}
+	}
+	return netlink.RuleAdd(nlr)
+}
+
+// DeleteRules removes the rules of family at priority and returns how many
+// there were.
+func DeleteRules(family, priority int) (int, error) {
+	rules, err := netlink.RuleListFiltered(nlFamily(family), &netlink.Rule{Priority: priority}, netlink.RT_FILTER_PRIORITY)
+	if err != nil {
+		return 0, err
+	}
+	for i := range rules {
+		if err := netlink.RuleDel(&rules[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(rules), nil
+}
+
+// AddAddress adds the address addr, in CIDR notation, to device, keeping it
+// if already there.
+func AddAddress(device, addr string) error {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return fmt.Errorf("interface %s: %w", device, err)
+	}
+	a, err := netlink.ParseAddr(addr)
+	if err != nil {
+		return err
+	}
+	return netlink.AddrReplace(link, a)
+}
+
+// DeleteAddress removes the address addr, in CIDR notation, from device,
+// and reports whether it was there.
+func DeleteAddress(device, addr string) (bool, error) {
+	link, err := netlink.LinkByName(device)
+	if err != nil {
+		return false, fmt.Errorf("interface %s: %w", device, err)
+	}
+	a, err := netlink.ParseAddr(addr)
+	if err != nil {
+		return false, err
+	}
+	err = netlink.AddrDel(link, a)
+	if errors.Is(err, unix.EADDRNOTAVAIL) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// nlFamily returns the netlink family of IPv4 or IPv6.
+func nlFamily(family int) int {
+	if family == IPv6 {
+		return netlink.FAMILY_V6
+	}
+	return netlink.FAMILY_V4
+}
+
+// tableOf returns table if not 0, the routing table of vrf otherwise, or the
+// main table if empty.
+func tableOf(vrf string, table int) (int, error) {
+	if table != 0 {
+		return table, nil
+	}
+	if vrf == "" {
+		return unix.RT_TABLE_MAIN, nil
+	}
+	link, err := netlink.LinkByName(vrf)
+	if err != nil {
+		return 0, fmt.Errorf("VRF %s: %w", vrf, err)
+	}
+	v, ok := link.(*netlink.Vrf)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a VRF device", vrf)
+	}
+	return int(v.Table), nil
+}
+
+// Watch reports the routes added, replaced or removed in the table of vrf,
+// or table if not 0, until done is closed, or until the rtnetlink socket
+// fails, closing the channel. The interfaces are named in the network
+// namespace of the calling thread.
+func Watch(vrf string, table int, done <-chan struct{}) (<-chan Change, error) {
+	table, err := tableOf(vrf, table)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := netlink.NewHandle()
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan netlink.RouteUpdate, 64)
+	if err := netlink.RouteSubscribeWithOptions(updates, done, netlink.RouteSubscribeOptions{}); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	changes := make(chan Change, 16)
+	go func() {
+		defer close(changes)
+		defer handle.Close()
+		linkName := func(index int) string {
+			if index == 0 {
+				return ""
+			}
+			link, err := handle.LinkByIndex(index)
+			if err != nil {
+				return ""
+			}
+			return link.Attrs().Name
+		}
+		for update := range updates {
+			if update.Table != table || update.Type != unix.RTM_NEWROUTE && update.Type != unix.RTM_DELROUTE {
+				continue
+			}
+			c := Change{
+				Route:   Route{Dst: dstOf(update.Route), VRF: vrf, Table: table, Protocol: int(update.Protocol), Metric: update.Priority},
+				Deleted: update.Type == unix.RTM_DELROUTE,
+			}
+			if update.Gw != nil {
+				c.Route.Gateway = update.Gw.String()
+			}
+			c.Route.Device = linkName(update.LinkIndex)
+			for _, hop := range update.MultiPath {
+				h := Nexthop{Device: linkName(hop.LinkIndex), Weight: hop.Hops + 1}
+				if hop.Gw != nil {
+					h.Gateway = hop.Gw.String()
+				}
+				c.Hops = append(c.Hops, h)
+			}
+			select {
+			case changes <- c:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// dstOf returns the destination of r in CIDR notation, the default route
+// of its family if it has none.
+func dstOf(r netlink.Route) string {
+	if r.Dst != nil {
+		return r.Dst.String()
+	}
+	if r.Family == netlink.FAMILY_V6 {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
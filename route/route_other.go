@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package route
+
+import "errors"
+
+// errUnsupported is returned on systems without rtnetlink.
+var errUnsupported = errors.New("routing table changes are only supported on Linux")
+
+// Replace fails, rtnetlink is Linux-only.
+func Replace(r Route) error {
+	return errUnsupported
+}
+
+// ReplaceMultipath fails, rtnetlink is Linux-only.
+func ReplaceMultipath(r Route, hops []Nexthop) error {
+	return errUnsupported
+}
+
+// DeleteMultipath fails, rtnetlink is Linux-only.
+func DeleteMultipath(dst, vrf string, table, metric, protocol int) (bool, error) {
+	return false, errUnsupported
+}
+
+// Delete fails, rtnetlink is Linux-only.
+func Delete(dst, device, vrf string, table int) error {
+	return errUnsupported
+}
+
+// DeleteMetric fails, rtnetlink is Linux-only.
+func DeleteMetric(dst, device, vrf string, table, metric int) (bool, error) {
+	return false, errUnsupported
+}
+
+// Device fails, rtnetlink is Linux-only.
+func Device(ip, vrf string) (string, error) {
+	return "", errUnsupported
+}
+
+// DefaultGateway fails, rtnetlink is Linux-only.
+func DefaultGateway(device, vrf string, family int) (string, error) {
+	return "", errUnsupported
+}
+
+// Defaults fails, rtnetlink is Linux-only.
+func Defaults(vrf string, family int) ([]Route, error) {
+	return nil, errUnsupported
+}
+
+// AddRule fails, rtnetlink is Linux-only.
+func AddRule(r Rule) error {
+	return errUnsupported
+}
+
+// DeleteRules fails, rtnetlink is Linux-only.
+func DeleteRules(family, priority int) (int, error) {
+	return 0, errUnsupported
+}
+
+// AddAddress fails, rtnetlink is Linux-only.
+func AddAddress(device, addr string) error {
+	return errUnsupported
+}
+
+// DeleteAddress fails, rtnetlink is Linux-only.
+func DeleteAddress(device, addr string) (bool, error) {
+	return false, errUnsupported
+}
+
+// Watch fails, rtnetlink is Linux-only.
+func Watch(vrf string, table int, done <-chan struct{}) (<-chan Change, error) {
+	return nil, errUnsupported
+}
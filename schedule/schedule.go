@@ -0,0 +1,173 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package schedule parses calendar windows, weekly like "mon-fri
+// 08:00-18:00" or on a date like "2024-12-24 22:00-06:00", and tells whether
+// a time falls in one.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dayNames are the day names, in the order of time.Weekday.
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Window is a time range repeated on some days of the week, or on a single
+// date. A range ending before it starts runs past midnight into the next
+// day.
+type Window struct {
+	text string
+	// days are the days of the week the range starts on, if date is zero.
+	days [7]bool
+	date time.Time
+	// start and end are the times of day bounding the range, read on the
+	// wall clock.
+	start time.Duration
+	end   time.Duration
+}
+
+// Parse parses a window made of optional days followed by a time range
+// HH:MM-HH:MM. The days are a comma-separated list of day names, short or
+// full, or ranges, e.g. "mon-fri" or "saturday,sunday", or a date
+// YYYY-MM-DD; every day if omitted.
+// "24:00" ends a range at midnight.
+func Parse(s string) (Window, error) {
+	w := Window{text: strings.TrimSpace(s)}
+	fields := strings.Fields(w.text)
+	switch len(fields) {
+	case 1:
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, fmt.Errorf("invalid window %q: %w", s, err)
+		}
+	default:
+		return w, fmt.Errorf("invalid window %q: it must be [days] HH:MM-HH:MM", s)
+	}
+	from, to, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return w, fmt.Errorf("invalid window %q: the time range must be HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil || w.start == 24*time.Hour {
+		return w, fmt.Errorf("invalid window %q: invalid start %q", s, from)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, fmt.Errorf("invalid window %q: invalid end %q", s, to)
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("invalid window %q: it is empty", s)
+	}
+	return w, nil
+}
+
+// parseDays parses the days of w, a date or a list of day names or ranges.
+func (w *Window) parseDays(s string) error {
+	if date, err := time.Parse(time.DateOnly, s); err == nil {
+		w.date = date
+		return nil
+	}
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		first, last, _ := strings.Cut(part, "-")
+		if last == "" {
+			last = first
+		}
+		from, to := day(first), day(last)
+		if from < 0 || to < 0 {
+			return fmt.Errorf("unknown day in %q", part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// day returns the weekday named by name, short or full, or -1.
+func day(name string) int {
+	for i, d := range dayNames {
+		if name == d || name == strings.ToLower(time.Weekday(i).String()) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseClock parses HH:MM into the duration since midnight.
+func parseClock(s string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, err
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Contains reports whether t falls in w, in the location of t.
+func (w Window) Contains(t time.Time) bool {
+	for _, offset := range []int{0, -1} {
+		y, m, d := t.AddDate(0, 0, offset).Date()
+		midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		if !w.on(midnight) {
+			continue
+		}
+		from, to := clock(midnight, w.start), clock(midnight, w.end)
+		if w.end <= w.start {
+			to = clock(midnight.AddDate(0, 0, 1), w.end)
+		}
+		if !t.Before(from) && t.Before(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// clock returns the time of day d on the day of midnight. It counts hours on
+// the wall clock, not from midnight, which differ on the days the clocks
+// change.
+func clock(midnight time.Time, d time.Duration) time.Time {
+	y, m, day := midnight.Date()
+	return time.Date(y, m, day, int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, midnight.Location())
+}
+
+// on reports whether the range of w starts on the day of midnight.
+func (w Window) on(midnight time.Time) bool {
+	if !w.date.IsZero() {
+		y, m, d := midnight.Date()
+		wy, wm, wd := w.date.Date()
+		return y == wy && m == wm && d == wd
+	}
+	return w.days[midnight.Weekday()]
+}
+
+// String returns w as parsed.
+func (w Window) String() string {
+	return w.text
+}
+
+// Any returns the first of windows t falls in, and false if none.
+func Any(windows []Window, t time.Time) (Window, bool) {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
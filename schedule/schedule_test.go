@@ -0,0 +1,103 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package schedule_test
+
+import (
+	"testing"
+	"time"
+	// The DST dates are checked in Europe/Paris, whatever the zones of
+	// the host.
+	_ "time/tzdata"
+
+	"github.com/shynuu/if-reliability/schedule"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		window string
+		valid  bool
+	}{
+		{"08:00-18:00", true},
+		{"mon-fri 08:00-18:00", true},
+		{"sat,sun 00:00-24:00", true},
+		{"monday-friday 08:00-18:00", true},
+		{"Saturday,SUN 10:00-12:00", true},
+		{"fri-mon 22:00-06:00", true},
+		{"2024-12-24 22:00-06:00", true},
+		{"monkey 08:00-18:00", false},
+		{"mo 08:00-18:00", false},
+		{"mondays 08:00-18:00", false},
+		{"mon-xyz 08:00-18:00", false},
+		{"mon 08:00", false},
+		{"mon 24:00-06:00", false},
+		{"mon 08:00-24:30", false},
+		{"mon 08:60-09:00", false},
+		{"mon 08:00-08:00", false},
+		{"mon tue 08:00-09:00", false},
+		{"2024-13-01 08:00-09:00", false},
+	} {
+		_, err := schedule.Parse(tt.window)
+		if tt.valid && err != nil {
+			t.Errorf("Parse(%q): %s", tt.window, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", tt.window)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		tm, err := time.ParseInLocation(time.DateTime, value, paris)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, tt := range []struct {
+		window string
+		time   string
+		want   bool
+	}{
+		// 2024-06-03 is a Monday.
+		{"mon-fri 08:00-18:00", "2024-06-03 08:00:00", true},
+		{"mon-fri 08:00-18:00", "2024-06-03 17:59:59", true},
+		{"mon-fri 08:00-18:00", "2024-06-03 18:00:00", false},
+		{"mon-fri 08:00-18:00", "2024-06-03 07:59:59", false},
+		{"mon-fri 08:00-18:00", "2024-06-08 12:00:00", false},
+		{"saturday,sunday 10:00-12:00", "2024-06-09 11:00:00", true},
+		{"sun 00:00-24:00", "2024-06-09 23:59:59", true},
+		{"sun 00:00-24:00", "2024-06-10 00:00:00", false},
+		// Ranges crossing midnight belong to the day they start on.
+		{"fri 22:00-06:00", "2024-06-07 23:00:00", true},
+		{"fri 22:00-06:00", "2024-06-08 05:59:59", true},
+		{"fri 22:00-06:00", "2024-06-08 06:00:00", false},
+		{"fri 22:00-06:00", "2024-06-07 05:00:00", false},
+		{"sat-sun 22:00-06:00", "2024-06-10 01:00:00", true},
+		{"2024-12-24 22:00-06:00", "2024-12-25 02:00:00", true},
+		{"2024-12-24 22:00-06:00", "2024-12-24 02:00:00", false},
+		{"2024-12-24 22:00-06:00", "2025-12-25 02:00:00", false},
+		// The clocks go forward at 02:00 on 2024-03-31 and back at 03:00
+		// on 2024-10-27: the bounds stay on the wall clock.
+		{"sun 06:00-08:00", "2024-03-31 06:30:00", true},
+		{"sun 06:00-08:00", "2024-03-31 07:59:00", true},
+		{"sun 06:00-08:00", "2024-03-31 08:00:00", false},
+		{"sun 06:00-08:00", "2024-10-27 05:30:00", false},
+		{"sun 06:00-08:00", "2024-10-27 06:00:00", true},
+		{"sat 22:00-04:00", "2024-10-27 03:30:00", true},
+		{"sat 22:00-04:00", "2024-10-27 04:00:00", false},
+	} {
+		w, err := schedule.Parse(tt.window)
+		if err != nil {
+			t.Fatalf("Parse(%q): %s", tt.window, err)
+		}
+		if got := w.Contains(at(tt.time)); got != tt.want {
+			t.Errorf("%q contains %s: %t, want %t", tt.window, tt.time, got, tt.want)
+		}
+	}
+}
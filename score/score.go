@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package score maintains a long-term reliability score per link: the ratio
+// of successful probes, where older results weigh exponentially less. With a
+// half-life of three days, yesterday's outage still counts while last
+// month's is forgotten.
+package score
+
+import (
+	"math"
+	"time"
+)
+
+// Score is the decayed probe record of one link.
+type Score struct {
+	// Successes and Total are the decayed counts of successful and of all
+	// probes.
+	Successes float64   `json:"successes"`
+	Total     float64   `json:"total"`
+	Updated   time.Time `json:"updated"`
+}
+
+// decay ages the counts to now.
+func (s *Score) decay(now time.Time, halfLife time.Duration) {
+	if !s.Updated.IsZero() && halfLife > 0 && now.After(s.Updated) {
+		factor := math.Exp2(-float64(now.Sub(s.Updated)) / float64(halfLife))
+		s.Successes *= factor
+		s.Total *= factor
+	}
+	s.Updated = now
+}
+
+// Add records a probe result at now.
+func (s *Score) Add(success bool, now time.Time, halfLife time.Duration) {
+	s.decay(now, halfLife)
+	s.Total++
+	if success {
+		s.Successes++
+	}
+}
+
+// Ratio returns the decayed success ratio between 0 and 1, or 1 for a link
+// without history.
+func (s Score) Ratio() float64 {
+	if s.Total == 0 {
+		return 1
+	}
+	return s.Successes / s.Total
+}
+
+// Prefer compares the historical reliability of two links: it returns -1 if
+// a is more reliable than b by more than margin, 1 if b is, and 0 otherwise.
+func Prefer(a, b Score, margin float64) int {
+	switch d := a.Ratio() - b.Ratio(); {
+	case d > margin:
+		return -1
+	case d < -margin:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package severity assigns a severity to every event so that each consumer
+// (logs, syslog export, metrics, notifiers) can keep only what matters to it,
+// e.g. page on critical events while archiving everything.
+//
+// An event logged at info level or below is info, at warn level a warning,
+// and at error level or above critical, unless an explicit severity is
+// attached to the event with Context.
+package severity
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/shynuu/if-reliability/metrics"
+)
+
+// Level is the severity of an event.
+type Level int
+
+// Severities, in increasing order.
+const (
+	// Info is routine activity.
+	Info Level = iota
+	// Warning is a degradation that does not need action yet.
+	Warning
+	// Critical is a failure or a failover, worth paging someone.
+	Critical
+)
+
+// Field is the log field holding the severity.
+const Field = "severity"
+
+// String returns the name of the severity.
+func (l Level) String() string {
+	switch l {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return fmt.Sprintf("severity(%d)", int(l))
+}
+
+// Parse parses a severity name.
+func Parse(name string) (Level, error) {
+	switch name {
+	case "info":
+		return Info, nil
+	case "warning":
+		return Warning, nil
+	case "critical":
+		return Critical, nil
+	}
+	return Info, fmt.Errorf("invalid severity %q, expected info, warning or critical", name)
+}
+
+// FromLog returns the default severity of an event logged at level.
+func FromLog(level zerolog.Level) Level {
+	switch {
+	case level >= zerolog.ErrorLevel && level != zerolog.NoLevel && level != zerolog.Disabled:
+		return Critical
+	case level == zerolog.WarnLevel:
+		return Warning
+	}
+	return Info
+}
+
+type contextKey struct{}
+
+// Context returns a context attaching l to a log event, overriding the
+// severity derived from its level:
+//
+//	log.Info().Ctx(severity.Context(severity.Critical)).Msg("Switched to WiFi")
+func Context(l Level) context.Context {
+	return context.WithValue(context.Background(), contextKey{}, l)
+}
+
+// Of returns the severity of a log event logged at level.
+func Of(e *zerolog.Event, level zerolog.Level) Level {
+	if l, ok := e.GetCtx().Value(contextKey{}).(Level); ok {
+		return l
+	}
+	return FromLog(level)
+}
+
+// Hook is a zerolog.Hook adding the severity field to every log event,
+// dropping the events below Log and counting the events at or above Metrics
+// in Registry, if set.
+type Hook struct {
+	Log      Level
+	Metrics  Level
+	Registry *metrics.Registry
+}
+
+// Run implements zerolog.Hook.
+func (h Hook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	l := Of(e, level)
+	if l >= h.Metrics && h.Registry != nil {
+		h.Registry.CountEvent(l.String())
+	}
+	if l < h.Log {
+		e.Discard()
+		return
+	}
+	e.Str(Field, l.String())
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/spf13/cobra"
+)
+
+// simulateOptions are the simulated links of the simulate command, bound to
+// its flags.
+var simulateOptions reliability.SimulateOptions
+
+// init registers the simulate command, which shares the flags of the root
+// command.
+func init() {
+	simulateCmd.Flags().AddFlagSet(rootCmd.Flags())
+	reliability.AddSimulateFlags(simulateCmd.Flags(), &simulateOptions)
+	rootCmd.AddCommand(simulateCmd)
+}
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Rehearse failovers against simulated links",
+	Long: "Monitor like the root command, with the same flags, against simulated links: the probes answer unless a " +
+		"--fail or --fail-carrier failure of their interface is under way, and the routes, NetworkManager and the " +
+		"other programs but the hooks are only simulated, so that the failover timing and the hooks can be rehearsed on any machine.",
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.Flags()
+		// Keep the simulation away from the files of a real instance.
+		dir := filepath.Join(os.TempDir(), "if-reliability-simulate")
+		defaults := map[string]string{
+			"state-file":     "",
+			"lock-dir":       dir,
+			"control-socket": filepath.Join(dir, "control.sock"),
+			"trigger-socket": filepath.Join(dir, "trigger.sock"),
+			"wifi-password":  "",
+		}
+		for name, value := range defaults {
+			if !flags.Changed(name) {
+				flags.Set(name, value)
+			}
+		}
+		config, err := commandConfig(flags)
+		if err == nil {
+			err = reliability.Simulate(interruptContext(), config, simulateOptions)
+		}
+		if err != nil {
+			log.Error().Msgf("Cannot simulate: %s", err)
+			os.Exit(1)
+		}
+	},
+}
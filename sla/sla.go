@@ -0,0 +1,198 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package sla judges link quality against latency, jitter and loss targets
+// over a sliding window of probes, so that a degraded link can be failed
+// over before it fails entirely.
+package sla
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Thresholds are the quality targets. Zero values disable a target.
+type Thresholds struct {
+	// MaxRTT is the highest mean RTT accepted.
+	MaxRTT time.Duration
+	// MaxJitter is the highest mean variation between consecutive RTTs
+	// accepted.
+	MaxJitter time.Duration
+	// MaxLoss is the highest ratio of failed probes accepted, between 0
+	// and 1.
+	MaxLoss float64
+}
+
+// Enabled reports whether any target is set.
+func (t Thresholds) Enabled() bool {
+	return t.MaxRTT > 0 || t.MaxJitter > 0 || t.MaxLoss > 0
+}
+
+// Stats are the quality statistics of a window.
+type Stats struct {
+	Samples int
+	// RTT is the mean RTT and Jitter the mean absolute difference between
+	// consecutive RTTs, of the successful probes.
+	RTT    time.Duration
+	Jitter time.Duration
+	// Loss is the ratio of failed probes.
+	Loss float64
+}
+
+// String renders the statistics, e.g. "RTT 42ms, jitter 5ms, loss 3.3%".
+func (s Stats) String() string {
+	return fmt.Sprintf("RTT %s, jitter %s, loss %.1f%%", s.RTT.Round(time.Microsecond), s.Jitter.Round(time.Microsecond), s.Loss*100)
+}
+
+// Violations returns the targets s misses, e.g. "loss 12.0% above 5.0%", or
+// nil if it meets all of them.
+func (t Thresholds) Violations(s Stats) []string {
+	var violations []string
+	if t.MaxRTT > 0 && s.RTT > t.MaxRTT {
+		violations = append(violations, fmt.Sprintf("RTT %s above %s", s.RTT.Round(time.Microsecond), t.MaxRTT))
+	}
+	if t.MaxJitter > 0 && s.Jitter > t.MaxJitter {
+		violations = append(violations, fmt.Sprintf("jitter %s above %s", s.Jitter.Round(time.Microsecond), t.MaxJitter))
+	}
+	if t.MaxLoss > 0 && s.Loss > t.MaxLoss {
+		violations = append(violations, fmt.Sprintf("loss %.1f%% above %.1f%%", s.Loss*100, t.MaxLoss*100))
+	}
+	return violations
+}
+
+// sample is one probe of a window.
+type sample struct {
+	rtt time.Duration
+	ok  bool
+}
+
+// window holds the last probes toward one endpoint over one link.
+type window struct {
+	samples []sample
+	next    int
+	full    bool
+}
+
+// add records a probe, replacing the oldest one once the window is full.
+func (w *window) add(s sample) {
+	w.samples[w.next] = s
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// ordered returns the samples from the oldest to the newest.
+func (w *window) ordered() []sample {
+	if !w.full {
+		return w.samples[:w.next]
+	}
+	return append(append([]sample{}, w.samples[w.next:]...), w.samples[:w.next]...)
+}
+
+// stats computes the statistics of the window.
+func (w *window) stats() Stats {
+	samples := w.ordered()
+	s := Stats{Samples: len(samples)}
+	var total, variation time.Duration
+	var ok, pairs int
+	var previous *sample
+	for i := range samples {
+		if !samples[i].ok {
+			previous = nil
+			continue
+		}
+		ok++
+		total += samples[i].rtt
+		if previous != nil {
+			variation += (samples[i].rtt - previous.rtt).Abs()
+			pairs++
+		}
+		previous = &samples[i]
+	}
+	if ok > 0 {
+		s.RTT = total / time.Duration(ok)
+	}
+	if pairs > 0 {
+		s.Jitter = variation / time.Duration(pairs)
+	}
+	if len(samples) > 0 {
+		s.Loss = float64(len(samples)-ok) / float64(len(samples))
+	}
+	return s
+}
+
+// Monitor keeps a sliding window per link and endpoint and judges them
+// against the thresholds.
+type Monitor struct {
+	Thresholds Thresholds
+	// Size is the number of probes in a window. Windows are judged only
+	// once full.
+	Size int
+
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+// key identifies the window of endpoint over link.
+func key(link, endpoint string) string {
+	return link + "|" + endpoint
+}
+
+// Add records a probe toward endpoint over link.
+func (m *Monitor) Add(link, endpoint string, rtt time.Duration, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.Thresholds.Enabled() || m.Size <= 0 {
+		return
+	}
+	if m.windows == nil {
+		m.windows = map[string]*window{}
+	}
+	w, found := m.windows[key(link, endpoint)]
+	if !found {
+		w = &window{samples: make([]sample, m.Size)}
+		m.windows[key(link, endpoint)] = w
+	}
+	w.add(sample{rtt: rtt, ok: ok})
+}
+
+// Check returns the statistics of endpoint over link and the targets they
+// miss. Nothing is missed until the window is full.
+func (m *Monitor) Check(link, endpoint string) (Stats, []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, found := m.windows[key(link, endpoint)]
+	if !found {
+		return Stats{}, nil
+	}
+	s := w.stats()
+	if !w.full {
+		return s, nil
+	}
+	return s, m.Thresholds.Violations(s)
+}
+
+// Configure replaces the thresholds and the size of the windows, forgetting
+// the probes if the size changed.
+func (m *Monitor) Configure(t Thresholds, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size != m.Size {
+		m.windows = nil
+	}
+	m.Thresholds, m.Size = t, size
+}
+
+// Reset forgets the probes over link, e.g. after switching away from it.
+func (m *Monitor) Reset(link string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.windows {
+		if strings.HasPrefix(k, link+"|") {
+			delete(m.windows, k)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package state persists the long-lived state of the tool across restarts,
+// in a single JSON document replaced atomically on each update.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/persist"
+	"github.com/shynuu/if-reliability/score"
+	"github.com/shynuu/if-reliability/usage"
+)
+
+// Verification sources.
+const (
+	// SourceDrill is a failover drill.
+	SourceDrill = "drill"
+	// SourceFailover is a real failover.
+	SourceFailover = "failover"
+	// SourceStandby is a probe of the warm standby link.
+	SourceStandby = "standby"
+)
+
+// Verification records when a backup path was last proven to work.
+type Verification struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+}
+
+// State is the persisted state.
+type State struct {
+	// BackupVerified holds the last verification of each backup interface.
+	BackupVerified map[string]Verification `json:"backup_verified,omitempty"`
+	// Reliability holds the long-term reliability score of each link.
+	Reliability map[string]score.Score `json:"reliability,omitempty"`
+	// Usage holds the traffic of each link over the current billing
+	// period.
+	Usage map[string]usage.Period `json:"usage,omitempty"`
+}
+
+// Store holds the state and its file.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	sync  bool
+	dirty bool
+	state State
+}
+
+// Open loads the state from path, starting empty if the file does not
+// exist. Updates are synced to stable storage when sync is set.
+func Open(path string, sync bool) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{path: path, sync: sync}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns a copy of the current state.
+func (s *Store) Get() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return State{
+		BackupVerified: maps.Clone(s.state.BackupVerified),
+		Reliability:    maps.Clone(s.state.Reliability),
+		Usage:          maps.Clone(s.state.Usage),
+	}
+}
+
+// Update applies fn to the state and writes it out.
+func (s *Store) Update(fn func(*State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+	return s.write()
+}
+
+// Modify applies fn to the state in memory only, for frequent updates. The
+// state is written at the next flush.
+func (s *Store) Modify(fn func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+	s.dirty = true
+}
+
+// Flush writes the state out if it was modified in memory.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.write()
+}
+
+// FlushEvery flushes the state at each interval, forever.
+func (s *Store) FlushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.Flush(); err != nil {
+			log.Error().Msgf("Error saving state: %s", err)
+		}
+	}
+}
+
+func (s *Store) write() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := persist.WriteFile(s.path, data, s.sync); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/availability"
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/shynuu/if-reliability/usage"
+	"github.com/spf13/cobra"
+)
+
+// init registers the status, failover, restore and inject-failure commands,
+// clients of the control API of the running instance.
+func init() {
+	for _, cmd := range []*cobra.Command{statusCmd, failoverCmd, restoreCmd, injectFailureCmd} {
+		cmd.Flags().String("socket", reliability.DefaultControlSocket, "Control socket of the running instance")
+		cmd.Flags().String("address", "", "Loopback address (host:port) of the control API, used instead of the socket if set")
+		cmd.Flags().String("token-file", "", "File holding the bearer token of the control API on --address")
+		cmd.Flags().String("group", "", "Group of the running instance, whose control socket is derived from --socket")
+		cmd.Flags().Duration("timeout", 2*reliability.CommandTimeout, "Time to wait for the running instance to answer")
+		cmd.Flags().Bool("json", false, "Print the reply as JSON")
+		rootCmd.AddCommand(cmd)
+	}
+	statusCmd.Flags().Int("probes", 0, "Also show the last N probe results")
+	statusCmd.Flags().Bool("availability", false, "Also show the availability of the links over the periods under way")
+	statusCmd.Flags().String("timezone", "Local", "Time zone used to display timestamps")
+	injectFailureCmd.Flags().Duration("for", 30*time.Second, "How long the probes fail")
+	injectFailureCmd.Flags().Bool("carrier", false, "Also report a loss of carrier of the interface, failing over at once")
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the running instance",
+	Run: func(cmd *cobra.Command, args []string) {
+		client := controlClient(cmd)
+		raw, _ := cmd.Flags().GetBool("json")
+		n, _ := cmd.Flags().GetInt("probes")
+		timezone, _ := cmd.Flags().GetString("timezone")
+		if err := timefmt.SetLocation(timezone); err != nil {
+			log.Error().Msgf("Invalid time zone %s: %s", timezone, err)
+			os.Exit(1)
+		}
+		status, err := client.Status()
+		if err != nil {
+			log.Error().Msgf("Error contacting the running instance: %s", err)
+			os.Exit(1)
+		}
+		withAvailability, _ := cmd.Flags().GetBool("availability")
+		if raw && n == 0 && !withAvailability {
+			printJSON(status)
+			return
+		}
+		if !raw {
+			printStatus(status)
+		}
+		reply := map[string]interface{}{"status": status}
+		if n > 0 {
+			samples, err := client.Samples(n)
+			if err != nil {
+				log.Error().Msgf("Error reading the probe results: %s", err)
+				os.Exit(1)
+			}
+			reply["probes"] = samples
+			if !raw {
+				printSamples(samples)
+			}
+		}
+		if withAvailability {
+			reports, err := client.Availability()
+			if err != nil {
+				log.Error().Msgf("Error reading the availability reports: %s", err)
+				os.Exit(1)
+			}
+			reply["availability"] = reports
+			if !raw {
+				printAvailability(reports)
+			}
+		}
+		if raw {
+			printJSON(reply)
+		}
+	},
+}
+
+var failoverCmd = &cobra.Command{
+	Use:   "failover",
+	Short: "Fail over to WiFi now",
+	Long:  "Ask the running instance to fail over to WiFi now, in the monitoring-primary state.",
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCommand(cmd, control.CommandFailover)
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Fail back to the primary link now",
+	Long:  "Ask the running instance to fail back to the primary link now, while on WiFi.",
+	Run: func(cmd *cobra.Command, args []string) {
+		sendCommand(cmd, control.CommandFailback)
+	},
+}
+
+// controlClient returns the client of the control API the flags of cmd
+// point to.
+func controlClient(cmd *cobra.Command) *control.Client {
+	socket, _ := cmd.Flags().GetString("socket")
+	address, _ := cmd.Flags().GetString("address")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if group, _ := cmd.Flags().GetString("group"); group != "" {
+		socket = reliability.GroupFile(socket, group)
+	}
+	tokenFile, _ := cmd.Flags().GetString("token-file")
+	token, err := reliability.ReadKey(tokenFile)
+	if err != nil {
+		log.Error().Msgf("Error reading the control token: %s", err)
+		os.Exit(1)
+	}
+	return control.NewClient(socket, address, string(token), timeout)
+}
+
+// sendCommand runs command on the running instance and prints the state it
+// left it in. It exits with status 2 if the command does not apply in the
+// current state.
+func sendCommand(cmd *cobra.Command, command string) {
+	status, err := controlClient(cmd).Command(command)
+	if errors.Is(err, control.ErrRejected) {
+		log.Error().Msgf("The running instance rejected %s: %s", command, err)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Error().Msgf("Error contacting the running instance: %s", err)
+		os.Exit(1)
+	}
+	if raw, _ := cmd.Flags().GetBool("json"); raw {
+		printJSON(status)
+		return
+	}
+	printStatus(status)
+}
+
+// printStatus prints the state of the instance.
+func printStatus(s control.Status) {
+	fmt.Printf("State:       %s since %s (%s)\n", s.State, timefmt.Format(s.Since), time.Since(s.Since).Round(time.Second))
+	fmt.Printf("Active link: %s\n", s.ActiveLink)
+	if s.Paused {
+		fmt.Println("Automatic switching is paused")
+	}
+	if s.Maintenance != "" {
+		fmt.Printf("Maintenance: %s, automatic switching is suspended\n", s.Maintenance)
+	}
+	if s.PreferredLink != "" {
+		fmt.Printf("Preferred:   %s by the schedule\n", s.PreferredLink)
+	}
+	if s.Pair != nil {
+		fmt.Printf("Pair:        %s, %s\n", reliability.PairRole(s.Pair.Master), reliability.DescribePeer(*s.Pair))
+	}
+	for _, f := range s.Faults {
+		fmt.Printf("Injected:    failure of %s until %s\n", f.Interface, timefmt.Format(f.Until))
+	}
+	for _, u := range s.Usage {
+		fmt.Printf("Usage:       %-10s %s received, %s sent since %s", u.Interface, usage.FormatSize(u.RxBytes), usage.FormatSize(u.TxBytes), timefmt.Format(u.Since))
+		if u.CapBytes > 0 {
+			fmt.Printf(", %s of its %s data cap", usage.FormatSize(u.RxBytes+u.TxBytes), usage.FormatSize(u.CapBytes))
+		}
+		if u.Capped {
+			fmt.Print(" (capped)")
+		}
+		fmt.Println()
+	}
+}
+
+// printSamples prints probe results, one per line.
+func printSamples(samples []history.Sample) {
+	for _, s := range samples {
+		if s.Success {
+			fmt.Printf("%s  %-10s %s  %s\n", timefmt.Format(s.Time), reliability.LinkKey(s.Interface), s.Endpoint, s.RTT.Round(10*time.Microsecond))
+		} else {
+			fmt.Printf("%s  %-10s %s  failed\n", timefmt.Format(s.Time), reliability.LinkKey(s.Interface), s.Endpoint)
+		}
+	}
+}
+
+// printAvailability prints the availability reports, a line per link and
+// per failover window.
+func printAvailability(reports []availability.Report) {
+	if len(reports) == 0 {
+		fmt.Println("Availability is not tracked (--availability-periods)")
+	}
+	for _, r := range reports {
+		fmt.Printf("Availability over the %s since %s:\n", r.Period, timefmt.Format(r.Start))
+		for _, l := range r.Links {
+			fmt.Printf("  %-10s %7.3f%% up  %d probes, %.1f%% loss  RTT mean %.1fms p50 %.1fms p95 %.1fms p99 %.1fms\n",
+				l.Interface, l.Uptime, l.Probes, l.Loss, l.RTTMean, l.RTTP50, l.RTTP95, l.RTTP99)
+		}
+		fmt.Printf("  %d failovers, %s on backup\n", r.Failovers, (time.Duration(r.FailoverSeconds) * time.Second).String())
+		for _, w := range r.Windows {
+			end := "ongoing"
+			if w.End != nil {
+				end = "until " + timefmt.Format(*w.End)
+			}
+			fmt.Printf("    %s: %s -> %s, %s (%s)\n", timefmt.Format(w.Start), w.From, w.To, end, (time.Duration(w.Seconds) * time.Second).String())
+		}
+	}
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Error().Msgf("Error encoding the reply: %s", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !windows && !plan9
+
+// Package syslogexport ships probe samples to a remote syslog server. To
+// spare metered links, only one sample out of N is sent while the link is
+// healthy, and one out of M (usually every sample) while it is degraded.
+// Healthy samples are info events and degraded samples warnings, samples
+// below the configured minimum severity are not sent.
+package syslogexport
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+	"sync"
+
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/severity"
+)
+
+// Exporter sends sampled probe results to syslog.
+type Exporter struct {
+	mu       sync.Mutex
+	writer   *syslog.Writer
+	healthy  int
+	degraded int
+	min      severity.Level
+	seen     uint64
+}
+
+// Dial connects to the syslog server at address over network ("udp" or
+// "tcp"). One sample out of healthy is sent while the link is healthy and
+// one out of degraded while it is degraded, if their severity is at least min.
+func Dial(network string, address string, healthy int, degraded int, min severity.Level) (*Exporter, error) {
+	if healthy < 1 || degraded < 1 {
+		return nil, fmt.Errorf("invalid sampling ratio 1/%d healthy, 1/%d degraded", healthy, degraded)
+	}
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "if-reliability")
+	if err != nil {
+		return nil, err
+	}
+	return &Exporter{writer: writer, healthy: healthy, degraded: degraded, min: min}, nil
+}
+
+// Export sends s if it is selected by the sampling ratio. A failed sample or
+// a sample taken during an outage is considered degraded.
+func (e *Exporter) Export(s history.Sample) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	degraded := !s.Success || s.OutageID != ""
+	ratio, level := e.healthy, severity.Info
+	if degraded {
+		ratio, level = e.degraded, severity.Warning
+	}
+	if level < e.min {
+		return nil
+	}
+	e.seen++
+	if e.seen%uint64(ratio) != 0 {
+		return nil
+	}
+	line := format(s, ratio)
+	if degraded {
+		return e.writer.Warning(line)
+	}
+	return e.writer.Info(line)
+}
+
+// Close closes the connection.
+func (e *Exporter) Close() error {
+	return e.writer.Close()
+}
+
+// format renders a sample as key=value pairs.
+func format(s history.Sample, ratio int) string {
+	fields := []string{
+		"endpoint=" + s.Endpoint,
+		fmt.Sprintf("success=%t", s.Success),
+	}
+	if s.Interface != "" {
+		fields = append(fields, "interface="+s.Interface)
+	}
+	if s.Success {
+		fields = append(fields, fmt.Sprintf("rtt_ms=%.3f", float64(s.RTT.Microseconds())/1000))
+	}
+	if s.OutageID != "" {
+		fields = append(fields, "outage_id="+s.OutageID)
+	}
+	if s.Hops > 0 {
+		fields = append(fields, fmt.Sprintf("hops=%d", s.Hops))
+	}
+	if s.PathChange {
+		fields = append(fields, "path_change=true")
+	}
+	fields = append(fields, fmt.Sprintf("sampling=1/%d", ratio))
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build windows || plan9
+
+// Package syslogexport ships probe samples to a remote syslog server, which
+// log/syslog does not support on this platform.
+package syslogexport
+
+import (
+	"errors"
+
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/severity"
+)
+
+// Exporter sends sampled probe results to syslog.
+type Exporter struct{}
+
+// Dial fails, syslog being unavailable on this platform.
+func Dial(network string, address string, healthy int, degraded int, min severity.Level) (*Exporter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Export does nothing.
+func (e *Exporter) Export(s history.Sample) error {
+	return nil
+}
+
+// Close does nothing.
+func (e *Exporter) Close() error {
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package throttle keeps a message repeated every probe round during a
+// sustained outage from flooding the log: the first occurrences go through,
+// then one per interval summarizing the ones held back.
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter tracks the streaks of repeated messages, by key. Its zero value
+// lets every message through.
+type Limiter struct {
+	// Burst is the number of occurrences of a streak let through before
+	// limiting it.
+	Burst int
+	// Interval is the least time between two occurrences let through once
+	// the burst is over, every occurrence going through if 0.
+	Interval time.Duration
+
+	mu      sync.Mutex
+	streaks map[string]*Streak
+}
+
+// Streak is a run of occurrences of a message with nothing ending it.
+type Streak struct {
+	// Since is the time of the first occurrence.
+	Since time.Time
+	// Count is the number of occurrences, the last one included.
+	Count int
+	// Suppressed is the number of occurrences held back since the last one
+	// let through.
+	Suppressed int
+	// Total is the number of occurrences held back in the whole streak.
+	Total int
+
+	last time.Time
+}
+
+// Allow records an occurrence of the message key at now and reports whether
+// it should be logged, with the streak so far. A held-back occurrence does
+// not reset the Suppressed count of the streak; one let through does, after
+// it is returned.
+func (l *Limiter) Allow(key string, now time.Time) (Streak, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streaks == nil {
+		l.streaks = map[string]*Streak{}
+	}
+	s, ok := l.streaks[key]
+	if !ok {
+		s = &Streak{Since: now}
+		l.streaks[key] = s
+	}
+	s.Count++
+	if l.Interval > 0 && s.Count > l.Burst && now.Sub(s.last) < l.Interval {
+		s.Suppressed++
+		s.Total++
+		return *s, false
+	}
+	s.last = now
+	out := *s
+	s.Suppressed = 0
+	return out, true
+}
+
+// End ends the streak of the message key and returns it, if there was one.
+func (l *Limiter) End(key string) (Streak, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.streaks[key]
+	if !ok {
+		return Streak{}, false
+	}
+	delete(l.streaks, key)
+	return *s, true
+}
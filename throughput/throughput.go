@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package throughput measures the rate a link downloads at, with an HTTP
+// download or an iperf3 client, to tell whether it can carry the traffic and
+// not only answer probes.
+package throughput
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Measurement is the outcome of a test.
+type Measurement struct {
+	// Bytes is the amount of data received.
+	Bytes int64
+	// Duration is the time the data took to arrive.
+	Duration time.Duration
+}
+
+// Mbps returns the throughput in Mbit/s.
+func (m Measurement) Mbps() float64 {
+	if m.Duration <= 0 {
+		return 0
+	}
+	return float64(m.Bytes) * 8 / m.Duration.Seconds() / 1e6
+}
+
+// String describes the measurement, e.g. "12.3 Mbit/s (7.7 MB in 5s)".
+func (m Measurement) String() string {
+	return fmt.Sprintf("%.1f Mbit/s (%.1f MB in %s)", m.Mbps(), float64(m.Bytes)/1e6, m.Duration.Round(time.Millisecond))
+}
+
+// Download fetches url with client until the body ends or duration elapsed,
+// whichever comes first. The rate is measured from the response headers, so
+// that the connection setup does not count.
+func Download(client *http.Client, url string, duration time.Duration) (Measurement, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Measurement{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Measurement{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Measurement{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	start := time.Now()
+	n, err := io.Copy(io.Discard, resp.Body)
+	m := Measurement{Bytes: n, Duration: time.Since(start)}
+	if err != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return m, err
+	}
+	if n == 0 {
+		return m, fmt.Errorf("empty body")
+	}
+	return m, nil
+}
+
+// Iperf3Args returns the arguments of an iperf3 client receiving from server
+// through ifname for duration, with its report in JSON.
+func Iperf3Args(server string, port int, ifname string, duration time.Duration) []string {
+	seconds := max(int(duration.Round(time.Second)/time.Second), 1)
+	args := []string{"--client", server, "--reverse", "--json", "--time", fmt.Sprint(seconds)}
+	if port != 0 {
+		args = append(args, "--port", fmt.Sprint(port))
+	}
+	if ifname != "" {
+		args = append(args, "--bind-dev", ifname)
+	}
+	return args
+}
+
+// ParseIperf3 returns the data received in the JSON report of an iperf3
+// client.
+func ParseIperf3(output []byte) (Measurement, error) {
+	var report struct {
+		Error string `json:"error"`
+		End   struct {
+			SumReceived struct {
+				Seconds float64 `json:"seconds"`
+				Bytes   int64   `json:"bytes"`
+			} `json:"sum_received"`
+		} `json:"end"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return Measurement{}, fmt.Errorf("invalid iperf3 report: %w", err)
+	}
+	if report.Error != "" {
+		return Measurement{}, errors.New(report.Error)
+	}
+	sum := report.End.SumReceived
+	if sum.Seconds <= 0 {
+		return Measurement{}, fmt.Errorf("iperf3 report without received data")
+	}
+	return Measurement{Bytes: sum.Bytes, Duration: time.Duration(sum.Seconds * float64(time.Second))}, nil
+}
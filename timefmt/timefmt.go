@@ -0,0 +1,43 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package timefmt renders timestamps for humans. Timestamps are always
+// stored in UTC; they are only converted to the configured display time zone
+// when shown, and the UTC offset is always printed alongside.
+package timefmt
+
+import (
+	"time"
+)
+
+// Layout is the layout used for displayed timestamps.
+const Layout = "2006-01-02 15:04:05 MST (UTC-07:00)"
+
+// location is the display time zone.
+var location = time.Local
+
+// SetLocation loads the named IANA time zone ("UTC", "Local",
+// "Europe/Luxembourg", ...) and uses it for displayed timestamps.
+func SetLocation(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	location = loc
+	return nil
+}
+
+// Location returns the display time zone.
+func Location() *time.Location {
+	return location
+}
+
+// Format renders t in the display time zone.
+func Format(t time.Time) string {
+	return FormatIn(t, location)
+}
+
+// FormatIn renders t in the time zone loc.
+func FormatIn(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(Layout)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/live"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/shynuu/if-reliability/tui"
+	"github.com/spf13/cobra"
+)
+
+// tuiResults is the number of probe results kept per link by the dashboard,
+// the longest sparkline.
+const tuiResults = 200
+
+// init registers the tui command.
+func init() {
+	tuiCmd.Flags().String("socket", reliability.DefaultControlSocket, "Control socket of the running instance")
+	tuiCmd.Flags().String("address", "", "Loopback address (host:port) of the control API, used instead of the socket if set")
+	tuiCmd.Flags().String("watch-socket", reliability.DefaultWatchSocket, "Watch socket of the running instance")
+	tuiCmd.Flags().String("group", "", "Group of the running instance, whose sockets are derived from --socket and --watch-socket")
+	tuiCmd.Flags().Duration("timeout", 2*reliability.CommandTimeout, "Time to wait for the running instance to answer")
+	tuiCmd.Flags().Duration("refresh", time.Second, "Interval between two redraws of the dashboard")
+	tuiCmd.Flags().String("timezone", "Local", "Time zone used to display timestamps")
+	rootCmd.AddCommand(tuiCmd)
+}
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Show a live dashboard of the running instance",
+	Long: "Show a live dashboard of the running instance in the terminal: its state, the recent RTTs and losses " +
+		"of each link and the last decisions. Quit with Ctrl-C.",
+	Run: func(cmd *cobra.Command, args []string) {
+		watchSocket, _ := cmd.Flags().GetString("watch-socket")
+		if group, _ := cmd.Flags().GetString("group"); group != "" {
+			watchSocket = reliability.GroupFile(watchSocket, group)
+		}
+		refresh, _ := cmd.Flags().GetDuration("refresh")
+		timezone, _ := cmd.Flags().GetString("timezone")
+		if err := timefmt.SetLocation(timezone); err != nil {
+			log.Error().Msgf("Invalid time zone %s: %s", timezone, err)
+			os.Exit(1)
+		}
+		if refresh <= 0 {
+			log.Error().Msgf("Invalid --refresh %s: it must be positive", refresh)
+			os.Exit(1)
+		}
+		client := controlClient(cmd)
+		status, err := client.Status()
+		if err != nil {
+			log.Error().Msgf("Error contacting the running instance: %s", err)
+			os.Exit(1)
+		}
+		dashboard := tui.New(reliability.PrimaryLink, tuiResults, timefmt.Format)
+		dashboard.SetStatus(status, nil)
+		if samples, err := client.Samples(tuiResults); err == nil {
+			dashboard.AddSamples(samples)
+		}
+		go func() {
+			err := live.Watch(watchSocket, dashboard.Add)
+			message := "live stream ended"
+			if err != nil {
+				message += ": " + err.Error()
+			}
+			dashboard.Notice(message)
+		}()
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		os.Stdout.WriteString(tui.EnterScreen)
+		defer os.Stdout.WriteString(tui.LeaveScreen)
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for {
+			width, height := tui.Size(int(os.Stdout.Fd()))
+			os.Stdout.WriteString(dashboard.Render(width, height, time.Now()))
+			select {
+			case <-signals:
+				return
+			case <-ticker.C:
+				dashboard.SetStatus(client.Status())
+			}
+		}
+	},
+}
@@ -0,0 +1,11 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !unix
+
+package tui
+
+// Size returns 80x24, the size of the terminal not being known.
+func Size(fd int) (int, int) {
+	return 80, 24
+}
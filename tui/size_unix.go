@@ -0,0 +1,20 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build unix
+
+package tui
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Size returns the width and height of the terminal on fd, 80x24 if it is
+// not a terminal.
+func Size(fd int) (int, int) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package tui renders a live dashboard of the running instance in a
+// terminal: the state, each link with a sparkline of its recent RTTs and its
+// loss counters, and the last decisions, redrawn in place with ANSI escape
+// sequences.
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shynuu/if-reliability/control"
+	"github.com/shynuu/if-reliability/history"
+	"github.com/shynuu/if-reliability/live"
+)
+
+// Escape sequences.
+const (
+	// EnterScreen switches to the alternate screen and hides the cursor,
+	// and LeaveScreen restores both.
+	EnterScreen = "\x1b[?1049h\x1b[?25l"
+	LeaveScreen = "\x1b[?25h\x1b[?1049l"
+	// home moves the cursor to the top left corner, and clearLine and
+	// clearBelow clear the rest of the line and of the screen.
+	home       = "\x1b[H"
+	clearLine  = "\x1b[K"
+	clearBelow = "\x1b[J"
+	bold       = "\x1b[1m"
+	red        = "\x1b[31m"
+	green      = "\x1b[32m"
+	reset      = "\x1b[0m"
+)
+
+// sparks are the levels of a sparkline, and lost marks a failed probe.
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+const lost = '×'
+
+// maxEvents is the number of decisions kept.
+const maxEvents = 50
+
+// link is what the dashboard knows of a link.
+type link struct {
+	// results are the last probe results, oldest first, a failure having a
+	// zero RTT.
+	results  []time.Duration
+	sent     int
+	failures int
+	// endpoints is the last result toward each endpoint.
+	endpoints map[string]bool
+}
+
+// up reports whether the last probe of an endpoint of l succeeded.
+func (l *link) up() bool {
+	for _, ok := range l.endpoints {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Dashboard accumulates the live records and the status of the instance.
+type Dashboard struct {
+	mu sync.Mutex
+	// primary names the link of the default route, format renders the
+	// timestamps and width is the number of results kept per link.
+	primary   string
+	format    func(time.Time) string
+	width     int
+	links     map[string]*link
+	events    []live.Record
+	status    control.Status
+	statusErr error
+	updated   time.Time
+}
+
+// New returns a dashboard keeping width results per link, naming the
+// default route primary.
+func New(primary string, width int, format func(time.Time) string) *Dashboard {
+	return &Dashboard{primary: primary, format: format, width: width, links: map[string]*link{}}
+}
+
+// Add adds a live record.
+func (d *Dashboard) Add(r live.Record) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r.Kind == live.KindSample && r.Sample != nil {
+		d.sample(*r.Sample)
+		return
+	}
+	d.events = append(d.events, r)
+	if len(d.events) > maxEvents {
+		d.events = d.events[len(d.events)-maxEvents:]
+	}
+}
+
+// Notice adds a message of the dashboard itself to the events.
+func (d *Dashboard) Notice(message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, live.Record{Time: time.Now(), Message: message})
+}
+
+// AddSamples adds earlier probe results, e.g. fetched from the control API
+// before the live records.
+func (d *Dashboard) AddSamples(samples []history.Sample) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range samples {
+		d.sample(s)
+	}
+}
+
+// sample adds a probe result.
+func (d *Dashboard) sample(s history.Sample) {
+	if s.Grade != "" {
+		return
+	}
+	name := s.Interface
+	if name == "" {
+		name = d.primary
+	}
+	l, ok := d.links[name]
+	if !ok {
+		l = &link{endpoints: map[string]bool{}}
+		d.links[name] = l
+	}
+	rtt := s.RTT
+	if !s.Success {
+		rtt = 0
+		l.failures++
+	}
+	l.sent++
+	l.endpoints[s.Endpoint] = s.Success
+	l.results = append(l.results, rtt)
+	if len(l.results) > d.width {
+		l.results = l.results[len(l.results)-d.width:]
+	}
+}
+
+// SetStatus sets the status of the instance, or the error reading it.
+func (d *Dashboard) SetStatus(s control.Status, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.status, d.updated = s, time.Now()
+	}
+	d.statusErr = err
+}
+
+// Render renders the dashboard to fit width columns and height lines, as
+// of now.
+func (d *Dashboard) Render(width, height int, now time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var lines []string
+	lines = append(lines, bold+"if-reliability"+reset+"  "+d.format(now))
+	switch {
+	case d.statusErr != nil && d.updated.IsZero():
+		lines = append(lines, red+"Status unavailable: "+d.statusErr.Error()+reset)
+	case d.statusErr != nil:
+		lines = append(lines, red+fmt.Sprintf("Status unavailable since %s: %s", d.format(d.updated), d.statusErr)+reset)
+	}
+	if !d.updated.IsZero() {
+		state := fmt.Sprintf("State %s%s%s since %s (%s), traffic over %s%s%s",
+			bold, d.status.State, reset, d.format(d.status.Since), now.Sub(d.status.Since).Round(time.Second), bold, d.status.ActiveLink, reset)
+		if d.status.Paused {
+			state += ", automatic switching paused"
+		}
+		lines = append(lines, state)
+	}
+	lines = append(lines, "")
+	names := make([]string, 0, len(d.links))
+	for name := range d.links {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == d.primary) != (names[j] == d.primary) {
+			return names[i] == d.primary
+		}
+		return names[i] < names[j]
+	})
+	// The columns before the sparkline take 55 of them.
+	spark := max(width-55, 10)
+	lines = append(lines, bold+fmt.Sprintf("%-10s %-5s %9s %8s %8s %7s  %s", "LINK", "", "LAST RTT", "SENT", "LOST", "LOSS", "RECENT RTT")+reset)
+	for _, name := range names {
+		l := d.links[name]
+		status := red + "down " + reset
+		if l.up() {
+			status = green + "up   " + reset
+		}
+		last := "timeout"
+		if n := len(l.results); n > 0 && l.results[n-1] > 0 {
+			last = l.results[n-1].Round(100 * time.Microsecond).String()
+		}
+		loss := 100 * float64(l.failures) / float64(l.sent)
+		lines = append(lines, fmt.Sprintf("%-10s %s %9s %8d %8d %6.1f%%  %s", name, status, last, l.sent, l.failures, loss, sparkline(l.results, spark)))
+	}
+	if len(names) == 0 {
+		lines = append(lines, "No probe results yet")
+	}
+	lines = append(lines, "", bold+"RECENT EVENTS"+reset)
+	room := height - len(lines) - 2
+	events := d.events
+	if room < len(events) {
+		events = events[len(events)-max(room, 0):]
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		r := events[i]
+		name := r.Interface
+		switch {
+		case r.Kind == "":
+			lines = append(lines, fmt.Sprintf("%s  %s", d.format(r.Time), r.Message))
+			continue
+		case name == "":
+			name = d.primary
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s: %s", d.format(r.Time), name, r.Message))
+	}
+	if len(d.events) == 0 {
+		lines = append(lines, "None since the dashboard started")
+	}
+	// The last line stays empty, so that the screen does not scroll.
+	if len(lines) > height-1 {
+		lines = lines[:max(height-1, 1)]
+	}
+	var b strings.Builder
+	b.WriteString(home)
+	for _, line := range lines {
+		b.WriteString(truncate(line, width))
+		b.WriteString(clearLine + "\r\n")
+	}
+	b.WriteString(clearBelow)
+	return b.String()
+}
+
+// sparkline renders the last width results, scaled between the lowest and
+// the highest RTT, failures as lost.
+func sparkline(results []time.Duration, width int) string {
+	if len(results) > width {
+		results = results[len(results)-width:]
+	}
+	var low, high time.Duration
+	for _, rtt := range results {
+		if rtt == 0 {
+			continue
+		}
+		if low == 0 || rtt < low {
+			low = rtt
+		}
+		high = max(high, rtt)
+	}
+	var b strings.Builder
+	for _, rtt := range results {
+		switch {
+		case rtt == 0:
+			b.WriteString(red + string(lost) + reset)
+		case high == low:
+			b.WriteRune(sparks[0])
+		default:
+			b.WriteRune(sparks[int64(rtt-low)*int64(len(sparks)-1)/int64(high-low)])
+		}
+	}
+	return b.String()
+}
+
+// truncate cuts line to width visible columns, the escape sequences not
+// counting.
+func truncate(line string, width int) string {
+	var b strings.Builder
+	visible, escape := 0, false
+	for _, r := range line {
+		switch {
+		case r == '\x1b':
+			escape = true
+		case escape:
+			escape = r < '@' || r > '~' || r == '['
+		case visible >= width:
+			continue
+		default:
+			visible++
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + reset
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package tuning holds optional hints applied with the failover routes so
+// that applications notice a path change faster without code changes:
+// route attributes such as rto_min and quickack, and TCP keepalive sysctls.
+package tuning
+
+import (
+	"strconv"
+	"time"
+)
+
+// Hints are the tuning knobs. Zero values leave the system defaults.
+type Hints struct {
+	// RTOMin lowers the minimum TCP retransmission timeout on the route,
+	// so that stalled connections retransmit sooner.
+	RTOMin time.Duration
+	// QuickAck disables delayed ACKs on the route.
+	QuickAck bool
+	// InitCwnd sets the initial congestion window on the route.
+	InitCwnd int
+	// KeepaliveTime, KeepaliveInterval and KeepaliveProbes set the global
+	// TCP keepalive sysctls, for applications enabling keepalives. They
+	// apply to every connection of the host, not only the ones over the
+	// backup link.
+	KeepaliveTime     time.Duration
+	KeepaliveInterval time.Duration
+	KeepaliveProbes   int
+}
+
+// Sysctls returns the sysctl settings to apply, keyed by name.
+func (h Hints) Sysctls() map[string]string {
+	sysctls := map[string]string{}
+	if h.KeepaliveTime > 0 {
+		sysctls["net.ipv4.tcp_keepalive_time"] = strconv.Itoa(int(h.KeepaliveTime.Seconds()))
+	}
+	if h.KeepaliveInterval > 0 {
+		sysctls["net.ipv4.tcp_keepalive_intvl"] = strconv.Itoa(int(h.KeepaliveInterval.Seconds()))
+	}
+	if h.KeepaliveProbes > 0 {
+		sysctls["net.ipv4.tcp_keepalive_probes"] = strconv.Itoa(h.KeepaliveProbes)
+	}
+	return sysctls
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package usage accounts the traffic of the links over billing periods from
+// the byte counters of their interfaces, and judges it against a data cap.
+package usage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Period is the traffic of a link over a billing period.
+type Period struct {
+	// Start is the beginning of the billing period.
+	Start   time.Time `json:"start"`
+	RxBytes uint64    `json:"rx_bytes"`
+	TxBytes uint64    `json:"tx_bytes"`
+	// LastRx and LastTx are the counters of the interface when last read,
+	// the traffic being their increase since.
+	LastRx uint64 `json:"last_rx"`
+	LastTx uint64 `json:"last_tx"`
+}
+
+// PeriodStart returns the beginning of the billing period holding now: the
+// last midnight, in the location of now, starting a day resetDay of a month.
+func PeriodStart(now time.Time, resetDay int) time.Time {
+	start := time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, now.Location())
+	if start.After(now) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// Add records the counters rx and tx of the interface read at now. The
+// first counters only set the baseline, and the traffic starts over in a new
+// billing period. Counters lower than the last ones were reset, e.g. by a
+// reboot or the interface being created again, and count from 0.
+func (p *Period) Add(rx, tx uint64, now time.Time, resetDay int) {
+	start := PeriodStart(now, resetDay)
+	if p.Start.IsZero() {
+		p.Start, p.LastRx, p.LastTx = start, rx, tx
+		return
+	}
+	if !start.Equal(p.Start) {
+		p.Start, p.RxBytes, p.TxBytes = start, 0, 0
+	}
+	p.RxBytes += increase(p.LastRx, rx)
+	p.TxBytes += increase(p.LastTx, tx)
+	p.LastRx, p.LastTx = rx, tx
+}
+
+// increase returns the increase of a counter from last to current.
+func increase(last, current uint64) uint64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+// Total returns the bytes received and sent over the period.
+func (p Period) Total() uint64 {
+	return p.RxBytes + p.TxBytes
+}
+
+// Cap is a data cap over a billing period.
+type Cap struct {
+	Bytes uint64
+	// Threshold is the share of the cap, between 0 and 1, at which it is
+	// considered reached, to leave the link before being throttled.
+	Threshold float64
+}
+
+// Reached reports whether the traffic of p reached the threshold of c.
+func (c Cap) Reached(p Period) bool {
+	return c.Bytes > 0 && float64(p.Total()) >= float64(c.Bytes)*c.Threshold
+}
+
+// units are the size units, decimal and binary.
+var units = map[string]uint64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// ParseSize parses a size in bytes with an optional unit, e.g. "20GB",
+// "1.5 TB" or "512MiB".
+func ParseSize(s string) (uint64, error) {
+	text := strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(text, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(text)
+	}
+	multiplier, ok := units[strings.TrimSpace(text[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+	}
+	value, err := strconv.ParseFloat(text[:i], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(value * float64(multiplier)), nil
+}
+
+// FormatSize formats bytes with a decimal unit, e.g. "18.5 GB".
+func FormatSize(bytes uint64) string {
+	for _, unit := range []string{"TB", "GB", "MB", "KB"} {
+		if bytes >= units[unit] {
+			return fmt.Sprintf("%.1f %s", float64(bytes)/float64(units[unit]), unit)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
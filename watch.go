@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/live"
+	"github.com/shynuu/if-reliability/pkg/reliability"
+	"github.com/shynuu/if-reliability/timefmt"
+	"github.com/spf13/cobra"
+)
+
+// init registers the watch command.
+func init() {
+	watchCmd.Flags().String("socket", reliability.DefaultWatchSocket, "Watch socket of the running instance")
+	watchCmd.Flags().StringSlice("link", nil, "Only show records about these links, primary for the default route (default: all)")
+	watchCmd.Flags().Bool("json", false, "Print the raw JSON records")
+	watchCmd.Flags().String("timezone", "Local", "Time zone used to display timestamps")
+	rootCmd.AddCommand(watchCmd)
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Stream live probe results and decisions from the running instance",
+	Run: func(cmd *cobra.Command, args []string) {
+		socket, _ := cmd.Flags().GetString("socket")
+		links, _ := cmd.Flags().GetStringSlice("link")
+		raw, _ := cmd.Flags().GetBool("json")
+		timezone, _ := cmd.Flags().GetString("timezone")
+		if err := timefmt.SetLocation(timezone); err != nil {
+			log.Error().Msgf("Invalid time zone %s: %s", timezone, err)
+			os.Exit(1)
+		}
+		wanted := map[string]bool{}
+		for _, link := range links {
+			if link == reliability.PrimaryLink {
+				link = ""
+			}
+			wanted[link] = true
+		}
+		err := live.Watch(socket, func(r live.Record) {
+			if len(wanted) > 0 && !wanted[r.Interface] {
+				return
+			}
+			if raw {
+				data, _ := json.Marshal(r)
+				fmt.Println(string(data))
+				return
+			}
+			fmt.Println(formatRecord(r))
+		})
+		if err != nil {
+			log.Error().Msgf("Error watching the running instance: %s", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// formatRecord renders a record on one line.
+func formatRecord(r live.Record) string {
+	link := reliability.LinkName(r.Interface)
+	if r.Kind != live.KindSample || r.Sample == nil {
+		return fmt.Sprintf("%s  %-8s %s: %s", timefmt.Format(r.Time), r.Kind, link, r.Message)
+	}
+	s := r.Sample
+	result := "timeout"
+	if s.Success {
+		result = s.RTT.Round(100 * time.Microsecond).String()
+	}
+	line := fmt.Sprintf("%s  %-8s %s → %s %s", timefmt.Format(r.Time), r.Kind, link, s.Endpoint, result)
+	if s.PathChange {
+		line += fmt.Sprintf(" path change, now %d hops", s.Hops)
+	}
+	if s.OutageID != "" {
+		line += " outage " + s.OutageID
+	}
+	return line
+}
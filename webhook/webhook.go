@@ -0,0 +1,186 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package webhook POSTs the state transitions of the failover as JSON to an
+// HTTP endpoint. Events are queued and delivered in order by one goroutine,
+// failed deliveries are retried with an exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/shynuu/if-reliability/history"
+)
+
+// queueSize is the number of events waiting for delivery beyond which new
+// events are dropped.
+const queueSize = 64
+
+// maxBackoff caps the delay between two delivery attempts.
+const maxBackoff = time.Minute
+
+// Event is the payload of a webhook.
+type Event struct {
+	Site string    `json:"site"`
+	Time time.Time `json:"time"`
+	// From and To are the states of the transition. They are empty with
+	// --interfaces, which goes through no states: Path then tells whether
+	// the traffic failed over or back.
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason"`
+	// OldInterface and NewInterface are the links carrying the traffic
+	// before and after the transition.
+	OldInterface string `json:"old_interface"`
+	NewInterface string `json:"new_interface"`
+	// OutageID identifies the ongoing outage, shared with the logs, alerts
+	// and events about it, or is empty.
+	OutageID string `json:"outage_id,omitempty"`
+	// Probes are the statistics of the recent probes per endpoint.
+	Probes []history.EndpointStats `json:"probes"`
+}
+
+// Notifier delivers events to a URL.
+type Notifier struct {
+	url      string
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	queue    chan Event
+
+	// mu guards closed, set once the queue is closed.
+	mu     sync.Mutex
+	closed bool
+	// ctx is cancelled when Close gives up on the queued events, and done
+	// closed once deliverLoop returned, leaving undelivered the number of
+	// events it gave up on.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	undelivered int
+}
+
+// New returns a notifier POSTing to url, making up to attempts delivery
+// attempts per event, the first retry after backoff and each next one
+// after twice the previous delay.
+func New(url string, timeout time.Duration, attempts int, backoff time.Duration) (*Notifier, error) {
+	if attempts < 1 {
+		return nil, fmt.Errorf("invalid attempt count %d", attempts)
+	}
+	n := &Notifier{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		attempts: attempts,
+		backoff:  backoff,
+		queue:    make(chan Event, queueSize),
+		done:     make(chan struct{}),
+	}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	go n.deliverLoop()
+	return n, nil
+}
+
+// Notify queues e for delivery. It does not block: the event is dropped if
+// the queue is full or the notifier closed.
+func (n *Notifier) Notify(e Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		log.Error().Msgf("Webhook closed, event %s -> %s dropped", e.OldInterface, e.NewInterface)
+		return
+	}
+	select {
+	case n.queue <- e:
+	default:
+		log.Error().Msgf("Webhook queue full, event %s -> %s dropped", e.OldInterface, e.NewInterface)
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be
+// delivered, or for their last attempt to fail. Once ctx is done, it gives up
+// on the delivery under way and the events left, and returns an error
+// telling how many were not delivered.
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-n.done
+		return fmt.Errorf("%d events not delivered: %w", n.undelivered, ctx.Err())
+	}
+}
+
+// deliverLoop delivers the queued events in order, until the queue is closed
+// and empty.
+func (n *Notifier) deliverLoop() {
+	defer close(n.done)
+	defer n.cancel()
+	for e := range n.queue {
+		if n.ctx.Err() != nil {
+			n.undelivered++
+			continue
+		}
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Error().Msgf("Error encoding webhook event: %s", err)
+			continue
+		}
+		delay := n.backoff
+		for attempt := 1; ; attempt++ {
+			err = n.post(body)
+			if err == nil {
+				log.Debug().Msgf("Webhook event %s -> %s delivered", e.From, e.To)
+				break
+			}
+			if n.ctx.Err() != nil {
+				n.undelivered++
+				break
+			}
+			if attempt >= n.attempts {
+				log.Error().Msgf("Webhook event %s -> %s dropped after %d attempts: %s", e.From, e.To, attempt, err)
+				break
+			}
+			log.Warn().Msgf("Webhook delivery failed, attempt %d out of %d, retrying in %s: %s", attempt, n.attempts, delay, err)
+			select {
+			case <-time.After(delay):
+			case <-n.ctx.Done():
+			}
+			delay = min(2*delay, maxBackoff)
+		}
+	}
+}
+
+// post sends one delivery attempt.
+func (n *Notifier) post(body []byte) error {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
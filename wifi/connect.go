@@ -0,0 +1,237 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package wifi
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConnectOptions bound the connect phase of a WiFi link, and therefore the
+// duration of a failover.
+type ConnectOptions struct {
+	// AssociationTimeout bounds association and authentication.
+	AssociationTimeout time.Duration
+	// DHCPTimeout bounds the wait for a lease and a reachable default
+	// router once associated.
+	DHCPTimeout time.Duration
+	// MaxAttempts is the number of connection attempts.
+	MaxAttempts int
+	// RetrySpacing is the delay before the second attempt, doubled before
+	// each following one up to MaxSpacing.
+	RetrySpacing time.Duration
+	MaxSpacing   time.Duration
+	// Deadline bounds all the attempts to connect to a network, unbounded
+	// if 0.
+	Deadline time.Duration
+	// Fallbacks are the networks tried when the requested one cannot be
+	// connected to, ordered by Order.
+	Fallbacks []Network
+	// Enterprise is the 802.1X authentication of the requested network,
+	// WPA2-Personal or open if nil.
+	Enterprise *Enterprise
+}
+
+// Network is a WiFi network to connect to.
+type Network struct {
+	SSID string
+	// Password is empty for an open network or an existing connection
+	// profile.
+	Password string
+	// BSSID pins the access point, any of the SSID if empty.
+	BSSID string
+	// Priority orders the networks, the highest first.
+	Priority int
+	// Enterprise is the 802.1X authentication of the network, WPA2-Personal
+	// or open if nil.
+	Enterprise *Enterprise
+}
+
+// String returns the SSID, with the BSSID if pinned.
+func (n Network) String() string {
+	if n.BSSID != "" {
+		return fmt.Sprintf("%s (%s)", n.SSID, n.BSSID)
+	}
+	return n.SSID
+}
+
+// ParseNetwork parses a network given as ssid:password, ssid for an open
+// network, or as comma-separated key=value pairs among ssid, bssid, priority,
+// the Enterprise settings eap, identity, anonymous-identity, ca-cert,
+// client-cert, private-key and phase2, and password, e.g.
+// "ssid=Office,bssid=aa:bb:cc:dd:ee:ff,priority=10,password=secret". The
+// password comes last, so that it may contain commas.
+func ParseNetwork(text string) (Network, error) {
+	if !strings.HasPrefix(text, "ssid=") {
+		ssid, password, _ := strings.Cut(text, ":")
+		if ssid == "" {
+			return Network{}, fmt.Errorf("invalid network %q: empty SSID", text)
+		}
+		return Network{SSID: ssid, Password: password}, nil
+	}
+	var n Network
+	var e Enterprise
+	rest := text
+	for rest != "" {
+		var pair string
+		if strings.HasPrefix(rest, "password=") {
+			pair, rest = rest, ""
+		} else {
+			pair, rest, _ = strings.Cut(rest, ",")
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return Network{}, fmt.Errorf("invalid network %q: %q is not key=value", text, pair)
+		}
+		switch key {
+		case "ssid":
+			n.SSID = value
+		case "password":
+			n.Password = value
+		case "bssid":
+			mac, err := net.ParseMAC(value)
+			if err != nil || len(mac) != 6 {
+				return Network{}, fmt.Errorf("invalid network %q: invalid BSSID %q", text, value)
+			}
+			n.BSSID = strings.ToUpper(mac.String())
+		case "priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil {
+				return Network{}, fmt.Errorf("invalid network %q: invalid priority %q", text, value)
+			}
+			n.Priority = priority
+		case "eap":
+			e.EAP = value
+		case "identity":
+			e.Identity = value
+		case "anonymous-identity":
+			e.AnonymousIdentity = value
+		case "ca-cert":
+			e.CACert = value
+		case "client-cert":
+			e.ClientCert = value
+		case "private-key":
+			e.PrivateKey = value
+		case "phase2":
+			e.Phase2 = value
+		default:
+			return Network{}, fmt.Errorf("invalid network %q: unknown key %q (ssid, bssid, priority, eap, identity, anonymous-identity, ca-cert, client-cert, private-key, phase2 or password)", text, key)
+		}
+	}
+	if n.SSID == "" {
+		return Network{}, fmt.Errorf("invalid network %q: empty SSID", text)
+	}
+	if e != (Enterprise{}) {
+		if err := e.Validate(); err != nil {
+			return Network{}, fmt.Errorf("invalid network %q: %w", text, err)
+		}
+		n.Enterprise = &e
+	}
+	return n, nil
+}
+
+// Order returns the networks by decreasing priority, the ones visible first
+// among equals, then in the given order. visible reports whether a scan saw
+// the network; the ones it did not, e.g. hidden SSIDs, are still returned.
+func Order(networks []Network, visible func(Network) bool) []Network {
+	ordered := append([]Network{}, networks...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority > ordered[j].Priority
+		}
+		return visible(ordered[i]) && !visible(ordered[j])
+	})
+	return ordered
+}
+
+// Validate checks the options.
+func (o ConnectOptions) Validate() error {
+	if o.AssociationTimeout < time.Second {
+		return fmt.Errorf("association timeout must be at least 1s")
+	}
+	if o.DHCPTimeout < time.Second {
+		return fmt.Errorf("DHCP timeout must be at least 1s")
+	}
+	if o.MaxAttempts < 1 {
+		return fmt.Errorf("at least one connection attempt is required")
+	}
+	if o.Deadline < 0 {
+		return fmt.Errorf("negative connect deadline")
+	}
+	if o.Enterprise != nil {
+		if err := o.Enterprise.Validate(); err != nil {
+			return fmt.Errorf("enterprise authentication: %w", err)
+		}
+	}
+	return nil
+}
+
+// Spacing returns the delay after the failed attempt number attempt,
+// starting at 1.
+func (o ConnectOptions) Spacing(attempt int) time.Duration {
+	spacing := o.RetrySpacing
+	for i := 1; i < attempt && spacing < o.MaxSpacing; i++ {
+		spacing *= 2
+	}
+	if o.MaxSpacing > o.RetrySpacing {
+		spacing = min(spacing, o.MaxSpacing)
+	}
+	return spacing
+}
+
+// MaxDuration returns the longest time connecting to one network can take.
+func (o ConnectOptions) MaxDuration() time.Duration {
+	total := time.Duration(o.MaxAttempts) * (o.AssociationTimeout + o.DHCPTimeout)
+	for attempt := 1; attempt < o.MaxAttempts; attempt++ {
+		total += o.Spacing(attempt)
+	}
+	if o.Deadline > 0 {
+		total = min(total, o.Deadline)
+	}
+	return total
+}
+
+// Within returns the options with the timeouts of one attempt cut to
+// remaining, if positive.
+func (o ConnectOptions) Within(remaining time.Duration) ConnectOptions {
+	if remaining > 0 {
+		o.AssociationTimeout = min(o.AssociationTimeout, remaining)
+		o.DHCPTimeout = min(o.DHCPTimeout, remaining)
+	}
+	return o
+}
+
+// Phases of a connection attempt.
+const (
+	PhaseAssociation = "association"
+	PhaseDHCP        = "DHCP"
+)
+
+// ConnectError is the failure to connect to a network.
+type ConnectError struct {
+	SSID string
+	// Phase is the phase the last attempt failed in.
+	Phase    string
+	Attempts int
+	// Deadline is set if the deadline stopped the attempts.
+	Deadline time.Duration
+	Err      error
+}
+
+// Error describes the failure.
+func (e *ConnectError) Error() string {
+	if e.Deadline > 0 {
+		return fmt.Sprintf("could not connect to %s within %s (%d attempts), %s failed: %s", e.SSID, e.Deadline, e.Attempts, e.Phase, e.Err)
+	}
+	return fmt.Sprintf("could not connect to %s after %d attempts, %s failed: %s", e.SSID, e.Attempts, e.Phase, e.Err)
+}
+
+// Unwrap returns the failure of the last attempt.
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
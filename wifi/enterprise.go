@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package wifi
+
+import (
+	"fmt"
+	"os"
+)
+
+// EAP methods of WPA2-Enterprise networks.
+const (
+	EAPPEAP = "peap"
+	EAPTTLS = "ttls"
+	EAPTLS  = "tls"
+)
+
+// DefaultPhase2 is the inner authentication of PEAP and TTLS if none is
+// given.
+const DefaultPhase2 = "mschapv2"
+
+// phase2Methods are the inner authentications of PEAP and TTLS.
+var phase2Methods = map[string]bool{"mschapv2": true, "mschap": true, "pap": true, "chap": true, "gtc": true, "md5": true}
+
+// Enterprise is the 802.1X authentication of a WPA2-Enterprise network. The
+// password of the network is the user password with PEAP and TTLS, and the
+// password of the private key, if encrypted, with TLS.
+type Enterprise struct {
+	// EAP is the method: peap, ttls or tls.
+	EAP      string
+	Identity string
+	// AnonymousIdentity is sent in clear in place of Identity with PEAP and
+	// TTLS, if not empty.
+	AnonymousIdentity string
+	// CACert is the path of the certificate authority checking the
+	// server, which is not checked if empty.
+	CACert string
+	// ClientCert and PrivateKey are the paths of the client certificate
+	// and key for TLS.
+	ClientCert string
+	PrivateKey string
+	// Phase2 is the inner authentication of PEAP and TTLS, DefaultPhase2 if
+	// empty.
+	Phase2 string
+}
+
+// Inner returns the inner authentication, empty for TLS.
+func (e *Enterprise) Inner() string {
+	if e.EAP == EAPTLS {
+		return ""
+	}
+	if e.Phase2 == "" {
+		return DefaultPhase2
+	}
+	return e.Phase2
+}
+
+// Validate checks the method, the identity and that the files exist.
+func (e *Enterprise) Validate() error {
+	switch e.EAP {
+	case EAPPEAP, EAPTTLS:
+		if e.Phase2 != "" && !phase2Methods[e.Phase2] {
+			return fmt.Errorf("unknown phase 2 authentication %q (mschapv2, mschap, pap, chap, gtc or md5)", e.Phase2)
+		}
+		if e.ClientCert != "" || e.PrivateKey != "" {
+			return fmt.Errorf("a client certificate only applies to EAP-TLS")
+		}
+	case EAPTLS:
+		if e.ClientCert == "" || e.PrivateKey == "" {
+			return fmt.Errorf("EAP-TLS needs a client certificate and a private key")
+		}
+		if e.Phase2 != "" || e.AnonymousIdentity != "" {
+			return fmt.Errorf("a phase 2 authentication and an anonymous identity only apply to PEAP and TTLS")
+		}
+	default:
+		return fmt.Errorf("unknown EAP method %q (%s, %s or %s)", e.EAP, EAPPEAP, EAPTTLS, EAPTLS)
+	}
+	if e.Identity == "" {
+		return fmt.Errorf("%s needs an identity", e.EAP)
+	}
+	for _, path := range []string{e.CACert, e.ClientCert, e.PrivateKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package wifi
+
+// Health is the health score of a WiFi link, from 0 (unusable) to 100.
+type Health struct {
+	Score       int
+	Utilization float64
+	Noise       int
+}
+
+// noiseFloor is the noise level (dBm) below which noise is not penalized.
+const noiseFloor = -95
+
+// Evaluate scores a link from its channel survey. A saturated channel is
+// penalized even if the signal is clean: channel utilization costs up to 60
+// points and every dB of noise above the floor costs 2 points, up to 40.
+func Evaluate(s Survey) Health {
+	h := Health{Utilization: s.Utilization(), Noise: s.Noise, Score: 100}
+	h.Score -= int(h.Utilization * 60)
+	if s.Noise != 0 && s.Noise > noiseFloor {
+		penalty := (s.Noise - noiseFloor) * 2
+		if penalty > 40 {
+			penalty = 40
+		}
+		h.Score -= penalty
+	}
+	if h.Score < 0 {
+		h.Score = 0
+	}
+	return h
+}
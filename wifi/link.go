@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package wifi
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Link is the state of the association of a WiFi interface.
+type Link struct {
+	BSSID string
+	SSID  string
+	// Frequency in MHz.
+	Frequency int
+	// Signal is the strength of the received signal (RSSI) in dBm.
+	Signal int
+	// TxBitrate in MBit/s.
+	TxBitrate float64
+}
+
+// ParseLink extracts the association from the output of "iw dev <if> link".
+// It fails if the interface is not associated or no signal is reported.
+func ParseLink(output string) (Link, error) {
+	var link Link
+	signal := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Not connected") {
+			return Link{}, fmt.Errorf("not associated")
+		}
+		if rest, ok := strings.CutPrefix(line, "Connected to "); ok {
+			link.BSSID = strings.Fields(rest)[0]
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "SSID":
+			link.SSID = strings.TrimSpace(value)
+		case "freq":
+			frequency, _ := strconv.ParseFloat(fields[0], 64)
+			link.Frequency = int(frequency)
+		case "signal":
+			dbm, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return Link{}, fmt.Errorf("invalid signal %q", strings.TrimSpace(value))
+			}
+			link.Signal, signal = dbm, true
+		case "tx bitrate":
+			link.TxBitrate, _ = strconv.ParseFloat(fields[0], 64)
+		}
+	}
+	if !signal {
+		return Link{}, fmt.Errorf("no signal reported")
+	}
+	return link, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package wifi evaluates the health and the signal of a WiFi link.
+package wifi
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Survey is the nl80211 survey data of the channel in use.
+type Survey struct {
+	Frequency int
+	// Noise in dBm.
+	Noise    int
+	Active   time.Duration
+	Busy     time.Duration
+	Receive  time.Duration
+	Transmit time.Duration
+}
+
+// Utilization returns the fraction of time the channel was busy.
+func (s Survey) Utilization() float64 {
+	if s.Active <= 0 {
+		return 0
+	}
+	return float64(s.Busy) / float64(s.Active)
+}
+
+// ParseSurvey extracts the entry of the channel in use from the output of
+// "iw dev <if> survey dump".
+func ParseSurvey(output string) (Survey, error) {
+	var current Survey
+	inUse := false
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "frequency":
+			if inUse {
+				return current, nil
+			}
+			current = Survey{}
+			inUse = strings.Contains(value, "[in use]")
+			current.Frequency, _ = strconv.Atoi(strings.Fields(value)[0])
+			found = found || inUse
+		case "noise":
+			current.Noise, _ = strconv.Atoi(strings.Fields(value)[0])
+		case "channel active time":
+			current.Active = parseMillis(value)
+		case "channel busy time":
+			current.Busy = parseMillis(value)
+		case "channel receive time":
+			current.Receive = parseMillis(value)
+		case "channel transmit time":
+			current.Transmit = parseMillis(value)
+		}
+	}
+	if !found {
+		return Survey{}, fmt.Errorf("no survey data for the channel in use")
+	}
+	return current, nil
+}
+
+func parseMillis(value string) time.Duration {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	ms, _ := strconv.ParseInt(fields[0], 10, 64)
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+// Package wireguard reads the peers of WireGuard interfaces and sets their
+// endpoints over the wireguard generic netlink family, like wg(8). Calls
+// operate in the network namespace of the calling thread.
+package wireguard
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// Key is a Curve25519 public key.
+type Key [32]byte
+
+// ParseKey parses a key in base64, as printed by wg(8).
+func ParseKey(s string) (Key, error) {
+	var k Key
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != len(k) {
+		return k, fmt.Errorf("invalid WireGuard key %q", s)
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// String returns k in base64.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// MarshalText encodes k in base64.
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes k from base64.
+func (k *Key) UnmarshalText(text []byte) error {
+	key, err := ParseKey(string(text))
+	if err != nil {
+		return err
+	}
+	*k = key
+	return nil
+}
+
+// Peer is a peer of a WireGuard interface.
+type Peer struct {
+	PublicKey Key `json:"public_key"`
+	// Endpoint is the address and port of the peer, empty if unknown.
+	Endpoint string `json:"endpoint,omitempty"`
+	// LastHandshake is when the last handshake with the peer completed,
+	// zero if none did.
+	LastHandshake time.Time `json:"last_handshake"`
+}
+
+// Device is a WireGuard interface.
+type Device struct {
+	Name  string `json:"name"`
+	Peers []Peer `json:"peers"`
+}
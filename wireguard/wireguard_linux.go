@@ -0,0 +1,170 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+package wireguard
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Generic netlink commands and attributes of the wireguard family, from
+// <linux/wireguard.h>.
+const (
+	familyName    = "wireguard"
+	familyVersion = 1
+
+	cmdGetDevice = 0
+	cmdSetDevice = 1
+
+	deviceIfname = 2
+	devicePeers  = 8
+
+	peerPublicKey     = 1
+	peerFlags         = 3
+	peerEndpoint      = 4
+	peerLastHandshake = 6
+
+	peerUpdateOnly = 1 << 2
+)
+
+// Get returns the interface ifname and its peers.
+func Get(ifname string) (Device, error) {
+	family, err := netlink.GenlFamilyGet(familyName)
+	if err != nil {
+		return Device{}, fmt.Errorf("wireguard netlink family: %w", err)
+	}
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_DUMP)
+	req.AddData(&nl.Genlmsg{Command: cmdGetDevice, Version: familyVersion})
+	req.AddData(nl.NewRtAttr(deviceIfname, nl.ZeroTerminated(ifname)))
+	msgs, err := req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return Device{}, fmt.Errorf("interface %s: %w", ifname, err)
+	}
+	d := Device{Name: ifname}
+	for _, msg := range msgs {
+		if len(msg) < nl.SizeofGenlmsg {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+		if err != nil {
+			return d, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type&nl.NLA_TYPE_MASK != devicePeers {
+				continue
+			}
+			peers, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return d, err
+			}
+			for _, p := range peers {
+				peer, err := parsePeer(p.Value)
+				if err != nil {
+					return d, err
+				}
+				d.Peers = append(d.Peers, peer)
+			}
+		}
+	}
+	return d, nil
+}
+
+// parsePeer parses the nested attributes of a peer.
+func parsePeer(b []byte) (Peer, error) {
+	var p Peer
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return p, err
+	}
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nl.NLA_TYPE_MASK {
+		case peerPublicKey:
+			copy(p.PublicKey[:], attr.Value)
+		case peerEndpoint:
+			p.Endpoint = parseSockaddr(attr.Value)
+		case peerLastHandshake:
+			if len(attr.Value) >= 16 {
+				sec := int64(nl.NativeEndian().Uint64(attr.Value[:8]))
+				nsec := int64(nl.NativeEndian().Uint64(attr.Value[8:16]))
+				if sec != 0 || nsec != 0 {
+					p.LastHandshake = time.Unix(sec, nsec)
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// parseSockaddr returns the address and port of a sockaddr_in or
+// sockaddr_in6, or an empty string.
+func parseSockaddr(b []byte) string {
+	if len(b) < 4 {
+		return ""
+	}
+	port := strconv.Itoa(int(binary.BigEndian.Uint16(b[2:4])))
+	switch nl.NativeEndian().Uint16(b[:2]) {
+	case unix.AF_INET:
+		if len(b) >= 8 {
+			return net.JoinHostPort(net.IP(b[4:8]).String(), port)
+		}
+	case unix.AF_INET6:
+		if len(b) >= 24 {
+			return net.JoinHostPort(net.IP(b[8:24]).String(), port)
+		}
+	}
+	return ""
+}
+
+// SetEndpoint sets the endpoint of the peer with key on ifname. The socket
+// of the interface forgets the source address it used toward the peer, so
+// that the next packets leave through the current route toward it.
+func SetEndpoint(ifname string, key Key, endpoint *net.UDPAddr) error {
+	family, err := netlink.GenlFamilyGet(familyName)
+	if err != nil {
+		return fmt.Errorf("wireguard netlink family: %w", err)
+	}
+	req := nl.NewNetlinkRequest(int(family.ID), unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: cmdSetDevice, Version: familyVersion})
+	req.AddData(nl.NewRtAttr(deviceIfname, nl.ZeroTerminated(ifname)))
+	peers := nl.NewRtAttr(devicePeers|unix.NLA_F_NESTED, nil)
+	peer := peers.AddRtAttr(unix.NLA_F_NESTED, nil)
+	peer.AddRtAttr(peerPublicKey, key[:])
+	peer.AddRtAttr(peerFlags, nl.Uint32Attr(peerUpdateOnly))
+	peer.AddRtAttr(peerEndpoint, sockaddr(endpoint))
+	req.AddData(peers)
+	if _, err := req.Execute(unix.NETLINK_GENERIC, 0); err != nil {
+		return fmt.Errorf("interface %s: %w", ifname, err)
+	}
+	return nil
+}
+
+// sockaddr encodes addr as a sockaddr_in, or a sockaddr_in6 if it is not an
+// IPv4 address.
+func sockaddr(addr *net.UDPAddr) []byte {
+	if ip := addr.IP.To4(); ip != nil {
+		b := make([]byte, syscall.SizeofSockaddrInet4)
+		nl.NativeEndian().PutUint16(b[:2], unix.AF_INET)
+		binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+		copy(b[4:8], ip)
+		return b
+	}
+	b := make([]byte, syscall.SizeofSockaddrInet6)
+	nl.NativeEndian().PutUint16(b[:2], unix.AF_INET6)
+	binary.BigEndian.PutUint16(b[2:4], uint16(addr.Port))
+	copy(b[8:24], addr.IP.To16())
+	if addr.Zone != "" {
+		if iface, err := net.InterfaceByName(addr.Zone); err == nil {
+			nl.NativeEndian().PutUint32(b[24:28], uint32(iface.Index))
+		}
+	}
+	return b
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2024 Youssouf Drif
+// Licensed under the MIT License: https://opensource.org/licenses/MIT
+
+//go:build !linux
+
+package wireguard
+
+import (
+	"errors"
+	"net"
+)
+
+// errUnsupported is returned on systems without generic netlink.
+var errUnsupported = errors.New("WireGuard interfaces are only managed on Linux")
+
+// Get fails, generic netlink is Linux-only.
+func Get(ifname string) (Device, error) {
+	return Device{}, errUnsupported
+}
+
+// SetEndpoint fails, generic netlink is Linux-only.
+func SetEndpoint(ifname string, key Key, endpoint *net.UDPAddr) error {
+	return errUnsupported
+}